@@ -0,0 +1,3 @@
+Rails.application.routes.draw do
+  get '/status', to: 'status#show'
+end
@@ -0,0 +1,5 @@
+class StatusController < ApplicationController
+  def show
+    render json: { status: 'ok', ruby: RUBY_VERSION, api_only: Rails.application.config.api_only }
+  end
+end
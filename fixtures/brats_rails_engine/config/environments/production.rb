@@ -0,0 +1,7 @@
+Rails.application.configure do
+  config.cache_classes = true
+  config.eager_load = true
+  config.consider_all_requests_local = false
+  config.log_level = :info
+  config.logger = ActiveSupport::Logger.new(STDOUT)
+end
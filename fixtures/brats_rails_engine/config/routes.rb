@@ -0,0 +1,4 @@
+Rails.application.routes.draw do
+  get '/status', to: 'status#show'
+  mount Greeter::Engine, at: '/greeter'
+end
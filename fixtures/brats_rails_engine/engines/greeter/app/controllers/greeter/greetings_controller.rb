@@ -0,0 +1,7 @@
+module Greeter
+  class GreetingsController < ActionController::API
+    def show
+      render plain: "Hello from the greeter engine at #{File.basename(Greeter::Engine.root)}"
+    end
+  end
+end
@@ -0,0 +1,3 @@
+Greeter::Engine.routes.draw do
+  root to: 'greetings#show'
+end
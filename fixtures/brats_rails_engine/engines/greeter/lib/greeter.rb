@@ -0,0 +1,4 @@
+require 'greeter/engine'
+
+module Greeter
+end
@@ -0,0 +1,5 @@
+module Greeter
+  class Engine < ::Rails::Engine
+    isolate_namespace Greeter
+  end
+end
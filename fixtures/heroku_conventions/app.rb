@@ -0,0 +1,13 @@
+require 'sinatra'
+require 'logger'
+
+LOGGER = Logger.new(ENV['RAILS_LOG_TO_STDOUT'] ? STDOUT : '/dev/null')
+
+get '/' do
+  LOGGER.info('heroku conventions request')
+  'Hello world!'
+end
+
+get '/env' do
+  %w[RAILS_LOG_TO_STDOUT RAILS_SERVE_STATIC_FILES RACK_ENV].map { |key| "#{key}=#{ENV[key]}" }.join("\n")
+end
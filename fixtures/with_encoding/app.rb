@@ -0,0 +1,9 @@
+require 'sinatra'
+
+get '/' do
+  "File: #{File.read(__FILE__).encoding}\n" \
+  "Command output: #{`echo`.encoding}\n" \
+  "Default external: #{Encoding.default_external}\n" \
+  "LANG: #{ENV['LANG']}\n" \
+  "LC_ALL: #{ENV['LC_ALL']}\n"
+end
@@ -4,5 +4,14 @@ set -exuo pipefail
 cd "$( dirname "${BASH_SOURCE[0]}" )/.."
 source .envrc
 
+# The packager runs this in its copy of the buildpack, staging warns once the
+# build date is more than BP_MANIFEST_MAX_AGE days ago
+if ! grep -q '^build_date:' manifest.yml; then
+  echo "build_date: $(date -u +%Y-%m-%d)" >> manifest.yml
+fi
+
 GOOS=linux go build -ldflags="-s -w" -o bin/supply ruby/supply/cli
 GOOS=linux go build -ldflags="-s -w" -o bin/finalize ruby/finalize/cli
+GOOS=linux go build -ldflags="-s -w" -o bin/doctor ruby/doctor/cli
+GOOS=linux go build -ldflags="-s -w" -o bin/dropletdiff ruby/dropletdiff/cli
+GOOS=linux go build -ldflags="-s -w" -o bin/gembundle ruby/gembundle/cli
@@ -0,0 +1,114 @@
+package brats_test
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"ruby/lockfile"
+
+	"github.com/cloudfoundry/libbuildpack/bratshelper"
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Airgapped foundations have no egress at all, the cached buildpack must
+// stage apps with vendored gems from what it ships with. Staging runs with
+// every proxy variable pointing at egressProxy, which by default is a port
+// nothing in the staging container listens on, so any outbound connection
+// fails and shows up in the staging log. A proxy which refuses and logs
+// connections can be given with -egress-proxy to see them on the proxy too.
+var (
+	airgapped   = flag.Bool("airgapped", os.Getenv("BRATS_AIRGAPPED") == "true", "run the version matrix with the cached buildpack and egress blocked")
+	egressProxy = flag.String("egress-proxy", envOr("BRATS_EGRESS_PROXY", "http://127.0.0.1:9"), "proxy all outbound connections go through in airgapped mode")
+)
+
+// egressAttempts are printed when something in staging tried to reach the
+// internet through the proxy
+var egressAttempts = []string{
+	"Download [",
+	"proxyconnect",
+	"Connection refused",
+	"Could not fetch specs",
+	"Failed to open TCP connection",
+}
+
+var _ = Describe("Ruby buildpack in an airgapped environment", func() {
+	if !*airgapped {
+		return
+	}
+
+	bratshelper.ForAllSupportedVersions("ruby", CopyBrats, func(rubyVersion string, app *cutlass.App) {
+		vendorGems(app)
+		for _, name := range []string{"http_proxy", "https_proxy", "HTTP_PROXY", "HTTPS_PROXY"} {
+			app.SetEnv(name, *egressProxy)
+		}
+		app.SetEnv("no_proxy", "")
+		app.SetEnv("NO_PROXY", "")
+		PushApp(app)
+
+		By("never connects outside the foundation", func() {
+			for _, attempt := range egressAttempts {
+				Expect(app.Stdout.String()).ToNot(ContainSubstring(attempt))
+			}
+		})
+		By("installs the correct version of Ruby", func() {
+			Expect(app.Stdout.String()).To(ContainSubstring("Installing ruby " + rubyVersion))
+			Expect(GetBody(app, "/version")).To(ContainSubstring(rubyVersion))
+		})
+		By("runs a simple webserver", func() {
+			Expect(GetBody(app, "/")).To(ContainSubstring("Hello World!"))
+		})
+	})
+})
+
+// vendorGems downloads the locked gems of app into vendor/cache, as apps
+// are pushed to airgapped foundations after bundle package
+func vendorGems(app *cutlass.App) {
+	lock, err := lockfile.ParseFile(filepath.Join(app.Path, "Gemfile.lock"))
+	Expect(err).ToNot(HaveOccurred())
+
+	cacheDir := filepath.Join(app.Path, "vendor", "cache")
+	Expect(os.MkdirAll(cacheDir, 0755)).To(Succeed())
+	for _, spec := range lock.Specs {
+		if spec.Source != nil && spec.Source.Type != "GEM" {
+			continue
+		}
+		name := spec.Name + "-" + spec.Version + ".gem"
+		if spec.Platform != "" && spec.Platform != "ruby" {
+			name = spec.Name + "-" + spec.Version + "-" + spec.Platform + ".gem"
+		}
+		Expect(downloadGem("https://rubygems.org/downloads/"+name, filepath.Join(cacheDir, name))).To(Succeed())
+	}
+}
+
+func downloadGem(url, path string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -8,10 +8,12 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/blang/semver"
 	"github.com/cloudfoundry/libbuildpack"
 	"github.com/cloudfoundry/libbuildpack/bratshelper"
 	"github.com/cloudfoundry/libbuildpack/cutlass"
@@ -49,12 +51,19 @@ var _ = SynchronizedAfterSuite(func() {
 })
 
 func TestBrats(t *testing.T) {
-	RegisterFailHandler(Fail)
+	RegisterFailHandler(FailWithAppLogs)
 	RunSpecs(t, "Brats Suite")
 }
 
 func CopyBrats(rubyVersion string) *cutlass.App {
-	dir, err := cutlass.CopyFixture(filepath.Join(bratshelper.Data.BpDir, "fixtures", "brats_ruby"))
+	return copyRubyFixture("brats_ruby", rubyVersion)
+}
+
+// copyRubyFixture copies fixture and sets the ruby in its Gemfile to
+// rubyVersion, the default version when empty or the newest match of a
+// version with an x
+func copyRubyFixture(fixture, rubyVersion string) *cutlass.App {
+	dir, err := cutlass.CopyFixture(filepath.Join(bratshelper.Data.BpDir, "fixtures", fixture))
 	Expect(err).ToNot(HaveOccurred())
 	data, err := ioutil.ReadFile(filepath.Join(dir, "Gemfile"))
 	Expect(err).ToNot(HaveOccurred())
@@ -113,6 +122,7 @@ func CopyBratsJRuby(jrubyVersion string) *cutlass.App {
 }
 
 func PushApp(app *cutlass.App) {
+	checkedApps = append(checkedApps, app)
 	Expect(app.Push()).To(Succeed())
 	Eventually(app.InstanceStates, 20*time.Second).Should(Equal([]string{"RUNNING"}))
 }
@@ -121,4 +131,47 @@ func ApiHasStackAssociation() bool {
 	supported, err := cutlass.ApiGreaterThan("2.113.0")
 	Expect(err).NotTo(HaveOccurred())
 	return supported
-}
\ No newline at end of file
+}
+
+func ApiHasTask() bool {
+	supported, err := cutlass.ApiGreaterThan("2.75.0")
+	Expect(err).NotTo(HaveOccurred())
+	return supported
+}
+
+// OlderPatchConstraint finds the newest version line (eg. 2.4.x) of depName
+// with more than one patch release for CF_STACK, so staging its oldest release
+// is guaranteed to not be the latest patch.
+func OlderPatchConstraint(depName string) (string, bool) {
+	bpDir, err := cutlass.FindRoot()
+	if err != nil {
+		panic(err)
+	}
+	manifest, err := libbuildpack.NewManifest(bpDir, nil, time.Now())
+	if err != nil {
+		panic(err)
+	}
+
+	patches := map[string]int{}
+	for _, version := range manifest.AllDependencyVersions(depName) {
+		v, err := semver.ParseTolerant(version)
+		if err != nil {
+			continue
+		}
+		patches[fmt.Sprintf("%d.%d.x", v.Major, v.Minor)]++
+	}
+
+	var lines []string
+	for line, count := range patches {
+		if count > 1 {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return "", false
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return semver.MustParse(strings.Replace(lines[i], "x", "0", 1)).LT(semver.MustParse(strings.Replace(lines[j], "x", "0", 1)))
+	})
+	return lines[len(lines)-1], true
+}
@@ -1,8 +1,6 @@
 package brats_test
 
 import (
-	"os"
-
 	"github.com/cloudfoundry/libbuildpack/bratshelper"
 	"github.com/cloudfoundry/libbuildpack/cutlass"
 	. "github.com/onsi/ginkgo"
@@ -16,9 +14,8 @@ var _ = Describe("Ruby buildpack", func() {
 	bratshelper.StagingWithBuildpackThatSetsEOL("ruby", func(_ string) *cutlass.App {
 		return CopyBrats("2.2.x")
 	})
-	//TODO: Old versions of ruby were not available on cflinuxfs3. Bring this test back when possible.
-	if os.Getenv("CF_STACK") == "cflinuxfs2" {
-		bratshelper.StagingWithADepThatIsNotTheLatest("ruby", CopyBrats)
+	if constraint, found := OlderPatchConstraint("ruby"); found {
+		bratshelper.StagingWithADepThatIsNotTheLatestConstrained("ruby", constraint, CopyBrats)
 	}
 	bratshelper.StagingWithCustomBuildpackWithCredentialsInDependencies(`ruby\-[\d\.]+\-linux\-x64\-(cflinuxfs.*-)?[\da-f]+\.tgz`, CopyBrats)
 	bratshelper.DeployAppWithExecutableProfileScript("ruby", CopyBrats)
@@ -28,30 +25,30 @@ var _ = Describe("Ruby buildpack", func() {
 
 		By("installs the correct version of Ruby", func() {
 			Expect(app.Stdout.String()).To(ContainSubstring("Installing ruby " + rubyVersion))
-			Expect(app.GetBody("/version")).To(ContainSubstring(rubyVersion))
+			Expect(GetBody(app, "/version")).To(ContainSubstring(rubyVersion))
 		})
 		By("runs a simple webserver", func() {
-			Expect(app.GetBody("/")).To(ContainSubstring("Hello World!"))
+			Expect(GetBody(app, "/")).To(ContainSubstring("Hello World!"))
 		})
 		By("parses XML with nokogiri", func() {
-			Expect(app.GetBody("/nokogiri")).To(ContainSubstring("Hello, World"))
+			Expect(GetBody(app, "/nokogiri")).To(ContainSubstring("Hello, World"))
 		})
 		By("supports EventMachine", func() {
-			Expect(app.GetBody("/em")).To(ContainSubstring("Hello, EventMachine"))
+			Expect(GetBody(app, "/em")).To(ContainSubstring("Hello, EventMachine"))
 		})
 		By("encrypts with bcrypt", func() {
-			hashedPassword, err := app.GetBody("/bcrypt")
+			hashedPassword, err := GetBody(app, "/bcrypt")
 			Expect(err).ToNot(HaveOccurred())
 			Expect(bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte("Hello, bcrypt"))).ToNot(HaveOccurred())
 		})
 		By("supports bson", func() {
-			Expect(app.GetBody("/bson")).To(ContainSubstring("00040000"))
+			Expect(GetBody(app, "/bson")).To(ContainSubstring("00040000"))
 		})
 		By("supports postgres", func() {
-			Expect(app.GetBody("/pg")).To(ContainSubstring("could not connect to server: No such file or directory"))
+			Expect(GetBody(app, "/pg")).To(ContainSubstring("could not connect to server: No such file or directory"))
 		})
 		By("supports mysql2", func() {
-			Expect(app.GetBody("/mysql2")).To(ContainSubstring("Unknown MySQL server host 'testing'"))
+			Expect(GetBody(app, "/mysql2")).To(ContainSubstring("Unknown MySQL server host 'testing'"))
 		})
 	})
 
@@ -64,27 +61,27 @@ var _ = Describe("Ruby buildpack", func() {
 			Expect(app.Stdout.String()).To(ContainSubstring("Installing jruby " + jrubyVersion))
 		})
 		By("runs a simple webserver", func() {
-			Expect(app.GetBody("/")).To(ContainSubstring("Hello, World"))
+			Expect(GetBody(app, "/")).To(ContainSubstring("Hello, World"))
 		})
 		By("parses XML with nokogiri", func() {
-			Expect(app.GetBody("/nokogiri")).To(ContainSubstring("Hello, World"))
+			Expect(GetBody(app, "/nokogiri")).To(ContainSubstring("Hello, World"))
 		})
 		By("supports EventMachine", func() {
-			Expect(app.GetBody("/em")).To(ContainSubstring("Hello, EventMachine"))
+			Expect(GetBody(app, "/em")).To(ContainSubstring("Hello, EventMachine"))
 		})
 		By("encrypts with bcrypt", func() {
-			hashedPassword, err := app.GetBody("/bcrypt")
+			hashedPassword, err := GetBody(app, "/bcrypt")
 			Expect(err).ToNot(HaveOccurred())
 			Expect(bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte("Hello, bcrypt"))).ToNot(HaveOccurred())
 		})
 		By("supports bson", func() {
-			Expect(app.GetBody("/bson")).To(ContainSubstring("00040000"))
+			Expect(GetBody(app, "/bson")).To(ContainSubstring("00040000"))
 		})
 		By("supports postgres", func() {
-			Expect(app.GetBody("/pg")).To(ContainSubstring("The connection attempt failed."))
+			Expect(GetBody(app, "/pg")).To(ContainSubstring("The connection attempt failed."))
 		})
 		By("supports mysql", func() {
-			Expect(app.GetBody("/mysql")).To(ContainSubstring("Communications link failure"))
+			Expect(GetBody(app, "/mysql")).To(ContainSubstring("Communications link failure"))
 		})
 	})
 })
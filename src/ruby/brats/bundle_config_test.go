@@ -0,0 +1,72 @@
+package brats_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack/bratshelper"
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("deploying an app with gem source credentials", func() {
+	const (
+		configSecret = "config-user:SUPER-SECRET-CONFIG-PASSWORD"
+		envSecret    = "env-user:SUPER-SECRET-ENV-PASSWORD"
+	)
+	var app *cutlass.App
+
+	BeforeEach(func() {
+		app = CopyBrats("")
+		app.Buildpacks = []string{bratshelper.Data.Cached}
+		Expect(os.MkdirAll(filepath.Join(app.Path, ".bundle"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(app.Path, ".bundle", "config"), []byte("---\nBUNDLE_GEMS__EXAMPLE__COM: \""+configSecret+"\"\n"), 0644)).To(Succeed())
+		app.SetEnv("BUNDLE_GEMS__EXAMPLE__ORG", envSecret)
+		PushApp(app)
+	})
+
+	AfterEach(func() {
+		if app != nil {
+			app.Destroy()
+		}
+		app = nil
+	})
+
+	It("does not write the credentials to the staging logs", func() {
+		for _, secret := range []string{configSecret, envSecret} {
+			Expect(app.Stdout.String()).ToNot(ContainSubstring(secret))
+		}
+	})
+
+	It("does not write the credentials to the droplet", func() {
+		Expect(app.DownloadDroplet(filepath.Join(app.Path, "droplet.tgz"))).To(Succeed())
+		file, err := os.Open(filepath.Join(app.Path, "droplet.tgz"))
+		Expect(err).ToNot(HaveOccurred())
+		defer file.Close()
+		gz, err := gzip.NewReader(file)
+		Expect(err).ToNot(HaveOccurred())
+		defer gz.Close()
+		tr := tar.NewReader(gz)
+
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).ToNot(HaveOccurred())
+			b, err := ioutil.ReadAll(tr)
+			Expect(err).ToNot(HaveOccurred())
+			for _, secret := range []string{configSecret, envSecret} {
+				if strings.Contains(string(b), secret) {
+					Fail("Found credentials in " + hdr.Name)
+				}
+			}
+		}
+	})
+})
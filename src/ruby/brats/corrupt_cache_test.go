@@ -0,0 +1,50 @@
+package brats_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack/bratshelper"
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// corruptCache is evaluated by bundler while the app stages, after the
+// buildpack cached the ruby it downloaded, and truncates that download as a
+// failed write to the app cache would
+const corruptCache = `
+Dir.glob('/tmp/cache/**/dependencies/*/ruby-*').each { |file| File.truncate(file, 0) }
+`
+
+var _ = Describe("deploying an app whose cached dependencies are corrupt", func() {
+	var app *cutlass.App
+
+	BeforeEach(func() {
+		app = CopyBrats("")
+		app.Buildpacks = []string{bratshelper.Data.Uncached}
+		gemfile, err := os.OpenFile(filepath.Join(app.Path, "Gemfile"), os.O_APPEND|os.O_WRONLY, 0644)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = gemfile.WriteString(corruptCache)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(gemfile.Close()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		if app != nil {
+			app.Destroy()
+		}
+		app = nil
+	})
+
+	It("downloads them again and stages", func() {
+		PushApp(app)
+		Expect(app.Stdout.String()).To(MatchRegexp(`Download \[.*/ruby-.*\]`))
+
+		app.Stdout.Reset()
+		PushApp(app)
+		Expect(app.Stdout.String()).To(MatchRegexp(`The cached download of ruby \S+ is empty, downloading it again`))
+		Expect(app.Stdout.String()).To(MatchRegexp(`Download \[.*/ruby-.*\]`))
+		Expect(GetBody(app, "/")).To(ContainSubstring("Hello World!"))
+	})
+})
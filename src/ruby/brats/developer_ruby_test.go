@@ -0,0 +1,69 @@
+package brats_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A build of ruby which is not in the manifest yet, such as a nightly of
+// ruby-head, is run against the brats fixture with -ruby-url and
+// -ruby-sha256, which stage it with BP_RUBY_URL and BP_RUBY_SHA256
+var (
+	developerRubyURL    = flag.String("ruby-url", envOr("BRATS_RUBY_URL", ""), "URL of a ruby archive to stage the brats fixture with instead of a ruby of the manifest")
+	developerRubySHA256 = flag.String("ruby-sha256", envOr("BRATS_RUBY_SHA256", ""), "sha256 of the archive at -ruby-url")
+)
+
+var gemfileRuby = regexp.MustCompile(`(?m)^ruby .*\n`)
+
+var _ = Describe("Ruby buildpack with a developer ruby", func() {
+	if *developerRubyURL == "" {
+		return
+	}
+
+	var app *cutlass.App
+
+	BeforeEach(func() {
+		app = CopyBrats("")
+		// Bundler refuses a Gemfile declaring another ruby than the one running
+		gemfile := filepath.Join(app.Path, "Gemfile")
+		data, err := ioutil.ReadFile(gemfile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ioutil.WriteFile(gemfile, gemfileRuby.ReplaceAll(data, nil), 0644)).To(Succeed())
+
+		app.SetEnv("BP_RUBY_URL", *developerRubyURL)
+		app.SetEnv("BP_RUBY_SHA256", *developerRubySHA256)
+	})
+
+	AfterEach(func() {
+		if app != nil {
+			app.Destroy()
+		}
+		app = nil
+	})
+
+	It("stages and runs the fixture with the ruby from the URL", func() {
+		PushApp(app)
+
+		By("marks the ruby unsupported", func() {
+			Expect(app.Stdout.String()).To(ContainSubstring("UNSUPPORTED: installing ruby from BP_RUBY_URL"))
+		})
+		By("runs a simple webserver", func() {
+			Expect(GetBody(app, "/")).To(ContainSubstring("Hello World!"))
+		})
+		By("parses XML with nokogiri", func() {
+			Expect(GetBody(app, "/nokogiri")).To(ContainSubstring("Hello, World"))
+		})
+		By("supports EventMachine", func() {
+			Expect(GetBody(app, "/em")).To(ContainSubstring("Hello, EventMachine"))
+		})
+		By("supports bson", func() {
+			Expect(GetBody(app, "/bson")).To(ContainSubstring("00040000"))
+		})
+	})
+})
@@ -0,0 +1,116 @@
+package brats_test
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+
+	. "github.com/onsi/ginkgo"
+)
+
+// These are package level so they are defined before init in
+// brats_suite_test.go parses the flags
+var (
+	httpConnectTimeout = flag.Duration("http-connect-timeout", 10*time.Second, "timeout for connecting to pushed apps")
+	httpTimeout        = flag.Duration("http-timeout", 60*time.Second, "timeout for a whole request to a pushed app")
+	artifactsDir       = flag.String("artifacts-dir", os.Getenv("BRATS_ARTIFACTS_DIR"), "directory app logs are written to when a spec fails")
+)
+
+const recentLogLines = 200
+
+// checkedApps are the apps requested during the current spec, their logs
+// are dumped if the spec fails
+var checkedApps []*cutlass.App
+
+var _ = BeforeEach(func() {
+	checkedApps = nil
+})
+
+// FailWithAppLogs is the suite fail handler, apps are usually destroyed by
+// the time an AfterEach runs so the logs are dumped as the assertion fails
+func FailWithAppLogs(message string, callerSkip ...int) {
+	for _, app := range checkedApps {
+		dumpAppLogs(app)
+	}
+	skip := 1
+	if len(callerSkip) > 0 {
+		skip += callerSkip[0]
+	}
+	Fail(message, skip)
+}
+
+// NewHTTPClient returns a client with the connection and request timeouts
+// of the suite, its connections are kept alive between requests
+func NewHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: *httpTimeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: *httpConnectTimeout}).DialContext,
+			TLSHandshakeTimeout:   *httpConnectTimeout,
+			ResponseHeaderTimeout: *httpTimeout,
+		},
+	}
+}
+
+// GetBody requests path from app with connection and request timeouts, and
+// logs how long the request took
+func GetBody(app *cutlass.App, path string) (string, error) {
+	_, body, err := GetResponse(NewHTTPClient(), app, path)
+	return string(body), err
+}
+
+// GetResponse requests path from app with client and returns the response
+// with its body read, for assertions on the headers and transfer encoding
+func GetResponse(client *http.Client, app *cutlass.App, path string) (*http.Response, []byte, error) {
+	checkedApps = append(checkedApps, app)
+
+	url, err := app.GetUrl(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	start := time.Now()
+	resp, err := client.Get(url)
+	if err != nil {
+		fmt.Fprintf(GinkgoWriter, "GET %s failed after %v: %v\n", url, time.Since(start), err)
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	fmt.Fprintf(GinkgoWriter, "GET %s: %d, %d bytes in %v\n", url, resp.StatusCode, len(body), time.Since(start))
+	return resp, body, err
+}
+
+func dumpAppLogs(app *cutlass.App) {
+	if app.Stdout == nil {
+		return
+	}
+	lines := strings.Split(app.Stdout.String(), "\n")
+	if len(lines) > recentLogLines {
+		lines = lines[len(lines)-recentLogLines:]
+	}
+	logs := strings.Join(lines, "\n")
+
+	fmt.Fprintf(GinkgoWriter, "\n---- Recent logs for %s ----\n%s\n---- End of logs for %s ----\n", app.Name, logs, app.Name)
+	if *artifactsDir == "" {
+		return
+	}
+	if err := os.MkdirAll(*artifactsDir, 0755); err != nil {
+		fmt.Fprintf(GinkgoWriter, "Could not create %s: %v\n", *artifactsDir, err)
+		return
+	}
+	file := filepath.Join(*artifactsDir, app.Name+".log")
+	if err := ioutil.WriteFile(file, []byte(app.Stdout.String()), 0644); err != nil {
+		fmt.Fprintf(GinkgoWriter, "Could not write %s: %v\n", file, err)
+	}
+}
@@ -0,0 +1,89 @@
+package brats_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack/bratshelper"
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func CopyBratsRailsAPI(rubyVersion string) *cutlass.App {
+	return copyRubyFixture("brats_rails_api", rubyVersion)
+}
+
+// withExecJS adds execjs to the Gemfile of app, which makes the buildpack
+// install node and yarn for it as for a Rails app with an asset pipeline
+func withExecJS(app *cutlass.App) *cutlass.App {
+	for file, replacements := range map[string][]string{
+		"Gemfile":      {"gem 'puma'", "gem 'execjs'\ngem 'puma'"},
+		"Gemfile.lock": {"    erubi (1.7.0)\n", "    erubi (1.7.0)\n    execjs (2.7.0)\n", "  puma (~> 3.11)\n", "  execjs\n  puma (~> 3.11)\n"},
+	} {
+		path := filepath.Join(app.Path, file)
+		data, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ioutil.WriteFile(path, []byte(strings.NewReplacer(replacements...).Replace(string(data))), 0644)).To(Succeed())
+	}
+	return app
+}
+
+func dropletSize(app *cutlass.App) int64 {
+	dir, err := ioutil.TempDir("", "brats.droplet.")
+	Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "droplet.tgz")
+	Expect(app.DownloadDroplet(path)).To(Succeed())
+	info, err := os.Stat(path)
+	Expect(err).ToNot(HaveOccurred())
+	return info.Size()
+}
+
+var _ = Describe("deploying an API-only Rails app", func() {
+	bratshelper.ForAllSupportedVersions("ruby", CopyBratsRailsAPI, func(rubyVersion string, app *cutlass.App) {
+		PushApp(app)
+
+		By("skips node and yarn", func() {
+			Expect(app.Stdout.String()).ToNot(ContainSubstring("Installing node"))
+			Expect(app.Stdout.String()).ToNot(ContainSubstring("Installing yarn"))
+		})
+		By("serves JSON", func() {
+			body, err := GetBody(app, "/status")
+			Expect(err).ToNot(HaveOccurred())
+			status := map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(body), &status)).To(Succeed())
+			Expect(status).To(Equal(map[string]interface{}{"status": "ok", "ruby": rubyVersion, "api_only": true}))
+		})
+	})
+
+	Context("compared to the same app with a javascript runtime", func() {
+		var api, assets *cutlass.App
+
+		AfterEach(func() {
+			for _, app := range []*cutlass.App{api, assets} {
+				if app != nil {
+					app.Destroy()
+				}
+			}
+			api, assets = nil, nil
+		})
+
+		It("builds a smaller droplet", func() {
+			api = CopyBratsRailsAPI("")
+			api.Buildpacks = []string{bratshelper.Data.Cached}
+			PushApp(api)
+
+			assets = withExecJS(CopyBratsRailsAPI(""))
+			assets.Buildpacks = []string{bratshelper.Data.Cached}
+			PushApp(assets)
+			Expect(assets.Stdout.String()).To(ContainSubstring("Installing node"))
+
+			Expect(dropletSize(api)).To(BeNumerically("<", dropletSize(assets)))
+		})
+	})
+})
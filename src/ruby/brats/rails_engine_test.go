@@ -0,0 +1,80 @@
+package brats_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack/bratshelper"
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// CopyBratsRailsEngine copies an app mounting the greeter Rails engine from
+// git. The engine is committed to a bare repository in vendor/git when the
+// app is copied, which the Gemfile pins by SHA at the path the app stages
+// in, so the test needs no git host. It returns the locked revision.
+func CopyBratsRailsEngine(rubyVersion string) (*cutlass.App, string) {
+	app := copyRubyFixture("brats_rails_engine", rubyVersion)
+
+	engine := filepath.Join(app.Path, "engines", "greeter")
+	git(engine, "init", "--quiet")
+	git(engine, "add", ".")
+	git(engine, "commit", "--quiet", "-m", "greeter 0.1.0")
+	revision := git(engine, "rev-parse", "HEAD")
+	git(app.Path, "clone", "--bare", "--quiet", engine, filepath.Join("vendor", "git", "greeter.git"))
+	Expect(os.RemoveAll(filepath.Join(app.Path, "engines"))).To(Succeed())
+
+	replacer := strings.NewReplacer("<%= greeter_revision %>", revision, "<%= greeter_ref %>", revision[:12])
+	for _, file := range []string{"Gemfile", "Gemfile.lock"} {
+		path := filepath.Join(app.Path, file)
+		data, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ioutil.WriteFile(path, []byte(replacer.Replace(string(data))), 0644)).To(Succeed())
+	}
+	return app, revision
+}
+
+func git(dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=brats", "GIT_AUTHOR_EMAIL=brats@example.com", "GIT_COMMITTER_NAME=brats", "GIT_COMMITTER_EMAIL=brats@example.com")
+	output, err := cmd.CombinedOutput()
+	Expect(err).ToNot(HaveOccurred(), string(output))
+	return strings.TrimSpace(string(output))
+}
+
+var _ = Describe("deploying a Rails app with an engine from git", func() {
+	var (
+		app      *cutlass.App
+		revision string
+	)
+
+	BeforeEach(func() {
+		app, revision = CopyBratsRailsEngine("")
+		app.Buildpacks = []string{bratshelper.Data.Cached}
+	})
+
+	AfterEach(func() {
+		if app != nil {
+			app.Destroy()
+		}
+		app = nil
+	})
+
+	It("serves the engine from its locked revision and caches the clone", func() {
+		PushApp(app)
+		Expect(app.Stdout.String()).To(ContainSubstring("Fetching /tmp/app/vendor/git/greeter.git at " + revision[:12]))
+		Expect(app.Stdout.String()).To(ContainSubstring("Verified the git gems against their locked revisions"))
+		Expect(GetBody(app, "/greeter")).To(Equal("Hello from the greeter engine at greeter-" + revision[:12]))
+
+		app.Stdout.Reset()
+		PushApp(app)
+		Expect(app.Stdout.String()).ToNot(ContainSubstring("Fetching /tmp/app/vendor/git/greeter.git"))
+		Expect(app.Stdout.String()).To(ContainSubstring("Verified the git gems against their locked revisions"))
+		Expect(GetBody(app, "/greeter")).To(Equal("Hello from the greeter engine at greeter-" + revision[:12]))
+	})
+})
@@ -0,0 +1,72 @@
+package brats_test
+
+import (
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/bratshelper"
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var taskName = regexp.MustCompile(`(?m)^task name:\s+(\S+)`)
+
+// A task runs the droplet in a container of its own with only the
+// profile.d scripts to set up ruby and bundler, which the web process gets
+// the same way but through a different launcher command
+var _ = Describe("running a task against the droplet", func() {
+	var app *cutlass.App
+
+	BeforeEach(func() {
+		if !ApiHasTask() {
+			Skip("Running against CF without run task support")
+		}
+		app = copyRubyFixture("brats_task", "")
+		PushApp(app)
+	})
+
+	AfterEach(func() {
+		if app != nil {
+			app.Destroy()
+		}
+		app = nil
+	})
+
+	// taskState is the state cf tasks lists for the task named name
+	taskState := func(name string) func() (string, error) {
+		return func() (string, error) {
+			output, err := exec.Command("cf", "tasks", app.Name).Output()
+			if err != nil {
+				return "", err
+			}
+			for _, line := range strings.Split(string(output), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) > 2 && fields[1] == name {
+					return fields[2], nil
+				}
+			}
+			return "", nil
+		}
+	}
+
+	It("runs rake with the bundled ruby and gems", func() {
+		output, err := app.RunTask(`bundle exec rake -T && bundle exec rake task_env`)
+		Expect(err).ToNot(HaveOccurred())
+		m := taskName.FindSubmatch(output)
+		Expect(m).ToNot(BeNil(), string(output))
+
+		Eventually(taskState(string(m[1])), 3*time.Minute, 5*time.Second).Should(Equal("SUCCEEDED"))
+
+		manifest, err := libbuildpack.NewManifest(bratshelper.Data.BpDir, nil, time.Now())
+		Expect(err).ToNot(HaveOccurred())
+		ruby, err := manifest.DefaultVersion("ruby")
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(app.Stdout.String, 30*time.Second).Should(ContainSubstring("rake task_env"))
+		Eventually(app.Stdout.String, 30*time.Second).Should(MatchRegexp(`TASK ENV: ruby %s bundler \S+ rack 2\.0\.3`, regexp.QuoteMeta(ruby.Version)))
+		Expect(app.Stdout.String()).ToNot(ContainSubstring("command not found"))
+	})
+})
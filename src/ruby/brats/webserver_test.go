@@ -0,0 +1,163 @@
+package brats_test
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack/bratshelper"
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// websocketGUID is appended to the key of a websocket handshake, RFC 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func CopyBratsHTTP(rubyVersion string) *cutlass.App {
+	return copyRubyFixture("brats_http", rubyVersion)
+}
+
+// The brats_http fixture has no start command, so it runs with the web
+// process type the buildpack generates for a rack app, served by puma with
+// its default configuration.
+var _ = Describe("the default web server of a rack app", func() {
+	var app *cutlass.App
+
+	BeforeEach(func() {
+		app = CopyBratsHTTP("")
+		app.Buildpacks = []string{bratshelper.Data.Cached}
+		PushApp(app)
+	})
+
+	AfterEach(func() {
+		if app != nil {
+			app.Destroy()
+		}
+		app = nil
+	})
+
+	It("serves large responses whole", func() {
+		resp, body, err := GetResponse(NewHTTPClient(), app, "/large?mb=32")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(body).To(HaveLen(32 * 1024 * 1024))
+		sum := sha256.Sum256(body)
+		Expect(hex.EncodeToString(sum[:])).To(Equal(resp.Header.Get("X-Content-SHA256")))
+	})
+
+	It("streams responses without a length with chunked transfer encoding", func() {
+		resp, body, err := GetResponse(NewHTTPClient(), app, "/chunked")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.TransferEncoding).To(Equal([]string{"chunked"}))
+		Expect(string(body)).To(HavePrefix("chunk 0\n"))
+		Expect(string(body)).To(HaveSuffix("chunk 9\n"))
+	})
+
+	It("keeps connections alive between requests", func() {
+		client := NewHTTPClient()
+		reused := 0
+		for i := 0; i < 5; i++ {
+			target, err := app.GetUrl("/")
+			Expect(err).ToNot(HaveOccurred())
+			req, err := http.NewRequest("GET", target, nil)
+			Expect(err).ToNot(HaveOccurred())
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) {
+					if info.Reused {
+						reused++
+					}
+				},
+			}))
+
+			resp, err := client.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = io.Copy(ioutil.Discard, resp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		}
+		Expect(reused).To(Equal(4))
+	})
+
+	It("upgrades websocket connections", func() {
+		target, err := app.GetUrl("/websocket")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(websocketEcho(target, "Hello, websocket")).To(Equal("Hello, websocket"))
+	})
+})
+
+// websocketEcho opens a websocket to target over plain http, sends message
+// as a text frame and returns the text of the frame the app answers with
+func websocketEcho(target, message string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+	conn, err := net.DialTimeout("tcp", host, *httpConnectTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(*httpTimeout))
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", u.RequestURI(), u.Host, key)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return "", fmt.Errorf("the handshake was answered with %s", resp.Status)
+	}
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(accept[:]) {
+		return "", fmt.Errorf("the handshake was answered with the wrong Sec-WebSocket-Accept")
+	}
+
+	// Frames from the client are masked, messages below 126 bytes have
+	// their length in the second byte
+	mask := make([]byte, 4)
+	if _, err := rand.Read(mask); err != nil {
+		return "", err
+	}
+	frame := append([]byte{0x81, 0x80 | byte(len(message))}, mask...)
+	for i := range message {
+		frame = append(frame, message[i]^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		return "", err
+	}
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", err
+	}
+	payload := make([]byte, header[1]&0x7f)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return "", err
+	}
+	return string(payload), nil
+}
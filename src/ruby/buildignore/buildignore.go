@@ -0,0 +1,103 @@
+// Package buildignore reads .buildignore, a list of app paths which do not
+// influence staging, such as tmp/, log/ or spec fixtures. The buildpack
+// leaves them out when it digests the app so they do not cause churn.
+package buildignore
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const File = ".buildignore"
+
+// Matcher matches app paths against .buildignore patterns. Patterns follow
+// .gitignore: a pattern containing a slash is matched against the path from
+// the app root, any other pattern against each path component, and a
+// trailing slash only matches directories. Negation and ** are not
+// supported.
+type Matcher struct {
+	patterns []pattern
+}
+
+type pattern struct {
+	glob     string
+	anchored bool
+	dirOnly  bool
+}
+
+// Load reads .buildignore from buildDir, an app without one ignores nothing
+func Load(buildDir string) (*Matcher, error) {
+	file, err := os.Open(filepath.Join(buildDir, File))
+	if os.IsNotExist(err) {
+		return &Matcher{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+func Parse(r io.Reader) (*Matcher, error) {
+	m := &Matcher{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p := pattern{}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			p.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if _, err := path.Match(line, ""); err != nil {
+			return nil, err
+		}
+		p.glob = line
+		m.patterns = append(m.patterns, p)
+	}
+	return m, scanner.Err()
+}
+
+// Patterns returns how many patterns were read
+func (m *Matcher) Patterns() int {
+	return len(m.patterns)
+}
+
+// Match reports whether rel, a slash separated path from the app root, or
+// one of the directories it is in is ignored
+func (m *Matcher) Match(rel string, isDir bool) bool {
+	components := strings.Split(path.Clean(rel), "/")
+	for i := range components {
+		prefix := strings.Join(components[:i+1], "/")
+		dir := isDir || i < len(components)-1
+		for _, p := range m.patterns {
+			if p.match(prefix, dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p pattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	name := rel
+	if !p.anchored {
+		name = path.Base(rel)
+	}
+	// Parse rejected patterns which are not valid globs
+	matched, _ := path.Match(p.glob, name)
+	return matched
+}
@@ -0,0 +1,13 @@
+package buildignore_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestBuildignore(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Buildignore Suite")
+}
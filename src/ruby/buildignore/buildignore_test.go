@@ -0,0 +1,80 @@
+package buildignore_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/buildignore"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Buildignore", func() {
+	var matcher *buildignore.Matcher
+
+	BeforeEach(func() {
+		var err error
+		matcher, err = buildignore.Parse(strings.NewReader(`# not needed to stage
+tmp/
+/log
+spec/fixtures/
+*.swp
+`))
+		Expect(err).To(BeNil())
+	})
+
+	It("ignores matching directories and everything in them", func() {
+		Expect(matcher.Patterns()).To(Equal(4))
+		Expect(matcher.Match("tmp", true)).To(BeTrue())
+		Expect(matcher.Match("tmp/cache/assets/app.js", false)).To(BeTrue())
+		Expect(matcher.Match("vendor/engine/tmp/pids/server.pid", false)).To(BeTrue())
+		Expect(matcher.Match("spec/fixtures/users.yml", false)).To(BeTrue())
+	})
+
+	It("anchors patterns containing a slash to the app root", func() {
+		Expect(matcher.Match("log/production.log", false)).To(BeTrue())
+		Expect(matcher.Match("app/models/log", false)).To(BeFalse())
+		Expect(matcher.Match("engines/spec/fixtures/users.yml", false)).To(BeFalse())
+	})
+
+	It("only matches directories with a trailing slash", func() {
+		Expect(matcher.Match("tmp", false)).To(BeFalse())
+	})
+
+	It("matches globs against each path component", func() {
+		Expect(matcher.Match("app/views/.index.html.erb.swp", false)).To(BeTrue())
+		Expect(matcher.Match("app/views/index.html.erb", false)).To(BeFalse())
+	})
+
+	It("returns an error for an invalid glob", func() {
+		_, err := buildignore.Parse(strings.NewReader("[\n"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("Load", func() {
+		var buildDir string
+		BeforeEach(func() {
+			var err error
+			buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+			Expect(err).To(BeNil())
+		})
+		AfterEach(func() {
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+		})
+
+		It("ignores nothing without a .buildignore", func() {
+			matcher, err := buildignore.Load(buildDir)
+			Expect(err).To(BeNil())
+			Expect(matcher.Match("tmp/cache", true)).To(BeFalse())
+		})
+
+		It("reads .buildignore from the app", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, ".buildignore"), []byte("tmp/\n"), 0644)).To(Succeed())
+			matcher, err := buildignore.Load(buildDir)
+			Expect(err).To(BeNil())
+			Expect(matcher.Match("tmp/cache", true)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,106 @@
+// Package bundlepath is where bundler installs the app's gems. It is the
+// vendor_bundle of the dep dir, which is cached and ends up in the droplet,
+// unless BP_BUNDLE_PATH moves it, for instance onto a volume mounted on
+// every cell to try out gems without restaging them each time. Like
+// bundle install --path it is a root, the gems of each ruby are in
+// <engine>/<abi> below it.
+package bundlepath
+
+import (
+	"fmt"
+	"path/filepath"
+	"ruby/depsdir"
+	"strings"
+)
+
+// Path is the root gems are installed to
+type Path struct {
+	// Root is the path while staging
+	Root string
+	// Runtime is Root as profile.d scripts see it, relative to $HOME or
+	// $DEPS_DIR when it is in the droplet
+	Runtime string
+	// InDroplet is false when Root is outside the app and the dep dir. The
+	// droplet then only runs where the gems are at that same path, and they
+	// are not cached.
+	InDroplet bool
+}
+
+// unsafe are characters which would break GEM_PATH or the profile.d scripts
+const unsafe = ":\"'`$\\ \t\n"
+
+// Default is the vendor_bundle of the dep dir of buildpack idx
+func Default(depDir depsdir.Dir, idx string) Path {
+	return Path{
+		Root:      depDir.VendorBundle(),
+		Runtime:   depsdir.Runtime(idx).VendorBundle(),
+		InDroplet: true,
+	}
+}
+
+// Resolve validates value, the BP_BUNDLE_PATH of the app, and returns the
+// Path it is while staging and at runtime. Without a value it is Default.
+func Resolve(value, buildDir string, depDir depsdir.Dir, idx string) (Path, error) {
+	if value == "" {
+		return Default(depDir, idx), nil
+	}
+	if !filepath.IsAbs(value) {
+		return Path{}, fmt.Errorf("BP_BUNDLE_PATH must be an absolute path, not %s", value)
+	}
+	if strings.ContainsAny(value, unsafe) {
+		return Path{}, fmt.Errorf("BP_BUNDLE_PATH can not contain colons, quotes, $, backslashes or whitespace: %s", value)
+	}
+	root := filepath.Clean(value)
+	if root == "/" {
+		return Path{}, fmt.Errorf("BP_BUNDLE_PATH can not be /")
+	}
+
+	if rel, ok := within(buildDir, root); ok {
+		if rel == "." {
+			return Path{}, fmt.Errorf("BP_BUNDLE_PATH can not be the app directory %s", root)
+		}
+		if _, ok := within("vendor/bundle", rel); ok {
+			return Path{}, fmt.Errorf("BP_BUNDLE_PATH can not be in vendor/bundle of the app, which is removed while staging")
+		}
+		return Path{Root: root, Runtime: "$HOME/" + rel, InDroplet: true}, nil
+	}
+	if rel, ok := within(string(depDir), root); ok {
+		if rel == "." {
+			return Path{}, fmt.Errorf("BP_BUNDLE_PATH can not be the dep dir %s", root)
+		}
+		return Path{Root: root, Runtime: string(depsdir.Runtime(idx)) + "/" + rel, InDroplet: true}, nil
+	}
+	if _, ok := within(filepath.Dir(string(depDir)), root); ok {
+		return Path{}, fmt.Errorf("BP_BUNDLE_PATH %s is in the deps dir of another buildpack", root)
+	}
+	return Path{Root: root, Runtime: root}, nil
+}
+
+// IsDefault reports whether p is the vendor_bundle of depDir
+func (p Path) IsDefault(depDir depsdir.Dir) bool {
+	return p.Root == depDir.VendorBundle()
+}
+
+// Dir is the gem dir of a ruby with engine and abi, BUNDLE_PATH for it
+func (p Path) Dir(engineAndABI ...string) string {
+	return filepath.Join(append([]string{p.Root}, engineAndABI...)...)
+}
+
+// RuntimeDir is Dir as profile.d scripts see it
+func (p Path) RuntimeDir(engineAndABI ...string) string {
+	return strings.Join(append([]string{p.Runtime}, engineAndABI...), "/")
+}
+
+// Glob globs name in the gem dir of every engine and abi
+func (p Path) Glob(name ...string) ([]string, error) {
+	return filepath.Glob(p.Dir(append([]string{"*", "*"}, name...)...))
+}
+
+// within returns path relative to dir, if it is dir or below it
+func within(dir, path string) (string, bool) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return rel, true
+}
@@ -0,0 +1,13 @@
+package bundlepath_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestBundlepath(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Bundlepath Suite")
+}
@@ -0,0 +1,63 @@
+package bundlepath_test
+
+import (
+	"ruby/bundlepath"
+	"ruby/depsdir"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bundlepath", func() {
+	var depDir = depsdir.Dir("/tmp/deps/9")
+
+	resolve := func(value string) (bundlepath.Path, error) {
+		return bundlepath.Resolve(value, "/tmp/app", depDir, "9")
+	}
+
+	It("installs gems to the vendor_bundle of the dep dir by default", func() {
+		path, err := resolve("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(path).To(Equal(bundlepath.Path{Root: "/tmp/deps/9/vendor_bundle", Runtime: "$DEPS_DIR/9/vendor_bundle", InDroplet: true}))
+		Expect(path.IsDefault(depDir)).To(BeTrue())
+		Expect(path.Dir("ruby", "2.5.0")).To(Equal("/tmp/deps/9/vendor_bundle/ruby/2.5.0"))
+		Expect(path.RuntimeDir("ruby", "2.5.0")).To(Equal("$DEPS_DIR/9/vendor_bundle/ruby/2.5.0"))
+	})
+
+	It("rewrites paths in the app relative to $HOME", func() {
+		path, err := resolve("/tmp/app/gems/")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(path).To(Equal(bundlepath.Path{Root: "/tmp/app/gems", Runtime: "$HOME/gems", InDroplet: true}))
+		Expect(path.IsDefault(depDir)).To(BeFalse())
+	})
+
+	It("rewrites paths in the dep dir relative to $DEPS_DIR", func() {
+		path, err := resolve("/tmp/deps/9/gems")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(path).To(Equal(bundlepath.Path{Root: "/tmp/deps/9/gems", Runtime: "$DEPS_DIR/9/gems", InDroplet: true}))
+	})
+
+	It("keeps paths outside the droplet as they are", func() {
+		path, err := resolve("/mnt/gems")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(path).To(Equal(bundlepath.Path{Root: "/mnt/gems", Runtime: "/mnt/gems"}))
+		Expect(path.RuntimeDir("ruby", "2.5.0")).To(Equal("/mnt/gems/ruby/2.5.0"))
+	})
+
+	for _, invalid := range []struct{ name, value, message string }{
+		{"relative", "vendor/gems", "must be an absolute path"},
+		{"with a colon", "/mnt/gems:/other", "can not contain"},
+		{"with a space", "/mnt/my gems", "can not contain"},
+		{"root", "/", "can not be /"},
+		{"the app", "/tmp/app", "can not be the app directory"},
+		{"in vendor/bundle of the app", "/tmp/app/vendor/bundle/x", "removed while staging"},
+		{"the dep dir", "/tmp/deps/9/", "can not be the dep dir"},
+		{"in the dep dir of another buildpack", "/tmp/deps/0/gems", "another buildpack"},
+	} {
+		invalid := invalid
+		It("rejects a path which is "+invalid.name, func() {
+			_, err := resolve(invalid.value)
+			Expect(err).To(MatchError(ContainSubstring(invalid.message)))
+		})
+	}
+})
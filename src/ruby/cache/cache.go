@@ -1,27 +1,108 @@
 package cache
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"ruby/depsdir"
+	"ruby/report"
+	"ruby/toolchain"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
 )
 
+// Version is the version of the caches this buildpack saves, of both the
+// fields of metadata.yml and the layout of the cached directories. Bump it
+// with a Migration whenever either changes, so caches saved by older
+// buildpacks are brought forward rather than dropped. Caches saved before
+// the cache was versioned have version 0, those saved before it had
+// migrations version 1.
+const Version = 3
+
+// stampedVersion is the first Version whose caches always have integrity
+// stamps, older ones without any are legacy
+const stampedVersion = 3
+
+// Migration brings a cache saved by an older buildpack to its Version.
+// Metadata migrates the fields of metadata.yml when the cache is loaded,
+// DepDir the cached directories once they are restored into the dep dir.
+// Caches of version 1 may or may not have been migrated already, so both
+// must leave a migrated cache as it is.
+type Migration struct {
+	Version     int
+	Description string
+	Metadata    func(*Metadata)
+	DepDir      func(depsdir.Dir) error
+}
+
+// Migrations are applied in order to caches older than their Version
+var Migrations = []Migration{
+	{
+		Version:     2,
+		Description: "give each cached directory its own key",
+		Metadata:    migrateLegacyKeys,
+	},
+	{
+		Version:     3,
+		Description: "move bundler's git clones out of vendor_bundle into " + depsdir.BundlerGit,
+		DepDir:      depsdir.Dir.MoveGitClones,
+	},
+}
+
+// Cached are the directories of the dep dir supply saves to the cache. Each
+// has its own Key, so a new ruby leaves the gems and the node toolchain
+// cached, and a new stack leaves the git clones.
+var Cached = []string{depsdir.Ruby, depsdir.Bundler, depsdir.VendorBundle, depsdir.BundlerGit, depsdir.BundlerPlugin, depsdir.Node, depsdir.NodeModules, depsdir.Yarn}
+
+// CachedAssets are the directories finalize restores from the cache to
+// compile the assets and saves once they are compiled
+var CachedAssets = []string{depsdir.YarnCache, depsdir.AssetsCache, depsdir.CompiledAssets}
+
+// Key is what a cached directory is valid for, by input. An input which was
+// not recorded when the directory was saved, or is not known now, is not
+// compared.
+type Key map[string]string
+
 type Metadata struct {
-	Stack         string
+	// Version is the version of the cache, see Migrations
+	Version       int
 	SecretKeyBase string
+	// Keys are the Key each cached directory was saved with
+	Keys map[string]Key `yaml:",omitempty"`
+	// Integrity maps each cached directory to the Digest of its contents
+	Integrity map[string]string `yaml:",omitempty"`
+
+	// Stack, BundlerVersion and Toolchain were stamped for every cached
+	// directory before each had its own Key, they are only read from caches
+	// saved then
+	Stack          string             `yaml:",omitempty"`
+	BundlerVersion string             `yaml:",omitempty"`
+	Toolchain      toolchain.Versions `yaml:",omitempty"`
 }
 
 type Cache struct {
 	buildDir string
 	cacheDir string
 	depDir   string
-	names    []string
 	metadata Metadata
+	// keys are what the directories are saved for by this staging
+	keys map[string]Key
+	// restored are the directories Restore brought back from the cache
+	restored map[string]bool
+	appGUID  string
 	log      *libbuildpack.Logger
 	yaml     YAML
+	// compression is off, gzip or auto, see SetCompression
+	compression string
 }
 
 type Stager interface {
@@ -40,8 +121,10 @@ func New(stager Stager, log *libbuildpack.Logger, yaml YAML) (*Cache, error) {
 		buildDir: stager.BuildDir(),
 		cacheDir: stager.CacheDir(),
 		depDir:   filepath.Join(stager.DepDir()),
-		names:    []string{"vendor_bundle", "node_modules"},
 		metadata: Metadata{},
+		keys:     map[string]Key{},
+		restored: map[string]bool{},
+		appGUID:  appGUID(),
 		log:      log,
 		yaml:     yaml,
 	}
@@ -50,48 +133,377 @@ func New(stager Stager, log *libbuildpack.Logger, yaml YAML) (*Cache, error) {
 		if !os.IsNotExist(err) {
 			return nil, err
 		}
+	} else {
+		c.migrate()
 	}
 
 	return c, nil
 }
 
+// migrate applies the Metadata migrations of the Migrations the loaded
+// metadata is older than. Its version is only brought forward by
+// MigrateDepDir, once the cached directories are migrated as well. Metadata
+// from a newer buildpack is left as it is, Restore drops the cache.
+func (c *Cache) migrate() {
+	for _, migration := range Migrations {
+		if migration.Version <= c.metadata.Version || migration.Metadata == nil {
+			continue
+		}
+		c.log.Debug("Migrating the cache metadata to version %d: %s", migration.Version, migration.Description)
+		migration.Metadata(&c.metadata)
+	}
+}
+
+// MigrateDepDir applies the DepDir migrations of the Migrations the cache
+// restored into dir is older than, and brings its version forward
+func MigrateDepDir(dir depsdir.Dir, metadata *Metadata, log *libbuildpack.Logger) error {
+	for _, migration := range Migrations {
+		if migration.Version <= metadata.Version || migration.DepDir == nil {
+			continue
+		}
+		log.Debug("Migrating the dep dir to version %d: %s", migration.Version, migration.Description)
+		if err := migration.DepDir(dir); err != nil {
+			return fmt.Errorf("migrating to version %d: %v", migration.Version, err)
+		}
+	}
+	if metadata.Version < Version {
+		metadata.Version = Version
+	}
+	return nil
+}
+
+// migrateLegacyKeys keys the directories of a cache saved before each had
+// its own Key
+func migrateLegacyKeys(m *Metadata) {
+	if m.Keys != nil {
+		return
+	}
+	m.Keys = legacyKeys(*m)
+	m.Stack, m.BundlerVersion, m.Toolchain = "", "", toolchain.Versions{}
+}
+
+// legacyKeys are the keys of a cache saved before each directory had its
+// own, when vendor_bundle, node_modules and bundler_git were all saved for
+// the stack, bundler and toolchain in the metadata
+func legacyKeys(m Metadata) map[string]Key {
+	return map[string]Key{
+		depsdir.VendorBundle: vendorBundleKey(m.Stack, m.BundlerVersion, m.Toolchain),
+		depsdir.NodeModules:  {"stack": m.Stack},
+		depsdir.BundlerGit:   {},
+	}
+}
+
+// vendorBundleKey invalidates the gems on a new stack, and on a new bundler
+// major version, since bundler 1 and 2 lay out installed gems differently,
+// or rootfs toolchain, since native extensions may no longer load. A new
+// ruby has its own directory in vendor_bundle.
+func vendorBundleKey(stack, bundlerVersion string, tools toolchain.Versions) Key {
+	return Key{
+		"stack":                 stack,
+		"bundler major version": majorVersion(bundlerVersion),
+		"gcc":                   tools.GCC,
+		"make":                  tools.Make,
+		"libc":                  tools.Libc,
+	}
+}
+
+// changed returns why a directory saved for saved is not valid for k, an
+// empty string when every input known to both matches
+func (k Key) changed(saved Key) string {
+	var inputs []string
+	for input := range k {
+		inputs = append(inputs, input)
+	}
+	sort.Strings(inputs)
+	for _, input := range inputs {
+		if saved[input] != "" && k[input] != "" && saved[input] != k[input] {
+			return fmt.Sprintf("%s changed from %s to %s", input, saved[input], k[input])
+		}
+	}
+	return ""
+}
+
 func (c *Cache) Metadata() *Metadata {
 	return &c.metadata
 }
 
-func (c *Cache) Restore() error {
-	if c.metadata.Stack == os.Getenv("CF_STACK") {
-		for _, name := range c.names {
-			if exists, err := libbuildpack.FileExists(filepath.Join(c.cacheDir, name)); err != nil {
+// Restore moves the cached directories into the dep dir. A directory is
+// not restored when an input of its Key changed, the others are restored
+// either way, and nothing is restored when the cache version changed. Each
+// BUNDLE_GEMFILE has its own cached vendor_bundle, so dual boot apps can
+// stage either.
+func (c *Cache) Restore(bundlerVersion string, tools toolchain.Versions) error {
+	stack := os.Getenv("CF_STACK")
+	c.keys[depsdir.Ruby] = Key{"stack": stack}
+	c.keys[depsdir.VendorBundle] = vendorBundleKey(stack, bundlerVersion, tools)
+	c.keys[depsdir.Node] = Key{"stack": stack}
+	c.keys[depsdir.NodeModules] = Key{"stack": stack}
+
+	if reason := c.incompatible(); reason != "" {
+		c.log.BeginStep("Skipping restoring the cache, %s", reason)
+		for _, name := range append(Cached, CachedAssets...) {
+			if err := c.removeCached(cachedName(name)); err != nil {
 				return err
-			} else if exists {
+			}
+		}
+		return c.removeVendorBundles()
+	}
+
+	if err := c.restore(Cached); err != nil {
+		return err
+	}
+	return c.removeCached(cachedName(depsdir.VendorBundle))
+}
+
+// incompatible returns why nothing in the cache can be restored, an empty
+// string when the cache can be
+func (c *Cache) incompatible() string {
+	if c.metadata.Version > Version {
+		return fmt.Sprintf("it has version %d, newer than the %d this buildpack reads", c.metadata.Version, Version)
+	}
+	return ""
+}
+
+// RestoreAssets moves the cached yarn cache and sprockets cache into the
+// dep dir for finalize, once supply restored the rest
+func (c *Cache) RestoreAssets() error {
+	return c.restore(CachedAssets)
+}
+
+func (c *Cache) restore(names []string) error {
+	for _, name := range names {
+		if reason := c.keys[name].changed(c.metadata.Keys[name]); reason != "" {
+			c.log.BeginStep("Skipping restoring %s from cache, %s", name, reason)
+			if name == depsdir.VendorBundle {
+				if err := c.removeVendorBundles(); err != nil {
+					return err
+				}
+			} else if err := c.removeCached(name); err != nil {
+				return err
+			}
+			continue
+		}
+		cached, archived, err := c.stored(cachedName(name))
+		if err != nil {
+			return err
+		} else if cached != "" {
+			if reason, err := c.tampered(cached); err != nil {
+				return err
+			} else if reason != "" {
+				c.log.Warning("Discarding %s from cache, %s", cached, reason)
+				if err := os.RemoveAll(filepath.Join(c.cacheDir, cached)); err != nil {
+					return err
+				}
+				continue
+			}
+			if restored, err := libbuildpack.FileExists(filepath.Join(c.depDir, name)); err != nil {
+				return err
+			} else if restored {
+				c.log.Debug("Keeping %s from an earlier run of supply", name)
+				continue
+			}
+			if cachedName(name) != name {
+				c.log.BeginStep("Restoring %s for %s from cache", name, os.Getenv("BUNDLE_GEMFILE"))
+			} else {
 				c.log.BeginStep("Restoring %s from cache", name)
-				if err := os.Rename(filepath.Join(c.cacheDir, name), filepath.Join(c.depDir, name)); err != nil {
+			}
+			if archived {
+				if err := extractArchive(filepath.Join(c.cacheDir, cached), filepath.Join(c.depDir, name)); err != nil {
+					os.RemoveAll(filepath.Join(c.depDir, name))
+					return fmt.Errorf("Could not extract %s: %v", cached, err)
+				}
+				if err := os.Remove(filepath.Join(c.cacheDir, cached)); err != nil {
 					return err
 				}
+			} else if err := os.Rename(filepath.Join(c.cacheDir, cached), filepath.Join(c.depDir, name)); err != nil {
+				return err
 			}
+			c.restored[name] = true
 		}
-	} else if c.metadata.Stack != "" {
-		c.log.BeginStep("Skipping restoring vendor_bundle from cache, stack changed from %s to %s", c.metadata.Stack, os.Getenv("CF_STACK"))
 	}
-	return os.RemoveAll(filepath.Join(c.cacheDir, "vendor_bundle"))
+	return nil
 }
 
+// Stamp adds input to the Key the cached directory name is saved with, for
+// what is only known once it is installed, like the version of ruby
+func (c *Cache) Stamp(name, input, value string) {
+	if c.keys[name] == nil {
+		c.keys[name] = Key{}
+	}
+	c.keys[name][input] = value
+}
+
+// Stamped returns the value of input in the Key of the directory name, or
+// an empty string unless Restore brought name back from the cache
+func (c *Cache) Stamped(name, input string) string {
+	if !c.restored[name] {
+		return ""
+	}
+	return c.metadata.Keys[name][input]
+}
+
+// stored returns how cached is stored in the cache dir: as a directory, as
+// an archive, or not at all when the returned name is empty
+func (c *Cache) stored(cached string) (string, bool, error) {
+	for _, candidate := range []struct {
+		name     string
+		archived bool
+	}{{cached, false}, {cached + ArchiveExt, true}} {
+		if exists, err := libbuildpack.FileExists(filepath.Join(c.cacheDir, candidate.name)); err != nil {
+			return "", false, err
+		} else if exists {
+			return candidate.name, candidate.archived, nil
+		}
+	}
+	return "", false, nil
+}
+
+// removeCached removes cached from the cache dir, however it is stored
+func (c *Cache) removeCached(cached string) error {
+	if err := os.RemoveAll(filepath.Join(c.cacheDir, cached)); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(c.cacheDir, cached+ArchiveExt))
+}
+
+// removeVendorBundles removes the cached vendor_bundle of every Gemfile
+func (c *Cache) removeVendorBundles() error {
+	for _, cached := range c.vendorBundles() {
+		if err := os.RemoveAll(filepath.Join(c.cacheDir, cached)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cache) vendorBundles() []string {
+	matches, _ := filepath.Glob(filepath.Join(c.cacheDir, "vendor_bundle*"))
+	var names []string
+	for _, match := range matches {
+		names = append(names, filepath.Base(match))
+	}
+	return names
+}
+
+// tampered returns why the cached directory name can not be trusted, an
+// empty string means its contents match the digest recorded when it was
+// saved by this app
+func (c *Cache) tampered(name string) (string, error) {
+	expected, found := c.metadata.Integrity[name]
+	if !found && c.legacy() {
+		c.log.Debug("Trusting %s from a cache saved before integrity stamps, it is stamped when it is saved again", name)
+		return "", nil
+	} else if !found {
+		return "it has no integrity stamp", nil
+	}
+	actual, err := Digest(filepath.Join(c.cacheDir, name), c.appGUID)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal([]byte(expected), []byte(actual)) {
+		return "its contents do not match the integrity stamp for this app", nil
+	}
+	return "", nil
+}
+
+// legacy reports whether the cache was saved before every cached directory
+// had an integrity stamp. Its directories can not be checked, they are
+// migrated and stamped instead of being thrown away, once.
+func (c *Cache) legacy() bool {
+	return c.metadata.Integrity == nil && c.metadata.Version < stampedVersion
+}
+
+// Save saves the directories supply installed to the cache
 func (c *Cache) Save() error {
-	for _, name := range c.names {
+	return c.save(Cached)
+}
+
+// SaveAssets saves the yarn cache and the sprockets cache once finalize
+// compiled the assets, the directories saved by supply stay cached
+func (c *Cache) SaveAssets() error {
+	return c.save(CachedAssets)
+}
+
+func (c *Cache) save(names []string) error {
+	// Whatever else is cached, like the vendor_bundles of the other
+	// Gemfiles, keeps its stamp
+	saving := map[string]bool{}
+	for _, name := range names {
+		saving[cachedName(name)] = true
+		saving[cachedName(name)+ArchiveExt] = true
+	}
+	integrity := map[string]string{}
+	for cached, digest := range c.metadata.Integrity {
+		if saving[cached] {
+			continue
+		}
+		if exists, err := libbuildpack.FileExists(filepath.Join(c.cacheDir, cached)); err != nil {
+			return err
+		} else if exists {
+			integrity[cached] = digest
+		}
+	}
+	if c.metadata.Keys == nil {
+		c.metadata.Keys = map[string]Key{}
+	}
+	saves := map[string]report.CacheSave{}
+	for _, name := range names {
 		if exists, err := libbuildpack.FileExists(filepath.Join(c.depDir, name)); err != nil {
 			return err
 		} else if exists {
-			c.log.BeginStep("Saving %s to cache", name)
-			cmd := exec.Command("cp", "-al", filepath.Join(c.depDir, name), filepath.Join(c.cacheDir, name))
-			if output, err := cmd.CombinedOutput(); err != nil {
-				c.log.Error(string(output))
-				return fmt.Errorf("Could not copy %s: %v", name, err)
+			start := time.Now()
+			cached := cachedName(name)
+			if err := c.removeCached(cached); err != nil {
+				return err
+			}
+			save := report.CacheSave{Compression: c.compressionFor(name)}
+			if save.Compression == Gzip {
+				cached += ArchiveExt
+				var err error
+				if save.Bytes, save.Stored, err = writeArchive(filepath.Join(c.depDir, name), filepath.Join(c.cacheDir, cached)); err != nil {
+					os.Remove(filepath.Join(c.cacheDir, cached))
+					return fmt.Errorf("Could not compress %s: %v", name, err)
+				}
+			} else {
+				c.log.BeginStep("Saving %s to cache", name)
+				cmd := exec.Command("cp", "-al", filepath.Join(c.depDir, name), filepath.Join(c.cacheDir, cached))
+				if output, err := cmd.CombinedOutput(); err != nil {
+					c.log.Error(string(output))
+					// A partial copy would only be discarded by the next Restore
+					os.RemoveAll(filepath.Join(c.cacheDir, cached))
+					return fmt.Errorf("Could not copy %s: %v", name, err)
+				}
+			}
+			digest, err := Digest(filepath.Join(c.cacheDir, cached), c.appGUID)
+			if err != nil {
+				return fmt.Errorf("Could not stamp %s: %v", name, err)
+			}
+			integrity[cached] = digest
+			c.metadata.Keys[name] = c.keys[name]
+
+			save.Seconds = time.Since(start).Seconds()
+			if save.Compression == Gzip {
+				c.log.BeginStep("Saving %s to cache, compressed %s to %s in %.1fs", name, megabytes(save.Bytes), megabytes(save.Stored), save.Seconds)
 			}
+			saves[name] = save
+		}
+	}
+	if err := report.Update(c.depDir, func(r *report.Report) {
+		if r.Metrics == nil {
+			r.Metrics = &report.Metrics{}
+		}
+		if r.Metrics.CacheSaves == nil {
+			r.Metrics.CacheSaves = map[string]report.CacheSave{}
+		}
+		for name, save := range saves {
+			r.Metrics.CacheSaves[name] = save
 		}
+	}); err != nil {
+		return err
 	}
 
-	c.metadata.Stack = os.Getenv("CF_STACK")
+	c.metadata.Version = Version
+	c.metadata.Integrity = integrity
 	if err := c.yaml.Write(c.metadata_yml(), c.metadata); err != nil {
 		return err
 	}
@@ -102,3 +514,78 @@ func (c *Cache) Save() error {
 func (c *Cache) metadata_yml() string {
 	return filepath.Join(c.cacheDir, "metadata.yml")
 }
+
+// majorVersion returns the major version of bundler, caches written before
+// the bundler version was recorded were created with bundler 1
+func majorVersion(version string) string {
+	if version == "" {
+		return "1"
+	}
+	return strings.SplitN(version, ".", 2)[0]
+}
+
+// Digest is an HMAC over the paths, modes and contents of everything in dir,
+// keyed by the app GUID so a cache copied from another app, or modified
+// after it was saved, does not match
+func Digest(dir, appGUID string) (string, error) {
+	key := hmac.New(sha256.New, []byte("ruby-buildpack cache"))
+	key.Write([]byte(appGUID))
+	mac := hmac.New(sha256.New, key.Sum(nil))
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(mac, "%s\x00%o\x00", rel, info.Mode())
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(mac, "%s\x00", target)
+		case info.Mode().IsRegular():
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			hash := sha256.New()
+			if _, err := io.Copy(hash, file); err != nil {
+				return err
+			}
+			fmt.Fprintf(mac, "%x\x00", hash.Sum(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// appGUID is the GUID of the app being staged, from VCAP_APPLICATION
+func appGUID() string {
+	var app struct {
+		ApplicationID string `json:"application_id"`
+	}
+	if err := json.Unmarshal([]byte(os.Getenv("VCAP_APPLICATION")), &app); err != nil {
+		return ""
+	}
+	return app.ApplicationID
+}
+
+// cachedName is where name is cached, vendor_bundle is cached separately
+// for each BUNDLE_GEMFILE other than the default
+func cachedName(name string) string {
+	gemfile := os.Getenv("BUNDLE_GEMFILE")
+	if name != depsdir.VendorBundle || gemfile == "" || gemfile == "Gemfile" {
+		return name
+	}
+	return name + "." + strings.Replace(gemfile, "/", "_", -1)
+}
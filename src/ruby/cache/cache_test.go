@@ -2,10 +2,14 @@ package cache_test
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"ruby/cache"
+	"ruby/depsdir"
+	"ruby/report"
+	"ruby/toolchain"
 
 	"github.com/cloudfoundry/libbuildpack"
 	"github.com/cloudfoundry/libbuildpack/ansicleaner"
@@ -28,6 +32,7 @@ var _ = Describe("Cache", func() {
 		mockCtrl   *gomock.Controller
 		mockYaml   *MockYAML
 		mockStager *MockStager
+		tools      toolchain.Versions
 	)
 
 	BeforeEach(func() {
@@ -41,6 +46,7 @@ var _ = Describe("Cache", func() {
 		Expect(err).To(BeNil())
 
 		depsIdx = "23"
+		tools = toolchain.Versions{GCC: "7.5.0", Make: "4.1", Libc: "2.27"}
 		Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx), 0755)).To(Succeed())
 
 		buffer = new(bytes.Buffer)
@@ -60,6 +66,15 @@ var _ = Describe("Cache", func() {
 		Expect(os.RemoveAll(cacheDir)).To(Succeed())
 	})
 
+	Describe("Migrations", func() {
+		It("has a migration for every version after 1 in order", func() {
+			for i, migration := range cache.Migrations {
+				Expect(migration.Version).To(Equal(i + 2))
+			}
+			Expect(cache.Migrations[len(cache.Migrations)-1].Version).To(Equal(cache.Version))
+		})
+	})
+
 	Describe("New", func() {
 		Context("cache/metadata.yml exists", func() {
 			BeforeEach(func() {
@@ -75,9 +90,44 @@ var _ = Describe("Cache", func() {
 				c, err := cache.New(mockStager, logger, mockYaml)
 				Expect(err).ToNot(HaveOccurred())
 
-				Expect(c.Metadata().Stack).To(Equal("cflinuxfs9"))
 				Expect(c.Metadata().SecretKeyBase).To(Equal("abcdef"))
 			})
+
+			It("keys the directories of a cache saved before each had its own key", func() {
+				c, err := cache.New(mockStager, logger, mockYaml)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(c.Metadata().Stack).To(Equal(""))
+				Expect(c.Metadata().Keys).To(HaveKeyWithValue("node_modules", cache.Key{"stack": "cflinuxfs9"}))
+				Expect(c.Metadata().Keys).To(HaveKeyWithValue("vendor_bundle", HaveKeyWithValue("bundler major version", "1")))
+				Expect(c.Metadata().Keys).To(HaveKeyWithValue("bundler_git", cache.Key{}))
+			})
+
+			It("leaves the version to MigrateDepDir", func() {
+				c, err := cache.New(mockStager, logger, mockYaml)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(c.Metadata().Version).To(Equal(0))
+			})
+		})
+
+		Context("cache/metadata.yml has the current version", func() {
+			BeforeEach(func() {
+				mockYaml.EXPECT().Load(filepath.Join(cacheDir, "metadata.yml"), gomock.Any()).Do(func(_ string, val interface{}) error {
+					metadata := val.(*cache.Metadata)
+					metadata.Version = cache.Version
+					metadata.Stack = "cflinuxfs9"
+					return nil
+				})
+			})
+
+			It("does not migrate it again", func() {
+				c, err := cache.New(mockStager, logger, mockYaml)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(c.Metadata().Keys).To(BeNil())
+				Expect(c.Metadata().Stack).To(Equal("cflinuxfs9"))
+			})
 		})
 
 		Context("cache/metadata.yml does NOT exist", func() {
@@ -89,7 +139,7 @@ var _ = Describe("Cache", func() {
 				c, err := cache.New(mockStager, logger, mockYaml)
 				Expect(err).ToNot(HaveOccurred())
 
-				Expect(c.Metadata().Stack).To(Equal(""))
+				Expect(c.Metadata().Keys).To(BeEmpty())
 				Expect(c.Metadata().SecretKeyBase).To(Equal(""))
 			})
 		})
@@ -117,18 +167,231 @@ var _ = Describe("Cache", func() {
 
 			Expect(c.Save()).To(Succeed())
 		})
+
+		It("Stamps the cache version and the key of each saved directory", func() {
+			os.Setenv("CF_STACK", "cflinuxfs8")
+			Expect(c.Restore("2.0.1", tools)).To(Succeed())
+			mockYaml.EXPECT().Write(filepath.Join(cacheDir, "metadata.yml"), gomock.Any()).Do(func(_ string, val interface{}) {
+				metadata := val.(cache.Metadata)
+				Expect(metadata.Version).To(Equal(cache.Version))
+				Expect(metadata.Keys).To(Equal(map[string]cache.Key{
+					"vendor_bundle": {"stack": "cflinuxfs8", "bundler major version": "2", "gcc": "7.5.0", "make": "4.1", "libc": "2.27"},
+				}))
+			}).Return(nil)
+
+			Expect(c.Save()).To(Succeed())
+		})
+
+		It("Stamps what was stamped once it was installed", func() {
+			os.Setenv("CF_STACK", "cflinuxfs8")
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "ruby", "bin"), 0755)).To(Succeed())
+			Expect(c.Restore("2.0.1", tools)).To(Succeed())
+			c.Stamp("ruby", "ruby", "3.2.2")
+			mockYaml.EXPECT().Write(filepath.Join(cacheDir, "metadata.yml"), gomock.Any()).Do(func(_ string, val interface{}) {
+				metadata := val.(cache.Metadata)
+				Expect(metadata.Keys).To(HaveKeyWithValue("ruby", cache.Key{"stack": "cflinuxfs8", "ruby": "3.2.2"}))
+			}).Return(nil)
+
+			Expect(c.Save()).To(Succeed())
+			Expect(filepath.Join(cacheDir, "ruby", "bin")).To(BeADirectory())
+		})
+
+		It("leaves the asset caches to SaveAssets", func() {
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "yarn_cache", "v6"), 0755)).To(Succeed())
+			mockYaml.EXPECT().Write(filepath.Join(cacheDir, "metadata.yml"), gomock.Any()).Return(nil)
+
+			Expect(c.Save()).To(Succeed())
+			Expect(filepath.Join(cacheDir, "yarn_cache")).ToNot(BeADirectory())
+		})
+
+		It("Saves vendor_bundle for another BUNDLE_GEMFILE next to the default one", func() {
+			os.Setenv("BUNDLE_GEMFILE", "Gemfile_next")
+			defer os.Unsetenv("BUNDLE_GEMFILE")
+			Expect(os.MkdirAll(filepath.Join(cacheDir, "vendor_bundle"), 0755)).To(Succeed())
+			c.Metadata().Integrity = map[string]string{"vendor_bundle": "default-digest"}
+			mockYaml.EXPECT().Write(filepath.Join(cacheDir, "metadata.yml"), gomock.Any()).Do(func(_ string, val interface{}) {
+				metadata := val.(cache.Metadata)
+				Expect(metadata.Integrity).To(HaveKeyWithValue("vendor_bundle", "default-digest"))
+				Expect(metadata.Integrity).To(HaveKeyWithValue("vendor_bundle.Gemfile_next", digest(filepath.Join(cacheDir, "vendor_bundle.Gemfile_next"), "")))
+			}).Return(nil)
+
+			Expect(c.Save()).To(Succeed())
+			Expect(filepath.Join(cacheDir, "vendor_bundle.Gemfile_next", "adir", "bdir")).To(BeADirectory())
+		})
+
+		It("Stamps the integrity of each saved directory", func() {
+			os.Setenv("VCAP_APPLICATION", `{"application_id":"app-guid"}`)
+			defer os.Unsetenv("VCAP_APPLICATION")
+			mockYaml.EXPECT().Load(filepath.Join(cacheDir, "metadata.yml"), gomock.Any()).Return(os.ErrNotExist)
+			c, err := cache.New(mockStager, logger, mockYaml)
+			Expect(err).ToNot(HaveOccurred())
+
+			mockYaml.EXPECT().Write(filepath.Join(cacheDir, "metadata.yml"), gomock.Any()).Do(func(_ string, val interface{}) {
+				metadata := val.(cache.Metadata)
+				Expect(metadata.Integrity).To(HaveLen(1))
+				Expect(metadata.Integrity["vendor_bundle"]).To(Equal(digest(filepath.Join(cacheDir, "vendor_bundle"), "app-guid")))
+			}).Return(nil)
+
+			Expect(c.Save()).To(Succeed())
+		})
 	})
 
-	Describe("Restore", func() {
+	Describe("SaveAssets", func() {
 		var c *cache.Cache
 		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "yarn_cache", "v6"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "assets_cache", "sprockets"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(cacheDir, "vendor_bundle"), 0755)).To(Succeed())
+			mockYaml.EXPECT().Load(filepath.Join(cacheDir, "metadata.yml"), gomock.Any()).Do(func(_ string, val interface{}) error {
+				metadata := val.(*cache.Metadata)
+				metadata.Version = cache.Version
+				metadata.Keys = map[string]cache.Key{"vendor_bundle": {"stack": "cflinuxfs8"}}
+				metadata.Integrity = map[string]string{"vendor_bundle": "supply-digest", "node_modules": "gone-digest"}
+				return nil
+			})
+			var err error
+			c, err = cache.New(mockStager, logger, mockYaml)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("saves the yarn and sprockets caches next to what supply saved", func() {
+			mockYaml.EXPECT().Write(filepath.Join(cacheDir, "metadata.yml"), gomock.Any()).Do(func(_ string, val interface{}) {
+				metadata := val.(cache.Metadata)
+				Expect(metadata.Keys).To(HaveKeyWithValue("vendor_bundle", cache.Key{"stack": "cflinuxfs8"}))
+				Expect(metadata.Keys).To(HaveKey("yarn_cache"))
+				Expect(metadata.Integrity).To(HaveKeyWithValue("vendor_bundle", "supply-digest"))
+				Expect(metadata.Integrity).To(HaveKey("yarn_cache"))
+				Expect(metadata.Integrity).To(HaveKey("assets_cache"))
+				Expect(metadata.Integrity).ToNot(HaveKey("node_modules"))
+			}).Return(nil)
+
+			Expect(c.SaveAssets()).To(Succeed())
+			Expect(filepath.Join(cacheDir, "yarn_cache", "v6")).To(BeADirectory())
+			Expect(filepath.Join(cacheDir, "assets_cache", "sprockets")).To(BeADirectory())
+		})
+	})
+
+	Describe("SetCompression", func() {
+		It("accepts off, gzip and auto", func() {
+			mockYaml.EXPECT().Load(gomock.Any(), gomock.Any()).Return(os.ErrNotExist)
+			c, err := cache.New(mockStager, logger, mockYaml)
+			Expect(err).ToNot(HaveOccurred())
+			for _, setting := range []string{"", "off", "gzip", "auto"} {
+				Expect(c.SetCompression(setting)).To(Succeed())
+			}
+			Expect(c.SetCompression("zstd")).To(MatchError("zstd cache compression is not available in this buildpack, use gzip or auto"))
+			Expect(c.SetCompression("xz")).To(MatchError(`unknown cache compression "xz", use off, gzip or auto`))
+		})
+	})
+
+	Describe("compressed caches", func() {
+		var metadata cache.Metadata
+
+		BeforeEach(func() {
+			os.Setenv("CF_STACK", "cflinuxfs8")
+			bundle := filepath.Join(depsDir, depsIdx, "vendor_bundle", "ruby", "2.5.0")
+			Expect(os.MkdirAll(filepath.Join(bundle, "gems", "rack-2.0.5", "lib"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(bundle, "gems", "rack-2.0.5", "lib", "rack.rb"), bytes.Repeat([]byte("module Rack; end\n"), 1000), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(bundle, "bin"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(bundle, "bin", "rackup"), []byte("#!/usr/bin/env ruby\n"), 0755)).To(Succeed())
+			Expect(os.Symlink("../gems/rack-2.0.5/lib/rack.rb", filepath.Join(bundle, "bin", "rack.rb"))).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "bundler_git", "rails-0123"), 0755)).To(Succeed())
+
+			mockYaml.EXPECT().Load(filepath.Join(cacheDir, "metadata.yml"), gomock.Any()).Return(os.ErrNotExist)
+			c, err := cache.New(mockStager, logger, mockYaml)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.SetCompression("auto")).To(Succeed())
+			Expect(c.Restore("1.16.3", tools)).To(Succeed())
+			mockYaml.EXPECT().Write(filepath.Join(cacheDir, "metadata.yml"), gomock.Any()).Do(func(_ string, val interface{}) {
+				metadata = val.(cache.Metadata)
+			}).Return(nil)
+			Expect(c.Save()).To(Succeed())
+		})
+		AfterEach(func() {
+			os.Unsetenv("CF_STACK")
+		})
+
+		It("compresses gems but not the git clones", func() {
+			Expect(filepath.Join(cacheDir, "vendor_bundle.tgz")).To(BeARegularFile())
+			Expect(filepath.Join(cacheDir, "vendor_bundle")).ToNot(BeADirectory())
+			Expect(filepath.Join(cacheDir, "bundler_git", "rails-0123")).To(BeADirectory())
+			Expect(metadata.Integrity).To(HaveKeyWithValue("vendor_bundle.tgz", digest(filepath.Join(cacheDir, "vendor_bundle.tgz"), "")))
+			Expect(buffer.String()).To(MatchRegexp(`Saving vendor_bundle to cache, compressed [0-9.]+MB to [0-9.]+MB in [0-9.]+s`))
+		})
+
+		It("reports how long each save took", func() {
+			r, err := report.Load(filepath.Join(depsDir, depsIdx))
+			Expect(err).To(BeNil())
+			Expect(r.Metrics.CacheSaves).To(HaveLen(2))
+			Expect(r.Metrics.CacheSaves["vendor_bundle"].Compression).To(Equal("gzip"))
+			Expect(r.Metrics.CacheSaves["vendor_bundle"].Stored).To(BeNumerically("<", r.Metrics.CacheSaves["vendor_bundle"].Bytes))
+			Expect(r.Metrics.CacheSaves["bundler_git"]).To(Equal(report.CacheSave{Compression: "off", Seconds: r.Metrics.CacheSaves["bundler_git"].Seconds}))
+		})
+
+		It("restores the compressed directory", func() {
+			Expect(os.RemoveAll(filepath.Join(depsDir, depsIdx, "vendor_bundle"))).To(Succeed())
+			Expect(os.RemoveAll(filepath.Join(depsDir, depsIdx, "bundler_git"))).To(Succeed())
+			mockYaml.EXPECT().Load(filepath.Join(cacheDir, "metadata.yml"), gomock.Any()).Do(func(_ string, val interface{}) error {
+				*val.(*cache.Metadata) = metadata
+				return nil
+			})
+			c, err := cache.New(mockStager, logger, mockYaml)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.Restore("1.16.3", tools)).To(Succeed())
+
+			bundle := filepath.Join(depsDir, depsIdx, "vendor_bundle", "ruby", "2.5.0")
+			Expect(ioutil.ReadFile(filepath.Join(bundle, "bin", "rack.rb"))).To(Equal(bytes.Repeat([]byte("module Rack; end\n"), 1000)))
+			info, err := os.Stat(filepath.Join(bundle, "bin", "rackup"))
+			Expect(err).To(BeNil())
+			Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+			Expect(filepath.Join(depsDir, depsIdx, "bundler_git", "rails-0123")).To(BeADirectory())
+			Expect(filepath.Join(cacheDir, "vendor_bundle.tgz")).ToNot(BeAnExistingFile())
+		})
+	})
+
+	Describe("Restore", func() {
+		var (
+			c               *cache.Cache
+			metadataVersion int
+			metadataBundler string
+			metadataTools   toolchain.Versions
+			stampedBy       string
+		)
+		BeforeEach(func() {
+			metadataVersion = 1
+			metadataTools = tools
+			metadataBundler = "1.16.3"
+			stampedBy = "app-guid"
+			os.Setenv("VCAP_APPLICATION", `{"application_id":"app-guid","application_name":"app"}`)
 			Expect(os.MkdirAll(filepath.Join(cacheDir, "vendor_bundle", "adir", "bdir"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "vendor_bundle", "adir", "rack.rb"), []byte("module Rack; end"), 0644)).To(Succeed())
 			mockYaml.EXPECT().Load(filepath.Join(cacheDir, "metadata.yml"), gomock.Any()).Do(func(_ string, val interface{}) error {
 				metadata := val.(*cache.Metadata)
 				metadata.Stack = "cflinuxfs8"
 				metadata.SecretKeyBase = "abcdef"
+				// The buildpack saved nothing else before the cache was versioned
+				if metadataVersion == 0 {
+					return nil
+				}
+				metadata.Version = metadataVersion
+				metadata.BundlerVersion = metadataBundler
+				metadata.Toolchain = metadataTools
+				metadata.Integrity = map[string]string{
+					"vendor_bundle": digest(filepath.Join(cacheDir, "vendor_bundle"), stampedBy),
+					"node_modules":  digest(filepath.Join(cacheDir, "node_modules"), stampedBy),
+					"bundler_git":   digest(filepath.Join(cacheDir, "bundler_git"), stampedBy),
+				}
 				return nil
 			})
+			Expect(os.MkdirAll(filepath.Join(cacheDir, "node_modules", "left-pad"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(cacheDir, "bundler_git", "rails-0123"), 0755)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("VCAP_APPLICATION")
+		})
+
+		JustBeforeEach(func() {
 			var err error
 			c, err = cache.New(mockStager, logger, mockYaml)
 			Expect(err).ToNot(HaveOccurred())
@@ -139,11 +402,216 @@ var _ = Describe("Cache", func() {
 				os.Setenv("CF_STACK", "cflinuxfs8")
 			})
 			It("restores vendor_bundle directory", func() {
-				Expect(c.Restore()).To(Succeed())
+				Expect(c.Restore("1.16.3", tools)).To(Succeed())
 
 				Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle", "adir", "bdir")).To(BeADirectory())
 				Expect(filepath.Join(cacheDir, "vendor_bundle")).ToNot(BeADirectory())
 			})
+
+			Context("the bundler major version changed", func() {
+				It("restores node_modules and the git clones but not vendor_bundle", func() {
+					Expect(c.Restore("2.0.1", tools)).To(Succeed())
+
+					Expect(buffer.String()).To(ContainSubstring("Skipping restoring vendor_bundle from cache, bundler major version changed from 1 to 2"))
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle")).ToNot(BeADirectory())
+					Expect(filepath.Join(depsDir, depsIdx, "node_modules", "left-pad")).To(BeADirectory())
+					Expect(filepath.Join(depsDir, depsIdx, "bundler_git", "rails-0123")).To(BeADirectory())
+					Expect(filepath.Join(cacheDir, "vendor_bundle")).ToNot(BeADirectory())
+				})
+			})
+
+			Context("an earlier run of supply restored vendor_bundle", func() {
+				BeforeEach(func() {
+					Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "vendor_bundle", "installed"), 0755)).To(Succeed())
+				})
+
+				It("keeps the restored vendor_bundle", func() {
+					Expect(c.Restore("1.16.3", tools)).To(Succeed())
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle", "installed")).To(BeADirectory())
+					Expect(filepath.Join(depsDir, depsIdx, "node_modules", "left-pad")).To(BeADirectory())
+				})
+			})
+
+			Context("the stack toolchain changed", func() {
+				BeforeEach(func() {
+					metadataTools = toolchain.Versions{GCC: "7.4.0", Make: "4.1", Libc: "2.27"}
+				})
+
+				It("restores node_modules but not vendor_bundle", func() {
+					Expect(c.Restore("1.16.3", tools)).To(Succeed())
+
+					Expect(buffer.String()).To(ContainSubstring("Skipping restoring vendor_bundle from cache, gcc changed from 7.4.0 to 7.5.0"))
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle")).ToNot(BeADirectory())
+					Expect(filepath.Join(depsDir, depsIdx, "node_modules", "left-pad")).To(BeADirectory())
+				})
+			})
+
+			Context("BUNDLE_GEMFILE is Gemfile_next", func() {
+				BeforeEach(func() {
+					os.Setenv("BUNDLE_GEMFILE", "Gemfile_next")
+				})
+				AfterEach(func() {
+					os.Unsetenv("BUNDLE_GEMFILE")
+				})
+
+				It("does not restore the vendor_bundle of the default Gemfile, and keeps it cached", func() {
+					Expect(c.Restore("1.16.3", tools)).To(Succeed())
+
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle")).ToNot(BeADirectory())
+					Expect(filepath.Join(depsDir, depsIdx, "node_modules", "left-pad")).To(BeADirectory())
+					Expect(filepath.Join(cacheDir, "vendor_bundle", "adir", "bdir")).To(BeADirectory())
+				})
+
+				It("restores the vendor_bundle cached for Gemfile_next", func() {
+					Expect(os.MkdirAll(filepath.Join(cacheDir, "vendor_bundle.Gemfile_next", "next"), 0755)).To(Succeed())
+					c.Metadata().Integrity["vendor_bundle.Gemfile_next"] = digest(filepath.Join(cacheDir, "vendor_bundle.Gemfile_next"), stampedBy)
+
+					Expect(c.Restore("1.16.3", tools)).To(Succeed())
+
+					Expect(buffer.String()).To(ContainSubstring("Restoring vendor_bundle for Gemfile_next from cache"))
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle", "next")).To(BeADirectory())
+					Expect(filepath.Join(cacheDir, "vendor_bundle.Gemfile_next")).ToNot(BeADirectory())
+					Expect(filepath.Join(cacheDir, "vendor_bundle", "adir", "bdir")).To(BeADirectory())
+				})
+
+				It("drops every cached vendor_bundle when bundler changed", func() {
+					Expect(os.MkdirAll(filepath.Join(cacheDir, "vendor_bundle.Gemfile_next"), 0755)).To(Succeed())
+
+					Expect(c.Restore("2.0.1", tools)).To(Succeed())
+
+					Expect(filepath.Join(cacheDir, "vendor_bundle")).ToNot(BeADirectory())
+					Expect(filepath.Join(cacheDir, "vendor_bundle.Gemfile_next")).ToNot(BeADirectory())
+				})
+			})
+
+			Context("BUNDLE_GEMFILE is set to the default", func() {
+				BeforeEach(func() {
+					os.Setenv("BUNDLE_GEMFILE", "Gemfile")
+				})
+				AfterEach(func() {
+					os.Unsetenv("BUNDLE_GEMFILE")
+				})
+
+				It("restores vendor_bundle directory", func() {
+					Expect(c.Restore("1.16.3", tools)).To(Succeed())
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle", "adir", "bdir")).To(BeADirectory())
+				})
+			})
+
+			Context("the cache predates toolchain stamping", func() {
+				BeforeEach(func() {
+					metadataTools = toolchain.Versions{}
+				})
+
+				It("restores vendor_bundle directory", func() {
+					Expect(c.Restore("1.16.3", tools)).To(Succeed())
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle", "adir", "bdir")).To(BeADirectory())
+				})
+			})
+
+			Context("the bundler minor version changed", func() {
+				It("restores vendor_bundle directory", func() {
+					Expect(c.Restore("1.17.3", tools)).To(Succeed())
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle", "adir", "bdir")).To(BeADirectory())
+				})
+			})
+
+			Context("the cache predates bundler version stamping", func() {
+				BeforeEach(func() {
+					metadataVersion = 0
+					metadataBundler = ""
+				})
+
+				It("treats the cache as created by bundler 1", func() {
+					Expect(c.Restore("1.16.3", tools)).To(Succeed())
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle", "adir", "bdir")).To(BeADirectory())
+				})
+
+				It("does not restore vendor_bundle for bundler 2", func() {
+					Expect(c.Restore("2.0.1", tools)).To(Succeed())
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle")).ToNot(BeADirectory())
+				})
+
+				It("migrates the cache without integrity stamps and stamps it", func() {
+					Expect(os.MkdirAll(filepath.Join(cacheDir, "vendor_bundle", "ruby", "2.5.0", "cache", "bundler", "git", "rack-4567"), 0755)).To(Succeed())
+					Expect(os.RemoveAll(filepath.Join(cacheDir, "bundler_git"))).To(Succeed())
+					Expect(c.Restore("1.16.3", tools)).To(Succeed())
+					Expect(buffer.String()).ToNot(ContainSubstring("Discarding"))
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle", "adir", "rack.rb")).To(BeAnExistingFile())
+					Expect(filepath.Join(depsDir, depsIdx, "node_modules", "left-pad")).To(BeADirectory())
+
+					Expect(cache.MigrateDepDir(depsdir.Dir(filepath.Join(depsDir, depsIdx)), c.Metadata(), logger)).To(Succeed())
+					Expect(filepath.Join(depsDir, depsIdx, "bundler_git", "rack-4567")).To(BeADirectory())
+
+					mockYaml.EXPECT().Write(filepath.Join(cacheDir, "metadata.yml"), gomock.Any()).Do(func(_ string, val interface{}) {
+						metadata := val.(cache.Metadata)
+						Expect(metadata.Version).To(Equal(cache.Version))
+						Expect(metadata.Integrity).To(HaveKey("vendor_bundle"))
+						Expect(metadata.Integrity).To(HaveKey("bundler_git"))
+					}).Return(nil)
+					Expect(c.Save()).To(Succeed())
+				})
+			})
+
+			Context("a cached file was modified after it was saved", func() {
+				It("discards vendor_bundle with a warning", func() {
+					Expect(ioutil.WriteFile(filepath.Join(cacheDir, "vendor_bundle", "adir", "rack.rb"), []byte("system('curl evil')"), 0644)).To(Succeed())
+					Expect(c.Restore("1.16.3", tools)).To(Succeed())
+
+					Expect(buffer.String()).To(ContainSubstring("Discarding vendor_bundle from cache, its contents do not match the integrity stamp for this app"))
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle")).ToNot(BeADirectory())
+					Expect(filepath.Join(cacheDir, "vendor_bundle")).ToNot(BeADirectory())
+					Expect(filepath.Join(depsDir, depsIdx, "node_modules", "left-pad")).To(BeADirectory())
+				})
+			})
+
+			Context("the cache was stamped by another app", func() {
+				BeforeEach(func() {
+					stampedBy = "other-app-guid"
+				})
+
+				It("discards every cached directory", func() {
+					Expect(c.Restore("1.16.3", tools)).To(Succeed())
+
+					Expect(buffer.String()).To(ContainSubstring("Discarding vendor_bundle from cache"))
+					Expect(buffer.String()).To(ContainSubstring("Discarding node_modules from cache"))
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle")).ToNot(BeADirectory())
+					Expect(filepath.Join(depsDir, depsIdx, "node_modules")).ToNot(BeADirectory())
+					Expect(filepath.Join(cacheDir, "node_modules")).ToNot(BeADirectory())
+				})
+			})
+
+			Context("a cached directory has no integrity stamp", func() {
+				BeforeEach(func() {
+					metadataVersion = cache.Version
+				})
+
+				JustBeforeEach(func() {
+					delete(c.Metadata().Integrity, "vendor_bundle")
+				})
+
+				It("discards it", func() {
+					Expect(c.Restore("1.16.3", tools)).To(Succeed())
+
+					Expect(buffer.String()).To(ContainSubstring("Discarding vendor_bundle from cache, it has no integrity stamp"))
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle")).ToNot(BeADirectory())
+				})
+			})
+
+			Context("the cache has a newer version", func() {
+				BeforeEach(func() {
+					metadataVersion = cache.Version + 1
+				})
+
+				It("does not restore anything", func() {
+					Expect(c.Restore("1.16.3", tools)).To(Succeed())
+
+					Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("it has version %d, newer than the %d this buildpack reads", cache.Version+1, cache.Version)))
+					Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle")).ToNot(BeADirectory())
+					Expect(filepath.Join(depsDir, depsIdx, "node_modules")).ToNot(BeADirectory())
+					Expect(filepath.Join(cacheDir, "vendor_bundle")).ToNot(BeADirectory())
+				})
+			})
 		})
 
 		Context("stack differs", func() {
@@ -151,11 +619,84 @@ var _ = Describe("Cache", func() {
 				os.Setenv("CF_STACK", "cflinuxfs9")
 			})
 			It("does not restore vendor_bundle directory", func() {
-				Expect(c.Restore()).To(Succeed())
+				Expect(c.Restore("1.16.3", tools)).To(Succeed())
 
 				Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle")).ToNot(BeADirectory())
 				Expect(filepath.Join(cacheDir, "vendor_bundle")).ToNot(BeADirectory())
 			})
+
+			It("restores the git clones", func() {
+				Expect(c.Restore("1.16.3", tools)).To(Succeed())
+
+				Expect(buffer.String()).To(ContainSubstring("Skipping restoring vendor_bundle from cache, stack changed from cflinuxfs8 to cflinuxfs9"))
+				Expect(filepath.Join(depsDir, depsIdx, "node_modules")).ToNot(BeADirectory())
+				Expect(filepath.Join(depsDir, depsIdx, "bundler_git", "rails-0123")).To(BeADirectory())
+			})
+		})
+
+		Context("each directory has its own key", func() {
+			BeforeEach(func() {
+				os.Setenv("CF_STACK", "cflinuxfs8")
+				metadataVersion = cache.Version
+				Expect(os.MkdirAll(filepath.Join(cacheDir, "ruby", "bin"), 0755)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(cacheDir, "yarn_cache", "v6"), 0755)).To(Succeed())
+			})
+
+			JustBeforeEach(func() {
+				c.Metadata().Keys = map[string]cache.Key{
+					"ruby":          {"stack": "cflinuxfs8", "ruby": "3.2.1"},
+					"vendor_bundle": {"stack": "cflinuxfs8", "bundler major version": "2", "gcc": "7.5.0"},
+					"bundler_git":   {},
+					"yarn_cache":    {},
+				}
+				c.Metadata().Integrity["ruby"] = digest(filepath.Join(cacheDir, "ruby"), stampedBy)
+				c.Metadata().Integrity["yarn_cache"] = digest(filepath.Join(cacheDir, "yarn_cache"), stampedBy)
+			})
+
+			It("restores each directory whose key matches", func() {
+				Expect(c.Restore("2.4.10", tools)).To(Succeed())
+				Expect(filepath.Join(depsDir, depsIdx, "yarn_cache")).ToNot(BeADirectory())
+				Expect(c.RestoreAssets()).To(Succeed())
+
+				Expect(filepath.Join(depsDir, depsIdx, "ruby", "bin")).To(BeADirectory())
+				Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle", "adir")).To(BeADirectory())
+				Expect(filepath.Join(depsDir, depsIdx, "yarn_cache", "v6")).To(BeADirectory())
+				Expect(c.Stamped("ruby", "ruby")).To(Equal("3.2.1"))
+			})
+
+			It("restores the others when the key of one changed", func() {
+				Expect(c.Restore("1.17.3", tools)).To(Succeed())
+				Expect(c.RestoreAssets()).To(Succeed())
+
+				Expect(buffer.String()).To(ContainSubstring("Skipping restoring vendor_bundle from cache, bundler major version changed from 2 to 1"))
+				Expect(filepath.Join(depsDir, depsIdx, "vendor_bundle")).ToNot(BeADirectory())
+				Expect(filepath.Join(depsDir, depsIdx, "ruby", "bin")).To(BeADirectory())
+				Expect(filepath.Join(depsDir, depsIdx, "yarn_cache", "v6")).To(BeADirectory())
+			})
+
+			Context("the stack changed", func() {
+				BeforeEach(func() {
+					os.Setenv("CF_STACK", "cflinuxfs9")
+				})
+
+				It("keeps the yarn cache and the git clones", func() {
+					Expect(c.Restore("2.4.10", tools)).To(Succeed())
+					Expect(c.RestoreAssets()).To(Succeed())
+
+					Expect(buffer.String()).To(ContainSubstring("Skipping restoring ruby from cache, stack changed from cflinuxfs8 to cflinuxfs9"))
+					Expect(filepath.Join(depsDir, depsIdx, "ruby")).ToNot(BeADirectory())
+					Expect(filepath.Join(cacheDir, "ruby")).ToNot(BeADirectory())
+					Expect(filepath.Join(depsDir, depsIdx, "yarn_cache", "v6")).To(BeADirectory())
+					Expect(filepath.Join(depsDir, depsIdx, "bundler_git", "rails-0123")).To(BeADirectory())
+					Expect(c.Stamped("ruby", "ruby")).To(Equal(""))
+				})
+			})
 		})
 	})
 })
+
+func digest(dir, appGUID string) string {
+	digest, err := cache.Digest(dir, appGUID)
+	Expect(err).ToNot(HaveOccurred())
+	return digest
+}
@@ -0,0 +1,214 @@
+package cache
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"ruby/depsdir"
+	"strings"
+)
+
+const (
+	// Off keeps a hardlinked copy of the directory, the platform compresses
+	// the whole cache dir when it uploads it
+	Off = "off"
+	// Gzip stores the directory as a gzipped tarball, at the fastest level
+	Gzip = "gzip"
+	// Auto picks the compression of each directory from componentCompression
+	Auto = "auto"
+)
+
+// ArchiveExt is appended to the cached name of a compressed directory
+const ArchiveExt = ".tgz"
+
+// componentCompression is what Auto uses for each cached directory. Gems,
+// node packages and interpreters compress well, the pack files of git
+// clones, and the images and .gz variants of compiled assets, are
+// compressed already.
+var componentCompression = map[string]string{
+	depsdir.Ruby:           Gzip,
+	depsdir.Bundler:        Gzip,
+	depsdir.VendorBundle:   Gzip,
+	depsdir.NodeModules:    Gzip,
+	depsdir.BundlerGit:     Off,
+	depsdir.BundlerPlugin:  Gzip,
+	depsdir.Node:           Gzip,
+	depsdir.Yarn:           Gzip,
+	depsdir.YarnCache:      Gzip,
+	depsdir.AssetsCache:    Gzip,
+	depsdir.CompiledAssets: Off,
+}
+
+// SetCompression selects how Save stores the cached directories: off,
+// gzip or auto. The default is off.
+func (c *Cache) SetCompression(setting string) error {
+	switch setting {
+	case "", Off, Gzip, Auto:
+		c.compression = setting
+		return nil
+	case "zstd":
+		return fmt.Errorf("zstd cache compression is not available in this buildpack, use gzip or auto")
+	default:
+		return fmt.Errorf("unknown cache compression %q, use off, gzip or auto", setting)
+	}
+}
+
+func (c *Cache) compressionFor(name string) string {
+	switch c.compression {
+	case Gzip:
+		return Gzip
+	case Auto:
+		if compression, found := componentCompression[name]; found {
+			return compression
+		}
+	}
+	return Off
+}
+
+// writeArchive stores dir as a gzipped tarball at archive, returning the
+// size of the files and of the archive
+func writeArchive(dir, archive string) (int64, int64, error) {
+	file, err := os.Create(archive)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	buffered := bufio.NewWriterSize(file, 1024*1024)
+	gz, err := gzip.NewWriterLevel(buffered, gzip.BestSpeed)
+	if err != nil {
+		return 0, 0, err
+	}
+	tw := tar.NewWriter(gz)
+
+	var size int64
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		n, err := io.Copy(tw, src)
+		size += n
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, closer := range []interface {
+		Close() error
+	}{tw, gz} {
+		if err := closer.Close(); err != nil {
+			return 0, 0, err
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return 0, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	return size, info.Size(), file.Close()
+}
+
+// extractArchive extracts an archive written by writeArchive into dir
+func extractArchive(archive, dir string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(bufio.NewReaderSize(file, 1024*1024))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s is outside of the archive", hdr.Name)
+		}
+		path := filepath.Join(dir, name)
+		info := hdr.FileInfo()
+		switch {
+		case info.IsDir():
+			err = os.MkdirAll(path, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			err = os.Symlink(hdr.Linkname, path)
+		case info.Mode().IsRegular():
+			err = writeFile(tr, path, info.Mode())
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && info.Mode()&os.ModeSymlink == 0 {
+			if err := os.Chtimes(path, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeFile(src io.Reader, path string, mode os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, src); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func megabytes(bytes int64) string {
+	return fmt.Sprintf("%.1fMB", float64(bytes)/(1024*1024))
+}
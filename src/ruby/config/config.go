@@ -0,0 +1,255 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Path is where apps keep their buildpack configuration, relative to the app
+const Path = "config/ruby-buildpack.yml"
+
+// Config is app level buildpack configuration. Environment variables take
+// precedence over the file, and the Gemfile over ruby.version.
+type Config struct {
+	Ruby      Ruby      `yaml:"ruby"`
+	RakeTasks []string  `yaml:"rake_tasks"`
+	Assets    Assets    `yaml:"assets"`
+	Prune     []string  `yaml:"prune"`
+	Contracts Contracts `yaml:"contracts"`
+	Logging   Logging   `yaml:"logging"`
+	// Concurrency tunes the WEB_CONCURRENCY and RAILS_MAX_THREADS defaults
+	Concurrency Concurrency `yaml:"concurrency"`
+	// Sideloads are binaries, such as wkhtmltopdf or ffmpeg, which are not
+	// in the buildpack's manifest
+	Sideloads []Sideload `yaml:"sideloads"`
+	// Profiles are named bundler install setups, BP_PROFILE picks one
+	Profiles map[string]Profile `yaml:"profiles"`
+
+	Profile *Profile `yaml:"-"`
+}
+
+type Profile struct {
+	Name           string   `yaml:"-"`
+	Without        []string `yaml:"without"`
+	With           []string `yaml:"with"`
+	SkipPrecompile bool     `yaml:"skip_precompile"`
+}
+
+// Contracts are API contracts, pacts or OpenAPI documents, which rake tasks
+// regenerate on every deploy and which are then published to a broker
+type Contracts struct {
+	Tasks     []string `yaml:"tasks"`
+	Artifacts []string `yaml:"artifacts"`
+	BrokerURL string   `yaml:"broker_url"`
+	// Service is the bound service whose credentials authenticate to the broker
+	Service string `yaml:"service"`
+	// Version is the consumer version, defaults to the app's version
+	Version string `yaml:"version"`
+}
+
+type Ruby struct {
+	// Version is used when the Gemfile does not declare a ruby version
+	Version string `yaml:"version"`
+}
+
+type Assets struct {
+	SkipPrecompile  bool `yaml:"skip_precompile"`
+	KeepNodeModules bool `yaml:"keep_node_modules"`
+	// Compress writes .gz and .br variants of the compiled assets
+	Compress bool `yaml:"compress"`
+	// PruneGems removes the gems only in the assets group once they are
+	// compiled
+	PruneGems bool `yaml:"prune_gems"`
+}
+
+type Logging struct {
+	// CFDefaults logs to stdout and tags request log lines with the request
+	// id and the CF instance index
+	CFDefaults bool `yaml:"cf_defaults"`
+}
+
+// Concurrency tunes how the WEB_CONCURRENCY and RAILS_MAX_THREADS defaults
+// are computed when an instance starts, zero values keep the defaults
+type Concurrency struct {
+	// MemoryPerWorker is the megabytes of MEMORY_LIMIT each worker gets
+	MemoryPerWorker int `yaml:"memory_per_worker"`
+	// WorkersPerCPU caps the workers by the CPUs of the instance
+	WorkersPerCPU int `yaml:"workers_per_cpu"`
+	// MaxWorkers caps the workers however big the instance is
+	MaxWorkers int `yaml:"max_workers"`
+	// Threads is the RAILS_MAX_THREADS default
+	Threads int `yaml:"threads"`
+}
+
+// Sideload is an archive supply installs into the deps dir, its bin and lib
+// directories are added to PATH and LD_LIBRARY_PATH
+type Sideload struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	URI     string `yaml:"uri"`
+	SHA256  string `yaml:"sha256"`
+	// Root is the directory in the archive holding bin and lib, for archives
+	// with a top level directory
+	Root string `yaml:"root"`
+}
+
+var (
+	sideloadName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+	sha256Hex    = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+var versionConstraint = regexp.MustCompile(`^\d+(\.(\d+|x))*$`)
+
+// Load reads the config file from the app, an app without one gets the
+// zero Config
+func Load(buildDir string) (*Config, error) {
+	c := &Config{}
+
+	data, err := ioutil.ReadFile(filepath.Join(buildDir, Path))
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("%s is invalid: %v", Path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s is invalid: %v", Path, err)
+	}
+	return c, nil
+}
+
+// SelectProfile applies the named profile, an empty name selects nothing
+func (c *Config) SelectProfile(name string) error {
+	if name == "" {
+		return nil
+	}
+
+	profile, found := c.Profiles[name]
+	if !found {
+		var names []string
+		for name := range c.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("profile %s is not defined in %s, defined profiles: [%s]", name, Path, strings.Join(names, ", "))
+	}
+
+	profile.Name = name
+	c.Profile = &profile
+	if profile.SkipPrecompile {
+		c.Assets.SkipPrecompile = true
+	}
+	return nil
+}
+
+// WithoutGroup reports whether the selected profile leaves out a bundler group
+func (c *Config) WithoutGroup(group string) bool {
+	if c.Profile == nil {
+		return false
+	}
+	for _, without := range c.Profile.Without {
+		if without == group {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.Ruby.Version != "" && !versionConstraint.MatchString(c.Ruby.Version) {
+		problems = append(problems, fmt.Sprintf("ruby.version must look like 2.5.1 or 2.5.x, got %s", c.Ruby.Version))
+	}
+	for _, task := range c.RakeTasks {
+		if strings.TrimSpace(task) == "" {
+			problems = append(problems, "rake_tasks can not contain empty task names")
+		}
+	}
+	for _, glob := range c.Prune {
+		if filepath.IsAbs(glob) || strings.HasPrefix(filepath.Clean(glob), "..") {
+			problems = append(problems, fmt.Sprintf("prune must contain paths inside the app, got %s", glob))
+		} else if _, err := filepath.Match(glob, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("prune contains an invalid pattern %s", glob))
+		}
+	}
+
+	if len(c.Contracts.Tasks) > 0 {
+		if u, err := url.Parse(c.Contracts.BrokerURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("contracts.broker_url must be an http or https URL, got '%s'", c.Contracts.BrokerURL))
+		}
+		if len(c.Contracts.Artifacts) == 0 {
+			problems = append(problems, "contracts.artifacts must list the files the contract tasks generate")
+		}
+	}
+	for _, task := range c.Contracts.Tasks {
+		if strings.TrimSpace(task) == "" {
+			problems = append(problems, "contracts.tasks can not contain empty task names")
+		}
+	}
+	for _, glob := range c.Contracts.Artifacts {
+		if filepath.IsAbs(glob) || strings.HasPrefix(filepath.Clean(glob), "..") {
+			problems = append(problems, fmt.Sprintf("contracts.artifacts must contain paths inside the app, got %s", glob))
+		} else if _, err := filepath.Match(glob, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("contracts.artifacts contains an invalid pattern %s", glob))
+		}
+	}
+
+	for _, setting := range []struct {
+		name  string
+		value int
+	}{
+		{"memory_per_worker", c.Concurrency.MemoryPerWorker},
+		{"workers_per_cpu", c.Concurrency.WorkersPerCPU},
+		{"max_workers", c.Concurrency.MaxWorkers},
+		{"threads", c.Concurrency.Threads},
+	} {
+		if setting.value < 0 {
+			problems = append(problems, fmt.Sprintf("concurrency.%s can not be negative, got %d", setting.name, setting.value))
+		}
+	}
+
+	sideloads := map[string]bool{}
+	for i, sideload := range c.Sideloads {
+		if !sideloadName.MatchString(sideload.Name) {
+			problems = append(problems, fmt.Sprintf("sideloads[%d].name must be lower case letters, digits, '.', '_' or '-', got '%s'", i, sideload.Name))
+		} else if sideloads[sideload.Name] {
+			problems = append(problems, fmt.Sprintf("sideloads contains %s more than once", sideload.Name))
+		}
+		sideloads[sideload.Name] = true
+		if u, err := url.Parse(sideload.URI); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("sideloads[%d].uri must be an http or https URL, got '%s'", i, sideload.URI))
+		}
+		if !sha256Hex.MatchString(sideload.SHA256) {
+			problems = append(problems, fmt.Sprintf("sideloads[%d].sha256 must be 64 lower case hex digits", i))
+		}
+		if filepath.IsAbs(sideload.Root) || strings.HasPrefix(filepath.Clean(sideload.Root), "..") {
+			problems = append(problems, fmt.Sprintf("sideloads[%d].root must be a path inside the archive, got %s", i, sideload.Root))
+		}
+	}
+
+	for name, profile := range c.Profiles {
+		for _, group := range append(append([]string{}, profile.Without...), profile.With...) {
+			if group == "" || strings.ContainsAny(group, ": ") {
+				problems = append(problems, fmt.Sprintf("profiles.%s contains an invalid group name '%s'", name, group))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
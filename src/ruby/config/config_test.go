@@ -0,0 +1,171 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var (
+		err      error
+		buildDir string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(buildDir, "config"), 0755)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	writeConfig := func(contents string) {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "config", "ruby-buildpack.yml"), []byte(contents), 0644)).To(Succeed())
+	}
+
+	Describe("Load", func() {
+		It("returns an empty config when there is no file", func() {
+			Expect(config.Load(buildDir)).To(Equal(&config.Config{}))
+		})
+
+		It("parses every section", func() {
+			writeConfig(`---
+ruby:
+  version: 2.5.x
+rake_tasks:
+- sitemap:refresh
+assets:
+  skip_precompile: true
+  keep_node_modules: true
+prune:
+- spec
+- "*.md"
+logging:
+  cf_defaults: true
+sideloads:
+- name: wkhtmltopdf
+  version: 0.12.5
+  uri: https://example.com/wkhtmltox-0.12.5.tar.gz
+  sha256: 0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef
+  root: wkhtmltox
+`)
+			Expect(config.Load(buildDir)).To(Equal(&config.Config{
+				Ruby:      config.Ruby{Version: "2.5.x"},
+				RakeTasks: []string{"sitemap:refresh"},
+				Assets:    config.Assets{SkipPrecompile: true, KeepNodeModules: true},
+				Prune:     []string{"spec", "*.md"},
+				Logging:   config.Logging{CFDefaults: true},
+				Sideloads: []config.Sideload{{
+					Name:    "wkhtmltopdf",
+					Version: "0.12.5",
+					URI:     "https://example.com/wkhtmltox-0.12.5.tar.gz",
+					SHA256:  "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+					Root:    "wkhtmltox",
+				}},
+			}))
+		})
+
+		It("rejects unknown keys", func() {
+			writeConfig("assets:\n  precompile_gzip: true\n")
+			_, err := config.Load(buildDir)
+			Expect(err).To(MatchError(ContainSubstring("config/ruby-buildpack.yml is invalid")))
+			Expect(err).To(MatchError(ContainSubstring("precompile_gzip")))
+		})
+
+		It("rejects values of the wrong type", func() {
+			writeConfig("rake_tasks: sitemap:refresh\n")
+			_, err := config.Load(buildDir)
+			Expect(err).To(MatchError(ContainSubstring("config/ruby-buildpack.yml is invalid")))
+		})
+	})
+
+	Describe("SelectProfile", func() {
+		var c *config.Config
+
+		BeforeEach(func() {
+			c = &config.Config{Profiles: map[string]config.Profile{
+				"worker": {Without: []string{"development", "test", "assets"}, SkipPrecompile: true},
+				"api":    {With: []string{"api"}},
+			}}
+		})
+
+		It("selects nothing without a name", func() {
+			Expect(c.SelectProfile("")).To(Succeed())
+			Expect(c.Profile).To(BeNil())
+			Expect(c.WithoutGroup("assets")).To(BeFalse())
+		})
+
+		It("applies the named profile", func() {
+			Expect(c.SelectProfile("worker")).To(Succeed())
+			Expect(c.Profile.Name).To(Equal("worker"))
+			Expect(c.Assets.SkipPrecompile).To(BeTrue())
+			Expect(c.WithoutGroup("assets")).To(BeTrue())
+			Expect(c.WithoutGroup("api")).To(BeFalse())
+		})
+
+		It("lists the defined profiles for an unknown name", func() {
+			Expect(c.SelectProfile("web")).To(MatchError("profile web is not defined in config/ruby-buildpack.yml, defined profiles: [api, worker]"))
+		})
+	})
+
+	Describe("Validate", func() {
+		It("accepts the zero config", func() {
+			Expect((&config.Config{}).Validate()).To(Succeed())
+		})
+
+		It("rejects invalid profile group names", func() {
+			c := &config.Config{Profiles: map[string]config.Profile{"worker": {Without: []string{"development:test"}}}}
+			Expect(c.Validate()).To(MatchError("profiles.worker contains an invalid group name 'development:test'"))
+		})
+
+		It("reports every problem", func() {
+			c := &config.Config{
+				Ruby:      config.Ruby{Version: "~> 2.5"},
+				RakeTasks: []string{" "},
+				Prune:     []string{"/etc", "../other", "[a-"},
+			}
+			Expect(c.Validate()).To(MatchError("ruby.version must look like 2.5.1 or 2.5.x, got ~> 2.5; " +
+				"rake_tasks can not contain empty task names; " +
+				"prune must contain paths inside the app, got /etc; " +
+				"prune must contain paths inside the app, got ../other; " +
+				"prune contains an invalid pattern [a-"))
+		})
+
+		It("requires a broker and artifacts for contract tasks", func() {
+			c := &config.Config{Contracts: config.Contracts{Tasks: []string{"pact:generate"}, BrokerURL: "broker.example.com"}}
+			Expect(c.Validate()).To(MatchError("contracts.broker_url must be an http or https URL, got 'broker.example.com'; " +
+				"contracts.artifacts must list the files the contract tasks generate"))
+
+			c.Contracts.BrokerURL = "https://broker.example.com"
+			c.Contracts.Artifacts = []string{"spec/pacts/*.json", "../pacts"}
+			Expect(c.Validate()).To(MatchError("contracts.artifacts must contain paths inside the app, got ../pacts"))
+		})
+
+		It("rejects negative concurrency settings", func() {
+			c := &config.Config{Concurrency: config.Concurrency{MemoryPerWorker: -512, Threads: -1}}
+			Expect(c.Validate()).To(MatchError("concurrency.memory_per_worker can not be negative, got -512; " +
+				"concurrency.threads can not be negative, got -1"))
+		})
+
+		It("checks every sideload", func() {
+			sha := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+			c := &config.Config{Sideloads: []config.Sideload{
+				{Name: "ffmpeg", URI: "https://example.com/ffmpeg.tgz", SHA256: sha},
+				{Name: "ffmpeg", URI: "ftp://example.com/ffmpeg.tgz", SHA256: "abc"},
+				{Name: "Wkhtml To PDF", URI: "https://example.com/wkhtmltox.tgz", SHA256: sha, Root: "../opt"},
+			}}
+			Expect(c.Validate()).To(MatchError("sideloads contains ffmpeg more than once; " +
+				"sideloads[1].uri must be an http or https URL, got 'ftp://example.com/ffmpeg.tgz'; " +
+				"sideloads[1].sha256 must be 64 lower case hex digits; " +
+				"sideloads[2].name must be lower case letters, digits, '.', '_' or '-', got 'Wkhtml To PDF'; " +
+				"sideloads[2].root must be a path inside the archive, got ../opt"))
+		})
+	})
+})
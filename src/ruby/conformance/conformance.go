@@ -0,0 +1,4 @@
+// Package conformance holds tests comparing the buildpack's behaviour with
+// the Heroku ruby buildpack, so apps migrating from Heroku stage the way
+// their owners expect. Intentional divergences are asserted, not skipped.
+package conformance
@@ -0,0 +1,198 @@
+package conformance_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"ruby/cache"
+	"ruby/featureflags"
+	"ruby/finalize"
+	"ruby/supply"
+	"ruby/toolchain"
+	"strconv"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeVersions answers gem queries from a map of gem name to major version
+type fakeVersions struct {
+	gemfile string
+	gems    map[string]int
+}
+
+var minimumMajor = regexp.MustCompile(`^>=\s*(\d+)`)
+
+func (v *fakeVersions) HasGem(name string) (bool, error) {
+	_, found := v.gems[name]
+	return found, nil
+}
+
+func (v *fakeVersions) GemMajorVersion(name string) (int, error) {
+	if major, found := v.gems[name]; found {
+		return major, nil
+	}
+	return -1, nil
+}
+
+func (v *fakeVersions) HasGemVersion(name string, constraints ...string) (bool, error) {
+	major, found := v.gems[name]
+	if !found {
+		return false, nil
+	}
+	for _, constraint := range constraints {
+		if m := minimumMajor.FindStringSubmatch(constraint); m != nil {
+			if minimum, _ := strconv.Atoi(m[1]); major < minimum {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+func (v *fakeVersions) Engine() (string, error)            { return "ruby", nil }
+func (v *fakeVersions) Version() (string, error)           { return "2.5.1", nil }
+func (v *fakeVersions) JrubyVersion() (string, error)      { return "", nil }
+func (v *fakeVersions) RubyEngineVersion() (string, error) { return "2.5.0", nil }
+func (v *fakeVersions) RubyPlatform() (string, error)      { return "x86_64-linux", nil }
+func (v *fakeVersions) HasWindowsGemfileLock() (bool, error) {
+	return false, nil
+}
+func (v *fakeVersions) VersionConstraint(string, ...string) (bool, error) { return true, nil }
+func (v *fakeVersions) Gemfile() string                                   { return v.gemfile }
+func (v *fakeVersions) GemGroups() (map[string][]string, error)           { return nil, nil }
+
+type fakeCache struct{ metadata cache.Metadata }
+
+func (c *fakeCache) Metadata() *cache.Metadata                { return &c.metadata }
+func (c *fakeCache) Restore(string, toolchain.Versions) error { return nil }
+func (c *fakeCache) Save() error                              { return nil }
+func (c *fakeCache) Stamp(string, string, string)             {}
+func (c *fakeCache) Stamped(string, string) string            { return "" }
+
+type fakeCommand struct{}
+
+func (c *fakeCommand) Execute(string, io.Writer, io.Writer, string, ...string) error { return nil }
+func (c *fakeCommand) Output(string, string, ...string) (string, error)              { return "secret", nil }
+func (c *fakeCommand) Run(*exec.Cmd) error                                           { return nil }
+
+var _ = Describe("Heroku ruby buildpack conformance", func() {
+	var (
+		err      error
+		buildDir string
+		depsDir  string
+		buffer   *bytes.Buffer
+		logger   *libbuildpack.Logger
+		stager   *libbuildpack.Stager
+		versions *fakeVersions
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		bpDir, err := cutlass.FindRoot()
+		Expect(err).To(BeNil())
+		Expect(libbuildpack.CopyDirectory(filepath.Join(bpDir, "fixtures", "heroku_conventions"), buildDir)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger = libbuildpack.NewLogger(ansicleaner.New(buffer))
+		stager = libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{})
+		versions = &fakeVersions{gemfile: filepath.Join(buildDir, "Gemfile"), gems: map[string]int{"rack": 1, "sinatra": 1}}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	newFinalizer := func(environ ...string) *finalize.Finalizer {
+		return &finalize.Finalizer{
+			Stager:       stager,
+			Versions:     versions,
+			Log:          logger,
+			Command:      &fakeCommand{},
+			Flags:        featureflags.New(environ),
+			RailsVersion: versions.gems["rails"],
+		}
+	}
+
+	Describe("default process types", func() {
+		It("runs rack apps with rackup on $PORT", func() {
+			release, err := newFinalizer().GenerateReleaseYaml()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(release["default_process_types"]["web"]).To(Equal("bundle exec rackup config.ru -p $PORT"))
+		})
+
+		It("runs Rails 4+ apps with bin/rails server", func() {
+			versions.gems["rails"] = 5
+			release, err := newFinalizer().GenerateReleaseYaml()
+			Expect(err).ToNot(HaveOccurred())
+			// Divergence: Heroku relies on Rails' default binding, Cloud Foundry
+			// routes to the container address so the server binds 0.0.0.0
+			Expect(release["default_process_types"]["web"]).To(Equal("bin/rails server -b 0.0.0.0 -p $PORT -e $RAILS_ENV"))
+			Expect(release["default_process_types"]["console"]).To(Equal("bin/rails console"))
+		})
+	})
+
+	Describe("bin/rails binstub", func() {
+		It("keeps the binstub committed with the app", func() {
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0", "binstubs"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(depsDir, "0", "binstubs", "rails"), []byte("bundler binstub"), 0755)).To(Succeed())
+
+			Expect(newFinalizer().CopyToAppBin()).To(Succeed())
+			Expect(ioutil.ReadFile(filepath.Join(buildDir, "bin", "rails"))).To(ContainSubstring("App provided binstub"))
+		})
+	})
+
+	Describe("runtime environment", func() {
+		It("logs to stdout and serves static files like Heroku's rails_12factor defaults", func() {
+			supplier := &supply.Supplier{
+				Stager:   stager,
+				Log:      logger,
+				Versions: versions,
+				Cache:    &fakeCache{},
+				Command:  &fakeCommand{},
+				Flags:    featureflags.New([]string{}),
+			}
+			Expect(supplier.Setup()).To(Succeed())
+			Expect(supplier.WriteProfileD("ruby")).To(Succeed())
+
+			profile, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "profile.d", "ruby.sh"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(profile)).To(ContainSubstring("export RAILS_LOG_TO_STDOUT=${RAILS_LOG_TO_STDOUT:-enabled}"))
+			Expect(string(profile)).To(ContainSubstring("export RAILS_SERVE_STATIC_FILES=${RAILS_SERVE_STATIC_FILES:-enabled}"))
+			Expect(string(profile)).To(ContainSubstring("export RACK_ENV=${RACK_ENV:-production}"))
+		})
+	})
+
+	Describe("Procfile release phase", func() {
+		It("does not run the release process, Cloud Foundry has no release phase", func() {
+			// Divergence: run release tasks with a cf run-task or BP_REVIEW_APP
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("release: exit 1\nweb: sleep 5\n"), 0644)).To(Succeed())
+
+			finalizer := newFinalizer("BP_BOOT_CHECK=true", "BP_BOOT_CHECK_TIMEOUT=1")
+			Expect(finalizer.BootCheck("")).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Running: sleep 5"))
+		})
+	})
+
+	Describe(".slugignore", func() {
+		It("leaves listed paths in the droplet, Cloud Foundry uses .cfignore at push time", func() {
+			Expect(newFinalizer().DeleteVendorBundle()).To(Succeed())
+			Expect(newFinalizer().CopyToAppBin()).To(Succeed())
+			Expect(filepath.Join(buildDir, ".slugignore")).To(BeAnExistingFile())
+			Expect(filepath.Join(buildDir, "spec", "app_spec.rb")).To(BeAnExistingFile())
+		})
+	})
+})
@@ -0,0 +1,99 @@
+// Package deprecations warns about the configuration and behaviors which
+// will be removed from the buildpack. Every deprecation is registered in
+// Deprecations with the version it is removed in, so users learn what to
+// migrate from one warning format and the staging report, rather than from
+// release notes.
+package deprecations
+
+import (
+	"fmt"
+	"io"
+	"ruby/report"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+type Deprecation struct {
+	ID          string
+	Description string
+	Replacement string
+	RemovedIn   string
+}
+
+// Deprecations lists every behavior slated for removal
+var Deprecations = []Deprecation{
+	{
+		ID:          "rails2-start-command",
+		Description: "Rails 2 apps without a Procfile start with bundle exec ruby script/server",
+		Replacement: "Add a Procfile with a web process",
+		RemovedIn:   "2.0.0",
+	},
+	{
+		ID:          "rails3-start-command",
+		Description: "Rails 3 apps without a Procfile start with bundle exec rails server",
+		Replacement: "Add a Procfile with a web process",
+		RemovedIn:   "2.0.0",
+	},
+	{
+		ID:          "bp-debug-any-value",
+		Description: "BP_DEBUG turns on debug output when set to any value, including false",
+		Replacement: "Set BP_DEBUG=true to turn on debug output and unset it otherwise",
+		RemovedIn:   "2.0.0",
+	},
+}
+
+// Tracker warns once about each deprecation the app relies on and records
+// them in the staging report. A nil Tracker ignores them.
+type Tracker struct {
+	log    *libbuildpack.Logger
+	depDir string
+	warned map[string]bool
+}
+
+func New(log *libbuildpack.Logger, depDir string) *Tracker {
+	return &Tracker{log: log, depDir: depDir, warned: map[string]bool{}}
+}
+
+// Use warns that the app relies on the deprecation id
+func (t *Tracker) Use(id string) error {
+	if t == nil || t.warned[id] {
+		return nil
+	}
+	deprecation, found := lookup(id)
+	if !found {
+		panic(fmt.Sprintf("deprecations: %s is not a registered deprecation", id))
+	}
+	t.warned[id] = true
+
+	t.log.Warning("Deprecated [%s]: %s, this is removed in buildpack %s.\n%s.", deprecation.ID, deprecation.Description, deprecation.RemovedIn, deprecation.Replacement)
+	return report.Update(t.depDir, func(r *report.Report) {
+		for _, used := range r.Deprecations {
+			if used == id {
+				return
+			}
+		}
+		r.Deprecations = append(r.Deprecations, id)
+		sort.Strings(r.Deprecations)
+	})
+}
+
+// List prints every deprecation with the version it is removed in
+func List(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tREMOVED IN\tDESCRIPTION\tREPLACEMENT")
+	for _, deprecation := range Deprecations {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", deprecation.ID, deprecation.RemovedIn, deprecation.Description, deprecation.Replacement)
+	}
+	tw.Flush()
+}
+
+func lookup(id string) (Deprecation, bool) {
+	for _, deprecation := range Deprecations {
+		if deprecation.ID == id {
+			return deprecation, true
+		}
+	}
+	return Deprecation{}, false
+}
@@ -0,0 +1,13 @@
+package deprecations_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDeprecations(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Deprecations Suite")
+}
@@ -0,0 +1,71 @@
+package deprecations_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"ruby/deprecations"
+	"ruby/report"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deprecations", func() {
+	var (
+		err     error
+		depDir  string
+		buffer  *bytes.Buffer
+		tracker *deprecations.Tracker
+	)
+
+	BeforeEach(func() {
+		depDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+		buffer = new(bytes.Buffer)
+		tracker = deprecations.New(libbuildpack.NewLogger(ansicleaner.New(buffer)), depDir)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(depDir)).To(Succeed())
+	})
+
+	Describe("Use", func() {
+		It("warns with the version the behavior is removed in and what replaces it", func() {
+			Expect(tracker.Use("rails3-start-command")).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("**WARNING** Deprecated [rails3-start-command]: Rails 3 apps without a Procfile start with bundle exec rails server, this is removed in buildpack 2.0.0.\n       Add a Procfile with a web process."))
+		})
+
+		It("warns once and records each deprecation in the staging report", func() {
+			Expect(tracker.Use("rails3-start-command")).To(Succeed())
+			Expect(tracker.Use("rails3-start-command")).To(Succeed())
+			Expect(deprecations.New(libbuildpack.NewLogger(ansicleaner.New(buffer)), depDir).Use("bp-debug-any-value")).To(Succeed())
+
+			Expect(bytes.Count(buffer.Bytes(), []byte("[rails3-start-command]"))).To(Equal(1))
+			r, err := report.Load(depDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Deprecations).To(Equal([]string{"bp-debug-any-value", "rails3-start-command"}))
+		})
+
+		It("ignores deprecations without a tracker", func() {
+			var tracker *deprecations.Tracker
+			Expect(tracker.Use("rails3-start-command")).To(Succeed())
+		})
+
+		It("panics for unregistered deprecations", func() {
+			Expect(func() { tracker.Use("unknown") }).To(Panic())
+		})
+	})
+
+	Describe("List", func() {
+		It("lists every deprecation", func() {
+			deprecations.List(buffer)
+			Expect(buffer.String()).To(MatchRegexp(`ID\s+REMOVED IN\s+DESCRIPTION\s+REPLACEMENT`))
+			for _, deprecation := range deprecations.Deprecations {
+				Expect(buffer.String()).To(ContainSubstring(deprecation.ID))
+			}
+		})
+	})
+})
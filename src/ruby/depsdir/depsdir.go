@@ -0,0 +1,134 @@
+// Package depsdir names what the buildpack keeps in its dep dir,
+// deps/<idx>. bin, lib, env, profile.d and config.yml are the multi
+// buildpack contract: later buildpacks put bin and lib on their paths and
+// load env, the launcher sources profile.d, and config.yml tells them which
+// buildpack supplied the dir. The rest is this buildpack's own layout,
+// which is restored from the app cache, so changes to it come with a
+// Migration for caches written by older buildpacks.
+package depsdir
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The multi buildpack contract
+const (
+	Bin       = "bin"
+	Lib       = "lib"
+	Env       = "env"
+	ProfileD  = "profile.d"
+	ConfigYml = "config.yml"
+)
+
+// The buildpack's own layout
+const (
+	Binstubs     = "binstubs"
+	Ruby         = "ruby"
+	Bundler      = "bundler"
+	GemHome      = "gem_home"
+	VendorBundle = "vendor_bundle"
+	BundlerGit   = "bundler_git"
+	NodeModules  = "node_modules"
+	Node         = "node"
+	Yarn         = "yarn"
+	// YarnCache and AssetsCache are only in the dep dir while staging, so
+	// they can be restored from and saved to the cache
+	YarnCache   = "yarn_cache"
+	AssetsCache = "assets_cache"
+	// BundlerPlugin is the plugin root of the plugins the Gemfile declares
+	BundlerPlugin = "bundler_plugin"
+	// CompiledAssets holds public/assets and public/packs as compiled for
+	// the asset sources of the last staging, only while staging like
+	// AssetsCache
+	CompiledAssets = "compiled_assets"
+)
+
+// Dir is a dep dir, either its path during staging or, from Runtime, the
+// path the running app finds it at
+type Dir string
+
+// Runtime is the dep dir of buildpack idx as profile.d scripts and the
+// launcher see it
+func Runtime(idx string) Dir {
+	return Dir("$DEPS_DIR/" + idx)
+}
+
+func (d Dir) Join(elem ...string) string {
+	return filepath.Join(append([]string{string(d)}, elem...)...)
+}
+
+func (d Dir) Bin() string      { return d.Join(Bin) }
+func (d Dir) Lib() string      { return d.Join(Lib) }
+func (d Dir) Binstubs() string { return d.Join(Binstubs) }
+func (d Dir) Ruby() string     { return d.Join(Ruby) }
+func (d Dir) GemHome() string  { return d.Join(GemHome) }
+func (d Dir) Bundler() string  { return d.Join(Bundler) }
+
+// EnvFile holds the value of name for the rest of staging
+func (d Dir) EnvFile(name string) string { return d.Join(Env, name) }
+
+// ProfileScript is the profile.d script name
+func (d Dir) ProfileScript(name string) string { return d.Join(ProfileD, name) }
+
+// VendorBundle is where bundler installs gems, with engine and abi it is
+// BUNDLE_PATH for that ruby. It is cached for each Gemfile.
+func (d Dir) VendorBundle(engineAndABI ...string) string {
+	return d.Join(append([]string{VendorBundle}, engineAndABI...)...)
+}
+
+// VendorBundles globs name in the vendor_bundle of every engine and abi
+func (d Dir) VendorBundles(name ...string) ([]string, error) {
+	return filepath.Glob(d.VendorBundle(append([]string{"*", "*"}, name...)...))
+}
+
+// Config is the config.yml of a dep dir
+type Config struct {
+	Name    string            `yaml:"name"`
+	Version string            `yaml:"version"`
+	Config  map[string]string `yaml:"config"`
+}
+
+// ReadConfig reads the config.yml a supply wrote to the dep dir
+func (d Dir) ReadConfig() (Config, error) {
+	var config Config
+	data, err := ioutil.ReadFile(d.Join(ConfigYml))
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("could not parse %s: %v", ConfigYml, err)
+	}
+	return config, nil
+}
+
+// MoveGitClones moves the clones bundler kept in vendor_bundle to
+// bundler_git, which is cached on its own, unless it already has clones
+func (d Dir) MoveGitClones() error {
+	clones, err := d.VendorBundles("cache", "bundler", "git")
+	if err != nil {
+		return err
+	}
+	for _, clone := range clones {
+		if info, err := os.Lstat(clone); err != nil {
+			return err
+		} else if !info.IsDir() {
+			continue
+		}
+		if exists, err := libbuildpack.FileExists(d.Join(BundlerGit)); err != nil {
+			return err
+		} else if exists {
+			if err := os.RemoveAll(clone); err != nil {
+				return err
+			}
+		} else if err := os.Rename(clone, d.Join(BundlerGit)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package depsdir_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDepsdir(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Depsdir Suite")
+}
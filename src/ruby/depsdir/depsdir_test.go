@@ -0,0 +1,105 @@
+package depsdir_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/depsdir"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Depsdir", func() {
+	var (
+		depsDir string
+		dir     depsdir.Dir
+		log     *libbuildpack.Logger
+	)
+
+	BeforeEach(func() {
+		var err error
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.depsdir.")
+		Expect(err).To(BeNil())
+		dir = depsdir.Dir(filepath.Join(depsDir, "9"))
+		Expect(os.MkdirAll(string(dir), 0755)).To(Succeed())
+		log = libbuildpack.NewLogger(ansicleaner.New(new(bytes.Buffer)))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("names the entries of the dep dir", func() {
+		Expect(dir.Bin()).To(Equal(filepath.Join(depsDir, "9", "bin")))
+		Expect(dir.EnvFile("GEM_HOME")).To(Equal(filepath.Join(depsDir, "9", "env", "GEM_HOME")))
+		Expect(dir.ProfileScript("ruby.sh")).To(Equal(filepath.Join(depsDir, "9", "profile.d", "ruby.sh")))
+		Expect(dir.VendorBundle("ruby", "2.5.0")).To(Equal(filepath.Join(depsDir, "9", "vendor_bundle", "ruby", "2.5.0")))
+	})
+
+	It("names the entries as the running app finds them", func() {
+		runtime := depsdir.Runtime("9")
+		Expect(runtime.GemHome()).To(Equal("$DEPS_DIR/9/gem_home"))
+		Expect(runtime.VendorBundle("ruby", "2.5.0")).To(Equal("$DEPS_DIR/9/vendor_bundle/ruby/2.5.0"))
+	})
+
+	It("globs every vendor_bundle", func() {
+		for _, abi := range []string{"2.4.0", "2.5.0"} {
+			Expect(os.MkdirAll(dir.VendorBundle("ruby", abi, "gems"), 0755)).To(Succeed())
+		}
+		Expect(dir.VendorBundles("gems")).To(Equal([]string{dir.VendorBundle("ruby", "2.4.0", "gems"), dir.VendorBundle("ruby", "2.5.0", "gems")}))
+	})
+
+	Describe("ReadConfig", func() {
+		It("reads the config.yml libbuildpack writes for later buildpacks", func() {
+			bpDir := filepath.Join(depsDir, "buildpack")
+			Expect(os.MkdirAll(bpDir, 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(bpDir, "manifest.yml"), []byte("---\nlanguage: ruby\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(bpDir, "VERSION"), []byte("1.7.9"), 0644)).To(Succeed())
+			manifest, err := libbuildpack.NewManifest(bpDir, log, time.Now())
+			Expect(err).To(BeNil())
+
+			stager := libbuildpack.NewStager([]string{depsDir, "", depsDir, "9"}, log, manifest)
+			Expect(stager.WriteConfigYml(map[string]string{"ruby_abi_version": "2.5.0"})).To(Succeed())
+
+			Expect(dir.ReadConfig()).To(Equal(depsdir.Config{Name: "ruby", Version: "1.7.9", Config: map[string]string{"ruby_abi_version": "2.5.0"}}))
+		})
+
+		It("fails without a config.yml", func() {
+			_, err := dir.ReadConfig()
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Describe("MoveGitClones", func() {
+		var clones string
+
+		BeforeEach(func() {
+			clones = dir.VendorBundle("ruby", "2.5.0", "cache", "bundler", "git")
+			Expect(os.MkdirAll(filepath.Join(clones, "rails-0123"), 0755)).To(Succeed())
+		})
+
+		It("moves git clones out of vendor_bundle", func() {
+			Expect(dir.MoveGitClones()).To(Succeed())
+			Expect(filepath.Join(string(dir), depsdir.BundlerGit, "rails-0123")).To(BeADirectory())
+			Expect(clones).ToNot(BeAnExistingFile())
+		})
+
+		It("keeps the clones already in bundler_git", func() {
+			Expect(os.MkdirAll(dir.Join(depsdir.BundlerGit, "rails-4567"), 0755)).To(Succeed())
+			Expect(dir.MoveGitClones()).To(Succeed())
+			Expect(dir.Join(depsdir.BundlerGit, "rails-4567")).To(BeADirectory())
+			Expect(clones).ToNot(BeAnExistingFile())
+		})
+
+		It("leaves a dep dir without clones in vendor_bundle alone", func() {
+			Expect(os.RemoveAll(clones)).To(Succeed())
+			Expect(dir.MoveGitClones()).To(Succeed())
+			Expect(dir.Join(depsdir.BundlerGit)).ToNot(BeAnExistingFile())
+		})
+	})
+})
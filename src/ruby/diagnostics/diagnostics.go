@@ -0,0 +1,254 @@
+package diagnostics
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"ruby/report"
+	"ruby/workspace"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+type Redactor interface {
+	Redact(string) string
+}
+
+// Collector gathers the files needed to debug a failed staging into a
+// tarball, so users do not have to reproduce the failure to read mkmf.log
+type Collector struct {
+	DepDir       string
+	BuildpackDir string
+	LogFiles     []string
+	Environ      []string
+	Redactor     Redactor
+	Log          *libbuildpack.Logger
+	UploadURL    string
+	// Workspace holds the tarball, it is removed with the workspace once
+	// uploaded
+	Workspace *workspace.Workspace
+}
+
+// diagnosticEnv are the variables whose values help debug a staging, only
+// the names of the others are collected as services and the app's own
+// variables hold credentials the redactor can't all know about
+var diagnosticEnv = map[string]bool{
+	"BUNDLE_GEMFILE":  true,
+	"BUNDLE_PATH":     true,
+	"BUNDLE_WITHOUT":  true,
+	"CF_STACK":        true,
+	"GEM_HOME":        true,
+	"GEM_PATH":        true,
+	"HOME":            true,
+	"LANG":            true,
+	"LD_LIBRARY_PATH": true,
+	"MEMORY_LIMIT":    true,
+	"NODE_ENV":        true,
+	"PATH":            true,
+	"RACK_ENV":        true,
+	"RAILS_ENV":       true,
+	"RUBYOPT":         true,
+	"STACK":           true,
+}
+
+// userinfo is the user:password@ of a URL
+var userinfo = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/@\s]+@`)
+
+// extensionLogs are written by rubygems when a native extension fails to build
+var extensionLogs = []string{"mkmf.log", "gem_make.out"}
+
+// Report collects the diagnostics tarball and either uploads it or tells
+// the user where it was written. A tarball which was not uploaded is moved
+// out of the workspace into TMPDIR, so it outlives the staging's scratch
+// files. Errors are logged rather than returned, staging has already failed.
+func (c *Collector) Report() {
+	path, err := c.Collect()
+	if err != nil {
+		c.Log.Warning("Unable to collect diagnostics: %s", err.Error())
+		return
+	}
+
+	if c.UploadURL == "" {
+		if path, err = c.keep(path); err != nil {
+			c.Log.Warning("Unable to keep diagnostics: %s", err.Error())
+			return
+		}
+		c.Log.BeginStep("Diagnostics written to %s", path)
+		c.Log.Info("Set BP_DIAGNOSTICS_URL to a URL accepting PUT requests to receive them from future stagings")
+		return
+	}
+
+	if err := upload(path, c.UploadURL); err != nil {
+		c.Log.Warning("Unable to upload diagnostics: %s", err.Error())
+		if path, err = c.keep(path); err != nil {
+			c.Log.Warning("Unable to keep diagnostics: %s", err.Error())
+			return
+		}
+		c.Log.Info("Diagnostics written to %s", path)
+		return
+	}
+	c.Log.BeginStep("Diagnostics uploaded to %s", c.UploadURL)
+}
+
+// keep moves the tarball at path from the workspace to TMPDIR, which holds
+// the workspace
+func (c *Collector) keep(path string) (string, error) {
+	kept := filepath.Join(filepath.Dir(c.Workspace.Root), filepath.Base(path))
+	if err := os.Rename(path, kept); err != nil {
+		return "", err
+	}
+	return kept, nil
+}
+
+// Collect writes the diagnostics tarball into the workspace
+func (c *Collector) Collect() (string, error) {
+	file, err := c.Workspace.File("ruby-buildpack-diagnostics-")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	for _, name := range c.extensionLogs() {
+		rel, err := filepath.Rel(c.DepDir, name)
+		if err != nil {
+			return "", err
+		}
+		if err := c.addFile(tw, filepath.Join("extensions", rel), name); err != nil {
+			return "", err
+		}
+	}
+
+	for _, name := range c.LogFiles {
+		if err := c.addFile(tw, filepath.Join("logs", filepath.Base(name)), name); err != nil {
+			return "", err
+		}
+	}
+
+	if err := c.addFile(tw, report.File, filepath.Join(c.DepDir, report.File)); err != nil {
+		return "", err
+	}
+
+	if err := c.addFile(tw, "manifest.yml", filepath.Join(c.BuildpackDir, "manifest.yml")); err != nil {
+		return "", err
+	}
+
+	if err := c.addContents(tw, "env", strings.Join(c.environ(), "\n")+"\n"); err != nil {
+		return "", err
+	}
+
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+
+	path := file.Name() + ".tgz"
+	if err := os.Rename(file.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (c *Collector) extensionLogs() []string {
+	var found []string
+	filepath.Walk(c.DepDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		for _, name := range extensionLogs {
+			if !info.IsDir() && info.Name() == name {
+				found = append(found, path)
+			}
+		}
+		return nil
+	})
+	return found
+}
+
+// environ is the sorted environment, with the values outside diagnosticEnv
+// omitted and credentials stripped from URLs in the others
+func (c *Collector) environ() []string {
+	var environ []string
+	for _, kv := range c.Environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		if !diagnosticEnv[parts[0]] {
+			environ = append(environ, parts[0]+"=[OMITTED]")
+			continue
+		}
+		environ = append(environ, parts[0]+"="+userinfo.ReplaceAllString(parts[1], "$1"))
+	}
+	sort.Strings(environ)
+	return environ
+}
+
+// addFile adds the redacted contents of source, missing files are skipped
+func (c *Collector) addFile(tw *tar.Writer, name, source string) error {
+	contents, err := ioutil.ReadFile(source)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return c.addContents(tw, name, string(contents))
+}
+
+func (c *Collector) addContents(tw *tar.Writer, name, contents string) error {
+	contents = c.Redactor.Redact(contents)
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(contents)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write([]byte(contents))
+	return err
+}
+
+func upload(path, url string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", url, file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/gzip")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
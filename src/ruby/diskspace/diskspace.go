@@ -0,0 +1,129 @@
+package diskspace
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+// markers are what ruby and rubygems print when a write fails because the
+// disk or its inodes are exhausted, or the container's quota is reached
+var markers = [][]byte{
+	[]byte("Errno::ENOSPC"),
+	[]byte("No space left on device"),
+	[]byte("Errno::EDQUOT"),
+	[]byte("Disk quota exceeded"),
+}
+
+// Detector is an io.Writer which watches command output for the disk
+// running out, output is usually streamed so markers split across writes
+// are still found
+type Detector struct {
+	Exhausted bool
+	tail      []byte
+}
+
+func (d *Detector) Write(p []byte) (int, error) {
+	if d.Exhausted {
+		return len(p), nil
+	}
+	data := append(d.tail, p...)
+	for _, marker := range markers {
+		if bytes.Contains(data, marker) {
+			d.Exhausted = true
+			d.tail = nil
+			return len(p), nil
+		}
+	}
+	if keep := 32; len(data) > keep {
+		data = data[len(data)-keep:]
+	}
+	d.tail = append([]byte{}, data...)
+	return len(p), nil
+}
+
+// Usage is the space and inodes of the filesystem holding a path
+type Usage struct {
+	Bytes      uint64
+	FreeBytes  uint64
+	Inodes     uint64
+	FreeInodes uint64
+}
+
+// UsageOf reports the usage of the filesystem dir is on
+func UsageOf(dir string) (Usage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return Usage{}, err
+	}
+	return Usage{
+		Bytes:      uint64(stat.Blocks) * uint64(stat.Bsize),
+		FreeBytes:  uint64(stat.Bavail) * uint64(stat.Bsize),
+		Inodes:     uint64(stat.Files),
+		FreeInodes: uint64(stat.Ffree),
+	}, nil
+}
+
+// InodesExhausted is true when less than 1% of the inodes are free, a
+// filesystem without inode accounting reports none at all
+func (u Usage) InodesExhausted() bool {
+	return u.Inodes > 0 && u.FreeInodes*100 < u.Inodes
+}
+
+// Entry is a directory and the files and bytes beneath it
+type Entry struct {
+	Name  string
+	Files int
+	Bytes int64
+}
+
+// Largest returns the n entries of dir with the most files beneath them,
+// the number of files is what exhausts inodes
+func Largest(dir string, n int) ([]Entry, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, info := range infos {
+		entry := Entry{Name: info.Name()}
+		filepath.Walk(filepath.Join(dir, info.Name()), func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			entry.Files++
+			entry.Bytes += info.Size()
+			return nil
+		})
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Files == entries[j].Files {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Files > entries[j].Files
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries, nil
+}
+
+// HumanBytes formats a size the way cf push -k takes it
+func HumanBytes(size uint64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", size)
+}
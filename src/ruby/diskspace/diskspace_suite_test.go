@@ -0,0 +1,13 @@
+package diskspace_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDiskspace(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Diskspace Suite")
+}
@@ -0,0 +1,84 @@
+package diskspace_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/diskspace"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Diskspace", func() {
+	Describe("Detector", func() {
+		It("finds ENOSPC in the output", func() {
+			detector := &diskspace.Detector{}
+			detector.Write([]byte("Installing nokogiri 1.8.2 with native extensions\n"))
+			Expect(detector.Exhausted).To(BeFalse())
+			detector.Write([]byte("Errno::ENOSPC: No space left on device @ rb_sysopen\n"))
+			Expect(detector.Exhausted).To(BeTrue())
+		})
+
+		It("finds a marker split across writes", func() {
+			detector := &diskspace.Detector{}
+			detector.Write([]byte("fatal: write error: Disk quota "))
+			detector.Write([]byte("exceeded\n"))
+			Expect(detector.Exhausted).To(BeTrue())
+		})
+
+		It("ignores other failures", func() {
+			detector := &diskspace.Detector{}
+			detector.Write([]byte("Gem::RemoteFetcher::FetchError: bad response Internal Server Error 500\n"))
+			Expect(detector.Exhausted).To(BeFalse())
+		})
+	})
+
+	Describe("UsageOf", func() {
+		It("reports the filesystem of the directory", func() {
+			usage, err := diskspace.UsageOf(os.TempDir())
+			Expect(err).To(BeNil())
+			Expect(usage.Bytes).To(BeNumerically(">", 0))
+			Expect(usage.FreeBytes).To(BeNumerically("<=", usage.Bytes))
+		})
+
+		It("knows when the inodes are exhausted", func() {
+			Expect(diskspace.Usage{Inodes: 1000, FreeInodes: 5}.InodesExhausted()).To(BeTrue())
+			Expect(diskspace.Usage{Inodes: 1000, FreeInodes: 500}.InodesExhausted()).To(BeFalse())
+			Expect(diskspace.Usage{}.InodesExhausted()).To(BeFalse())
+		})
+	})
+
+	Describe("Largest", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "ruby-buildpack.diskspace.")
+			Expect(err).To(BeNil())
+			for _, name := range []string{"a/1", "a/2", "a/3", "b/1", "c/1", "c/2"} {
+				Expect(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte("12345"), 0644)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("returns the entries with the most files", func() {
+			largest, err := diskspace.Largest(dir, 2)
+			Expect(err).To(BeNil())
+			Expect(largest).To(HaveLen(2))
+			Expect(largest[0].Name).To(Equal("a"))
+			Expect(largest[0].Files).To(Equal(4))
+			Expect(largest[1].Name).To(Equal("c"))
+		})
+	})
+
+	It("formats sizes", func() {
+		Expect(diskspace.HumanBytes(512)).To(Equal("512B"))
+		Expect(diskspace.HumanBytes(3 << 20)).To(Equal("3.0M"))
+		Expect(diskspace.HumanBytes(5 << 29)).To(Equal("2.5G"))
+	})
+})
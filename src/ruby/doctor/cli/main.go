@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"ruby/doctor"
+)
+
+func main() {
+	stack := flag.String("stack", os.Getenv("CF_STACK"), "stack the app will be staged on")
+	gemfile := flag.String("gemfile", os.Getenv("BUNDLE_GEMFILE"), "Gemfile the app is staged with, relative to the app directory")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: doctor [-stack cflinuxfs3] [-gemfile Gemfile_next] [app dir]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	appDir := "."
+	if flag.NArg() > 0 {
+		appDir = flag.Arg(0)
+	}
+	appDir, err := filepath.Abs(appDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to find app directory: %s\n", err.Error())
+		os.Exit(2)
+	}
+	if *stack == "" {
+		*stack = "cflinuxfs3"
+	}
+
+	d := &doctor.Doctor{AppDir: appDir, Gemfile: *gemfile, Stack: *stack, Snapshot: doctor.ManifestSnapshot}
+	findings, err := d.Check()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to check app: %s\n", err.Error())
+		os.Exit(2)
+	}
+
+	fmt.Printf("Checked %s for the %s stack\n", appDir, *stack)
+	doctor.Print(os.Stdout, findings)
+	for _, finding := range findings {
+		if finding.Priority == doctor.High {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package doctor
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"ruby/lockfile"
+	"ruby/problemgems"
+)
+
+//go:generate go run gen/main.go
+
+type Priority int
+
+const (
+	High Priority = iota
+	Medium
+	Low
+)
+
+func (p Priority) String() string {
+	switch p {
+	case High:
+		return "high"
+	case Medium:
+		return "medium"
+	default:
+		return "low"
+	}
+}
+
+type Finding struct {
+	Priority Priority
+	Problem  string
+	Fix      string
+}
+
+// Snapshot is the subset of the buildpack manifest the doctor needs to
+// resolve ruby versions without network access
+type Snapshot struct {
+	DefaultRuby string
+	Rubies      map[string][]string
+}
+
+type Doctor struct {
+	AppDir string
+	// Gemfile is relative to AppDir, Gemfile when empty
+	Gemfile  string
+	Stack    string
+	Snapshot Snapshot
+}
+
+var (
+	gemfileGem    = regexp.MustCompile(`^\s*gem\s+['"]([^'"]+)['"]`)
+	gemfileRuby   = regexp.MustCompile(`^\s*ruby\s+['"]([^'"]+)['"]`)
+	linuxPlatform = regexp.MustCompile(`^(ruby|java|x86_64-linux|.*-linux(-gnu)?)$`)
+)
+
+// Check runs every check against the app and returns the findings, most
+// important first
+func (d *Doctor) Check() ([]Finding, error) {
+	var findings []Finding
+
+	name := d.Gemfile
+	if name == "" {
+		name = "Gemfile"
+	}
+	gemfile, err := readLines(filepath.Join(d.AppDir, name))
+	if os.IsNotExist(err) {
+		return []Finding{{
+			Priority: High,
+			Problem:  fmt.Sprintf("There is no %s in %s", name, d.AppDir),
+			Fix:      "Run the doctor from the root of the app, or pass the app directory as an argument.",
+		}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	lock, err := lockfile.ParseInstallable(filepath.Join(d.AppDir, name+".lock"))
+	if os.IsNotExist(err) {
+		findings = append(findings, Finding{
+			Priority: High,
+			Problem:  fmt.Sprintf("There is no %s.lock, gem versions will be resolved during staging", name),
+			Fix:      fmt.Sprintf("Run bundle install and commit %s.lock.", name),
+		})
+		lock = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	findings = append(findings, d.checkRuby(gemfile, lock)...)
+	if lock != nil {
+		findings = append(findings, checkConsistency(name+".lock", gemfile, lock)...)
+		findings = append(findings, checkPlatforms(name+".lock", lock)...)
+
+		gems, err := d.checkProblemGems(lock)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, gems...)
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Priority < findings[j].Priority
+	})
+	return findings, nil
+}
+
+// Print writes findings as a numbered fix list
+func Print(w io.Writer, findings []Finding) {
+	if len(findings) == 0 {
+		fmt.Fprintln(w, "No problems found")
+		return
+	}
+	for i, finding := range findings {
+		fmt.Fprintf(w, "%d. [%s] %s\n", i+1, finding.Priority, finding.Problem)
+		fmt.Fprintf(w, "   Fix: %s\n", finding.Fix)
+	}
+}
+
+func (d *Doctor) checkRuby(gemfile []string, lock *lockfile.Lockfile) []Finding {
+	available, found := d.Snapshot.Rubies[d.Stack]
+	if !found {
+		return []Finding{{
+			Priority: High,
+			Problem:  fmt.Sprintf("The buildpack does not support the %s stack", d.Stack),
+			Fix:      "Push the app with -s set to one of: " + strings.Join(d.stacks(), ", ") + ".",
+		}}
+	}
+
+	requirement := ""
+	for _, line := range gemfile {
+		if m := gemfileRuby.FindStringSubmatch(line); m != nil {
+			requirement = m[1]
+		}
+	}
+	if requirement == "" && lock != nil {
+		requirement = lock.RubyVersion
+	}
+	if requirement == "" {
+		return []Finding{{
+			Priority: Low,
+			Problem:  fmt.Sprintf("No ruby version is declared, the buildpack default %s will be used", d.Snapshot.DefaultRuby),
+			Fix:      "Add a ruby directive to the Gemfile so upgrades to the buildpack do not change ruby.",
+		}}
+	}
+
+	constraints, err := lockfile.ParseRequirement(requirement)
+	if err != nil {
+		return []Finding{{
+			Priority: Medium,
+			Problem:  fmt.Sprintf("Could not understand the ruby requirement %s", requirement),
+			Fix:      "Use a plain version such as ruby '2.5.1' in the Gemfile.",
+		}}
+	}
+	for _, version := range available {
+		if v, err := lockfile.ParseVersion(version); err == nil && constraints.Check(v) {
+			return nil
+		}
+	}
+	return []Finding{{
+		Priority: High,
+		Problem:  fmt.Sprintf("ruby %s is not available for the %s stack", requirement, d.Stack),
+		Fix:      "Change the ruby directive in the Gemfile to one of: " + strings.Join(available, ", ") + ".",
+	}}
+}
+
+func checkConsistency(lockName string, gemfile []string, lock *lockfile.Lockfile) []Finding {
+	var findings []Finding
+	if lock.CRLF {
+		findings = append(findings, Finding{
+			Priority: High,
+			Problem:  lockName + " has Windows line endings, the buildpack will discard it and resolve gems again",
+			Fix:      "Convert " + lockName + " to Unix line endings and commit it.",
+		})
+	}
+
+	var missing []string
+	for _, line := range gemfile {
+		if m := gemfileGem.FindStringSubmatch(line); m != nil && !lock.HasDependency(m[1]) {
+			missing = append(missing, m[1])
+		}
+	}
+	if len(missing) > 0 {
+		findings = append(findings, Finding{
+			Priority: High,
+			Problem:  lockName + " is out of date, it does not include: " + strings.Join(missing, ", "),
+			Fix:      "Run bundle install and commit the updated " + lockName + ".",
+		})
+	}
+	return findings
+}
+
+func checkPlatforms(lockName string, lock *lockfile.Lockfile) []Finding {
+	for _, platform := range lock.Platforms {
+		if linuxPlatform.MatchString(platform) {
+			return nil
+		}
+	}
+	return []Finding{{
+		Priority: High,
+		Problem:  lockName + " only lists the platforms: " + strings.Join(lock.Platforms, ", "),
+		Fix:      "Run bundle lock --add-platform x86_64-linux (or ruby) and commit " + lockName + ".",
+	}}
+}
+
+func (d *Doctor) checkProblemGems(lock *lockfile.Lockfile) ([]Finding, error) {
+	var findings []Finding
+
+	locked := lockedVersions(lock.Versions())
+	known, err := problemgems.Find(locked)
+	if err != nil {
+		return nil, err
+	}
+	for _, gem := range known {
+		priority := Medium
+		if gem.Fatal {
+			priority = High
+		}
+		findings = append(findings, Finding{
+			Priority: priority,
+			Problem:  fmt.Sprintf("%s %s %s", gem.Name, locked[gem.Name], gem.Reason),
+			Fix:      gem.Migration,
+		})
+	}
+
+	stack, err := problemgems.FindOnStack(locked, d.Stack, problemgems.StackIncompatible)
+	if err != nil {
+		return nil, err
+	}
+	for _, gem := range stack {
+		findings = append(findings, Finding{
+			Priority: Medium,
+			Problem:  fmt.Sprintf("%s %s %s (%s)", gem.Name, locked[gem.Name], gem.Reason, d.Stack),
+			Fix:      gem.Migration,
+		})
+	}
+	return findings, nil
+}
+
+func (d *Doctor) stacks() []string {
+	var stacks []string
+	for stack := range d.Snapshot.Rubies {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	return stacks
+}
+
+// lockedVersions lets the lockfile be used with problemgems without ruby
+type lockedVersions map[string]string
+
+func (l lockedVersions) HasGemVersion(gem string, constraints ...string) (bool, error) {
+	version, found := l[gem]
+	if !found {
+		return false, nil
+	}
+	v, err := lockfile.ParseVersion(version)
+	if err != nil {
+		return false, nil
+	}
+	for _, constraint := range constraints {
+		c, err := lockfile.ParseRequirement(constraint)
+		if err != nil {
+			return false, err
+		}
+		if !c.Check(v) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func readLines(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n"), nil
+}
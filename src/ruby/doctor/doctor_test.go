@@ -0,0 +1,256 @@
+package doctor_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/doctor"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const lockfile = `GEM
+  remote: https://rubygems.org/
+  specs:
+    rack (2.0.5)
+    sinatra (2.0.3)
+      rack (~> 2.0)
+
+PLATFORMS
+  ruby
+
+DEPENDENCIES
+  sinatra
+
+RUBY VERSION
+   ruby 2.5.1p57
+
+BUNDLED WITH
+   1.16.2
+`
+
+var _ = Describe("Doctor", func() {
+	var (
+		err    error
+		appDir string
+		d      *doctor.Doctor
+	)
+
+	BeforeEach(func() {
+		appDir, err = ioutil.TempDir("", "ruby-buildpack.doctor.")
+		Expect(err).To(BeNil())
+
+		d = &doctor.Doctor{
+			AppDir: appDir,
+			Stack:  "cflinuxfs3",
+			Snapshot: doctor.Snapshot{
+				DefaultRuby: "2.4.x",
+				Rubies:      map[string][]string{"cflinuxfs3": {"2.4.4", "2.5.1"}},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(appDir)).To(Succeed())
+	})
+
+	write := func(name, contents string) {
+		Expect(ioutil.WriteFile(filepath.Join(appDir, name), []byte(contents), 0644)).To(Succeed())
+	}
+
+	problems := func() []string {
+		findings, err := d.Check()
+		Expect(err).To(BeNil())
+		var problems []string
+		for _, finding := range findings {
+			problems = append(problems, finding.Priority.String()+": "+finding.Problem)
+		}
+		return problems
+	}
+
+	Context("the app is healthy", func() {
+		BeforeEach(func() {
+			write("Gemfile", "source 'https://rubygems.org'\nruby '~> 2.5.0'\ngem 'sinatra'\n")
+			write("Gemfile.lock", lockfile)
+		})
+
+		It("finds nothing", func() {
+			Expect(problems()).To(BeEmpty())
+		})
+	})
+
+	Context("there is no Gemfile", func() {
+		It("reports only that", func() {
+			Expect(problems()).To(Equal([]string{"high: There is no Gemfile in " + appDir}))
+		})
+	})
+
+	Context("there is no Gemfile.lock", func() {
+		BeforeEach(func() {
+			write("Gemfile", "ruby '2.5.1'\ngem 'sinatra'\n")
+		})
+
+		It("reports it", func() {
+			Expect(problems()).To(ConsistOf(ContainSubstring("high: There is no Gemfile.lock")))
+		})
+	})
+
+	Context("the app dual boots with Gemfile_next", func() {
+		BeforeEach(func() {
+			d.Gemfile = "Gemfile_next"
+			write("Gemfile", "source 'https://rubygems.org'\nruby '~> 2.5.0'\ngem 'sinatra'\n")
+			write("Gemfile.lock", lockfile)
+			write("Gemfile_next", "source 'https://rubygems.org'\nruby '~> 2.5.0'\ngem 'sinatra'\ngem 'puma'\n")
+		})
+
+		It("checks Gemfile_next and its lockfile", func() {
+			Expect(problems()).To(ConsistOf("high: There is no Gemfile_next.lock, gem versions will be resolved during staging"))
+
+			write("Gemfile_next.lock", lockfile)
+			Expect(problems()).To(ConsistOf("high: Gemfile_next.lock is out of date, it does not include: puma"))
+		})
+	})
+
+	Context("the Gemfile has gems which are not locked", func() {
+		BeforeEach(func() {
+			write("Gemfile", "gem 'sinatra'\ngem \"puma\", '~> 3.0'\n  gem 'rspec'\n")
+			write("Gemfile.lock", lockfile)
+		})
+
+		It("lists them", func() {
+			Expect(problems()).To(ContainElement("high: Gemfile.lock is out of date, it does not include: puma, rspec"))
+		})
+	})
+
+	Context("the ruby version is not in the manifest", func() {
+		BeforeEach(func() {
+			write("Gemfile", "ruby '2.3.7'\ngem 'sinatra'\n")
+			write("Gemfile.lock", lockfile)
+		})
+
+		It("lists the available versions", func() {
+			findings, err := d.Check()
+			Expect(err).To(BeNil())
+			Expect(findings).To(HaveLen(1))
+			Expect(findings[0].Problem).To(Equal("ruby 2.3.7 is not available for the cflinuxfs3 stack"))
+			Expect(findings[0].Fix).To(ContainSubstring("2.4.4, 2.5.1"))
+		})
+	})
+
+	Context("the ruby version is only in Gemfile.lock", func() {
+		BeforeEach(func() {
+			write("Gemfile", "gem 'sinatra'\n")
+			write("Gemfile.lock", lockfile)
+			d.Snapshot.Rubies["cflinuxfs3"] = []string{"2.5.0"}
+		})
+
+		It("checks the locked version", func() {
+			Expect(problems()).To(Equal([]string{"high: ruby 2.5.1 is not available for the cflinuxfs3 stack"}))
+		})
+	})
+
+	Context("no ruby version is declared", func() {
+		BeforeEach(func() {
+			write("Gemfile", "gem 'sinatra'\n")
+		})
+
+		It("mentions the default", func() {
+			Expect(problems()).To(ContainElement("low: No ruby version is declared, the buildpack default 2.4.x will be used"))
+		})
+	})
+
+	Context("the stack is unknown", func() {
+		BeforeEach(func() {
+			write("Gemfile", "gem 'sinatra'\n")
+			write("Gemfile.lock", lockfile)
+			d.Stack = "cflinuxfs9"
+		})
+
+		It("reports it", func() {
+			Expect(problems()).To(ContainElement("high: The buildpack does not support the cflinuxfs9 stack"))
+		})
+	})
+
+	Context("Gemfile.lock only has windows platforms", func() {
+		BeforeEach(func() {
+			write("Gemfile", "gem 'sinatra'\n")
+			write("Gemfile.lock", `GEM
+  specs:
+    sinatra (2.0.3)
+
+PLATFORMS
+  x64-mingw32
+
+DEPENDENCIES
+  sinatra
+`)
+		})
+
+		It("suggests adding a linux platform", func() {
+			findings, err := d.Check()
+			Expect(err).To(BeNil())
+			Expect(findings[0].Problem).To(Equal("Gemfile.lock only lists the platforms: x64-mingw32"))
+			Expect(findings[0].Fix).To(ContainSubstring("bundle lock --add-platform x86_64-linux"))
+		})
+	})
+
+	Context("Gemfile.lock has windows line endings", func() {
+		BeforeEach(func() {
+			write("Gemfile", "gem 'sinatra'\r\n")
+			write("Gemfile.lock", "GEM\r\n  specs:\r\n    sinatra (2.0.3)\r\n\r\nPLATFORMS\r\n  ruby\r\n\r\nDEPENDENCIES\r\n  sinatra\r\n")
+		})
+
+		It("reports it", func() {
+			Expect(problems()).To(ContainElement(ContainSubstring("high: Gemfile.lock has Windows line endings")))
+		})
+	})
+
+	Context("problem gems are locked", func() {
+		BeforeEach(func() {
+			d.Stack = "cflinuxfs4"
+			d.Snapshot.Rubies["cflinuxfs4"] = []string{"2.5.1"}
+			write("Gemfile", "gem 'mini_racer'\ngem 'capybara-webkit'\ngem 'openssl'\n")
+			write("Gemfile.lock", `GEM
+  specs:
+    capybara-webkit (1.15.0)
+    libv8 (3.16.14.19-x86_64-linux)
+    mini_racer (0.1.15)
+      libv8 (~> 3.16)
+    openssl (2.1.2)
+
+PLATFORMS
+  ruby
+
+DEPENDENCIES
+  capybara-webkit
+  mini_racer
+  openssl
+`)
+		})
+
+		It("lists them by priority", func() {
+			Expect(problems()).To(Equal([]string{
+				"high: libv8 3.16.14.19 bundles a version of V8 which no longer compiles on current stacks",
+				"medium: capybara-webkit 1.15.0 requires Qt WebKit, which is not available on the stack",
+				"medium: openssl 2.1.2 does not compile against OpenSSL 3, which is the only OpenSSL on the stack (cflinuxfs4)",
+				"low: No ruby version is declared, the buildpack default 2.4.x will be used",
+			}))
+		})
+	})
+
+	Describe("Print", func() {
+		It("numbers the findings", func() {
+			buffer := new(bytes.Buffer)
+			doctor.Print(buffer, []doctor.Finding{{Priority: doctor.High, Problem: "broken", Fix: "mend it"}})
+			Expect(buffer.String()).To(Equal("1. [high] broken\n   Fix: mend it\n"))
+		})
+
+		It("says when there is nothing to fix", func() {
+			buffer := new(bytes.Buffer)
+			doctor.Print(buffer, nil)
+			Expect(buffer.String()).To(Equal("No problems found\n"))
+		})
+	})
+})
@@ -0,0 +1,90 @@
+//go:build ignore
+// +build ignore
+
+// Generates snapshot_generated.go from the buildpack manifest, run with
+// go generate from the doctor package
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func main() {
+	var manifest struct {
+		DefaultVersions []struct {
+			Name    string `yaml:"name"`
+			Version string `yaml:"version"`
+		} `yaml:"default_versions"`
+		Dependencies []struct {
+			Name    string   `yaml:"name"`
+			Version string   `yaml:"version"`
+			Stacks  []string `yaml:"cf_stacks"`
+		} `yaml:"dependencies"`
+	}
+
+	data, err := ioutil.ReadFile("../../../manifest.yml")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		log.Fatal(err)
+	}
+
+	defaultRuby := ""
+	for _, dep := range manifest.DefaultVersions {
+		if dep.Name == "ruby" {
+			defaultRuby = dep.Version
+		}
+	}
+
+	rubies := map[string][]string{}
+	for _, dep := range manifest.Dependencies {
+		if dep.Name != "ruby" {
+			continue
+		}
+		for _, stack := range dep.Stacks {
+			rubies[stack] = append(rubies[stack], dep.Version)
+		}
+	}
+	var stacks []string
+	for stack := range rubies {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "// Code generated by gen/main.go from manifest.yml. DO NOT EDIT.")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "package doctor")
+	fmt.Fprintln(buf)
+	fmt.Fprintln(buf, "var ManifestSnapshot = Snapshot{")
+	fmt.Fprintf(buf, "DefaultRuby: %q,\n", defaultRuby)
+	fmt.Fprintln(buf, "Rubies: map[string][]string{")
+	for _, stack := range stacks {
+		fmt.Fprintf(buf, "%q: {", stack)
+		for i, version := range rubies[stack] {
+			if i > 0 {
+				fmt.Fprint(buf, ", ")
+			}
+			fmt.Fprintf(buf, "%q", version)
+		}
+		fmt.Fprintln(buf, "},")
+	}
+	fmt.Fprintln(buf, "},")
+	fmt.Fprintln(buf, "}")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("snapshot_generated.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,11 @@
+// Code generated by gen/main.go from manifest.yml. DO NOT EDIT.
+
+package doctor
+
+var ManifestSnapshot = Snapshot{
+	DefaultRuby: "2.4.x",
+	Rubies: map[string][]string{
+		"cflinuxfs2": {"2.2.9", "2.2.10", "2.3.6", "2.3.7", "2.4.3", "2.4.4", "2.5.0", "2.5.1"},
+		"cflinuxfs3": {"2.2.10", "2.3.7", "2.4.4", "2.5.1"},
+	},
+}
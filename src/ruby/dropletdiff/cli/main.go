@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"ruby/dropletdiff"
+	"ruby/report"
+)
+
+func main() {
+	secret := flag.String("secret", os.Getenv("BP_REPORT_SIGNING_SECRET"), "secret the staging reports were signed with, their signatures are verified when set")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: dropletdiff [-secret SECRET] <before> <after>")
+		fmt.Fprintln(os.Stderr, "Each of before and after is a staging_report.json, an extracted droplet or a droplet .tgz")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	before, err := dropletdiff.Load(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load %s: %s\n", flag.Arg(0), err.Error())
+		os.Exit(2)
+	}
+	after, err := dropletdiff.Load(flag.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load %s: %s\n", flag.Arg(1), err.Error())
+		os.Exit(2)
+	}
+
+	if *secret != "" {
+		for i, rep := range []*report.Report{before, after} {
+			if err := rep.Contents.Verify(*secret); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to verify %s: %s\n", flag.Arg(i), err.Error())
+				os.Exit(2)
+			}
+		}
+	}
+
+	changes := dropletdiff.Diff(before, after)
+	dropletdiff.Print(os.Stdout, changes)
+	if len(changes) > 0 {
+		os.Exit(1)
+	}
+}
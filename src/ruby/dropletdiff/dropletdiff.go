@@ -0,0 +1,213 @@
+// Package dropletdiff compares the staging reports of two deploys, to
+// answer what changed between them: which gems were added, removed or
+// upgraded, and which assets and executables differ.
+package dropletdiff
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"ruby/report"
+	"sort"
+	"strings"
+)
+
+// Load reads a staging report from a staging_report.json, an extracted
+// droplet directory or a droplet tarball
+func Load(path string) (*report.Report, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return loadDir(path)
+	}
+	if strings.HasSuffix(path, ".tgz") || strings.HasSuffix(path, ".tar.gz") {
+		return loadTarball(path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return decode(file, path)
+}
+
+func loadDir(dir string) (*report.Report, error) {
+	for _, glob := range []string{report.File, filepath.Join("deps", "*", report.File)} {
+		matches, err := filepath.Glob(filepath.Join(dir, glob))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) > 0 {
+			return Load(matches[0])
+		}
+	}
+	return nil, fmt.Errorf("%s does not contain a %s", dir, report.File)
+}
+
+func loadTarball(path string) (*report.Report, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s does not contain a %s", path, report.File)
+		} else if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(header.Name, "./")
+		if matched, _ := filepath.Match(filepath.Join("deps", "*", report.File), name); matched {
+			return decode(tr, path)
+		}
+	}
+}
+
+func decode(r io.Reader, path string) (*report.Report, error) {
+	rep := &report.Report{}
+	if err := json.NewDecoder(r).Decode(rep); err != nil {
+		return nil, fmt.Errorf("could not parse the staging report in %s: %v", path, err)
+	}
+	if rep.Contents == nil {
+		return nil, fmt.Errorf("the staging report in %s has no droplet contents, it was staged by an older buildpack", path)
+	}
+	return rep, nil
+}
+
+// Change is one difference between two deploys
+type Change struct {
+	Kind   string
+	Op     string
+	Name   string
+	Detail string
+}
+
+const (
+	Added   = "+"
+	Removed = "-"
+	Changed = "~"
+)
+
+// Diff lists the gem, asset and binary differences from before to after
+func Diff(before, after *report.Report) []Change {
+	var changes []Change
+	changes = append(changes, diffGems(before.Contents.Gems, after.Contents.Gems)...)
+	changes = append(changes, diffFiles("Assets", before.Contents.Assets, after.Contents.Assets)...)
+	changes = append(changes, diffFiles("Binaries", before.Contents.Binaries, after.Contents.Binaries)...)
+	return changes
+}
+
+func diffGems(before, after []report.Gem) []Change {
+	old, cur := map[string]report.Gem{}, map[string]report.Gem{}
+	var names []string
+	for _, gem := range before {
+		old[gem.Name] = gem
+		names = append(names, gem.Name)
+	}
+	for _, gem := range after {
+		cur[gem.Name] = gem
+		if _, found := old[gem.Name]; !found {
+			names = append(names, gem.Name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []Change
+	for _, name := range names {
+		a, inBefore := old[name]
+		b, inAfter := cur[name]
+		switch {
+		case !inAfter:
+			changes = append(changes, Change{Kind: "Gems", Op: Removed, Name: name, Detail: gemDetail(a)})
+		case !inBefore:
+			changes = append(changes, Change{Kind: "Gems", Op: Added, Name: name, Detail: gemDetail(b)})
+		case gemVersion(a) != gemVersion(b):
+			changes = append(changes, Change{Kind: "Gems", Op: Changed, Name: name, Detail: fmt.Sprintf("%s -> %s%s", gemVersion(a), gemVersion(b), groupsDetail(b))})
+		case a.Digest != b.Digest:
+			changes = append(changes, Change{Kind: "Gems", Op: Changed, Name: name, Detail: fmt.Sprintf("%s contents changed%s", gemVersion(b), groupsDetail(b))})
+		case strings.Join(a.Groups, ",") != strings.Join(b.Groups, ","):
+			changes = append(changes, Change{Kind: "Gems", Op: Changed, Name: name, Detail: fmt.Sprintf("%s groups %s -> %s", gemVersion(b), strings.Join(a.Groups, ","), strings.Join(b.Groups, ","))})
+		}
+	}
+	return changes
+}
+
+func gemVersion(gem report.Gem) string {
+	if gem.Platform != "" {
+		return gem.Version + "-" + gem.Platform
+	}
+	return gem.Version
+}
+
+func gemDetail(gem report.Gem) string {
+	return gemVersion(gem) + groupsDetail(gem)
+}
+
+func groupsDetail(gem report.Gem) string {
+	if len(gem.Groups) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(gem.Groups, ", ") + ")"
+}
+
+func diffFiles(kind string, before, after map[string]string) []Change {
+	var names []string
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, found := before[name]; !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []Change
+	for _, name := range names {
+		a, inBefore := before[name]
+		b, inAfter := after[name]
+		switch {
+		case !inAfter:
+			changes = append(changes, Change{Kind: kind, Op: Removed, Name: name})
+		case !inBefore:
+			changes = append(changes, Change{Kind: kind, Op: Added, Name: name})
+		case a != b:
+			changes = append(changes, Change{Kind: kind, Op: Changed, Name: name})
+		}
+	}
+	return changes
+}
+
+// Print writes the changes grouped by kind
+func Print(w io.Writer, changes []Change) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No differences")
+		return
+	}
+	kind := ""
+	for _, change := range changes {
+		if change.Kind != kind {
+			kind = change.Kind
+			fmt.Fprintf(w, "%s:\n", kind)
+		}
+		if change.Detail == "" {
+			fmt.Fprintf(w, "  %s %s\n", change.Op, change.Name)
+		} else {
+			fmt.Fprintf(w, "  %s %s %s\n", change.Op, change.Name, change.Detail)
+		}
+	}
+}
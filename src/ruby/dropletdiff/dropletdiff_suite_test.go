@@ -0,0 +1,13 @@
+package dropletdiff_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDropletdiff(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Dropletdiff Suite")
+}
@@ -0,0 +1,125 @@
+package dropletdiff_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/dropletdiff"
+	"ruby/report"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dropletdiff", func() {
+	var before, after *report.Report
+
+	BeforeEach(func() {
+		before = &report.Report{Contents: &report.Contents{
+			Gems: []report.Gem{
+				{Name: "nokogiri", Version: "1.8.2", Platform: "x86_64-linux", Groups: []string{"default"}, Digest: "n1"},
+				{Name: "puma", Version: "3.11.4", Groups: []string{"default"}, Digest: "p1"},
+				{Name: "rack", Version: "2.0.5", Groups: []string{"default"}, Digest: "r1"},
+				{Name: "resque", Version: "1.27.4", Groups: []string{"default"}, Digest: "q1"},
+			},
+			Assets:   map[string]string{"app/public/assets/application-1.js": "a1", "app/public/assets/logo.png": "l1"},
+			Binaries: map[string]string{"deps/0/bin/ruby": "b1"},
+		}}
+		after = &report.Report{Contents: &report.Contents{
+			Gems: []report.Gem{
+				{Name: "nokogiri", Version: "1.8.2", Platform: "x86_64-linux", Groups: []string{"default"}, Digest: "n2"},
+				{Name: "puma", Version: "3.11.4", Groups: []string{"default"}, Digest: "p1"},
+				{Name: "rack", Version: "2.0.6", Groups: []string{"default"}, Digest: "r2"},
+				{Name: "sidekiq", Version: "5.1.3", Groups: []string{"default", "worker"}, Digest: "s1"},
+			},
+			Assets:   map[string]string{"app/public/assets/application-2.js": "a2", "app/public/assets/logo.png": "l1"},
+			Binaries: map[string]string{"deps/0/bin/ruby": "b2"},
+		}}
+	})
+
+	Describe("Diff", func() {
+		It("lists gem, asset and binary changes", func() {
+			Expect(dropletdiff.Diff(before, after)).To(Equal([]dropletdiff.Change{
+				{Kind: "Gems", Op: dropletdiff.Changed, Name: "nokogiri", Detail: "1.8.2-x86_64-linux contents changed (default)"},
+				{Kind: "Gems", Op: dropletdiff.Changed, Name: "rack", Detail: "2.0.5 -> 2.0.6 (default)"},
+				{Kind: "Gems", Op: dropletdiff.Removed, Name: "resque", Detail: "1.27.4 (default)"},
+				{Kind: "Gems", Op: dropletdiff.Added, Name: "sidekiq", Detail: "5.1.3 (default, worker)"},
+				{Kind: "Assets", Op: dropletdiff.Removed, Name: "app/public/assets/application-1.js"},
+				{Kind: "Assets", Op: dropletdiff.Added, Name: "app/public/assets/application-2.js"},
+				{Kind: "Binaries", Op: dropletdiff.Changed, Name: "deps/0/bin/ruby"},
+			}))
+		})
+
+		It("prints the changes grouped by kind", func() {
+			buffer := new(bytes.Buffer)
+			dropletdiff.Print(buffer, dropletdiff.Diff(before, after))
+			Expect(buffer.String()).To(ContainSubstring("Gems:\n  ~ nokogiri 1.8.2-x86_64-linux contents changed (default)\n  ~ rack 2.0.5 -> 2.0.6 (default)\n"))
+			Expect(buffer.String()).To(ContainSubstring("Binaries:\n  ~ deps/0/bin/ruby\n"))
+		})
+
+		It("prints when nothing changed", func() {
+			buffer := new(bytes.Buffer)
+			dropletdiff.Print(buffer, dropletdiff.Diff(before, before))
+			Expect(buffer.String()).To(Equal("No differences\n"))
+		})
+	})
+
+	Describe("Load", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "ruby-buildpack.dropletdiff.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("loads a staging report", func() {
+			Expect(before.Save(dir)).To(Succeed())
+			Expect(dropletdiff.Load(filepath.Join(dir, report.File))).To(Equal(before))
+		})
+
+		It("loads the report from an extracted droplet", func() {
+			Expect(os.MkdirAll(filepath.Join(dir, "deps", "0"), 0755)).To(Succeed())
+			Expect(before.Save(filepath.Join(dir, "deps", "0"))).To(Succeed())
+			Expect(dropletdiff.Load(dir)).To(Equal(before))
+		})
+
+		It("loads the report from a droplet tarball", func() {
+			data, err := json.Marshal(after)
+			Expect(err).To(BeNil())
+
+			buffer := new(bytes.Buffer)
+			gz := gzip.NewWriter(buffer)
+			tw := tar.NewWriter(gz)
+			for name, contents := range map[string][]byte{"./app/Gemfile": []byte("gem 'rack'\n"), "./deps/0/staging_report.json": data} {
+				Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))})).To(Succeed())
+				_, err := tw.Write(contents)
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(Succeed())
+			Expect(gz.Close()).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "droplet.tgz"), buffer.Bytes(), 0644)).To(Succeed())
+
+			Expect(dropletdiff.Load(filepath.Join(dir, "droplet.tgz"))).To(Equal(after))
+		})
+
+		It("rejects reports without droplet contents", func() {
+			Expect((&report.Report{Rubygems: "2.7.7"}).Save(dir)).To(Succeed())
+			_, err := dropletdiff.Load(filepath.Join(dir, report.File))
+			Expect(err).To(MatchError(ContainSubstring("it was staged by an older buildpack")))
+		})
+
+		It("returns an error for a directory without a report", func() {
+			_, err := dropletdiff.Load(dir)
+			Expect(err).To(MatchError(dir + " does not contain a staging_report.json"))
+		})
+	})
+})
@@ -0,0 +1,120 @@
+// Package extensions lets custom builds of the buildpack add steps to
+// staging, such as a compliance scan or internal telemetry, without patching
+// supply or finalize. An extension is compiled in by registering it from an
+// init function of a file in ruby/hooks:
+//
+//	func init() {
+//		extensions.Register(scanner{})
+//	}
+//
+// Staging runs the extensions registered for each Point in the order they
+// were registered, each as a stage of its own in the staging log. An error
+// of an extension fails staging.
+package extensions
+
+import (
+	"fmt"
+	"ruby/featureflags"
+	"ruby/stages"
+	"sync"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Point is a point of staging extensions run at
+type Point string
+
+// The points of staging, which stay where they are between releases
+const (
+	// AfterSupplySetup is after supply has selected the Gemfile and the
+	// versions to install, before anything is installed
+	AfterSupplySetup Point = "after_supply_setup"
+	// AfterGems is after bundler installed and verified the gems
+	AfterGems Point = "after_gems"
+	// AfterSupply is after the profile.d scripts are written, before the
+	// cache is saved
+	AfterSupply Point = "after_supply"
+	// AfterFinalizeSetup is after finalize has restored the Gemfile.lock
+	// and the bundler config to the app
+	AfterFinalizeSetup Point = "after_finalize_setup"
+	// AfterAssets is after the assets are precompiled and their caches saved
+	AfterAssets Point = "after_assets"
+	// AfterFinalize is after the release is generated, before the contents
+	// of the droplet are recorded and attested
+	AfterFinalize Point = "after_finalize"
+)
+
+// Points are the points in the order staging reaches them
+var Points = []Point{AfterSupplySetup, AfterGems, AfterSupply, AfterFinalizeSetup, AfterAssets, AfterFinalize}
+
+// Context is what an extension is given about the staging it runs in
+type Context struct {
+	Point    Point
+	BuildDir string
+	DepDir   string
+	DepsIdx  string
+	Flags    *featureflags.FeatureFlags
+	Log      *libbuildpack.Logger
+}
+
+// Extension is a step compiled into the buildpack
+type Extension interface {
+	// Name names the extension in the log and its stages, in snake case
+	Name() string
+	// Points are the points to run the extension at
+	Points() []Point
+	// Run runs the extension at ctx.Point
+	Run(ctx Context) error
+}
+
+var (
+	registered     []Extension
+	registeredLock sync.Mutex
+)
+
+// Register adds an extension to staging
+func Register(extension Extension) {
+	registeredLock.Lock()
+	registered = append(registered, extension)
+	registeredLock.Unlock()
+}
+
+// Clear removes the registered extensions
+func Clear() {
+	registeredLock.Lock()
+	registered = nil
+	registeredLock.Unlock()
+}
+
+// Registered returns the registered extensions
+func Registered() []Extension {
+	registeredLock.Lock()
+	defer registeredLock.Unlock()
+	return append([]Extension(nil), registered...)
+}
+
+// Run runs the extensions registered for ctx.Point, stopping at the first
+// which fails. Each runs as the stage extension_<name>.
+func Run(ctx Context) error {
+	for _, extension := range Registered() {
+		if !runsAt(extension, ctx.Point) {
+			continue
+		}
+		extension := extension
+		ctx.Log.BeginStep("Running the %s extension", extension.Name())
+		err := stages.Run(ctx.Log, ctx.DepDir, "extension_"+extension.Name(), func() error { return extension.Run(ctx) })
+		if err != nil {
+			return fmt.Errorf("extension %s failed at %s: %v", extension.Name(), ctx.Point, err)
+		}
+	}
+	return nil
+}
+
+func runsAt(extension Extension, point Point) bool {
+	for _, p := range extension.Points() {
+		if p == point {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package extensions_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"ruby/extensions"
+
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeExtension struct {
+	name   string
+	points []extensions.Point
+	err    error
+	ran    *[]string
+}
+
+func (e fakeExtension) Name() string               { return e.name }
+func (e fakeExtension) Points() []extensions.Point { return e.points }
+func (e fakeExtension) Run(ctx extensions.Context) error {
+	*e.ran = append(*e.ran, e.name+"@"+string(ctx.Point))
+	return e.err
+}
+
+var _ = Describe("Extensions", func() {
+	var (
+		depDir string
+		buffer *bytes.Buffer
+		ctx    extensions.Context
+		ran    []string
+	)
+
+	BeforeEach(func() {
+		var err error
+		depDir, err = ioutil.TempDir("", "ruby-buildpack.extensions.")
+		Expect(err).To(BeNil())
+		buffer = new(bytes.Buffer)
+		ran = nil
+		ctx = extensions.Context{Point: extensions.AfterGems, BuildDir: "/app", DepDir: depDir, DepsIdx: "9", Log: libbuildpack.NewLogger(buffer)}
+		extensions.Clear()
+	})
+
+	AfterEach(func() {
+		extensions.Clear()
+		Expect(os.RemoveAll(depDir)).To(Succeed())
+	})
+
+	It("runs the extensions of the point in the order they were registered", func() {
+		extensions.Register(fakeExtension{name: "scan", points: []extensions.Point{extensions.AfterGems, extensions.AfterFinalize}, ran: &ran})
+		extensions.Register(fakeExtension{name: "unrelated", points: []extensions.Point{extensions.AfterAssets}, ran: &ran})
+		extensions.Register(fakeExtension{name: "telemetry", points: []extensions.Point{extensions.AfterGems}, ran: &ran})
+
+		Expect(extensions.Run(ctx)).To(Succeed())
+		Expect(ran).To(Equal([]string{"scan@after_gems", "telemetry@after_gems"}))
+		Expect(buffer.String()).To(ContainSubstring("Running the scan extension"))
+		Expect(buffer.String()).To(ContainSubstring("=== stage:begin name=extension_scan ==="))
+		Expect(buffer.String()).To(MatchRegexp(`=== stage:end name=extension_telemetry status=ok duration=[\d.]+s ===`))
+	})
+
+	It("stops at the first extension which fails", func() {
+		extensions.Register(fakeExtension{name: "scan", points: []extensions.Point{extensions.AfterGems}, err: errors.New("found GPL gems"), ran: &ran})
+		extensions.Register(fakeExtension{name: "telemetry", points: []extensions.Point{extensions.AfterGems}, ran: &ran})
+
+		err := extensions.Run(ctx)
+		Expect(err).To(MatchError("extension scan failed at after_gems: found GPL gems"))
+		Expect(ran).To(Equal([]string{"scan@after_gems"}))
+		Expect(buffer.String()).To(ContainSubstring("=== stage:end name=extension_scan status=failed"))
+	})
+
+	It("does nothing without extensions", func() {
+		Expect(extensions.Run(ctx)).To(Succeed())
+		Expect(buffer.String()).To(BeEmpty())
+	})
+})
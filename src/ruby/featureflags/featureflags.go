@@ -0,0 +1,228 @@
+package featureflags
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type Kind int
+
+const (
+	Bool Kind = iota
+	String
+	Seconds
+	Days
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Bool:
+		return "bool"
+	case Seconds:
+		return "seconds"
+	case Days:
+		return "days"
+	default:
+		return "string"
+	}
+}
+
+type Flag struct {
+	Name        string
+	Kind        Kind
+	Default     string
+	Description string
+	// Secret flags hold credentials, List does not print their value
+	Secret bool
+}
+
+// Flags lists every BP_* environment variable understood by the buildpack
+var Flags = []Flag{
+	{Name: "BP_DEBUG", Kind: String, Default: "", Description: "Print debug output during staging when set to any value"},
+	{Name: "BP_BOOT_CHECK", Kind: Bool, Default: "false", Description: "Boot the web process during finalize to verify the app starts"},
+	{Name: "BP_BOOT_CHECK_TIMEOUT", Kind: Seconds, Default: "10", Description: "How long the web process must stay up during the boot check"},
+	{Name: "BP_DIAGNOSTICS", Kind: Bool, Default: "false", Description: "Collect a diagnostics tarball when staging fails"},
+	{Name: "BP_DIAGNOSTICS_URL", Kind: String, Default: "", Description: "URL the diagnostics tarball is uploaded to with a PUT request"},
+	{Name: "BP_REPRODUCIBLE", Kind: Bool, Default: "false", Description: "Normalize timestamps and remove build artifacts so droplets are byte identical"},
+	{Name: "BP_REVIEW_APP", Kind: Bool, Default: "false", Description: "Load the schema and seeds into an empty database when a Rails app starts"},
+	{Name: "BP_SLIM_INTERPRETERS", Kind: Bool, Default: "false", Description: "Strip debug symbols from the ruby and node binaries and remove their documentation"},
+	{Name: "BP_KEEP_NODE_MODULES", Kind: Bool, Default: "false", Description: "Keep node_modules in the droplet after assets are compiled"},
+	{Name: "BP_PROFILE", Kind: String, Default: "", Description: "Name of the install profile from config/ruby-buildpack.yml to stage with"},
+	{Name: "BP_PREBUILT_GEM_CACHE", Kind: String, Default: "", Description: "URL of an operator run cache of compiled native gems, used when the buildpack includes the key its index is signed with"},
+	{Name: "BP_PREBUILT_GEM_CACHE_UPLOAD", Kind: Bool, Default: "false", Description: "Upload the native gems compiled while staging to BP_PREBUILT_GEM_CACHE for the operator to sign into its index"},
+	{Name: "BP_PREBUILT_GEM_CACHE_TOKEN", Kind: String, Default: "", Description: "Bearer token BP_PREBUILT_GEM_CACHE_UPLOAD authenticates with", Secret: true},
+	{Name: "BP_GEMFILE_NEXT", Kind: Bool, Default: "false", Description: "Stage dual boot apps with Gemfile_next or Gemfile.next instead of Gemfile"},
+	{Name: "BP_GEM_FALLBACK_SOURCES", Kind: String, Default: "", Description: "Comma separated gem sources tried in order for each gem bundler fails to download"},
+	{Name: "BP_REPORT_SIGNING_SECRET", Kind: String, Default: "", Description: "Secret the digest of the droplet contents in the staging report is signed with", Secret: true},
+	{Name: "BP_REPORT_OUTDATED", Kind: Bool, Default: "false", Description: "List outdated and vulnerable gems from the gem index snapshot shipped in the buildpack"},
+	{Name: "BP_NATIVE_EXTENSION_RETRY", Kind: Bool, Default: "true", Description: "Compile a gem whose native extension failed again on its own with -j1 before failing staging"},
+	{Name: "BP_SYSTEM_LIBRARY_CHECK", Kind: Bool, Default: "true", Description: "Check the rootfs for the libraries of known native gems before bundle install"},
+	{Name: "BP_EXEC_SANDBOX", Kind: Bool, Default: "false", Description: "Run bundler, rake, yarn and node with only an allowlisted environment"},
+	{Name: "BP_EXEC_ENV_ALLOW", Kind: String, Default: "", Description: "Comma separated names, or prefixes ending in *, passed through by BP_EXEC_SANDBOX as well"},
+	{Name: "BP_MANIFEST_MAX_AGE", Kind: Days, Default: "180", Description: "Warn when the buildpack was packaged more days ago than this, 0 turns the warning off"},
+	{Name: "BP_TELEMETRY_OPT_OUT", Kind: Bool, Default: "false", Description: "Do not send the anonymized dependency usage an operator packaged telemetry.yml asks for"},
+	{Name: "BP_LOCALE", Kind: String, Default: "C.UTF-8", Description: "Locale LANG and LC_ALL default to during staging and at runtime"},
+	{Name: "BP_ALLOW_HTTP_GEM_SOURCES", Kind: Bool, Default: "false", Description: "Only warn, rather than fail staging, when the Gemfile or Gemfile.lock fetch gems over plain http"},
+	{Name: "BP_ADDITIONAL_RUBY", Kind: String, Default: "", Description: "Version of a second ruby, such as 3.1.x, installed with its own gems for BP_ADDITIONAL_RUBY_PROCESSES"},
+	{Name: "BP_ADDITIONAL_RUBY_PROCESSES", Kind: String, Default: "worker", Description: "Comma separated process types which run with BP_ADDITIONAL_RUBY"},
+	{Name: "BP_REPORT_GEM_CHECKSUMS", Kind: Bool, Default: "false", Description: "Record the sha256 of the installed gems in the staging report when Gemfile.lock has no CHECKSUMS section"},
+	{Name: "BP_ALLOW_PRIVATE_KEYS", Kind: Bool, Default: "false", Description: "Only warn, rather than fail staging, when the app contains private keys"},
+	{Name: "BP_CACHE_COMPRESSION", Kind: String, Default: "off", Description: "How the app cache stores each directory: off, gzip or auto, which only compresses gems and node packages"},
+	{Name: "BP_SQLITE_VOLUME", Kind: Bool, Default: "true", Description: "Point DATABASE_URL at a bound volume service when the production database is a sqlite3 file"},
+	{Name: "BP_STRICT_GIT_GEMS", Kind: Bool, Default: "true", Description: "Fail staging when the checkout of a git gem pinned by SHA differs from its locked revision"},
+	{Name: "BP_STRICT_SCHEDULES", Kind: Bool, Default: "false", Description: "Fail staging when a scheduled job runs a class or rake task which does not exist"},
+	{Name: "BP_FORCE_RUBY_PLATFORM", Kind: Bool, Default: "false", Description: "Compile gems the Gemfile.lock only has builds of for other platforms from source instead of failing staging"},
+	{Name: "BP_BUNDLE_PATH", Kind: String, Default: "", Description: "Absolute path bundler installs the gems to instead of the dep dir, such as a volume mounted on the cells; gems outside the droplet are not cached"},
+	{Name: "BP_GUARD_PROFILE_SCRIPTS", Kind: Bool, Default: "false", Description: "Run the app's .profile and .profile.d scripts at startup in a subshell with BP_PROFILE_TIMEOUT and their output prefixed with their names, only the variables they export are kept"},
+	{Name: "BP_PROFILE_TIMEOUT", Kind: Seconds, Default: "60", Description: "Seconds each of the app's .profile and .profile.d scripts may run at startup before it is skipped"},
+	{Name: "BP_WEB_CONCURRENCY", Kind: Bool, Default: "true", Description: "Default WEB_CONCURRENCY and RAILS_MAX_THREADS at startup from MEMORY_LIMIT and the CPUs of the instance"},
+	{Name: "BP_YARN_OFFLINE", Kind: Bool, Default: "false", Description: "Fail staging, rather than fetch from the registry, when the vendored node_modules or yarn offline mirror lack packages of yarn.lock"},
+	{Name: "BP_DATA_MIGRATIONS", Kind: Bool, Default: "false", Description: "Run the data migrations of after_party or data_migrate on the first instance before the web process starts"},
+	{Name: "BP_COMPRESS_ASSETS", Kind: Bool, Default: "false", Description: "Write .gz, and .br when brotli is on the stack, variants of the compiled assets which have none"},
+	{Name: "BP_PRUNE_ASSET_GEMS", Kind: Bool, Default: "false", Description: "Remove the gems only in the assets group, and node when no gem left needs it, from the droplet once the assets are compiled"},
+	{Name: "BP_RACK_HEALTHCHECK", Kind: String, Default: "", Description: "Path, such as /healthz, a generated wrapper of config.ru answers with 200 so bare Rack apps can use http health checks"},
+	{Name: "BP_BUNDLER_PLUGINS_OFFLINE", Kind: Bool, Default: "false", Description: "Fail staging, rather than fetch them, when bundler plugins of the Gemfile are neither cached nor vendored with path"},
+	{Name: "BP_RUBY_URL", Kind: String, Default: "", Description: "Unsupported: URL of a ruby archive, such as a ruby-head build, installed instead of a ruby of the manifest for testing the buildpack; needs BP_RUBY_SHA256"},
+	{Name: "BP_RUBY_SHA256", Kind: String, Default: "", Description: "sha256 of the archive at BP_RUBY_URL"},
+	{Name: "BP_REDACT_PATTERNS", Kind: String, Default: "", Description: "Comma separated regular expressions masked in the staging log"},
+}
+
+type FeatureFlags struct {
+	flags   map[string]Flag
+	values  map[string]string
+	invalid []string
+	unknown []string
+}
+
+func New(environ []string) *FeatureFlags {
+	f := &FeatureFlags{
+		flags:  map[string]Flag{},
+		values: map[string]string{},
+	}
+	for _, flag := range Flags {
+		f.flags[flag.Name] = flag
+	}
+
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "BP_") {
+			continue
+		}
+		name, value := parts[0], parts[1]
+
+		flag, found := f.flags[name]
+		if !found {
+			f.unknown = append(f.unknown, name)
+			continue
+		}
+		if err := parse(flag, value); err != nil {
+			f.invalid = append(f.invalid, err.Error())
+			continue
+		}
+		f.values[name] = value
+	}
+
+	sort.Strings(f.unknown)
+	sort.Strings(f.invalid)
+	return f
+}
+
+// Validate returns an error describing every flag set to an unparseable value
+func (f *FeatureFlags) Validate() error {
+	if len(f.invalid) > 0 {
+		return fmt.Errorf("%s", strings.Join(f.invalid, "; "))
+	}
+	return nil
+}
+
+// Unknown returns the BP_* variables which are set but not understood by the buildpack
+func (f *FeatureFlags) Unknown() []string {
+	return f.unknown
+}
+
+func (f *FeatureFlags) Bool(name string) bool {
+	val, _ := strconv.ParseBool(f.value(name, Bool))
+	return val
+}
+
+func (f *FeatureFlags) String(name string) string {
+	return f.value(name, String)
+}
+
+func (f *FeatureFlags) Duration(name string) time.Duration {
+	val, _ := strconv.Atoi(f.value(name, Seconds))
+	return time.Duration(val) * time.Second
+}
+
+func (f *FeatureFlags) Days(name string) int {
+	val, _ := strconv.Atoi(f.value(name, Days))
+	return val
+}
+
+// BoolOr lets an environment variable override a setting from the app's
+// config file, fallback is used when the flag is not set
+func (f *FeatureFlags) BoolOr(name string, fallback bool) bool {
+	if !f.IsSet(name) {
+		f.value(name, Bool)
+		return fallback
+	}
+	return f.Bool(name)
+}
+
+func (f *FeatureFlags) IsSet(name string) bool {
+	_, found := f.values[name]
+	return found
+}
+
+// List prints every flag with its default and current value, the value of a
+// set Secret flag is printed as ***
+func (f *FeatureFlags) List(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tDEFAULT\tVALUE\tDESCRIPTION")
+	for _, flag := range Flags {
+		value := "-"
+		if f.IsSet(flag.Name) && flag.Secret {
+			value = "***"
+		} else if f.IsSet(flag.Name) {
+			value = f.values[flag.Name]
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", flag.Name, flag.Kind, flag.Default, value, flag.Description)
+	}
+	tw.Flush()
+}
+
+func (f *FeatureFlags) value(name string, kind Kind) string {
+	flag, found := f.flags[name]
+	if !found {
+		panic(fmt.Sprintf("featureflags: %s is not a registered flag", name))
+	} else if flag.Kind != kind {
+		panic(fmt.Sprintf("featureflags: %s is a %s flag, not %s", name, flag.Kind, kind))
+	}
+	if val, found := f.values[name]; found {
+		return val
+	}
+	return flag.Default
+}
+
+func parse(flag Flag, value string) error {
+	switch flag.Kind {
+	case Bool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s must be true or false, got %s", flag.Name, value)
+		}
+	case Seconds:
+		if seconds, err := strconv.Atoi(value); err != nil || seconds <= 0 {
+			return fmt.Errorf("%s must be a positive number of seconds, got %s", flag.Name, value)
+		}
+	case Days:
+		if days, err := strconv.Atoi(value); err != nil || days < 0 {
+			return fmt.Errorf("%s must be a number of days, got %s", flag.Name, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package featureflags_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestFeatureflags(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Featureflags Suite")
+}
@@ -0,0 +1,114 @@
+package featureflags_test
+
+import (
+	"bytes"
+	"ruby/featureflags"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Featureflags", func() {
+	var flags *featureflags.FeatureFlags
+
+	Context("no flags are set", func() {
+		BeforeEach(func() {
+			flags = featureflags.New([]string{"PATH=/bin", "RAILS_ENV=production"})
+		})
+
+		It("returns the defaults", func() {
+			Expect(flags.Bool("BP_BOOT_CHECK")).To(BeFalse())
+			Expect(flags.Duration("BP_BOOT_CHECK_TIMEOUT")).To(Equal(10 * time.Second))
+			Expect(flags.String("BP_DEBUG")).To(Equal(""))
+			Expect(flags.IsSet("BP_BOOT_CHECK")).To(BeFalse())
+			Expect(flags.Days("BP_MANIFEST_MAX_AGE")).To(Equal(180))
+		})
+
+		It("validates", func() {
+			Expect(flags.Validate()).To(Succeed())
+			Expect(flags.Unknown()).To(BeEmpty())
+		})
+	})
+
+	Context("flags are set", func() {
+		BeforeEach(func() {
+			flags = featureflags.New([]string{"BP_BOOT_CHECK=1", "BP_BOOT_CHECK_TIMEOUT=3", "BP_DEBUG=yes", "BP_MANIFEST_MAX_AGE=0"})
+		})
+
+		It("parses the typed values", func() {
+			Expect(flags.Bool("BP_BOOT_CHECK")).To(BeTrue())
+			Expect(flags.Duration("BP_BOOT_CHECK_TIMEOUT")).To(Equal(3 * time.Second))
+			Expect(flags.String("BP_DEBUG")).To(Equal("yes"))
+			Expect(flags.Days("BP_MANIFEST_MAX_AGE")).To(Equal(0))
+			Expect(flags.IsSet("BP_BOOT_CHECK")).To(BeTrue())
+		})
+	})
+
+	Context("flags have invalid values", func() {
+		BeforeEach(func() {
+			flags = featureflags.New([]string{"BP_BOOT_CHECK=sometimes", "BP_BOOT_CHECK_TIMEOUT=soon"})
+		})
+
+		It("reports every invalid flag", func() {
+			Expect(flags.Validate()).To(MatchError("BP_BOOT_CHECK must be true or false, got sometimes; BP_BOOT_CHECK_TIMEOUT must be a positive number of seconds, got soon"))
+		})
+
+		It("falls back to the defaults", func() {
+			Expect(flags.Bool("BP_BOOT_CHECK")).To(BeFalse())
+			Expect(flags.Duration("BP_BOOT_CHECK_TIMEOUT")).To(Equal(10 * time.Second))
+		})
+	})
+
+	Context("unknown BP_ variables are set", func() {
+		BeforeEach(func() {
+			flags = featureflags.New([]string{"BP_BOOTCHECK=true", "BP_AAA=1"})
+		})
+
+		It("returns them sorted", func() {
+			Expect(flags.Unknown()).To(Equal([]string{"BP_AAA", "BP_BOOTCHECK"}))
+		})
+	})
+
+	Describe("BoolOr", func() {
+		It("returns the fallback when the flag is not set", func() {
+			flags = featureflags.New([]string{})
+			Expect(flags.BoolOr("BP_BOOT_CHECK", true)).To(BeTrue())
+		})
+
+		It("returns the flag when it is set", func() {
+			flags = featureflags.New([]string{"BP_BOOT_CHECK=false"})
+			Expect(flags.BoolOr("BP_BOOT_CHECK", true)).To(BeFalse())
+		})
+	})
+
+	It("panics when reading an unregistered flag", func() {
+		flags = featureflags.New([]string{})
+		Expect(func() { flags.Bool("BP_NOT_A_FLAG") }).To(Panic())
+	})
+
+	It("panics when reading a flag as the wrong type", func() {
+		flags = featureflags.New([]string{})
+		Expect(func() { flags.Bool("BP_BOOT_CHECK_TIMEOUT") }).To(Panic())
+	})
+
+	Describe("List", func() {
+		It("prints every flag with its default and current value", func() {
+			flags = featureflags.New([]string{"BP_BOOT_CHECK=true"})
+			buffer := new(bytes.Buffer)
+			flags.List(buffer)
+			Expect(buffer.String()).To(HavePrefix("NAME"))
+			Expect(buffer.String()).To(MatchRegexp(`BP_BOOT_CHECK\s+bool\s+false\s+true\s+Boot the web process`))
+			Expect(buffer.String()).To(MatchRegexp(`BP_BOOT_CHECK_TIMEOUT\s+seconds\s+10\s+-\s+How long`))
+		})
+
+		It("masks the values of secret flags", func() {
+			flags = featureflags.New([]string{"BP_PREBUILT_GEM_CACHE_TOKEN=t0ken", "BP_REPORT_SIGNING_SECRET=s3cr3t"})
+			buffer := new(bytes.Buffer)
+			flags.List(buffer)
+			Expect(buffer.String()).ToNot(ContainSubstring("t0ken"))
+			Expect(buffer.String()).ToNot(ContainSubstring("s3cr3t"))
+			Expect(buffer.String()).To(MatchRegexp(`BP_PREBUILT_GEM_CACHE_TOKEN\s+string\s+\*\*\*\s+Bearer token`))
+		})
+	})
+})
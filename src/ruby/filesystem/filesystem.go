@@ -0,0 +1,70 @@
+// Package filesystem is the file access the supply and finalize phases go
+// through. OS is the real filesystem; Mem keeps the files in memory, so a
+// unit test can give a phase an app and a deps dir without temp dirs and
+// check what it wrote without touching the disk. Code which shells out or
+// relies on libbuildpack helpers still needs the real filesystem.
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+type FS interface {
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	Stat(path string) (os.FileInfo, error)
+	ReadDir(dir string) ([]os.FileInfo, error)
+	Glob(pattern string) ([]string, error)
+	Rename(oldpath, newpath string) error
+	Remove(path string) error
+	RemoveAll(path string) error
+}
+
+// Exists is libbuildpack.FileExists for fs
+func Exists(fs FS, path string) (bool, error) {
+	if _, err := fs.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CopyFile copies source to target in fs with the mode of source
+func CopyFile(fs FS, source, target string) error {
+	info, err := fs.Stat(source)
+	if err != nil {
+		return err
+	}
+	data, err := fs.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	if err := fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return fs.WriteFile(target, data, info.Mode())
+}
+
+// OS is the real filesystem
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) ReadFile(path string) ([]byte, error) { return ioutil.ReadFile(path) }
+
+func (osFS) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(path, data, perm)
+}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+func (osFS) Stat(path string) (os.FileInfo, error)        { return os.Stat(path) }
+func (osFS) Glob(pattern string) ([]string, error)        { return filepath.Glob(pattern) }
+func (osFS) Rename(oldpath, newpath string) error         { return os.Rename(oldpath, newpath) }
+func (osFS) Remove(path string) error                     { return os.Remove(path) }
+func (osFS) RemoveAll(path string) error                  { return os.RemoveAll(path) }
+
+func (osFS) ReadDir(dir string) ([]os.FileInfo, error) { return ioutil.ReadDir(dir) }
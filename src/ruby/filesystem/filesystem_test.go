@@ -0,0 +1,121 @@
+package filesystem_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/filesystem"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Both filesystems run the same specs, Mem has to behave like the disk for
+// tests against it to mean anything
+var _ = Describe("Filesystem", func() {
+	for _, kind := range []string{"OS", "Mem"} {
+		kind := kind
+
+		Context(kind, func() {
+			var (
+				fs   filesystem.FS
+				root string
+			)
+
+			BeforeEach(func() {
+				var err error
+				root, err = ioutil.TempDir("", "ruby-buildpack.filesystem.")
+				Expect(err).To(BeNil())
+				fs = filesystem.OS
+				if kind == "Mem" {
+					fs = filesystem.NewMem()
+					Expect(fs.MkdirAll(root, 0755)).To(Succeed())
+				}
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(root)).To(Succeed())
+			})
+
+			path := func(elem ...string) string {
+				return filepath.Join(append([]string{root}, elem...)...)
+			}
+
+			It("reads the files it writes", func() {
+				Expect(fs.WriteFile(path("Gemfile"), []byte("source 'https://rubygems.org'\n"), 0644)).To(Succeed())
+				Expect(fs.ReadFile(path("Gemfile"))).To(Equal([]byte("source 'https://rubygems.org'\n")))
+
+				info, err := fs.Stat(path("Gemfile"))
+				Expect(err).To(BeNil())
+				Expect(info.Name()).To(Equal("Gemfile"))
+				Expect(info.Size()).To(Equal(int64(30)))
+				Expect(info.IsDir()).To(BeFalse())
+			})
+
+			It("only writes into existing directories", func() {
+				err := fs.WriteFile(path("config", "database.yml"), []byte{}, 0644)
+				Expect(os.IsNotExist(err)).To(BeTrue())
+
+				Expect(fs.MkdirAll(path("config"), 0755)).To(Succeed())
+				Expect(fs.WriteFile(path("config", "database.yml"), []byte{}, 0644)).To(Succeed())
+				Expect(filesystem.Exists(fs, path("config"))).To(BeTrue())
+			})
+
+			It("reports missing files", func() {
+				_, err := fs.ReadFile(path("Procfile"))
+				Expect(os.IsNotExist(err)).To(BeTrue())
+				_, err = fs.Stat(path("Procfile"))
+				Expect(os.IsNotExist(err)).To(BeTrue())
+				Expect(filesystem.Exists(fs, path("Procfile"))).To(BeFalse())
+			})
+
+			It("lists and globs directories in order", func() {
+				Expect(fs.MkdirAll(path("bin", "sub"), 0755)).To(Succeed())
+				for _, name := range []string{"rake", "bundle", "rails"} {
+					Expect(fs.WriteFile(path("bin", name), []byte(name), 0755)).To(Succeed())
+				}
+
+				infos, err := fs.ReadDir(path("bin"))
+				Expect(err).To(BeNil())
+				var names []string
+				for _, info := range infos {
+					names = append(names, info.Name())
+				}
+				Expect(names).To(Equal([]string{"bundle", "rails", "rake", "sub"}))
+
+				Expect(fs.Glob(path("bin", "ra*"))).To(Equal([]string{path("bin", "rails"), path("bin", "rake")}))
+				Expect(fs.Glob(path("*", "bundle"))).To(Equal([]string{path("bin", "bundle")}))
+			})
+
+			It("renames directories with their contents", func() {
+				Expect(fs.MkdirAll(path("deps", ".bundle"), 0755)).To(Succeed())
+				Expect(fs.WriteFile(path("deps", ".bundle", "config"), []byte("---\n"), 0644)).To(Succeed())
+				Expect(fs.Rename(path("deps"), path("app"))).To(Succeed())
+
+				Expect(fs.ReadFile(path("app", ".bundle", "config"))).To(Equal([]byte("---\n")))
+				Expect(filesystem.Exists(fs, path("deps"))).To(BeFalse())
+			})
+
+			It("removes files and trees", func() {
+				Expect(fs.MkdirAll(path("tmp", "cache"), 0755)).To(Succeed())
+				Expect(fs.WriteFile(path("tmp", "cache", "assets"), []byte{}, 0644)).To(Succeed())
+				Expect(fs.Remove(path("tmp"))).ToNot(Succeed())
+
+				Expect(fs.Remove(path("tmp", "cache", "assets"))).To(Succeed())
+				Expect(fs.RemoveAll(path("tmp"))).To(Succeed())
+				Expect(filesystem.Exists(fs, path("tmp"))).To(BeFalse())
+				Expect(fs.RemoveAll(path("tmp"))).To(Succeed())
+			})
+
+			It("copies files with their mode", func() {
+				Expect(fs.WriteFile(path("rails"), []byte("#!/usr/bin/env ruby\n"), 0755)).To(Succeed())
+				Expect(filesystem.CopyFile(fs, path("rails"), path("bin", "rails"))).To(Succeed())
+
+				info, err := fs.Stat(path("bin", "rails"))
+				Expect(err).To(BeNil())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+				Expect(fs.ReadFile(path("bin", "rails"))).To(Equal([]byte("#!/usr/bin/env ruby\n")))
+			})
+		})
+	}
+})
@@ -0,0 +1,209 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The errors the real filesystem returns for the same mistakes
+var (
+	errIsDir    error = syscall.EISDIR
+	errNotDir   error = syscall.ENOTDIR
+	errNotEmpty error = syscall.ENOTEMPTY
+)
+
+// Mem is an FS in memory. Paths are cleaned and made absolute against /,
+// which always exists. Like the real filesystem, files can only be written
+// into existing directories.
+type Mem struct {
+	mu      sync.Mutex
+	entries map[string]*memEntry
+}
+
+type memEntry struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func NewMem() *Mem {
+	return &Mem{entries: map[string]*memEntry{"/": {mode: os.ModeDir | 0755}}}
+}
+
+func clean(path string) string {
+	return filepath.Join("/", path)
+}
+
+func notExist(op, path string) error {
+	return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+}
+
+// under reports whether path is dir or below it
+func under(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+func (m *Mem) ReadFile(path string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, found := m.entries[clean(path)]
+	if !found {
+		return nil, notExist("open", path)
+	} else if entry.mode.IsDir() {
+		return nil, &os.PathError{Op: "read", Path: path, Err: errIsDir}
+	}
+	return append([]byte{}, entry.data...), nil
+}
+
+func (m *Mem) WriteFile(path string, data []byte, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name := clean(path)
+	if parent, found := m.entries[filepath.Dir(name)]; !found || !parent.mode.IsDir() {
+		return notExist("open", path)
+	}
+	if entry, found := m.entries[name]; found {
+		if entry.mode.IsDir() {
+			return &os.PathError{Op: "open", Path: path, Err: errIsDir}
+		}
+		entry.data, entry.modTime = append([]byte{}, data...), time.Now()
+		return nil
+	}
+	m.entries[name] = &memEntry{data: append([]byte{}, data...), mode: perm & os.ModePerm, modTime: time.Now()}
+	return nil
+}
+
+func (m *Mem) MkdirAll(path string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for dir := clean(path); ; dir = filepath.Dir(dir) {
+		if entry, found := m.entries[dir]; found {
+			if !entry.mode.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: dir, Err: errNotDir}
+			}
+		} else {
+			m.entries[dir] = &memEntry{mode: os.ModeDir | perm&os.ModePerm, modTime: time.Now()}
+		}
+		if dir == "/" {
+			return nil
+		}
+	}
+}
+
+func (m *Mem) Stat(path string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name := clean(path)
+	entry, found := m.entries[name]
+	if !found {
+		return nil, notExist("stat", path)
+	}
+	return memInfo{name: filepath.Base(name), entry: entry}, nil
+}
+
+func (m *Mem) ReadDir(dir string) ([]os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name := clean(dir)
+	if entry, found := m.entries[name]; !found {
+		return nil, notExist("open", dir)
+	} else if !entry.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdirent", Path: dir, Err: errNotDir}
+	}
+
+	var infos []os.FileInfo
+	for path, entry := range m.entries {
+		if path != "/" && filepath.Dir(path) == name {
+			infos = append(infos, memInfo{name: filepath.Base(path), entry: entry})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// Glob matches pattern like filepath.Glob, malformed patterns are an error
+func (m *Mem) Glob(pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var matches []string
+	for path := range m.entries {
+		if matched, _ := filepath.Match(clean(pattern), path); matched {
+			matches = append(matches, path)
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func (m *Mem) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	from, to := clean(oldpath), clean(newpath)
+	if _, found := m.entries[from]; !found {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if parent, found := m.entries[filepath.Dir(to)]; !found || !parent.mode.IsDir() {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	moved := map[string]*memEntry{}
+	for path, entry := range m.entries {
+		if under(path, from) {
+			moved[to+strings.TrimPrefix(path, from)] = entry
+			delete(m.entries, path)
+		} else if under(path, to) {
+			delete(m.entries, path)
+		}
+	}
+	for path, entry := range moved {
+		m.entries[path] = entry
+	}
+	return nil
+}
+
+func (m *Mem) Remove(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name := clean(path)
+	if _, found := m.entries[name]; !found {
+		return notExist("remove", path)
+	}
+	for other := range m.entries {
+		if other != name && under(other, name) {
+			return &os.PathError{Op: "remove", Path: path, Err: errNotEmpty}
+		}
+	}
+	delete(m.entries, name)
+	return nil
+}
+
+func (m *Mem) RemoveAll(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name := clean(path)
+	for other := range m.entries {
+		if other != "/" && under(other, name) {
+			delete(m.entries, other)
+		}
+	}
+	return nil
+}
+
+type memInfo struct {
+	name  string
+	entry *memEntry
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return int64(len(i.entry.data)) }
+func (i memInfo) Mode() os.FileMode  { return i.entry.mode }
+func (i memInfo) ModTime() time.Time { return i.entry.modTime }
+func (i memInfo) IsDir() bool        { return i.entry.mode.IsDir() }
+func (i memInfo) Sys() interface{}   { return nil }
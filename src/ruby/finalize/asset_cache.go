@@ -0,0 +1,242 @@
+package finalize
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/cache"
+	"ruby/depsdir"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// assetCache is where sprockets caches what it compiled, in the app
+var assetCache = filepath.Join("tmp", "cache", "assets")
+
+// The compiled assets are made from the whole app but for
+// assetSourcesIgnored, which staging, the app or its tools write to, and
+// from the assetSourceEnv variables and those starting with
+// assetSourceEnvPrefixes, which change them
+var (
+	assetSourcesIgnored    = []string{".git", ".bundle", ".ruby-buildpack", "log", "tmp", "node_modules", "vendor/bundle", "public/assets", "public/packs"}
+	assetSourceEnv         = []string{"RAILS_ENV", "RACK_ENV", "NODE_ENV", "BABEL_ENV", "NODE_OPTIONS", "RAILS_GROUPS", "RAILS_RELATIVE_URL_ROOT", "ASSET_HOST", "CDN_HOST"}
+	assetSourceEnvPrefixes = []string{"WEBPACKER_", "SHAKAPACKER_", "VITE_", "REACT_APP_"}
+)
+
+// compiledAssets are the directories of public assets:precompile writes
+var compiledAssets = []string{filepath.Join("public", "assets"), filepath.Join("public", "packs")}
+
+// compiledAssetsSources is the file in CompiledAssets holding the digest of
+// the sources they were compiled from
+const compiledAssetsSources = "sources.sha256"
+
+// assetSourcesDigest is a sha256 over the paths and contents of the files
+// of the app outside assetSourcesIgnored, and the names and values of the
+// asset source variables, which it returns too. Unlike the mtimes sprockets
+// and webpacker go by, it is the same for an app package built again by CI
+// from the same commit.
+func (f *Finalizer) assetSourcesDigest() (string, []string, error) {
+	ignored := map[string]bool{}
+	for _, name := range assetSourcesIgnored {
+		ignored[name] = true
+	}
+
+	digest := sha256.New()
+	var env []string
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if assetSourceVariable(name) {
+			env = append(env, name)
+		}
+	}
+	sort.Strings(env)
+	for _, name := range env {
+		fmt.Fprintf(digest, "%s=%s\x00", name, os.Getenv(name))
+	}
+
+	err := filepath.Walk(f.Stager.BuildDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(f.Stager.BuildDir(), path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if ignored[rel] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		fmt.Fprintf(digest, "%s\x00", rel)
+		return hashFile(digest, path)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), env, nil
+}
+
+func assetSourceVariable(name string) bool {
+	for _, source := range assetSourceEnv {
+		if name == source {
+			return true
+		}
+	}
+	for _, prefix := range assetSourceEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashFile writes the sha256 of the file at path to digest. A link to a
+// file is followed, any other link is hashed as its target.
+func hashFile(digest io.Writer, path string) error {
+	if info, err := os.Stat(path); os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(digest, "-> %s\x00", target)
+		return err
+	} else if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(digest, "%x\x00", hash.Sum(nil))
+	return err
+}
+
+// reuseCompiledAssets copies the assets compiled by an earlier staging from
+// the app cache into public when they were compiled from sources with the
+// same digest. Assets the app was pushed with are never overwritten.
+func (f *Finalizer) reuseCompiledAssets(digest string) (bool, error) {
+	dir := f.deps().Join(depsdir.CompiledAssets)
+	cached, err := ioutil.ReadFile(filepath.Join(dir, compiledAssetsSources))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if strings.TrimSpace(string(cached)) != digest {
+		f.Log.Info("The asset sources changed since the assets were cached, compiling them")
+		return false, nil
+	}
+	for _, compiled := range compiledAssets {
+		if exists, err := libbuildpack.FileExists(filepath.Join(f.Stager.BuildDir(), compiled)); err != nil || exists {
+			return false, err
+		}
+	}
+	for _, compiled := range compiledAssets {
+		if exists, err := libbuildpack.FileExists(filepath.Join(dir, compiled)); err != nil {
+			return false, err
+		} else if exists {
+			if err := copyTree(filepath.Join(dir, compiled), filepath.Join(f.Stager.BuildDir(), compiled)); err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// cacheCompiledAssets copies public/assets and public/packs to
+// CompiledAssets with the digest of their sources, for SaveAssetCaches to
+// save to the app cache
+func (f *Finalizer) cacheCompiledAssets(digest string) error {
+	dir := f.deps().Join(depsdir.CompiledAssets)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	for _, compiled := range compiledAssets {
+		if exists, err := libbuildpack.FileExists(filepath.Join(f.Stager.BuildDir(), compiled)); err != nil {
+			return err
+		} else if exists {
+			if err := copyTree(filepath.Join(f.Stager.BuildDir(), compiled), filepath.Join(dir, compiled)); err != nil {
+				return err
+			}
+		}
+	}
+	if exists, err := libbuildpack.FileExists(dir); err != nil || !exists {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, compiledAssetsSources), []byte(digest+"\n"), 0644)
+}
+
+// restoreAssetCache moves the sprockets cache restored from the app cache
+// into the app, unless the app was pushed with its own. It returns
+// whether sprockets starts warm.
+func (f *Finalizer) restoreAssetCache() (bool, error) {
+	appCache := filepath.Join(f.Stager.BuildDir(), assetCache)
+	if exists, err := libbuildpack.FileExists(appCache); err != nil {
+		return false, err
+	} else if exists {
+		f.appAssetCache = true
+		return true, nil
+	}
+
+	restored := f.deps().Join(depsdir.AssetsCache)
+	if exists, err := libbuildpack.FileExists(restored); err != nil || !exists {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(appCache), 0755); err != nil {
+		return false, err
+	}
+	return true, os.Rename(restored, appCache)
+}
+
+func copyTree(src, dest string) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	return libbuildpack.CopyDirectory(src, dest)
+}
+
+// SaveAssetCaches saves the yarn cache and the sprockets cache filled by
+// PrecompileAssets back to the app cache, next to what supply saved.
+// Neither is needed at runtime, so both are left out of the droplet, except
+// for a sprockets cache the app was pushed with, which is not cached.
+func (f *Finalizer) SaveAssetCaches() error {
+	if !f.appAssetCache {
+		appCache := filepath.Join(f.Stager.BuildDir(), assetCache)
+		if exists, err := libbuildpack.FileExists(appCache); err != nil {
+			return err
+		} else if exists {
+			if err := os.RemoveAll(f.deps().Join(depsdir.AssetsCache)); err != nil {
+				return err
+			}
+			if err := os.Rename(appCache, f.deps().Join(depsdir.AssetsCache)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := f.Cache.SaveAssets(); err != nil {
+		return err
+	}
+	for _, name := range cache.CachedAssets {
+		if err := os.RemoveAll(f.deps().Join(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/config"
+	"ruby/featureflags"
+	"ruby/finalize"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("asset caches", func() {
+	var (
+		err         error
+		buildDir    string
+		depsDir     string
+		finalizer   *finalize.Finalizer
+		mockCtrl    *gomock.Controller
+		mockCommand *MockCommand
+		mockCache   *MockCache
+		precompile  *exec.Cmd
+		warm        bool
+		compiled    string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		logger := libbuildpack.NewLogger(ansicleaner.New(new(bytes.Buffer)))
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockCommand = NewMockCommand(mockCtrl)
+		mockCache = NewMockCache(mockCtrl)
+		mockVersions := NewMockVersions(mockCtrl)
+		mockVersions.EXPECT().HasGem(gomock.Any()).AnyTimes().Return(false, nil)
+		precompile = nil
+		warm = false
+		compiled = ""
+		mockCommand.EXPECT().Run(gomock.Any()).AnyTimes().DoAndReturn(func(cmd *exec.Cmd) error {
+			if cmd.Args[len(cmd.Args)-1] == "assets:precompile" && cmd.Args[len(cmd.Args)-2] != "-n" {
+				precompile = cmd
+				warm, err = libbuildpack.FileExists(filepath.Join(buildDir, "tmp", "cache", "assets", "sprockets"))
+				Expect(err).ToNot(HaveOccurred())
+				if compiled != "" {
+					Expect(os.MkdirAll(filepath.Join(buildDir, "public", "assets"), 0755)).To(Succeed())
+					Expect(ioutil.WriteFile(filepath.Join(buildDir, "public", "assets", compiled), []byte("compiled"), 0644)).To(Succeed())
+				}
+			}
+			return nil
+		})
+
+		finalizer = &finalize.Finalizer{
+			Stager:       libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Versions:     mockVersions,
+			Command:      mockCommand,
+			Cache:        mockCache,
+			Log:          logger,
+			Flags:        featureflags.New([]string{}),
+			Config:       &config.Config{},
+			RailsVersion: 5,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("points yarn at the cached yarn cache", func() {
+		Expect(finalizer.PrecompileAssets()).To(Succeed())
+		Expect(precompile).ToNot(BeNil())
+		Expect(precompile.Env).To(ContainElement("YARN_CACHE_FOLDER=" + filepath.Join(depsDir, "0", "yarn_cache")))
+	})
+
+	Context("the sprockets cache was restored from the app cache", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0", "assets_cache", "sprockets"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0", "yarn_cache", "v6"), 0755)).To(Succeed())
+		})
+
+		It("compiles the assets from it and caches it again without leaving it in the droplet", func() {
+			Expect(finalizer.PrecompileAssets()).To(Succeed())
+			Expect(warm).To(BeTrue())
+
+			mockCache.EXPECT().SaveAssets().Do(func() {
+				Expect(filepath.Join(depsDir, "0", "assets_cache", "sprockets")).To(BeADirectory())
+				Expect(filepath.Join(depsDir, "0", "yarn_cache", "v6")).To(BeADirectory())
+			})
+			Expect(finalizer.SaveAssetCaches()).To(Succeed())
+
+			Expect(filepath.Join(buildDir, "tmp", "cache", "assets")).ToNot(BeADirectory())
+			Expect(filepath.Join(depsDir, "0", "assets_cache")).ToNot(BeADirectory())
+			Expect(filepath.Join(depsDir, "0", "yarn_cache")).ToNot(BeADirectory())
+		})
+
+		Context("the app was pushed with its own sprockets cache", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(buildDir, "tmp", "cache", "assets", "pushed"), 0755)).To(Succeed())
+			})
+
+			It("compiles the assets from the pushed cache and leaves it in the app", func() {
+				Expect(finalizer.PrecompileAssets()).To(Succeed())
+				Expect(warm).To(BeFalse())
+
+				mockCache.EXPECT().SaveAssets()
+				Expect(finalizer.SaveAssetCaches()).To(Succeed())
+
+				Expect(filepath.Join(buildDir, "tmp", "cache", "assets", "pushed")).To(BeADirectory())
+				Expect(filepath.Join(depsDir, "0", "assets_cache")).ToNot(BeADirectory())
+			})
+		})
+	})
+
+	Context("the assets are compiled from sources", func() {
+		var cachedDir string
+
+		BeforeEach(func() {
+			cachedDir = filepath.Join(depsDir, "0", "compiled_assets")
+			Expect(os.MkdirAll(filepath.Join(buildDir, "app", "assets", "javascripts"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "app", "assets", "javascripts", "application.js"), []byte("alert(1)"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "yarn.lock"), []byte("# yarn lockfile v1"), 0644)).To(Succeed())
+			compiled = "application-0123.js"
+		})
+
+		// stageAgain moves what the staging saved back into the dep dir, as
+		// RestoreAssets does, and clears public for the next staging
+		stageAgain := func() {
+			mockCache.EXPECT().SaveAssets().Do(func() {
+				Expect(os.Rename(cachedDir, filepath.Join(depsDir, "compiled_assets"))).To(Succeed())
+			})
+			Expect(finalizer.SaveAssetCaches()).To(Succeed())
+			Expect(os.Rename(filepath.Join(depsDir, "compiled_assets"), cachedDir)).To(Succeed())
+			Expect(os.RemoveAll(filepath.Join(buildDir, "public"))).To(Succeed())
+			precompile = nil
+		}
+
+		It("caches them with the digest of their sources", func() {
+			Expect(finalizer.PrecompileAssets()).To(Succeed())
+			Expect(precompile).ToNot(BeNil())
+			Expect(filepath.Join(cachedDir, "public", "assets", "application-0123.js")).To(BeAnExistingFile())
+			Expect(ioutil.ReadFile(filepath.Join(cachedDir, "sources.sha256"))).To(MatchRegexp(`^[0-9a-f]{64}\n$`))
+		})
+
+		It("reuses them for the same sources, however fresh their mtimes", func() {
+			Expect(finalizer.PrecompileAssets()).To(Succeed())
+			stageAgain()
+
+			later := time.Now().Add(time.Hour)
+			Expect(os.Chtimes(filepath.Join(buildDir, "app", "assets", "javascripts", "application.js"), later, later)).To(Succeed())
+			Expect(finalizer.PrecompileAssets()).To(Succeed())
+			Expect(precompile).To(BeNil())
+			Expect(ioutil.ReadFile(filepath.Join(buildDir, "public", "assets", "application-0123.js"))).To(Equal([]byte("compiled")))
+		})
+
+		It("compiles them again when a source changed", func() {
+			Expect(finalizer.PrecompileAssets()).To(Succeed())
+			stageAgain()
+
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "yarn.lock"), []byte("# yarn lockfile v1\nleft-pad@1.3.0"), 0644)).To(Succeed())
+			compiled = "application-4567.js"
+			Expect(finalizer.PrecompileAssets()).To(Succeed())
+			Expect(precompile).ToNot(BeNil())
+			Expect(filepath.Join(buildDir, "public", "assets", "application-0123.js")).ToNot(BeAnExistingFile())
+			Expect(filepath.Join(cachedDir, "public", "assets", "application-4567.js")).To(BeAnExistingFile())
+		})
+
+		It("compiles them again when any file of the app or an asset variable changed", func() {
+			defer os.Unsetenv("VITE_API_URL")
+			for _, change := range []func(){
+				func() {
+					Expect(os.MkdirAll(filepath.Join(buildDir, "config", "webpack"), 0755)).To(Succeed())
+					Expect(ioutil.WriteFile(filepath.Join(buildDir, "config", "webpack", "production.js"), []byte("module.exports = {}"), 0644)).To(Succeed())
+				},
+				func() {
+					Expect(ioutil.WriteFile(filepath.Join(buildDir, "tailwind.config.js"), []byte("module.exports = {}"), 0644)).To(Succeed())
+				},
+				func() {
+					Expect(os.Setenv("VITE_API_URL", "https://api.example.com")).To(Succeed())
+				},
+			} {
+				Expect(finalizer.PrecompileAssets()).To(Succeed())
+				stageAgain()
+				change()
+				Expect(finalizer.PrecompileAssets()).To(Succeed())
+				Expect(precompile).ToNot(BeNil())
+				stageAgain()
+			}
+		})
+
+		It("reuses them when only logs, temporary files or node_modules changed", func() {
+			Expect(finalizer.PrecompileAssets()).To(Succeed())
+			stageAgain()
+
+			for _, dir := range []string{"log", "tmp", "node_modules"} {
+				Expect(os.MkdirAll(filepath.Join(buildDir, dir), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, dir, "changed"), []byte("changed"), 0644)).To(Succeed())
+			}
+			Expect(finalizer.PrecompileAssets()).To(Succeed())
+			Expect(precompile).To(BeNil())
+		})
+
+		It("compiles them when the app was pushed with compiled assets of its own", func() {
+			Expect(finalizer.PrecompileAssets()).To(Succeed())
+			stageAgain()
+
+			Expect(os.MkdirAll(filepath.Join(buildDir, "public", "packs"), 0755)).To(Succeed())
+			Expect(finalizer.PrecompileAssets()).To(Succeed())
+			Expect(precompile).ToNot(BeNil())
+		})
+	})
+})
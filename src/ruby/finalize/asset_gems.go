@@ -0,0 +1,226 @@
+package finalize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"ruby/depsdir"
+	"ruby/lockfile"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// assetGroup is the Gemfile group of the gems only needed to compile assets
+const assetGroup = "assets"
+
+// knownAssetGems are only needed to compile assets, but Rails 4 and later
+// Gemfiles list them in the default group where they can not be pruned
+var knownAssetGems = []string{"sass-rails", "sassc-rails", "uglifier", "terser", "coffee-rails", "jsbundling-rails", "cssbundling-rails"}
+
+var bundleConfigWithout = regexp.MustCompile(`(?m)^(BUNDLE_WITHOUT: )"?([^"\n]*)"?$`)
+var profileBundleWithout = regexp.MustCompile(`(?m)^(bundle config WITHOUT )"([^"]*)"`)
+
+// PruneAssetGems removes the gems only in the assets group from the droplet
+// once the assets are compiled, with BP_PRUNE_ASSET_GEMS or
+// assets.prune_gems, and adds the group to the WITHOUT of the runtime
+// bundler config so bundler does not look for them. The node and yarn the
+// buildpack installed go as well when no gem left needs execjs. Like Heroku,
+// gems shared with other groups are kept.
+func (f *Finalizer) PruneAssetGems() error {
+	if !f.Flags.BoolOr("BP_PRUNE_ASSET_GEMS", f.Config.Assets.PruneGems) {
+		return nil
+	}
+	if compiled, err := f.assetsCompiled(); err != nil {
+		return err
+	} else if !compiled {
+		f.Log.Info("Keeping the asset gems, the app has no compiled assets")
+		return nil
+	}
+	if !f.bundlePath().InDroplet {
+		f.Log.Info("Keeping the asset gems, BP_BUNDLE_PATH is outside the droplet")
+		return nil
+	}
+
+	lockPath := filepath.Join(f.Stager.BuildDir(), gemfile()) + ".lock"
+	if exists, err := libbuildpack.FileExists(lockPath); err != nil || !exists {
+		return err
+	}
+	lock, err := lockfile.ParseInstallable(lockPath)
+	if err != nil {
+		return err
+	}
+	groups, err := f.Versions.GemGroups()
+	if err != nil {
+		return fmt.Errorf("could not determine the Gemfile groups of the gems: %v", err)
+	}
+
+	pruned := map[string]bool{}
+	var misplaced []string
+	for _, spec := range lock.Specs {
+		gemGroups := groups[spec.Name]
+		if len(gemGroups) > 0 && onlyGroup(gemGroups, assetGroup) {
+			pruned[spec.Name] = true
+		} else if containsString(knownAssetGems, spec.Name) {
+			misplaced = append(misplaced, spec.Name)
+		}
+	}
+	sort.Strings(misplaced)
+	if len(misplaced) > 0 {
+		f.Log.Warning("%s are only needed to compile assets, move them to the %s group of the Gemfile to prune them", strings.Join(dedupe(misplaced), ", "), assetGroup)
+	}
+	if len(pruned) == 0 {
+		return nil
+	}
+
+	var size int64
+	for _, name := range []string{"gems", filepath.Join("bundler", "gems"), "specifications", filepath.Join("extensions", "*", "*")} {
+		dirs, err := f.bundlePath().Glob(name, "*")
+		if err != nil {
+			return err
+		}
+		for _, dir := range dirs {
+			spec, found := specForDir(lock, strings.TrimSuffix(filepath.Base(dir), ".gemspec"))
+			if !found || !pruned[spec.Name] {
+				continue
+			}
+			n, err := pathSize(dir)
+			if err != nil {
+				return err
+			}
+			size += n
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+		}
+	}
+	var names []string
+	for name := range pruned {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	f.Log.BeginStep("Pruned %d asset gems (%d MB): %s", len(names), size>>20, strings.Join(names, ", "))
+
+	if err := f.withoutAssetGroup(); err != nil {
+		return err
+	}
+
+	if groups["execjs"] == nil || pruned["execjs"] {
+		return f.pruneNodeToolchain()
+	}
+	return nil
+}
+
+// withoutAssetGroup adds the assets group to the WITHOUT of the bundler
+// config in the app and of the one ruby.sh sets when an instance starts
+func (f *Finalizer) withoutAssetGroup() error {
+	for _, file := range []struct {
+		path    string
+		pattern *regexp.Regexp
+	}{
+		{filepath.Join(f.Stager.BuildDir(), ".bundle", "config"), bundleConfigWithout},
+		{f.deps().ProfileScript("ruby.sh"), profileBundleWithout},
+	} {
+		data, err := f.fs().ReadFile(file.path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		data = file.pattern.ReplaceAllFunc(data, func(match []byte) []byte {
+			parts := file.pattern.FindSubmatch(match)
+			without := string(parts[2])
+			if containsString(strings.Split(without, ":"), assetGroup) {
+				return match
+			} else if without != "" {
+				without += ":"
+			}
+			return []byte(fmt.Sprintf(`%s"%s%s"`, parts[1], without, assetGroup))
+		})
+		if err := f.fs().WriteFile(file.path, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneNodeToolchain removes the node and yarn the buildpack installed to
+// compile the assets, with their links in the bin of the dep dir
+func (f *Finalizer) pruneNodeToolchain() error {
+	var removed []string
+	for _, dir := range []string{depsdir.Node, depsdir.Yarn} {
+		path := f.deps().Join(dir)
+		if exists, err := libbuildpack.FileExists(path); err != nil {
+			return err
+		} else if !exists {
+			continue
+		}
+		links, err := filepath.Glob(filepath.Join(f.deps().Bin(), "*"))
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			target, err := os.Readlink(link)
+			if err != nil {
+				continue
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(link), target)
+			}
+			if strings.HasPrefix(filepath.Clean(target), path+string(filepath.Separator)) {
+				if err := os.Remove(link); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		removed = append(removed, dir)
+	}
+	if len(removed) > 0 {
+		f.Log.Info("Removed %s, no gem left needs a JavaScript runtime", strings.Join(removed, " and "))
+	}
+	return nil
+}
+
+func onlyGroup(groups []string, group string) bool {
+	for _, g := range groups {
+		if g != group {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func dedupe(list []string) []string {
+	var unique []string
+	for i, value := range list {
+		if i == 0 || list[i-1] != value {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+func pathSize(path string) (int64, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return info.Size(), nil
+	}
+	return dirSize(path)
+}
@@ -0,0 +1,136 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/config"
+	"ruby/featureflags"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PruneAssetGems", func() {
+	var (
+		err          error
+		buildDir     string
+		depsDir      string
+		depDir       string
+		gemDir       string
+		finalizer    *finalize.Finalizer
+		buffer       *bytes.Buffer
+		mockCtrl     *gomock.Controller
+		mockVersions *MockVersions
+		groups       map[string][]string
+	)
+
+	write := func(file, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(file), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(file, []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+		depDir = filepath.Join(depsDir, "0")
+		gemDir = filepath.Join(depDir, "vendor_bundle", "ruby", "2.5.0")
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockVersions = NewMockVersions(mockCtrl)
+		groups = map[string][]string{
+			"rails":      {"default"},
+			"sass":       {"assets"},
+			"sass-rails": {"assets"},
+			"uglifier":   {"default"},
+		}
+		mockVersions.EXPECT().GemGroups().AnyTimes().DoAndReturn(func() (map[string][]string, error) { return groups, nil })
+
+		finalizer = &finalize.Finalizer{
+			Stager:       libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Versions:     mockVersions,
+			Log:          logger,
+			Flags:        featureflags.New([]string{"BP_PRUNE_ASSET_GEMS=true"}),
+			Config:       &config.Config{},
+			RailsVersion: 5,
+		}
+
+		write(filepath.Join(buildDir, "Gemfile.lock"), "GEM\n  remote: https://rubygems.org/\n  specs:\n    rails (5.2.0)\n    sass (3.5.6)\n    sass-rails (5.0.7)\n      sass (~> 3.1)\n    uglifier (4.1.17)\n")
+		write(filepath.Join(buildDir, "public", "assets", ".sprockets-manifest-abc.json"), "{}")
+		write(filepath.Join(buildDir, ".bundle", "config"), "---\nBUNDLE_WITHOUT: \"development:test\"\n")
+		write(filepath.Join(depDir, "profile.d", "ruby.sh"), "bundle config PATH \"$DEPS_DIR/0/vendor_bundle\" > /dev/null\nbundle config WITHOUT \"development:test\" > /dev/null\n")
+		for _, gem := range []string{"rails-5.2.0", "sass-3.5.6", "sass-rails-5.0.7", "uglifier-4.1.17"} {
+			write(filepath.Join(gemDir, "gems", gem, "lib", "init.rb"), "")
+			write(filepath.Join(gemDir, "specifications", gem+".gemspec"), "")
+		}
+		write(filepath.Join(gemDir, "extensions", "x86_64-linux", "2.5.0", "sass-3.5.6", "gem.build_complete"), "")
+		write(filepath.Join(depDir, "node", "bin", "node"), "")
+		Expect(os.MkdirAll(filepath.Join(depDir, "bin"), 0755)).To(Succeed())
+		Expect(os.Symlink("../node/bin/node", filepath.Join(depDir, "bin", "node"))).To(Succeed())
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("does nothing unless it is turned on", func() {
+		finalizer.Flags = featureflags.New([]string{})
+		Expect(finalizer.PruneAssetGems()).To(Succeed())
+		Expect(filepath.Join(gemDir, "gems", "sass-rails-5.0.7")).To(BeADirectory())
+		Expect(buffer.String()).To(BeEmpty())
+	})
+
+	It("keeps the gems when no assets were compiled", func() {
+		Expect(os.RemoveAll(filepath.Join(buildDir, "public"))).To(Succeed())
+		Expect(finalizer.PruneAssetGems()).To(Succeed())
+		Expect(filepath.Join(gemDir, "gems", "sass-rails-5.0.7")).To(BeADirectory())
+		Expect(buffer.String()).To(ContainSubstring("Keeping the asset gems, the app has no compiled assets"))
+	})
+
+	It("removes the gems only in the assets group", func() {
+		Expect(finalizer.PruneAssetGems()).To(Succeed())
+
+		Expect(filepath.Join(gemDir, "gems", "sass-rails-5.0.7")).ToNot(BeAnExistingFile())
+		Expect(filepath.Join(gemDir, "gems", "sass-3.5.6")).ToNot(BeAnExistingFile())
+		Expect(filepath.Join(gemDir, "specifications", "sass-3.5.6.gemspec")).ToNot(BeAnExistingFile())
+		Expect(filepath.Join(gemDir, "extensions", "x86_64-linux", "2.5.0", "sass-3.5.6")).ToNot(BeAnExistingFile())
+		Expect(filepath.Join(gemDir, "gems", "rails-5.2.0")).To(BeADirectory())
+		Expect(filepath.Join(gemDir, "gems", "uglifier-4.1.17")).To(BeADirectory())
+		Expect(buffer.String()).To(ContainSubstring("Pruned 2 asset gems (0 MB): sass, sass-rails"))
+		Expect(buffer.String()).To(ContainSubstring("uglifier are only needed to compile assets, move them to the assets group"))
+	})
+
+	It("adds the assets group to the runtime bundler config", func() {
+		Expect(finalizer.PruneAssetGems()).To(Succeed())
+
+		Expect(ioutil.ReadFile(filepath.Join(buildDir, ".bundle", "config"))).To(ContainSubstring(`BUNDLE_WITHOUT: "development:test:assets"`))
+		Expect(ioutil.ReadFile(filepath.Join(depDir, "profile.d", "ruby.sh"))).To(ContainSubstring(`bundle config WITHOUT "development:test:assets" > /dev/null`))
+	})
+
+	It("removes node when no gem left needs execjs", func() {
+		Expect(finalizer.PruneAssetGems()).To(Succeed())
+
+		Expect(filepath.Join(depDir, "node")).ToNot(BeAnExistingFile())
+		_, err := os.Lstat(filepath.Join(depDir, "bin", "node"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		Expect(buffer.String()).To(ContainSubstring("Removed node, no gem left needs a JavaScript runtime"))
+	})
+
+	It("keeps node when a runtime gem needs execjs", func() {
+		groups["execjs"] = []string{"default", "assets"}
+		Expect(finalizer.PruneAssetGems()).To(Succeed())
+
+		Expect(filepath.Join(depDir, "node", "bin", "node")).To(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,119 @@
+package finalize
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// fingerprinted matches the digest sprockets puts in compiled asset names,
+// an MD5 for sprockets 2 and a SHA256 since sprockets 3
+var fingerprinted = regexp.MustCompile(`-([0-9a-f]{32}|[0-9a-f]{64})\.`)
+
+// CheckAssetFingerprints makes sure public/assets can be served: exactly one
+// sprockets manifest, and every asset it lists present. A partial precompile,
+// or public/assets pushed without its manifest, otherwise deploys fine and
+// serves 404s for assets once a blue/green cutover sends traffic to it.
+func (f *Finalizer) CheckAssetFingerprints() error {
+	assetsDir := filepath.Join(f.Stager.BuildDir(), "public", "assets")
+	if info, err := os.Stat(assetsDir); os.IsNotExist(err) || (err == nil && !info.IsDir()) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var manifests []string
+	for _, glob := range []string{".sprockets-manifest-*.json", "manifest-*.json", "manifest.yml"} {
+		matches, err := filepath.Glob(filepath.Join(assetsDir, glob))
+		if err != nil {
+			return err
+		}
+		manifests = append(manifests, matches...)
+	}
+
+	var problems []string
+	switch len(manifests) {
+	case 0:
+		count, err := countFingerprinted(assetsDir)
+		if err != nil {
+			return err
+		} else if count > 0 {
+			problems = append(problems, fmt.Sprintf("public/assets holds %d fingerprinted assets but no sprockets manifest", count))
+		}
+	case 1:
+		missing, err := missingAssets(assetsDir, manifests[0])
+		if err != nil {
+			return err
+		}
+		for _, asset := range missing {
+			problems = append(problems, fmt.Sprintf("%s lists %s, which is not in public/assets", filepath.Base(manifests[0]), asset))
+		}
+	default:
+		var names []string
+		for _, manifest := range manifests {
+			names = append(names, filepath.Base(manifest))
+		}
+		problems = append(problems, fmt.Sprintf("public/assets holds %d sprockets manifests, %s, and rails only reads one of them", len(names), strings.Join(names, ", ")))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	f.Log.Error("The compiled assets in public/assets are incomplete:\n  - %s\nRun `rake assets:clobber assets:precompile` and push all of public/assets, or leave public/assets out of the push so the buildpack precompiles the assets.", strings.Join(problems, "\n  - "))
+	return fmt.Errorf("public/assets does not match its sprockets manifest")
+}
+
+func countFingerprinted(assetsDir string) (int, error) {
+	count := 0
+	err := filepath.Walk(assetsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && fingerprinted.MatchString(info.Name()) && !strings.HasSuffix(info.Name(), ".gz") && !strings.HasSuffix(info.Name(), ".br") {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// missingAssets returns the compiled files the manifest maps logical paths
+// to which are not in assetsDir
+func missingAssets(assetsDir, manifest string) ([]string, error) {
+	data, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	assets := map[string]string{}
+	if filepath.Ext(manifest) == ".yml" {
+		err = yaml.Unmarshal(data, &assets)
+	} else {
+		var m struct {
+			Assets map[string]string `json:"assets"`
+		}
+		err = json.Unmarshal(data, &m)
+		assets = m.Assets
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", filepath.Base(manifest), err)
+	}
+
+	var missing []string
+	for _, compiled := range assets {
+		if _, err := os.Stat(filepath.Join(assetsDir, filepath.FromSlash(compiled))); os.IsNotExist(err) {
+			missing = append(missing, compiled)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
@@ -0,0 +1,91 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CheckAssetFingerprints", func() {
+	const (
+		digest   = "5f3d4c2b1a0987654321fedcba9876543210abcdef0123456789abcdef012345"
+		manifest = `{"files":{"application-` + digest + `.js":{}},"assets":{"application.js":"application-` + digest + `.js"}}`
+	)
+
+	var (
+		err       error
+		buildDir  string
+		assetsDir string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		assetsDir = filepath.Join(buildDir, "public", "assets")
+		Expect(os.MkdirAll(assetsDir, 0755)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	write := func(name, contents string) {
+		Expect(ioutil.WriteFile(filepath.Join(assetsDir, name), []byte(contents), 0644)).To(Succeed())
+	}
+
+	It("accepts assets which match their manifest", func() {
+		write(".sprockets-manifest-abc.json", manifest)
+		write("application-"+digest+".js", "")
+		write("application-"+digest+".js.gz", "")
+		Expect(finalizer.CheckAssetFingerprints()).To(Succeed())
+	})
+
+	It("accepts an app without public/assets", func() {
+		Expect(os.RemoveAll(assetsDir)).To(Succeed())
+		Expect(finalizer.CheckAssetFingerprints()).To(Succeed())
+	})
+
+	It("fails for fingerprinted assets without a manifest", func() {
+		write("application-"+digest+".js", "")
+		write("application-"+digest+".js.gz", "")
+		Expect(finalizer.CheckAssetFingerprints()).To(MatchError("public/assets does not match its sprockets manifest"))
+		Expect(buffer.String()).To(ContainSubstring("public/assets holds 1 fingerprinted assets but no sprockets manifest"))
+		Expect(buffer.String()).To(ContainSubstring("rake assets:clobber assets:precompile"))
+	})
+
+	It("fails when the manifest lists assets which are missing", func() {
+		write(".sprockets-manifest-abc.json", manifest)
+		Expect(finalizer.CheckAssetFingerprints()).To(HaveOccurred())
+		Expect(buffer.String()).To(ContainSubstring(".sprockets-manifest-abc.json lists application-" + digest + ".js, which is not in public/assets"))
+	})
+
+	It("fails for more than one manifest", func() {
+		write(".sprockets-manifest-abc.json", manifest)
+		write("manifest-def.json", manifest)
+		write("application-"+digest+".js", "")
+		Expect(finalizer.CheckAssetFingerprints()).To(HaveOccurred())
+		Expect(buffer.String()).To(ContainSubstring("public/assets holds 2 sprockets manifests, .sprockets-manifest-abc.json, manifest-def.json"))
+	})
+
+	It("reads the manifest.yml of rails 3", func() {
+		write("manifest.yml", "---\napplication.js: application-0123456789abcdef0123456789abcdef.js\n")
+		Expect(finalizer.CheckAssetFingerprints()).To(HaveOccurred())
+		Expect(buffer.String()).To(ContainSubstring("manifest.yml lists application-0123456789abcdef0123456789abcdef.js"))
+	})
+})
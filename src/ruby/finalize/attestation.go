@@ -0,0 +1,54 @@
+package finalize
+
+import (
+	"fmt"
+	"ruby/provenance"
+	"ruby/report"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// WriteAttestation attests that the droplet contents recorded by
+// RecordContents were built from the dependencies supply installed. It runs
+// from RecordDroplet, right after RecordContents. The
+// attestation is written to the dep dir next to the staging report and is
+// signed with the key at AttestationKeyFile when the operator packaged one. With
+// BP_REPRODUCIBLE the install times are SOURCE_DATE_EPOCH, like every mtime.
+func (f *Finalizer) WriteAttestation() error {
+	r, err := report.Load(f.Stager.DepDir())
+	if err != nil {
+		return err
+	}
+	if r.Contents == nil {
+		return fmt.Errorf("the staging report has no droplet contents")
+	}
+
+	dependencies := r.Dependencies
+	if f.Flags.Bool("BP_REPRODUCIBLE") {
+		epoch := time.Unix(sourceDateEpoch(), 0).UTC()
+		for i := range dependencies {
+			dependencies[i].InstalledAt = epoch
+		}
+	}
+
+	statement, err := provenance.NewStatement("droplet", r.Contents.Digest, dependencies)
+	if err != nil {
+		return err
+	}
+	envelope, err := provenance.NewEnvelope(statement)
+	if err != nil {
+		return err
+	}
+	key, err := provenance.LoadKey(f.AttestationKeyFile)
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		if err := envelope.Sign(key); err != nil {
+			return err
+		}
+		f.Log.Info("Signed the provenance attestation with key %s", provenance.KeyID(key.Public().(ed25519.PublicKey)))
+	}
+	return envelope.Save(f.Stager.DepDir())
+}
@@ -0,0 +1,125 @@
+package finalize_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/featureflags"
+	"ruby/finalize"
+	"ruby/provenance"
+	"ruby/report"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ed25519"
+)
+
+var _ = Describe("WriteAttestation", func() {
+	var (
+		err       error
+		depsDir   string
+		depDir    string
+		flags     []string
+		keyFile   string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+		seed      []byte
+	)
+
+	BeforeEach(func() {
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+		depDir = filepath.Join(depsDir, "0")
+		Expect(os.MkdirAll(depDir, 0755)).To(Succeed())
+		buffer = new(bytes.Buffer)
+		flags = nil
+		keyFile = filepath.Join(depsDir, provenance.KeyFile)
+		seed = []byte(strings.Repeat("k", ed25519.SeedSize))
+
+		Expect((&report.Report{
+			Contents: &report.Contents{Digest: "sha256:0123abcd"},
+			Dependencies: []provenance.Dependency{
+				{Name: "ruby", Version: "2.5.1", URI: "https://buildpacks.cloudfoundry.org/ruby-2.5.1.tgz", SHA256: "abc123", Origin: provenance.FromDownload, BuildpackVersion: "1.7.9", InstalledAt: time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)},
+			},
+		}).Save(depDir)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{"", "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+			Flags:  featureflags.New(flags),
+
+			AttestationKeyFile: keyFile,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.Unsetenv("SOURCE_DATE_EPOCH")).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	statement := func() (*provenance.Envelope, provenance.Statement) {
+		envelope, err := provenance.Load(depDir)
+		Expect(err).To(BeNil())
+		statement, err := envelope.Statement()
+		Expect(err).To(BeNil())
+		return envelope, statement
+	}
+
+	It("attests the droplet contents with the installed dependencies", func() {
+		Expect(finalizer.WriteAttestation()).To(Succeed())
+
+		envelope, statement := statement()
+		Expect(envelope.Signatures).To(BeEmpty())
+		Expect(statement.Subject[0].Digest).To(Equal(map[string]string{"sha256": "0123abcd"}))
+		Expect(statement.Predicate.Materials).To(Equal([]provenance.Material{{URI: "https://buildpacks.cloudfoundry.org/ruby-2.5.1.tgz", Digest: map[string]string{"sha256": "abc123"}}}))
+		Expect(statement.Predicate.BuildConfig.Dependencies[0].InstalledAt).To(Equal(time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)))
+	})
+
+	Context("with BP_REPRODUCIBLE", func() {
+		BeforeEach(func() {
+			flags = []string{"BP_REPRODUCIBLE=true"}
+			Expect(os.Setenv("SOURCE_DATE_EPOCH", "1500000000")).To(Succeed())
+		})
+
+		It("uses SOURCE_DATE_EPOCH as the install time", func() {
+			Expect(finalizer.WriteAttestation()).To(Succeed())
+			_, statement := statement()
+			Expect(statement.Predicate.BuildConfig.Dependencies[0].InstalledAt).To(Equal(time.Unix(1500000000, 0).UTC()))
+		})
+	})
+
+	Context("with a signing key in the buildpack", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(seed)+"\n"), 0600)).To(Succeed())
+		})
+
+		It("signs the attestation", func() {
+			Expect(finalizer.WriteAttestation()).To(Succeed())
+
+			envelope, _ := statement()
+			public := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+			Expect(envelope.Verify(public)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Signed the provenance attestation with key " + provenance.KeyID(public)))
+		})
+
+		It("fails with a key which is not ed25519", func() {
+			Expect(ioutil.WriteFile(keyFile, []byte("c2hvcnQ="), 0600)).To(Succeed())
+			Expect(finalizer.WriteAttestation()).To(MatchError("invalid attestation_signing.key: an ed25519 key is 32 or 64 bytes, not 5"))
+			Expect(filepath.Join(depDir, provenance.File)).ToNot(BeAnExistingFile())
+		})
+	})
+
+	It("fails before the droplet contents are recorded", func() {
+		Expect((&report.Report{}).Save(depDir)).To(Succeed())
+		Expect(finalizer.WriteAttestation()).To(MatchError("the staging report has no droplet contents"))
+	})
+})
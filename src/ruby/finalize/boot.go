@@ -0,0 +1,103 @@
+package finalize
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kr/text"
+)
+
+// BootCheck starts the web process inside the staging container when
+// BP_BOOT_CHECK=true and fails staging if it exits before the timeout.
+func (f *Finalizer) BootCheck(defaultWebCommand string) error {
+	if !f.Flags.Bool("BP_BOOT_CHECK") {
+		return nil
+	}
+	timeout := f.Flags.Duration("BP_BOOT_CHECK_TIMEOUT")
+
+	command, err := f.webCommand(defaultWebCommand)
+	if err != nil {
+		return err
+	} else if command == "" {
+		f.Log.Warning("Skipping boot check, no web process type was found")
+		return nil
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return fmt.Errorf("Could not find a free port: %v", err)
+	}
+
+	f.Log.BeginStep("Checking that the app boots (%v)", timeout)
+	f.Log.Info("Running: %s", command)
+
+	output := new(bytes.Buffer)
+	cmd := exec.Command("bash", "-c", command)
+	cmd.Dir = f.Stager.BuildDir()
+	cmd.Env = append(os.Environ(), "PORT="+port)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		f.Log.Error("The app exited during the boot check, output follows:")
+		text.NewIndentWriter(f.Log.Output(), []byte("       ")).Write(output.Bytes())
+		if err == nil {
+			return errors.New("app exited during boot check")
+		}
+		return fmt.Errorf("app exited during boot check: %v", err)
+	case <-time.After(timeout):
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		f.Log.Info("App booted successfully")
+		return nil
+	}
+}
+
+func (f *Finalizer) webCommand(defaultWebCommand string) (string, error) {
+	data, err := f.fs().ReadFile(filepath.Join(f.Stager.BuildDir(), "Procfile"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return defaultWebCommand, nil
+		}
+		return "", err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "web" {
+			return strings.TrimSpace(parts[1]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return defaultWebCommand, nil
+}
+
+func freePort() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port), nil
+}
@@ -0,0 +1,95 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/featureflags"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BootCheck", func() {
+	var (
+		err       error
+		buildDir  string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+
+		args := []string{buildDir, "", "", ""}
+		stager := libbuildpack.NewStager(args, logger, &libbuildpack.Manifest{})
+
+		finalizer = &finalize.Finalizer{
+			Stager: stager,
+			Log:    logger,
+			Flags:  featureflags.New([]string{}),
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	Context("BP_BOOT_CHECK is not set", func() {
+		It("does not run the web process", func() {
+			Expect(finalizer.BootCheck("exit 1")).To(Succeed())
+			Expect(buffer.String()).To(BeEmpty())
+		})
+	})
+
+	Context("BP_BOOT_CHECK is true", func() {
+		BeforeEach(func() {
+			finalizer.Flags = featureflags.New([]string{"BP_BOOT_CHECK=true", "BP_BOOT_CHECK_TIMEOUT=1"})
+		})
+
+		Context("the web process keeps running", func() {
+			It("succeeds", func() {
+				Expect(finalizer.BootCheck("sleep 5")).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Checking that the app boots (1s)"))
+				Expect(buffer.String()).To(ContainSubstring("App booted successfully"))
+			})
+
+			It("provides a PORT to bind to", func() {
+				Expect(finalizer.BootCheck(`[ -n "$PORT" ] && sleep 5`)).To(Succeed())
+			})
+		})
+
+		Context("the web process exits", func() {
+			It("fails and prints the output", func() {
+				Expect(finalizer.BootCheck("echo missing initializer; exit 3")).ToNot(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("The app exited during the boot check"))
+				Expect(buffer.String()).To(ContainSubstring("       missing initializer"))
+			})
+		})
+
+		Context("the app has a Procfile with a web process", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("worker: sleep 5\nweb: exit 4\n"), 0644)).To(Succeed())
+			})
+
+			It("runs the Procfile web process", func() {
+				Expect(finalizer.BootCheck("sleep 5")).To(MatchError("app exited during boot check: exit status 4"))
+			})
+		})
+
+		Context("there is no web process", func() {
+			It("warns and skips the check", func() {
+				Expect(finalizer.BootCheck("")).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Skipping boot check, no web process type was found"))
+			})
+		})
+	})
+})
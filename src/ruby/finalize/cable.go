@@ -0,0 +1,136 @@
+package finalize
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"ruby/profiled"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	erbTag    = regexp.MustCompile(`<%=?.*?%>`)
+	webServer = regexp.MustCompile(`\b(puma|unicorn|thin|rails s(erver)?)\b`)
+)
+
+// redisURLScript exports REDIS_URL from the first redis uri in
+// VCAP_SERVICES, unless the app sets it
+func redisURLScript() *profiled.Script {
+	found := profiled.New().AddEnv("REDIS_URL", profiled.Expand("$__cf_redis_url"))
+	lookup := profiled.New().
+		AddShell(`__cf_redis_url=$(echo "$VCAP_SERVICES" | sed -n 's/.*"uri": *"\(rediss\{0,1\}:\/\/[^"]*\)".*/\1/p')`).
+		AddScriptBlock(found, profiled.IfSet("__cf_redis_url")).
+		AddUnset("__cf_redis_url")
+	return profiled.New().AddScriptBlock(lookup, profiled.IfUnset("REDIS_URL"))
+}
+
+// ConfigureCable checks that apps using ActionCable or AnyCable are set up
+// in a way the platform can serve websockets, and exports REDIS_URL from a
+// bound redis service when the cable adapter needs it.
+func (f *Finalizer) ConfigureCable() error {
+	actionCable, err := f.Versions.HasGem("actioncable")
+	if err != nil {
+		return err
+	}
+	anyCable, err := f.Versions.HasGem("anycable-rails")
+	if err != nil {
+		return err
+	}
+	if !actionCable && !anyCable {
+		return nil
+	}
+
+	adapter, err := f.cableAdapter()
+	if err != nil {
+		return err
+	} else if adapter == "" && !anyCable {
+		return nil
+	}
+
+	f.Log.BeginStep("Configuring %s", map[bool]string{true: "AnyCable", false: "ActionCable"}[anyCable])
+
+	processes, err := f.procfileCommands()
+	if err != nil {
+		return err
+	}
+
+	switch adapter {
+	case "async":
+		f.Log.Warning("config/cable.yml uses the async adapter in production, broadcasts only reach clients connected to the same instance. Bind a redis service and use the redis adapter to run more than one instance.")
+	case "any_cable":
+		anyCable = true
+	}
+
+	if anyCable {
+		f.checkAnyCableProcesses(processes)
+	} else {
+		f.checkCableProcesses(processes)
+	}
+
+	if adapter == "redis" || anyCable {
+		f.Log.Info("Exporting REDIS_URL from a bound redis service when it is not set")
+		return redisURLScript().WriteFS(f.fs(), f.Stager.DepDir(), "redis_url.sh")
+	}
+	return nil
+}
+
+// cableAdapter returns the production adapter in config/cable.yml, ERB tags
+// are ignored since the values they render are only known at runtime
+func (f *Finalizer) cableAdapter() (string, error) {
+	data, err := f.fs().ReadFile(filepath.Join(f.Stager.BuildDir(), "config", "cable.yml"))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	var environments map[string]map[string]interface{}
+	if err := yaml.Unmarshal(erbTag.ReplaceAll(data, []byte("erb")), &environments); err != nil {
+		f.Log.Warning("Could not parse config/cable.yml, skipping ActionCable checks: %v", err)
+		return "", nil
+	}
+	adapter, _ := environments["production"]["adapter"].(string)
+	return adapter, nil
+}
+
+// checkAnyCableProcesses warns when the AnyCable RPC server is not a process
+// of its own, or when the websocket server shares a process with the web
+// server since the platform only routes a single port to each process
+func (f *Finalizer) checkAnyCableProcesses(processes map[string]string) {
+	rpc := false
+	for _, name := range sortedKeys(processes) {
+		command := processes[name]
+		if strings.Contains(command, "anycable-go") && webServer.MatchString(command) {
+			f.Log.Warning("The %s process runs anycable-go alongside the web server, only one of them can listen on $PORT. Run anycable-go as a separate process or app with its own route.", name)
+		} else if strings.Contains(strings.Replace(command, "anycable-go", "", -1), "anycable") {
+			rpc = true
+		}
+	}
+	if !rpc {
+		f.Log.Warning("anycable-rails is in the Gemfile.lock but no Procfile process runs the AnyCable RPC server, add one such as 'rpc: bundle exec anycable'")
+	}
+}
+
+// checkCableProcesses gives a hint about allowed_request_origins when the
+// ActionCable server runs as a separate process, only the web process gets
+// the app's route so the cable server is on a different origin
+func (f *Finalizer) checkCableProcesses(processes map[string]string) {
+	for _, name := range sortedKeys(processes) {
+		if name == "web" || !strings.Contains(processes[name], "cable") {
+			continue
+		}
+		f.Log.Info("The %s process serves ActionCable, map a route to it and set config.action_cable.url and config.action_cable.allowed_request_origins so browsers on the app's route can connect", name)
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
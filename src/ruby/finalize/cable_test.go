@@ -0,0 +1,161 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConfigureCable", func() {
+	var (
+		err          error
+		buildDir     string
+		depsDir      string
+		finalizer    *finalize.Finalizer
+		buffer       *bytes.Buffer
+		mockCtrl     *gomock.Controller
+		mockVersions *MockVersions
+		gems         map[string]bool
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(buildDir, "config"), 0755)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+
+		gems = map[string]bool{"actioncable": true}
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockVersions = NewMockVersions(mockCtrl)
+		mockVersions.EXPECT().HasGem(gomock.Any()).AnyTimes().DoAndReturn(func(name string) (bool, error) {
+			return gems[name], nil
+		})
+
+		args := []string{buildDir, "", depsDir, "0"}
+		finalizer = &finalize.Finalizer{
+			Stager:   libbuildpack.NewStager(args, logger, &libbuildpack.Manifest{}),
+			Versions: mockVersions,
+			Log:      logger,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	writeFile := func(name, contents string) {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, name), []byte(contents), 0644)).To(Succeed())
+	}
+	script := func() string {
+		return filepath.Join(depsDir, "0", "profile.d", "redis_url.sh")
+	}
+
+	Context("the app does not use ActionCable", func() {
+		BeforeEach(func() {
+			gems = map[string]bool{}
+		})
+
+		It("does nothing", func() {
+			Expect(finalizer.ConfigureCable()).To(Succeed())
+			Expect(buffer.String()).To(Equal(""))
+		})
+	})
+
+	Context("config/cable.yml uses the redis adapter", func() {
+		BeforeEach(func() {
+			writeFile("config/cable.yml", `default: &default
+  adapter: redis
+  url: <%= ENV.fetch("REDIS_URL") { "redis://localhost:6379/1" } %>
+
+development:
+  adapter: async
+
+production:
+  <<: *default
+  channel_prefix: app_production
+`)
+		})
+
+		It("exports REDIS_URL from a bound redis service", func() {
+			Expect(finalizer.ConfigureCable()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Configuring ActionCable"))
+
+			cmd := exec.Command("bash", "-c", "source "+script()+" && echo $REDIS_URL")
+			cmd.Env = []string{`VCAP_SERVICES={"p-redis":[{"name":"cache","credentials":{"uri":"redis://:secret@10.0.0.1:6379"}}]}`}
+			output, err := cmd.CombinedOutput()
+			Expect(err).To(BeNil(), string(output))
+			Expect(string(output)).To(Equal("redis://:secret@10.0.0.1:6379\n"))
+		})
+
+		It("keeps REDIS_URL when it is set", func() {
+			Expect(finalizer.ConfigureCable()).To(Succeed())
+
+			cmd := exec.Command("bash", "-c", "source "+script()+" && echo $REDIS_URL")
+			cmd.Env = []string{"REDIS_URL=redis://elsewhere", `VCAP_SERVICES={"p-redis":[{"credentials":{"uri":"redis://10.0.0.1"}}]}`}
+			output, err := cmd.CombinedOutput()
+			Expect(err).To(BeNil(), string(output))
+			Expect(string(output)).To(Equal("redis://elsewhere\n"))
+		})
+
+		It("hints at allowed_request_origins for a separate cable process", func() {
+			writeFile("Procfile", "web: bundle exec puma\ncable: bundle exec puma -p $PORT cable/config.ru\n")
+			Expect(finalizer.ConfigureCable()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("The cable process serves ActionCable, map a route to it"))
+			Expect(buffer.String()).To(ContainSubstring("allowed_request_origins"))
+		})
+	})
+
+	Context("config/cable.yml uses the async adapter in production", func() {
+		BeforeEach(func() {
+			writeFile("config/cable.yml", "production:\n  adapter: async\n")
+		})
+
+		It("warns and does not export REDIS_URL", func() {
+			Expect(finalizer.ConfigureCable()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("config/cable.yml uses the async adapter in production"))
+			Expect(script()).ToNot(BeAnExistingFile())
+		})
+	})
+
+	Context("the app uses AnyCable", func() {
+		BeforeEach(func() {
+			gems["anycable-rails"] = true
+			writeFile("config/cable.yml", "production:\n  adapter: any_cable\n")
+		})
+
+		It("warns when there is no RPC process", func() {
+			writeFile("Procfile", "web: bundle exec puma\n")
+			Expect(finalizer.ConfigureCable()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Configuring AnyCable"))
+			Expect(buffer.String()).To(ContainSubstring("no Procfile process runs the AnyCable RPC server"))
+			Expect(script()).To(BeAnExistingFile())
+		})
+
+		It("does not warn when the RPC server is a process of its own", func() {
+			writeFile("Procfile", "web: bundle exec puma\nrpc: bundle exec anycable\n")
+			Expect(finalizer.ConfigureCable()).To(Succeed())
+			Expect(buffer.String()).ToNot(ContainSubstring("WARNING"))
+		})
+
+		It("warns when anycable-go shares a process with the web server", func() {
+			writeFile("Procfile", "web: anycable-go --port 8081 & bundle exec puma\nrpc: bundle exec anycable\n")
+			Expect(finalizer.ConfigureCable()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("The web process runs anycable-go alongside the web server, only one of them can listen on $PORT"))
+		})
+	})
+})
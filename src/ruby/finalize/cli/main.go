@@ -4,15 +4,35 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"ruby/cache"
+	"ruby/config"
+	"ruby/deprecations"
+	"ruby/diagnostics"
+	"ruby/featureflags"
 	"ruby/finalize"
+	_ "ruby/hooks"
+	"ruby/provenance"
+	"ruby/redact"
+	"ruby/sandbox"
+	"ruby/telemetry"
 	"ruby/versions"
-	// _ "ruby/hooks"
+	"ruby/workspace"
 	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "--list-flags" {
+		featureflags.New(os.Environ()).List(os.Stdout)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--list-deprecations" {
+		deprecations.List(os.Stdout)
+		return
+	}
+
 	logfile, err := ioutil.TempFile("", "cloudfoundry.ruby-buildpack.finalize")
 	defer logfile.Close()
 	if err != nil {
@@ -21,53 +41,159 @@ func main() {
 		os.Exit(8)
 	}
 
-	stdout := io.MultiWriter(os.Stdout, logfile)
+	flags := featureflags.New(os.Environ())
+	redactor, err := redact.New(os.Environ(), flags.String("BP_REDACT_PATTERNS"))
+	if err != nil {
+		libbuildpack.NewLogger(os.Stdout).Error("Unable to setup log redaction: %s", err.Error())
+		os.Exit(16)
+	}
+	if len(os.Args) > 1 {
+		if err := redactor.AddBundleCredentials(os.Args[1], os.Environ()); err != nil {
+			libbuildpack.NewLogger(os.Stdout).Error("Unable to setup log redaction: %s", err.Error())
+			os.Exit(16)
+		}
+	}
+	stdout := redactor.Writer(io.MultiWriter(os.Stdout, logfile))
+	defer stdout.Close()
 	logger := libbuildpack.NewLogger(stdout)
+	// os.Exit skips the deferred calls, exit writes out a trailing partial
+	// line the redacting writer still holds first
+	exit := func(code int) {
+		stdout.Close()
+		os.Exit(code)
+	}
 
 	buildpackDir, err := libbuildpack.GetBuildpackDir()
 	if err != nil {
 		logger.Error("Unable to determine buildpack directory: %s", err.Error())
-		os.Exit(9)
+		exit(9)
 	}
 
 	manifest, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
 	if err != nil {
 		logger.Error("Unable to load buildpack manifest: %s", err.Error())
-		os.Exit(10)
+		exit(10)
+	}
+
+	if err := flags.Validate(); err != nil {
+		logger.Error("Invalid buildpack flags: %s", err.Error())
+		exit(15)
 	}
 
 	stager := libbuildpack.NewStager(os.Args[1:], logger, manifest)
 
 	if err = manifest.ApplyOverride(stager.DepsDir()); err != nil {
 		logger.Error("Unable to apply override.yml files: %s", err)
-		os.Exit(17)
+		exit(17)
 	}
 
 	if err := stager.SetStagingEnvironment(); err != nil {
 		logger.Error("Unable to setup environment variables: %s", err.Error())
-		os.Exit(11)
+		exit(11)
+	}
+
+	appConfig, err := config.Load(stager.BuildDir())
+	if err != nil {
+		logger.Error("Unable to load app config: %s", err.Error())
+		exit(19)
+	}
+	if err := appConfig.SelectProfile(flags.String("BP_PROFILE")); err != nil {
+		logger.Error("Unable to select install profile: %s", err.Error())
+		exit(19)
 	}
 
+	cacher, err := cache.New(stager, logger, libbuildpack.NewYAML())
+	if err != nil {
+		logger.Error("Unable to create cacher: %s", err.Error())
+		exit(21)
+	}
+	if err := cacher.SetCompression(flags.String("BP_CACHE_COMPRESSION")); err != nil {
+		logger.Error("Invalid BP_CACHE_COMPRESSION: %s", err.Error())
+		exit(21)
+	}
+
+	appVersions := versions.New(stager.BuildDir(), manifest)
 	f := finalize.Finalizer{
-		Stager:   stager,
-		Log:      logger,
-		Versions: versions.New(stager.BuildDir(), manifest),
-		Command:  &libbuildpack.Command{},
+		Stager:       stager,
+		Log:          logger,
+		Versions:     appVersions,
+		Command:      sandbox.New(flags.Bool("BP_EXEC_SANDBOX"), flags.String("BP_EXEC_ENV_ALLOW")),
+		Cache:        cacher,
+		Flags:        flags,
+		Config:       appConfig,
+		Deprecations: deprecations.New(logger, stager.DepDir()),
+
+		AttestationKeyFile: filepath.Join(buildpackDir, provenance.KeyFile),
 	}
 
 	if err := finalize.Run(&f); err != nil {
-		os.Exit(12)
+		if flags.Bool("BP_DIAGNOSTICS") {
+			reportDiagnostics(diagnostics.Collector{
+				DepDir:       stager.DepDir(),
+				BuildpackDir: buildpackDir,
+				LogFiles:     []string{logfile.Name()},
+				Environ:      os.Environ(),
+				Redactor:     redactor,
+				Log:          logger,
+				UploadURL:    flags.String("BP_DIAGNOSTICS_URL"),
+			})
+		}
+		exit(12)
 	}
 
 	if err := libbuildpack.RunAfterCompile(stager); err != nil {
 		logger.Error("After Compile: %s", err.Error())
-		os.Exit(13)
+		exit(13)
 	}
 
 	if err := stager.SetLaunchEnvironment(); err != nil {
 		logger.Error("Unable to setup launch environment: %s", err.Error())
-		os.Exit(14)
+		exit(14)
+	}
+
+	if err := f.NormalizePermissions(); err != nil {
+		logger.Error("Unable to normalize file permissions: %s", err.Error())
+		exit(20)
+	}
+
+	if err := f.NormalizeDroplet(); err != nil {
+		logger.Error("Unable to normalize droplet: %s", err.Error())
+		exit(18)
+	}
+
+	if err := f.RecordDroplet(); err != nil {
+		logger.Error("Unable to record the droplet contents: %s", err.Error())
+		exit(22)
+	}
+
+	if config, err := telemetry.LoadConfig(filepath.Join(buildpackDir, telemetry.ConfigFile)); err != nil {
+		logger.Warning("Unable to load the telemetry config: %s", err.Error())
+	} else if config != nil && !flags.Bool("BP_TELEMETRY_OPT_OUT") {
+		buildpackVersion, _ := manifest.Version()
+		reporter := telemetry.Reporter{
+			Config:           config,
+			DepDir:           stager.DepDir(),
+			GemfileLock:      appVersions.Gemfile() + ".lock",
+			BuildpackVersion: buildpackVersion,
+			Log:              logger,
+		}
+		reporter.Report()
 	}
 
 	stager.StagingComplete()
 }
+
+// reportDiagnostics collects the diagnostics in a workspace of their own,
+// finalize has no other scratch files, and removes it once reported
+func reportDiagnostics(collector diagnostics.Collector) {
+	ws, err := workspace.New("ruby-buildpack.finalize.")
+	if err != nil {
+		collector.Log.Warning("Unable to collect diagnostics: %s", err.Error())
+		return
+	}
+	collector.Workspace = ws
+	collector.Report()
+	if err := ws.Cleanup(); err != nil {
+		collector.Log.Warning("Unable to remove the staging workspace: %s", err.Error())
+	}
+}
@@ -0,0 +1,174 @@
+package finalize
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/report"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// compressibleAssets are the extensions of the compiled assets worth
+// compressing, images and fonts other than svg and ttf are compressed already
+var compressibleAssets = map[string]bool{
+	".css": true, ".js": true, ".json": true, ".map": true, ".svg": true,
+	".html": true, ".txt": true, ".xml": true, ".ttf": true, ".eot": true,
+}
+
+// minCompressSize is the size below which compressing an asset saves less
+// than the headers of a response
+const minCompressSize = 1024
+
+// CompressAssets writes .gz and .br variants of the compiled assets in
+// public/assets and public/packs with BP_COMPRESS_ASSETS or assets.compress,
+// so they are served compressed without a CDN. Assets which have a variant
+// already, such as the .gz files sprockets writes, keep it. The originals are
+// kept for clients which do not accept compression, and each variant is
+// written to a temp file and renamed, so instances of the previous droplet
+// never see a partial file during a cutover. Brotli is only written when the
+// brotli command is on the stack.
+func (f *Finalizer) CompressAssets() error {
+	if !f.Flags.BoolOr("BP_COMPRESS_ASSETS", f.Config.Assets.Compress) {
+		return nil
+	}
+
+	var assets []string
+	for _, dir := range []string{"assets", "packs"} {
+		root := filepath.Join(f.Stager.BuildDir(), "public", dir)
+		if exists, err := libbuildpack.FileExists(root); err != nil {
+			return err
+		} else if !exists {
+			continue
+		}
+		if err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() && info.Size() >= minCompressSize && compressibleAssets[strings.ToLower(filepath.Ext(path))] {
+				assets = append(assets, path)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	if len(assets) == 0 {
+		return nil
+	}
+
+	f.Log.BeginStep("Compressing assets")
+	_, brotliErr := exec.LookPath("brotli")
+	compressed := report.CompressedAssets{}
+	for _, asset := range assets {
+		if size, written, err := f.compressAsset(asset, ".gz", gzipFile); err != nil {
+			return err
+		} else if written {
+			compressed.Gzip++
+			compressed.Bytes += size
+		}
+		if brotliErr != nil {
+			continue
+		}
+		if size, written, err := f.compressAsset(asset, ".br", f.brotliFile); err != nil {
+			return err
+		} else if written {
+			compressed.Brotli++
+			compressed.Bytes += size
+		}
+	}
+
+	f.Log.Info("Wrote %d gzip and %d brotli variants, adding %d KB to the droplet", compressed.Gzip, compressed.Brotli, compressed.Bytes>>10)
+	if brotliErr != nil {
+		f.Log.Info("The brotli command is not on the stack, only gzip variants were written")
+	}
+	f.staticAssetHints()
+	if err := report.Update(f.Stager.DepDir(), func(r *report.Report) {
+		r.CompressedAssets = &compressed
+	}); err != nil {
+		f.Log.Debug("Unable to record the compressed assets: %v", err)
+	}
+	return nil
+}
+
+// compressAsset writes the variant of asset with extension ext unless it has
+// one, it is dropped when it is not smaller than the asset
+func (f *Finalizer) compressAsset(asset, ext string, compress func(src, dst string) error) (int64, bool, error) {
+	variant := asset + ext
+	if exists, err := libbuildpack.FileExists(variant); err != nil || exists {
+		return 0, false, err
+	}
+	original, err := os.Stat(asset)
+	if err != nil {
+		return 0, false, err
+	}
+
+	temp := filepath.Join(filepath.Dir(asset), "."+filepath.Base(variant)+".partial")
+	if err := compress(asset, temp); err != nil {
+		os.Remove(temp)
+		return 0, false, err
+	}
+	info, err := os.Stat(temp)
+	if err != nil {
+		return 0, false, err
+	}
+	if info.Size() >= original.Size() {
+		return 0, false, os.Remove(temp)
+	}
+	if err := os.Chtimes(temp, original.ModTime(), original.ModTime()); err != nil {
+		return 0, false, err
+	}
+	return info.Size(), true, os.Rename(temp, variant)
+}
+
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw, err := gzip.NewWriterLevel(out, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(zw, in); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func (f *Finalizer) brotliFile(src, dst string) error {
+	cmd := exec.Command("brotli", "--quality=11", "--force", "--output="+dst, src)
+	cmd.Stdout = ioutil.Discard
+	cmd.Stderr = ioutil.Discard
+	return f.Command.Run(cmd)
+}
+
+// staticAssetHints tells how the app's server picks up the variants, rails
+// serves the .gz variants from 5.0 and the .br ones from 6.1
+func (f *Finalizer) staticAssetHints() {
+	if f.RailsVersion == 0 {
+		f.Log.Info("Serve them with Rack::Static, such as: use Rack::Static, urls: [\"/assets\", \"/packs\"], root: \"public\", gzip: true")
+		return
+	}
+	if brotli, err := f.Versions.HasGemVersion("actionpack", ">= 6.1.0"); err == nil && brotli {
+		f.Log.Info("Rails serves the .br and .gz variants from public when RAILS_SERVE_STATIC_FILES is set, which is the default")
+	} else if gzipped, err := f.Versions.HasGemVersion("actionpack", ">= 5.0.0"); err == nil && gzipped {
+		f.Log.Info("Rails serves the .gz variants from public when RAILS_SERVE_STATIC_FILES is set, which is the default, upgrade to Rails 6.1 to serve the .br variants")
+	} else {
+		f.Log.Warning("Rails %d does not serve compressed variants of the assets, serve them with Rack::Static and gzip: true", f.RailsVersion)
+	}
+}
@@ -0,0 +1,141 @@
+package finalize_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/config"
+	"ruby/featureflags"
+	"ruby/finalize"
+	"ruby/report"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CompressAssets", func() {
+	var (
+		err          error
+		buildDir     string
+		depsDir      string
+		binDir       string
+		path         string
+		finalizer    *finalize.Finalizer
+		buffer       *bytes.Buffer
+		mockCtrl     *gomock.Controller
+		mockCommand  *MockCommand
+		mockVersions *MockVersions
+		css          string
+	)
+
+	write := func(file, contents string) {
+		Expect(os.MkdirAll(filepath.Join(buildDir, filepath.Dir(file)), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, file), []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+		binDir, err = ioutil.TempDir("", "ruby-buildpack.bin.")
+		Expect(err).To(BeNil())
+		path = os.Getenv("PATH")
+		Expect(os.Setenv("PATH", binDir)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockCommand = NewMockCommand(mockCtrl)
+		mockVersions = NewMockVersions(mockCtrl)
+
+		finalizer = &finalize.Finalizer{
+			Stager:   libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Versions: mockVersions,
+			Command:  mockCommand,
+			Log:      logger,
+			Flags:    featureflags.New([]string{"BP_COMPRESS_ASSETS=true"}),
+			Config:   &config.Config{},
+		}
+
+		css = strings.Repeat("body { color: red; }\n", 100)
+		write("public/assets/application-abc123.css", css)
+		write("public/assets/application-abc123.js", strings.Repeat("var a = 1;\n", 200))
+		write("public/assets/application-abc123.js.gz", "from sprockets")
+		write("public/assets/small-abc123.css", "p {}")
+		write("public/assets/logo-abc123.png", strings.Repeat("x", 2048))
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		Expect(os.Setenv("PATH", path)).To(Succeed())
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+		Expect(os.RemoveAll(binDir)).To(Succeed())
+	})
+
+	It("does nothing unless it is turned on", func() {
+		finalizer.Flags = featureflags.New([]string{})
+		Expect(finalizer.CompressAssets()).To(Succeed())
+		Expect(filepath.Join(buildDir, "public", "assets", "application-abc123.css.gz")).ToNot(BeAnExistingFile())
+		Expect(buffer.String()).To(BeEmpty())
+	})
+
+	It("writes gzip variants of the compressible assets which have none", func() {
+		Expect(finalizer.CompressAssets()).To(Succeed())
+
+		file, err := os.Open(filepath.Join(buildDir, "public", "assets", "application-abc123.css.gz"))
+		Expect(err).To(BeNil())
+		defer file.Close()
+		zr, err := gzip.NewReader(file)
+		Expect(err).To(BeNil())
+		Expect(ioutil.ReadAll(zr)).To(Equal([]byte(css)))
+
+		Expect(ioutil.ReadFile(filepath.Join(buildDir, "public", "assets", "application-abc123.js.gz"))).To(Equal([]byte("from sprockets")))
+		Expect(filepath.Join(buildDir, "public", "assets", "application-abc123.css")).To(BeAnExistingFile())
+		Expect(filepath.Join(buildDir, "public", "assets", "small-abc123.css.gz")).ToNot(BeAnExistingFile())
+		Expect(filepath.Join(buildDir, "public", "assets", "logo-abc123.png.gz")).ToNot(BeAnExistingFile())
+		Expect(buffer.String()).To(ContainSubstring("Wrote 1 gzip and 0 brotli variants"))
+		Expect(buffer.String()).To(ContainSubstring("The brotli command is not on the stack"))
+		Expect(buffer.String()).To(ContainSubstring("use Rack::Static"))
+	})
+
+	It("records the variants and their size in the staging report", func() {
+		Expect(finalizer.CompressAssets()).To(Succeed())
+
+		info, err := os.Stat(filepath.Join(buildDir, "public", "assets", "application-abc123.css.gz"))
+		Expect(err).To(BeNil())
+		r, err := report.Load(filepath.Join(depsDir, "0"))
+		Expect(err).To(BeNil())
+		Expect(r.CompressedAssets).To(Equal(&report.CompressedAssets{Gzip: 1, Bytes: info.Size()}))
+	})
+
+	Context("brotli is on the stack", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(binDir, "brotli"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+			mockCommand.EXPECT().Run(gomock.Any()).AnyTimes().DoAndReturn(func(cmd *exec.Cmd) error {
+				Expect(cmd.Args[0]).To(Equal("brotli"))
+				output := strings.TrimPrefix(cmd.Args[3], "--output=")
+				return ioutil.WriteFile(output, []byte("brotli"), 0644)
+			})
+			finalizer.RailsVersion = 6
+			mockVersions.EXPECT().HasGemVersion("actionpack", ">= 6.1.0").Return(true, nil)
+		})
+
+		It("writes brotli variants as well", func() {
+			Expect(finalizer.CompressAssets()).To(Succeed())
+			Expect(ioutil.ReadFile(filepath.Join(buildDir, "public", "assets", "application-abc123.css.br"))).To(Equal([]byte("brotli")))
+			Expect(ioutil.ReadFile(filepath.Join(buildDir, "public", "assets", "application-abc123.js.br"))).To(Equal([]byte("brotli")))
+			Expect(buffer.String()).To(ContainSubstring("Wrote 1 gzip and 2 brotli variants"))
+			Expect(buffer.String()).To(ContainSubstring("Rails serves the .br and .gz variants"))
+		})
+	})
+})
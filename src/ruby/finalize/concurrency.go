@@ -0,0 +1,84 @@
+package finalize
+
+import (
+	"fmt"
+	"ruby/profiled"
+)
+
+// The concurrency defaults unless config/ruby-buildpack.yml tunes them
+const (
+	defaultMemoryPerWorker = 512
+	defaultWorkersPerCPU   = 2
+	defaultThreads         = 5
+)
+
+// concurrencyScript computes the workers of an instance from MEMORY_LIMIT, a
+// worker for each memory_per_worker megabytes, at most workers_per_cpu for
+// each CPU and max_workers. The CPUs are those nproc reports, fewer when the
+// cgroup has a CPU quota. MEMORY_LIMIT without a unit is in megabytes.
+const concurrencyScript = `__cf_workers=
+if [[ "${MEMORY_LIMIT:-}" =~ ^([0-9]+)([kKmMgG]?)[bB]?$ ]]; then
+  case "${BASH_REMATCH[2]}" in
+    k|K) __cf_memory_mb=$(( 10#${BASH_REMATCH[1]} / 1024 )) ;;
+    g|G) __cf_memory_mb=$(( 10#${BASH_REMATCH[1]} * 1024 )) ;;
+    *) __cf_memory_mb=$(( 10#${BASH_REMATCH[1]} )) ;;
+  esac
+  __cf_cpus=$(nproc 2>/dev/null)
+  if ! [[ "$__cf_cpus" =~ ^[0-9]+$ ]] || [ "$__cf_cpus" -lt 1 ]; then
+    __cf_cpus=1
+  fi
+  __cf_quota=
+  __cf_period=
+  if [ -r /sys/fs/cgroup/cpu.max ]; then
+    read -r __cf_quota __cf_period < /sys/fs/cgroup/cpu.max
+  elif [ -r /sys/fs/cgroup/cpu/cpu.cfs_quota_us ] && [ -r /sys/fs/cgroup/cpu/cpu.cfs_period_us ]; then
+    __cf_quota=$(cat /sys/fs/cgroup/cpu/cpu.cfs_quota_us)
+    __cf_period=$(cat /sys/fs/cgroup/cpu/cpu.cfs_period_us)
+  fi
+  if [[ "$__cf_quota" =~ ^[0-9]+$ ]] && [[ "$__cf_period" =~ ^[0-9]+$ ]] && [ "$__cf_quota" -gt 0 ] && [ "$__cf_period" -gt 0 ]; then
+    __cf_quota=$(( (__cf_quota + __cf_period - 1) / __cf_period ))
+    if [ "$__cf_quota" -lt "$__cf_cpus" ]; then
+      __cf_cpus=$__cf_quota
+    fi
+  fi
+  __cf_workers=$(( __cf_memory_mb / %[1]d ))
+  if [ "$__cf_workers" -gt $(( __cf_cpus * %[2]d )) ]; then
+    __cf_workers=$(( __cf_cpus * %[2]d ))
+  fi
+  if [ %[3]d -gt 0 ] && [ "$__cf_workers" -gt %[3]d ]; then
+    __cf_workers=%[3]d
+  fi
+  if [ "$__cf_workers" -lt 1 ]; then
+    __cf_workers=1
+  fi
+fi`
+
+// WriteConcurrencyDefaults writes a profile.d script which defaults
+// WEB_CONCURRENCY when an instance starts from its MEMORY_LIMIT and CPUs,
+// and RAILS_MAX_THREADS, so puma and unicorn get as many workers as the
+// instance can hold rather than one on every size. concurrency in
+// config/ruby-buildpack.yml tunes the defaults, values set with cf set-env
+// or procfile.env.yml win.
+func (f *Finalizer) WriteConcurrencyDefaults() error {
+	if !f.Flags.Bool("BP_WEB_CONCURRENCY") {
+		return nil
+	}
+	memoryPerWorker := orDefault(f.Config.Concurrency.MemoryPerWorker, defaultMemoryPerWorker)
+	workersPerCPU := orDefault(f.Config.Concurrency.WorkersPerCPU, defaultWorkersPerCPU)
+	threads := orDefault(f.Config.Concurrency.Threads, defaultThreads)
+	f.Log.BeginStep("Defaulting WEB_CONCURRENCY to a worker for each %d MB of MEMORY_LIMIT, at most %d for each CPU, and RAILS_MAX_THREADS to %d", memoryPerWorker, workersPerCPU, threads)
+
+	script := profiled.New().
+		AddShell(fmt.Sprintf(concurrencyScript, memoryPerWorker, workersPerCPU, f.Config.Concurrency.MaxWorkers)).
+		AddScriptBlock(profiled.New().AddEnvDefault("WEB_CONCURRENCY", profiled.Expand("$__cf_workers")), profiled.IfSet("__cf_workers")).
+		AddEnvDefault("RAILS_MAX_THREADS", profiled.Literal(fmt.Sprint(threads))).
+		AddUnset("__cf_workers", "__cf_memory_mb", "__cf_cpus", "__cf_quota", "__cf_period")
+	return script.WriteFS(f.fs(), f.Stager.DepDir(), "concurrency.sh")
+}
+
+func orDefault(value, fallback int) int {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,97 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/config"
+	"ruby/featureflags"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteConcurrencyDefaults", func() {
+	var (
+		err       error
+		buildDir  string
+		depsDir   string
+		binDir    string
+		buffer    *bytes.Buffer
+		finalizer *finalize.Finalizer
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+		binDir, err = ioutil.TempDir("", "ruby-buildpack.bin.")
+		Expect(err).To(BeNil())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+			Config: &config.Config{},
+			Flags:  featureflags.New([]string{}),
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+		Expect(os.RemoveAll(binDir)).To(Succeed())
+	})
+
+	// source runs the script on an instance with cpus CPUs
+	source := func(cpus string, env ...string) string {
+		Expect(ioutil.WriteFile(filepath.Join(binDir, "nproc"), []byte("#!/bin/sh\necho "+cpus+"\n"), 0755)).To(Succeed())
+		cmd := exec.Command("bash", "-c", `source "$1" && echo "${WEB_CONCURRENCY:-unset} $RAILS_MAX_THREADS ${__cf_workers:-clean}"`, "bash", filepath.Join(depsDir, "0", "profile.d", "concurrency.sh"))
+		cmd.Env = append([]string{"PATH=" + binDir + ":" + os.Getenv("PATH")}, env...)
+		output, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(output))
+		return string(output)
+	}
+
+	It("gives each worker 512 MB, at most two for each CPU", func() {
+		Expect(finalizer.WriteConcurrencyDefaults()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Defaulting WEB_CONCURRENCY to a worker for each 512 MB of MEMORY_LIMIT, at most 2 for each CPU, and RAILS_MAX_THREADS to 5"))
+
+		Expect(source("8", "MEMORY_LIMIT=2048m")).To(Equal("4 5 clean\n"))
+		Expect(source("8", "MEMORY_LIMIT=8G")).To(Equal("16 5 clean\n"))
+		Expect(source("1", "MEMORY_LIMIT=8G")).To(Equal("2 5 clean\n"))
+		Expect(source("8", "MEMORY_LIMIT=256M")).To(Equal("1 5 clean\n"))
+		Expect(source("8", "MEMORY_LIMIT=1048576k")).To(Equal("2 5 clean\n"))
+	})
+
+	It("leaves WEB_CONCURRENCY alone without a MEMORY_LIMIT it understands", func() {
+		Expect(finalizer.WriteConcurrencyDefaults()).To(Succeed())
+		Expect(source("4")).To(Equal("unset 5 clean\n"))
+		Expect(source("4", "MEMORY_LIMIT=1.5G")).To(Equal("unset 5 clean\n"))
+	})
+
+	It("keeps values set with cf set-env", func() {
+		Expect(finalizer.WriteConcurrencyDefaults()).To(Succeed())
+		Expect(source("4", "MEMORY_LIMIT=4G", "WEB_CONCURRENCY=3", "RAILS_MAX_THREADS=16")).To(Equal("3 16 clean\n"))
+	})
+
+	It("uses the concurrency settings of the config", func() {
+		finalizer.Config.Concurrency = config.Concurrency{MemoryPerWorker: 256, WorkersPerCPU: 4, MaxWorkers: 6, Threads: 3}
+		Expect(finalizer.WriteConcurrencyDefaults()).To(Succeed())
+		Expect(source("1", "MEMORY_LIMIT=1G")).To(Equal("4 3 clean\n"))
+		Expect(source("8", "MEMORY_LIMIT=4G")).To(Equal("6 3 clean\n"))
+	})
+
+	It("writes nothing with BP_WEB_CONCURRENCY=false", func() {
+		finalizer.Flags = featureflags.New([]string{"BP_WEB_CONCURRENCY=false"})
+		Expect(finalizer.WriteConcurrencyDefaults()).To(Succeed())
+		Expect(filepath.Join(depsDir, "0", "profile.d", "concurrency.sh")).ToNot(BeAnExistingFile())
+	})
+})
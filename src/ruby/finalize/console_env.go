@@ -0,0 +1,75 @@
+package finalize
+
+import (
+	"os"
+	"path/filepath"
+	"ruby/filesystem"
+	"ruby/profiled"
+)
+
+// consoleEnvScript loads the environment the launcher gives app processes,
+// cf ssh sessions start without it so bundler and rails can not find the
+// installed gems. Sourced it only exports the environment, run it starts
+// the command, or a shell, in the app directory.
+const consoleEnvScript = `#!/usr/bin/env bash
+# Loads the app's environment into a cf ssh session:
+#
+#   cf ssh APP -t -c "app/bin/console-env bin/rails console"
+#
+__cf_app_dir="$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)"
+if [ -z "${CF_CONSOLE_ENV:-}" ]; then
+  export HOME="$__cf_app_dir"
+  export DEPS_DIR="${DEPS_DIR:-$(cd "$__cf_app_dir/../deps" 2>/dev/null && pwd)}"
+  for __cf_script in "$__cf_app_dir"/.profile.d/*.sh; do
+    [ -f "$__cf_script" ] && source "$__cf_script"
+  done
+  unset __cf_script
+  [ -f "$__cf_app_dir/.profile" ] && source "$__cf_app_dir/.profile"
+  export CF_CONSOLE_ENV=1
+fi
+if [ "${BASH_SOURCE[0]}" != "$0" ]; then
+  unset __cf_app_dir
+  return 0
+fi
+cd "$__cf_app_dir"
+if [ $# -eq 0 ]; then
+  exec bash
+fi
+exec "$@"
+`
+
+// consoleBashrc is read by the non-login shell cf ssh starts
+const consoleBashrc = `# Written by the ruby buildpack, loads the app's environment for cf ssh sessions
+[ -f "$HOME/bin/console-env" ] && source "$HOME/bin/console-env"
+`
+
+// WriteConsoleEnv sets up bin/console-env and a .bashrc which sources it, so
+// cf ssh sessions can run rails console without exporting GEM_HOME,
+// BUNDLE_PATH and PATH by hand. Files the app already has are left alone.
+func (f *Finalizer) WriteConsoleEnv() error {
+	f.Log.BeginStep("Writing bin/console-env for cf ssh sessions")
+
+	binDir := filepath.Join(f.Stager.BuildDir(), "bin")
+	if err := f.fs().MkdirAll(binDir, 0755); err != nil {
+		return err
+	}
+	if written, err := f.writeIfMissing(filepath.Join(binDir, "console-env"), consoleEnvScript, 0755); err != nil {
+		return err
+	} else if !written {
+		f.Log.Info("Keeping the app's bin/console-env")
+	}
+	if _, err := f.writeIfMissing(filepath.Join(f.Stager.BuildDir(), ".bashrc"), consoleBashrc, 0644); err != nil {
+		return err
+	}
+
+	// The app's binstubs go on the PATH after everything else, so rails
+	// console works as well as bin/rails console
+	return profiled.New().AddPathAppend("PATH", profiled.Expand("$HOME/bin")).WriteFS(f.fs(), f.Stager.DepDir(), "console_env.sh")
+}
+
+func (f *Finalizer) writeIfMissing(path, contents string, mode os.FileMode) (bool, error) {
+	if exists, err := filesystem.Exists(f.fs(), path); err != nil || exists {
+		return false, err
+	}
+	return true, f.fs().WriteFile(path, []byte(contents), mode)
+}
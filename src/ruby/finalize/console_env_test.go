@@ -0,0 +1,103 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteConsoleEnv", func() {
+	var (
+		err       error
+		buildDir  string
+		depsDir   string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		stager := libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{})
+
+		finalizer = &finalize.Finalizer{
+			Stager: stager,
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	run := func(command string) string {
+		cmd := exec.Command("bash", "-c", command)
+		cmd.Dir = os.TempDir()
+		cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "DEPS_DIR=" + depsDir}
+		output, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(output))
+		return string(output)
+	}
+
+	Context("the app runs with profile.d scripts", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, ".profile.d"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, ".profile.d", "0_ruby.sh"), []byte("export GEM_HOME=$DEPS_DIR/0/gem_home\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, ".profile"), []byte("export FROM_PROFILE=yes\n"), 0644)).To(Succeed())
+		})
+
+		It("runs commands in the app directory with the app's environment", func() {
+			Expect(finalizer.WriteConsoleEnv()).To(Succeed())
+
+			output := run(filepath.Join(buildDir, "bin", "console-env") + " env")
+			Expect(output).To(ContainSubstring("GEM_HOME=" + depsDir + "/0/gem_home\n"))
+			Expect(output).To(ContainSubstring("FROM_PROFILE=yes\n"))
+			Expect(output).To(ContainSubstring("HOME=" + buildDir + "\n"))
+			Expect(output).To(ContainSubstring("PWD=" + buildDir + "\n"))
+		})
+
+		It("only exports the environment when sourced", func() {
+			Expect(finalizer.WriteConsoleEnv()).To(Succeed())
+
+			output := run("source " + filepath.Join(buildDir, "bin", "console-env") + " && echo \"$GEM_HOME\" && pwd")
+			Expect(output).To(Equal(depsDir + "/0/gem_home\n" + os.TempDir() + "\n"))
+		})
+
+		It("writes a .bashrc which loads it", func() {
+			Expect(finalizer.WriteConsoleEnv()).To(Succeed())
+
+			output := run("HOME=" + buildDir + " && source " + filepath.Join(buildDir, ".bashrc") + " && echo \"$GEM_HOME\"")
+			Expect(output).To(Equal(depsDir + "/0/gem_home\n"))
+		})
+	})
+
+	It("puts the app's bin directory on the PATH", func() {
+		Expect(finalizer.WriteConsoleEnv()).To(Succeed())
+		Expect(ioutil.ReadFile(filepath.Join(depsDir, "0", "profile.d", "console_env.sh"))).To(ContainSubstring(`export PATH=${PATH:+"$PATH:"}"$HOME/bin"`))
+	})
+
+	It("keeps the app's own bin/console-env and .bashrc", func() {
+		Expect(os.MkdirAll(filepath.Join(buildDir, "bin"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "bin", "console-env"), []byte("mine"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, ".bashrc"), []byte("alias ll='ls -l'\n"), 0644)).To(Succeed())
+
+		Expect(finalizer.WriteConsoleEnv()).To(Succeed())
+		Expect(ioutil.ReadFile(filepath.Join(buildDir, "bin", "console-env"))).To(Equal([]byte("mine")))
+		Expect(ioutil.ReadFile(filepath.Join(buildDir, ".bashrc"))).To(Equal([]byte("alias ll='ls -l'\n")))
+		Expect(buffer.String()).To(ContainSubstring("Keeping the app's bin/console-env"))
+	})
+})
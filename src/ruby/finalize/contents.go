@@ -0,0 +1,227 @@
+package finalize
+
+import (
+	"os"
+	"path/filepath"
+	"ruby/lockfile"
+	"ruby/provenance"
+	"ruby/report"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// assetDirs hold the compiled assets of sprockets and webpacker
+var assetDirs = []string{"public/assets", "public/packs"}
+
+// RecordDroplet records the droplet contents and attests them. It is the
+// last step which writes to the droplet, after the boot check, the after
+// compile hooks, NormalizePermissions and NormalizeDroplet, so what is
+// recorded is the droplet which ships. With BP_REPRODUCIBLE the staging
+// report, the attestation and the dep dir keep SOURCE_DATE_EPOCH as mtime.
+func (f *Finalizer) RecordDroplet() error {
+	if err := f.RecordContents(); err != nil {
+		return err
+	}
+	if err := f.WriteAttestation(); err != nil {
+		return err
+	}
+	if !f.Flags.Bool("BP_REPRODUCIBLE") {
+		return nil
+	}
+	epoch := time.Unix(sourceDateEpoch(), 0)
+	for _, path := range []string{filepath.Join(f.Stager.DepDir(), report.File), filepath.Join(f.Stager.DepDir(), provenance.File), f.Stager.DepDir()} {
+		if err := os.Chtimes(path, epoch, epoch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordContents adds digests of the installed gems, compiled assets and
+// executables to the staging report, so dropletdiff can tell what changed
+// between two deploys. It runs from RecordDroplet once nothing else changes
+// the droplet. The native build artifacts NormalizeDroplet removes with
+// BP_REPRODUCIBLE, isBuildArtifact and isExtObject, are left out of the gem
+// digests, so they describe the gems the same way with or without it.
+func (f *Finalizer) RecordContents() error {
+	contents := &report.Contents{
+		Assets:   map[string]string{},
+		Binaries: map[string]string{},
+	}
+
+	gems, err := f.installedGems()
+	if err != nil {
+		return err
+	}
+	contents.Gems = gems
+
+	for _, dir := range assetDirs {
+		if err := hashFiles(contents.Assets, f.Stager.BuildDir(), "app", dir, nil); err != nil {
+			return err
+		}
+	}
+	if err := hashFiles(contents.Binaries, f.Stager.BuildDir(), "app", "bin", nil); err != nil {
+		return err
+	}
+	depPrefix := filepath.Join("deps", f.Stager.DepsIdx())
+	if err := hashFiles(contents.Binaries, f.Stager.DepDir(), depPrefix, "bin", nil); err != nil {
+		return err
+	}
+	root, prefix, bundleDir := f.bundleRoot(depPrefix)
+	if err := hashFiles(contents.Binaries, root, prefix, bundleDir, func(name string) bool {
+		return strings.HasSuffix(name, ".so")
+	}); err != nil {
+		return err
+	}
+
+	if err := contents.Seal(f.Flags.String("BP_REPORT_SIGNING_SECRET")); err != nil {
+		return err
+	}
+	return report.Update(f.Stager.DepDir(), func(r *report.Report) {
+		r.Contents = contents
+	})
+}
+
+// gemDirs returns the Gemfile.lock and the dirs of the gems from a gem
+// source and from git in the bundle path, a nil lock when the app has none
+func (f *Finalizer) gemDirs() (*lockfile.Lockfile, []string, []string, error) {
+	lockPath := filepath.Join(f.Stager.BuildDir(), gemfile()) + ".lock"
+	if exists, err := libbuildpack.FileExists(lockPath); err != nil || !exists {
+		return nil, nil, nil, err
+	}
+	lock, err := lockfile.ParseInstallable(lockPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dirs, err := f.bundlePath().Glob("gems", "*")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	gitDirs, err := f.bundlePath().Glob("bundler", "gems", "*")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return lock, dirs, gitDirs, nil
+}
+
+// bundledGems lists the gems installedGems digests, without their digests
+// and groups
+func (f *Finalizer) bundledGems() ([]report.Gem, error) {
+	lock, dirs, gitDirs, err := f.gemDirs()
+	if err != nil || lock == nil {
+		return nil, err
+	}
+	var gems []report.Gem
+	for _, dir := range append(dirs, gitDirs...) {
+		if spec, found := specForDir(lock, filepath.Base(dir)); found {
+			gems = append(gems, report.Gem{Name: spec.Name, Version: spec.Version, Platform: spec.Platform})
+		}
+	}
+	return gems, nil
+}
+
+// installedGems digests the gems in the bundle path which are in the
+// Gemfile.lock, with the groups they are installed for
+func (f *Finalizer) installedGems() ([]report.Gem, error) {
+	lock, dirs, gitDirs, err := f.gemDirs()
+	if err != nil || lock == nil {
+		return nil, err
+	}
+	groups, err := f.Versions.GemGroups()
+	if err != nil {
+		f.Log.Warning("Unable to determine the Gemfile groups of the gems: %s", err.Error())
+	}
+
+	// NormalizeDroplet removes the ext objects of the gems from a gem
+	// source, bundler keeps the ext dir of git gems as it was checked out
+	gemArtifact := func(rel string) bool { return isBuildArtifact(rel) || isExtObject(rel) }
+	var gems []report.Gem
+	for i, dir := range append(dirs, gitDirs...) {
+		spec, found := specForDir(lock, filepath.Base(dir))
+		if !found {
+			continue
+		}
+		skip := isBuildArtifact
+		if i < len(dirs) {
+			skip = gemArtifact
+		}
+		digest, err := report.HashDir(dir, skip)
+		if err != nil {
+			return nil, err
+		}
+		gems = append(gems, report.Gem{
+			Name:     spec.Name,
+			Version:  spec.Version,
+			Platform: spec.Platform,
+			Groups:   groups[spec.Name],
+			Digest:   digest,
+		})
+	}
+	sort.Slice(gems, func(i, j int) bool { return gems[i].Name < gems[j].Name })
+	return gems, nil
+}
+
+// specForDir finds the locked spec installed as a gem directory, rubygems
+// names it name-version[-platform] and bundler names git checkouts
+// name-<revision>
+func specForDir(lock *lockfile.Lockfile, dir string) (lockfile.Spec, bool) {
+	for _, spec := range lock.Specs {
+		name := spec.Name + "-" + spec.Version
+		if spec.Platform != "" {
+			name += "-" + spec.Platform
+		}
+		if dir == name {
+			return spec, true
+		}
+		if spec.Source != nil && spec.Source.Type == "GIT" && strings.HasPrefix(dir, spec.Name+"-") && !strings.Contains(strings.TrimPrefix(dir, spec.Name+"-"), "-") {
+			return spec, true
+		}
+	}
+	return lockfile.Spec{}, false
+}
+
+// hashFiles adds the digest of each file beneath root/dir which include
+// accepts to digests, keyed by its path in the droplet under prefix
+func hashFiles(digests map[string]string, root, prefix, dir string, include func(name string) bool) error {
+	base := filepath.Join(root, dir)
+	if exists, err := libbuildpack.FileExists(base); err != nil || !exists {
+		return err
+	}
+	return filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || (include != nil && !include(info.Name())) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		digest, err := report.HashFile(path)
+		if err != nil {
+			return err
+		}
+		digests[filepath.ToSlash(filepath.Join(prefix, rel))] = digest
+		return nil
+	})
+}
+
+// bundleRoot splits the bundle path into the dir its binaries are keyed
+// relative to, the prefix of their keys and the bundle path below that dir.
+// A bundle path outside the droplet is keyed by its absolute path.
+func (f *Finalizer) bundleRoot(depPrefix string) (string, string, string) {
+	path := f.bundlePath().Root
+	for _, dir := range []struct{ root, prefix string }{
+		{f.Stager.BuildDir(), "app"},
+		{f.Stager.DepDir(), depPrefix},
+	} {
+		if rel, err := filepath.Rel(dir.root, path); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return dir.root, dir.prefix, rel
+		}
+	}
+	return filepath.Dir(path), filepath.Dir(path), filepath.Base(path)
+}
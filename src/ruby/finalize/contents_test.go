@@ -0,0 +1,178 @@
+package finalize_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/config"
+	"ruby/featureflags"
+	"ruby/finalize"
+	"ruby/provenance"
+	"ruby/report"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RecordContents", func() {
+	var (
+		err          error
+		buildDir     string
+		depsDir      string
+		depDir       string
+		finalizer    *finalize.Finalizer
+		buffer       *bytes.Buffer
+		mockCtrl     *gomock.Controller
+		mockVersions *MockVersions
+	)
+
+	write := func(path, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+		depDir = filepath.Join(depsDir, "0")
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockVersions = NewMockVersions(mockCtrl)
+
+		args := []string{buildDir, "", depsDir, "0"}
+		finalizer = &finalize.Finalizer{
+			Stager:   libbuildpack.NewStager(args, logger, &libbuildpack.Manifest{}),
+			Versions: mockVersions,
+			Log:      logger,
+			Flags:    featureflags.New([]string{"BP_REPORT_SIGNING_SECRET=s3cr3t"}),
+			Config:   &config.Config{},
+		}
+
+		write(filepath.Join(buildDir, "Gemfile.lock"), "GIT\n  remote: https://github.com/rack/rack-git.git\n  revision: 0123456789ab\n  specs:\n    rack-git (0.1.0)\n\nGEM\n  remote: https://rubygems.org/\n  specs:\n    nokogiri (1.8.2-x86_64-linux)\n    rack (2.0.5)\n")
+		gems := filepath.Join(depDir, "vendor_bundle", "ruby", "2.5.0")
+		write(filepath.Join(gems, "gems", "rack-2.0.5", "lib", "rack.rb"), "module Rack; end\n")
+		write(filepath.Join(gems, "gems", "nokogiri-1.8.2-x86_64-linux", "lib", "nokogiri.rb"), "module Nokogiri; end\n")
+		write(filepath.Join(gems, "gems", "nokogiri-1.8.2-x86_64-linux", "ext", "nokogiri", "mkmf.log"), "checking for ruby.h\n")
+		write(filepath.Join(gems, "extensions", "x86_64-linux", "2.5.0", "nokogiri-1.8.2", "nokogiri.so"), "ELF")
+		write(filepath.Join(gems, "bundler", "gems", "rack-git-0123456789ab", "lib", "rack_git.rb"), "module RackGit; end\n")
+		write(filepath.Join(gems, "gems", "leftover-1.0.0", "lib", "leftover.rb"), "")
+		write(filepath.Join(depDir, "bin", "ruby"), "ruby binary")
+		write(filepath.Join(buildDir, "bin", "rails"), "#!/usr/bin/env ruby\n")
+		write(filepath.Join(buildDir, "public", "assets", "application-abc.js"), "alert(1)")
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	load := func() *report.Contents {
+		r, err := report.Load(depDir)
+		Expect(err).To(BeNil())
+		Expect(r.Contents).ToNot(BeNil())
+		return r.Contents
+	}
+
+	It("records the locked gems with their groups", func() {
+		mockVersions.EXPECT().GemGroups().Return(map[string][]string{"rack": {"default"}, "nokogiri": {"default", "test"}}, nil)
+		Expect(finalizer.RecordContents()).To(Succeed())
+
+		contents := load()
+		Expect(contents.Gems).To(HaveLen(3))
+		Expect(contents.Gems[0].Name).To(Equal("nokogiri"))
+		Expect(contents.Gems[0].Platform).To(Equal("x86_64-linux"))
+		Expect(contents.Gems[0].Groups).To(Equal([]string{"default", "test"}))
+		Expect(contents.Gems[1].Name).To(Equal("rack"))
+		Expect(contents.Gems[2].Name).To(Equal("rack-git"))
+		Expect(contents.Gems[2].Groups).To(BeEmpty())
+	})
+
+	It("records the assets and executables", func() {
+		mockVersions.EXPECT().GemGroups().Return(nil, nil)
+		Expect(finalizer.RecordContents()).To(Succeed())
+
+		contents := load()
+		Expect(contents.Assets).To(HaveKey("app/public/assets/application-abc.js"))
+		Expect(contents.Binaries).To(HaveKey("app/bin/rails"))
+		Expect(contents.Binaries).To(HaveKey("deps/0/bin/ruby"))
+		Expect(contents.Binaries).To(HaveKey("deps/0/vendor_bundle/ruby/2.5.0/extensions/x86_64-linux/2.5.0/nokogiri-1.8.2/nokogiri.so"))
+		Expect(contents.Binaries).To(HaveLen(3))
+	})
+
+	It("records the native extensions of a BP_BUNDLE_PATH in the app", func() {
+		finalizer.Flags = featureflags.New([]string{"BP_REPORT_SIGNING_SECRET=s3cr3t", "BP_BUNDLE_PATH=" + filepath.Join(buildDir, "gems")})
+		write(filepath.Join(buildDir, "gems", "ruby", "2.5.0", "extensions", "x86_64-linux", "2.5.0", "puma-6.0.0", "puma_http11.so"), "ELF")
+		mockVersions.EXPECT().GemGroups().Return(nil, nil)
+		Expect(finalizer.RecordContents()).To(Succeed())
+
+		contents := load()
+		Expect(contents.Binaries).To(HaveKey("app/gems/ruby/2.5.0/extensions/x86_64-linux/2.5.0/puma-6.0.0/puma_http11.so"))
+		Expect(contents.Binaries).ToNot(HaveKey("deps/0/vendor_bundle/ruby/2.5.0/extensions/x86_64-linux/2.5.0/nokogiri-1.8.2/nokogiri.so"))
+	})
+
+	It("signs the digest", func() {
+		mockVersions.EXPECT().GemGroups().Return(nil, nil)
+		Expect(finalizer.RecordContents()).To(Succeed())
+		Expect(load().Verify("s3cr3t")).To(Succeed())
+	})
+
+	It("does not change the gem digest when build artifacts are removed", func() {
+		mockVersions.EXPECT().GemGroups().AnyTimes().Return(nil, nil)
+		Expect(finalizer.RecordContents()).To(Succeed())
+		digest := load().Gems[0].Digest
+
+		Expect(os.Remove(filepath.Join(depDir, "vendor_bundle", "ruby", "2.5.0", "gems", "nokogiri-1.8.2-x86_64-linux", "ext", "nokogiri", "mkmf.log"))).To(Succeed())
+		Expect(finalizer.RecordContents()).To(Succeed())
+		Expect(load().Gems[0].Digest).To(Equal(digest))
+	})
+
+	It("records the gem digests of the droplet BP_REPRODUCIBLE normalizes", func() {
+		nokogiri := filepath.Join(depDir, "vendor_bundle", "ruby", "2.5.0", "gems", "nokogiri-1.8.2-x86_64-linux")
+		write(filepath.Join(nokogiri, "ext", "nokogiri", "Makefile"), "CC = gcc\n")
+		write(filepath.Join(nokogiri, "ext", "nokogiri", "xml_node.o"), "ELF")
+		mockVersions.EXPECT().GemGroups().Return(nil, nil)
+		Expect(finalizer.RecordContents()).To(Succeed())
+		digest := load().Gems[0].Digest
+
+		finalizer.Flags = featureflags.New([]string{"BP_REPRODUCIBLE=true"})
+		Expect(finalizer.NormalizeDroplet()).To(Succeed())
+		Expect(filepath.Join(nokogiri, "ext", "nokogiri", "Makefile")).ToNot(BeAnExistingFile())
+		Expect(report.HashDir(nokogiri, nil)).To(Equal(digest))
+	})
+
+	Describe("RecordDroplet", func() {
+		It("records and attests the droplet BP_REPRODUCIBLE normalized, keeping the epoch mtimes", func() {
+			finalizer.Flags = featureflags.New([]string{"BP_REPRODUCIBLE=true"})
+			nokogiri := filepath.Join(depDir, "vendor_bundle", "ruby", "2.5.0", "gems", "nokogiri-1.8.2-x86_64-linux")
+			write(filepath.Join(nokogiri, "ext", "nokogiri", "xml_node.o"), "ELF")
+			mockVersions.EXPECT().GemGroups().Return(nil, nil)
+			Expect(finalizer.NormalizeDroplet()).To(Succeed())
+			Expect(finalizer.RecordDroplet()).To(Succeed())
+
+			Expect(report.HashDir(nokogiri, nil)).To(Equal(load().Gems[0].Digest))
+			Expect(provenance.Load(depDir)).ToNot(BeNil())
+			for _, path := range []string{filepath.Join(depDir, report.File), filepath.Join(depDir, provenance.File), depDir} {
+				info, err := os.Stat(path)
+				Expect(err).To(BeNil())
+				Expect(info.ModTime().Unix()).To(Equal(int64(315532800)))
+			}
+		})
+	})
+
+	It("warns when the gem groups can not be determined", func() {
+		mockVersions.EXPECT().GemGroups().Return(nil, errors.New("bundler is missing"))
+		Expect(finalizer.RecordContents()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Unable to determine the Gemfile groups of the gems: bundler is missing"))
+		Expect(load().Gems).To(HaveLen(3))
+	})
+})
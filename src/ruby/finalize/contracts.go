@@ -0,0 +1,177 @@
+package finalize
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var contractsClient = &http.Client{Timeout: 30 * time.Second}
+
+// brokerCredentials authenticate to the contract broker, either with a
+// token or a username and password
+type brokerCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Token    string `json:"token"`
+}
+
+// PublishContracts runs the contracts.tasks from the app's config file,
+// which regenerate pacts or OpenAPI documents, and publishes the generated
+// contracts.artifacts to the broker. The tasks failing fails staging, the
+// broker being unreachable only warns so a broker outage does not block
+// deploys.
+func (f *Finalizer) PublishContracts() error {
+	contracts := f.Config.Contracts
+	if len(contracts.Tasks) == 0 {
+		return nil
+	}
+
+	credentials, err := f.brokerCredentials(contracts.Service)
+	if err != nil {
+		return err
+	}
+	version := contracts.Version
+	if version == "" {
+		version = applicationVersion()
+	}
+	if version == "" {
+		return fmt.Errorf("contracts.version is not set and the app has no version to publish contracts with")
+	}
+
+	if err := f.runRakeTasks(contracts.Tasks); err != nil {
+		return err
+	}
+
+	var artifacts []string
+	for _, glob := range contracts.Artifacts {
+		matches, err := filepath.Glob(filepath.Join(f.Stager.BuildDir(), glob))
+		if err != nil {
+			return err
+		}
+		artifacts = append(artifacts, matches...)
+	}
+	sort.Strings(artifacts)
+	if len(artifacts) == 0 {
+		f.Log.Warning("The contract tasks did not generate any of: %s", strings.Join(contracts.Artifacts, ", "))
+		return nil
+	}
+
+	f.Log.BeginStep("Publishing API contracts to %s", displayURL(contracts.BrokerURL))
+	f.recordEgress("contract broker", contracts.BrokerURL)
+	for _, artifact := range artifacts {
+		rel, err := filepath.Rel(f.Stager.BuildDir(), artifact)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(artifact)
+		if err != nil {
+			return err
+		}
+		target := contractURL(contracts.BrokerURL, rel, version, data)
+		if err := publishContract(target, data, credentials); err != nil {
+			f.Log.Warning("Unable to publish %s: %s", rel, err.Error())
+			continue
+		}
+		f.Log.Info("Published %s", rel)
+	}
+	return nil
+}
+
+// brokerCredentials reads the credentials of the named bound service from
+// VCAP_SERVICES, no service means the broker needs no authentication
+func (f *Finalizer) brokerCredentials(service string) (brokerCredentials, error) {
+	if service == "" {
+		return brokerCredentials{}, nil
+	}
+
+	var services map[string][]struct {
+		Name        string            `json:"name"`
+		Credentials brokerCredentials `json:"credentials"`
+	}
+	if vcapServices := os.Getenv("VCAP_SERVICES"); vcapServices != "" {
+		if err := json.Unmarshal([]byte(vcapServices), &services); err != nil {
+			return brokerCredentials{}, fmt.Errorf("could not parse VCAP_SERVICES: %v", err)
+		}
+	}
+	for _, instances := range services {
+		for _, instance := range instances {
+			if instance.Name == service {
+				return instance.Credentials, nil
+			}
+		}
+	}
+	return brokerCredentials{}, fmt.Errorf("contracts.service %s is not bound to the app", service)
+}
+
+// applicationVersion is the version cloud controller gives the app's code
+func applicationVersion() string {
+	var app struct {
+		Version string `json:"application_version"`
+	}
+	json.Unmarshal([]byte(os.Getenv("VCAP_APPLICATION")), &app)
+	return app.Version
+}
+
+// contractURL is where a contract is published: pacts go to the pact
+// broker's endpoint for their consumer and provider, other documents under
+// contracts/<version>/ with their path in the app
+func contractURL(broker, rel, version string, data []byte) string {
+	broker = strings.TrimSuffix(broker, "/")
+
+	var pact struct {
+		Consumer struct {
+			Name string `json:"name"`
+		} `json:"consumer"`
+		Provider struct {
+			Name string `json:"name"`
+		} `json:"provider"`
+	}
+	if err := json.Unmarshal(data, &pact); err == nil && pact.Consumer.Name != "" && pact.Provider.Name != "" {
+		return fmt.Sprintf("%s/pacts/provider/%s/consumer/%s/version/%s", broker, url.PathEscape(pact.Provider.Name), url.PathEscape(pact.Consumer.Name), url.PathEscape(version))
+	}
+	return fmt.Sprintf("%s/contracts/%s/%s", broker, url.PathEscape(version), filepath.ToSlash(rel))
+}
+
+func publishContract(target string, data []byte, credentials brokerCredentials) error {
+	req, err := http.NewRequest("PUT", target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if json.Valid(data) {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if credentials.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+credentials.Token)
+	} else if credentials.Username != "" {
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+
+	resp, err := contractsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("broker responded %s", resp.Status)
+	}
+	return nil
+}
+
+// displayURL strips any credentials from a broker URL before it is logged
+func displayURL(broker string) string {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return broker
+	}
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,149 @@
+package finalize_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/config"
+	"ruby/featureflags"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PublishContracts", func() {
+	var (
+		err          error
+		buildDir     string
+		depsDir      string
+		finalizer    *finalize.Finalizer
+		buffer       *bytes.Buffer
+		mockCtrl     *gomock.Controller
+		mockCommand  *MockCommand
+		mockVersions *MockVersions
+		server       *httptest.Server
+		published    map[string]string
+		auth         map[string]string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockCommand = NewMockCommand(mockCtrl)
+		mockVersions = NewMockVersions(mockCtrl)
+		mockVersions.EXPECT().HasGem(gomock.Any()).AnyTimes().Return(false, nil)
+
+		published = map[string]string{}
+		auth = map[string]string{}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "PUT" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			published[r.URL.Path] = string(body)
+			auth[r.URL.Path] = r.Header.Get("Authorization")
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+		args := []string{buildDir, "", depsDir, "0"}
+		finalizer = &finalize.Finalizer{
+			Stager:   libbuildpack.NewStager(args, logger, &libbuildpack.Manifest{}),
+			Versions: mockVersions,
+			Command:  mockCommand,
+			Log:      logger,
+			Flags:    featureflags.New([]string{}),
+			Config: &config.Config{Contracts: config.Contracts{
+				Tasks:     []string{"pact:generate"},
+				Artifacts: []string{"spec/pacts/*.json", "swagger/v1/*.yaml"},
+				BrokerURL: server.URL + "/",
+				Version:   "1.2.3",
+			}},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		mockCtrl.Finish()
+		os.Unsetenv("VCAP_SERVICES")
+		os.Unsetenv("VCAP_APPLICATION")
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	generate := func(cmd *exec.Cmd) error {
+		Expect(cmd.Args).To(Equal([]string{"bundle", "exec", "rake", "pact:generate"}))
+		Expect(os.MkdirAll(filepath.Join(cmd.Dir, "spec", "pacts"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(cmd.Dir, "swagger", "v1"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(cmd.Dir, "spec", "pacts", "web-orders.json"), []byte(`{"consumer":{"name":"web"},"provider":{"name":"orders api"}}`), 0644)).To(Succeed())
+		return ioutil.WriteFile(filepath.Join(cmd.Dir, "swagger", "v1", "swagger.yaml"), []byte("openapi: 3.0.1\n"), 0644)
+	}
+
+	It("does nothing when no contract tasks are configured", func() {
+		finalizer.Config = &config.Config{}
+		Expect(finalizer.PublishContracts()).To(Succeed())
+		Expect(published).To(BeEmpty())
+	})
+
+	It("runs the tasks and publishes the artifacts", func() {
+		mockCommand.EXPECT().Run(gomock.Any()).DoAndReturn(generate)
+
+		Expect(finalizer.PublishContracts()).To(Succeed())
+		Expect(published).To(HaveKeyWithValue("/pacts/provider/orders api/consumer/web/version/1.2.3", `{"consumer":{"name":"web"},"provider":{"name":"orders api"}}`))
+		Expect(published).To(HaveKeyWithValue("/contracts/1.2.3/swagger/v1/swagger.yaml", "openapi: 3.0.1\n"))
+		Expect(buffer.String()).To(ContainSubstring("Publishing API contracts to " + server.URL))
+		Expect(buffer.String()).To(ContainSubstring("Published spec/pacts/web-orders.json"))
+	})
+
+	It("authenticates with the credentials of the bound service", func() {
+		finalizer.Config.Contracts.Service = "pact-broker"
+		os.Setenv("VCAP_SERVICES", `{"user-provided":[{"name":"other","credentials":{"token":"nope"}},{"name":"pact-broker","credentials":{"token":"s3cr3t-token"}}]}`)
+		mockCommand.EXPECT().Run(gomock.Any()).DoAndReturn(generate)
+
+		Expect(finalizer.PublishContracts()).To(Succeed())
+		Expect(auth).To(HaveKeyWithValue("/contracts/1.2.3/swagger/v1/swagger.yaml", "Bearer s3cr3t-token"))
+	})
+
+	It("returns an error when the service is not bound", func() {
+		finalizer.Config.Contracts.Service = "pact-broker"
+		Expect(finalizer.PublishContracts()).To(MatchError("contracts.service pact-broker is not bound to the app"))
+	})
+
+	It("publishes with the app version when no version is configured", func() {
+		finalizer.Config.Contracts.Version = ""
+		os.Setenv("VCAP_APPLICATION", `{"application_version":"0a1b2c"}`)
+		mockCommand.EXPECT().Run(gomock.Any()).DoAndReturn(generate)
+
+		Expect(finalizer.PublishContracts()).To(Succeed())
+		Expect(published).To(HaveKey("/contracts/0a1b2c/swagger/v1/swagger.yaml"))
+	})
+
+	It("fails staging when a task fails", func() {
+		mockCommand.EXPECT().Run(gomock.Any()).Return(errors.New("exit status 1"))
+		Expect(finalizer.PublishContracts()).To(MatchError("rake pact:generate: exit status 1"))
+	})
+
+	It("only warns when the broker is unreachable", func() {
+		server.Close()
+		mockCommand.EXPECT().Run(gomock.Any()).DoAndReturn(generate)
+
+		Expect(finalizer.PublishContracts()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Unable to publish spec/pacts/web-orders.json"))
+	})
+})
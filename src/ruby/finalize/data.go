@@ -42,3 +42,50 @@ params = CGI.parse(uri.query || "")
   <%= key %>: <%= value.first %>
 <% end %>
 `
+
+const data_migrations_rake = `# Generated by the ruby buildpack because BP_DATA_MIGRATIONS=true
+namespace :cf do
+  desc "Run the data migrations on the first instance of a release"
+  task data_migrations: :environment do
+    next unless ENV.fetch("CF_INSTANCE_INDEX", "0") == "0"
+
+    %%w[%s].each { |task| Rake::Task[task].invoke }
+  end
+end
+`
+
+const review_app_rake = `# Generated by the ruby buildpack because BP_REVIEW_APP=true
+namespace :cf do
+  namespace :review_app do
+    desc "Load the schema and seeds into an empty review app database, migrate it otherwise"
+    task setup: :environment do
+      next unless ENV.fetch("CF_INSTANCE_INDEX", "0") == "0"
+
+      tables = ActiveRecord::Base.connection.tables - %w[schema_migrations ar_internal_metadata]
+      if tables.empty?
+        ENV["DISABLE_DATABASE_ENVIRONMENT_CHECK"] = "1"
+        Rake::Task["db:schema:load"].invoke
+        Rake::Task["db:seed"].invoke
+      else
+        Rake::Task["db:migrate"].invoke
+      end
+    end
+  end
+end
+`
+
+const healthcheck_ru = `# Generated by the ruby buildpack because BP_RACK_HEALTHCHECK is set, it
+# answers %[1]s itself and hands every other request to config.ru
+require 'rack'
+
+app = Rack::Builder.parse_file(File.expand_path('config.ru', __dir__))
+app = app.first if app.is_a?(Array)
+
+run lambda { |env|
+  if env['PATH_INFO'] == %[1]q
+    [200, { 'content-type' => 'text/plain' }, ['ok']]
+  else
+    app.call(env)
+  end
+}
+`
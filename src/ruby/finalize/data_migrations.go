@@ -0,0 +1,132 @@
+package finalize
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"ruby/filesystem"
+	"sort"
+	"strings"
+)
+
+const dataMigrationsRelease = "bundle exec rake cf:data_migrations"
+
+// dataMigrationGem is a gem which keeps data migrations in the app, Task is
+// the rake task its authors recommend running on every release
+type dataMigrationGem struct {
+	Gem  string
+	Task string
+	// Dir holds the migrations, Pattern their file names
+	Dir     string
+	Pattern *regexp.Regexp
+}
+
+var dataMigrationGems = []dataMigrationGem{
+	{Gem: "after_party", Task: "after_party:run", Dir: "lib/tasks/deployment", Pattern: regexp.MustCompile(`^(\d{14})_\w+\.rake$`)},
+	{Gem: "data_migrate", Task: "data:migrate", Dir: "db/data", Pattern: regexp.MustCompile(`^(\d{14})_\w+\.rb$`)},
+	// The tasks of maintenance_tasks are run by hand from its UI or CLI
+	{Gem: "maintenance_tasks", Dir: "app/tasks/maintenance", Pattern: regexp.MustCompile(`^()\w+_task\.rb$`)},
+}
+
+// SetupDataMigrations checks the migrations of data migration gems which
+// are in the Gemfile, failing staging for migrations the gem would refuse
+// at release. With BP_DATA_MIGRATIONS=true the web process of the first
+// instance runs the release tasks of those gems before it starts, like
+// db:migrate for the schema.
+func (f *Finalizer) SetupDataMigrations(processTypes map[string]string) error {
+	var tasks []string
+	for _, gem := range dataMigrationGems {
+		if has, err := f.Versions.HasGem(gem.Gem); err != nil {
+			return err
+		} else if !has {
+			continue
+		}
+		if err := f.checkDataMigrations(gem); err != nil {
+			return err
+		}
+		if gem.Task != "" {
+			tasks = append(tasks, gem.Task)
+		}
+	}
+	if len(tasks) == 0 || !f.Flags.Bool("BP_DATA_MIGRATIONS") {
+		return nil
+	}
+
+	f.Log.BeginStep("Running %s on the first instance before the web process starts", strings.Join(tasks, " and "))
+	rakefile := filepath.Join(f.Stager.BuildDir(), "lib", "tasks", "cf_data_migrations.rake")
+	if err := f.fs().MkdirAll(filepath.Dir(rakefile), 0755); err != nil {
+		return err
+	}
+	if err := f.fs().WriteFile(rakefile, []byte(fmt.Sprintf(data_migrations_rake, strings.Join(tasks, " "))), 0644); err != nil {
+		return err
+	}
+
+	if procfileWeb, err := f.webCommand(""); err != nil {
+		return err
+	} else if procfileWeb != "" {
+		if !strings.Contains(procfileWeb, dataMigrationsRelease) {
+			f.Log.Warning("The Procfile web process replaces the buildpack's, prefix it with '%s && ' to run the data migrations", dataMigrationsRelease)
+		}
+		return nil
+	}
+
+	// The data migrations run once the review app has its schema
+	if web, found := processTypes["web"]; found {
+		if strings.HasPrefix(web, reviewAppSetup+" && ") {
+			processTypes["web"] = reviewAppSetup + " && " + dataMigrationsRelease + " && " + strings.TrimPrefix(web, reviewAppSetup+" && ")
+		} else {
+			processTypes["web"] = dataMigrationsRelease + " && " + web
+		}
+	}
+	return nil
+}
+
+// checkDataMigrations warns about files in the migrations directory of gem
+// which it ignores, and fails for versions used by several migrations
+func (f *Finalizer) checkDataMigrations(gem dataMigrationGem) error {
+	dir := filepath.Join(f.Stager.BuildDir(), gem.Dir)
+	if exists, err := filesystem.Exists(f.fs(), dir); err != nil {
+		return err
+	} else if !exists {
+		f.Log.Warning("%s is in the Gemfile but %s does not exist", gem.Gem, gem.Dir)
+		return nil
+	}
+
+	files, err := f.fs().Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	versions := map[string][]string{}
+	var ignored, duplicates []string
+	for _, file := range files {
+		name := filepath.Base(file)
+		if info, err := f.fs().Stat(file); err != nil {
+			return err
+		} else if info.IsDir() {
+			continue
+		}
+		m := gem.Pattern.FindStringSubmatch(name)
+		if m == nil {
+			ignored = append(ignored, name)
+			continue
+		}
+		if m[1] != "" {
+			versions[m[1]] = append(versions[m[1]], name)
+		}
+	}
+	for _, names := range versions {
+		if len(names) > 1 {
+			duplicates = append(duplicates, strings.Join(names, " and "))
+		}
+	}
+	sort.Strings(duplicates)
+
+	if len(ignored) > 0 {
+		f.Log.Warning("%s ignores these files in %s, their names do not match %s: %s", gem.Gem, gem.Dir, gem.Pattern, strings.Join(ignored, ", "))
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%s migrations in %s share a version: %s", gem.Gem, gem.Dir, strings.Join(duplicates, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/featureflags"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SetupDataMigrations", func() {
+	var (
+		err          error
+		buildDir     string
+		finalizer    *finalize.Finalizer
+		mockCtrl     *gomock.Controller
+		buffer       *bytes.Buffer
+		gems         map[string]bool
+		processTypes map[string]string
+	)
+
+	write := func(path string) {
+		Expect(os.MkdirAll(filepath.Join(buildDir, filepath.Dir(path)), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, path), []byte(""), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockVersions := NewMockVersions(mockCtrl)
+		gems = map[string]bool{"after_party": true}
+		mockVersions.EXPECT().HasGem(gomock.Any()).AnyTimes().DoAndReturn(func(gem string) (bool, error) {
+			return gems[gem], nil
+		})
+
+		args := []string{buildDir, "", "", ""}
+		stager := libbuildpack.NewStager(args, logger, &libbuildpack.Manifest{})
+
+		finalizer = &finalize.Finalizer{
+			Stager:   stager,
+			Versions: mockVersions,
+			Log:      logger,
+			Flags:    featureflags.New([]string{"BP_DATA_MIGRATIONS=true"}),
+		}
+		processTypes = map[string]string{"web": "bin/rails server"}
+
+		write("lib/tasks/deployment/20240101120000_backfill_names.rake")
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("writes the release rake task and runs it before the web process", func() {
+		Expect(finalizer.SetupDataMigrations(processTypes)).To(Succeed())
+		Expect(processTypes["web"]).To(Equal("bundle exec rake cf:data_migrations && bin/rails server"))
+
+		rakefile, err := ioutil.ReadFile(filepath.Join(buildDir, "lib", "tasks", "cf_data_migrations.rake"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(rakefile)).To(ContainSubstring(`%w[after_party:run].each`))
+		Expect(string(rakefile)).To(ContainSubstring(`ENV.fetch("CF_INSTANCE_INDEX", "0") == "0"`))
+	})
+
+	It("runs every detected gem's task after the review app setup", func() {
+		gems["data_migrate"] = true
+		write("db/data/20240102120000_fill_slugs.rb")
+		processTypes["web"] = "bundle exec rake cf:review_app:setup && bin/rails server"
+
+		Expect(finalizer.SetupDataMigrations(processTypes)).To(Succeed())
+		Expect(processTypes["web"]).To(Equal("bundle exec rake cf:review_app:setup && bundle exec rake cf:data_migrations && bin/rails server"))
+
+		rakefile, err := ioutil.ReadFile(filepath.Join(buildDir, "lib", "tasks", "cf_data_migrations.rake"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(rakefile)).To(ContainSubstring(`%w[after_party:run data:migrate].each`))
+	})
+
+	It("fails for migrations which share a version", func() {
+		gems["data_migrate"] = true
+		write("db/data/20240102120000_fill_slugs.rb")
+		write("db/data/20240102120000_fill_titles.rb")
+
+		err := finalizer.SetupDataMigrations(processTypes)
+		Expect(err).To(MatchError("data_migrate migrations in db/data share a version: 20240102120000_fill_slugs.rb and 20240102120000_fill_titles.rb"))
+	})
+
+	It("warns about files the gem ignores", func() {
+		write("lib/tasks/deployment/backfill_emails.rake")
+
+		Expect(finalizer.SetupDataMigrations(processTypes)).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("after_party ignores these files in lib/tasks/deployment"))
+		Expect(buffer.String()).To(ContainSubstring("backfill_emails.rake"))
+	})
+
+	It("warns when the migrations directory is missing", func() {
+		gems["data_migrate"] = true
+
+		Expect(finalizer.SetupDataMigrations(processTypes)).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("data_migrate is in the Gemfile but db/data does not exist"))
+	})
+
+	Context("the app only has maintenance_tasks", func() {
+		BeforeEach(func() {
+			gems = map[string]bool{"maintenance_tasks": true}
+			write("app/tasks/maintenance/backfill_task.rb")
+			write("app/tasks/maintenance/helper.rb")
+		})
+
+		It("checks the tasks but leaves the web process alone", func() {
+			Expect(finalizer.SetupDataMigrations(processTypes)).To(Succeed())
+			Expect(processTypes["web"]).To(Equal("bin/rails server"))
+			Expect(buffer.String()).To(ContainSubstring("maintenance_tasks ignores these files in app/tasks/maintenance"))
+			Expect(buffer.String()).To(ContainSubstring("helper.rb"))
+			Expect(filepath.Join(buildDir, "lib", "tasks", "cf_data_migrations.rake")).ToNot(BeAnExistingFile())
+		})
+	})
+
+	Context("BP_DATA_MIGRATIONS is not set", func() {
+		BeforeEach(func() {
+			finalizer.Flags = featureflags.New([]string{})
+		})
+
+		It("checks the migrations but does not run them", func() {
+			write("lib/tasks/deployment/20240101120000_backfill_emails.rake")
+
+			Expect(finalizer.SetupDataMigrations(processTypes)).To(MatchError(ContainSubstring("share a version")))
+			Expect(processTypes["web"]).To(Equal("bin/rails server"))
+			Expect(filepath.Join(buildDir, "lib", "tasks", "cf_data_migrations.rake")).ToNot(BeAnExistingFile())
+		})
+	})
+
+	Context("the app has a Procfile web process", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("web: bundle exec puma\n"), 0644)).To(Succeed())
+		})
+
+		It("warns that the Procfile must run the release task", func() {
+			Expect(finalizer.SetupDataMigrations(processTypes)).To(Succeed())
+			Expect(processTypes["web"]).To(Equal("bin/rails server"))
+			Expect(buffer.String()).To(ContainSubstring("prefix it with 'bundle exec rake cf:data_migrations && '"))
+		})
+	})
+})
@@ -0,0 +1,33 @@
+package finalize
+
+import (
+	"ruby/report"
+	"strings"
+)
+
+// recordEgress adds the hosts of urls to the outbound hosts of the staging
+// report
+func (f *Finalizer) recordEgress(purpose string, urls ...string) {
+	if err := report.RecordEgress(f.Stager.DepDir(), purpose, urls...); err != nil {
+		f.Log.Debug("Unable to record the outbound hosts of the %s: %v", purpose, err)
+	}
+}
+
+// ReportEgress lists the outbound hosts supply and finalize contacted, or
+// had bundler and yarn contact, for network policies of the staging cells.
+// The staging report keeps the same list as egress.
+func (f *Finalizer) ReportEgress() {
+	r, err := report.Load(f.Stager.DepDir())
+	if err != nil {
+		f.Log.Debug("Unable to load the outbound hosts: %v", err)
+		return
+	}
+	if len(r.Egress) == 0 {
+		f.Log.BeginStep("Staging contacted no outbound hosts")
+		return
+	}
+	f.Log.BeginStep("Outbound hosts contacted during staging")
+	for _, host := range r.Egress {
+		f.Log.Info("%s (%s)", host.Host, strings.Join(host.Purposes, ", "))
+	}
+}
@@ -0,0 +1,57 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/finalize"
+	"ruby/report"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReportEgress", func() {
+	var (
+		err       error
+		depsDir   string
+		buffer    *bytes.Buffer
+		finalizer *finalize.Finalizer
+	)
+
+	BeforeEach(func() {
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{"/app", "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("lists every host supply and finalize contacted", func() {
+		Expect(report.RecordEgress(filepath.Join(depsDir, "0"), "download of ruby", "https://buildpacks.cloudfoundry.org/ruby.tgz")).To(Succeed())
+		Expect(report.RecordEgress(filepath.Join(depsDir, "0"), "gem source", "https://rubygems.org/")).To(Succeed())
+		Expect(report.RecordEgress(filepath.Join(depsDir, "0"), "node packages", "https://registry.yarnpkg.com/left-pad/-/left-pad-1.3.0.tgz")).To(Succeed())
+
+		finalizer.ReportEgress()
+		Expect(buffer.String()).To(ContainSubstring("-----> Outbound hosts contacted during staging\n" +
+			"       buildpacks.cloudfoundry.org:443 (download of ruby)\n" +
+			"       registry.yarnpkg.com:443 (node packages)\n" +
+			"       rubygems.org:443 (gem source)\n"))
+	})
+
+	It("says so when staging contacted nothing", func() {
+		finalizer.ReportEgress()
+		Expect(buffer.String()).To(ContainSubstring("Staging contacted no outbound hosts"))
+	})
+})
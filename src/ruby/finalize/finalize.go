@@ -1,12 +1,21 @@
 package finalize
 
 import (
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"ruby/bundlepath"
+	"ruby/config"
+	"ruby/deprecations"
+	"ruby/depsdir"
+	"ruby/extensions"
+	"ruby/featureflags"
+	"ruby/filesystem"
+	"ruby/generated"
+	"ruby/redact"
+	"ruby/revision"
+	"strings"
 	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
@@ -15,6 +24,7 @@ import (
 
 type Stager interface {
 	BuildDir() string
+	CacheDir() string
 	DepsIdx() string
 	DepDir() string
 }
@@ -23,19 +33,41 @@ type Command interface {
 	Run(*exec.Cmd) error
 }
 
+type Cache interface {
+	RestoreAssets() error
+	SaveAssets() error
+}
+
 type Finalizer struct {
 	Stager           Stager
 	Versions         Versions
 	Log              *libbuildpack.Logger
 	Command          Command
+	Cache            Cache
+	Flags            *featureflags.FeatureFlags
+	Config           *config.Config
+	Deprecations     *deprecations.Tracker
+	FS               filesystem.FS
 	Gem12Factor      bool
 	GemStaticAssets  bool
 	GemStdoutLogging bool
 	RailsVersion     int
+
+	// AttestationKeyFile is the provenance.KeyFile in the buildpack dir
+	AttestationKeyFile string
+	// appAssetCache is set when the app was pushed with its sprockets cache
+	appAssetCache bool
 }
 
-func Run(f *Finalizer) error {
-	f.Log.BeginStep("Finalizing Ruby")
+func Run(f *Finalizer) (err error) {
+	if rev := f.appRevision(); rev != "" {
+		f.Log.BeginStep("Finalizing Ruby for revision %s", revision.Short(rev))
+	} else {
+		f.Log.BeginStep("Finalizing Ruby")
+	}
+	// The stage ends before the metrics are written, failures end it here
+	stage := f.beginStage("finalize")
+	defer func() { stage.End(err) }()
 
 	if err := f.AssetGemfileLockExists(); err != nil {
 		f.Log.Error(err.Error())
@@ -57,6 +89,11 @@ func Run(f *Finalizer) error {
 		return err
 	}
 
+	if err := f.runExtensions(extensions.AfterFinalizeSetup); err != nil {
+		f.Log.Error("%s", err.Error())
+		return err
+	}
+
 	if err := f.InstallPlugins(); err != nil {
 		f.Log.Error("Error installing plugins: %v", err)
 		return err
@@ -67,34 +104,162 @@ func Run(f *Finalizer) error {
 		return err
 	}
 
-	if err := f.PrecompileAssets(); err != nil {
+	if err := f.Cache.RestoreAssets(); err != nil {
+		f.Log.Error("Error restoring the asset caches: %v", err)
+		return err
+	}
+
+	if err := f.InstallVendoredNodePackages(); err != nil {
+		f.Log.Error("Error installing the vendored node packages: %v", err)
+		return err
+	}
+
+	if err := f.stage("precompile_assets", f.PrecompileAssets); err != nil {
 		f.Log.Error("Error precompiling assets: %v", err)
 		return err
 	}
 
+	if err := f.CheckAssetFingerprints(); err != nil {
+		f.Log.Error("Error checking compiled assets: %v", err)
+		return err
+	}
+
+	if err := f.CompressAssets(); err != nil {
+		f.Log.Error("Error compressing assets: %v", err)
+		return err
+	}
+
+	if err := f.SaveAssetCaches(); err != nil {
+		f.Log.Error("Error saving the asset caches: %v", err)
+		return err
+	}
+
+	if err := f.runExtensions(extensions.AfterAssets); err != nil {
+		f.Log.Error("%s", err.Error())
+		return err
+	}
+
+	if err := f.stage("rake_tasks", f.RunRakeTasks); err != nil {
+		f.Log.Error("Error running rake tasks: %v", err)
+		return err
+	}
+
+	if err := f.PublishContracts(); err != nil {
+		f.Log.Error("Error generating API contracts: %v", err)
+		return err
+	}
+
+	if err := f.CheckSchedules(); err != nil {
+		f.Log.Error("Error checking scheduled jobs: %v", err)
+		return err
+	}
+
 	f.BestPracticeWarnings()
 
+	if err := f.PruneNodeModules(); err != nil {
+		f.Log.Error("Error removing node_modules: %v", err)
+		return err
+	}
+
+	if err := f.PruneAssetGems(); err != nil {
+		f.Log.Error("Error pruning the asset gems: %v", err)
+		return err
+	}
+
 	if err := f.DeleteVendorBundle(); err != nil {
 		f.Log.Error("Error deleting vendor/bundle: %v", err)
 		return err
 	}
 
+	if err := f.PruneFiles(); err != nil {
+		f.Log.Error("Error pruning files: %v", err)
+		return err
+	}
+
 	if err := f.CopyToAppBin(); err != nil {
 		f.Log.Error("Error creating files in bin: %v", err)
 		return err
 	}
 
+	if err := f.WriteConsoleEnv(); err != nil {
+		f.Log.Error("Error writing bin/console-env: %v", err)
+		return err
+	}
+
+	if err := f.GuardProfileScripts(); err != nil {
+		f.Log.Error("Error guarding the app's .profile scripts: %v", err)
+		return err
+	}
+
+	if err := f.WriteLoggingDefaults(); err != nil {
+		f.Log.Error("Error writing logging defaults: %v", err)
+		return err
+	}
+
+	if err := f.WriteConcurrencyDefaults(); err != nil {
+		f.Log.Error("Error writing concurrency defaults: %v", err)
+		return err
+	}
+
+	if err := f.WriteRevision(); err != nil {
+		f.Log.Error("Error writing the app revision: %v", err)
+		return err
+	}
+
 	data, err := f.GenerateReleaseYaml()
 	if err != nil {
 		f.Log.Error("Error generating release YAML: %v", err)
 		return err
 	}
+	if err := f.SetupReviewApp(data["default_process_types"]); err != nil {
+		f.Log.Error("Error setting up review app: %v", err)
+		return err
+	}
+	if err := f.SetupDataMigrations(data["default_process_types"]); err != nil {
+		f.Log.Error("Error setting up data migrations: %v", err)
+		return err
+	}
+	if err := f.SetupRackHealthcheck(data["default_process_types"]); err != nil {
+		f.Log.Error("Error setting up the health check endpoint: %v", err)
+		return err
+	}
+	if err := f.WriteProcessEnv(data["default_process_types"]); err != nil {
+		f.Log.Error("Error writing process environment: %v", err)
+		return err
+	}
+	if err := f.ConfigureCable(); err != nil {
+		f.Log.Error("Error configuring ActionCable: %v", err)
+		return err
+	}
+	if err := f.ConfigureSQLite(); err != nil {
+		f.Log.Error("Error configuring sqlite3: %v", err)
+		return err
+	}
+	if err := f.runExtensions(extensions.AfterFinalize); err != nil {
+		f.Log.Error("%s", err.Error())
+		return err
+	}
+	f.ReportEgress()
+	stage.End(nil)
+	if err := f.WriteMetrics(); err != nil {
+		f.Log.Error("Error writing staging metrics: %v", err)
+		return err
+	}
+	if err := generated.Record(f.Stager.DepDir(), f.Stager.CacheDir(), f.Log); err != nil {
+		f.Log.Error("Error recording generated scripts: %v", err)
+		return err
+	}
 	releasePath := filepath.Join(f.Stager.BuildDir(), "tmp", "ruby-buildpack-release-step.yml")
 	if err := libbuildpack.NewYAML().Write(releasePath, data); err != nil {
 		f.Log.Error("Error writing release YAML: %v", err)
 		return err
 	}
 
+	if err := f.stage("boot_check", func() error { return f.BootCheck(data["default_process_types"]["web"]) }); err != nil {
+		f.Log.Error("Error checking that the app boots: %v", err)
+		return err
+	}
+
 	return nil
 }
 
@@ -124,11 +289,44 @@ func (f *Finalizer) Setup() error {
 	return nil
 }
 
+// gemfile is the app's Gemfile relative to the build dir, BUNDLE_GEMFILE
+// points dual boot apps at another one such as Gemfile_next
+func gemfile() string {
+	if gemfile := os.Getenv("BUNDLE_GEMFILE"); gemfile != "" {
+		return gemfile
+	}
+	return "Gemfile"
+}
+
+// deps is the dep dir of the buildpack
+func (f *Finalizer) deps() depsdir.Dir {
+	return depsdir.Dir(f.Stager.DepDir())
+}
+
+// bundlePath is where supply installed the gems, supply already failed
+// staging for a BP_BUNDLE_PATH which does not resolve
+func (f *Finalizer) bundlePath() bundlepath.Path {
+	path, err := bundlepath.Resolve(f.Flags.String("BP_BUNDLE_PATH"), f.Stager.BuildDir(), f.deps(), f.Stager.DepsIdx())
+	if err != nil {
+		return bundlepath.Default(f.deps(), f.Stager.DepsIdx())
+	}
+	return path
+}
+
+// fs is the filesystem the app and deps dir are on, tests can set FS to an
+// in-memory one
+func (f *Finalizer) fs() filesystem.FS {
+	if f.FS == nil {
+		return filesystem.OS
+	}
+	return f.FS
+}
+
 func (f *Finalizer) AssetGemfileLockExists() error {
-	if exists, err := libbuildpack.FileExists(filepath.Join(f.Stager.BuildDir(), "Gemfile.lock")); err != nil {
+	if exists, err := filesystem.Exists(f.fs(), filepath.Join(f.Stager.BuildDir(), gemfile()+".lock")); err != nil {
 		return err
 	} else if !exists {
-		return errors.New("Gemfile.lock required")
+		return fmt.Errorf("%s.lock required", gemfile())
 	}
 	return nil
 }
@@ -136,16 +334,12 @@ func (f *Finalizer) AssetGemfileLockExists() error {
 func (f *Finalizer) RestoreGemfileLock() error {
 	source := filepath.Join(f.Stager.DepDir(), "Gemfile.lock")
 	f.Log.Debug("RestoreGemfileLock; %s", source)
-	if exists, err := libbuildpack.FileExists(source); err != nil {
+	if exists, err := filesystem.Exists(f.fs(), source); err != nil {
 		return err
 	} else if exists {
-		gemfile := "Gemfile"
-		if os.Getenv("BUNDLE_GEMFILE") != "" {
-			gemfile = os.Getenv("BUNDLE_GEMFILE")
-		}
-		target := filepath.Join(f.Stager.BuildDir(), gemfile) + ".lock"
+		target := filepath.Join(f.Stager.BuildDir(), gemfile()) + ".lock"
 		f.Log.Debug("RestoreGemfileLock; exists, copy to %s", target)
-		return os.Rename(source, target)
+		return f.fs().Rename(source, target)
 	}
 	return nil
 }
@@ -153,19 +347,42 @@ func (f *Finalizer) RestoreGemfileLock() error {
 func (f *Finalizer) RestoreBundleConfig() error {
 	source := filepath.Join(f.Stager.DepDir(), "bundle_config")
 	f.Log.Debug("RestoreBundleConfig; %s", source)
-	if exists, err := libbuildpack.FileExists(source); err != nil {
+	if exists, err := filesystem.Exists(f.fs(), source); err != nil {
 		return err
 	} else if exists {
 		target := filepath.Join(f.Stager.BuildDir(), ".bundle", "config")
 		f.Log.Debug("RestoreBundleConfig; exists, copy to %s", target)
-		os.MkdirAll(filepath.Join(f.Stager.BuildDir(), ".bundle"), 0755)
-		return os.Rename(source, target)
+		f.fs().MkdirAll(filepath.Join(f.Stager.BuildDir(), ".bundle"), 0755)
+		if err := f.fs().Rename(source, target); err != nil {
+			return err
+		}
+		return f.scrubBundleConfig(target)
 	}
 	return nil
 }
 
+// scrubBundleConfig removes gem source credentials from the bundler config
+// in the droplet, the gems are installed so the app does not need them
+func (f *Finalizer) scrubBundleConfig(path string) error {
+	data, err := f.fs().ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sourceKeys := redact.SourceKeys(filepath.Join(f.Stager.BuildDir(), gemfile()) + ".lock")
+	scrubbed, changed, err := redact.ScrubBundleConfig(data, sourceKeys)
+	if err != nil {
+		f.Log.Warning("Could not parse .bundle/config, any gem source credentials in it will be in the droplet")
+		return nil
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	f.Log.BeginStep("Removing gem source credentials from .bundle/config: %s", strings.Join(changed, ", "))
+	return f.fs().WriteFile(path, scrubbed, 0644)
+}
+
 func (f *Finalizer) WriteDatabaseYml() error {
-	if exists, err := libbuildpack.FileExists(filepath.Join(f.Stager.BuildDir(), "config")); err != nil {
+	if exists, err := filesystem.Exists(f.fs(), filepath.Join(f.Stager.BuildDir(), "config")); err != nil {
 		return err
 	} else if !exists {
 		return nil
@@ -177,7 +394,7 @@ func (f *Finalizer) WriteDatabaseYml() error {
 	}
 
 	f.Log.BeginStep("Writing config/database.yml to read from DATABASE_URL")
-	if err := ioutil.WriteFile(filepath.Join(f.Stager.BuildDir(), "config", "database.yml"), []byte(config_database_yml), 0644); err != nil {
+	if err := f.fs().WriteFile(filepath.Join(f.Stager.BuildDir(), "config", "database.yml"), []byte(config_database_yml), 0644); err != nil {
 		return err
 	}
 
@@ -224,7 +441,21 @@ func (f *Finalizer) hasPrecompiledAssets() (bool, error) {
 	return false, nil
 }
 
+// assetsCompiled reports whether sprockets or webpacker compiled assets
+// into public
+func (f *Finalizer) assetsCompiled() (bool, error) {
+	if compiled, err := f.hasPrecompiledAssets(); err != nil || compiled {
+		return compiled, err
+	}
+	return libbuildpack.FileExists(filepath.Join(f.Stager.BuildDir(), "public", "packs", "manifest.json"))
+}
+
 func (f *Finalizer) PrecompileAssets() error {
+	if f.Config.Assets.SkipPrecompile {
+		f.Log.Info("Skipping assets:precompile, assets.skip_precompile is set in %s", config.Path)
+		return nil
+	}
+
 	if exists, err := f.hasPrecompiledAssets(); err != nil {
 		return err
 	} else if exists {
@@ -242,29 +473,52 @@ func (f *Finalizer) PrecompileAssets() error {
 	if _, exists := os.LookupEnv("SECRET_KEY_BASE"); !exists {
 		env = append(env, "SECRET_KEY_BASE=dummy-staging-key")
 	}
+	if _, exists := os.LookupEnv("YARN_CACHE_FOLDER"); !exists {
+		env = append(env, "YARN_CACHE_FOLDER="+f.deps().Join(depsdir.YarnCache))
+	}
 
 	f.Log.BeginStep("Precompiling assets")
 	startTime := time.Now()
+	digest, variables, err := f.assetSourcesDigest()
+	if err != nil {
+		return err
+	}
+	f.Log.Info("Asset sources digest %s, of the app without %s and of %s", digest[:12], strings.Join(assetSourcesIgnored, ", "), strings.Join(variables, ", "))
+	if reused, err := f.reuseCompiledAssets(digest); err != nil {
+		return err
+	} else if reused {
+		f.Log.Info("Using the assets compiled from the same sources from cache, sources digest %s", digest[:12])
+		f.recordComponent("assets", startTime, true)
+		return nil
+	}
+	warm, err := f.restoreAssetCache()
+	if err != nil {
+		return err
+	}
 	cmd = exec.Command("bundle", "exec", "rake", "assets:precompile")
 	cmd.Dir = f.Stager.BuildDir()
-	cmd.Stdout = text.NewIndentWriter(os.Stdout, []byte("       "))
-	cmd.Stderr = text.NewIndentWriter(os.Stderr, []byte("       "))
+	cmd.Stdout = text.NewIndentWriter(f.Log.Output(), []byte("       "))
+	cmd.Stderr = text.NewIndentWriter(f.Log.Output(), []byte("       "))
 	cmd.Env = env
-	err := f.Command.Run(cmd)
+	err = f.Command.Run(cmd)
 
 	f.Log.Info("Asset precompilation completed (%v)", time.Since(startTime))
+	f.recordComponent("assets", startTime, warm)
 
 	if f.RailsVersion >= 4 && err == nil {
 		f.Log.Info("Cleaning assets")
 		cmd = exec.Command("bundle", "exec", "rake", "assets:clean")
 		cmd.Dir = f.Stager.BuildDir()
-		cmd.Stdout = text.NewIndentWriter(os.Stdout, []byte("       "))
-		cmd.Stderr = text.NewIndentWriter(os.Stderr, []byte("       "))
+		cmd.Stdout = text.NewIndentWriter(f.Log.Output(), []byte("       "))
+		cmd.Stderr = text.NewIndentWriter(f.Log.Output(), []byte("       "))
 		cmd.Env = env
 		err = f.Command.Run(cmd)
 	}
+	if err != nil {
+		return err
+	}
 
-	return err
+	return f.cacheCompiledAssets(digest)
 }
 
 func (f *Finalizer) InstallPlugins() error {
@@ -314,10 +568,10 @@ rescue Exception => ex
 end
 `
 
-	if err := os.MkdirAll(filepath.Join(f.Stager.BuildDir(), "vendor", "plugins", "rails_log_stdout"), 0755); err != nil {
+	if err := f.fs().MkdirAll(filepath.Join(f.Stager.BuildDir(), "vendor", "plugins", "rails_log_stdout"), 0755); err != nil {
 		return fmt.Errorf("Error creating rails_log_stdout plugin directory: %v", err)
 	}
-	if err := ioutil.WriteFile(filepath.Join(f.Stager.BuildDir(), "vendor", "plugins", "rails_log_stdout", "init.rb"), []byte(code), 0644); err != nil {
+	if err := f.fs().WriteFile(filepath.Join(f.Stager.BuildDir(), "vendor", "plugins", "rails_log_stdout", "init.rb"), []byte(code), 0644); err != nil {
 		return fmt.Errorf("Error writing rails_log_stdout plugin file: %v", err)
 	}
 	return nil
@@ -332,10 +586,10 @@ func (f *Finalizer) installPluginServeStaticAssets() error {
 
 	code := "Rails.application.class.config.serve_static_assets = true\n"
 
-	if err := os.MkdirAll(filepath.Join(f.Stager.BuildDir(), "vendor", "plugins", "rails3_serve_static_assets"), 0755); err != nil {
+	if err := f.fs().MkdirAll(filepath.Join(f.Stager.BuildDir(), "vendor", "plugins", "rails3_serve_static_assets"), 0755); err != nil {
 		return fmt.Errorf("Error creating rails3_serve_static_assets plugin directory: %v", err)
 	}
-	if err := ioutil.WriteFile(filepath.Join(f.Stager.BuildDir(), "vendor", "plugins", "rails3_serve_static_assets", "init.rb"), []byte(code), 0644); err != nil {
+	if err := f.fs().WriteFile(filepath.Join(f.Stager.BuildDir(), "vendor", "plugins", "rails3_serve_static_assets", "init.rb"), []byte(code), 0644); err != nil {
 		return fmt.Errorf("Error writing rails3_serve_static_assets plugin file: %v", err)
 	}
 	return nil
@@ -347,6 +601,114 @@ func (f *Finalizer) BestPracticeWarnings() {
 	}
 }
 
+// RunRakeTasks runs the rake_tasks from the app's config file once assets
+// are compiled, with the same environment as assets:precompile
+func (f *Finalizer) RunRakeTasks() error {
+	if len(f.Config.RakeTasks) == 0 {
+		return nil
+	}
+
+	return f.runRakeTasks(f.Config.RakeTasks)
+}
+
+func (f *Finalizer) runRakeTasks(tasks []string) error {
+	env := f.rakeEnv()
+	for _, task := range tasks {
+		f.Log.BeginStep("Running rake %s", task)
+		cmd := exec.Command("bundle", "exec", "rake", task)
+		cmd.Dir = f.Stager.BuildDir()
+		cmd.Stdout = text.NewIndentWriter(f.Log.Output(), []byte("       "))
+		cmd.Stderr = text.NewIndentWriter(f.Log.Output(), []byte("       "))
+		cmd.Env = env
+		if err := f.Command.Run(cmd); err != nil {
+			return fmt.Errorf("rake %s: %v", task, err)
+		}
+	}
+	return nil
+}
+
+// rakeEnv lets the app boot while staging, without its database or secrets
+func (f *Finalizer) rakeEnv() []string {
+	env := append(os.Environ(), fmt.Sprintf("DATABASE_URL=%s", f.databaseUrl()))
+	if _, exists := os.LookupEnv("SECRET_KEY_BASE"); !exists {
+		env = append(env, "SECRET_KEY_BASE=dummy-staging-key")
+	}
+	return env
+}
+
+// PruneFiles removes the paths matching the prune globs in the app's
+// config file from the droplet
+func (f *Finalizer) PruneFiles() error {
+	for _, glob := range f.Config.Prune {
+		matches, err := filepath.Glob(filepath.Join(f.Stager.BuildDir(), glob))
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			rel, err := filepath.Rel(f.Stager.BuildDir(), match)
+			if err != nil {
+				return err
+			}
+			f.Log.Info("Pruning %s", rel)
+			if err := os.RemoveAll(match); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PruneNodeModules drops node_modules from the droplet once a JS bundler
+// has compiled the assets, it is only needed to build them
+func (f *Finalizer) PruneNodeModules() error {
+	nodeModules := filepath.Join(f.Stager.BuildDir(), "node_modules")
+	if exists, err := libbuildpack.FileExists(nodeModules); err != nil {
+		return err
+	} else if !exists || f.Flags.BoolOr("BP_KEEP_NODE_MODULES", f.Config.Assets.KeepNodeModules) {
+		return nil
+	}
+
+	size, err := dirSize(nodeModules)
+	if err != nil {
+		return err
+	}
+
+	bundled := false
+	for _, gem := range []string{"webpacker", "jsbundling-rails"} {
+		if hasGem, err := f.Versions.HasGem(gem); err != nil {
+			return err
+		} else if hasGem {
+			bundled = true
+		}
+	}
+	compiled, err := f.assetsCompiled()
+	if err != nil {
+		return err
+	}
+
+	if bundled && compiled {
+		f.Log.BeginStep("Removing node_modules (%d MB) now that assets are compiled, set BP_KEEP_NODE_MODULES=true to keep it", size>>20)
+		return os.RemoveAll(nodeModules)
+	}
+
+	f.Log.Warning("node_modules adds %d MB to the droplet. If it is only needed to compile assets, remove it in a post-compile step or .cfignore it.", size>>20)
+	return nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
 func (f *Finalizer) DeleteVendorBundle() error {
 	if exists, err := libbuildpack.FileExists(filepath.Join(f.Stager.BuildDir(), "vendor", "bundle")); err != nil {
 		return err
@@ -362,11 +724,11 @@ func (f *Finalizer) CopyToAppBin() error {
 	f.Log.BeginStep("Copy binaries to app/bin directory")
 
 	binDir := filepath.Join(f.Stager.BuildDir(), "bin")
-	if err := os.MkdirAll(binDir, 0755); err != nil {
+	if err := f.fs().MkdirAll(binDir, 0755); err != nil {
 		return fmt.Errorf("Could not create /app/bin directory: %v", err)
 	}
 
-	files, err := ioutil.ReadDir(filepath.Join(f.Stager.DepDir(), "binstubs"))
+	files, err := f.fs().ReadDir(f.deps().Binstubs())
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return fmt.Errorf("Could not read dep/binstubs directory: %v", err)
@@ -374,18 +736,18 @@ func (f *Finalizer) CopyToAppBin() error {
 		files = []os.FileInfo{}
 	}
 	for _, file := range files {
-		source := filepath.Join(f.Stager.DepDir(), "binstubs", file.Name())
+		source := f.deps().Join(depsdir.Binstubs, file.Name())
 		target := filepath.Join(binDir, file.Name())
-		if exists, err := libbuildpack.FileExists(target); err != nil {
+		if exists, err := filesystem.Exists(f.fs(), target); err != nil {
 			return fmt.Errorf("Checking existence: %v", err)
 		} else if !exists {
-			if err := libbuildpack.CopyFile(source, target); err != nil {
+			if err := filesystem.CopyFile(f.fs(), source, target); err != nil {
 				return fmt.Errorf("CopyFile: %v", err)
 			}
 		}
 	}
 
-	files, err = ioutil.ReadDir(filepath.Join(f.Stager.DepDir(), "bin"))
+	files, err = f.fs().ReadDir(f.deps().Bin())
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return fmt.Errorf("Could not read dep/bin directory: %v", err)
@@ -394,11 +756,11 @@ func (f *Finalizer) CopyToAppBin() error {
 	}
 	for _, file := range files {
 		target := filepath.Join(binDir, file.Name())
-		if exists, err := libbuildpack.FileExists(target); err != nil {
+		if exists, err := filesystem.Exists(f.fs(), target); err != nil {
 			return fmt.Errorf("Checking existence: %v", err)
 		} else if !exists {
 			contents := fmt.Sprintf("#!/usr/bin/env ruby\nKernel.exec \"#{ENV['DEPS_DIR']}/%s/bin/%s\", *ARGV\n", f.Stager.DepsIdx(), file.Name())
-			if err := ioutil.WriteFile(target, []byte(contents), 0755); err != nil {
+			if err := f.fs().WriteFile(target, []byte(contents), 0755); err != nil {
 				return fmt.Errorf("WriteFile: %v", err)
 			}
 		}
@@ -7,6 +7,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"ruby/config"
+	"ruby/featureflags"
 	"ruby/finalize"
 	"strings"
 
@@ -59,6 +61,8 @@ var _ = Describe("Finalize", func() {
 			Versions: mockVersions,
 			Command:  mockCommand,
 			Log:      logger,
+			Flags:    featureflags.New([]string{}),
+			Config:   &config.Config{},
 		}
 	})
 
@@ -82,6 +86,22 @@ var _ = Describe("Finalize", func() {
 				Expect(finalizer.AssetGemfileLockExists()).To(Succeed())
 			})
 		})
+		Context("BUNDLE_GEMFILE is Gemfile_next", func() {
+			BeforeEach(func() {
+				os.Setenv("BUNDLE_GEMFILE", "Gemfile_next")
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "Gemfile.lock"), []byte("body"), 0644)).To(Succeed())
+			})
+			AfterEach(func() {
+				os.Unsetenv("BUNDLE_GEMFILE")
+			})
+
+			It("requires Gemfile_next.lock", func() {
+				Expect(finalizer.AssetGemfileLockExists()).To(MatchError("Gemfile_next.lock required"))
+
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "Gemfile_next.lock"), []byte("body"), 0644)).To(Succeed())
+				Expect(finalizer.AssetGemfileLockExists()).To(Succeed())
+			})
+		})
 		Context("Gemfile.lock is missing", func() {
 			BeforeEach(func() {
 				Expect(filepath.Join(buildDir, "Gemfile.lock")).ToNot(BeAnExistingFile())
@@ -108,6 +128,17 @@ var _ = Describe("Finalize", func() {
 			})
 		})
 
+		Context("DEPS/IDX/.bundle_config has gem source credentials", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(filepath.Join(depsDir, depsIdx, "bundle_config"), []byte("---\nBUNDLE_GEMS__CONTRIBSYS__COM: \"user:pass1234\"\nBUNDLE_WITHOUT: development\n"), 0644)).To(Succeed())
+			})
+
+			It("removes them from the config in the build dir", func() {
+				Expect(ioutil.ReadFile(filepath.Join(buildDir, ".bundle", "config"))).To(Equal([]byte("---\nBUNDLE_WITHOUT: development\n")))
+				Expect(buffer.String()).To(ContainSubstring("Removing gem source credentials from .bundle/config: BUNDLE_GEMS__CONTRIBSYS__COM"))
+			})
+		})
+
 		Context("DEPS/IDX/.bundle_config does NOT exist", func() {
 			It("does nothing", func() {
 				Expect(filepath.Join(buildDir, ".bundle", "config")).ToNot(BeARegularFile())
@@ -285,6 +316,17 @@ var _ = Describe("Finalize", func() {
 	})
 
 	Describe("PrecompileAssets", func() {
+		Context("assets.skip_precompile is set in the config file", func() {
+			BeforeEach(func() {
+				finalizer.Config = &config.Config{Assets: config.Assets{SkipPrecompile: true}}
+			})
+
+			It("doesn't run assets:precompile", func() {
+				Expect(finalizer.PrecompileAssets()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Skipping assets:precompile, assets.skip_precompile is set in config/ruby-buildpack.yml"))
+			})
+		})
+
 		Context("app does not have assets:precompile task", func() {
 			It("doesn't run assets:precompile", func() {
 				mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
@@ -435,6 +477,126 @@ var _ = Describe("Finalize", func() {
 		})
 	})
 
+	Describe("RunRakeTasks", func() {
+		It("runs nothing when no tasks are configured", func() {
+			Expect(finalizer.RunRakeTasks()).To(Succeed())
+		})
+
+		Context("rake_tasks are configured", func() {
+			BeforeEach(func() {
+				finalizer.Config = &config.Config{RakeTasks: []string{"sitemap:refresh", "assets:upload"}}
+				mockVersions.EXPECT().HasGem(gomock.Any()).AnyTimes().Return(false, nil)
+			})
+
+			It("runs each task in order", func() {
+				var cmds []*exec.Cmd
+				mockCommand.EXPECT().Run(gomock.Any()).Times(2).Do(func(cmd *exec.Cmd) {
+					cmds = append(cmds, cmd)
+				}).Return(nil)
+
+				Expect(finalizer.RunRakeTasks()).To(Succeed())
+				Expect(cmds[0].Args).To(Equal([]string{"bundle", "exec", "rake", "sitemap:refresh"}))
+				Expect(cmds[1].Args).To(Equal([]string{"bundle", "exec", "rake", "assets:upload"}))
+				Expect(cmds[0].Env).To(ContainElement("SECRET_KEY_BASE=dummy-staging-key"))
+			})
+
+			It("stops at the first failing task", func() {
+				mockCommand.EXPECT().Run(gomock.Any()).Return(errors.New("exit status 1"))
+				Expect(finalizer.RunRakeTasks()).To(MatchError("rake sitemap:refresh: exit status 1"))
+			})
+		})
+	})
+
+	Describe("PruneFiles", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "spec", "fixtures"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "spec", "fixtures", "big.json"), []byte("{}"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "notes.md"), []byte("notes"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "config.ru"), []byte("run App"), 0644)).To(Succeed())
+			finalizer.Config = &config.Config{Prune: []string{"spec", "*.md"}}
+		})
+
+		It("removes paths matching the prune globs", func() {
+			Expect(finalizer.PruneFiles()).To(Succeed())
+			Expect(filepath.Join(buildDir, "spec")).ToNot(BeAnExistingFile())
+			Expect(filepath.Join(buildDir, "notes.md")).ToNot(BeAnExistingFile())
+			Expect(filepath.Join(buildDir, "config.ru")).To(BeAnExistingFile())
+			Expect(buffer.String()).To(ContainSubstring("Pruning spec"))
+		})
+	})
+
+	Describe("PruneNodeModules", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "node_modules", "left-pad"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "node_modules", "left-pad", "index.js"), []byte("module.exports = {}"), 0644)).To(Succeed())
+			finalizer.RailsVersion = 5
+		})
+
+		Context("webpacker compiled the assets", func() {
+			BeforeEach(func() {
+				mockVersions.EXPECT().HasGem("webpacker").Return(true, nil)
+				mockVersions.EXPECT().HasGem(gomock.Any()).AnyTimes().Return(false, nil)
+				Expect(os.MkdirAll(filepath.Join(buildDir, "public", "packs"), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "public", "packs", "manifest.json"), []byte("{}"), 0644)).To(Succeed())
+			})
+
+			It("removes node_modules", func() {
+				Expect(finalizer.PruneNodeModules()).To(Succeed())
+				Expect(filepath.Join(buildDir, "node_modules")).ToNot(BeAnExistingFile())
+				Expect(buffer.String()).To(ContainSubstring("Removing node_modules (0 MB) now that assets are compiled"))
+			})
+		})
+
+		Context("BP_KEEP_NODE_MODULES is true", func() {
+			BeforeEach(func() {
+				finalizer.Flags = featureflags.New([]string{"BP_KEEP_NODE_MODULES=true"})
+			})
+
+			It("keeps node_modules", func() {
+				Expect(finalizer.PruneNodeModules()).To(Succeed())
+				Expect(filepath.Join(buildDir, "node_modules")).To(BeADirectory())
+			})
+		})
+
+		Context("assets.keep_node_modules is set in the config file", func() {
+			BeforeEach(func() {
+				finalizer.Config = &config.Config{Assets: config.Assets{KeepNodeModules: true}}
+			})
+
+			It("keeps node_modules", func() {
+				Expect(finalizer.PruneNodeModules()).To(Succeed())
+				Expect(filepath.Join(buildDir, "node_modules")).To(BeADirectory())
+			})
+
+			Context("BP_KEEP_NODE_MODULES is false", func() {
+				BeforeEach(func() {
+					finalizer.Flags = featureflags.New([]string{"BP_KEEP_NODE_MODULES=false"})
+					mockVersions.EXPECT().HasGem("jsbundling-rails").Return(true, nil)
+					mockVersions.EXPECT().HasGem(gomock.Any()).AnyTimes().Return(false, nil)
+					Expect(os.MkdirAll(filepath.Join(buildDir, "public", "packs"), 0755)).To(Succeed())
+					Expect(ioutil.WriteFile(filepath.Join(buildDir, "public", "packs", "manifest.json"), []byte("{}"), 0644)).To(Succeed())
+				})
+
+				It("lets the environment variable win", func() {
+					Expect(finalizer.PruneNodeModules()).To(Succeed())
+					Expect(filepath.Join(buildDir, "node_modules")).ToNot(BeAnExistingFile())
+				})
+			})
+		})
+
+		Context("no JS bundler is used", func() {
+			BeforeEach(func() {
+				mockVersions.EXPECT().HasGem(gomock.Any()).AnyTimes().Return(false, nil)
+			})
+
+			It("keeps node_modules and warns about its size", func() {
+				Expect(finalizer.PruneNodeModules()).To(Succeed())
+				Expect(filepath.Join(buildDir, "node_modules")).To(BeADirectory())
+				Expect(buffer.String()).To(ContainSubstring("node_modules adds 0 MB to the droplet"))
+			})
+		})
+	})
+
 	Describe("DeleteVendorBundle", func() {
 		Context("vendor/bundle in pushed app", func() {
 			BeforeEach(func() {
@@ -0,0 +1,54 @@
+package finalize
+
+import (
+	"fmt"
+	"path/filepath"
+	"ruby/filesystem"
+	"strings"
+)
+
+// healthcheckRackup wraps config.ru with the BP_RACK_HEALTHCHECK endpoint
+const healthcheckRackup = "cf_healthcheck.ru"
+
+// SetupRackHealthcheck writes a rackup file which answers the path in
+// BP_RACK_HEALTHCHECK with 200 and runs config.ru for everything else, and
+// points the default web process at it, so a bare Rack app can use an http
+// health check without changes. Rails apps route their own health checks.
+func (f *Finalizer) SetupRackHealthcheck(processTypes map[string]string) error {
+	path := f.Flags.String("BP_RACK_HEALTHCHECK")
+	if path == "" {
+		return nil
+	}
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, " \t\n?#") {
+		return fmt.Errorf("BP_RACK_HEALTHCHECK must be a path such as /healthz, not %q", path)
+	}
+	if f.RailsVersion > 0 {
+		f.Log.Warning("Ignoring BP_RACK_HEALTHCHECK, it is only for Rack apps which do not use Rails")
+		return nil
+	}
+	if exists, err := filesystem.Exists(f.fs(), filepath.Join(f.Stager.BuildDir(), "config.ru")); err != nil {
+		return err
+	} else if !exists {
+		f.Log.Warning("Ignoring BP_RACK_HEALTHCHECK, the app has no config.ru")
+		return nil
+	}
+
+	f.Log.BeginStep("Answering health checks on %s with %s", path, healthcheckRackup)
+	if err := f.fs().WriteFile(filepath.Join(f.Stager.BuildDir(), healthcheckRackup), []byte(fmt.Sprintf(healthcheck_ru, path)), 0644); err != nil {
+		return err
+	}
+	f.Log.Info("Push with health-check-type: http and health-check-http-endpoint: %s to use it", path)
+
+	if procfileWeb, err := f.webCommand(""); err != nil {
+		return err
+	} else if procfileWeb != "" {
+		if !strings.Contains(procfileWeb, healthcheckRackup) {
+			f.Log.Warning("The Procfile web process replaces the buildpack's, start it with %s instead of config.ru to answer %s", healthcheckRackup, path)
+		}
+		return nil
+	}
+	if web, found := processTypes["web"]; found {
+		processTypes["web"] = strings.Replace(web, "config.ru", healthcheckRackup, 1)
+	}
+	return nil
+}
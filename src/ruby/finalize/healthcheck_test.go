@@ -0,0 +1,81 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/featureflags"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SetupRackHealthcheck", func() {
+	var (
+		err          error
+		buildDir     string
+		finalizer    *finalize.Finalizer
+		buffer       *bytes.Buffer
+		processTypes map[string]string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "config.ru"), []byte("run App\n"), 0644)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", "", ""}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+			Flags:  featureflags.New([]string{"BP_RACK_HEALTHCHECK=/healthz"}),
+		}
+		processTypes = map[string]string{"web": "bundle exec rackup config.ru -p $PORT"}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("does nothing unless BP_RACK_HEALTHCHECK is set", func() {
+		finalizer.Flags = featureflags.New([]string{})
+		Expect(finalizer.SetupRackHealthcheck(processTypes)).To(Succeed())
+		Expect(filepath.Join(buildDir, "cf_healthcheck.ru")).ToNot(BeAnExistingFile())
+		Expect(processTypes["web"]).To(Equal("bundle exec rackup config.ru -p $PORT"))
+	})
+
+	It("wraps config.ru and starts the web process with the wrapper", func() {
+		Expect(finalizer.SetupRackHealthcheck(processTypes)).To(Succeed())
+
+		rackup, err := ioutil.ReadFile(filepath.Join(buildDir, "cf_healthcheck.ru"))
+		Expect(err).To(BeNil())
+		Expect(string(rackup)).To(ContainSubstring(`Rack::Builder.parse_file(File.expand_path('config.ru', __dir__))`))
+		Expect(string(rackup)).To(ContainSubstring(`if env['PATH_INFO'] == "/healthz"`))
+		Expect(processTypes["web"]).To(Equal("bundle exec rackup cf_healthcheck.ru -p $PORT"))
+		Expect(buffer.String()).To(ContainSubstring("health-check-http-endpoint: /healthz"))
+	})
+
+	It("only warns when the Procfile replaces the web process", func() {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("web: bundle exec puma config.ru\n"), 0644)).To(Succeed())
+		Expect(finalizer.SetupRackHealthcheck(processTypes)).To(Succeed())
+		Expect(filepath.Join(buildDir, "cf_healthcheck.ru")).To(BeAnExistingFile())
+		Expect(buffer.String()).To(ContainSubstring("start it with cf_healthcheck.ru instead of config.ru"))
+	})
+
+	It("leaves Rails apps alone", func() {
+		finalizer.RailsVersion = 5
+		Expect(finalizer.SetupRackHealthcheck(processTypes)).To(Succeed())
+		Expect(filepath.Join(buildDir, "cf_healthcheck.ru")).ToNot(BeAnExistingFile())
+		Expect(buffer.String()).To(ContainSubstring("Ignoring BP_RACK_HEALTHCHECK, it is only for Rack apps"))
+	})
+
+	It("fails for a value which is not a path", func() {
+		finalizer.Flags = featureflags.New([]string{"BP_RACK_HEALTHCHECK=healthz"})
+		Expect(finalizer.SetupRackHealthcheck(processTypes)).To(MatchError(`BP_RACK_HEALTHCHECK must be a path such as /healthz, not "healthz"`))
+	})
+})
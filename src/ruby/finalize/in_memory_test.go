@@ -0,0 +1,115 @@
+package finalize_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"ruby/config"
+	"ruby/featureflags"
+	"ruby/filesystem"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// The app and deps dir only exist in memory here, nothing below these paths
+// is touched on disk
+var _ = Describe("Finalizing against an in-memory filesystem", func() {
+	const (
+		buildDir = "/in-memory/app"
+		depDir   = "/in-memory/deps/9"
+	)
+	var (
+		fs        *filesystem.Mem
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+		mockCtrl  *gomock.Controller
+	)
+
+	write := func(path, contents string, mode os.FileMode) {
+		Expect(fs.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(fs.WriteFile(path, []byte(contents), mode)).To(Succeed())
+	}
+	read := func(path string) string {
+		data, err := fs.ReadFile(path)
+		Expect(err).To(BeNil())
+		return string(data)
+	}
+
+	BeforeEach(func() {
+		fs = filesystem.NewMem()
+		buffer = new(bytes.Buffer)
+		mockCtrl = gomock.NewController(GinkgoT())
+
+		mockStager := NewMockStager(mockCtrl)
+		mockStager.EXPECT().BuildDir().AnyTimes().Return(buildDir)
+		mockStager.EXPECT().DepDir().AnyTimes().Return(depDir)
+		mockStager.EXPECT().DepsIdx().AnyTimes().Return("9")
+		mockVersions := NewMockVersions(mockCtrl)
+		mockVersions.EXPECT().HasGem(gomock.Any()).AnyTimes().DoAndReturn(func(name string) (bool, error) {
+			return name == "sqlite3", nil
+		})
+
+		finalizer = &finalize.Finalizer{
+			Stager:       mockStager,
+			Versions:     mockVersions,
+			Command:      NewMockCommand(mockCtrl),
+			Log:          libbuildpack.NewLogger(ansicleaner.New(buffer)),
+			Flags:        featureflags.New([]string{"BP_REVIEW_APP=true"}),
+			Config:       &config.Config{},
+			FS:           fs,
+			RailsVersion: 5,
+		}
+
+		write(filepath.Join(buildDir, "Gemfile"), "source 'https://rubygems.org'\n", 0644)
+		write(filepath.Join(buildDir, "Procfile"), "web: bundle exec puma\nworker: bundle exec sidekiq\n", 0644)
+		write(filepath.Join(buildDir, "procfile.env.yml"), "worker:\n  MALLOC_ARENA_MAX: '2'\n", 0644)
+		write(filepath.Join(buildDir, "db", "schema.rb"), "ActiveRecord::Schema.define {}\n", 0644)
+		write(filepath.Join(buildDir, "config", "database.yml"), "production:\n  adapter: sqlite3\n  database: storage/production.sqlite3\n", 0644)
+		write(filepath.Join(depDir, "Gemfile.lock"), "GEM\n  specs:\n    sqlite3 (1.4.2)\n", 0644)
+		write(filepath.Join(depDir, "bundle_config"), "---\nBUNDLE_WITHOUT: development:test\n", 0644)
+		write(filepath.Join(depDir, "binstubs", "rake"), "#!/usr/bin/env ruby\n", 0755)
+		write(filepath.Join(depDir, "bin", "ruby"), "", 0755)
+
+		Expect(os.Setenv("VCAP_SERVICES", `{"nfs":[{"name":"storage","volume_mounts":[{"container_dir":"/var/vcap/data/storage","mode":"rw"}]}]}`)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.Unsetenv("VCAP_SERVICES")).To(Succeed())
+		mockCtrl.Finish()
+	})
+
+	It("runs the steps which only touch files", func() {
+		Expect(finalizer.RestoreGemfileLock()).To(Succeed())
+		Expect(finalizer.AssetGemfileLockExists()).To(Succeed())
+		Expect(finalizer.RestoreBundleConfig()).To(Succeed())
+		Expect(finalizer.CopyToAppBin()).To(Succeed())
+		Expect(finalizer.WriteProcessEnv(map[string]string{"web": "bundle exec rails server"})).To(Succeed())
+		Expect(finalizer.WriteConsoleEnv()).To(Succeed())
+		Expect(finalizer.SetupReviewApp(map[string]string{"web": "bundle exec rails server"})).To(Succeed())
+		Expect(finalizer.ConfigureSQLite()).To(Succeed())
+
+		Expect(read(filepath.Join(buildDir, "Gemfile.lock"))).To(ContainSubstring("sqlite3 (1.4.2)"))
+		Expect(read(filepath.Join(buildDir, ".bundle", "config"))).To(Equal("---\nBUNDLE_WITHOUT: development:test\n"))
+		Expect(read(filepath.Join(buildDir, "bin", "rake"))).To(Equal("#!/usr/bin/env ruby\n"))
+		Expect(read(filepath.Join(buildDir, "bin", "ruby"))).To(ContainSubstring(`/9/bin/ruby", *ARGV`))
+		Expect(read(filepath.Join(buildDir, "bin", "console-env"))).To(ContainSubstring("exec \"$@\""))
+		Expect(read(filepath.Join(buildDir, "lib", "tasks", "cf_review_app.rake"))).To(ContainSubstring("review_app"))
+		Expect(read(filepath.Join(depDir, "profile.d", "process_env.sh"))).To(ContainSubstring("MALLOC_ARENA_MAX"))
+		Expect(read(filepath.Join(depDir, "profile.d", "sqlite_volume.sh"))).To(ContainSubstring("sqlite3:/var/vcap/data/storage/production.sqlite3"))
+		Expect(buffer.String()).To(ContainSubstring("The Procfile web process replaces the buildpack's"))
+
+		Expect(filesystem.Exists(fs, filepath.Join(depDir, "Gemfile.lock"))).To(BeFalse())
+		Expect(buildDir).ToNot(BeAnExistingFile())
+	})
+
+	It("fails without a Gemfile.lock", func() {
+		Expect(fs.RemoveAll(depDir)).To(Succeed())
+		Expect(finalizer.RestoreGemfileLock()).To(Succeed())
+		Expect(finalizer.AssetGemfileLockExists()).To(MatchError("Gemfile.lock required"))
+	})
+})
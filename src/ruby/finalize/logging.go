@@ -0,0 +1,54 @@
+package finalize
+
+import (
+	"path/filepath"
+	"ruby/config"
+	"ruby/profiled"
+)
+
+// logTagsInitializer appends the tags in RAILS_LOG_TAGS to the app's own
+// log tags. Rails builds its logger middleware after the initializers run,
+// so setting them here still tags every request.
+const logTagsInitializer = `# Written by the ruby buildpack for logging.cf_defaults in config/ruby-buildpack.yml
+if ENV['RAILS_LOG_TAGS'].to_s != ''
+  tags = Array(Rails.application.config.log_tags)
+  ENV['RAILS_LOG_TAGS'].split(',').map(&:strip).reject(&:empty?).each do |tag|
+    tag = tag.to_sym if %w[request_id uuid remote_ip subdomain].include?(tag)
+    tags << tag unless tags.include?(tag)
+  end
+  Rails.application.config.log_tags = tags
+end
+`
+
+// WriteLoggingDefaults exports the logging environment apps on CF need, with
+// logging.cf_defaults set: Rails logs to stdout, and request log lines are
+// tagged with the request id and the index of the instance serving them, so
+// the logs of several instances can be told apart. Values set with cf
+// set-env win.
+func (f *Finalizer) WriteLoggingDefaults() error {
+	if !f.Config.Logging.CFDefaults {
+		return nil
+	}
+	f.Log.BeginStep("Writing logging defaults for logging.cf_defaults in %s", config.Path)
+
+	script := profiled.New().
+		AddEnvDefault("RAILS_LOG_TO_STDOUT", profiled.Literal("enabled")).
+		AddEnvDefault("RAILS_LOG_TAGS", profiled.Expand("request_id,instance:${CF_INSTANCE_INDEX:-0}"))
+	if err := script.WriteFS(f.fs(), f.Stager.DepDir(), "logging.sh"); err != nil {
+		return err
+	}
+
+	if f.RailsVersion < 3 {
+		return nil
+	}
+	initializer := filepath.Join(f.Stager.BuildDir(), "config", "initializers", "cf_log_tags.rb")
+	if err := f.fs().MkdirAll(filepath.Dir(initializer), 0755); err != nil {
+		return err
+	}
+	if written, err := f.writeIfMissing(initializer, logTagsInitializer, 0644); err != nil {
+		return err
+	} else if !written {
+		f.Log.Info("Keeping the app's config/initializers/cf_log_tags.rb")
+	}
+	return nil
+}
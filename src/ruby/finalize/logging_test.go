@@ -0,0 +1,78 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/config"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteLoggingDefaults", func() {
+	var (
+		err       error
+		buildDir  string
+		depsDir   string
+		finalizer *finalize.Finalizer
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+
+		logger := libbuildpack.NewLogger(ansicleaner.New(new(bytes.Buffer)))
+		finalizer = &finalize.Finalizer{
+			Stager:       libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:          logger,
+			Config:       &config.Config{Logging: config.Logging{CFDefaults: true}},
+			RailsVersion: 5,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	source := func(env ...string) string {
+		cmd := exec.Command("bash", "-c", `source "$1" && echo "$RAILS_LOG_TO_STDOUT $RAILS_LOG_TAGS"`, "bash", filepath.Join(depsDir, "0", "profile.d", "logging.sh"))
+		cmd.Env = append([]string{"PATH=" + os.Getenv("PATH")}, env...)
+		output, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(output))
+		return string(output)
+	}
+
+	It("tags log lines with the request id and the instance index", func() {
+		Expect(finalizer.WriteLoggingDefaults()).To(Succeed())
+		Expect(source("CF_INSTANCE_INDEX=2")).To(Equal("enabled request_id,instance:2\n"))
+		Expect(ioutil.ReadFile(filepath.Join(buildDir, "config", "initializers", "cf_log_tags.rb"))).To(ContainSubstring("ENV['RAILS_LOG_TAGS']"))
+	})
+
+	It("keeps values set with cf set-env", func() {
+		Expect(finalizer.WriteLoggingDefaults()).To(Succeed())
+		Expect(source("RAILS_LOG_TO_STDOUT=disabled", "RAILS_LOG_TAGS=uuid")).To(Equal("disabled uuid\n"))
+	})
+
+	It("keeps the app's initializer", func() {
+		Expect(os.MkdirAll(filepath.Join(buildDir, "config", "initializers"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "config", "initializers", "cf_log_tags.rb"), []byte("# mine\n"), 0644)).To(Succeed())
+		Expect(finalizer.WriteLoggingDefaults()).To(Succeed())
+		Expect(ioutil.ReadFile(filepath.Join(buildDir, "config", "initializers", "cf_log_tags.rb"))).To(Equal([]byte("# mine\n")))
+	})
+
+	It("does nothing when logging.cf_defaults is not set", func() {
+		finalizer.Config = &config.Config{}
+		Expect(finalizer.WriteLoggingDefaults()).To(Succeed())
+		Expect(filepath.Join(depsDir, "0", "profile.d", "logging.sh")).ToNot(BeAnExistingFile())
+		Expect(filepath.Join(buildDir, "config", "initializers")).ToNot(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,89 @@
+package finalize
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"ruby/extensions"
+	"ruby/metrics"
+	"ruby/report"
+	"ruby/stages"
+	"time"
+
+	"github.com/kr/text"
+)
+
+// beginStage begins the stage name in the log, see stages. Its duration goes
+// to the staging metrics when it ends.
+func (f *Finalizer) beginStage(name string) *stages.Stage {
+	return stages.Begin(f.Log, f.Stager.DepDir(), name)
+}
+
+// stage runs fn as the stage name
+func (f *Finalizer) stage(name string, fn func() error) error {
+	return stages.Run(f.Log, f.Stager.DepDir(), name, fn)
+}
+
+// runExtensions runs the extensions compiled in for point
+func (f *Finalizer) runExtensions(point extensions.Point) error {
+	return extensions.Run(extensions.Context{
+		Point:    point,
+		BuildDir: f.Stager.BuildDir(),
+		DepDir:   f.Stager.DepDir(),
+		DepsIdx:  f.Stager.DepsIdx(),
+		Flags:    f.Flags,
+		Log:      f.Log,
+	})
+}
+
+// recordComponent adds how long installing a component took to the staging
+// metrics
+func (f *Finalizer) recordComponent(name string, start time.Time, warm bool) {
+	if err := report.RecordComponent(f.Stager.DepDir(), name, start, warm); err != nil {
+		f.Log.Debug("Unable to record the install time of %s: %v", name, err)
+	}
+}
+
+// WriteMetrics prints the staging time by component and the resources each
+// phase used, then renders the staging metrics together with the sizes of
+// the app and of the dependencies this buildpack installed into metrics.File
+// in the app. Durations differ between every staging, with BP_REPRODUCIBLE
+// they are left out of the droplet altogether.
+func (f *Finalizer) WriteMetrics() error {
+	if r, err := report.Load(f.Stager.DepDir()); err == nil && r.Metrics != nil {
+		if len(r.Metrics.Components) > 0 {
+			f.Log.BeginStep("Staging time by component")
+			metrics.PrintBreakdown(text.NewIndentWriter(f.Log.Output(), []byte("       ")), r)
+		}
+		if len(r.Metrics.Resources) > 0 {
+			f.Log.BeginStep("Staging resources by phase")
+			metrics.PrintResources(text.NewIndentWriter(f.Log.Output(), []byte("       ")), r)
+		}
+	}
+
+	if f.Flags.Bool("BP_REPRODUCIBLE") {
+		return report.Update(f.Stager.DepDir(), func(r *report.Report) {
+			r.Metrics = nil
+		})
+	}
+
+	r, err := report.Load(f.Stager.DepDir())
+	if err != nil {
+		return err
+	}
+	// The droplet contents are only recorded once nothing changes the
+	// droplet anymore, the gems are counted without their digests
+	gems, err := f.bundledGems()
+	if err != nil {
+		return err
+	}
+	r.Contents = &report.Contents{Gems: gems}
+
+	sizes := map[string]int64{}
+	for name, dir := range map[string]string{"app": f.Stager.BuildDir(), "deps": f.Stager.DepDir()} {
+		if sizes[name], err = metrics.Size(dir); err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(filepath.Join(f.Stager.BuildDir(), metrics.File), []byte(metrics.Render(r, sizes)), 0644)
+}
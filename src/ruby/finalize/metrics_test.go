@@ -0,0 +1,91 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/featureflags"
+	"ruby/finalize"
+	"ruby/metrics"
+	"ruby/report"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteMetrics", func() {
+	var (
+		err       error
+		buildDir  string
+		depsDir   string
+		depDir    string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+		depDir = filepath.Join(depsDir, "9")
+		Expect(os.MkdirAll(depDir, 0755)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "9"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+			Flags:  featureflags.New(nil),
+		}
+
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "config.ru"), []byte("run App"), 0644)).To(Succeed())
+		Expect(report.RecordDuration(depDir, "supply", time.Now())).To(Succeed())
+		Expect(report.RecordCacheHit(depDir, "vendor_bundle", true)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("writes the metrics into the app", func() {
+		Expect(finalizer.WriteMetrics()).To(Succeed())
+
+		data, err := ioutil.ReadFile(filepath.Join(buildDir, metrics.File))
+		Expect(err).To(BeNil())
+		Expect(string(data)).To(ContainSubstring(`ruby_buildpack_staging_duration_seconds{phase="supply"} `))
+		Expect(string(data)).To(ContainSubstring(`ruby_buildpack_cache_hit{dir="vendor_bundle"} 1`))
+		Expect(string(data)).To(ContainSubstring(`ruby_buildpack_droplet_size_bytes{dir="app"} 7`))
+		Expect(string(data)).To(ContainSubstring(`ruby_buildpack_droplet_size_bytes{dir="deps"} `))
+	})
+
+	It("logs the staging time of each component", func() {
+		Expect(report.RecordComponent(depDir, "gems", time.Now(), true)).To(Succeed())
+		Expect(report.RecordComponent(depDir, "assets", time.Now(), false)).To(Succeed())
+		Expect(finalizer.WriteMetrics()).To(Succeed())
+
+		Expect(buffer.String()).To(ContainSubstring("-----> Staging time by component"))
+		Expect(buffer.String()).To(MatchRegexp(`assets +0\.0s +cold`))
+		Expect(buffer.String()).To(ContainSubstring("1 warm in 0.0s, 1 cold in 0.0s"))
+	})
+
+	Context("BP_REPRODUCIBLE is set", func() {
+		BeforeEach(func() {
+			finalizer.Flags = featureflags.New([]string{"BP_REPRODUCIBLE=true"})
+		})
+
+		It("leaves the metrics out of the droplet", func() {
+			Expect(finalizer.WriteMetrics()).To(Succeed())
+			Expect(filepath.Join(buildDir, metrics.File)).ToNot(BeAnExistingFile())
+
+			r, err := report.Load(depDir)
+			Expect(err).To(BeNil())
+			Expect(r.Metrics).To(BeNil())
+		})
+	})
+})
@@ -45,6 +45,18 @@ func (mr *MockStagerMockRecorder) BuildDir() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BuildDir", reflect.TypeOf((*MockStager)(nil).BuildDir))
 }
 
+// CacheDir mocks base method
+func (m *MockStager) CacheDir() string {
+	ret := m.ctrl.Call(m, "CacheDir")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// CacheDir indicates an expected call of CacheDir
+func (mr *MockStagerMockRecorder) CacheDir() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CacheDir", reflect.TypeOf((*MockStager)(nil).CacheDir))
+}
+
 // DepsIdx mocks base method
 func (m *MockStager) DepsIdx() string {
 	ret := m.ctrl.Call(m, "DepsIdx")
@@ -103,3 +115,50 @@ func (m *MockCommand) Run(arg0 *exec.Cmd) error {
 func (mr *MockCommandMockRecorder) Run(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockCommand)(nil).Run), arg0)
 }
+
+// MockCache is a mock of Cache interface
+type MockCache struct {
+	ctrl     *gomock.Controller
+	recorder *MockCacheMockRecorder
+}
+
+// MockCacheMockRecorder is the mock recorder for MockCache
+type MockCacheMockRecorder struct {
+	mock *MockCache
+}
+
+// NewMockCache creates a new mock instance
+func NewMockCache(ctrl *gomock.Controller) *MockCache {
+	mock := &MockCache{ctrl: ctrl}
+	mock.recorder = &MockCacheMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockCache) EXPECT() *MockCacheMockRecorder {
+	return m.recorder
+}
+
+// RestoreAssets mocks base method
+func (m *MockCache) RestoreAssets() error {
+	ret := m.ctrl.Call(m, "RestoreAssets")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestoreAssets indicates an expected call of RestoreAssets
+func (mr *MockCacheMockRecorder) RestoreAssets() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreAssets", reflect.TypeOf((*MockCache)(nil).RestoreAssets))
+}
+
+// SaveAssets mocks base method
+func (m *MockCache) SaveAssets() error {
+	ret := m.ctrl.Call(m, "SaveAssets")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveAssets indicates an expected call of SaveAssets
+func (mr *MockCacheMockRecorder) SaveAssets() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveAssets", reflect.TypeOf((*MockCache)(nil).SaveAssets))
+}
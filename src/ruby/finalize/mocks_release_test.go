@@ -75,3 +75,16 @@ func (mr *MockVersionsMockRecorder) HasGemVersion(arg0 interface{}, arg1 ...inte
 	varargs := append([]interface{}{arg0}, arg1...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasGemVersion", reflect.TypeOf((*MockVersions)(nil).HasGemVersion), varargs...)
 }
+
+// GemGroups mocks base method
+func (m *MockVersions) GemGroups() (map[string][]string, error) {
+	ret := m.ctrl.Call(m, "GemGroups")
+	ret0, _ := ret[0].(map[string][]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GemGroups indicates an expected call of GemGroups
+func (mr *MockVersionsMockRecorder) GemGroups() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GemGroups", reflect.TypeOf((*MockVersions)(nil).GemGroups))
+}
@@ -0,0 +1,202 @@
+package finalize
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"ruby/report"
+	"strings"
+)
+
+// unsafeModes are removed from every file and directory in the droplet.
+// Gems are often packaged with world writable files, which security
+// scanners flag, and nothing in a droplet needs setuid or setgid. The
+// executable bits are left alone, so bin/ keeps working.
+const unsafeModes = os.FileMode(0002) | os.ModeSetuid | os.ModeSetgid
+
+var (
+	privateKeyPEM = regexp.MustCompile(`-----BEGIN (?:RSA |DSA |EC |OPENSSH |ENCRYPTED )?PRIVATE KEY-----`)
+	// keyFiles are checked for a PEM private key, keyStores always hold one
+	keyFiles  = regexp.MustCompile(`(?:\.pem|\.key|^id_(?:rsa|dsa|ecdsa|ed25519))$`)
+	keyStores = regexp.MustCompile(`\.(?:p12|pfx|jks)$`)
+	// railsKeys decrypt config/credentials.yml.enc, the app gets the key
+	// from RAILS_MASTER_KEY at runtime instead
+	railsKeys = regexp.MustCompile(`^config/(?:master\.key|credentials/[^/]+\.key)$`)
+)
+
+// keylessDirs hold the files of gems and node packages, whose test
+// fixtures often include keys, rather than the app's own files
+var keylessDirs = []string{"node_modules", "vendor/bundle", "vendor/cache", ".git"}
+
+// NormalizePermissions removes world write, setuid and setgid permissions
+// from the droplet and fails staging when the app contains private keys,
+// unless BP_ALLOW_PRIVATE_KEYS turns that into a warning. The changes are
+// recorded in the staging report. It runs after everything else has
+// written to the build and dep dirs.
+func (f *Finalizer) NormalizePermissions() error {
+	permissions := &report.Permissions{}
+	depDir := filepath.Join("deps", f.Stager.DepsIdx())
+	for _, dir := range []struct{ path, name string }{{f.Stager.BuildDir(), "app"}, {f.Stager.DepDir(), depDir}} {
+		if err := normalizeModes(dir.path, dir.name, permissions); err != nil {
+			return err
+		}
+	}
+	if err := report.Update(f.Stager.DepDir(), func(r *report.Report) { r.Permissions = permissions }); err != nil {
+		return err
+	}
+	if permissions.Changed > 0 {
+		f.Log.BeginStep("Removed world write, setuid and setgid permissions from %d files, the staging report lists them", permissions.Changed)
+	}
+
+	keys, err := privateKeys(f.Stager.BuildDir())
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	allow := f.Flags.Bool("BP_ALLOW_PRIVATE_KEYS")
+	for _, key := range keys {
+		if allow {
+			f.Log.Warning("The app contains the private key %s", key)
+		} else {
+			f.Log.Error("The app contains the private key %s", key)
+		}
+	}
+	if allow {
+		return nil
+	}
+	f.Log.Info("Leave keys out of the droplet with .cfignore and provide them through a service binding or an environment variable, or set BP_ALLOW_PRIVATE_KEYS=true to stage anyway")
+	return fmt.Errorf("the app contains private keys")
+}
+
+func normalizeModes(dir, name string, permissions *report.Permissions) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		mode := info.Mode()
+		if mode&os.ModeSymlink != 0 || mode&unsafeModes == 0 {
+			return nil
+		}
+
+		fixed := mode &^ unsafeModes
+		if mode.IsRegular() {
+			if err := chmodCopy(path, fixed); err != nil {
+				return err
+			}
+		} else if err := os.Chmod(path, fixed); err != nil {
+			return err
+		}
+		permissions.Changed++
+		if len(permissions.Files) < report.MaxPermissionChanges {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			permissions.Files = append(permissions.Files, fmt.Sprintf("%s %04o -> %04o", filepath.Join(name, rel), unixMode(mode), unixMode(fixed)))
+		}
+		return nil
+	})
+}
+
+// chmodCopy replaces the file at path with a copy of it with mode. Supply
+// saves the dep dir to the cache as hard links, and changing the mode of the
+// file itself would change the cached file as well, whose integrity stamp
+// covers its mode. Directories are never linked and are changed in place.
+func chmodCopy(path string, mode os.FileMode) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(dst.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(dst.Name(), path)
+}
+
+func unixMode(mode os.FileMode) os.FileMode {
+	unix := mode.Perm()
+	if mode&os.ModeSetuid != 0 {
+		unix |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		unix |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		unix |= 01000
+	}
+	return unix
+}
+
+// privateKeys returns the private keys among the app's own files
+func privateKeys(buildDir string) ([]string, error) {
+	var keys []string
+	err := filepath.Walk(buildDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(buildDir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			for _, dir := range keylessDirs {
+				if rel == dir || strings.HasSuffix(rel, "/"+dir) {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		switch {
+		case railsKeys.MatchString(rel), keyStores.MatchString(info.Name()):
+			keys = append(keys, rel)
+		case keyFiles.MatchString(info.Name()):
+			if found, err := containsPrivateKey(path); err != nil {
+				return err
+			} else if found {
+				keys = append(keys, rel)
+			}
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func containsPrivateKey(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	data := make([]byte, 64*1024)
+	n, err := io.ReadFull(file, data)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return privateKeyPEM.Match(data[:n]), nil
+}
@@ -0,0 +1,111 @@
+package finalize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"ruby/profiled"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const processEnvFile = "procfile.env.yml"
+
+var (
+	processTypeName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	envVarName      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// WriteProcessEnv renders procfile.env.yml, a map of process type to
+// environment variables, into a profile.d script which only exports the
+// variables for the process type the instance was started as.
+func (f *Finalizer) WriteProcessEnv(processTypes map[string]string) error {
+	data, err := f.fs().ReadFile(filepath.Join(f.Stager.BuildDir(), processEnvFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var env map[string]map[string]string
+	if err := yaml.UnmarshalStrict(data, &env); err != nil {
+		return fmt.Errorf("could not parse %s: %v", processEnvFile, err)
+	}
+
+	known, err := f.procfileProcessTypes()
+	if err != nil {
+		return err
+	}
+	for name := range processTypes {
+		known[name] = true
+	}
+
+	var names []string
+	for name, vars := range env {
+		if !processTypeName.MatchString(name) {
+			return fmt.Errorf("%s: %q is not a valid process type", processEnvFile, name)
+		}
+		for key := range vars {
+			if !envVarName.MatchString(key) {
+				return fmt.Errorf("%s: %q is not a valid environment variable name for the %s process", processEnvFile, key, name)
+			}
+		}
+		if !known[name] {
+			f.Log.Warning("%s sets environment for the %s process, which is not in the Procfile", processEnvFile, name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	f.Log.BeginStep("Writing environment for processes: %s", strings.Join(names, ", "))
+
+	script := profiled.New()
+	for _, name := range names {
+		var keys []string
+		for key := range env[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		block := profiled.New()
+		for _, key := range keys {
+			block.AddEnv(key, profiled.Literal(env[name][key]))
+		}
+		script.AddScriptBlock(block, profiled.IfProcessType(name))
+	}
+	return script.WriteFS(f.fs(), f.Stager.DepDir(), "process_env.sh")
+}
+
+func (f *Finalizer) procfileProcessTypes() (map[string]bool, error) {
+	commands, err := f.procfileCommands()
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for name := range commands {
+		names[name] = true
+	}
+	return names, nil
+}
+
+// procfileCommands maps the process types in the Procfile to their commands
+func (f *Finalizer) procfileCommands() (map[string]string, error) {
+	data, err := f.fs().ReadFile(filepath.Join(f.Stager.BuildDir(), "Procfile"))
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	commands := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 {
+			commands[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return commands, nil
+}
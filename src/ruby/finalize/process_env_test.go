@@ -0,0 +1,146 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteProcessEnv", func() {
+	var (
+		err          error
+		buildDir     string
+		depsDir      string
+		finalizer    *finalize.Finalizer
+		buffer       *bytes.Buffer
+		processTypes map[string]string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+
+		args := []string{buildDir, "", depsDir, "0"}
+		stager := libbuildpack.NewStager(args, logger, &libbuildpack.Manifest{})
+
+		finalizer = &finalize.Finalizer{
+			Stager: stager,
+			Log:    logger,
+		}
+		processTypes = map[string]string{"web": "bin/rails server"}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	script := func() string {
+		return filepath.Join(depsDir, "0", "profile.d", "process_env.sh")
+	}
+
+	envFor := func(vcapApplication string) string {
+		cmd := exec.Command("bash", "-c", "source "+script()+" && env")
+		cmd.Env = []string{"VCAP_APPLICATION=" + vcapApplication}
+		output, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(output))
+		return string(output)
+	}
+
+	Context("there is no procfile.env.yml", func() {
+		It("does not write a script", func() {
+			Expect(finalizer.WriteProcessEnv(processTypes)).To(Succeed())
+			Expect(script()).ToNot(BeAnExistingFile())
+		})
+	})
+
+	Context("procfile.env.yml sets environment for web and worker", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("worker: bundle exec sidekiq\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "procfile.env.yml"), []byte(`---
+web:
+  RAILS_MAX_THREADS: 5
+  RUBY_GC_HEAP_GROWTH_FACTOR: "1.1"
+worker:
+  RAILS_MAX_THREADS: 25
+  GREETING: "it's a worker"
+`), 0644)).To(Succeed())
+		})
+
+		It("exports the web variables for the web process", func() {
+			Expect(finalizer.WriteProcessEnv(processTypes)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Writing environment for processes: web, worker"))
+
+			env := envFor(`{"application_name":"app","process_type": "web","space_name":"dev"}`)
+			Expect(env).To(ContainSubstring("RAILS_MAX_THREADS=5\n"))
+			Expect(env).To(ContainSubstring("RUBY_GC_HEAP_GROWTH_FACTOR=1.1\n"))
+			Expect(env).ToNot(ContainSubstring("GREETING"))
+			Expect(env).ToNot(ContainSubstring("__cf_process_type"))
+		})
+
+		It("exports the worker variables for the worker process", func() {
+			Expect(finalizer.WriteProcessEnv(processTypes)).To(Succeed())
+
+			env := envFor(`{"process_type":"worker"}`)
+			Expect(env).To(ContainSubstring("RAILS_MAX_THREADS=25\n"))
+			Expect(env).To(ContainSubstring("GREETING=it's a worker\n"))
+			Expect(env).ToNot(ContainSubstring("RUBY_GC_HEAP_GROWTH_FACTOR"))
+		})
+
+		It("exports nothing when the process type is unknown", func() {
+			Expect(finalizer.WriteProcessEnv(processTypes)).To(Succeed())
+
+			env := envFor(`{"application_name":"app"}`)
+			Expect(env).ToNot(ContainSubstring("RAILS_MAX_THREADS"))
+		})
+
+		It("does not warn about known process types", func() {
+			Expect(finalizer.WriteProcessEnv(processTypes)).To(Succeed())
+			Expect(buffer.String()).ToNot(ContainSubstring("WARNING"))
+		})
+	})
+
+	Context("procfile.env.yml names a process which does not exist", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "procfile.env.yml"), []byte("clock:\n  TZ: UTC\n"), 0644)).To(Succeed())
+		})
+
+		It("warns", func() {
+			Expect(finalizer.WriteProcessEnv(processTypes)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("procfile.env.yml sets environment for the clock process, which is not in the Procfile"))
+		})
+	})
+
+	Context("procfile.env.yml has an invalid variable name", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "procfile.env.yml"), []byte("web:\n  \"BAD NAME\": x\n"), 0644)).To(Succeed())
+		})
+
+		It("returns an error", func() {
+			Expect(finalizer.WriteProcessEnv(processTypes)).To(MatchError(`procfile.env.yml: "BAD NAME" is not a valid environment variable name for the web process`))
+		})
+	})
+
+	Context("procfile.env.yml is not a map of maps", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "procfile.env.yml"), []byte("web: 5\n"), 0644)).To(Succeed())
+		})
+
+		It("returns an error", func() {
+			Expect(finalizer.WriteProcessEnv(processTypes)).To(MatchError(ContainSubstring("could not parse procfile.env.yml")))
+		})
+	})
+})
@@ -0,0 +1,110 @@
+package finalize
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// guardedProfileDir is where the app's .profile and .profile.d scripts are
+// moved to, relative to the app. Their place is taken by wrappers which run
+// them through profileGuardScript.
+var guardedProfileDir = filepath.Join(".ruby-buildpack", "profile")
+
+// maxProfileScriptSize is the largest .profile script staging accepts, a
+// bigger one is a committed binary or log rather than a script
+const maxProfileScriptSize = 1024 * 1024
+
+// profileWrapperMarker starts every wrapper, so scripts are not wrapped twice
+const profileWrapperMarker = "# Written by the ruby buildpack, runs the app's "
+
+// profileGuardScript runs an app script of .profile.d, or .profile, in a
+// subshell under a timeout. Its output is prefixed with its name, and the
+// variables it exports are brought back into the launcher's shell, even when
+// its last command failed, as sourcing it would. A script which hangs is
+// reported and skipped rather than keeping the instance from ever starting.
+// Only exported variables make it out of the subshell: a cd, ulimit or
+// umask, functions, aliases, unset variables and shell options the script
+// sets are lost, such scripts need BP_GUARD_PROFILE_SCRIPTS left off.
+const profileGuardScript = `# Written by the ruby buildpack, sourced by the wrappers of the app's
+# .profile and .profile.d scripts with the name of the script and the timeout
+__cf_profile_name="$1"
+__cf_profile_timeout="$2"
+__cf_profile_env="$(mktemp)"
+timeout "$__cf_profile_timeout" bash -c 'source "$1"; status=$?; export -p > "$2"; exit $status' _ "$HOME/.ruby-buildpack/profile/$__cf_profile_name" "$__cf_profile_env" 2>&1 | sed "s|^|[$__cf_profile_name] |"
+__cf_profile_status="${PIPESTATUS[0]}"
+if [ "$__cf_profile_status" = 124 ]; then
+  echo "[$__cf_profile_name] did not finish within $__cf_profile_timeout, skipped it and the variables it exports. Set BP_PROFILE_TIMEOUT to give it longer." >&2
+else
+  if [ "$__cf_profile_status" != 0 ]; then
+    echo "[$__cf_profile_name] exited with status $__cf_profile_status" >&2
+  fi
+  source <(grep -vE '^declare -x (OLDPWD|PWD|SHLVL|_)(=|$)' "$__cf_profile_env") 2>/dev/null
+fi
+rm -f "$__cf_profile_env"
+unset __cf_profile_name __cf_profile_timeout __cf_profile_env __cf_profile_status
+`
+
+// GuardProfileScripts makes the launcher run the app's .profile and
+// .profile.d scripts with the timeout BP_PROFILE_TIMEOUT, their output
+// prefixed with their names. Each script is moved to guardedProfileDir and
+// replaced by a wrapper of the same name, so the launcher keeps running
+// them in the same order. Staging fails for scripts over
+// maxProfileScriptSize. Scripts are only guarded with
+// BP_GUARD_PROFILE_SCRIPTS, see profileGuardScript for what a subshell loses.
+func (f *Finalizer) GuardProfileScripts() error {
+	if !f.Flags.Bool("BP_GUARD_PROFILE_SCRIPTS") {
+		return nil
+	}
+	timeout := f.Flags.Duration("BP_PROFILE_TIMEOUT")
+
+	scripts, err := f.fs().Glob(filepath.Join(f.Stager.BuildDir(), ".profile.d", "*.sh"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(scripts)
+	scripts = append(scripts, filepath.Join(f.Stager.BuildDir(), ".profile"))
+
+	var names []string
+	for _, script := range scripts {
+		info, err := f.fs().Stat(script)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if info.Size() > maxProfileScriptSize {
+			return fmt.Errorf("%s is %d bytes, scripts the launcher sources can be at most %d", filepath.Base(script), info.Size(), maxProfileScriptSize)
+		}
+		name, err := filepath.Rel(f.Stager.BuildDir(), script)
+		if err != nil {
+			return err
+		}
+		if contents, err := f.fs().ReadFile(script); err != nil {
+			return err
+		} else if strings.HasPrefix(string(contents), profileWrapperMarker) {
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	f.Log.BeginStep("Running %s with a timeout of %s at startup", strings.Join(names, ", "), timeout)
+	guarded := filepath.Join(f.Stager.BuildDir(), guardedProfileDir)
+	for _, name := range names {
+		original := filepath.Join(guarded, name)
+		if err := f.fs().MkdirAll(filepath.Dir(original), 0755); err != nil {
+			return err
+		}
+		if err := f.fs().Rename(filepath.Join(f.Stager.BuildDir(), name), original); err != nil {
+			return err
+		}
+		quoted := strings.Replace(name, "'", `'\''`, -1)
+		wrapper := fmt.Sprintf("%s%s with a timeout, see %s\nsource \"$HOME/%s/guard.sh\" '%s' '%ds'\n", profileWrapperMarker, name, filepath.Join(guardedProfileDir, name), guardedProfileDir, quoted, int(timeout.Seconds()))
+		if err := f.fs().WriteFile(filepath.Join(f.Stager.BuildDir(), name), []byte(wrapper), 0644); err != nil {
+			return err
+		}
+	}
+	return f.fs().WriteFile(filepath.Join(guarded, "guard.sh"), []byte(profileGuardScript), 0644)
+}
@@ -0,0 +1,102 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/featureflags"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GuardProfileScripts", func() {
+	var (
+		err       error
+		buildDir  string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(buildDir, ".profile.d"), 0755)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+			Flags:  featureflags.New([]string{"BP_GUARD_PROFILE_SCRIPTS=true", "BP_PROFILE_TIMEOUT=1"}),
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	write := func(name, contents string) {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, name), []byte(contents), 0644)).To(Succeed())
+	}
+
+	// launch sources the scripts the way the launcher does
+	launch := func(command string) string {
+		cmd := exec.Command("bash", "-c", `for s in "$HOME"/.profile.d/*.sh; do [ -f "$s" ] && source "$s"; done; [ -f "$HOME/.profile" ] && source "$HOME/.profile"; `+command)
+		cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + buildDir}
+		output, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(output))
+		return string(output)
+	}
+
+	It("runs the scripts in order with their output prefixed and keeps what they export", func() {
+		write(".profile.d/a.sh", "echo configuring\nexport FIRST=1\n")
+		write(".profile.d/b.sh", "export SECOND=\"$FIRST and 2\"\n")
+		write(".profile", "export FROM_PROFILE=yes\n")
+
+		Expect(finalizer.GuardProfileScripts()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Running .profile.d/a.sh, .profile.d/b.sh, .profile with a timeout of 1s at startup"))
+		Expect(filepath.Join(buildDir, ".ruby-buildpack", "profile", ".profile.d", "a.sh")).To(BeARegularFile())
+
+		output := launch(`echo "$SECOND $FROM_PROFILE"`)
+		Expect(output).To(Equal("[.profile.d/a.sh] configuring\n1 and 2 yes\n"))
+	})
+
+	It("skips a script which hangs and reports one which fails", func() {
+		write(".profile.d/hang.sh", "export HUNG=yes\nsleep 5\n")
+		write(".profile.d/fail.sh", "export FAILED=yes\nfalse\n")
+
+		Expect(finalizer.GuardProfileScripts()).To(Succeed())
+
+		output := launch(`echo "hung=$HUNG failed=$FAILED"`)
+		Expect(output).To(ContainSubstring("[.profile.d/hang.sh] did not finish within 1s"))
+		Expect(output).To(ContainSubstring("[.profile.d/fail.sh] exited with status 1"))
+		Expect(output).To(ContainSubstring("hung= failed=yes\n"))
+	})
+
+	It("does not wrap scripts twice", func() {
+		write(".profile", "export FROM_PROFILE=yes\n")
+		Expect(finalizer.GuardProfileScripts()).To(Succeed())
+		buffer.Reset()
+		Expect(finalizer.GuardProfileScripts()).To(Succeed())
+		Expect(buffer.String()).To(BeEmpty())
+		Expect(launch(`echo "$FROM_PROFILE"`)).To(Equal("yes\n"))
+	})
+
+	It("fails staging for a script over the size limit", func() {
+		write(".profile.d/huge.sh", string(make([]byte, 1024*1024+1)))
+		Expect(finalizer.GuardProfileScripts()).To(MatchError(ContainSubstring("huge.sh is 1048577 bytes")))
+	})
+
+	It("leaves the scripts alone by default", func() {
+		finalizer.Flags = featureflags.New(nil)
+		write(".profile", "export FROM_PROFILE=yes\n")
+		Expect(finalizer.GuardProfileScripts()).To(Succeed())
+		Expect(ioutil.ReadFile(filepath.Join(buildDir, ".profile"))).To(Equal([]byte("export FROM_PROFILE=yes\n")))
+	})
+})
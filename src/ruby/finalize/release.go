@@ -1,6 +1,9 @@
 package finalize
 
 import (
+	"path/filepath"
+	"ruby/filesystem"
+
 	"github.com/blang/semver"
 )
 
@@ -8,6 +11,7 @@ type Versions interface {
 	HasGem(string) (bool, error)
 	GemMajorVersion(string) (int, error)
 	HasGemVersion(string, ...string) (bool, error)
+	GemGroups() (map[string][]string, error)
 }
 
 func (f *Finalizer) GenerateReleaseYaml() (map[string]map[string]string, error) {
@@ -31,6 +35,7 @@ func (f *Finalizer) GenerateReleaseYaml() (map[string]map[string]string, error)
 	if err != nil {
 		return nil, err
 	}
+	legacy := ""
 	processTypes := map[string]string{
 		"rake":    "bundle exec rake",
 		"console": "bundle exec irb",
@@ -40,6 +45,7 @@ func (f *Finalizer) GenerateReleaseYaml() (map[string]map[string]string, error)
 		processTypes["console"] = "bin/rails console"
 		processTypes["web"] = "bin/rails server -b 0.0.0.0 -p $PORT -e $RAILS_ENV"
 	} else if hasRails3 {
+		legacy = "rails3-start-command"
 		processTypes["worker"] = "bundle exec rake jobs:work"
 		processTypes["console"] = "bundle exec rails console"
 		processTypes["web"] = "bundle exec rails server -p $PORT"
@@ -47,6 +53,7 @@ func (f *Finalizer) GenerateReleaseYaml() (map[string]map[string]string, error)
 			processTypes["web"] = "bundle exec thin start -R config.ru -e $RAILS_ENV -p $PORT"
 		}
 	} else if hasRails2 {
+		legacy = "rails2-start-command"
 		processTypes["worker"] = "bundle exec rake jobs:work"
 		processTypes["console"] = "bundle exec script/console"
 		processTypes["web"] = "bundle exec ruby script/server -p $PORT"
@@ -59,11 +66,30 @@ func (f *Finalizer) GenerateReleaseYaml() (map[string]map[string]string, error)
 			processTypes["web"] = "bundle exec thin start -R config.ru -e $RACK_ENV -p $PORT"
 		}
 	}
+	if legacy != "" {
+		if err := f.useLegacyStartCommand(legacy); err != nil {
+			return nil, err
+		}
+	}
 	return map[string]map[string]string{
 		"default_process_types": processTypes,
 	}, nil
 }
 
+// useLegacyStartCommand warns about the deprecated default web command of
+// the app, unless its Procfile replaces it
+func (f *Finalizer) useLegacyStartCommand(id string) error {
+	if f.Deprecations == nil {
+		return nil
+	}
+	if exists, err := filesystem.Exists(f.fs(), filepath.Join(f.Stager.BuildDir(), "Procfile")); err != nil {
+		return err
+	} else if exists {
+		return nil
+	}
+	return f.Deprecations.Use(id)
+}
+
 func mustParse(s string) semver.Version {
 	semver, err := semver.ParseTolerant(s)
 	if err != nil {
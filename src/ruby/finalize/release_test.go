@@ -5,7 +5,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"ruby/deprecations"
 	"ruby/finalize"
+	"ruby/report"
 
 	"github.com/cloudfoundry/libbuildpack"
 	"github.com/cloudfoundry/libbuildpack/ansicleaner"
@@ -176,6 +178,31 @@ var _ = Describe("Finalize", func() {
 				})
 			})
 		})
+		Context("Rails 2.x without a Procfile, with deprecations tracked", func() {
+			BeforeEach(func() {
+				railsVersion = 2
+				finalizer.Deprecations = deprecations.New(logger, filepath.Join(depsDir, depsIdx))
+				mockStager.EXPECT().BuildDir().AnyTimes().Return(buildDir)
+			})
+
+			It("warns that the default web command is deprecated", func() {
+				_, err := finalizer.GenerateReleaseYaml()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(buffer.String()).To(ContainSubstring("Deprecated [rails2-start-command]"))
+
+				r, err := report.Load(filepath.Join(depsDir, depsIdx))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(r.Deprecations).To(Equal([]string{"rails2-start-command"}))
+			})
+
+			It("does not warn when the Procfile replaces it", func() {
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("web: bundle exec thin start\n"), 0644)).To(Succeed())
+
+				_, err := finalizer.GenerateReleaseYaml()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(buffer.String()).NotTo(ContainSubstring("Deprecated"))
+			})
+		})
 		Context("Rack", func() {
 			BeforeEach(func() {
 				hasRack = true
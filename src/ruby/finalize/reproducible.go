@@ -0,0 +1,185 @@
+package finalize
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"ruby/metrics"
+	"ruby/report"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSourceDateEpoch is 1980-01-01, the earliest time a zip file can hold
+const defaultSourceDateEpoch = 315532800
+
+// buildArtifacts are left behind by native extension builds, they embed
+// temporary paths and timestamps but are not needed at runtime
+var buildArtifacts = []string{"mkmf.log", "gem_make.out"}
+
+// NormalizeDroplet makes two stagings of the same app produce identical
+// droplets when BP_REPRODUCIBLE=true. It removes native build artifacts,
+// leaves what differs between stagings out of the staging report and sets
+// every mtime to SOURCE_DATE_EPOCH, so it must run after everything else has
+// written to the build and dep dirs but RecordDroplet, which keeps the
+// mtimes of what it writes at SOURCE_DATE_EPOCH.
+func (f *Finalizer) NormalizeDroplet() error {
+	if !f.Flags.Bool("BP_REPRODUCIBLE") {
+		return nil
+	}
+
+	epoch := time.Unix(sourceDateEpoch(), 0)
+	f.Log.BeginStep("Normalizing droplet contents to %s", epoch.UTC().Format(time.RFC3339))
+
+	if err := f.removeExtObjects(); err != nil {
+		return err
+	}
+	if err := f.normalizeReport(epoch); err != nil {
+		return err
+	}
+	for _, dir := range []string{f.Stager.DepDir(), f.Stager.BuildDir()} {
+		if err := removeBuildArtifacts(dir); err != nil {
+			return err
+		}
+		if err := normalizeTimes(dir, epoch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sourceDateEpoch() int64 {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil && epoch >= 0 {
+		return epoch
+	}
+	return defaultSourceDateEpoch
+}
+
+func removeBuildArtifacts(dir string) error {
+	var artifacts []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if isBuildArtifact(info.Name()) {
+			artifacts = append(artifacts, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range artifacts {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeReport removes the staging metrics, which measure this staging,
+// from the staging report and from the app, and sets the install times of
+// the dependencies to epoch. Stages which end after WriteMetrics, such as
+// the boot check, record their durations again.
+func (f *Finalizer) normalizeReport(epoch time.Time) error {
+	if err := report.Update(f.Stager.DepDir(), func(r *report.Report) {
+		r.Metrics = nil
+		for i := range r.Dependencies {
+			r.Dependencies[i].InstalledAt = epoch.UTC()
+		}
+	}); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(f.Stager.BuildDir(), metrics.File)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// isBuildArtifact reports whether the file at rel, a slash separated path
+// in the build or dep dir, is one of the buildArtifacts
+func isBuildArtifact(rel string) bool {
+	for _, artifact := range buildArtifacts {
+		if path.Base(rel) == artifact {
+			return true
+		}
+	}
+	return false
+}
+
+// isExtObject reports whether the file at rel, a slash separated path in
+// the dir of a gem installed from a gem source, is an object file or the
+// Makefile of its ext dir. They are only used to compile the gem, the built
+// library is copied out of ext.
+func isExtObject(rel string) bool {
+	return strings.HasPrefix(rel, "ext/") && (path.Base(rel) == "Makefile" || path.Ext(rel) == ".o")
+}
+
+// removeExtObjects removes the ext objects of the installed gems. Ext dirs
+// elsewhere, in the app or in a gem's own lib, may ship them on purpose and
+// are left alone.
+func (f *Finalizer) removeExtObjects() error {
+	dirs, err := f.bundlePath().Glob("gems", "*")
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		var objects []string
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			if isExtObject(filepath.ToSlash(rel)) {
+				objects = append(objects, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, path := range objects {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeTimes walks depth first so setting a file's time does not
+// disturb the time already set on its directory
+func normalizeTimes(dir string, epoch time.Time) error {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// os.Chtimes follows symlinks, their targets are normalized on their own
+		if info.Mode()&os.ModeSymlink == 0 {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err := os.Chtimes(paths[i], epoch, epoch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package finalize_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/featureflags"
+	"ruby/finalize"
+	"ruby/metrics"
+	"ruby/provenance"
+	"ruby/report"
+	"ruby/stages"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NormalizeDroplet", func() {
+	var (
+		err       error
+		buildDir  string
+		depsDir   string
+		extDir    string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+
+		args := []string{buildDir, "", depsDir, "9"}
+		stager := libbuildpack.NewStager(args, logger, &libbuildpack.Manifest{})
+
+		finalizer = &finalize.Finalizer{
+			Stager: stager,
+			Log:    logger,
+			Flags:  featureflags.New([]string{"BP_REPRODUCIBLE=true"}),
+		}
+
+		extDir = filepath.Join(depsDir, "9", "vendor_bundle", "ruby", "2.5.0", "gems", "json-2.1.0", "ext", "json")
+		Expect(os.MkdirAll(extDir, 0755)).To(Succeed())
+		for _, name := range []string{"mkmf.log", "gem_make.out", "Makefile", "parser.o", "parser.c"} {
+			Expect(ioutil.WriteFile(filepath.Join(extDir, name), []byte(name), 0644)).To(Succeed())
+		}
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "config.ru"), []byte("run App"), 0644)).To(Succeed())
+		Expect(os.Symlink(filepath.Join(buildDir, "config.ru"), filepath.Join(buildDir, "link.ru"))).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+		Expect(os.Unsetenv("SOURCE_DATE_EPOCH")).To(Succeed())
+	})
+
+	It("removes native build artifacts", func() {
+		Expect(finalizer.NormalizeDroplet()).To(Succeed())
+		for _, name := range []string{"mkmf.log", "gem_make.out", "Makefile", "parser.o"} {
+			Expect(filepath.Join(extDir, name)).ToNot(BeAnExistingFile())
+		}
+		Expect(filepath.Join(extDir, "parser.c")).To(BeAnExistingFile())
+	})
+
+	It("keeps Makefiles and object files outside the ext dirs of installed gems", func() {
+		appExt := filepath.Join(buildDir, "ext", "native")
+		Expect(os.MkdirAll(appExt, 0755)).To(Succeed())
+		for _, name := range []string{"Makefile", "native.o"} {
+			Expect(ioutil.WriteFile(filepath.Join(appExt, name), []byte(name), 0644)).To(Succeed())
+		}
+
+		Expect(finalizer.NormalizeDroplet()).To(Succeed())
+		Expect(filepath.Join(appExt, "Makefile")).To(BeAnExistingFile())
+		Expect(filepath.Join(appExt, "native.o")).To(BeAnExistingFile())
+		Expect(filepath.Join(extDir, "Makefile")).ToNot(BeAnExistingFile())
+	})
+
+	It("sets every mtime to 1980-01-01", func() {
+		Expect(finalizer.NormalizeDroplet()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Normalizing droplet contents to 1980-01-01T00:00:00Z"))
+		for _, path := range []string{buildDir, filepath.Join(buildDir, "config.ru"), extDir, filepath.Join(extDir, "parser.c")} {
+			info, err := os.Stat(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.ModTime().Unix()).To(Equal(int64(315532800)))
+		}
+	})
+
+	It("uses SOURCE_DATE_EPOCH when it is set", func() {
+		Expect(os.Setenv("SOURCE_DATE_EPOCH", "1500000000")).To(Succeed())
+		Expect(finalizer.NormalizeDroplet()).To(Succeed())
+		info, err := os.Stat(filepath.Join(buildDir, "config.ru"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.ModTime()).To(BeTemporally("==", time.Unix(1500000000, 0)))
+	})
+
+	It("produces the same droplet from two stagings", func() {
+		staging := func() map[string]string {
+			root, err := ioutil.TempDir("", "ruby-buildpack.staging.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(root)
+			build, deps := filepath.Join(root, "app"), filepath.Join(root, "deps")
+			depDir := filepath.Join(deps, "9")
+			Expect(os.MkdirAll(build, 0755)).To(Succeed())
+			Expect(os.MkdirAll(depDir, 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(build, "config.ru"), []byte("run App"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(build, metrics.File), []byte("ruby_buildpack_staging_seconds 1\n"), 0644)).To(Succeed())
+
+			logger := libbuildpack.NewLogger(ansicleaner.New(new(bytes.Buffer)))
+			finalizer := &finalize.Finalizer{
+				Stager: libbuildpack.NewStager([]string{build, "", deps, "9"}, logger, &libbuildpack.Manifest{}),
+				Log:    logger,
+				Flags:  featureflags.New([]string{"BP_REPRODUCIBLE=true"}),
+			}
+			Expect(report.Update(depDir, func(r *report.Report) {
+				r.Dependencies = []provenance.Dependency{{Name: "ruby", Version: "3.2.1", InstalledAt: time.Now()}}
+				r.Metrics = &report.Metrics{CacheSaves: map[string]report.CacheSave{"vendor_bundle": {Seconds: 0.5}}}
+			})).To(Succeed())
+			Expect(stages.Run(logger, depDir, "boot_check", func() error {
+				time.Sleep(time.Millisecond)
+				return nil
+			})).To(Succeed())
+
+			Expect(finalizer.NormalizeDroplet()).To(Succeed())
+			Expect(finalizer.RecordDroplet()).To(Succeed())
+
+			files := map[string]string{}
+			for _, dir := range []string{build, depDir} {
+				Expect(filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+					Expect(err).To(BeNil())
+					rel, err := filepath.Rel(root, path)
+					Expect(err).To(BeNil())
+					files[rel] = fmt.Sprintf("%s %d", info.Mode(), info.ModTime().UnixNano())
+					if info.Mode().IsRegular() {
+						data, err := ioutil.ReadFile(path)
+						Expect(err).To(BeNil())
+						files[rel] += " " + string(data)
+					}
+					return nil
+				})).To(Succeed())
+			}
+			return files
+		}
+
+		first := staging()
+		Expect(first).To(HaveKey(filepath.Join("deps", "9", report.File)))
+		Expect(first).To(HaveKey(filepath.Join("deps", "9", provenance.File)))
+		Expect(first).ToNot(HaveKey(filepath.Join("app", metrics.File)))
+		Expect(staging()).To(Equal(first))
+	})
+
+	Context("BP_REPRODUCIBLE is not set", func() {
+		BeforeEach(func() {
+			finalizer.Flags = featureflags.New([]string{})
+		})
+
+		It("leaves the droplet alone", func() {
+			Expect(finalizer.NormalizeDroplet()).To(Succeed())
+			Expect(filepath.Join(extDir, "mkmf.log")).To(BeAnExistingFile())
+			Expect(buffer.String()).To(BeEmpty())
+		})
+	})
+})
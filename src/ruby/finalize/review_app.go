@@ -0,0 +1,60 @@
+package finalize
+
+import (
+	"path/filepath"
+	"ruby/filesystem"
+	"strings"
+)
+
+const reviewAppSetup = "bundle exec rake cf:review_app:setup"
+
+// SetupReviewApp lets ephemeral review environments be created by a push
+// alone, when BP_REVIEW_APP=true the web process loads the schema and seeds
+// into an empty database before it starts.
+func (f *Finalizer) SetupReviewApp(processTypes map[string]string) error {
+	if !f.Flags.Bool("BP_REVIEW_APP") {
+		return nil
+	}
+
+	if f.RailsVersion == 0 {
+		f.Log.Warning("BP_REVIEW_APP is only supported for Rails apps, skipping review app setup")
+		return nil
+	}
+
+	hasSchema := false
+	for _, name := range []string{"schema.rb", "structure.sql"} {
+		if exists, err := filesystem.Exists(f.fs(), filepath.Join(f.Stager.BuildDir(), "db", name)); err != nil {
+			return err
+		} else if exists {
+			hasSchema = true
+		}
+	}
+	if !hasSchema {
+		f.Log.Warning("BP_REVIEW_APP requires db/schema.rb or db/structure.sql, skipping review app setup")
+		return nil
+	}
+
+	f.Log.BeginStep("Preparing review app database setup")
+
+	rakefile := filepath.Join(f.Stager.BuildDir(), "lib", "tasks", "cf_review_app.rake")
+	if err := f.fs().MkdirAll(filepath.Dir(rakefile), 0755); err != nil {
+		return err
+	}
+	if err := f.fs().WriteFile(rakefile, []byte(review_app_rake), 0644); err != nil {
+		return err
+	}
+
+	if procfileWeb, err := f.webCommand(""); err != nil {
+		return err
+	} else if procfileWeb != "" {
+		if !strings.Contains(procfileWeb, reviewAppSetup) {
+			f.Log.Warning("The Procfile web process replaces the buildpack's, prefix it with '%s && ' to set up the review app database", reviewAppSetup)
+		}
+		return nil
+	}
+
+	if web, found := processTypes["web"]; found {
+		processTypes["web"] = reviewAppSetup + " && " + web
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/featureflags"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SetupReviewApp", func() {
+	var (
+		err          error
+		buildDir     string
+		finalizer    *finalize.Finalizer
+		buffer       *bytes.Buffer
+		processTypes map[string]string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+
+		args := []string{buildDir, "", "", ""}
+		stager := libbuildpack.NewStager(args, logger, &libbuildpack.Manifest{})
+
+		finalizer = &finalize.Finalizer{
+			Stager:       stager,
+			Log:          logger,
+			Flags:        featureflags.New([]string{"BP_REVIEW_APP=true"}),
+			RailsVersion: 5,
+		}
+		processTypes = map[string]string{"web": "bin/rails server"}
+
+		Expect(os.MkdirAll(filepath.Join(buildDir, "db"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "db", "schema.rb"), []byte("ActiveRecord::Schema.define {}"), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("writes the setup rake task and runs it before the web process", func() {
+		Expect(finalizer.SetupReviewApp(processTypes)).To(Succeed())
+		Expect(processTypes["web"]).To(Equal("bundle exec rake cf:review_app:setup && bin/rails server"))
+
+		rakefile, err := ioutil.ReadFile(filepath.Join(buildDir, "lib", "tasks", "cf_review_app.rake"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(rakefile)).To(ContainSubstring(`Rake::Task["db:schema:load"].invoke`))
+		Expect(string(rakefile)).To(ContainSubstring(`Rake::Task["db:seed"].invoke`))
+	})
+
+	Context("BP_REVIEW_APP is not set", func() {
+		BeforeEach(func() {
+			finalizer.Flags = featureflags.New([]string{})
+		})
+
+		It("does nothing", func() {
+			Expect(finalizer.SetupReviewApp(processTypes)).To(Succeed())
+			Expect(processTypes["web"]).To(Equal("bin/rails server"))
+			Expect(filepath.Join(buildDir, "lib", "tasks", "cf_review_app.rake")).ToNot(BeAnExistingFile())
+		})
+	})
+
+	Context("the app is not a Rails app", func() {
+		BeforeEach(func() {
+			finalizer.RailsVersion = 0
+		})
+
+		It("warns and skips the setup", func() {
+			Expect(finalizer.SetupReviewApp(processTypes)).To(Succeed())
+			Expect(processTypes["web"]).To(Equal("bin/rails server"))
+			Expect(buffer.String()).To(ContainSubstring("BP_REVIEW_APP is only supported for Rails apps"))
+		})
+	})
+
+	Context("the app has no schema file", func() {
+		BeforeEach(func() {
+			Expect(os.Remove(filepath.Join(buildDir, "db", "schema.rb"))).To(Succeed())
+		})
+
+		It("warns and skips the setup", func() {
+			Expect(finalizer.SetupReviewApp(processTypes)).To(Succeed())
+			Expect(processTypes["web"]).To(Equal("bin/rails server"))
+			Expect(buffer.String()).To(ContainSubstring("BP_REVIEW_APP requires db/schema.rb or db/structure.sql"))
+		})
+	})
+
+	Context("the app has a Procfile web process", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("web: bundle exec puma\n"), 0644)).To(Succeed())
+		})
+
+		It("warns that the Procfile must run the setup task", func() {
+			Expect(finalizer.SetupReviewApp(processTypes)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("prefix it with 'bundle exec rake cf:review_app:setup && '"))
+		})
+	})
+})
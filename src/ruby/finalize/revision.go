@@ -0,0 +1,27 @@
+package finalize
+
+import (
+	"ruby/profiled"
+	"ruby/report"
+)
+
+// appRevision is the revision supply recorded in the staging report
+func (f *Finalizer) appRevision() string {
+	r, err := report.Load(f.Stager.DepDir())
+	if err != nil {
+		return ""
+	}
+	return r.Revision
+}
+
+// WriteRevision exports the revision the app was pushed from as
+// APP_REVISION, so logs and error reports of the running app can name the
+// commit. A value set with cf set-env wins.
+func (f *Finalizer) WriteRevision() error {
+	rev := f.appRevision()
+	if rev == "" {
+		f.Log.Debug("The app has no REVISION file or git metadata")
+		return nil
+	}
+	return profiled.New().AddEnvDefault("APP_REVISION", profiled.Literal(rev)).Write(f.Stager.DepDir(), "app_revision.sh")
+}
@@ -0,0 +1,52 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/finalize"
+	"ruby/report"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteRevision", func() {
+	var (
+		err       error
+		buildDir  string
+		depsDir   string
+		finalizer *finalize.Finalizer
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		logger := libbuildpack.NewLogger(ansicleaner.New(new(bytes.Buffer)))
+		stager := libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{})
+		finalizer = &finalize.Finalizer{Stager: stager, Log: logger}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("exports the revision supply recorded", func() {
+		Expect(report.Update(filepath.Join(depsDir, "0"), func(r *report.Report) { r.Revision = "v1.2.3" })).To(Succeed())
+		Expect(finalizer.WriteRevision()).To(Succeed())
+		Expect(ioutil.ReadFile(filepath.Join(depsDir, "0", "profile.d", "app_revision.sh"))).To(Equal([]byte("export APP_REVISION=${APP_REVISION:-'v1.2.3'}\n")))
+	})
+
+	It("writes nothing when the app has no revision", func() {
+		Expect(finalizer.WriteRevision()).To(Succeed())
+		Expect(filepath.Join(depsDir, "0", "profile.d", "app_revision.sh")).ToNot(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,129 @@
+package finalize
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"ruby/schedules"
+	"strings"
+)
+
+// missingConstant prefixes the classes the runner script can not load, the
+// app may print other things while it boots
+const missingConstant = "missing-constant: "
+
+const missingConstantsScript = `ARGV.each { |name| begin; Object.const_get(name); rescue NameError, LoadError; puts "` + missingConstant + `#{name}"; end }`
+
+// CheckSchedules checks the jobs of the app's scheduler descriptors, a
+// sidekiq or resque schedule or a whenever schedule.rb, while staging. The
+// rake tasks they run are looked up with rake -P and their classes loaded in
+// rails runner, only Rails apps are booted for that. Problems are warnings
+// unless BP_STRICT_SCHEDULES is set, an app which can not be booted while
+// staging only skips the check.
+func (f *Finalizer) CheckSchedules() error {
+	jobs, problems, err := schedules.Load(f.Stager.BuildDir())
+	if err != nil {
+		f.Log.Warning("Unable to check the scheduled jobs: %s", err.Error())
+		return nil
+	}
+	if len(jobs) == 0 && len(problems) == 0 {
+		return nil
+	}
+	f.Log.BeginStep("Checking %d scheduled jobs", len(jobs))
+
+	var (
+		tasks   []string
+		classes []string
+	)
+	for _, job := range jobs {
+		if job.Task != "" {
+			tasks = append(tasks, job.Task)
+		} else {
+			classes = append(classes, job.Class)
+		}
+	}
+
+	if len(tasks) > 0 {
+		if defined, err := f.rakeTasks(); err != nil {
+			f.Log.Warning("Unable to list the rake tasks of the app, their jobs are not checked: %s", err.Error())
+		} else {
+			for _, job := range jobs {
+				if job.Task != "" && !defined[job.Task] {
+					problems = append(problems, fmt.Sprintf("%s in %s runs the rake task %s, which does not exist", job.Name, job.File, job.Task))
+				}
+			}
+		}
+	}
+
+	if len(classes) > 0 && f.RailsVersion < 3 {
+		f.Log.Debug("Not checking the classes of the scheduled jobs, only Rails apps are booted for that")
+	} else if len(classes) > 0 {
+		if missing, err := f.missingConstants(classes); err != nil {
+			f.Log.Warning("Unable to boot the app, the classes of the scheduled jobs are not checked: %s", err.Error())
+		} else {
+			for _, job := range jobs {
+				if job.Class != "" && missing[job.Class] {
+					problems = append(problems, fmt.Sprintf("%s in %s runs the class %s, which does not exist", job.Name, job.File, job.Class))
+				}
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	message := fmt.Sprintf("The scheduler will skip these jobs at runtime:\n  - %s", strings.Join(problems, "\n  - "))
+	if f.Flags.Bool("BP_STRICT_SCHEDULES") {
+		f.Log.Error("%s", message)
+		return fmt.Errorf("%d problems with the scheduled jobs", len(problems))
+	}
+	f.Log.Warning("%s\nSet BP_STRICT_SCHEDULES=true to fail staging on these problems.", message)
+	return nil
+}
+
+// rakeTasks lists every rake task of the app, including those without a
+// description
+func (f *Finalizer) rakeTasks() (map[string]bool, error) {
+	output := new(bytes.Buffer)
+	cmd := exec.Command("bundle", "exec", "rake", "-P")
+	cmd.Dir = f.Stager.BuildDir()
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.Env = f.rakeEnv()
+	if err := f.Command.Run(cmd); err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, output.String())
+	}
+
+	tasks := map[string]bool{}
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "rake ") {
+			tasks[strings.TrimPrefix(line, "rake ")] = true
+		}
+	}
+	return tasks, scanner.Err()
+}
+
+// missingConstants loads classes in the booted app and returns those which
+// do not exist
+func (f *Finalizer) missingConstants(classes []string) (map[string]bool, error) {
+	output := new(bytes.Buffer)
+	cmd := exec.Command("bundle", append([]string{"exec", "rails", "runner", missingConstantsScript}, classes...)...)
+	cmd.Dir = f.Stager.BuildDir()
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.Env = f.rakeEnv()
+	if err := f.Command.Run(cmd); err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, output.String())
+	}
+
+	missing := map[string]bool{}
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, missingConstant) {
+			missing[strings.TrimPrefix(line, missingConstant)] = true
+		}
+	}
+	return missing, scanner.Err()
+}
@@ -0,0 +1,134 @@
+package finalize_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/config"
+	"ruby/featureflags"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CheckSchedules", func() {
+	var (
+		err         error
+		buildDir    string
+		finalizer   *finalize.Finalizer
+		buffer      *bytes.Buffer
+		mockCtrl    *gomock.Controller
+		mockCommand *MockCommand
+		commands    [][]string
+	)
+
+	write := func(file, contents string) {
+		Expect(os.MkdirAll(filepath.Join(buildDir, filepath.Dir(file)), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, file), []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockCommand = NewMockCommand(mockCtrl)
+		mockVersions := NewMockVersions(mockCtrl)
+		mockVersions.EXPECT().HasGem(gomock.Any()).AnyTimes().Return(false, nil)
+		commands = nil
+
+		finalizer = &finalize.Finalizer{
+			Stager:       libbuildpack.NewStager([]string{buildDir, "", buildDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Versions:     mockVersions,
+			Command:      mockCommand,
+			Log:          logger,
+			Flags:        featureflags.New([]string{}),
+			Config:       &config.Config{},
+			RailsVersion: 5,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("does nothing without a schedule", func() {
+		Expect(finalizer.CheckSchedules()).To(Succeed())
+		Expect(buffer.String()).To(BeEmpty())
+	})
+
+	Context("with jobs which do not exist", func() {
+		BeforeEach(func() {
+			write("config/schedule.yml", "cleanup:\n  cron: '0 3 * * *'\n  class: CleanupJob\nreport:\n  every: 1h\n  class: Reports::Daily\n")
+			write("config/schedule.rb", "every 1.day do\n  rake 'sessions:trim'\n  rake 'db:sessions:purge'\nend\n")
+			mockCommand.EXPECT().Run(gomock.Any()).AnyTimes().DoAndReturn(func(cmd *exec.Cmd) error {
+				commands = append(commands, cmd.Args)
+				Expect(cmd.Dir).To(Equal(buildDir))
+				Expect(cmd.Env).To(ContainElement("SECRET_KEY_BASE=dummy-staging-key"))
+				if cmd.Args[2] == "rake" {
+					fmt.Fprint(cmd.Stdout, "rake db:migrate\n    environment\nrake sessions:trim\n")
+				} else {
+					fmt.Fprint(cmd.Stdout, "DEPRECATION WARNING: noise\nmissing-constant: Reports::Daily\n")
+				}
+				return nil
+			})
+		})
+
+		It("warns about them", func() {
+			Expect(finalizer.CheckSchedules()).To(Succeed())
+
+			Expect(commands).To(HaveLen(2))
+			Expect(commands[0]).To(Equal([]string{"bundle", "exec", "rake", "-P"}))
+			Expect(commands[1][:4]).To(Equal([]string{"bundle", "exec", "rails", "runner"}))
+			Expect(commands[1][5:]).To(Equal([]string{"CleanupJob", "Reports::Daily"}))
+			Expect(buffer.String()).To(ContainSubstring("Checking 4 scheduled jobs"))
+			Expect(buffer.String()).To(ContainSubstring("The scheduler will skip these jobs at runtime:"))
+			Expect(buffer.String()).To(ContainSubstring("- report in config/schedule.yml runs the class Reports::Daily, which does not exist"))
+			Expect(buffer.String()).To(ContainSubstring("- rake 'db:sessions:purge' in config/schedule.rb runs the rake task db:sessions:purge, which does not exist"))
+			Expect(buffer.String()).ToNot(ContainSubstring("CleanupJob, which"))
+			Expect(buffer.String()).ToNot(ContainSubstring("sessions:trim, which"))
+		})
+
+		It("fails with BP_STRICT_SCHEDULES", func() {
+			finalizer.Flags = featureflags.New([]string{"BP_STRICT_SCHEDULES=true"})
+			Expect(finalizer.CheckSchedules()).To(MatchError("2 problems with the scheduled jobs"))
+			Expect(buffer.String()).To(ContainSubstring("**ERROR** The scheduler will skip these jobs at runtime:"))
+		})
+
+		It("only checks the rake tasks of other apps", func() {
+			finalizer.RailsVersion = 0
+			Expect(finalizer.CheckSchedules()).To(Succeed())
+			Expect(commands).To(Equal([][]string{{"bundle", "exec", "rake", "-P"}}))
+		})
+	})
+
+	It("skips the check when the app does not boot", func() {
+		write("config/schedule.yml", "cleanup:\n  cron: '0 3 * * *'\n  class: CleanupJob\n")
+		mockCommand.EXPECT().Run(gomock.Any()).DoAndReturn(func(cmd *exec.Cmd) error {
+			fmt.Fprint(cmd.Stderr, "could not connect to redis")
+			return errors.New("exit status 1")
+		})
+		finalizer.Flags = featureflags.New([]string{"BP_STRICT_SCHEDULES=true"})
+
+		Expect(finalizer.CheckSchedules()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Unable to boot the app, the classes of the scheduled jobs are not checked: exit status 1"))
+	})
+
+	It("reports jobs without a schedule", func() {
+		write("config/schedule.yml", "cleanup:\n  class: CleanupJob\n")
+		mockCommand.EXPECT().Run(gomock.Any()).Return(nil)
+
+		Expect(finalizer.CheckSchedules()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("- cleanup in config/schedule.yml has no cron, every, at, in, interval"))
+	})
+})
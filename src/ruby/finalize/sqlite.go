@@ -0,0 +1,149 @@
+package finalize
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"ruby/profiled"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+type sqliteDatabase struct {
+	Name     string
+	Database string
+}
+
+type volumeMount struct {
+	ContainerDir string `json:"container_dir"`
+	Mode         string `json:"mode"`
+}
+
+// ConfigureSQLite warns when the production database is a sqlite3 file,
+// which Rails 7.1 and later generate apps with. The file is in the
+// container, so it is lost whenever an instance restarts and every instance
+// has a database of its own. With BP_SQLITE_VOLUME the primary database is
+// moved to a bound volume service by exporting DATABASE_URL, unless the app
+// sets it.
+func (f *Finalizer) ConfigureSQLite() error {
+	if hasSQLite, err := f.Versions.HasGem("sqlite3"); err != nil || !hasSQLite {
+		return err
+	}
+	if url := os.Getenv("DATABASE_URL"); url != "" && !strings.HasPrefix(url, "sqlite3:") {
+		return nil
+	}
+
+	databases, err := f.productionSQLite()
+	if err != nil || len(databases) == 0 {
+		return err
+	}
+
+	f.Log.Warning("config/database.yml stores the production database in %s with sqlite3. The container filesystem is ephemeral, the database is lost whenever the app restarts, and every instance has its own. Bind a database service, or a volume service to keep the sqlite3 file on.", databases[0].Database)
+	if !f.Flags.Bool("BP_SQLITE_VOLUME") {
+		return nil
+	}
+
+	dir, service, err := volumeServiceDir()
+	if err != nil {
+		return err
+	} else if dir == "" {
+		return nil
+	}
+
+	url := "sqlite3:" + path.Join(dir, path.Base(databases[0].Database))
+	f.Log.BeginStep("Storing the %s database on the volume service %s", databases[0].Name, service)
+	f.Log.Info("DATABASE_URL defaults to %s", url)
+	for _, database := range databases[1:] {
+		f.Log.Info("The %s database is not configured by DATABASE_URL, set its database to a path below %s in config/database.yml", database.Name, dir)
+	}
+
+	env := profiled.New().AddEnv("DATABASE_URL", profiled.Literal(url))
+	return profiled.New().AddScriptBlock(env, profiled.IfUnset("DATABASE_URL")).WriteFS(f.fs(), f.Stager.DepDir(), "sqlite_volume.sh")
+}
+
+// productionSQLite returns the sqlite3 databases of the production
+// environment in config/database.yml, the primary one first. A multiple
+// database config has a section for each database below production.
+func (f *Finalizer) productionSQLite() ([]sqliteDatabase, error) {
+	data, err := f.fs().ReadFile(filepath.Join(f.Stager.BuildDir(), "config", "database.yml"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var environments map[string]map[string]interface{}
+	if err := yaml.Unmarshal(erbTag.ReplaceAll(data, []byte("erb")), &environments); err != nil {
+		f.Log.Warning("Could not parse config/database.yml, skipping sqlite3 checks: %v", err)
+		return nil, nil
+	}
+	production := environments["production"]
+
+	sections := map[string]map[interface{}]interface{}{}
+	if _, single := production["adapter"]; single {
+		sections["primary"] = map[interface{}]interface{}{"adapter": production["adapter"], "database": production["database"]}
+	} else {
+		for name, value := range production {
+			if section, ok := value.(map[interface{}]interface{}); ok {
+				sections[name] = section
+			}
+		}
+	}
+
+	var names []string
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == "primary" || names[j] == "primary" {
+			return names[i] == "primary"
+		}
+		return names[i] < names[j]
+	})
+
+	var databases []sqliteDatabase
+	for _, name := range names {
+		if adapter, _ := sections[name]["adapter"].(string); adapter != "sqlite3" {
+			continue
+		}
+		database, _ := sections[name]["database"].(string)
+		databases = append(databases, sqliteDatabase{Name: name, Database: database})
+	}
+	return databases, nil
+}
+
+// volumeServiceDir returns where the first writable volume service in
+// VCAP_SERVICES is mounted and the name of the service
+func volumeServiceDir() (string, string, error) {
+	vcapServices := os.Getenv("VCAP_SERVICES")
+	if vcapServices == "" {
+		return "", "", nil
+	}
+	var services map[string][]struct {
+		Name         string        `json:"name"`
+		VolumeMounts []volumeMount `json:"volume_mounts"`
+	}
+	if err := json.Unmarshal([]byte(vcapServices), &services); err != nil {
+		return "", "", fmt.Errorf("could not parse VCAP_SERVICES: %v", err)
+	}
+
+	var labels []string
+	for label := range services {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		for _, instance := range services[label] {
+			for _, mount := range instance.VolumeMounts {
+				if mount.Mode == "rw" && mount.ContainerDir != "" {
+					return mount.ContainerDir, instance.Name, nil
+				}
+			}
+		}
+	}
+	return "", "", nil
+}
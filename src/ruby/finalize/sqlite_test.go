@@ -0,0 +1,162 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/featureflags"
+	"ruby/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConfigureSQLite", func() {
+	const rails71DatabaseYml = `default: &default
+  adapter: sqlite3
+  pool: <%= ENV.fetch("RAILS_MAX_THREADS") { 5 } %>
+  timeout: 5000
+
+development:
+  <<: *default
+  database: storage/development.sqlite3
+
+production:
+  <<: *default
+  database: storage/production.sqlite3
+`
+	const volumeServices = `{"nfs":[{"name":"data","volume_mounts":[{"container_dir":"/var/vcap/data/d7f3","mode":"rw","device_type":"shared"}]}]}`
+
+	var (
+		err          error
+		buildDir     string
+		depsDir      string
+		finalizer    *finalize.Finalizer
+		buffer       *bytes.Buffer
+		mockCtrl     *gomock.Controller
+		mockVersions *MockVersions
+		gems         map[string]bool
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(buildDir, "config"), 0755)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+
+		gems = map[string]bool{"sqlite3": true}
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockVersions = NewMockVersions(mockCtrl)
+		mockVersions.EXPECT().HasGem(gomock.Any()).AnyTimes().DoAndReturn(func(name string) (bool, error) {
+			return gems[name], nil
+		})
+
+		args := []string{buildDir, "", depsDir, "0"}
+		finalizer = &finalize.Finalizer{
+			Stager:   libbuildpack.NewStager(args, logger, &libbuildpack.Manifest{}),
+			Versions: mockVersions,
+			Log:      logger,
+			Flags:    featureflags.New([]string{}),
+		}
+		os.Unsetenv("DATABASE_URL")
+		os.Unsetenv("VCAP_SERVICES")
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		os.Unsetenv("DATABASE_URL")
+		os.Unsetenv("VCAP_SERVICES")
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	writeFile := func(name, contents string) {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, name), []byte(contents), 0644)).To(Succeed())
+	}
+	script := filepath.Join("profile.d", "sqlite_volume.sh")
+
+	Context("the production database is a sqlite3 file", func() {
+		BeforeEach(func() {
+			writeFile("config/database.yml", rails71DatabaseYml)
+		})
+
+		It("warns that the database is lost on restart", func() {
+			Expect(finalizer.ConfigureSQLite()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("**WARNING** config/database.yml stores the production database in storage/production.sqlite3 with sqlite3. The container filesystem is ephemeral"))
+			Expect(filepath.Join(depsDir, "0", script)).ToNot(BeAnExistingFile())
+		})
+
+		It("moves the database to a bound volume service", func() {
+			os.Setenv("VCAP_SERVICES", volumeServices)
+
+			Expect(finalizer.ConfigureSQLite()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Storing the primary database on the volume service data"))
+			contents, err := ioutil.ReadFile(filepath.Join(depsDir, "0", script))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(ContainSubstring(`if [ -z "${DATABASE_URL:-}" ]; then`))
+			Expect(string(contents)).To(ContainSubstring("export DATABASE_URL='sqlite3:/var/vcap/data/d7f3/production.sqlite3'"))
+		})
+
+		It("leaves the database alone when BP_SQLITE_VOLUME is false", func() {
+			os.Setenv("VCAP_SERVICES", volumeServices)
+			finalizer.Flags = featureflags.New([]string{"BP_SQLITE_VOLUME=false"})
+
+			Expect(finalizer.ConfigureSQLite()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("The container filesystem is ephemeral"))
+			Expect(filepath.Join(depsDir, "0", script)).ToNot(BeAnExistingFile())
+		})
+
+		It("ignores read only volumes", func() {
+			os.Setenv("VCAP_SERVICES", `{"nfs":[{"name":"data","volume_mounts":[{"container_dir":"/var/vcap/data/d7f3","mode":"r"}]}]}`)
+
+			Expect(finalizer.ConfigureSQLite()).To(Succeed())
+			Expect(filepath.Join(depsDir, "0", script)).ToNot(BeAnExistingFile())
+		})
+
+		It("does nothing when DATABASE_URL points at another database", func() {
+			os.Setenv("DATABASE_URL", "postgres://db.example.com/app")
+
+			Expect(finalizer.ConfigureSQLite()).To(Succeed())
+			Expect(buffer.String()).To(Equal(""))
+		})
+	})
+
+	Context("the production environment has multiple sqlite3 databases", func() {
+		BeforeEach(func() {
+			writeFile("config/database.yml", `production:
+  primary:
+    adapter: sqlite3
+    database: storage/production.sqlite3
+  cache:
+    adapter: sqlite3
+    database: storage/production_cache.sqlite3
+`)
+			os.Setenv("VCAP_SERVICES", volumeServices)
+		})
+
+		It("moves the primary database and explains how to move the others", func() {
+			Expect(finalizer.ConfigureSQLite()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("DATABASE_URL defaults to sqlite3:/var/vcap/data/d7f3/production.sqlite3"))
+			Expect(buffer.String()).To(ContainSubstring("The cache database is not configured by DATABASE_URL, set its database to a path below /var/vcap/data/d7f3 in config/database.yml"))
+		})
+	})
+
+	Context("the production database is not sqlite3", func() {
+		BeforeEach(func() {
+			writeFile("config/database.yml", "production:\n  adapter: postgresql\n  database: app_production\n")
+		})
+
+		It("does nothing", func() {
+			Expect(finalizer.ConfigureSQLite()).To(Succeed())
+			Expect(buffer.String()).To(Equal(""))
+		})
+	})
+})
@@ -0,0 +1,175 @@
+package finalize
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"ruby/depsdir"
+	"ruby/yarnlock"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/kr/text"
+)
+
+// defaultOfflineMirror is where apps vendor a yarn offline mirror when
+// .yarnrc does not set yarn-offline-mirror, relative to the app
+const defaultOfflineMirror = "npm-packages-offline-cache"
+
+var yarnrcOfflineMirror = regexp.MustCompile(`(?m)^\s*"?yarn-offline-mirror"?\s+"?([^"\n]*?)"?\s*$`)
+
+// InstallVendoredNodePackages runs yarn install before the assets are
+// compiled when the app vendors its node packages, in node_modules or in an
+// offline mirror, so airgapped builds do not need the registry. When every
+// package of yarn.lock is vendored yarn runs with --offline, otherwise the
+// packages yarn still fetches are listed and it runs with --prefer-offline,
+// or staging fails with BP_YARN_OFFLINE. Tarballs in the mirror must match
+// the integrity yarn.lock records for them.
+func (f *Finalizer) InstallVendoredNodePackages() error {
+	buildDir := f.Stager.BuildDir()
+	if exists, err := libbuildpack.FileExists(filepath.Join(buildDir, "yarn.lock")); err != nil || !exists {
+		return err
+	}
+	mirror, fromYarnrc, err := f.yarnOfflineMirror()
+	if err != nil {
+		return err
+	}
+	nodeModules, err := f.vendoredNodeModules()
+	if err != nil {
+		return err
+	}
+	packages, err := yarnlock.ParseFile(filepath.Join(buildDir, "yarn.lock"))
+	if mirror == "" && !nodeModules {
+		// yarn fetches every package when the assets are compiled
+		if err == nil {
+			f.recordNodeEgress(packages)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var vendored []string
+	if nodeModules {
+		vendored = append(vendored, "node_modules")
+	}
+	if mirror != "" {
+		rel, err := filepath.Rel(buildDir, mirror)
+		if err != nil {
+			rel = mirror
+		}
+		vendored = append(vendored, "the offline mirror "+rel)
+	}
+
+	installed := map[string]bool{}
+	if nodeModules {
+		if installed, err = yarnlock.Installed(filepath.Join(buildDir, "node_modules")); err != nil {
+			return err
+		}
+	}
+	var (
+		missing  []string
+		fetching []yarnlock.Package
+	)
+	for _, pkg := range packages {
+		if installed[pkg.String()] {
+			continue
+		}
+		if file := pkg.MirrorFile(); mirror != "" && file != "" {
+			tarball := filepath.Join(mirror, file)
+			if exists, err := libbuildpack.FileExists(tarball); err != nil {
+				return err
+			} else if exists {
+				if err := pkg.Verify(tarball); err != nil {
+					return fmt.Errorf("the offline mirror has a corrupt tarball of %s: %v", pkg, err)
+				}
+				continue
+			}
+		}
+		missing = append(missing, pkg.String())
+		fetching = append(fetching, pkg)
+	}
+
+	args := []string{"install", "--frozen-lockfile", "--non-interactive"}
+	if len(missing) == 0 {
+		f.Log.BeginStep("Installing the node packages from %s without the registry", strings.Join(vendored, " and "))
+		args = append(args, "--offline")
+	} else if f.Flags.Bool("BP_YARN_OFFLINE") {
+		return fmt.Errorf("%d packages of yarn.lock are not in %s, yarn would fetch them from the registry: %s", len(missing), strings.Join(vendored, " or "), strings.Join(missing, ", "))
+	} else {
+		f.Log.Warning("%d packages of yarn.lock are not in %s, yarn fetches them from the registry:\n%s", len(missing), strings.Join(vendored, " or "), strings.Join(missing, "\n"))
+		f.Log.BeginStep("Installing the node packages, preferring %s", strings.Join(vendored, " and "))
+		args = append(args, "--prefer-offline")
+		f.recordNodeEgress(fetching)
+	}
+
+	env := append(os.Environ(), "YARN_CACHE_FOLDER="+f.deps().Join(depsdir.YarnCache))
+	if mirror != "" && !fromYarnrc {
+		env = append(env, "YARN_YARN_OFFLINE_MIRROR="+mirror)
+	}
+	cmd := exec.Command("yarn", args...)
+	cmd.Dir = buildDir
+	cmd.Stdout = text.NewIndentWriter(f.Log.Output(), []byte("       "))
+	cmd.Stderr = text.NewIndentWriter(f.Log.Output(), []byte("       "))
+	cmd.Env = env
+	if err := f.Command.Run(cmd); err != nil {
+		return fmt.Errorf("yarn install failed: %v", err)
+	}
+	return nil
+}
+
+// recordNodeEgress records the hosts yarn fetches packages from
+func (f *Finalizer) recordNodeEgress(packages []yarnlock.Package) {
+	var resolved []string
+	for _, pkg := range packages {
+		resolved = append(resolved, pkg.Resolved)
+	}
+	f.recordEgress("node packages", resolved...)
+}
+
+// yarnOfflineMirror returns the offline mirror of the app if it has one,
+// and whether .yarnrc configures it, yarn only uses the default location
+// when told
+func (f *Finalizer) yarnOfflineMirror() (string, bool, error) {
+	buildDir := f.Stager.BuildDir()
+	mirror, fromYarnrc := filepath.Join(buildDir, defaultOfflineMirror), false
+	data, err := ioutil.ReadFile(filepath.Join(buildDir, ".yarnrc"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", false, err
+	}
+	if m := yarnrcOfflineMirror.FindSubmatch(data); m != nil && len(m[1]) > 0 {
+		mirror, fromYarnrc = string(m[1]), true
+		if !filepath.IsAbs(mirror) {
+			mirror = filepath.Join(buildDir, mirror)
+		}
+	}
+	if info, err := os.Stat(mirror); os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	} else if !info.IsDir() {
+		return "", false, nil
+	}
+	return mirror, fromYarnrc, nil
+}
+
+// vendoredNodeModules reports whether the app vendors a node_modules which
+// yarn check --integrity agrees is installed from yarn.lock
+func (f *Finalizer) vendoredNodeModules() (bool, error) {
+	integrity := filepath.Join(f.Stager.BuildDir(), "node_modules", ".yarn-integrity")
+	if exists, err := libbuildpack.FileExists(integrity); err != nil || !exists {
+		return false, err
+	}
+	cmd := exec.Command("yarn", "check", "--integrity", "--non-interactive")
+	cmd.Dir = f.Stager.BuildDir()
+	cmd.Stdout = ioutil.Discard
+	cmd.Stderr = ioutil.Discard
+	if err := f.Command.Run(cmd); err != nil {
+		f.Log.Warning("The vendored node_modules does not match yarn.lock, yarn check --integrity failed")
+		return false, nil
+	}
+	return true, nil
+}
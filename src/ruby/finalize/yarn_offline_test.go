@@ -0,0 +1,164 @@
+package finalize_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/config"
+	"ruby/featureflags"
+	"ruby/finalize"
+	"ruby/report"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("InstallVendoredNodePackages", func() {
+	var (
+		err         error
+		buildDir    string
+		depsDir     string
+		finalizer   *finalize.Finalizer
+		buffer      *bytes.Buffer
+		mockCtrl    *gomock.Controller
+		mockCommand *MockCommand
+		installs    []*exec.Cmd
+		checkErr    error
+	)
+
+	write := func(file, contents string) {
+		Expect(os.MkdirAll(filepath.Join(buildDir, filepath.Dir(file)), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, file), []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "ruby-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockCommand = NewMockCommand(mockCtrl)
+		installs = nil
+		checkErr = nil
+		mockCommand.EXPECT().Run(gomock.Any()).AnyTimes().DoAndReturn(func(cmd *exec.Cmd) error {
+			Expect(cmd.Dir).To(Equal(buildDir))
+			if cmd.Args[1] == "check" {
+				return checkErr
+			}
+			installs = append(installs, cmd)
+			return nil
+		})
+
+		finalizer = &finalize.Finalizer{
+			Stager:  libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Command: mockCommand,
+			Log:     logger,
+			Flags:   featureflags.New([]string{}),
+			Config:  &config.Config{},
+		}
+
+		write("yarn.lock", `left-pad@^1.3.0:
+  version "1.3.0"
+  resolved "https://registry.yarnpkg.com/left-pad/-/left-pad-1.3.0.tgz#ebfb0f08ca499b3bc417c83545d8788f826adc86"
+
+"@rails/webpacker@^5.0.0":
+  version "5.0.1"
+  resolved "https://registry.yarnpkg.com/@rails/webpacker/-/webpacker-5.0.1.tgz"
+`)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("does nothing when the packages are not vendored", func() {
+		Expect(finalizer.InstallVendoredNodePackages()).To(Succeed())
+		Expect(installs).To(BeEmpty())
+		Expect(buffer.String()).To(BeEmpty())
+	})
+
+	Context("with an offline mirror", func() {
+		BeforeEach(func() {
+			write("npm-packages-offline-cache/left-pad-1.3.0.tgz", "tarball of left-pad")
+		})
+
+		It("installs offline when the mirror has every package", func() {
+			write("npm-packages-offline-cache/@rails-webpacker-5.0.1.tgz", "tarball of webpacker")
+			Expect(finalizer.InstallVendoredNodePackages()).To(Succeed())
+
+			Expect(installs).To(HaveLen(1))
+			Expect(installs[0].Args).To(Equal([]string{"yarn", "install", "--frozen-lockfile", "--non-interactive", "--offline"}))
+			Expect(installs[0].Env).To(ContainElement("YARN_YARN_OFFLINE_MIRROR=" + filepath.Join(buildDir, "npm-packages-offline-cache")))
+			Expect(installs[0].Env).To(ContainElement("YARN_CACHE_FOLDER=" + filepath.Join(depsDir, "0", "yarn_cache")))
+			Expect(buffer.String()).To(ContainSubstring("Installing the node packages from the offline mirror npm-packages-offline-cache without the registry"))
+		})
+
+		It("lists the packages yarn still fetches", func() {
+			Expect(finalizer.InstallVendoredNodePackages()).To(Succeed())
+
+			Expect(installs).To(HaveLen(1))
+			Expect(installs[0].Args).To(ContainElement("--prefer-offline"))
+			Expect(buffer.String()).To(ContainSubstring("1 packages of yarn.lock are not in the offline mirror npm-packages-offline-cache, yarn fetches them from the registry:\n       @rails/webpacker@5.0.1"))
+
+			r, err := report.Load(filepath.Join(depsDir, "0"))
+			Expect(err).To(BeNil())
+			Expect(r.Egress).To(Equal([]report.Host{{Host: "registry.yarnpkg.com:443", Purposes: []string{"node packages"}}}))
+		})
+
+		It("fails with BP_YARN_OFFLINE when packages are missing", func() {
+			finalizer.Flags = featureflags.New([]string{"BP_YARN_OFFLINE=true"})
+			Expect(finalizer.InstallVendoredNodePackages()).To(MatchError("1 packages of yarn.lock are not in the offline mirror npm-packages-offline-cache, yarn would fetch them from the registry: @rails/webpacker@5.0.1"))
+			Expect(installs).To(BeEmpty())
+		})
+
+		It("fails for a tarball which does not match yarn.lock", func() {
+			write("npm-packages-offline-cache/left-pad-1.3.0.tgz", "tampered")
+			Expect(finalizer.InstallVendoredNodePackages()).To(MatchError(ContainSubstring("the offline mirror has a corrupt tarball of left-pad@1.3.0: sha1 of left-pad-1.3.0.tgz is ")))
+			Expect(installs).To(BeEmpty())
+		})
+
+		It("uses the mirror .yarnrc configures", func() {
+			Expect(os.Rename(filepath.Join(buildDir, "npm-packages-offline-cache"), filepath.Join(buildDir, "vendor-npm"))).To(Succeed())
+			write("vendor-npm/@rails-webpacker-5.0.1.tgz", "tarball of webpacker")
+			write(".yarnrc", "yarn-offline-mirror \"./vendor-npm\"\nyarn-offline-mirror-pruning true\n")
+			Expect(finalizer.InstallVendoredNodePackages()).To(Succeed())
+
+			Expect(installs).To(HaveLen(1))
+			Expect(installs[0].Args).To(ContainElement("--offline"))
+			Expect(installs[0].Env).ToNot(ContainElement(HavePrefix("YARN_YARN_OFFLINE_MIRROR=")))
+		})
+	})
+
+	Context("with a vendored node_modules", func() {
+		BeforeEach(func() {
+			write("node_modules/.yarn-integrity", "{}")
+			write("node_modules/left-pad/package.json", `{"name": "left-pad", "version": "1.3.0"}`)
+			write("node_modules/@rails/webpacker/package.json", `{"name": "@rails/webpacker", "version": "5.0.1"}`)
+		})
+
+		It("installs offline when yarn agrees node_modules matches yarn.lock", func() {
+			Expect(finalizer.InstallVendoredNodePackages()).To(Succeed())
+			Expect(installs).To(HaveLen(1))
+			Expect(installs[0].Args).To(ContainElement("--offline"))
+			Expect(buffer.String()).To(ContainSubstring("Installing the node packages from node_modules without the registry"))
+		})
+
+		It("ignores a node_modules which fails the integrity check", func() {
+			checkErr = errors.New("exit status 1")
+			Expect(finalizer.InstallVendoredNodePackages()).To(Succeed())
+			Expect(installs).To(BeEmpty())
+			Expect(buffer.String()).To(ContainSubstring("The vendored node_modules does not match yarn.lock"))
+		})
+	})
+})
@@ -0,0 +1,161 @@
+// Package freshness tells how old a buildpack is. Packaging stamps the build
+// date into manifest.yml, and a snapshot of the newest upstream release of
+// each version line is shipped next to it, so a buildpack forked and never
+// rebuilt can point out the rubies it is behind on without reaching the
+// network.
+package freshness
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ReleasesFile is the snapshot, relative to the buildpack directory
+const ReleasesFile = "upstream_releases.yml"
+
+// BuildDate is the build_date of the manifest in bpDir, ok is false for a
+// buildpack which was not packaged, such as one pushed from a git checkout
+func BuildDate(bpDir string) (date time.Time, ok bool, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(bpDir, "manifest.yml"))
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	manifest := struct {
+		BuildDate string `yaml:"build_date"`
+	}{}
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return time.Time{}, false, err
+	} else if manifest.BuildDate == "" {
+		return time.Time{}, false, nil
+	}
+
+	date, err = time.Parse("2006-01-02", manifest.BuildDate)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("build_date must be a YYYY-MM-DD date, got %s", manifest.BuildDate)
+	}
+	return date, true, nil
+}
+
+// Releases are the newest upstream versions of each line of the
+// dependencies, as of Date
+type Releases struct {
+	Date     string              `yaml:"date"`
+	Releases map[string][]string `yaml:"releases"`
+}
+
+// LoadReleases reads the snapshot at path, nil is returned when the
+// buildpack does not ship one
+func LoadReleases(path string) (*Releases, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	releases := &Releases{}
+	if err := yaml.UnmarshalStrict(data, releases); err != nil {
+		return nil, fmt.Errorf("%s is invalid: %v", ReleasesFile, err)
+	}
+	return releases, nil
+}
+
+// Line is a version line, such as ruby 2.5.x, with a newer upstream release
+// than the buildpack ships. Shipped is empty for a line newer than any the
+// buildpack has.
+type Line struct {
+	Name     string
+	Line     string
+	Shipped  string
+	Upstream string
+}
+
+func (l Line) String() string {
+	if l.Shipped == "" {
+		return fmt.Sprintf("%s %s is not shipped, upstream has %s", l.Name, l.Line, l.Upstream)
+	}
+	return fmt.Sprintf("%s %s ships %s, upstream has %s", l.Name, l.Line, l.Shipped, l.Upstream)
+}
+
+// Behind compares the versions the manifest ships with the snapshot. Lines
+// older than every shipped one were dropped on purpose and are left out.
+func (r *Releases) Behind(shipped func(name string) []string) []Line {
+	var names []string
+	for name := range r.Releases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []Line
+	for _, name := range names {
+		newest := map[string]string{}
+		oldestLine := ""
+		for _, version := range shipped(name) {
+			line := lineOf(version)
+			if newest[line] == "" || compare(version, newest[line]) > 0 {
+				newest[line] = version
+			}
+			if oldestLine == "" || compare(line, oldestLine) < 0 {
+				oldestLine = line
+			}
+		}
+		if oldestLine == "" {
+			continue
+		}
+
+		upstream := append([]string{}, r.Releases[name]...)
+		sort.Slice(upstream, func(i, j int) bool { return compare(upstream[i], upstream[j]) > 0 })
+		seen := map[string]bool{}
+		for _, version := range upstream {
+			line := lineOf(version)
+			if seen[line] || compare(line, oldestLine) < 0 {
+				continue
+			}
+			seen[line] = true
+			if newest[line] == "" || compare(version, newest[line]) > 0 {
+				lines = append(lines, Line{Name: name, Line: line + ".x", Shipped: newest[line], Upstream: version})
+			}
+		}
+	}
+	return lines
+}
+
+// lineOf is the major and minor version, 9.2.0.0 is on line 9.2
+func lineOf(version string) string {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// compare orders versions a numeric segment at a time, a missing segment
+// counts as 0
+func compare(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
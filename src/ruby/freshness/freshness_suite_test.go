@@ -0,0 +1,13 @@
+package freshness_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestFreshness(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Freshness Suite")
+}
@@ -0,0 +1,86 @@
+package freshness_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/freshness"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Freshness", func() {
+	var bpDir string
+
+	BeforeEach(func() {
+		var err error
+		bpDir, err = ioutil.TempDir("", "ruby-buildpack.freshness.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(bpDir)).To(Succeed())
+	})
+
+	Describe("BuildDate", func() {
+		It("reads build_date from the manifest", func() {
+			Expect(ioutil.WriteFile(filepath.Join(bpDir, "manifest.yml"), []byte("---\nlanguage: ruby\nbuild_date: 2018-07-20\n"), 0644)).To(Succeed())
+			date, ok, err := freshness.BuildDate(bpDir)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeTrue())
+			Expect(date).To(Equal(time.Date(2018, 7, 20, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("is not ok for a buildpack which was not packaged", func() {
+			Expect(ioutil.WriteFile(filepath.Join(bpDir, "manifest.yml"), []byte("---\nlanguage: ruby\n"), 0644)).To(Succeed())
+			_, ok, err := freshness.BuildDate(bpDir)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeFalse())
+		})
+
+		It("rejects a date in another format", func() {
+			Expect(ioutil.WriteFile(filepath.Join(bpDir, "manifest.yml"), []byte("---\nbuild_date: 20 July 2018\n"), 0644)).To(Succeed())
+			_, _, err := freshness.BuildDate(bpDir)
+			Expect(err).To(MatchError("build_date must be a YYYY-MM-DD date, got 20 July 2018"))
+		})
+	})
+
+	Describe("LoadReleases", func() {
+		It("returns nil without a snapshot", func() {
+			Expect(freshness.LoadReleases(filepath.Join(bpDir, freshness.ReleasesFile))).To(BeNil())
+		})
+
+		It("rejects unknown keys", func() {
+			Expect(ioutil.WriteFile(filepath.Join(bpDir, freshness.ReleasesFile), []byte("date: 2018-07-20\nrubies: []\n"), 0644)).To(Succeed())
+			_, err := freshness.LoadReleases(filepath.Join(bpDir, freshness.ReleasesFile))
+			Expect(err).To(MatchError(ContainSubstring("upstream_releases.yml is invalid")))
+		})
+	})
+
+	Describe("Behind", func() {
+		shipped := map[string][]string{
+			"ruby":  {"2.4.3", "2.4.4", "2.5.0", "2.5.1"},
+			"jruby": {"9.1.17.0", "9.2.0.0"},
+			"yarn":  {"1.9.2"},
+		}
+		releases := &freshness.Releases{Releases: map[string][]string{
+			"ruby":  {"2.3.8", "2.4.4", "2.5.2", "2.5.3", "2.6.0"},
+			"jruby": {"9.2.10.0"},
+			"yarn":  {"1.9.2"},
+			"node":  {"10.15.0"},
+		}}
+
+		It("lists the lines with newer upstream releases", func() {
+			lines := releases.Behind(func(name string) []string { return shipped[name] })
+			Expect(lines).To(Equal([]freshness.Line{
+				{Name: "jruby", Line: "9.2.x", Shipped: "9.2.0.0", Upstream: "9.2.10.0"},
+				{Name: "ruby", Line: "2.6.x", Upstream: "2.6.0"},
+				{Name: "ruby", Line: "2.5.x", Shipped: "2.5.1", Upstream: "2.5.3"},
+			}))
+			Expect(lines[1].String()).To(Equal("ruby 2.6.x is not shipped, upstream has 2.6.0"))
+			Expect(lines[2].String()).To(Equal("ruby 2.5.x ships 2.5.1, upstream has 2.5.3"))
+		})
+	})
+})
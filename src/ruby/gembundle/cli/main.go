@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"ruby/gembundle"
+)
+
+func main() {
+	manifest := flag.String("manifest", "", "manifest.yml whose include_files the bundle is added to by index")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gembundle [-manifest manifest.yml] index|verify <dir>")
+		fmt.Fprintln(os.Stderr, "index writes the index of the .gem files in <dir>/gems and the prebuilt gems in <dir>/prebuilt/<stack>/<engine>-<ruby version>")
+		fmt.Fprintln(os.Stderr, "verify checks the files of the bundle in <dir> against their sha256 in its index")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := flag.Arg(1)
+
+	switch flag.Arg(0) {
+	case "index":
+		bundle, err := gembundle.Build(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to index %s: %s\n", dir, err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Indexed %d gems and %d prebuilt gems\n", len(bundle.Index.Gems), len(bundle.Index.Prebuilt))
+		if *manifest != "" {
+			if err := bundle.AddToManifest(*manifest); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to add the bundle to %s: %s\n", *manifest, err.Error())
+				os.Exit(1)
+			}
+		}
+	case "verify":
+		bundle, err := gembundle.Load(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load %s: %s\n", dir, err.Error())
+			os.Exit(1)
+		} else if bundle == nil {
+			fmt.Fprintf(os.Stderr, "%s has no %s\n", dir, gembundle.IndexFile)
+			os.Exit(1)
+		}
+		if err := bundle.Verify(); err != nil {
+			fmt.Fprintf(os.Stderr, "The gem bundle is corrupt: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Verified %d gems and %d prebuilt gems\n", len(bundle.Index.Gems), len(bundle.Index.Prebuilt))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
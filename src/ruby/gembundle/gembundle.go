@@ -0,0 +1,241 @@
+// Package gembundle reads the curated gems a cached buildpack can be
+// packaged with. Their .gem files are put in the app's vendor/cache, where
+// bundler looks before the gem source, and their prebuilt native builds are
+// installed before bundler runs, as from BP_PREBUILT_GEM_CACHE. Staging
+// apps using popular gems then needs little from the network.
+package gembundle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/gemsource"
+	"ruby/prebuilt"
+	"ruby/report"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// Dir is the gem bundle in the buildpack
+	Dir = "gem_bundle"
+	// IndexFile lists the files of the bundle with their sha256
+	IndexFile = "index.yml"
+)
+
+// Entry is a file of the bundle, relative to its directory. Gems are
+// gems/<name>-<version>.gem, prebuilt gems are
+// prebuilt/<stack>/<engine>-<ruby version>/<name>-<version>.tgz in the
+// layout of a BP_PREBUILT_GEM_CACHE.
+type Entry struct {
+	File   string `yaml:"file"`
+	SHA256 string `yaml:"sha256"`
+}
+
+type Index struct {
+	Gems     []Entry `yaml:"gems"`
+	Prebuilt []Entry `yaml:"prebuilt"`
+}
+
+type Bundle struct {
+	Dir    string
+	Index  Index
+	hashes map[string]string
+}
+
+// Load reads the bundle in dir, there is none when dir has no index
+func Load(dir string) (*Bundle, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, IndexFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{Dir: dir}
+	if err := yaml.Unmarshal(data, &bundle.Index); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", IndexFile, err)
+	}
+	bundle.index()
+	return bundle, nil
+}
+
+// Build indexes the gems and prebuilt gems in dir and writes the index
+func Build(dir string) (*Bundle, error) {
+	bundle := &Bundle{Dir: dir}
+	for _, kind := range []struct {
+		glob    string
+		entries *[]Entry
+	}{
+		{filepath.Join("gems", "*.gem"), &bundle.Index.Gems},
+		{filepath.Join("prebuilt", "*", "*", "*.tgz"), &bundle.Index.Prebuilt},
+	} {
+		files, err := filepath.Glob(filepath.Join(dir, kind.glob))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			sum, err := report.HashFile(file)
+			if err != nil {
+				return nil, err
+			}
+			rel, err := filepath.Rel(dir, file)
+			if err != nil {
+				return nil, err
+			}
+			*kind.entries = append(*kind.entries, Entry{File: filepath.ToSlash(rel), SHA256: sum})
+		}
+	}
+
+	data, err := yaml.Marshal(bundle.Index)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, IndexFile), data, 0644); err != nil {
+		return nil, err
+	}
+	bundle.index()
+	return bundle, nil
+}
+
+func (b *Bundle) index() {
+	b.hashes = map[string]string{}
+	for _, entry := range append(append([]Entry{}, b.Index.Gems...), b.Index.Prebuilt...) {
+		b.hashes[entry.File] = entry.SHA256
+	}
+}
+
+// Files are the paths of the index and every file it lists, relative to
+// the directory of the bundle
+func (b *Bundle) Files() []string {
+	files := []string{IndexFile}
+	for _, entry := range append(append([]Entry{}, b.Index.Gems...), b.Index.Prebuilt...) {
+		files = append(files, entry.File)
+	}
+	return files
+}
+
+// Verify checks every file of the bundle against its sha256
+func (b *Bundle) Verify() error {
+	var problems []string
+	for _, file := range b.Files()[1:] {
+		if err := b.verify(file); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+func (b *Bundle) verify(file string) error {
+	sum, err := report.HashFile(filepath.Join(b.Dir, filepath.FromSlash(file)))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s is missing", file)
+	} else if err != nil {
+		return err
+	}
+	if sum != b.hashes[file] {
+		return fmt.Errorf("%s has sha256 %s, %s has %s", file, sum, IndexFile, b.hashes[file])
+	}
+	return nil
+}
+
+// CopyGems copies the .gem files of the locked gems the bundle has into
+// cacheDir and returns the gems copied. Gems already in cacheDir are left
+// alone, gems failing verification are skipped with an error.
+func (b *Bundle) CopyGems(gems []gemsource.Gem, cacheDir string) ([]gemsource.Gem, error) {
+	var copied []gemsource.Gem
+	var problems []string
+	for _, gem := range gems {
+		file := "gems/" + gem.String() + ".gem"
+		if _, found := b.hashes[file]; !found {
+			continue
+		}
+		target := filepath.Join(cacheDir, gem.String()+".gem")
+		if exists, err := libbuildpack.FileExists(target); err != nil {
+			return copied, err
+		} else if exists {
+			continue
+		}
+
+		if err := b.verify(file); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if err := os.MkdirAll(cacheDir, 0755); err != nil {
+			return copied, err
+		}
+		if err := libbuildpack.CopyFile(filepath.Join(b.Dir, filepath.FromSlash(file)), target); err != nil {
+			return copied, err
+		}
+		copied = append(copied, gem)
+	}
+	if len(problems) > 0 {
+		return copied, fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+	return copied, nil
+}
+
+// PrebuiltFile is where the bundle keeps the build of gem for stack and ruby
+func PrebuiltFile(stack, ruby string, gem prebuilt.Gem) string {
+	return fmt.Sprintf("prebuilt/%s/%s/%s.tgz", stack, ruby, gem)
+}
+
+// InstallPrebuilt extracts the prebuilt gem into bundleDir, it returns false
+// when the bundle has no build of it
+func (b *Bundle) InstallPrebuilt(stack, ruby string, gem prebuilt.Gem, bundleDir string) (bool, error) {
+	file := PrebuiltFile(stack, ruby, gem)
+	if _, found := b.hashes[file]; !found {
+		return false, nil
+	}
+	if err := b.verify(file); err != nil {
+		return false, err
+	}
+	if err := libbuildpack.ExtractTarGz(filepath.Join(b.Dir, filepath.FromSlash(file)), bundleDir); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// AddToManifest lists the files of the bundle in the include_files of the
+// buildpack's manifest.yml, which the packager copies into the buildpack.
+// The manifest is edited as text to keep its comments and layout.
+func (b *Bundle) AddToManifest(manifest string) error {
+	data, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+
+	start := -1
+	for i, line := range lines {
+		if line == "include_files:" {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return fmt.Errorf("%s has no include_files", manifest)
+	}
+	end := start + 1
+	included := map[string]bool{}
+	for ; end < len(lines) && strings.HasPrefix(lines[end], "- "); end++ {
+		included[strings.TrimPrefix(lines[end], "- ")] = true
+	}
+
+	var added []string
+	for _, file := range b.Files() {
+		if file = Dir + "/" + file; !included[file] {
+			added = append(added, "- "+file)
+		}
+	}
+	lines = append(lines[:end], append(added, lines[end:]...)...)
+	return ioutil.WriteFile(manifest, []byte(strings.Join(lines, "\n")), 0644)
+}
@@ -0,0 +1,13 @@
+package gembundle_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestGembundle(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Gembundle Suite")
+}
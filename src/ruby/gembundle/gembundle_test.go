@@ -0,0 +1,165 @@
+package gembundle_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/gembundle"
+	"ruby/gemsource"
+	"ruby/prebuilt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Gembundle", func() {
+	var (
+		err error
+		dir string
+	)
+
+	writeFile := func(name, contents string) {
+		path := filepath.Join(dir, name)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	writeTgz := func(name string, files map[string]string) {
+		path := filepath.Join(dir, name)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		file, err := os.Create(path)
+		Expect(err).ToNot(HaveOccurred())
+		defer file.Close()
+		gz := gzip.NewWriter(file)
+		tw := tar.NewWriter(gz)
+		for name, contents := range files {
+			Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))})).To(Succeed())
+			_, err := tw.Write([]byte(contents))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(tw.Close()).To(Succeed())
+		Expect(gz.Close()).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "ruby-buildpack.gem_bundle.")
+		Expect(err).To(BeNil())
+		writeFile("gems/rack-2.0.3.gem", "rack package")
+		writeFile("gems/nokogiri-1.8.2-x86_64-linux.gem", "nokogiri package")
+		writeTgz("prebuilt/cflinuxfs3/ruby-2.5.1/bcrypt-3.1.11.tgz", map[string]string{"specifications/bcrypt-3.1.11.gemspec": "spec"})
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	Describe("Build", func() {
+		It("indexes the gems and prebuilt gems with their sha256", func() {
+			bundle, err := gembundle.Build(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bundle.Files()).To(Equal([]string{
+				"index.yml",
+				"gems/nokogiri-1.8.2-x86_64-linux.gem",
+				"gems/rack-2.0.3.gem",
+				"prebuilt/cflinuxfs3/ruby-2.5.1/bcrypt-3.1.11.tgz",
+			}))
+			Expect(bundle.Index.Gems[1]).To(Equal(gembundle.Entry{File: "gems/rack-2.0.3.gem", SHA256: "8e63e78b5db91307491cc7a480844fcd91ffd3f8b835f4e4e4855792e3613237"}))
+
+			loaded, err := gembundle.Load(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Index).To(Equal(bundle.Index))
+			Expect(loaded.Verify()).To(Succeed())
+		})
+	})
+
+	Describe("Load", func() {
+		It("returns no bundle without an index", func() {
+			Expect(gembundle.Load(dir)).To(BeNil())
+		})
+	})
+
+	Describe("Verify", func() {
+		It("finds changed and missing files", func() {
+			bundle, err := gembundle.Build(dir)
+			Expect(err).ToNot(HaveOccurred())
+			writeFile("gems/rack-2.0.3.gem", "tampered")
+			Expect(os.Remove(filepath.Join(dir, "gems", "nokogiri-1.8.2-x86_64-linux.gem"))).To(Succeed())
+
+			err = bundle.Verify()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("gems/nokogiri-1.8.2-x86_64-linux.gem is missing"))
+			Expect(err.Error()).To(MatchRegexp(`gems/rack-2.0.3.gem has sha256 [0-9a-f]{64}, index.yml has [0-9a-f]{64}`))
+		})
+	})
+
+	Describe("CopyGems", func() {
+		var cacheDir string
+
+		BeforeEach(func() {
+			cacheDir = filepath.Join(dir, "app", "vendor", "cache")
+		})
+
+		It("copies the locked gems the bundle has", func() {
+			bundle, err := gembundle.Build(dir)
+			Expect(err).ToNot(HaveOccurred())
+
+			copied, err := bundle.CopyGems([]gemsource.Gem{
+				{Name: "rack", Version: "2.0.3"},
+				{Name: "nokogiri", Version: "1.8.2", Platform: "x86_64-linux"},
+				{Name: "sinatra", Version: "2.0.1"},
+			}, cacheDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(copied).To(HaveLen(2))
+			Expect(ioutil.ReadFile(filepath.Join(cacheDir, "rack-2.0.3.gem"))).To(Equal([]byte("rack package")))
+			Expect(filepath.Join(cacheDir, "nokogiri-1.8.2-x86_64-linux.gem")).To(BeAnExistingFile())
+		})
+
+		It("leaves the app's own cached gems alone and skips corrupt ones", func() {
+			bundle, err := gembundle.Build(dir)
+			Expect(err).ToNot(HaveOccurred())
+			writeFile("app/vendor/cache/rack-2.0.3.gem", "the app's rack")
+			writeFile("gems/nokogiri-1.8.2-x86_64-linux.gem", "tampered")
+
+			copied, err := bundle.CopyGems([]gemsource.Gem{
+				{Name: "rack", Version: "2.0.3"},
+				{Name: "nokogiri", Version: "1.8.2", Platform: "x86_64-linux"},
+			}, cacheDir)
+			Expect(err).To(MatchError(ContainSubstring("gems/nokogiri-1.8.2-x86_64-linux.gem has sha256")))
+			Expect(copied).To(BeEmpty())
+			Expect(ioutil.ReadFile(filepath.Join(cacheDir, "rack-2.0.3.gem"))).To(Equal([]byte("the app's rack")))
+			Expect(filepath.Join(cacheDir, "nokogiri-1.8.2-x86_64-linux.gem")).ToNot(BeAnExistingFile())
+		})
+	})
+
+	Describe("InstallPrebuilt", func() {
+		It("extracts the prebuilt gem for the stack and ruby", func() {
+			bundle, err := gembundle.Build(dir)
+			Expect(err).ToNot(HaveOccurred())
+			bundleDir := filepath.Join(dir, "vendor_bundle")
+
+			Expect(bundle.InstallPrebuilt("cflinuxfs3", "ruby-2.4.4", prebuilt.Gem{Name: "bcrypt", Version: "3.1.11"}, bundleDir)).To(BeFalse())
+			Expect(bundle.InstallPrebuilt("cflinuxfs3", "ruby-2.5.1", prebuilt.Gem{Name: "bcrypt", Version: "3.1.11"}, bundleDir)).To(BeTrue())
+			Expect(ioutil.ReadFile(filepath.Join(bundleDir, "specifications", "bcrypt-3.1.11.gemspec"))).To(Equal([]byte("spec")))
+		})
+	})
+
+	Describe("AddToManifest", func() {
+		It("adds the files of the bundle to include_files once", func() {
+			bundle, err := gembundle.Build(dir)
+			Expect(err).ToNot(HaveOccurred())
+			manifest := filepath.Join(dir, "manifest.yml")
+			Expect(ioutil.WriteFile(manifest, []byte("language: ruby\ninclude_files:\n- README.md\n- manifest.yml\npre_package: scripts/build.sh\n"), 0644)).To(Succeed())
+
+			Expect(bundle.AddToManifest(manifest)).To(Succeed())
+			Expect(bundle.AddToManifest(manifest)).To(Succeed())
+			Expect(ioutil.ReadFile(manifest)).To(Equal([]byte("language: ruby\ninclude_files:\n- README.md\n- manifest.yml\n" +
+				"- gem_bundle/index.yml\n" +
+				"- gem_bundle/gems/nokogiri-1.8.2-x86_64-linux.gem\n" +
+				"- gem_bundle/gems/rack-2.0.3.gem\n" +
+				"- gem_bundle/prebuilt/cflinuxfs3/ruby-2.5.1/bcrypt-3.1.11.tgz\n" +
+				"pre_package: scripts/build.sh\n")))
+		})
+	})
+})
@@ -0,0 +1,264 @@
+package gemsource
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"ruby/lockfile"
+	"ruby/report"
+	"strings"
+	"time"
+)
+
+// Timeout bounds each download from a fallback source, so a mirror which
+// stops answering fails the gem instead of hanging staging
+const Timeout = 5 * time.Minute
+
+// gemfileSource matches source 'http://...' and the source: and :source =>
+// options of gem and git_source lines in a Gemfile
+var gemfileSource = regexp.MustCompile(`(?m)^[^#\n]*\b(?:source|:source\s*=>|source:)\s*\(?\s*['"](http://[^'"]+)['"]`)
+
+// Gem is a locked gem as rubygems names its .gem file, with its sha256 when
+// the Gemfile.lock has CHECKSUMS
+type Gem struct {
+	Name     string
+	Version  string
+	Platform string
+	SHA256   string
+}
+
+func (g Gem) String() string {
+	if g.Platform == "" {
+		return g.Name + "-" + g.Version
+	}
+	return g.Name + "-" + g.Version + "-" + g.Platform
+}
+
+// Fallback fetches .gem files from mirrors of the app's gem source. Each gem
+// is tried against the sources in order, so a source which fails for one gem
+// is still tried for the next.
+type Fallback struct {
+	Sources []string
+	Client  *http.Client
+}
+
+// New parses a comma separated list of source URLs
+func New(sources string) *Fallback {
+	f := &Fallback{Client: &http.Client{Timeout: Timeout}}
+	for _, source := range strings.Split(sources, ",") {
+		if source = strings.TrimSpace(source); source != "" {
+			f.Sources = append(f.Sources, strings.TrimSuffix(source, "/"))
+		}
+	}
+	return f
+}
+
+// LockedGems returns the rubygems sourced gems in a Gemfile.lock which
+// bundler installs on linux, the x86_64-linux build of a gem is preferred
+// over the ruby one. Gems the lock has CHECKSUMS for carry their sha256.
+func LockedGems(gemfileLock string) ([]Gem, error) {
+	lock, err := lockfile.ParseInstallable(gemfileLock)
+	if err != nil {
+		return nil, err
+	}
+
+	sums := map[string]string{}
+	for _, c := range lock.Checksums {
+		sums[c.String()] = c.SHA256
+	}
+
+	var names []string
+	gems := map[string]Gem{}
+	for _, spec := range lock.Specs {
+		if spec.Source.Type != "GEM" {
+			continue
+		}
+		linux := strings.HasPrefix(spec.Platform, "x86_64-linux")
+		if spec.Platform != "" && !linux {
+			continue
+		}
+		if _, found := gems[spec.Name]; !found {
+			names = append(names, spec.Name)
+		} else if !linux {
+			continue
+		}
+		gem := Gem{Name: spec.Name, Version: spec.Version, Platform: spec.Platform}
+		gem.SHA256 = sums[gem.String()]
+		gems[spec.Name] = gem
+	}
+
+	var locked []Gem
+	for _, name := range names {
+		locked = append(locked, gems[name])
+	}
+	return locked, nil
+}
+
+// Fetch downloads the gem into dir from the first source which has it,
+// returning that source with any credentials removed. A gem with a sha256 is
+// only kept when the download matches it, a mirror serving something else is
+// treated like one which does not have the gem.
+func (f *Fallback) Fetch(gem Gem, dir string) (string, error) {
+	var failures []string
+	for _, source := range f.Sources {
+		if err := f.fetchFrom(source, gem, dir); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", Display(source), err))
+			continue
+		}
+		return Display(source), nil
+	}
+	return "", fmt.Errorf("no fallback source has %s (%s)", gem, strings.Join(failures, "; "))
+}
+
+func (f *Fallback) fetchFrom(source string, gem Gem, dir string) error {
+	resp, err := f.Client.Get(fmt.Sprintf("%s/gems/%s.gem", source, gem))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so a dropped connection does not leave
+	// a truncated gem for bundler to install
+	file, err := ioutil.TempFile(dir, ".fetch")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), resp.Body); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); gem.SHA256 != "" && !strings.EqualFold(sum, gem.SHA256) {
+		return fmt.Errorf("sha256 %s does not match Gemfile.lock CHECKSUMS %s", sum, gem.SHA256)
+	}
+	return os.Rename(file.Name(), filepath.Join(dir, gem.String()+".gem"))
+}
+
+// Display returns source without the user info, for logging
+func Display(source string) string {
+	u, err := url.Parse(source)
+	if err != nil || u.User == nil {
+		return source
+	}
+	u.User = nil
+	return u.String()
+}
+
+// Insecure is a gem source fetched over plain http, anyone on the network
+// between staging and the source can replace the gems it serves
+type Insecure struct {
+	File   string `json:"file"`
+	Source string `json:"source"`
+}
+
+func (i Insecure) String() string {
+	return fmt.Sprintf("%s in %s", i.Source, i.File)
+}
+
+// FindInsecure returns the http sources of the Gemfile and of the GEM and
+// GIT sections of its lock, missing files are skipped
+func FindInsecure(gemfile, gemfileLock string) ([]Insecure, error) {
+	var insecure []Insecure
+	seen := map[Insecure]bool{}
+	add := func(file, source string) {
+		found := Insecure{File: file, Source: Display(source)}
+		if !seen[found] {
+			seen[found] = true
+			insecure = append(insecure, found)
+		}
+	}
+
+	data, err := ioutil.ReadFile(gemfile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, match := range gemfileSource.FindAllStringSubmatch(string(data), -1) {
+		add(filepath.Base(gemfile), match[1])
+	}
+
+	data, err = ioutil.ReadFile(gemfileLock)
+	if os.IsNotExist(err) {
+		return insecure, nil
+	} else if err != nil {
+		return nil, err
+	}
+	lock, err := lockfile.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range lock.Sources {
+		if (source.Type == "GEM" || source.Type == "GIT") && strings.HasPrefix(strings.ToLower(source.Remote), "http://") {
+			add(filepath.Base(gemfileLock), source.Remote)
+		}
+	}
+	return insecure, nil
+}
+
+// Checksums returns the sha256 of the .gem files in dirs keyed by the full
+// name of the gem, bundler keeps the packages it installed in the cache
+// directory of the bundle path. Missing dirs are skipped.
+func Checksums(dirs ...string) (map[string]string, error) {
+	checksums := map[string]string{}
+	for _, dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*.gem"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			sum, err := report.HashFile(file)
+			if err != nil {
+				return nil, err
+			}
+			checksums[strings.TrimSuffix(filepath.Base(file), ".gem")] = sum
+		}
+	}
+	return checksums, nil
+}
+
+// Mismatch is an installed gem whose package differs from the one the app
+// locked, its source served something else than when the lock was written
+type Mismatch struct {
+	Gem    string
+	Locked string
+	Actual string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s has sha256 %s, Gemfile.lock CHECKSUMS has %s", m.Gem, m.Actual, m.Locked)
+}
+
+// VerifyChecksums compares the locked checksums with those of the installed
+// packages. Gems without a locked sha256 or without a package, such as git
+// gems and default gems, can not be compared and are skipped.
+func VerifyChecksums(locked []lockfile.Checksum, installed map[string]string) []Mismatch {
+	var mismatches []Mismatch
+	for _, c := range locked {
+		actual, found := installed[c.String()]
+		if c.SHA256 == "" || !found {
+			continue
+		}
+		if !strings.EqualFold(actual, c.SHA256) {
+			mismatches = append(mismatches, Mismatch{Gem: c.String(), Locked: c.SHA256, Actual: actual})
+		}
+	}
+	return mismatches
+}
@@ -0,0 +1,13 @@
+package gemsource_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestGemsource(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Gemsource Suite")
+}
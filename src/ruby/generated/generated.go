@@ -0,0 +1,110 @@
+// Package generated tracks the profile.d and env scripts the buildpack writes
+// into its dep dir, so a script an older buildpack wrote, but this one no
+// longer does, does not keep setting the environment of later stagings. The
+// dep dir starts out empty, the manifest of the scripts is kept in the app
+// cache dir next to the cache metadata.
+package generated
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const manifestFile = "generated_files.yml"
+
+// trackedDirs are the dep dir directories whose contents are sourced or
+// exported when the app stages or starts
+var trackedDirs = []string{"profile.d", "env"}
+
+type Manifest struct {
+	Files []string `yaml:"files"`
+}
+
+// Clean removes every file the previous staging recorded from depDir, the
+// buildpack writes the ones it still generates again during this staging
+func Clean(depDir, cacheDir string, log *libbuildpack.Logger) error {
+	manifest, err := load(cacheDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range manifest.Files {
+		if !tracked(file) {
+			log.Warning("Not removing %s, it is outside of %s", file, strings.Join(trackedDirs, " and "))
+			continue
+		}
+		log.Debug("Removing generated %s", file)
+		if err := os.Remove(filepath.Join(depDir, file)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record writes the manifest of the files in the tracked directories of
+// depDir to cacheDir, and reports the files the previous staging generated
+// which this one did not
+func Record(depDir, cacheDir string, log *libbuildpack.Logger) error {
+	previous, err := load(cacheDir)
+	if err != nil {
+		return err
+	}
+
+	current := Manifest{}
+	for _, dir := range trackedDirs {
+		files, err := ioutil.ReadDir(filepath.Join(depDir, dir))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if !file.IsDir() {
+				current.Files = append(current.Files, dir+"/"+file.Name())
+			}
+		}
+	}
+	sort.Strings(current.Files)
+
+	for _, file := range previous.Files {
+		if i := sort.SearchStrings(current.Files, file); i == len(current.Files) || current.Files[i] != file {
+			log.Info("Removed stale %s, it is no longer generated by this buildpack", file)
+		}
+	}
+
+	data, err := yaml.Marshal(current)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(cacheDir, manifestFile), data, 0644)
+}
+
+func load(cacheDir string) (Manifest, error) {
+	manifest := Manifest{}
+	data, err := ioutil.ReadFile(filepath.Join(cacheDir, manifestFile))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return manifest, err
+	}
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("could not parse %s: %v", manifestFile, err)
+	}
+	return manifest, nil
+}
+
+func tracked(file string) bool {
+	file = filepath.ToSlash(filepath.Clean(file))
+	for _, dir := range trackedDirs {
+		if strings.HasPrefix(file, dir+"/") && !strings.Contains(file[len(dir)+1:], "/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package generated_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/generated"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Generated", func() {
+	var (
+		err      error
+		depDir   string
+		cacheDir string
+		buffer   *bytes.Buffer
+		logger   *libbuildpack.Logger
+	)
+
+	BeforeEach(func() {
+		depDir, err = ioutil.TempDir("", "ruby-buildpack.dep.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "ruby-buildpack.cache.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depDir, "profile.d"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(depDir, "env"), 0755)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger = libbuildpack.NewLogger(ansicleaner.New(buffer))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(depDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	write := func(file string) {
+		Expect(ioutil.WriteFile(filepath.Join(depDir, file), []byte("export A=1"), 0644)).To(Succeed())
+	}
+
+	Context("an earlier staging generated a script this staging does not", func() {
+		BeforeEach(func() {
+			write("profile.d/ruby.sh")
+			write("profile.d/legacy.sh")
+			write("env/LD_LIBRARY_PATH")
+			Expect(generated.Record(depDir, cacheDir, logger)).To(Succeed())
+		})
+
+		It("removes the stale script", func() {
+			Expect(generated.Clean(depDir, cacheDir, logger)).To(Succeed())
+			Expect(filepath.Join(depDir, "profile.d", "legacy.sh")).ToNot(BeAnExistingFile())
+			Expect(filepath.Join(depDir, "env", "LD_LIBRARY_PATH")).ToNot(BeAnExistingFile())
+
+			write("profile.d/ruby.sh")
+			write("env/LD_LIBRARY_PATH")
+			Expect(generated.Record(depDir, cacheDir, logger)).To(Succeed())
+
+			Expect(filepath.Join(depDir, "profile.d", "ruby.sh")).To(BeAnExistingFile())
+			Expect(buffer.String()).To(ContainSubstring("Removed stale profile.d/legacy.sh, it is no longer generated by this buildpack"))
+			Expect(buffer.String()).ToNot(ContainSubstring("ruby.sh"))
+
+			manifest, err := ioutil.ReadFile(filepath.Join(cacheDir, "generated_files.yml"))
+			Expect(err).To(BeNil())
+			Expect(string(manifest)).To(Equal("files:\n- env/LD_LIBRARY_PATH\n- profile.d/ruby.sh\n"))
+		})
+	})
+
+	Context("there is no manifest", func() {
+		It("does not remove anything", func() {
+			write("profile.d/ruby.sh")
+			Expect(generated.Clean(depDir, cacheDir, logger)).To(Succeed())
+			Expect(filepath.Join(depDir, "profile.d", "ruby.sh")).To(BeAnExistingFile())
+		})
+	})
+
+	Context("the manifest lists files outside of profile.d and env", func() {
+		BeforeEach(func() {
+			write("Gemfile.lock")
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "generated_files.yml"), []byte("files:\n- Gemfile.lock\n- profile.d/../Gemfile.lock\n"), 0644)).To(Succeed())
+		})
+
+		It("does not remove them", func() {
+			Expect(generated.Clean(depDir, cacheDir, logger)).To(Succeed())
+			Expect(filepath.Join(depDir, "Gemfile.lock")).To(BeAnExistingFile())
+			Expect(buffer.String()).To(ContainSubstring("Not removing Gemfile.lock, it is outside of profile.d and env"))
+		})
+	})
+})
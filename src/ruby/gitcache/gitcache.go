@@ -0,0 +1,248 @@
+// Package gitcache keeps bundler's clones of git sourced gems in the app
+// cache. Bundler clones every git source in full below
+// <bundle path>/cache/bundler/git, which for a fork of rails takes minutes,
+// and that directory goes whenever vendor_bundle is not restored. Pointing
+// it at Dir, which is cached on its own, keeps the clones across stagings,
+// and clones made here only fetch the locked commit.
+package gitcache
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"ruby/depsdir"
+	"ruby/lockfile"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Dir is where the clones are kept, relative to the dep dir
+const Dir = depsdir.BundlerGit
+
+// lockedRef keeps the locked commit of a shallow clone reachable, so bundler
+// copies it when cloning the gem's checkout from the cache
+const lockedRef = "refs/heads/ruby-buildpack-locked"
+
+var (
+	fullRevision = regexp.MustCompile(`^[0-9a-f]{40}$`)
+	shaRef       = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+	schemeURI    = regexp.MustCompile(`^\w+://(\w+@)?`)
+	basePrefix   = regexp.MustCompile(`^(\w+://)?([^/:]+:)?(//\w*/)?(\w*/)*`)
+)
+
+type Command interface {
+	Execute(dir string, stdout io.Writer, stderr io.Writer, program string, args ...string) error
+}
+
+type Cache struct {
+	Dir     string
+	Command Command
+	Log     *libbuildpack.Logger
+}
+
+func New(depDir string, command Command, log *libbuildpack.Logger) *Cache {
+	return &Cache{Dir: filepath.Join(depDir, Dir), Command: command, Log: log}
+}
+
+// Sources returns the git sources of a Gemfile.lock
+func Sources(gemfileLock string) ([]*lockfile.Source, error) {
+	lock, err := lockfile.ParseFile(gemfileLock)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []*lockfile.Source
+	for _, source := range lock.Sources {
+		if source.Type == "GIT" && source.Remote != "" {
+			sources = append(sources, source)
+		}
+	}
+	return sources, nil
+}
+
+// Link points bundler's git cache in bundleDir at the cached clones. Clones
+// inside a vendor_bundle cached by an older buildpack are moved over.
+func (c *Cache) Link(bundleDir string) error {
+	link := filepath.Join(bundleDir, "cache", "bundler", "git")
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(link)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	} else if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+	} else if err == nil {
+		if exists, err := libbuildpack.FileExists(c.Dir); err != nil {
+			return err
+		} else if exists {
+			if err := os.RemoveAll(link); err != nil {
+				return err
+			}
+		} else if err := os.Rename(link, c.Dir); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	return os.Symlink(c.Dir, link)
+}
+
+// Unlink removes the clones and the links to them from the droplet once they
+// are cached, the app only loads bundler's checkouts of the gems
+func Unlink(depDir string) error {
+	links, err := depsdir.Dir(depDir).VendorBundles("cache", "bundler", "git")
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if info, err := os.Lstat(link); err != nil {
+			return err
+		} else if info.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(link); err != nil {
+				return err
+			}
+		}
+	}
+	return os.RemoveAll(filepath.Join(depDir, Dir))
+}
+
+// Fetch makes sure the clone of source holds its locked revision before
+// bundler looks for it. Only that commit is fetched, without its history,
+// and only into clones made here; a full clone made by bundler is left for
+// bundler to update. When Fetch fails bundler clones the source as before.
+func (c *Cache) Fetch(source *lockfile.Source) error {
+	scope, ok := Scope(source.Remote)
+	if !ok || !fullRevision.MatchString(source.Revision) {
+		c.Log.Debug("Leaving the clone of %s to bundler", source.Remote)
+		return nil
+	}
+	path := filepath.Join(c.Dir, scope)
+
+	created := false
+	if exists, err := libbuildpack.FileExists(path); err != nil {
+		return err
+	} else if !exists {
+		if err := c.git(c.Dir, "init", "--bare", "--quiet", path); err != nil {
+			return err
+		}
+		if err := c.git(path, "symbolic-ref", "HEAD", lockedRef); err != nil {
+			os.RemoveAll(path)
+			return err
+		}
+		created = true
+	} else if err := c.git(path, "cat-file", "-e", source.Revision+"^{commit}"); err == nil {
+		c.Log.Debug("Using the cached clone of %s at %s", source.Remote, source.Revision)
+		return nil
+	} else if shallow, err := libbuildpack.FileExists(filepath.Join(path, "shallow")); err != nil || !shallow {
+		return err
+	}
+
+	c.Log.Info("Fetching %s at %s", source.Remote, source.Revision[:12])
+	if err := c.git(path, "fetch", "--depth=1", "--quiet", source.Remote, "+"+source.Revision+":"+lockedRef); err != nil {
+		if created {
+			os.RemoveAll(path)
+		}
+		return err
+	}
+	return nil
+}
+
+// Checkout is bundler's checkout of source below bundleDir, named after the
+// remote and the first 12 characters of the locked revision
+func Checkout(bundleDir string, source *lockfile.Source) string {
+	revision := source.Revision
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	return filepath.Join(bundleDir, "bundler", "gems", baseName(source.Remote)+"-"+revision)
+}
+
+// Verify checks that bundler's checkout of a source pinned by SHA is at the
+// locked revision without changes, so an engine loaded by the app at runtime
+// is the code the Gemfile.lock names. A ref: given as a SHA must also be the
+// revision it is locked at. Sources locked to an abbreviated revision and
+// sources bundler did not check out, like those in BUNDLE_WITHOUT groups,
+// are not checked.
+func (c *Cache) Verify(source *lockfile.Source, bundleDir string) error {
+	if !fullRevision.MatchString(source.Revision) {
+		return nil
+	}
+	if shaRef.MatchString(source.Ref) && !strings.HasPrefix(source.Revision, source.Ref) {
+		return fmt.Errorf("%s locks ref %s at revision %s", source.Remote, source.Ref, source.Revision)
+	}
+
+	checkout := Checkout(bundleDir, source)
+	if exists, err := libbuildpack.FileExists(filepath.Join(checkout, ".git")); err != nil || !exists {
+		return err
+	}
+	head, err := c.gitOutput(checkout, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	if head != source.Revision {
+		return fmt.Errorf("the checkout of %s is at %s, not the locked revision %s", source.Remote, head, source.Revision)
+	}
+	changes, err := c.gitOutput(checkout, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return err
+	}
+	if changes != "" {
+		return fmt.Errorf("the checkout of %s at %s has changes: %s", source.Remote, source.Revision[:12], strings.Join(strings.Fields(changes), " "))
+	}
+	return nil
+}
+
+func (c *Cache) git(dir string, args ...string) error {
+	_, err := c.gitOutput(dir, args...)
+	return err
+}
+
+func (c *Cache) gitOutput(dir string, args ...string) (string, error) {
+	output, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := c.Command.Execute(dir, output, stderr, "git", args...); err != nil {
+		return "", fmt.Errorf("git %s: %v %s", args[0], err, strings.TrimSpace(output.String()+stderr.String()))
+	}
+	return strings.TrimSpace(output.String()), nil
+}
+
+// Scope is the directory bundler clones remote into, its base name and the
+// SHA1 of the remote with the scheme and host lower cased. ok is false for
+// remotes with a query or fragment, which bundler normalises differently.
+func Scope(remote string) (scope string, ok bool) {
+	input := remote
+	if schemeURI.MatchString(remote) {
+		if strings.ContainsAny(remote, "?#") {
+			return "", false
+		}
+		idx := strings.Index(remote, "://")
+		authority, path := remote[idx+3:], ""
+		if slash := strings.Index(authority, "/"); slash >= 0 {
+			authority, path = authority[:slash], authority[slash:]
+		}
+		if at := strings.LastIndex(authority, "@"); at >= 0 {
+			authority = authority[:at+1] + strings.ToLower(authority[at+1:])
+		} else {
+			authority = strings.ToLower(authority)
+		}
+		input = strings.TrimSuffix(strings.ToLower(remote[:idx])+"://"+authority+path, "/")
+	}
+
+	sum := sha1.Sum([]byte(input))
+	return baseName(remote) + "-" + hex.EncodeToString(sum[:]), true
+}
+
+func baseName(remote string) string {
+	return strings.TrimSuffix(filepath.Base(basePrefix.ReplaceAllString(remote, "")), ".git")
+}
@@ -0,0 +1,13 @@
+package gitcache_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestGitcache(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Gitcache Suite")
+}
@@ -0,0 +1,212 @@
+package gitcache_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/gitcache"
+	"ruby/lockfile"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Gitcache", func() {
+	var (
+		depDir string
+		buffer *bytes.Buffer
+		cache  *gitcache.Cache
+	)
+
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@example.com", "GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL=a@example.com")
+		output, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(output))
+		return strings.TrimSpace(string(output))
+	}
+
+	BeforeEach(func() {
+		var err error
+		depDir, err = ioutil.TempDir("", "ruby-buildpack.gitcache.")
+		Expect(err).To(BeNil())
+
+		buffer = new(bytes.Buffer)
+		cache = gitcache.New(depDir, &libbuildpack.Command{}, libbuildpack.NewLogger(ansicleaner.New(buffer)))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(depDir)).To(Succeed())
+	})
+
+	Describe("Scope", func() {
+		scope := func(remote string) string {
+			scope, ok := gitcache.Scope(remote)
+			Expect(ok).To(BeTrue())
+			return scope
+		}
+
+		It("names the clone like bundler", func() {
+			Expect(scope("https://github.com/rails/rails.git")).To(Equal("rails-fcf0202857b07db1a0f6220dae5ca99319ca0f32"))
+			Expect(scope("git@github.com:rails/rails.git")).To(Equal("rails-fad5bf2b0f9378b73eb20d353117ba7ec5bebc88"))
+		})
+
+		It("normalises the scheme, host and trailing slash", func() {
+			Expect(scope("HTTPS://GitHub.com/rails/rails/")).To(Equal("rails-7e96f5920135ce48fbfa67d410034f21afd79d7b"))
+		})
+
+		It("leaves remotes with a query to bundler", func() {
+			_, ok := gitcache.Scope("https://example.com/rails.git?token=abc")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("Sources", func() {
+		It("returns the git sources", func() {
+			Expect(ioutil.WriteFile(filepath.Join(depDir, "Gemfile.lock"), []byte("GIT\n  remote: https://github.com/rails/rails.git\n  revision: 0123456789abcdef0123456789abcdef01234567\n  specs:\n    rails (5.2.0)\n\nGEM\n  remote: https://rubygems.org/\n  specs:\n    rack (2.0.5)\n"), 0644)).To(Succeed())
+			sources, err := gitcache.Sources(filepath.Join(depDir, "Gemfile.lock"))
+			Expect(err).To(BeNil())
+			Expect(sources).To(HaveLen(1))
+			Expect(sources[0].Remote).To(Equal("https://github.com/rails/rails.git"))
+		})
+	})
+
+	Describe("Link and Unlink", func() {
+		var bundleDir string
+
+		BeforeEach(func() {
+			bundleDir = filepath.Join(depDir, "vendor_bundle", "ruby", "2.5.0")
+		})
+
+		It("points bundler's git cache at the cached clones", func() {
+			Expect(cache.Link(bundleDir)).To(Succeed())
+			Expect(os.Readlink(filepath.Join(bundleDir, "cache", "bundler", "git"))).To(Equal(filepath.Join(depDir, gitcache.Dir)))
+			Expect(cache.Link(bundleDir)).To(Succeed())
+		})
+
+		It("moves clones out of a vendor_bundle cached by an older buildpack", func() {
+			Expect(os.MkdirAll(filepath.Join(bundleDir, "cache", "bundler", "git", "rails-0123"), 0755)).To(Succeed())
+			Expect(cache.Link(bundleDir)).To(Succeed())
+			Expect(filepath.Join(depDir, gitcache.Dir, "rails-0123")).To(BeADirectory())
+		})
+
+		It("removes the clones from the droplet", func() {
+			Expect(cache.Link(bundleDir)).To(Succeed())
+			Expect(gitcache.Unlink(depDir)).To(Succeed())
+			Expect(filepath.Join(depDir, gitcache.Dir)).ToNot(BeAnExistingFile())
+			_, err := os.Lstat(filepath.Join(bundleDir, "cache", "bundler", "git"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Describe("Fetch", func() {
+		var (
+			remote string
+			source *lockfile.Source
+		)
+
+		BeforeEach(func() {
+			if _, err := exec.LookPath("git"); err != nil {
+				Skip("git is not installed")
+			}
+
+			remote = filepath.Join(depDir, "remote", "rails")
+			Expect(os.MkdirAll(remote, 0755)).To(Succeed())
+			git(remote, "init", "--quiet")
+			git(remote, "commit", "--quiet", "--allow-empty", "-m", "first")
+			git(remote, "commit", "--quiet", "--allow-empty", "-m", "second")
+			source = &lockfile.Source{Type: "GIT", Remote: "file://" + remote, Revision: git(remote, "rev-parse", "HEAD")}
+			Expect(os.MkdirAll(cache.Dir, 0755)).To(Succeed())
+		})
+
+		clone := func() string {
+			scope, _ := gitcache.Scope(source.Remote)
+			return filepath.Join(cache.Dir, scope)
+		}
+
+		It("fetches only the locked commit", func() {
+			Expect(cache.Fetch(source)).To(Succeed())
+			Expect(filepath.Join(clone(), "shallow")).To(BeAnExistingFile())
+			Expect(git(clone(), "rev-list", "--count", source.Revision)).To(Equal("1"))
+		})
+
+		It("can be cloned by bundler", func() {
+			Expect(cache.Fetch(source)).To(Succeed())
+			checkout := filepath.Join(depDir, "checkout")
+			git(depDir, "clone", "--no-checkout", "--quiet", clone(), checkout)
+			git(checkout, "reset", "--hard", source.Revision)
+		})
+
+		It("does not fetch a revision it already has", func() {
+			Expect(cache.Fetch(source)).To(Succeed())
+			Expect(os.RemoveAll(remote)).To(Succeed())
+			Expect(cache.Fetch(source)).To(Succeed())
+		})
+
+		It("removes the clone when the fetch fails", func() {
+			source.Revision = "0123456789abcdef0123456789abcdef01234567"
+			Expect(cache.Fetch(source)).ToNot(Succeed())
+			Expect(clone()).ToNot(BeAnExistingFile())
+		})
+	})
+
+	Describe("Verify", func() {
+		var (
+			bundleDir string
+			checkout  string
+			source    *lockfile.Source
+		)
+
+		BeforeEach(func() {
+			if _, err := exec.LookPath("git"); err != nil {
+				Skip("git is not installed")
+			}
+
+			remote := filepath.Join(depDir, "remote", "greeter.git")
+			Expect(os.MkdirAll(remote, 0755)).To(Succeed())
+			git(remote, "init", "--quiet")
+			Expect(ioutil.WriteFile(filepath.Join(remote, "greeter.rb"), []byte("module Greeter; end\n"), 0644)).To(Succeed())
+			git(remote, "add", "greeter.rb")
+			git(remote, "commit", "--quiet", "-m", "first")
+			source = &lockfile.Source{Type: "GIT", Remote: remote, Revision: git(remote, "rev-parse", "HEAD")}
+
+			bundleDir = filepath.Join(depDir, "vendor_bundle", "ruby", "2.5.0")
+			checkout = gitcache.Checkout(bundleDir, source)
+			Expect(checkout).To(Equal(filepath.Join(bundleDir, "bundler", "gems", "greeter-"+source.Revision[:12])))
+			Expect(os.MkdirAll(filepath.Dir(checkout), 0755)).To(Succeed())
+			git(depDir, "clone", "--quiet", remote, checkout)
+		})
+
+		It("passes a checkout at the locked revision", func() {
+			Expect(cache.Verify(source, bundleDir)).To(Succeed())
+		})
+
+		It("fails a checkout at another revision", func() {
+			git(checkout, "commit", "--quiet", "--allow-empty", "-m", "second")
+			Expect(cache.Verify(source, bundleDir)).To(MatchError(ContainSubstring("is at " + git(checkout, "rev-parse", "HEAD") + ", not the locked revision " + source.Revision)))
+		})
+
+		It("fails a checkout with changes", func() {
+			Expect(ioutil.WriteFile(filepath.Join(checkout, "greeter.rb"), []byte("module Greeter; VERSION = 2; end\n"), 0644)).To(Succeed())
+			Expect(cache.Verify(source, bundleDir)).To(MatchError(ContainSubstring("has changes: M greeter.rb")))
+		})
+
+		It("fails a SHA ref locked at another revision", func() {
+			source.Ref = "0123456"
+			Expect(cache.Verify(source, bundleDir)).To(MatchError(source.Remote + " locks ref 0123456 at revision " + source.Revision))
+			source.Ref = source.Revision[:7]
+			Expect(cache.Verify(source, bundleDir)).To(Succeed())
+		})
+
+		It("skips sources which are not checked out", func() {
+			Expect(os.RemoveAll(checkout)).To(Succeed())
+			Expect(cache.Verify(source, bundleDir)).To(Succeed())
+		})
+	})
+})
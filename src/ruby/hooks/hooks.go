@@ -0,0 +1,17 @@
+// Package hooks is where custom builds of the buildpack compile their
+// extensions in. Supply and finalize import it for its side effects, so a
+// file added here which registers an extension from its init function is
+// all a custom build needs:
+//
+//	package hooks
+//
+//	import "ruby/extensions"
+//
+//	func init() {
+//		extensions.Register(complianceScan{})
+//	}
+//
+// See extensions for the points of staging an extension can run at.
+// Hooks of libbuildpack, registered with libbuildpack.AddHook, keep running
+// before supply and after finalize.
+package hooks
@@ -0,0 +1,777 @@
+package installer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"ruby/provenance"
+	"ruby/workspace"
+
+	"github.com/Masterminds/semver"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// quarantineDir holds corrupt downloads moved out of the app cache, it is
+// next to the dependencies directory
+const quarantineDir = "quarantine"
+
+// bufferSize is how much of an archive is read, decompressed and written at
+// once. Reading ahead in blocks this large keeps the download, gzip and the
+// file writes busy at the same time.
+const bufferSize = 1024 * 1024
+
+// readAheadBlocks is how many decompressed blocks may wait for tar
+const readAheadBlocks = 8
+
+// DownloadTimeout bounds a whole download, the JDK on a slow mirror
+// included, so a stalled connection fails staging rather than hanging it
+const DownloadTimeout = 15 * time.Minute
+
+// DefaultProgressSize makes jruby and the JDK, which are over 100MB,
+// report their extraction progress
+const DefaultProgressSize = 64 * 1024 * 1024
+
+// Installer is a drop in replacement for libbuildpack.Installer which
+// hashes and extracts dependencies while they are downloaded, rather than
+// downloading to a temp file, hashing it and then extracting it
+type Installer struct {
+	manifest *libbuildpack.Manifest
+	log      *libbuildpack.Logger
+	Client   *http.Client
+	// Workspace holds the archives while they are extracted, until their
+	// checksum matches. Without one they are extracted next to outputDir.
+	Workspace *workspace.Workspace
+	// ProgressSize is the size from which archives log how far their
+	// extraction got, zero turns it off
+	ProgressSize    int64
+	appCacheDir     string
+	filesInAppCache map[string]bool
+	installed       []provenance.Dependency
+}
+
+func New(manifest *libbuildpack.Manifest, logger *libbuildpack.Logger) *Installer {
+	return &Installer{
+		manifest:        manifest,
+		log:             logger,
+		Client:          &http.Client{Timeout: DownloadTimeout},
+		ProgressSize:    DefaultProgressSize,
+		filesInAppCache: map[string]bool{},
+	}
+}
+
+// SetAppCacheDir caches downloads in appCacheDir. Downloads a staging was
+// stopped in the middle of are removed, nothing else would clean them up.
+func (i *Installer) SetAppCacheDir(appCacheDir string) (err error) {
+	if i.appCacheDir, err = filepath.Abs(filepath.Join(appCacheDir, "dependencies")); err != nil {
+		return err
+	}
+
+	partials, err := filepath.Glob(filepath.Join(i.appCacheDir, "*", "*.partial"))
+	if err != nil {
+		return err
+	}
+	for _, partial := range partials {
+		i.log.Debug("Deleting partial download: %s", partial)
+		if err := os.Remove(partial); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Installer) InstallOnlyVersion(depName string, installDir string) error {
+	depVersions := i.manifest.AllDependencyVersions(depName)
+
+	if len(depVersions) > 1 {
+		return fmt.Errorf("more than one version of %s found", depName)
+	} else if len(depVersions) == 0 {
+		return fmt.Errorf("no versions of %s found", depName)
+	}
+
+	return i.InstallDependency(libbuildpack.Dependency{Name: depName, Version: depVersions[0]}, installDir)
+}
+
+func (i *Installer) InstallDependency(dep libbuildpack.Dependency, outputDir string) error {
+	i.log.BeginStep("Installing %s %s", dep.Name, dep.Version)
+
+	entry, err := i.manifest.GetEntry(dep)
+	if err != nil {
+		return err
+	}
+	if err := i.installEntry(entry, outputDir); err != nil {
+		return err
+	}
+
+	if err := i.warnNewerPatch(dep); err != nil {
+		return err
+	}
+	if err := i.reportVersionLine(dep); err != nil {
+		return err
+	}
+	return i.warnEndOfLife(dep)
+}
+
+// InstallSideload installs a dependency the app declares itself, which is
+// not in the manifest. It is downloaded, cached and checked like the others.
+func (i *Installer) InstallSideload(dep libbuildpack.Dependency, uri, checksum, outputDir string) error {
+	if dep.Version == "" {
+		i.log.BeginStep("Installing %s", dep.Name)
+	} else {
+		i.log.BeginStep("Installing %s %s", dep.Name, dep.Version)
+	}
+	return i.installEntry(&libbuildpack.ManifestEntry{Dependency: dep, URI: uri, SHA256: checksum}, outputDir)
+}
+
+func (i *Installer) installEntry(entry *libbuildpack.ManifestEntry, outputDir string) error {
+	dep := entry.Dependency
+	source, cached, err := i.open(entry)
+	if err != nil {
+		return err
+	}
+	origin := provenance.FromDownload
+	if entry.File != "" {
+		origin = provenance.FromBuildpack
+	} else if cached {
+		origin = provenance.FromAppCache
+	}
+	if err := i.install(entry, source, outputDir); err != nil {
+		if !cached {
+			i.discard(entry)
+			return err
+		}
+		// A download cached by an earlier staging only fails like this when
+		// it was damaged since, staging goes on with a fresh download
+		i.log.Warning("The cached download of %s %s is corrupt, downloading it again: %v", dep.Name, dep.Version, err)
+		if err := i.quarantine(entry); err != nil {
+			return err
+		}
+		if source, err = i.download(entry); err != nil {
+			return err
+		}
+		if err := i.install(entry, source, outputDir); err != nil {
+			i.discard(entry)
+			return err
+		}
+		origin = provenance.FromDownload
+	}
+	i.record(entry, origin)
+	return nil
+}
+
+// record keeps the provenance of an installed dependency
+func (i *Installer) record(entry *libbuildpack.ManifestEntry, origin string) {
+	buildpackVersion, _ := i.manifest.Version()
+	i.installed = append(i.installed, provenance.Dependency{
+		Name:             entry.Dependency.Name,
+		Version:          entry.Dependency.Version,
+		URI:              filterURI(entry.URI),
+		SHA256:           entry.SHA256,
+		Origin:           origin,
+		BuildpackVersion: buildpackVersion,
+		InstalledAt:      time.Now().UTC(),
+	})
+}
+
+// Reused records the provenance of a dependency staging reuses from the
+// app cache instead of installing it
+func (i *Installer) Reused(dep libbuildpack.Dependency) error {
+	entry, err := i.manifest.GetEntry(dep)
+	if err != nil {
+		return err
+	}
+	i.record(entry, provenance.FromAppCache)
+	return nil
+}
+
+// Installed lists the provenance of the dependencies installed so far
+func (i *Installer) Installed() []provenance.Dependency {
+	return i.installed
+}
+
+// install extracts source into a directory of the workspace and closes
+// it. Only once the checksum matches is what was extracted moved into
+// outputDir, which is left as it was when the install fails.
+func (i *Installer) install(entry *libbuildpack.ManifestEntry, source io.ReadCloser, outputDir string) error {
+	defer source.Close()
+	staging, err := i.stagingDir(outputDir)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	extracted := filepath.Join(staging, "extracted")
+
+	hash := sha256.New()
+	body := io.TeeReader(source, hash)
+	if size := sizeOf(source); i.ProgressSize > 0 && size >= i.ProgressSize {
+		body = &progressReader{Reader: body, size: size, name: entry.Dependency.Name + " " + entry.Dependency.Version, log: i.log}
+	}
+	if err := unpack(entry.URI, body, extracted); err != nil {
+		return err
+	}
+	// The archive may end before the body does, the whole body has to be
+	// read for the checksum to cover it
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		return err
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if actual != entry.SHA256 {
+		return fmt.Errorf("dependency sha256 mismatch: expected sha256 %s, actual sha256 %s", entry.SHA256, actual)
+	}
+	if err := source.Close(); err != nil {
+		return err
+	}
+	return MoveInto(extracted, outputDir)
+}
+
+// stagingDir is where an archive for outputDir is extracted until its
+// checksum is known
+func (i *Installer) stagingDir(outputDir string) (string, error) {
+	if i.Workspace != nil {
+		return i.Workspace.Dir("install")
+	}
+	if err := os.MkdirAll(filepath.Dir(outputDir), 0755); err != nil {
+		return "", err
+	}
+	return ioutil.TempDir(filepath.Dir(outputDir), ".install")
+}
+
+// MoveInto moves src to dest. A directory is merged into one which is
+// already there, the files of src replacing those of dest. The workspace
+// may be on another filesystem than dest, then src is copied.
+func MoveInto(src, dest string) error {
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	destInfo, err := os.Lstat(dest)
+	if err == nil && srcInfo.IsDir() && destInfo.IsDir() {
+		entries, err := ioutil.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := MoveInto(filepath.Join(src, entry.Name()), filepath.Join(dest, entry.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+	} else if err == nil {
+		if err := os.RemoveAll(dest); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	err = os.Rename(src, dest)
+	if linkErr, ok := err.(*os.LinkError); !ok || linkErr.Err != syscall.EXDEV {
+		return err
+	}
+	if srcInfo.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dest)
+	} else if srcInfo.IsDir() {
+		if err := os.MkdirAll(dest, srcInfo.Mode()); err != nil {
+			return err
+		}
+		return libbuildpack.CopyDirectory(src, dest)
+	}
+	return libbuildpack.CopyFile(src, dest)
+}
+
+func (i *Installer) CleanupAppCache() error {
+	pathsToDelete := []string{}
+
+	if err := filepath.Walk(i.appCacheDir, func(path string, info os.FileInfo, err error) error {
+		if info == nil || info.IsDir() {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Failed while cleaning up app cache; couldn't look at %s because: %v", path, err)
+		}
+		if !i.filesInAppCache[path] {
+			pathsToDelete = append(pathsToDelete, path)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, path := range pathsToDelete {
+		i.log.Debug("Deleting cached file: %s", path)
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("Failed while cleaning up app cache; couldn't delete %s because: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// open returns the dependency from the buildpack, the app cache or the
+// network, cached is true when it came from the app cache. Downloads are
+// written to the app cache as they are read, and only kept once Close is
+// called after the whole body has been read.
+func (i *Installer) open(entry *libbuildpack.ManifestEntry) (source io.ReadCloser, cached bool, err error) {
+	if entry.File != "" {
+		source := entry.File
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(i.manifest.RootDir(), source)
+		}
+		i.log.Info("Copy [%s]", source)
+		file, err := os.Open(source)
+		return file, false, err
+	}
+
+	if cacheFile := i.cacheFile(entry); cacheFile != "" {
+		i.filesInAppCache[cacheFile] = true
+		if info, err := os.Stat(cacheFile); err == nil && info.Size() == 0 {
+			i.log.Warning("The cached download of %s %s is empty, downloading it again", entry.Dependency.Name, entry.Dependency.Version)
+			if err := i.quarantine(entry); err != nil {
+				return nil, false, err
+			}
+		} else if file, err := os.Open(cacheFile); err == nil {
+			i.log.Info("Copy [%s]", cacheFile)
+			return file, true, nil
+		}
+	}
+
+	source, err = i.download(entry)
+	return source, false, err
+}
+
+// download fetches the dependency, copying it into the app cache
+func (i *Installer) download(entry *libbuildpack.ManifestEntry) (io.ReadCloser, error) {
+	i.log.Info("Download [%s]", filterURI(entry.URI))
+	resp, err := i.Client.Get(entry.URI)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("could not download: %d", resp.StatusCode)
+	}
+	cacheFile := i.cacheFile(entry)
+	if cacheFile == "" {
+		return sizedReader{ReadCloser: resp.Body, size: resp.ContentLength}, nil
+	}
+	i.filesInAppCache[cacheFile] = true
+
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	file, err := os.Create(cacheFile + ".partial")
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return &cachingReader{Reader: io.TeeReader(resp.Body, file), body: resp.Body, file: file, path: cacheFile, size: resp.ContentLength}, nil
+}
+
+func (i *Installer) cacheFile(entry *libbuildpack.ManifestEntry) string {
+	if i.appCacheDir == "" {
+		return ""
+	}
+	shaURI := sha256.Sum256([]byte(entry.URI))
+	return filepath.Join(i.appCacheDir, hex.EncodeToString(shaURI[:]), filepath.Base(entry.URI))
+}
+
+// quarantine moves a corrupt download out of the app cache, next to it in
+// quarantineDir, so it can still be looked at. Only the latest copy of each
+// dependency is kept.
+func (i *Installer) quarantine(entry *libbuildpack.ManifestEntry) error {
+	cacheFile := i.cacheFile(entry)
+	dir := filepath.Join(filepath.Dir(i.appCacheDir), quarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(cacheFile, filepath.Join(dir, filepath.Base(filepath.Dir(cacheFile))+"-"+filepath.Base(cacheFile))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(i.filesInAppCache, cacheFile)
+	return nil
+}
+
+func (i *Installer) discard(entry *libbuildpack.ManifestEntry) {
+	if cacheFile := i.cacheFile(entry); cacheFile != "" {
+		os.Remove(cacheFile)
+		delete(i.filesInAppCache, cacheFile)
+	}
+}
+
+func (i *Installer) warnNewerPatch(dep libbuildpack.Dependency) error {
+	v, err := semver.NewVersion(dep.Version)
+	if err != nil {
+		return nil
+	}
+
+	constraint := fmt.Sprintf("%d.%d.x", v.Major(), v.Minor())
+	latest, err := libbuildpack.FindMatchingVersion(constraint, i.manifest.AllDependencyVersions(dep.Name))
+	if err != nil {
+		return err
+	}
+
+	if latest != dep.Version {
+		i.log.Warning("A newer version of %s is available in this buildpack. "+
+			"Please adjust your app to use version %s instead of version %s as soon as possible. "+
+			"Old versions of %s are only provided to assist in migrating to newer versions.", dep.Name, latest, dep.Version, dep.Name)
+	}
+	return nil
+}
+
+func (i *Installer) warnEndOfLife(dep libbuildpack.Dependency) error {
+	v, versionErr := semver.NewVersion(dep.Version)
+	for _, deprecation := range i.manifest.Deprecations {
+		if deprecation.Name != dep.Name {
+			continue
+		}
+		if versionErr != nil {
+			if deprecation.VersionLine != dep.Version {
+				continue
+			}
+		} else if constraint, err := semver.NewConstraint(deprecation.VersionLine); err != nil || !constraint.Check(v) {
+			continue
+		}
+
+		eolTime, err := time.Parse("2006-01-02", deprecation.Date)
+		if err != nil {
+			return err
+		}
+
+		if time.Until(eolTime) < 30*24*time.Hour {
+			warning := fmt.Sprintf("%s %s will no longer be available in new buildpacks released after %s.", dep.Name, deprecation.VersionLine, deprecation.Date)
+			if deprecation.Link != "" {
+				warning += "\nSee: " + deprecation.Link
+			}
+			i.log.Warning("%s", warning)
+		}
+	}
+	return nil
+}
+
+// unpack streams the archive at uri into outputDir, zip and xz archives
+// need a file on disk to be extracted
+func unpack(uri string, body io.Reader, outputDir string) error {
+	if strings.HasSuffix(uri, ".sh") {
+		return writeFile(body, outputDir, 0755, nil)
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(uri, ".zip") || strings.HasSuffix(uri, ".tar.xz") {
+		tmpDir, err := ioutil.TempDir(filepath.Dir(outputDir), "download")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+
+		tmpFile := filepath.Join(tmpDir, "archive")
+		if err := writeFile(body, tmpFile, 0644, nil); err != nil {
+			return err
+		}
+		if strings.HasSuffix(uri, ".zip") {
+			return extractZip(tmpFile, outputDir)
+		}
+		file, err := os.Open(tmpFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		xz := xzReader(file)
+		defer xz.Close()
+		return ExtractTar(xz, outputDir)
+	}
+
+	gz, err := gzip.NewReader(bufio.NewReaderSize(body, bufferSize))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	decompressed := newReadAhead(gz, readAheadBlocks)
+	defer decompressed.Close()
+	return ExtractTar(decompressed, outputDir)
+}
+
+// ExtractTar extracts the tar stream src into destDir. Entries and link
+// targets which would end up outside destDir, through an absolute path or
+// .., fail the extraction rather than write anywhere the staging user can.
+func ExtractTar(src io.Reader, destDir string) error {
+	destDir = filepath.Clean(destDir)
+	tr := tar.NewReader(src)
+	buf := make([]byte, bufferSize)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		path, err := entryPath(destDir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		fi := hdr.FileInfo()
+		if fi.IsDir() {
+			err = os.MkdirAll(path, fi.Mode())
+		} else if fi.Mode()&os.ModeSymlink != 0 {
+			if filepath.IsAbs(hdr.Linkname) || !within(destDir, filepath.Join(filepath.Dir(path), hdr.Linkname)) {
+				return fmt.Errorf("archive entry %s links outside of the archive to %s", hdr.Name, hdr.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			err = os.Symlink(hdr.Linkname, path)
+		} else {
+			err = writeFile(tr, path, fi.Mode(), buf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// entryPath is where the archive entry name goes in destDir, an error when
+// that is outside of destDir
+func entryPath(destDir, name string) (string, error) {
+	path := filepath.Join(destDir, name)
+	if filepath.IsAbs(name) || !within(destDir, path) {
+		return "", fmt.Errorf("archive entry %s is outside of the archive", name)
+	}
+	return path, nil
+}
+
+// within is true when the clean path is dir or below it
+func within(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// extractZip extracts the zip archive at file into destDir, with the checks
+// of ExtractTar
+func extractZip(file, destDir string) error {
+	destDir = filepath.Clean(destDir)
+	r, err := zip.OpenReader(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		path, err := entryPath(destDir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, f.Mode()); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFile(rc, path, f.Mode(), nil)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// xzReader decompresses r with the xz of the stack
+func xzReader(r io.Reader) io.ReadCloser {
+	rpipe, wpipe := io.Pipe()
+	cmd := exec.Command("xz", "--decompress", "--stdout")
+	cmd.Stdin = r
+	cmd.Stdout = wpipe
+	go func() {
+		wpipe.CloseWithError(cmd.Run())
+	}()
+	return rpipe
+}
+
+// writeFile copies source to destFile, buf is the copy buffer and may be nil
+func writeFile(source io.Reader, destFile string, mode os.FileMode, buf []byte) error {
+	if err := os.MkdirAll(filepath.Dir(destFile), 0755); err != nil {
+		return err
+	}
+
+	fh, err := os.OpenFile(destFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	_, err = io.CopyBuffer(fh, source, buf)
+	return err
+}
+
+func filterURI(rawURL string) string {
+	unsafeURL, err := url.Parse(rawURL)
+	if err != nil || unsafeURL.User == nil {
+		return rawURL
+	}
+	unsafeURL.User = url.UserPassword("-redacted-", "-redacted-")
+	return unsafeURL.String()
+}
+
+// cachingReader copies a download into the app cache, the copy is only
+// moved into place by Close once the body has been read to the end
+type cachingReader struct {
+	io.Reader
+	body io.ReadCloser
+	file *os.File
+	path string
+	size int64
+	eof  bool
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	r.body.Close()
+	if err := r.file.Close(); err != nil || !r.eof {
+		os.Remove(r.file.Name())
+		return err
+	}
+	return os.Rename(r.file.Name(), r.path)
+}
+
+// sizedReader is a download which is not cached, size is -1 when the server
+// did not send a Content-Length
+type sizedReader struct {
+	io.ReadCloser
+	size int64
+}
+
+// sizeOf returns the size of an opened dependency, or -1 when it is unknown
+func sizeOf(source io.ReadCloser) int64 {
+	switch source := source.(type) {
+	case *os.File:
+		if info, err := source.Stat(); err == nil {
+			return info.Size()
+		}
+	case *cachingReader:
+		return source.size
+	case sizedReader:
+		return source.size
+	}
+	return -1
+}
+
+// progressReader logs each quarter of the archive it has read
+type progressReader struct {
+	io.Reader
+	size   int64
+	read   int64
+	logged int64
+	name   string
+	log    *libbuildpack.Logger
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+	if quarter := r.read * 4 / r.size; quarter > r.logged && quarter <= 4 {
+		r.logged = quarter
+		r.log.Info("Extracting %s: %d%%", r.name, quarter*25)
+	}
+	return n, err
+}
+
+// readAhead decompresses blocks of bufferSize in a goroutine while the
+// previous blocks are extracted. Close stops the goroutine and waits for
+// it, nothing reads from the source once Close returns.
+type readAhead struct {
+	blocks  chan []byte
+	done    chan struct{}
+	wg      sync.WaitGroup
+	current []byte
+	err     error
+}
+
+func newReadAhead(src io.Reader, blocks int) *readAhead {
+	r := &readAhead{blocks: make(chan []byte, blocks), done: make(chan struct{})}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer close(r.blocks)
+		for {
+			block := make([]byte, bufferSize)
+			n, err := io.ReadFull(src, block)
+			if n > 0 {
+				select {
+				case r.blocks <- block[:n]:
+				case <-r.done:
+					return
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			} else if err != nil {
+				r.err = err
+				return
+			}
+		}
+	}()
+	return r
+}
+
+func (r *readAhead) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		block, ok := <-r.blocks
+		if !ok {
+			// The goroutine has returned once blocks is closed
+			if r.err != nil {
+				return 0, r.err
+			}
+			return 0, io.EOF
+		}
+		r.current = block
+	}
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+	return n, nil
+}
+
+func (r *readAhead) Close() error {
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+	r.wg.Wait()
+	return nil
+}
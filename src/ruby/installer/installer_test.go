@@ -0,0 +1,460 @@
+package installer_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"ruby/installer"
+	"ruby/provenance"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func tgz(files map[string]string) []byte {
+	buffer := new(bytes.Buffer)
+	gz := gzip.NewWriter(buffer)
+	tw := tar.NewWriter(gz)
+	for name, contents := range files {
+		Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(contents))})).To(Succeed())
+		_, err := tw.Write([]byte(contents))
+		Expect(err).To(BeNil())
+	}
+	Expect(tw.Close()).To(Succeed())
+	Expect(gz.Close()).To(Succeed())
+	return buffer.Bytes()
+}
+
+var _ = Describe("Installer", func() {
+	var (
+		err          error
+		buildpackDir string
+		cacheDir     string
+		outputDir    string
+		buffer       *bytes.Buffer
+		logger       *libbuildpack.Logger
+		server       *httptest.Server
+		requests     int
+		archive      []byte
+		sha          string
+		subject      *installer.Installer
+	)
+
+	BeforeEach(func() {
+		buildpackDir, err = ioutil.TempDir("", "ruby-buildpack.buildpack.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "ruby-buildpack.cache.")
+		Expect(err).To(BeNil())
+		outputDir, err = ioutil.TempDir("", "ruby-buildpack.output.")
+		Expect(err).To(BeNil())
+		outputDir = filepath.Join(outputDir, "thing")
+
+		buffer = new(bytes.Buffer)
+		logger = libbuildpack.NewLogger(ansicleaner.New(buffer))
+
+		archive = tgz(map[string]string{"bin/thing": "#!/bin/sh\necho thing\n"})
+		sum := sha256.Sum256(archive)
+		sha = hex.EncodeToString(sum[:])
+
+		os.Setenv("CF_STACK", "cflinuxfs3")
+		requests = 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if r.URL.Path == "/missing.tgz" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			} else if r.URL.Path == "/truncated.tgz" {
+				w.Header().Set("Content-Length", fmt.Sprint(len(archive)))
+				w.Write(archive[:len(archive)/2])
+				return
+			}
+			w.Write(archive)
+		}))
+	})
+
+	JustBeforeEach(func() {
+		manifest := fmt.Sprintf(`---
+language: ruby
+dependencies:
+- name: thing
+  version: 1.2.3
+  uri: %s/thing-1.2.3.tgz
+  sha256: %s
+  cf_stacks: [cflinuxfs3]
+- name: thing
+  version: 1.2.4
+  uri: %s/thing-1.2.4.tgz
+  sha256: %s
+  cf_stacks: [cflinuxfs3]
+- name: missing
+  version: 1.0.0
+  uri: %s/missing.tgz
+  sha256: abcdef
+  cf_stacks: [cflinuxfs3]
+dependency_deprecation_dates:
+- name: thing
+  version_line: 1.2.x
+  date: 2001-01-01
+  link: https://example.com/eol
+version_lines:
+- name: thing
+  line: 1.3.x
+  status: supported
+- name: thing
+  line: 1.2.x
+  status: security
+  until: 2001-01-01
+`, server.URL, sha, server.URL, sha, server.URL)
+		Expect(ioutil.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), []byte(manifest), 0644)).To(Succeed())
+		m, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
+		Expect(err).To(BeNil())
+
+		subject = installer.New(m, logger)
+		Expect(subject.SetAppCacheDir(cacheDir)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.Unsetenv("CF_STACK")
+		Expect(os.RemoveAll(buildpackDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+		Expect(os.RemoveAll(filepath.Dir(outputDir))).To(Succeed())
+	})
+
+	Describe("InstallDependency", func() {
+		It("downloads and extracts the archive", func() {
+			Expect(subject.InstallDependency(libbuildpack.Dependency{Name: "thing", Version: "1.2.4"}, outputDir)).To(Succeed())
+
+			Expect(ioutil.ReadFile(filepath.Join(outputDir, "bin", "thing"))).To(Equal([]byte("#!/bin/sh\necho thing\n")))
+			Expect(buffer.String()).To(ContainSubstring("Installing thing 1.2.4"))
+			Expect(buffer.String()).To(ContainSubstring("Download [" + server.URL + "/thing-1.2.4.tgz]"))
+		})
+
+		It("keeps the download in the app cache", func() {
+			dep := libbuildpack.Dependency{Name: "thing", Version: "1.2.4"}
+			Expect(subject.InstallDependency(dep, outputDir)).To(Succeed())
+			Expect(os.RemoveAll(outputDir)).To(Succeed())
+			Expect(subject.InstallDependency(dep, outputDir)).To(Succeed())
+
+			Expect(requests).To(Equal(1))
+			Expect(filepath.Join(outputDir, "bin", "thing")).To(BeAnExistingFile())
+			Expect(buffer.String()).To(MatchRegexp(`Copy \[.*/dependencies/[0-9a-f]+/thing-1.2.4.tgz\]`))
+		})
+
+		It("warns about newer patches and end of life", func() {
+			Expect(subject.InstallDependency(libbuildpack.Dependency{Name: "thing", Version: "1.2.3"}, outputDir)).To(Succeed())
+
+			Expect(buffer.String()).To(ContainSubstring("A newer version of thing is available in this buildpack"))
+			Expect(buffer.String()).To(ContainSubstring("thing 1.2.x will no longer be available in new buildpacks released after 2001-01-01."))
+			Expect(buffer.String()).To(ContainSubstring("See: https://example.com/eol"))
+		})
+
+		It("prints the status of the version line", func() {
+			Expect(subject.InstallDependency(libbuildpack.Dependency{Name: "thing", Version: "1.2.4"}, outputDir)).To(Succeed())
+
+			Expect(buffer.String()).To(ContainSubstring("thing 1.2.x only receives security fixes until 2001-01-01. Plan an upgrade to a supported line such as 1.3.x."))
+		})
+
+		It("reports the extraction progress of large archives", func() {
+			subject.ProgressSize = 1
+			Expect(subject.InstallDependency(libbuildpack.Dependency{Name: "thing", Version: "1.2.4"}, outputDir)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Extracting thing 1.2.4: 100%"))
+
+			buffer.Reset()
+			subject.ProgressSize = 0
+			Expect(os.RemoveAll(outputDir)).To(Succeed())
+			Expect(subject.InstallDependency(libbuildpack.Dependency{Name: "thing", Version: "1.2.4"}, outputDir)).To(Succeed())
+			Expect(buffer.String()).ToNot(ContainSubstring("Extracting"))
+		})
+
+		Context("the archive is larger than the read ahead buffers", func() {
+			var contents []byte
+
+			BeforeEach(func() {
+				contents = make([]byte, 5*1024*1024+7)
+				for i := range contents {
+					contents[i] = byte(i * 7 % 251)
+				}
+				archive = tgz(map[string]string{"lib/jruby.jar": string(contents), "bin/jruby": "#!/bin/sh\n"})
+				sum := sha256.Sum256(archive)
+				sha = hex.EncodeToString(sum[:])
+			})
+
+			It("extracts every file", func() {
+				Expect(subject.InstallDependency(libbuildpack.Dependency{Name: "thing", Version: "1.2.4"}, outputDir)).To(Succeed())
+				Expect(ioutil.ReadFile(filepath.Join(outputDir, "lib", "jruby.jar"))).To(Equal(contents))
+				Expect(filepath.Join(outputDir, "bin", "jruby")).To(BeAnExistingFile())
+			})
+		})
+
+		Context("the checksum does not match", func() {
+			BeforeEach(func() {
+				sha = "0000"
+			})
+
+			It("fails and removes the extracted files and the cached download", func() {
+				err := subject.InstallDependency(libbuildpack.Dependency{Name: "thing", Version: "1.2.4"}, outputDir)
+				Expect(err).To(MatchError(ContainSubstring("dependency sha256 mismatch: expected sha256 0000")))
+
+				Expect(outputDir).ToNot(BeADirectory())
+				cached, err := filepath.Glob(filepath.Join(cacheDir, "dependencies", "*", "*"))
+				Expect(err).To(BeNil())
+				Expect(cached).To(BeEmpty())
+			})
+		})
+
+		Context("the checksum does not match an install which is there already", func() {
+			BeforeEach(func() {
+				sha = "0000"
+				Expect(os.MkdirAll(filepath.Join(outputDir, "bin"), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(outputDir, "bin", "thing"), []byte("installed"), 0755)).To(Succeed())
+			})
+
+			It("never extracts the archive over it", func() {
+				Expect(subject.InstallDependency(libbuildpack.Dependency{Name: "thing", Version: "1.2.4"}, outputDir)).ToNot(Succeed())
+
+				Expect(ioutil.ReadFile(filepath.Join(outputDir, "bin", "thing"))).To(Equal([]byte("installed")))
+				Expect(filepath.Glob(filepath.Join(filepath.Dir(outputDir), ".install*"))).To(BeEmpty())
+			})
+		})
+
+		Context("the cached download is corrupt", func() {
+			var (
+				dep    libbuildpack.Dependency
+				cached string
+			)
+
+			JustBeforeEach(func() {
+				dep = libbuildpack.Dependency{Name: "thing", Version: "1.2.4"}
+				Expect(subject.InstallDependency(dep, outputDir)).To(Succeed())
+				Expect(os.RemoveAll(outputDir)).To(Succeed())
+				matches, err := filepath.Glob(filepath.Join(cacheDir, "dependencies", "*", "thing-1.2.4.tgz"))
+				Expect(err).To(BeNil())
+				Expect(matches).To(HaveLen(1))
+				cached = matches[0]
+			})
+
+			It("quarantines a truncated download and downloads it again", func() {
+				Expect(ioutil.WriteFile(cached, archive[:len(archive)/2], 0644)).To(Succeed())
+				Expect(subject.InstallDependency(dep, outputDir)).To(Succeed())
+
+				Expect(requests).To(Equal(2))
+				Expect(filepath.Join(outputDir, "bin", "thing")).To(BeAnExistingFile())
+				Expect(buffer.String()).To(ContainSubstring("The cached download of thing 1.2.4 is corrupt, downloading it again"))
+				Expect(ioutil.ReadFile(cached)).To(Equal(archive))
+				quarantined, err := filepath.Glob(filepath.Join(cacheDir, "quarantine", "*-thing-1.2.4.tgz"))
+				Expect(err).To(BeNil())
+				Expect(quarantined).To(HaveLen(1))
+			})
+
+			It("downloads an empty download again", func() {
+				Expect(ioutil.WriteFile(cached, []byte{}, 0644)).To(Succeed())
+				Expect(subject.InstallDependency(dep, outputDir)).To(Succeed())
+
+				Expect(requests).To(Equal(2))
+				Expect(buffer.String()).To(ContainSubstring("The cached download of thing 1.2.4 is empty, downloading it again"))
+				Expect(ioutil.ReadFile(cached)).To(Equal(archive))
+			})
+
+			It("keeps the fresh download when the app cache is cleaned up", func() {
+				Expect(ioutil.WriteFile(cached, []byte("garbage"), 0644)).To(Succeed())
+				Expect(subject.InstallDependency(dep, outputDir)).To(Succeed())
+				Expect(subject.CleanupAppCache()).To(Succeed())
+				Expect(ioutil.ReadFile(cached)).To(Equal(archive))
+			})
+		})
+
+		Context("the download fails", func() {
+			It("returns an error", func() {
+				err := subject.InstallDependency(libbuildpack.Dependency{Name: "missing", Version: "1.0.0"}, outputDir)
+				Expect(err).To(MatchError("could not download: 404"))
+			})
+		})
+
+		Context("the dependency is cached in the buildpack", func() {
+			JustBeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(buildpackDir, "dependencies"), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(buildpackDir, "dependencies", "thing.tgz"), archive, 0644)).To(Succeed())
+				manifest := fmt.Sprintf("---\nlanguage: ruby\ndependencies:\n- name: thing\n  version: 1.2.4\n  uri: %s/thing-1.2.4.tgz\n  file: dependencies/thing.tgz\n  sha256: %s\n  cf_stacks: [cflinuxfs3]\n", server.URL, sha)
+				Expect(ioutil.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), []byte(manifest), 0644)).To(Succeed())
+				m, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
+				Expect(err).To(BeNil())
+				subject = installer.New(m, logger)
+			})
+
+			It("extracts it without downloading", func() {
+				Expect(subject.InstallDependency(libbuildpack.Dependency{Name: "thing", Version: "1.2.4"}, outputDir)).To(Succeed())
+				Expect(requests).To(Equal(0))
+				Expect(filepath.Join(outputDir, "bin", "thing")).To(BeAnExistingFile())
+			})
+		})
+	})
+
+	Describe("InstallSideload", func() {
+		It("downloads a dependency which is not in the manifest", func() {
+			dep := libbuildpack.Dependency{Name: "wkhtmltopdf", Version: "0.12.5"}
+			Expect(subject.InstallSideload(dep, server.URL+"/wkhtmltox.tgz", sha, outputDir)).To(Succeed())
+
+			Expect(filepath.Join(outputDir, "bin", "thing")).To(BeAnExistingFile())
+			Expect(buffer.String()).To(ContainSubstring("Installing wkhtmltopdf 0.12.5"))
+			Expect(buffer.String()).ToNot(ContainSubstring("A newer version"))
+		})
+
+		It("keeps the download in the app cache", func() {
+			dep := libbuildpack.Dependency{Name: "wkhtmltopdf", Version: "0.12.5"}
+			Expect(subject.InstallSideload(dep, server.URL+"/wkhtmltox.tgz", sha, outputDir)).To(Succeed())
+			Expect(os.RemoveAll(outputDir)).To(Succeed())
+			Expect(subject.InstallSideload(dep, server.URL+"/wkhtmltox.tgz", sha, outputDir)).To(Succeed())
+			Expect(requests).To(Equal(1))
+		})
+
+		It("fails when the checksum does not match", func() {
+			err := subject.InstallSideload(libbuildpack.Dependency{Name: "wkhtmltopdf"}, server.URL+"/wkhtmltox.tgz", "0000", outputDir)
+			Expect(err).To(MatchError(ContainSubstring("dependency sha256 mismatch: expected sha256 0000")))
+			Expect(outputDir).ToNot(BeADirectory())
+		})
+
+		Context("the archive has entries outside of it", func() {
+			install := func(headers ...*tar.Header) error {
+				buffer := new(bytes.Buffer)
+				gz := gzip.NewWriter(buffer)
+				tw := tar.NewWriter(gz)
+				for _, hdr := range headers {
+					Expect(tw.WriteHeader(hdr)).To(Succeed())
+					_, err := tw.Write(make([]byte, hdr.Size))
+					Expect(err).To(BeNil())
+				}
+				Expect(tw.Close()).To(Succeed())
+				Expect(gz.Close()).To(Succeed())
+				archive = buffer.Bytes()
+				sum := sha256.Sum256(archive)
+				return subject.InstallSideload(libbuildpack.Dependency{Name: "wkhtmltopdf"}, server.URL+"/wkhtmltox.tgz", hex.EncodeToString(sum[:]), outputDir)
+			}
+
+			It("rejects an entry with ..", func() {
+				err := install(&tar.Header{Name: "../escaped", Mode: 0644, Size: 4})
+				Expect(err).To(MatchError("archive entry ../escaped is outside of the archive"))
+				Expect(filepath.Join(filepath.Dir(outputDir), "escaped")).ToNot(BeAnExistingFile())
+				Expect(outputDir).ToNot(BeADirectory())
+			})
+
+			It("rejects an absolute entry", func() {
+				err := install(&tar.Header{Name: "/tmp/escaped", Mode: 0644, Size: 4})
+				Expect(err).To(MatchError("archive entry /tmp/escaped is outside of the archive"))
+			})
+
+			It("rejects a link out of the archive", func() {
+				err := install(&tar.Header{Name: "lib/etc", Typeflag: tar.TypeSymlink, Linkname: "../../../etc", Mode: 0777})
+				Expect(err).To(MatchError("archive entry lib/etc links outside of the archive to ../../../etc"))
+				err = install(&tar.Header{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/etc", Mode: 0777})
+				Expect(err).To(MatchError("archive entry etc links outside of the archive to /etc"))
+			})
+
+			It("keeps a link within the archive", func() {
+				Expect(install(
+					&tar.Header{Name: "lib/libthing.so.1", Mode: 0644, Size: 4},
+					&tar.Header{Name: "lib/libthing.so", Typeflag: tar.TypeSymlink, Linkname: "libthing.so.1", Mode: 0777},
+				)).To(Succeed())
+				Expect(os.Readlink(filepath.Join(outputDir, "lib", "libthing.so"))).To(Equal("libthing.so.1"))
+			})
+		})
+	})
+
+	Describe("Installed", func() {
+		It("records where each installed dependency came from", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildpackDir, "VERSION"), []byte("1.7.9\n"), 0644)).To(Succeed())
+			m, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
+			Expect(err).To(BeNil())
+			subject = installer.New(m, logger)
+			Expect(subject.SetAppCacheDir(cacheDir)).To(Succeed())
+
+			dep := libbuildpack.Dependency{Name: "thing", Version: "1.2.4"}
+			Expect(subject.InstallDependency(dep, outputDir)).To(Succeed())
+			Expect(subject.InstallDependency(dep, outputDir)).To(Succeed())
+
+			installed := subject.Installed()
+			Expect(installed).To(HaveLen(2))
+			Expect(installed[0].Name).To(Equal("thing"))
+			Expect(installed[0].Version).To(Equal("1.2.4"))
+			Expect(installed[0].URI).To(Equal(server.URL + "/thing-1.2.4.tgz"))
+			Expect(installed[0].SHA256).To(Equal(sha))
+			Expect(installed[0].Origin).To(Equal(provenance.FromDownload))
+			Expect(installed[0].BuildpackVersion).To(Equal("1.7.9"))
+			Expect(installed[0].InstalledAt).To(BeTemporally("~", time.Now(), time.Minute))
+			Expect(installed[1].Origin).To(Equal(provenance.FromAppCache))
+		})
+
+		It("leaves out dependencies which failed to install", func() {
+			Expect(subject.InstallDependency(libbuildpack.Dependency{Name: "missing", Version: "1.0.0"}, outputDir)).ToNot(Succeed())
+			Expect(subject.Installed()).To(BeEmpty())
+		})
+
+		It("records dependencies reused from the app cache", func() {
+			Expect(subject.Reused(libbuildpack.Dependency{Name: "thing", Version: "1.2.4"})).To(Succeed())
+
+			installed := subject.Installed()
+			Expect(installed).To(HaveLen(1))
+			Expect(installed[0].SHA256).To(Equal(sha))
+			Expect(installed[0].Origin).To(Equal(provenance.FromAppCache))
+		})
+	})
+
+	Describe("InstallOnlyVersion", func() {
+		It("fails when there is more than one version", func() {
+			Expect(subject.InstallOnlyVersion("thing", outputDir)).To(MatchError("more than one version of thing found"))
+		})
+
+		It("installs the only version", func() {
+			Expect(subject.InstallOnlyVersion("missing", outputDir)).To(MatchError("could not download: 404"))
+			Expect(buffer.String()).To(ContainSubstring("Installing missing 1.0.0"))
+		})
+	})
+
+	Describe("SetAppCacheDir", func() {
+		It("removes downloads a stopped staging left behind", func() {
+			partial := filepath.Join(cacheDir, "dependencies", "abc", "thing-1.2.4.tgz.partial")
+			Expect(os.MkdirAll(filepath.Dir(partial), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(partial, archive[:10], 0644)).To(Succeed())
+
+			Expect(subject.SetAppCacheDir(cacheDir)).To(Succeed())
+			Expect(partial).ToNot(BeAnExistingFile())
+		})
+	})
+
+	It("leaves nothing in the app cache or the output dir when a download breaks off", func() {
+		err := subject.InstallSideload(libbuildpack.Dependency{Name: "thing"}, server.URL+"/truncated.tgz", sha, outputDir)
+		Expect(err).ToNot(BeNil())
+
+		Expect(outputDir).ToNot(BeADirectory())
+		cached, err := filepath.Glob(filepath.Join(cacheDir, "dependencies", "*", "*"))
+		Expect(err).To(BeNil())
+		Expect(cached).To(BeEmpty())
+	})
+
+	Describe("CleanupAppCache", func() {
+		It("removes downloads which were not used", func() {
+			stale := filepath.Join(cacheDir, "dependencies", "abc", "old.tgz")
+			Expect(os.MkdirAll(filepath.Dir(stale), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(stale, []byte("old"), 0644)).To(Succeed())
+
+			Expect(subject.InstallDependency(libbuildpack.Dependency{Name: "thing", Version: "1.2.4"}, outputDir)).To(Succeed())
+			Expect(subject.CleanupAppCache()).To(Succeed())
+
+			Expect(stale).ToNot(BeAnExistingFile())
+			cached, err := filepath.Glob(filepath.Join(cacheDir, "dependencies", "*", "thing-1.2.4.tgz"))
+			Expect(err).To(BeNil())
+			Expect(cached).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,236 @@
+package installer_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"ruby/installer"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// These specs pin down the parts of libbuildpack's manifest handling this
+// buildpack relies on, using golden manifests in fixtures/manifests/contract.
+// A libbuildpack bump which changes the schema should fail here first.
+var _ = Describe("Manifest contract", func() {
+	const fixtures = "../../../fixtures/manifests/contract"
+
+	var (
+		buildpackDir string
+		outputDir    string
+		buffer       *bytes.Buffer
+		logger       *libbuildpack.Logger
+		server       *httptest.Server
+		requests     []*http.Request
+		archive      []byte
+		sha          string
+	)
+
+	BeforeEach(func() {
+		var err error
+		buildpackDir, err = ioutil.TempDir("", "ruby-buildpack.buildpack.")
+		Expect(err).To(BeNil())
+		outputDir, err = ioutil.TempDir("", "ruby-buildpack.output.")
+		Expect(err).To(BeNil())
+
+		buffer = new(bytes.Buffer)
+		logger = libbuildpack.NewLogger(ansicleaner.New(buffer))
+
+		archive = tgz(map[string]string{"bin/thing": "thing"})
+		sum := sha256.Sum256(archive)
+		sha = hex.EncodeToString(sum[:])
+
+		requests = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r)
+			w.Write(archive)
+		}))
+		os.Setenv("CF_STACK", "cflinuxfs3")
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.Unsetenv("CF_STACK")
+		Expect(os.RemoveAll(buildpackDir)).To(Succeed())
+		Expect(os.RemoveAll(outputDir)).To(Succeed())
+	})
+
+	load := func(name string) *libbuildpack.Manifest {
+		data, err := ioutil.ReadFile(filepath.Join(fixtures, name, "manifest.yml"))
+		Expect(err).To(BeNil())
+		withCredentials := strings.Replace(server.URL, "http://", "http://user:s3cr3t@", 1)
+		manifest := strings.NewReplacer(
+			"{{server}}", server.URL,
+			"{{server_with_credentials}}", withCredentials,
+			"{{sha256}}", sha,
+		).Replace(string(data))
+		Expect(ioutil.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), []byte(manifest), 0644)).To(Succeed())
+
+		m, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
+		Expect(err).To(BeNil())
+		return m
+	}
+
+	Describe("per stack entries", func() {
+		var manifest *libbuildpack.Manifest
+		BeforeEach(func() {
+			manifest = load("per_stack")
+		})
+
+		It("only lists the versions for the current stack", func() {
+			Expect(manifest.AllDependencyVersions("ruby")).To(Equal([]string{"2.4.4"}))
+
+			os.Setenv("CF_STACK", "cflinuxfs2")
+			Expect(manifest.AllDependencyVersions("ruby")).To(ConsistOf("2.4.4", "2.4.5"))
+		})
+
+		It("resolves the default version against the current stack", func() {
+			os.Setenv("CF_STACK", "cflinuxfs2")
+			dep, err := manifest.DefaultVersion("ruby")
+			Expect(err).To(BeNil())
+			Expect(dep).To(Equal(libbuildpack.Dependency{Name: "ruby", Version: "2.4.5"}))
+		})
+
+		It("finds the entry built for the current stack", func() {
+			entry, err := manifest.GetEntry(libbuildpack.Dependency{Name: "ruby", Version: "2.4.4"})
+			Expect(err).To(BeNil())
+			Expect(entry.URI).To(HaveSuffix("cflinuxfs3.tgz"))
+			Expect(entry.SHA256).To(Equal(sha))
+			Expect(entry.CFStacks).To(Equal([]string{"cflinuxfs3"}))
+		})
+
+		It("returns an error for a version which is not built for the current stack", func() {
+			_, err := manifest.GetEntry(libbuildpack.Dependency{Name: "ruby", Version: "2.4.5"})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("installs the entry for the current stack", func() {
+			Expect(installer.New(manifest, logger).InstallDependency(libbuildpack.Dependency{Name: "ruby", Version: "2.4.4"}, outputDir)).To(Succeed())
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].URL.Path).To(Equal("/ruby-2.4.4-linux-x64-cflinuxfs3.tgz"))
+		})
+	})
+
+	Describe("end of life dates", func() {
+		var manifest *libbuildpack.Manifest
+		BeforeEach(func() {
+			manifest = load("eol")
+		})
+
+		It("parses every deprecation", func() {
+			Expect(manifest.Deprecations).To(HaveLen(3))
+			Expect(manifest.Deprecations[0]).To(Equal(libbuildpack.DeprecationDate{
+				Name:        "ruby",
+				VersionLine: "2.2.x",
+				Date:        "2018-04-01",
+				Link:        "https://www.ruby-lang.org/en/news/2017/04/01/support-of-ruby-2-1-has-ended/",
+			}))
+			Expect(manifest.Deprecations[2].Link).To(Equal(""))
+		})
+
+		It("warns when installing a version line past its end of life", func() {
+			Expect(installer.New(manifest, logger).InstallDependency(libbuildpack.Dependency{Name: "ruby", Version: "2.2.10"}, outputDir)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("ruby 2.2.x will no longer be available in new buildpacks released after 2018-04-01."))
+			Expect(buffer.String()).To(ContainSubstring("See: https://www.ruby-lang.org/en/news/2017/04/01/support-of-ruby-2-1-has-ended/"))
+		})
+
+		It("does not warn about a version line which is still supported", func() {
+			Expect(installer.New(manifest, logger).InstallDependency(libbuildpack.Dependency{Name: "ruby", Version: "2.5.1"}, outputDir)).To(Succeed())
+			Expect(buffer.String()).ToNot(ContainSubstring("no longer be available"))
+		})
+	})
+
+	Describe("credentials in uris", func() {
+		var manifest *libbuildpack.Manifest
+		BeforeEach(func() {
+			manifest = load("credentials")
+		})
+
+		It("keeps the credentials in the entry", func() {
+			entry, err := manifest.GetEntry(libbuildpack.Dependency{Name: "node", Version: "8.11.4"})
+			Expect(err).To(BeNil())
+			Expect(entry.URI).To(ContainSubstring("user:s3cr3t@"))
+		})
+
+		It("sends the credentials but never logs them", func() {
+			Expect(installer.New(manifest, logger).InstallDependency(libbuildpack.Dependency{Name: "node", Version: "8.11.4"}, outputDir)).To(Succeed())
+
+			Expect(requests).To(HaveLen(1))
+			username, password, ok := requests[0].BasicAuth()
+			Expect(ok).To(BeTrue())
+			Expect(username).To(Equal("user"))
+			Expect(password).To(Equal("s3cr3t"))
+
+			Expect(buffer.String()).To(ContainSubstring("-redacted-:-redacted-@"))
+			Expect(buffer.String()).ToNot(ContainSubstring("s3cr3t"))
+		})
+	})
+
+	Describe("the buildpack's manifest.yml", func() {
+		var manifest *libbuildpack.Manifest
+		BeforeEach(func() {
+			var err error
+			manifest, err = libbuildpack.NewManifest("../../..", logger, time.Now())
+			Expect(err).To(BeNil())
+		})
+
+		It("has a checksum and stacks for every entry", func() {
+			for _, entry := range manifest.ManifestEntries {
+				Expect(entry.SHA256).To(MatchRegexp(`^[0-9a-f]{64}$`), entry.Dependency.Name+" "+entry.Dependency.Version)
+				Expect(entry.CFStacks).ToNot(BeEmpty(), entry.Dependency.Name+" "+entry.Dependency.Version)
+			}
+		})
+
+		It("has deprecation dates which parse", func() {
+			for _, deprecation := range manifest.Deprecations {
+				_, err := time.Parse("2006-01-02", deprecation.Date)
+				Expect(err).To(BeNil(), deprecation.Name+" "+deprecation.VersionLine)
+			}
+		})
+
+		It("pins rubygems versions which are in the manifest", func() {
+			pins, err := installer.LoadRubygemsVersions("../../../manifest.yml")
+			Expect(err).To(BeNil())
+			Expect(pins).ToNot(BeEmpty())
+			for _, pin := range pins {
+				Expect(manifest.AllDependencyVersions("rubygems")).To(ContainElement(pin.Rubygems), "ruby "+pin.Ruby)
+			}
+		})
+
+		It("has version lines which dependencies of the manifest are in", func() {
+			lines, err := installer.LoadVersionLines("../../../manifest.yml")
+			Expect(err).To(BeNil())
+			Expect(lines).ToNot(BeEmpty())
+			for _, line := range lines {
+				_, err := libbuildpack.FindMatchingVersion(line.Line, manifest.AllDependencyVersions(line.Name))
+				Expect(err).To(BeNil(), line.Name+" "+line.Line)
+			}
+		})
+
+		It("resolves every default version on every stack it supports", func() {
+			stacks := map[string]bool{}
+			for _, entry := range manifest.ManifestEntries {
+				for _, stack := range entry.CFStacks {
+					stacks[stack] = true
+				}
+			}
+			for stack := range stacks {
+				os.Setenv("CF_STACK", stack)
+				for _, dep := range manifest.DefaultVersions {
+					_, err := manifest.DefaultVersion(dep.Name)
+					Expect(err).To(BeNil(), dep.Name+" on "+stack)
+				}
+			}
+		})
+	})
+})
@@ -0,0 +1,43 @@
+package installer
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudfoundry/libbuildpack"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// RubygemsVersion pins the rubygems a ruby version line is upgraded to, the
+// rubygems shipped with older rubies can not install some modern gems
+type RubygemsVersion struct {
+	Ruby     string `yaml:"ruby"`
+	Rubygems string `yaml:"rubygems"`
+}
+
+// LoadRubygemsVersions reads the rubygems_versions of the manifest
+func LoadRubygemsVersions(manifestFile string) ([]RubygemsVersion, error) {
+	var manifest struct {
+		RubygemsVersions []RubygemsVersion `yaml:"rubygems_versions"`
+	}
+	data, err := ioutil.ReadFile(manifestFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return manifest.RubygemsVersions, nil
+}
+
+// RubygemsFor returns the rubygems pinned for rubyVersion
+func RubygemsFor(pins []RubygemsVersion, rubyVersion string) (string, bool) {
+	for _, pin := range pins {
+		if _, err := libbuildpack.FindMatchingVersion(pin.Ruby, []string{rubyVersion}); err == nil {
+			return pin.Rubygems, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,51 @@
+package installer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/installer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RubygemsVersions", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ruby-buildpack.manifest.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("loads the pins from the manifest", func() {
+		manifest := filepath.Join(dir, "manifest.yml")
+		Expect(ioutil.WriteFile(manifest, []byte("language: ruby\nrubygems_versions:\n- ruby: 2.4.x\n  rubygems: 2.7.7\n"), 0644)).To(Succeed())
+
+		pins, err := installer.LoadRubygemsVersions(manifest)
+		Expect(err).To(BeNil())
+		Expect(pins).To(Equal([]installer.RubygemsVersion{{Ruby: "2.4.x", Rubygems: "2.7.7"}}))
+	})
+
+	It("has no pins when there is no manifest", func() {
+		pins, err := installer.LoadRubygemsVersions(filepath.Join(dir, "manifest.yml"))
+		Expect(err).To(BeNil())
+		Expect(pins).To(BeEmpty())
+	})
+
+	It("finds the pin for the ruby version line", func() {
+		pins := []installer.RubygemsVersion{{Ruby: "2.4.x", Rubygems: "2.7.7"}, {Ruby: "2.5.x", Rubygems: "3.0.3"}}
+
+		version, found := installer.RubygemsFor(pins, "2.5.1")
+		Expect(found).To(BeTrue())
+		Expect(version).To(Equal("3.0.3"))
+
+		_, found = installer.RubygemsFor(pins, "2.6.0")
+		Expect(found).To(BeFalse())
+	})
+})
@@ -0,0 +1,106 @@
+package installer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The statuses of a version line
+const (
+	LineSupported = "supported"
+	LineSecurity  = "security"
+	LineEOL       = "eol"
+)
+
+// VersionLine is the support status of a minor version line of a
+// dependency, such as ruby 2.3.x only receiving security fixes. Lines of a
+// dependency are listed newest first, Until is when the status ends.
+type VersionLine struct {
+	Name   string `yaml:"name"`
+	Line   string `yaml:"line"`
+	Status string `yaml:"status"`
+	Until  string `yaml:"until"`
+}
+
+// LoadVersionLines reads the version_lines of the manifest
+func LoadVersionLines(manifestFile string) ([]VersionLine, error) {
+	var manifest struct {
+		VersionLines []VersionLine `yaml:"version_lines"`
+	}
+	data, err := ioutil.ReadFile(manifestFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	for _, line := range manifest.VersionLines {
+		if line.Status != LineSupported && line.Status != LineSecurity && line.Status != LineEOL {
+			return nil, fmt.Errorf("version line %s %s has the unknown status %q", line.Name, line.Line, line.Status)
+		}
+	}
+	return manifest.VersionLines, nil
+}
+
+// LineOf returns the line of name which version is in
+func LineOf(lines []VersionLine, name, version string) (VersionLine, bool) {
+	for _, line := range lines {
+		if line.Name != name {
+			continue
+		}
+		if _, err := libbuildpack.FindMatchingVersion(line.Line, []string{version}); err == nil {
+			return line, true
+		}
+	}
+	return VersionLine{}, false
+}
+
+// SupportedLine returns the newest supported line of name
+func SupportedLine(lines []VersionLine, name string) (VersionLine, bool) {
+	for _, line := range lines {
+		if line.Name == name && line.Status == LineSupported {
+			return line, true
+		}
+	}
+	return VersionLine{}, false
+}
+
+// reportVersionLine prints the status of the line dep is in, a warning with
+// the line to upgrade to unless it is still supported
+func (i *Installer) reportVersionLine(dep libbuildpack.Dependency) error {
+	lines, err := LoadVersionLines(filepath.Join(i.manifest.RootDir(), "manifest.yml"))
+	if err != nil {
+		return err
+	}
+	line, found := LineOf(lines, dep.Name, dep.Version)
+	if !found {
+		return nil
+	}
+
+	var message string
+	switch line.Status {
+	case LineSupported:
+		i.log.Info("%s %s is a supported version line", dep.Name, line.Line)
+		return nil
+	case LineSecurity:
+		message = fmt.Sprintf("%s %s only receives security fixes", dep.Name, line.Line)
+		if line.Until != "" {
+			message += " until " + line.Until
+		}
+		message += "."
+	case LineEOL:
+		message = fmt.Sprintf("%s %s is end of life and receives no more fixes.", dep.Name, line.Line)
+	}
+	if supported, ok := SupportedLine(lines, dep.Name); ok {
+		message += fmt.Sprintf(" Plan an upgrade to a supported line such as %s.", supported.Line)
+	}
+	i.log.Warning("%s", message)
+	return nil
+}
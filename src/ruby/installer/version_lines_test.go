@@ -0,0 +1,76 @@
+package installer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/installer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VersionLines", func() {
+	var (
+		dir   string
+		lines = []installer.VersionLine{
+			{Name: "ruby", Line: "2.5.x", Status: installer.LineSupported},
+			{Name: "ruby", Line: "2.4.x", Status: installer.LineSupported},
+			{Name: "ruby", Line: "2.3.x", Status: installer.LineSecurity, Until: "2019-03-31"},
+			{Name: "node", Line: "6.x", Status: installer.LineEOL},
+		}
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ruby-buildpack.manifest.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("loads the lines from the manifest", func() {
+		manifest := filepath.Join(dir, "manifest.yml")
+		Expect(ioutil.WriteFile(manifest, []byte("language: ruby\nversion_lines:\n- name: ruby\n  line: 2.3.x\n  status: security\n  until: 2019-03-31\n"), 0644)).To(Succeed())
+
+		loaded, err := installer.LoadVersionLines(manifest)
+		Expect(err).To(BeNil())
+		Expect(loaded).To(Equal([]installer.VersionLine{lines[2]}))
+	})
+
+	It("rejects a line with an unknown status", func() {
+		manifest := filepath.Join(dir, "manifest.yml")
+		Expect(ioutil.WriteFile(manifest, []byte("version_lines:\n- name: ruby\n  line: 2.3.x\n  status: maintained\n"), 0644)).To(Succeed())
+
+		_, err := installer.LoadVersionLines(manifest)
+		Expect(err).To(MatchError(`version line ruby 2.3.x has the unknown status "maintained"`))
+	})
+
+	It("has no lines when there is no manifest", func() {
+		loaded, err := installer.LoadVersionLines(filepath.Join(dir, "manifest.yml"))
+		Expect(err).To(BeNil())
+		Expect(loaded).To(BeEmpty())
+	})
+
+	It("finds the line of a version", func() {
+		line, found := installer.LineOf(lines, "ruby", "2.3.7")
+		Expect(found).To(BeTrue())
+		Expect(line.Status).To(Equal(installer.LineSecurity))
+
+		_, found = installer.LineOf(lines, "ruby", "2.2.10")
+		Expect(found).To(BeFalse())
+		_, found = installer.LineOf(lines, "jruby", "9.2.0.0")
+		Expect(found).To(BeFalse())
+	})
+
+	It("finds the newest supported line", func() {
+		line, found := installer.SupportedLine(lines, "ruby")
+		Expect(found).To(BeTrue())
+		Expect(line.Line).To(Equal("2.5.x"))
+
+		_, found = installer.SupportedLine(lines, "node")
+		Expect(found).To(BeFalse())
+	})
+})
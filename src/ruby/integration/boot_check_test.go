@@ -0,0 +1,28 @@
+package integration_test
+
+import (
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("App with BP_BOOT_CHECK enabled", func() {
+	var app *cutlass.App
+	AfterEach(func() { app = DestroyApp(app) })
+
+	BeforeEach(func() {
+		app = cutlass.New(filepath.Join(bpDir, "fixtures", "sinatra"))
+		app.SetEnv("BP_BOOT_CHECK", "true")
+		app.SetEnv("BP_BOOT_CHECK_TIMEOUT", "5")
+	})
+
+	It("boots the app during staging", func() {
+		PushAppAndConfirm(app)
+		Expect(app.Stdout.String()).To(ContainSubstring("Checking that the app boots (5s)"))
+		Expect(app.Stdout.String()).To(ContainSubstring("App booted successfully"))
+		Expect(app.GetBody("/")).To(ContainSubstring("Hello world!"))
+	})
+})
@@ -0,0 +1,32 @@
+package integration_test
+
+import (
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CF Ruby Buildpack", func() {
+	var app *cutlass.App
+	AfterEach(func() { app = DestroyApp(app) })
+
+	Context("an app which reads files and command output", func() {
+		BeforeEach(func() {
+			SkipUnlessUncached()
+			app = cutlass.New(filepath.Join(bpDir, "fixtures", "with_encoding"))
+		})
+
+		It("reads them as UTF-8 at runtime", func() {
+			PushAppAndConfirm(app)
+			body, err := app.GetBody("/")
+			Expect(err).To(BeNil())
+			Expect(body).To(ContainSubstring("File: UTF-8"))
+			Expect(body).To(ContainSubstring("Command output: UTF-8"))
+			Expect(body).To(ContainSubstring("Default external: UTF-8"))
+			Expect(body).To(MatchRegexp(`LC_ALL: \S+\.UTF-8`))
+		})
+	})
+})
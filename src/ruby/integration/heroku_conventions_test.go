@@ -0,0 +1,32 @@
+package integration_test
+
+import (
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("App following Heroku conventions", func() {
+	var app *cutlass.App
+	AfterEach(func() { app = DestroyApp(app) })
+
+	BeforeEach(func() {
+		app = cutlass.New(filepath.Join(bpDir, "fixtures", "heroku_conventions"))
+	})
+
+	It("runs the Procfile web process with Heroku's default environment", func() {
+		PushAppAndConfirm(app)
+		Expect(app.GetBody("/")).To(ContainSubstring("Hello world!"))
+
+		body, err := app.GetBody("/env")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(body).To(ContainSubstring("RAILS_LOG_TO_STDOUT=enabled"))
+		Expect(body).To(ContainSubstring("RAILS_SERVE_STATIC_FILES=enabled"))
+		Expect(body).To(ContainSubstring("RACK_ENV=production"))
+
+		Eventually(app.Stdout.String).Should(ContainSubstring("heroku conventions request"))
+	})
+})
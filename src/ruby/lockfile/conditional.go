@@ -0,0 +1,181 @@
+package lockfile
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// windowsPlatform matches the Gemfile platforms which never match linux,
+// such as :mingw, :x64_mingw and :mswin64 with or without a ruby version
+var windowsPlatform = regexp.MustCompile(`^((x64_)?mingw|mswin(64)?)(_\d+)?$|^windows$`)
+
+// otherOS matches install_if conditions which only hold on macOS or windows
+var otherOS = regexp.MustCompile(`darwin|mingw|mswin|cygwin|win_platform\?|OS\.(mac|windows)\?`)
+
+var (
+	gemLine       = regexp.MustCompile(`^\s*gem\s*\(?\s*["']([^"']+)["'](.*)$`)
+	platformBlock = regexp.MustCompile(`^\s*platforms?\s*\(?\s*((?:%i[\[(][^\])]*[\])]|:\w+|[\s,*\[\]])+)\)?\s*do\b`)
+	installIf     = regexp.MustCompile(`^\s*install_if\b(.*)\bdo\b`)
+	platformsOpt  = regexp.MustCompile(`(?:\bplatforms?:|:platforms?\s*=>)\s*(\[[^\]]*\]|%i[\[(][^\])]*[\])]|:\w+)`)
+	installIfOpt  = regexp.MustCompile(`\binstall_if:\s*(.*)$|:install_if\s*=>\s*(.*)$`)
+	ifModifier    = regexp.MustCompile(`\sif\s(.*)$`)
+	blockStart    = regexp.MustCompile(`(^\s*(if|unless|case|begin|def|while|until|class|module)\b)|\bdo\s*(\|[^|]*\|)?\s*(#.*)?$`)
+	blockEnd      = regexp.MustCompile(`^\s*end\b`)
+	word          = regexp.MustCompile(`\w+`)
+)
+
+// NotInstalledOnLinux returns the gems a Gemfile declares only for windows
+// platforms, or under an install_if or if which only holds on macOS or
+// windows, either for the gem or for the platforms or install_if block it
+// is in.
+// Bundler locks them like any other gem but never installs them on linux.
+// Conditions which can not be told from the Gemfile's text count as
+// installing the gem.
+func NotInstalledOnLinux(gemfile []byte) []string {
+	var (
+		names []string
+		// blocks has whether each open block excludes its gems
+		blocks []bool
+	)
+	excluded := func() bool {
+		for _, b := range blocks {
+			if b {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, line := range strings.Split(string(Normalize(gemfile)), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 && !strings.ContainsAny(line[:i], `"'`) {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if m := gemLine.FindStringSubmatch(line); m != nil {
+			if excluded() || excludedOptions(m[2]) {
+				names = append(names, m[1])
+			}
+			if blockStart.MatchString(line) {
+				blocks = append(blocks, false)
+			}
+			continue
+		}
+		if m := platformBlock.FindStringSubmatch(line); m != nil {
+			blocks = append(blocks, onlyWindows(m[1]))
+			continue
+		}
+		if m := installIf.FindStringSubmatch(line); m != nil {
+			blocks = append(blocks, otherOS.MatchString(m[1]))
+			continue
+		}
+		if blockEnd.MatchString(line) {
+			if len(blocks) > 0 {
+				blocks = blocks[:len(blocks)-1]
+			}
+			continue
+		}
+		if blockStart.MatchString(line) {
+			blocks = append(blocks, false)
+		}
+	}
+	return names
+}
+
+// excludedOptions reports whether the options of a gem line keep it off
+// linux
+func excludedOptions(options string) bool {
+	if m := platformsOpt.FindStringSubmatch(options); m != nil && onlyWindows(m[1]) {
+		return true
+	}
+	if m := installIfOpt.FindStringSubmatch(options); m != nil && otherOS.MatchString(m[1]+m[2]) {
+		return true
+	}
+	if m := ifModifier.FindStringSubmatch(options); m != nil && otherOS.MatchString(m[1]) {
+		return true
+	}
+	return false
+}
+
+// onlyWindows reports whether every platform in list, symbols or a %i
+// array, is a windows one
+func onlyWindows(list string) bool {
+	platforms := word.FindAllString(strings.Replace(list, "%i", "", -1), -1)
+	if len(platforms) == 0 {
+		return false
+	}
+	for _, platform := range platforms {
+		if !windowsPlatform.MatchString(platform) {
+			return false
+		}
+	}
+	return true
+}
+
+// Without returns the lockfile without the direct dependencies names and
+// the specs only they depend on
+func (l *Lockfile) Without(names []string) *Lockfile {
+	if len(names) == 0 || len(l.Dependencies) == 0 {
+		return l
+	}
+	skip := map[string]bool{}
+	for _, name := range names {
+		skip[name] = true
+	}
+
+	dependencies := map[string][]string{}
+	for _, spec := range l.Specs {
+		for _, dep := range spec.Dependencies {
+			dependencies[spec.Name] = append(dependencies[spec.Name], dep.Name)
+		}
+	}
+	reached := map[string]bool{}
+	var visit func(name string)
+	visit = func(name string) {
+		if reached[name] {
+			return
+		}
+		reached[name] = true
+		for _, dep := range dependencies[name] {
+			visit(dep)
+		}
+	}
+
+	pruned := *l
+	pruned.Dependencies = nil
+	for _, dep := range l.Dependencies {
+		if !skip[dep.Name] {
+			pruned.Dependencies = append(pruned.Dependencies, dep)
+			visit(dep.Name)
+		}
+	}
+	// Bundler is locked without being a dependency of the Gemfile
+	visit("bundler")
+	pruned.Specs = nil
+	for _, spec := range l.Specs {
+		if reached[spec.Name] {
+			pruned.Specs = append(pruned.Specs, spec)
+		}
+	}
+	return &pruned
+}
+
+// ParseInstallable parses the Gemfile.lock at path without the gems its
+// Gemfile, next to it, does not install on linux
+func ParseInstallable(path string) (*Lockfile, error) {
+	lock, err := ParseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	gemfile, err := ioutil.ReadFile(strings.TrimSuffix(path, ".lock"))
+	if os.IsNotExist(err) {
+		return lock, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return lock.Without(NotInstalledOnLinux(gemfile)), nil
+}
@@ -0,0 +1,108 @@
+package lockfile_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/lockfile"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conditional gems", func() {
+	Describe("NotInstalledOnLinux", func() {
+		It("finds gems only declared for windows or macOS", func() {
+			gemfile := `source 'https://rubygems.org'
+gem 'rails', '~> 5.2'
+gem 'tzinfo-data', platforms: [:mingw, :mswin, :x64_mingw, :jruby]
+gem 'wdm', '>= 0.1.0', platforms: %i[mingw x64_mingw]
+gem "win32console", :platforms => :mswin
+gem 'terminal-notifier', install_if: -> { RUBY_PLATFORM =~ /darwin/ }
+gem 'rb-fsevent' if RUBY_PLATFORM =~ /darwin/
+gem 'pg' unless Gem.win_platform?
+
+platforms :mingw, :x64_mingw do # only on windows
+  gem 'win32-service'
+  group :development do
+    gem 'windows-pr'
+  end
+end
+
+install_if -> { RbConfig::CONFIG['host_os'] =~ /darwin/ } do
+  gem 'growl'
+end
+
+group :development, :test do
+  gem 'byebug', platforms: [:mri, :mingw]
+  if ENV['DEBUG']
+    gem 'pry'
+  end
+end
+gem 'puma'
+`
+			Expect(lockfile.NotInstalledOnLinux([]byte(gemfile))).To(Equal([]string{"wdm", "win32console", "terminal-notifier", "rb-fsevent", "win32-service", "windows-pr", "growl"}))
+		})
+
+		It("reads Gemfiles saved on windows", func() {
+			gemfile := "\xef\xbb\xbfplatforms :mswin do\r\n  gem 'win32ole'\r\nend\r\ngem 'rack'\r\n"
+			Expect(lockfile.NotInstalledOnLinux([]byte(gemfile))).To(Equal([]string{"win32ole"}))
+		})
+	})
+
+	Describe("ParseInstallable", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "ruby-buildpack.lockfile.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("leaves out the gems only the windows gems depend on", func() {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "Gemfile"), []byte("gem 'rails'\ngem 'wdm', platforms: :mingw\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "Gemfile.lock"), []byte(strings.Join([]string{
+				"GEM",
+				"  remote: https://rubygems.org/",
+				"  specs:",
+				"    ffi (1.9.25)",
+				"    rack (2.0.5)",
+				"    rails (5.2.0)",
+				"      ffi",
+				"      rack",
+				"    wdm (0.1.1)",
+				"      ffi",
+				"      win32-api",
+				"    win32-api (1.5.3)",
+				"",
+				"PLATFORMS",
+				"  ruby",
+				"",
+				"DEPENDENCIES",
+				"  rails",
+				"  wdm",
+				"",
+				"BUNDLED WITH",
+				"   1.16.3",
+				"",
+			}, "\n")), 0644)).To(Succeed())
+
+			lock, err := lockfile.ParseInstallable(filepath.Join(dir, "Gemfile.lock"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lock.Versions()).To(Equal(map[string]string{"ffi": "1.9.25", "rack": "2.0.5", "rails": "5.2.0"}))
+			Expect(lock.HasDependency("wdm")).To(BeFalse())
+		})
+
+		It("keeps every gem without a Gemfile", func() {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "Gemfile.lock"), []byte("GEM\n  specs:\n    wdm (0.1.1)\n\nDEPENDENCIES\n  wdm\n"), 0644)).To(Succeed())
+			lock, err := lockfile.ParseInstallable(filepath.Join(dir, "Gemfile.lock"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lock.Versions()).To(HaveKey("wdm"))
+		})
+	})
+})
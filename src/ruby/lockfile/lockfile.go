@@ -0,0 +1,407 @@
+package lockfile
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Source is a GEM, GIT, PATH or PLUGIN SOURCE section of a Gemfile.lock
+type Source struct {
+	Type     string
+	Remote   string
+	Revision string
+	Branch   string
+	Tag      string
+	Ref      string
+	Glob     string
+	Options  map[string]string
+}
+
+// Spec is a locked gem, multi platform lockfiles contain one spec for each
+// platform a gem was resolved for
+type Spec struct {
+	Name         string
+	Version      string
+	Platform     string
+	Source       *Source
+	Dependencies []Dependency
+}
+
+// Dependency is a gem requirement, either from the DEPENDENCIES section or
+// from a spec. Pinned dependencies (rack!) come from a GIT or PATH source.
+type Dependency struct {
+	Name         string
+	Requirements []string
+	Pinned       bool
+}
+
+// Checksum is a line of the CHECKSUMS section bundler 2.5 writes, SHA256
+// is empty for gems bundler could not checksum such as git and path gems
+type Checksum struct {
+	Name     string
+	Version  string
+	Platform string
+	SHA256   string
+}
+
+// String is the full name of the gem, as rubygems names its .gem file
+func (c Checksum) String() string {
+	if c.Platform == "" {
+		return c.Name + "-" + c.Version
+	}
+	return c.Name + "-" + c.Version + "-" + c.Platform
+}
+
+type Lockfile struct {
+	Sources      []*Source
+	Specs        []Spec
+	Platforms    []string
+	Dependencies []Dependency
+	Checksums    []Checksum
+	RubyVersion  string
+	BundledWith  string
+	CRLF         bool
+}
+
+var (
+	specLine       = regexp.MustCompile(`^    ([^ ]+) \(([^)]+)\)$`)
+	dependencyLine = regexp.MustCompile(`^ {2}(?: {4})?([^ !]+)(!)?(?: \(([^)]+)\))?$`)
+	rubyVersion    = regexp.MustCompile(`^ruby (\d+(?:\.\d+)*)`)
+	checksumLine   = regexp.MustCompile(`^ {2}([^ ]+) \(([^)]+)\)(?: (\S+))?$`)
+)
+
+// ParseFile parses the Gemfile.lock at path
+func ParseFile(path string) (*Lockfile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+// Parse reads a Gemfile.lock a line at a time, so the size of the lockfile
+// does not matter. Unknown sections are skipped. Lines are cleaned up as by
+// Normalize, only a lockfile saved as UTF-16 is read at once to decode it.
+func Parse(r io.Reader) (*Lockfile, error) {
+	lock := &Lockfile{}
+
+	buffered := bufio.NewReader(r)
+	if bom, _ := buffered.Peek(2); bytes.Equal(bom, utf16LEBOM) || bytes.Equal(bom, utf16BEBOM) {
+		data, err := ioutil.ReadAll(buffered)
+		if err != nil {
+			return nil, err
+		}
+		data = decodeUTF16(data)
+		lock.CRLF = bytes.Contains(data, []byte("\r\n"))
+		r = bytes.NewReader(Normalize(data))
+	} else {
+		r = buffered
+	}
+
+	var (
+		section string
+		source  *Source
+		spec    = -1
+	)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil && advance == len(token)+2 {
+			lock.CRLF = true
+		}
+		return advance, token, err
+	})
+	for scanner.Scan() {
+		line := normalizeLine(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, " ") {
+			section = line
+			source, spec = nil, -1
+			switch section {
+			case "GEM", "GIT", "PATH", "PLUGIN SOURCE":
+				source = &Source{Type: section, Options: map[string]string{}}
+				lock.Sources = append(lock.Sources, source)
+			}
+			continue
+		}
+
+		switch section {
+		case "GEM", "GIT", "PATH", "PLUGIN SOURCE":
+			spec = parseSourceLine(lock, source, spec, line)
+		case "PLATFORMS":
+			lock.Platforms = append(lock.Platforms, strings.TrimSpace(line))
+		case "DEPENDENCIES":
+			if dep, ok := parseDependency(line); ok {
+				lock.Dependencies = append(lock.Dependencies, dep)
+			}
+		case "CHECKSUMS":
+			if checksum, ok := parseChecksum(line); ok {
+				lock.Checksums = append(lock.Checksums, checksum)
+			}
+		case "RUBY VERSION":
+			if m := rubyVersion.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+				lock.RubyVersion = m[1]
+			}
+		case "BUNDLED WITH":
+			lock.BundledWith = strings.TrimSpace(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// parseSourceLine adds a spec, a dependency of the spec at index spec, or an
+// option of source to lock, returning the index of the current spec
+func parseSourceLine(lock *Lockfile, source *Source, spec int, line string) int {
+	if strings.HasPrefix(line, "      ") {
+		if dep, ok := parseDependency(line); ok && spec >= 0 {
+			lock.Specs[spec].Dependencies = append(lock.Specs[spec].Dependencies, dep)
+		}
+		return spec
+	}
+
+	if m := specLine.FindStringSubmatch(line); m != nil {
+		version, platform := m[2], ""
+		if idx := strings.Index(version, "-"); idx > 0 {
+			version, platform = version[:idx], version[idx+1:]
+		}
+		lock.Specs = append(lock.Specs, Spec{Name: m[1], Version: version, Platform: platform, Source: source})
+		return len(lock.Specs) - 1
+	}
+
+	parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+	if len(parts) != 2 || parts[0] == "specs" {
+		return -1
+	}
+	key, value := parts[0], strings.TrimSpace(parts[1])
+	switch key {
+	case "remote":
+		source.Remote = value
+	case "revision":
+		source.Revision = value
+	case "branch":
+		source.Branch = value
+	case "tag":
+		source.Tag = value
+	case "ref":
+		source.Ref = value
+	case "glob":
+		source.Glob = value
+	default:
+		source.Options[key] = value
+	}
+	return -1
+}
+
+// parseChecksum reads "  rack (2.2.8) sha256=...", bundler separates the
+// digests of several algorithms with commas
+func parseChecksum(line string) (Checksum, bool) {
+	m := checksumLine.FindStringSubmatch(line)
+	if m == nil {
+		return Checksum{}, false
+	}
+	checksum := Checksum{Name: m[1], Version: m[2]}
+	if idx := strings.Index(checksum.Version, "-"); idx > 0 {
+		checksum.Version, checksum.Platform = checksum.Version[:idx], checksum.Version[idx+1:]
+	}
+	for _, digest := range strings.Split(m[3], ",") {
+		if strings.HasPrefix(digest, "sha256=") {
+			checksum.SHA256 = strings.TrimPrefix(digest, "sha256=")
+		}
+	}
+	return checksum, true
+}
+
+func parseDependency(line string) (Dependency, bool) {
+	m := dependencyLine.FindStringSubmatch(line)
+	if m == nil {
+		return Dependency{}, false
+	}
+	dep := Dependency{Name: m[1], Pinned: m[2] == "!"}
+	if m[3] != "" {
+		for _, requirement := range strings.Split(m[3], ",") {
+			dep.Requirements = append(dep.Requirements, strings.TrimSpace(requirement))
+		}
+	}
+	return dep, true
+}
+
+// Spec returns the first locked spec for the gem name
+func (l *Lockfile) Spec(name string) (Spec, bool) {
+	for _, spec := range l.Specs {
+		if spec.Name == name {
+			return spec, true
+		}
+	}
+	return Spec{}, false
+}
+
+// Versions maps each locked gem to its version
+func (l *Lockfile) Versions() map[string]string {
+	versions := map[string]string{}
+	for _, spec := range l.Specs {
+		versions[spec.Name] = spec.Version
+	}
+	return versions
+}
+
+// HasDependency reports whether the gem is a direct dependency of the app
+func (l *Lockfile) HasDependency(name string) bool {
+	for _, dep := range l.Dependencies {
+		if dep.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Groups spreads the Gemfile groups of the direct dependencies to the gems
+// they depend on, so every locked gem maps to the sorted groups which
+// bring it in. Gems no group reaches are left out.
+func (l *Lockfile) Groups(direct map[string][]string) map[string][]string {
+	dependencies := map[string][]string{}
+	for _, spec := range l.Specs {
+		for _, dep := range spec.Dependencies {
+			dependencies[spec.Name] = append(dependencies[spec.Name], dep.Name)
+		}
+	}
+
+	seen := map[string]map[string]bool{}
+	var visit func(name, group string)
+	visit = func(name, group string) {
+		if seen[name] == nil {
+			seen[name] = map[string]bool{}
+		}
+		if seen[name][group] {
+			return
+		}
+		seen[name][group] = true
+		for _, dep := range dependencies[name] {
+			visit(dep, group)
+		}
+	}
+	for name, groups := range direct {
+		for _, group := range groups {
+			visit(name, group)
+		}
+	}
+
+	groups := map[string][]string{}
+	for name, set := range seen {
+		for group := range set {
+			groups[name] = append(groups[name], group)
+		}
+		sort.Strings(groups[name])
+	}
+	return groups
+}
+
+// Compiled reports whether bundler compiles the extension of the gem name on
+// arch: it is locked for the ruby platform and not precompiled for arch
+func (l *Lockfile) Compiled(name, arch string) bool {
+	compiled := false
+	for _, spec := range l.Specs {
+		if spec.Name != name {
+			continue
+		}
+		if spec.Platform == "" || spec.Platform == "ruby" {
+			compiled = true
+		} else if MatchesPlatform(spec.Platform, arch) {
+			return false
+		}
+	}
+	return compiled
+}
+
+// HasRubyOrJavaPlatform reports whether PLATFORMS has the ruby platform or
+// a java one. The buildpack treats a lockfile with neither, or no PLATFORMS
+// at all, as generated on Windows and resolves the gems again.
+func (l *Lockfile) HasRubyOrJavaPlatform() bool {
+	for _, platform := range l.Platforms {
+		if _, os, _ := splitPlatform(platform); strings.Contains(platform, "ruby") || os == "java" {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesPlatform reports whether a gem built for platform runs on arch, the
+// RbConfig arch of a ruby, the way rubygems matches them: a universal or
+// missing cpu matches any cpu, and an os version only has to match when
+// both have one. linux and linux-gnu are the same.
+func MatchesPlatform(platform, arch string) bool {
+	cpu, os, version := splitPlatform(platform)
+	archCPU, archOS, archVersion := splitPlatform(arch)
+	if cpu != "" && archCPU != "" && cpu != "universal" && archCPU != "universal" && cpu != archCPU {
+		return false
+	}
+	return os == archOS && (version == "" || archVersion == "" || version == archVersion)
+}
+
+func splitPlatform(platform string) (cpu, os, version string) {
+	parts := strings.SplitN(platform, "-", 3)
+	if len(parts) == 1 {
+		return "", parts[0], ""
+	}
+	cpu, os = parts[0], parts[1]
+	if len(parts) == 3 && parts[2] != "gnu" {
+		version = parts[2]
+	}
+	return cpu, os, version
+}
+
+// ForeignSpecs are the platform specific specs of gems which have no spec
+// for the ruby platform or any of platforms, bundler can not install them
+// there. Lockfiles generated on Apple Silicon lock nokogiri like this.
+func (l *Lockfile) ForeignSpecs(platforms ...string) []Spec {
+	installable := map[string]bool{}
+	for _, spec := range l.Specs {
+		if spec.Platform == "" || spec.Platform == "ruby" || matchesAny(spec.Platform, platforms) {
+			installable[spec.Name+" "+spec.Version] = true
+		}
+	}
+	var foreign []Spec
+	for _, spec := range l.Specs {
+		if !installable[spec.Name+" "+spec.Version] {
+			foreign = append(foreign, spec)
+		}
+	}
+	return foreign
+}
+
+// SupportsPlatform reports whether the PLATFORMS section has the ruby
+// platform or one of platforms. Lockfiles without a PLATFORMS section, as
+// very old bundlers write them, support any platform.
+func (l *Lockfile) SupportsPlatform(platforms ...string) bool {
+	if len(l.Platforms) == 0 {
+		return true
+	}
+	for _, platform := range l.Platforms {
+		if platform == "ruby" || matchesAny(platform, platforms) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(platform string, archs []string) bool {
+	for _, arch := range archs {
+		if MatchesPlatform(platform, arch) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,322 @@
+package lockfile_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/lockfile"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const fixture = `GIT
+  remote: https://github.com/rails/rails.git
+  revision: 4d1d9d6a1b2c3d4e5f60718293a4b5c6d7e8f901
+  branch: main
+  specs:
+    actionpack (7.1.0.alpha)
+      rack (~> 2.2, >= 2.2.4)
+    rails (7.1.0.alpha)
+      actionpack (= 7.1.0.alpha)
+
+PATH
+  remote: engines/billing
+  specs:
+    billing (0.1.0)
+      rails
+
+GEM
+  remote: https://rubygems.org/
+  specs:
+    mini_portile2 (2.8.1)
+    nokogiri (1.14.2)
+      mini_portile2 (~> 2.8.0)
+      racc (~> 1.4)
+    nokogiri (1.14.2-arm64-darwin)
+      racc (~> 1.4)
+    nokogiri (1.14.2-x86_64-linux)
+      racc (~> 1.4)
+    rack (2.2.6.4)
+    racc (1.6.2)
+
+PLATFORMS
+  arm64-darwin-22
+  ruby
+  x86_64-linux
+
+DEPENDENCIES
+  billing!
+  nokogiri (>= 1.14)
+  rails!
+
+CHECKSUMS
+  billing (0.1.0)
+  nokogiri (1.14.2) sha256=abcdef
+  nokogiri (1.14.2-x86_64-linux) sha512=fedcba,sha256=012345
+
+RUBY VERSION
+   ruby 3.2.1p31
+
+BUNDLED WITH
+   2.4.6
+`
+
+var _ = Describe("Lockfile", func() {
+	var lock *lockfile.Lockfile
+
+	BeforeEach(func() {
+		var err error
+		lock, err = lockfile.Parse(strings.NewReader(fixture))
+		Expect(err).To(BeNil())
+	})
+
+	It("parses every source", func() {
+		Expect(lock.Sources).To(HaveLen(3))
+
+		Expect(lock.Sources[0].Type).To(Equal("GIT"))
+		Expect(lock.Sources[0].Remote).To(Equal("https://github.com/rails/rails.git"))
+		Expect(lock.Sources[0].Revision).To(Equal("4d1d9d6a1b2c3d4e5f60718293a4b5c6d7e8f901"))
+		Expect(lock.Sources[0].Branch).To(Equal("main"))
+
+		Expect(lock.Sources[1].Type).To(Equal("PATH"))
+		Expect(lock.Sources[1].Remote).To(Equal("engines/billing"))
+
+		Expect(lock.Sources[2].Type).To(Equal("GEM"))
+		Expect(lock.Sources[2].Remote).To(Equal("https://rubygems.org/"))
+	})
+
+	It("parses the specs of git and path sources", func() {
+		rails, found := lock.Spec("rails")
+		Expect(found).To(BeTrue())
+		Expect(rails.Version).To(Equal("7.1.0.alpha"))
+		Expect(rails.Source.Type).To(Equal("GIT"))
+		Expect(rails.Dependencies).To(Equal([]lockfile.Dependency{{Name: "actionpack", Requirements: []string{"= 7.1.0.alpha"}}}))
+
+		billing, found := lock.Spec("billing")
+		Expect(found).To(BeTrue())
+		Expect(billing.Source.Type).To(Equal("PATH"))
+		Expect(billing.Dependencies).To(Equal([]lockfile.Dependency{{Name: "rails"}}))
+	})
+
+	It("keeps a spec for each platform", func() {
+		var platforms []string
+		for _, spec := range lock.Specs {
+			if spec.Name == "nokogiri" {
+				Expect(spec.Version).To(Equal("1.14.2"))
+				platforms = append(platforms, spec.Platform)
+			}
+		}
+		Expect(platforms).To(Equal([]string{"", "arm64-darwin", "x86_64-linux"}))
+	})
+
+	It("parses requirements with several constraints", func() {
+		actionpack, _ := lock.Spec("actionpack")
+		Expect(actionpack.Dependencies[0].Requirements).To(Equal([]string{"~> 2.2", ">= 2.2.4"}))
+	})
+
+	It("maps gems to versions", func() {
+		Expect(lock.Versions()).To(HaveKeyWithValue("rack", "2.2.6.4"))
+		Expect(lock.Versions()).To(HaveKeyWithValue("nokogiri", "1.14.2"))
+		Expect(lock.Versions()).To(HaveLen(7))
+	})
+
+	It("parses the other sections", func() {
+		Expect(lock.Platforms).To(Equal([]string{"arm64-darwin-22", "ruby", "x86_64-linux"}))
+		Expect(lock.Dependencies).To(Equal([]lockfile.Dependency{
+			{Name: "billing", Pinned: true},
+			{Name: "nokogiri", Requirements: []string{">= 1.14"}},
+			{Name: "rails", Pinned: true},
+		}))
+		Expect(lock.HasDependency("nokogiri")).To(BeTrue())
+		Expect(lock.HasDependency("racc")).To(BeFalse())
+		Expect(lock.Checksums).To(Equal([]lockfile.Checksum{
+			{Name: "billing", Version: "0.1.0"},
+			{Name: "nokogiri", Version: "1.14.2", SHA256: "abcdef"},
+			{Name: "nokogiri", Version: "1.14.2", Platform: "x86_64-linux", SHA256: "012345"},
+		}))
+		Expect(lock.Checksums[2].String()).To(Equal("nokogiri-1.14.2-x86_64-linux"))
+		Expect(lock.RubyVersion).To(Equal("3.2.1"))
+		Expect(lock.BundledWith).To(Equal("2.4.6"))
+		Expect(lock.CRLF).To(BeFalse())
+	})
+
+	It("handles windows line endings", func() {
+		lock, err := lockfile.Parse(strings.NewReader(strings.Replace(fixture, "\n", "\r\n", -1)))
+		Expect(err).To(BeNil())
+		Expect(lock.CRLF).To(BeTrue())
+		Expect(lock.Platforms).To(Equal([]string{"arm64-darwin-22", "ruby", "x86_64-linux"}))
+		Expect(lock.BundledWith).To(Equal("2.4.6"))
+	})
+
+	It("skips a byte order mark and stray whitespace", func() {
+		dirty := "\ufeff" + strings.Replace(strings.Replace(fixture, "ruby\n", "ruby \t\n", 1), "    rack (2.2.6.4)", "    rack\u00a0(2.2.6.4)", 1)
+		lock, err := lockfile.Parse(strings.NewReader(dirty))
+		Expect(err).To(BeNil())
+		Expect(lock.Sources).To(HaveLen(3))
+		Expect(lock.Sources[0].Remote).To(Equal("https://github.com/rails/rails.git"))
+		Expect(lock.Versions()).To(HaveKeyWithValue("rack", "2.2.6.4"))
+		Expect(lock.Platforms).To(ContainElement("ruby"))
+	})
+
+	It("decodes lockfiles saved as UTF-16", func() {
+		for _, bigEndian := range []bool{false, true} {
+			data := []byte{0xff, 0xfe}
+			if bigEndian {
+				data = []byte{0xfe, 0xff}
+			}
+			for _, r := range strings.Replace(fixture, "\n", "\r\n", -1) {
+				if bigEndian {
+					data = append(data, 0, byte(r))
+				} else {
+					data = append(data, byte(r), 0)
+				}
+			}
+			lock, err := lockfile.Parse(bytes.NewReader(data))
+			Expect(err).To(BeNil())
+			Expect(lock.CRLF).To(BeTrue())
+			Expect(lock.Versions()).To(HaveLen(7))
+			Expect(lock.BundledWith).To(Equal("2.4.6"))
+		}
+	})
+
+	Describe("Normalize", func() {
+		It("cleans up a Gemfile saved on windows", func() {
+			gemfile := "\ufeffsource 'https://rubygems.org' \r\nruby\u00a0'2.5.1'\r\n\r\ngem 'rack'\u200b\r"
+			Expect(string(lockfile.Normalize([]byte(gemfile)))).To(Equal("source 'https://rubygems.org'\nruby '2.5.1'\n\ngem 'rack'\n"))
+		})
+
+		It("leaves a clean Gemfile alone", func() {
+			gemfile := "source 'https://rubygems.org'\n\ngem 'rack'\n"
+			Expect(string(lockfile.Normalize([]byte(gemfile)))).To(Equal(gemfile))
+		})
+	})
+
+	It("streams lockfiles of any size", func() {
+		buffer := bytes.NewBufferString("GEM\n  remote: https://rubygems.org/\n  specs:\n")
+		for i := 0; i < 100000; i++ {
+			buffer.WriteString("    gem" + strings.Repeat("x", i%20) + " (1.0.0)\n      rack (>= 0)\n")
+		}
+		lock, err := lockfile.Parse(buffer)
+		Expect(err).To(BeNil())
+		Expect(lock.Specs).To(HaveLen(100000))
+	})
+
+	Describe("Groups", func() {
+		It("gives dependencies the groups of the gems requiring them", func() {
+			groups := lock.Groups(map[string][]string{
+				"rails":    {"default"},
+				"nokogiri": {"default", "test"},
+			})
+			Expect(groups).To(HaveKeyWithValue("rails", []string{"default"}))
+			Expect(groups).To(HaveKeyWithValue("rack", []string{"default"}))
+			Expect(groups).To(HaveKeyWithValue("mini_portile2", []string{"default", "test"}))
+			Expect(groups).ToNot(HaveKey("billing"))
+
+			groups = lock.Groups(map[string][]string{"billing": {"billing"}, "rails": {"default"}})
+			Expect(groups).To(HaveKeyWithValue("actionpack", []string{"billing", "default"}))
+		})
+	})
+
+	Describe("HasRubyOrJavaPlatform", func() {
+		It("is true with the ruby or a java platform", func() {
+			Expect((&lockfile.Lockfile{Platforms: []string{"x64-mingw32", "ruby"}}).HasRubyOrJavaPlatform()).To(BeTrue())
+			Expect((&lockfile.Lockfile{Platforms: []string{"java"}}).HasRubyOrJavaPlatform()).To(BeTrue())
+			Expect((&lockfile.Lockfile{Platforms: []string{"universal-java-11"}}).HasRubyOrJavaPlatform()).To(BeTrue())
+		})
+
+		It("is false without, even for linux platforms", func() {
+			Expect((&lockfile.Lockfile{Platforms: []string{"x86-mingw32", "x64-mingw32", "x86-mswin32"}}).HasRubyOrJavaPlatform()).To(BeFalse())
+			Expect((&lockfile.Lockfile{Platforms: []string{"x86_64-linux"}}).HasRubyOrJavaPlatform()).To(BeFalse())
+			Expect((&lockfile.Lockfile{}).HasRubyOrJavaPlatform()).To(BeFalse())
+		})
+	})
+
+	Describe("MatchesPlatform", func() {
+		It("matches platforms the way rubygems does", func() {
+			Expect(lockfile.MatchesPlatform("x86_64-linux", "x86_64-linux")).To(BeTrue())
+			Expect(lockfile.MatchesPlatform("x86_64-linux-gnu", "x86_64-linux")).To(BeTrue())
+			Expect(lockfile.MatchesPlatform("universal-java-1.8", "java")).To(BeTrue())
+			Expect(lockfile.MatchesPlatform("java", "universal-java-11")).To(BeTrue())
+
+			Expect(lockfile.MatchesPlatform("arm64-darwin", "x86_64-linux")).To(BeFalse())
+			Expect(lockfile.MatchesPlatform("aarch64-linux", "x86_64-linux")).To(BeFalse())
+			Expect(lockfile.MatchesPlatform("x86_64-linux-musl", "x86_64-linux-gnu")).To(BeTrue())
+			Expect(lockfile.MatchesPlatform("x86_64-darwin-21", "x86_64-darwin-22")).To(BeFalse())
+		})
+	})
+
+	Describe("ForeignSpecs", func() {
+		var lock *lockfile.Lockfile
+
+		BeforeEach(func() {
+			var err error
+			lock, err = lockfile.Parse(strings.NewReader(`GEM
+  remote: https://rubygems.org/
+  specs:
+    ffi (1.15.5)
+    ffi (1.15.5-arm64-darwin)
+    nokogiri (1.13.10-arm64-darwin)
+      racc (~> 1.4)
+    nokogiri (1.13.10-x86_64-darwin)
+      racc (~> 1.4)
+    racc (1.6.2)
+    sqlite3 (1.6.0-x86_64-linux)
+
+PLATFORMS
+  arm64-darwin-21
+  x86_64-darwin-21
+`))
+			Expect(err).To(BeNil())
+		})
+
+		It("lists the gems locked only for other platforms", func() {
+			var names []string
+			for _, spec := range lock.ForeignSpecs("x86_64-linux") {
+				names = append(names, spec.Name+"-"+spec.Version+"-"+spec.Platform)
+			}
+			Expect(names).To(Equal([]string{"nokogiri-1.13.10-arm64-darwin", "nokogiri-1.13.10-x86_64-darwin"}))
+		})
+
+		It("has none when every gem has a build for the platform", func() {
+			Expect(lock.ForeignSpecs("x86_64-darwin")).To(HaveLen(1))
+			Expect(lock.ForeignSpecs("x86_64-darwin", "x86_64-linux")).To(BeEmpty())
+		})
+
+		It("checks the staging platform against PLATFORMS", func() {
+			Expect(lock.SupportsPlatform("x86_64-linux")).To(BeFalse())
+			Expect(lock.SupportsPlatform("x86_64-linux", "arm64-darwin-21")).To(BeTrue())
+			lock.Platforms = append(lock.Platforms, "ruby")
+			Expect(lock.SupportsPlatform("x86_64-linux")).To(BeTrue())
+			Expect((&lockfile.Lockfile{}).SupportsPlatform("x86_64-linux")).To(BeTrue())
+		})
+
+		It("compiles the gems locked for the ruby platform and not for the staging one", func() {
+			Expect(lock.Compiled("ffi", "x86_64-linux")).To(BeTrue())
+			Expect(lock.Compiled("ffi", "arm64-darwin-21")).To(BeFalse())
+			Expect(lock.Compiled("sqlite3", "x86_64-linux")).To(BeFalse())
+			Expect(lock.Compiled("racc", "x86_64-linux")).To(BeTrue())
+			Expect(lock.Compiled("missing", "x86_64-linux")).To(BeFalse())
+		})
+	})
+
+	Describe("ParseFile", func() {
+		It("reads the file", func() {
+			dir, err := ioutil.TempDir("", "ruby-buildpack.lockfile.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			Expect(ioutil.WriteFile(filepath.Join(dir, "Gemfile.lock"), []byte(fixture), 0644)).To(Succeed())
+
+			lock, err := lockfile.ParseFile(filepath.Join(dir, "Gemfile.lock"))
+			Expect(err).To(BeNil())
+			Expect(lock.Specs).To(HaveLen(9))
+		})
+
+		It("returns an error when there is no file", func() {
+			_, err := lockfile.ParseFile("/does/not/exist/Gemfile.lock")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+})
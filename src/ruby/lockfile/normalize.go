@@ -0,0 +1,65 @@
+package lockfile
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+)
+
+// unusualSpace replaces the spaces editors and copy and paste from web pages
+// put in Gemfiles, ruby only takes ASCII whitespace as a separator
+var unusualSpace = strings.NewReplacer(
+	"\u00a0", " ", "\u2002", " ", "\u2003", " ", "\u2009", " ", "\u202f", " ", "\u3000", " ",
+	"\u200b", "", "\ufeff", "",
+)
+
+// Normalize turns a Gemfile or Gemfile.lock saved on windows into what
+// bundler writes on linux: UTF-16 is decoded, the byte order mark dropped,
+// line endings become \n and unusual spaces and trailing whitespace are
+// cleaned up. Without this the BOM ends up in front of the first word of
+// the file and the ruby directive or first section is not recognized.
+func Normalize(data []byte) []byte {
+	data = decodeUTF16(data)
+	data = bytes.TrimPrefix(data, utf8BOM)
+	data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	data = bytes.Replace(data, []byte("\r"), []byte("\n"), -1)
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		lines[i] = normalizeLine(line)
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func normalizeLine(line string) string {
+	return strings.TrimRight(unusualSpace.Replace(line), " \t")
+}
+
+// decodeUTF16 decodes data starting with a UTF-16 byte order mark, as
+// notepad saves "Unicode" files, other data is returned as is
+func decodeUTF16(data []byte) []byte {
+	var bigEndian bool
+	switch {
+	case bytes.HasPrefix(data, utf16LEBOM):
+	case bytes.HasPrefix(data, utf16BEBOM):
+		bigEndian = true
+	default:
+		return data
+	}
+
+	units := make([]uint16, 0, len(data)/2)
+	for i := 2; i+1 < len(data); i += 2 {
+		if bigEndian {
+			units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+		} else {
+			units = append(units, uint16(data[i+1])<<8|uint16(data[i]))
+		}
+	}
+	return []byte(string(utf16.Decode(units)))
+}
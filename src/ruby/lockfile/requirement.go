@@ -0,0 +1,54 @@
+package lockfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// ParseRequirement converts a rubygems requirement such as "~> 2.5, >= 2.5.1"
+// into semver constraints
+func ParseRequirement(requirement string) (*semver.Constraints, error) {
+	var parts []string
+	for _, part := range strings.Split(requirement, ",") {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "~>") {
+			version := strings.TrimSpace(strings.TrimPrefix(part, "~>"))
+			upper, err := pessimisticUpperBound(version)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, ">= "+version, "< "+upper)
+		} else {
+			parts = append(parts, part)
+		}
+	}
+	return semver.NewConstraint(strings.Join(parts, ", "))
+}
+
+// pessimisticUpperBound returns the exclusive upper bound of "~> version",
+// e.g. 2.5 gives 3.0 and 2.5.1 gives 2.6
+func pessimisticUpperBound(version string) (string, error) {
+	segments := strings.Split(version, ".")
+	if len(segments) > 1 {
+		segments = segments[:len(segments)-1]
+	}
+	last, err := strconv.Atoi(segments[len(segments)-1])
+	if err != nil {
+		return "", fmt.Errorf("invalid version %s", version)
+	}
+	segments[len(segments)-1] = strconv.Itoa(last + 1)
+	return strings.Join(segments, "."), nil
+}
+
+// ParseVersion accepts gem versions with more than three segments by
+// ignoring the extra ones
+func ParseVersion(version string) (*semver.Version, error) {
+	segments := strings.Split(version, ".")
+	if len(segments) > 3 {
+		version = strings.Join(segments[:3], ".")
+	}
+	return semver.NewVersion(version)
+}
@@ -0,0 +1,169 @@
+// Package metrics renders the staging report's measurements in the
+// prometheus text exposition format, so node exporters' textfile collectors
+// or a sidecar can scrape build metrics from every droplet.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"ruby/diskspace"
+	"ruby/report"
+)
+
+// File is where finalize writes the metrics, relative to the app directory
+const File = ".staging_metrics.prom"
+
+// Render formats the metrics of r together with the droplet sizes, keyed by
+// the directory they were measured for
+func Render(r *report.Report, sizes map[string]int64) string {
+	out := &bytes.Buffer{}
+	metrics := r.Metrics
+	if metrics == nil {
+		metrics = &report.Metrics{}
+	}
+
+	if len(metrics.Durations) > 0 {
+		header(out, "ruby_buildpack_staging_duration_seconds", "Seconds each staging phase took", "gauge")
+		for _, phase := range sortedKeys(metrics.Durations) {
+			fmt.Fprintf(out, "ruby_buildpack_staging_duration_seconds{phase=%q} %s\n", phase, strconv.FormatFloat(metrics.Durations[phase], 'f', 3, 64))
+		}
+	}
+
+	if len(metrics.CacheHits) > 0 {
+		header(out, "ruby_buildpack_cache_hit", "Whether a cached directory was restored from the app cache", "gauge")
+		for _, name := range sortedKeys(metrics.CacheHits) {
+			hit := 0
+			if metrics.CacheHits[name] {
+				hit = 1
+			}
+			fmt.Fprintf(out, "ruby_buildpack_cache_hit{dir=%q} %d\n", name, hit)
+		}
+	}
+
+	if len(metrics.Components) > 0 {
+		header(out, "ruby_buildpack_component_duration_seconds", "Seconds installing each component took, by whether it started warm or cold", "gauge")
+		for _, name := range sortedKeys(metrics.Components) {
+			component := metrics.Components[name]
+			fmt.Fprintf(out, "ruby_buildpack_component_duration_seconds{component=%q,start=%q} %s\n", name, start(component), strconv.FormatFloat(component.Seconds, 'f', 3, 64))
+		}
+	}
+
+	if len(metrics.Resources) > 0 {
+		header(out, "ruby_buildpack_staging_cpu_seconds", "CPU seconds the buildpack's process tree used in each staging phase", "gauge")
+		for _, phase := range sortedKeys(metrics.Resources) {
+			fmt.Fprintf(out, "ruby_buildpack_staging_cpu_seconds{phase=%q} %s\n", phase, strconv.FormatFloat(metrics.Resources[phase].CPUSeconds, 'f', 2, 64))
+		}
+		header(out, "ruby_buildpack_staging_io_bytes", "Bytes the buildpack's process tree read from and wrote to storage in each staging phase", "gauge")
+		for _, phase := range sortedKeys(metrics.Resources) {
+			usage := metrics.Resources[phase]
+			fmt.Fprintf(out, "ruby_buildpack_staging_io_bytes{phase=%q,direction=\"read\"} %d\n", phase, usage.ReadBytes)
+			fmt.Fprintf(out, "ruby_buildpack_staging_io_bytes{phase=%q,direction=\"write\"} %d\n", phase, usage.WriteBytes)
+		}
+	}
+
+	if len(sizes) > 0 {
+		header(out, "ruby_buildpack_droplet_size_bytes", "Bytes staged into each droplet directory", "gauge")
+		for _, dir := range sortedKeys(sizes) {
+			fmt.Fprintf(out, "ruby_buildpack_droplet_size_bytes{dir=%q} %d\n", dir, sizes[dir])
+		}
+	}
+
+	if r.Contents != nil {
+		header(out, "ruby_buildpack_gems_installed", "Gems installed into the droplet", "gauge")
+		fmt.Fprintf(out, "ruby_buildpack_gems_installed %d\n", len(r.Contents.Gems))
+	}
+
+	return out.String()
+}
+
+// PrintBreakdown writes how long restoring the cache and installing each
+// component took, and the totals for warm and cold components, so the time
+// the cache saves and components which never start warm stand out
+func PrintBreakdown(w io.Writer, r *report.Report) {
+	if r.Metrics == nil {
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if seconds, found := r.Metrics.Durations["restore_cache"]; found {
+		fmt.Fprintf(tw, "cache restore\t%.1fs\n", seconds)
+	}
+	var warm, cold int
+	var warmSeconds, coldSeconds float64
+	for _, name := range sortedKeys(r.Metrics.Components) {
+		component := r.Metrics.Components[name]
+		fmt.Fprintf(tw, "%s\t%.1fs\t%s\n", name, component.Seconds, start(component))
+		if component.Warm {
+			warm++
+			warmSeconds += component.Seconds
+		} else {
+			cold++
+			coldSeconds += component.Seconds
+		}
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d warm in %.1fs, %d cold in %.1fs\n", warm, warmSeconds, cold, coldSeconds)
+}
+
+// PrintResources writes the wall time, CPU time and disk IO of each staging
+// phase, a CPU time well above the wall time shows a phase which uses more
+// than one CPU of the staging container
+func PrintResources(w io.Writer, r *report.Report) {
+	if r.Metrics == nil || len(r.Metrics.Resources) == 0 {
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "phase\twall\tcpu\tread\twrite\n")
+	for _, phase := range sortedKeys(r.Metrics.Resources) {
+		usage := r.Metrics.Resources[phase]
+		fmt.Fprintf(tw, "%s\t%.1fs\t%.1fs\t%s\t%s\n", phase, r.Metrics.Durations[phase], usage.CPUSeconds, diskspace.HumanBytes(usage.ReadBytes), diskspace.HumanBytes(usage.WriteBytes))
+	}
+	tw.Flush()
+}
+
+func start(component report.Component) string {
+	if component.Warm {
+		return "warm"
+	}
+	return "cold"
+}
+
+// Size is the number of bytes of the regular files below dir, symlinks are
+// not followed
+func Size(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+func header(out *bytes.Buffer, name, help, kind string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sortedKeys keeps the output stable between stagings, m must be a map
+// with string keys
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, key := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,133 @@
+package metrics_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/metrics"
+	"ruby/procstat"
+	"ruby/report"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	Describe("Render", func() {
+		It("renders the staging metrics in prometheus text format", func() {
+			r := &report.Report{
+				Metrics: &report.Metrics{
+					Durations: map[string]float64{"supply": 42.1234, "install_gems": 30.5},
+					CacheHits: map[string]bool{"vendor_bundle": true, "node_modules": false},
+				},
+				Contents: &report.Contents{Gems: []report.Gem{{Name: "rack"}, {Name: "puma"}}},
+			}
+
+			Expect(metrics.Render(r, map[string]int64{"app": 1024, "deps": 2048})).To(Equal(`# HELP ruby_buildpack_staging_duration_seconds Seconds each staging phase took
+# TYPE ruby_buildpack_staging_duration_seconds gauge
+ruby_buildpack_staging_duration_seconds{phase="install_gems"} 30.500
+ruby_buildpack_staging_duration_seconds{phase="supply"} 42.123
+# HELP ruby_buildpack_cache_hit Whether a cached directory was restored from the app cache
+# TYPE ruby_buildpack_cache_hit gauge
+ruby_buildpack_cache_hit{dir="node_modules"} 0
+ruby_buildpack_cache_hit{dir="vendor_bundle"} 1
+# HELP ruby_buildpack_droplet_size_bytes Bytes staged into each droplet directory
+# TYPE ruby_buildpack_droplet_size_bytes gauge
+ruby_buildpack_droplet_size_bytes{dir="app"} 1024
+ruby_buildpack_droplet_size_bytes{dir="deps"} 2048
+# HELP ruby_buildpack_gems_installed Gems installed into the droplet
+# TYPE ruby_buildpack_gems_installed gauge
+ruby_buildpack_gems_installed 2
+`))
+		})
+
+		It("renders component durations by how they started", func() {
+			r := &report.Report{Metrics: &report.Metrics{Components: map[string]report.Component{"gems": {Seconds: 12.5, Warm: true}, "assets": {Seconds: 30}}}}
+			Expect(metrics.Render(r, nil)).To(Equal(`# HELP ruby_buildpack_component_duration_seconds Seconds installing each component took, by whether it started warm or cold
+# TYPE ruby_buildpack_component_duration_seconds gauge
+ruby_buildpack_component_duration_seconds{component="assets",start="cold"} 30.000
+ruby_buildpack_component_duration_seconds{component="gems",start="warm"} 12.500
+`))
+		})
+
+		It("renders the CPU time and IO of each phase", func() {
+			r := &report.Report{Metrics: &report.Metrics{Resources: map[string]procstat.Usage{"install_gems": {CPUSeconds: 81.25, ReadBytes: 4096, WriteBytes: 1 << 20}}}}
+			Expect(metrics.Render(r, nil)).To(Equal(`# HELP ruby_buildpack_staging_cpu_seconds CPU seconds the buildpack's process tree used in each staging phase
+# TYPE ruby_buildpack_staging_cpu_seconds gauge
+ruby_buildpack_staging_cpu_seconds{phase="install_gems"} 81.25
+# HELP ruby_buildpack_staging_io_bytes Bytes the buildpack's process tree read from and wrote to storage in each staging phase
+# TYPE ruby_buildpack_staging_io_bytes gauge
+ruby_buildpack_staging_io_bytes{phase="install_gems",direction="read"} 4096
+ruby_buildpack_staging_io_bytes{phase="install_gems",direction="write"} 1048576
+`))
+		})
+
+		It("leaves out what was not measured", func() {
+			Expect(metrics.Render(&report.Report{}, nil)).To(BeEmpty())
+		})
+	})
+
+	Describe("PrintBreakdown", func() {
+		It("lists the cache restore and each component with the warm and cold totals", func() {
+			r := &report.Report{Metrics: &report.Metrics{
+				Durations:  map[string]float64{"restore_cache": 1.5, "supply": 60},
+				Components: map[string]report.Component{"gems": {Seconds: 12.5, Warm: true}, "ruby": {Seconds: 0.5, Warm: true}, "assets": {Seconds: 30}},
+			}}
+			out := &bytes.Buffer{}
+			metrics.PrintBreakdown(out, r)
+			Expect(out.String()).To(Equal(`cache restore  1.5s
+assets         30.0s  cold
+gems           12.5s  warm
+ruby           0.5s   warm
+2 warm in 13.0s, 1 cold in 30.0s
+`))
+		})
+	})
+
+	Describe("PrintResources", func() {
+		It("lists the wall time, CPU time and IO of each phase", func() {
+			r := &report.Report{Metrics: &report.Metrics{
+				Durations: map[string]float64{"install_gems": 40, "supply": 60},
+				Resources: map[string]procstat.Usage{
+					"install_gems": {CPUSeconds: 81.25, ReadBytes: 4096, WriteBytes: 300 << 20},
+					"supply":       {CPUSeconds: 95, ReadBytes: 1 << 20, WriteBytes: 400 << 20},
+				},
+			}}
+			out := &bytes.Buffer{}
+			metrics.PrintResources(out, r)
+			Expect(out.String()).To(Equal(`phase         wall   cpu    read  write
+install_gems  40.0s  81.2s  4.0K  300.0M
+supply        60.0s  95.0s  1.0M  400.0M
+`))
+		})
+
+		It("prints nothing when the resources were not measured", func() {
+			out := &bytes.Buffer{}
+			metrics.PrintResources(out, &report.Report{Metrics: &report.Metrics{}})
+			Expect(out.String()).To(BeEmpty())
+		})
+	})
+
+	Describe("Size", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "ruby-buildpack.metrics.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("adds up the regular files", func() {
+			Expect(os.MkdirAll(filepath.Join(dir, "lib"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "lib", "app.rb"), []byte("12345"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "Gemfile"), []byte("123"), 0644)).To(Succeed())
+			Expect(os.Symlink(filepath.Join(dir, "Gemfile"), filepath.Join(dir, "Gemfile.link"))).To(Succeed())
+			Expect(metrics.Size(dir)).To(Equal(int64(8)))
+		})
+	})
+})
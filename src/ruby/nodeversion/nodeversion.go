@@ -0,0 +1,282 @@
+// Package nodeversion works out which node a rails app needs. Besides
+// engines.node in package.json, an app states it in config/webpacker.yml,
+// in the node targets of its babel and webpack config and, through the
+// engines of @rails/webpacker, in the webpacker it installed. Webpacker
+// refuses to compile with a node outside its engines, so installing the
+// newest node regardless only moves the failure to assets:precompile.
+package nodeversion
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"ruby/lockfile"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Order describes which hint wins when two of them conflict
+const Order = "package.json engines.node, then the engines of the installed @rails/webpacker, then node_version in config/webpacker.yml, then the node targets of the babel and webpack config"
+
+var (
+	nodeTarget   = regexp.MustCompile(`targets['"]?\s*:\s*\{[^}]*?\bnode['"]?\s*:\s*['"]?v?([0-9]+(\.[0-9]+){0,2})\b`)
+	npmOperator  = regexp.MustCompile(`^(<=|>=|=<|=>|<|>|=|~>|~|\^|!=)$`)
+	partialBound = regexp.MustCompile(`^(<|>)v?([0-9]+(\.[0-9]+)?)$`)
+)
+
+// Hint is a node version constraint stated in one place of the app
+type Hint struct {
+	Source      string
+	Constraint  string
+	constraints *semver.Constraints
+}
+
+func (h Hint) String() string {
+	return fmt.Sprintf("%s from %s", h.Constraint, h.Source)
+}
+
+// Conflict is a hint which was ignored because it rules out every version
+// allowed by the hints with a higher priority
+type Conflict struct {
+	Ignored Hint
+	Kept    []Hint
+}
+
+func (c Conflict) String() string {
+	var kept []string
+	for _, hint := range c.Kept {
+		kept = append(kept, hint.String())
+	}
+	return fmt.Sprintf("node %s conflicts with %s", c.Ignored, strings.Join(kept, " and "))
+}
+
+// Hints returns the node constraints stated by the app in buildDir, in the
+// order given by Order. Constraints which cannot be parsed are errors, the
+// app would fail later on them as well.
+func Hints(buildDir string) ([]Hint, error) {
+	var hints []Hint
+
+	for _, pkg := range []struct{ source, path string }{
+		{"package.json engines.node", "package.json"},
+		{"@rails/webpacker engines.node", filepath.Join("node_modules", "@rails", "webpacker", "package.json")},
+	} {
+		constraint, err := engines(filepath.Join(buildDir, pkg.path))
+		if err != nil {
+			return nil, err
+		} else if constraint != "" {
+			hint, err := npmHint(pkg.source, constraint)
+			if err != nil {
+				return nil, err
+			}
+			hints = append(hints, hint)
+		}
+	}
+
+	if constraint, err := webpackerNodeVersion(filepath.Join(buildDir, "config", "webpacker.yml")); err != nil {
+		return nil, err
+	} else if constraint != "" {
+		// webpacker.yml is read by ruby, so ~> is pessimistic as in a Gemfile
+		constraints, err := lockfile.ParseRequirement(constraint)
+		if err != nil {
+			return nil, fmt.Errorf("node_version in config/webpacker.yml is not a valid requirement: %s", constraint)
+		}
+		hints = append(hints, Hint{Source: "config/webpacker.yml node_version", Constraint: constraint, constraints: constraints})
+	}
+
+	targets, err := targetHints(buildDir)
+	if err != nil {
+		return nil, err
+	}
+	return append(hints, targets...), nil
+}
+
+// Resolve returns the newest of versions allowed by the hints. Hints are
+// applied in order and one which would rule out every version left is
+// ignored and returned as a conflict. Only when the first hint matches
+// none of the versions is an error returned. Without hints version is empty.
+func Resolve(hints []Hint, versions []string) (version string, conflicts []Conflict, err error) {
+	if len(hints) == 0 {
+		return "", nil, nil
+	}
+
+	var kept []Hint
+	for _, hint := range hints {
+		if newest(append(kept, hint), versions) == "" {
+			if len(kept) == 0 {
+				return "", nil, fmt.Errorf("no node version in the buildpack matches %s, the buildpack has %s", hint, strings.Join(versions, ", "))
+			}
+			conflicts = append(conflicts, Conflict{Ignored: hint, Kept: append([]Hint{}, kept...)})
+			continue
+		}
+		kept = append(kept, hint)
+	}
+	return newest(kept, versions), conflicts, nil
+}
+
+func newest(hints []Hint, versions []string) string {
+	var matching []*semver.Version
+	for _, version := range versions {
+		v, err := semver.NewVersion(version)
+		if err != nil {
+			continue
+		}
+		ok := true
+		for _, hint := range hints {
+			if !hint.constraints.Check(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matching = append(matching, v)
+		}
+	}
+	if len(matching) == 0 {
+		return ""
+	}
+	sort.Sort(semver.Collection(matching))
+	return matching[len(matching)-1].Original()
+}
+
+// npmHint parses an npm range, where comparators are separated by spaces
+// rather than the commas semver expects
+func npmHint(source, constraint string) (Hint, error) {
+	var groups []string
+	for _, group := range strings.Split(constraint, "||") {
+		if strings.Contains(group, " - ") {
+			groups = append(groups, group)
+			continue
+		}
+		var comparators []string
+		pending := ""
+		for _, field := range strings.Fields(group) {
+			if npmOperator.MatchString(field) {
+				pending += field
+				continue
+			}
+			comparators = append(comparators, npmComparator(pending+field))
+			pending = ""
+		}
+		if pending != "" {
+			comparators = append(comparators, pending)
+		}
+		if len(comparators) == 0 {
+			comparators = []string{"*"}
+		}
+		groups = append(groups, strings.Join(comparators, ", "))
+	}
+
+	constraints, err := semver.NewConstraint(strings.Join(groups, " || "))
+	if err != nil {
+		return Hint{}, fmt.Errorf("%s is not a valid version range: %s", source, constraint)
+	}
+	return Hint{Source: source, Constraint: constraint, constraints: constraints}, nil
+}
+
+// npmComparator rewrites < and > against a partial version the way npm
+// reads them: <10 excludes all of 10.x and >8.11 starts at 8.12.0, where
+// semver would include 10.x and 8.11.1
+func npmComparator(comparator string) string {
+	match := partialBound.FindStringSubmatch(comparator)
+	if match == nil {
+		return comparator
+	}
+
+	parts := strings.Split(match[2], ".")
+	if match[1] == ">" {
+		last, _ := strconv.Atoi(parts[len(parts)-1])
+		parts[len(parts)-1] = strconv.Itoa(last + 1)
+	}
+	for len(parts) < 3 {
+		parts = append(parts, "0")
+	}
+	if match[1] == ">" {
+		return ">=" + strings.Join(parts, ".")
+	}
+	return "<" + strings.Join(parts, ".")
+}
+
+func engines(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	var pkg struct {
+		Engines struct {
+			Node string `json:"node"`
+		} `json:"engines"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return "", fmt.Errorf("%s is invalid: %v", path, err)
+	}
+	return strings.TrimSpace(pkg.Engines.Node), nil
+}
+
+// webpackerNodeVersion reads node_version from the production environment
+// of webpacker.yml, which inherits from default through the usual anchor
+func webpackerNodeVersion(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return "", fmt.Errorf("config/webpacker.yml is invalid: %v", err)
+	}
+	for _, env := range []string{"production", "default"} {
+		if settings, ok := config[env].(map[interface{}]interface{}); ok && settings["node_version"] != nil {
+			return strings.TrimSpace(fmt.Sprint(settings["node_version"])), nil
+		}
+	}
+	return "", nil
+}
+
+// targetHints returns the node targets of babel's preset-env found in the
+// babel config and in babel-loader options of the webpack config. Code
+// compiled for node 8.10 needs at least that node to run the build.
+func targetHints(buildDir string) ([]Hint, error) {
+	files := []string{".babelrc", ".babelrc.js", "babel.config.js", "webpack.config.js"}
+	webpack, err := filepath.Glob(filepath.Join(buildDir, "config", "webpack", "*.js"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range webpack {
+		rel, err := filepath.Rel(buildDir, path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, rel)
+	}
+
+	var hints []Hint
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(buildDir, file))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, match := range nodeTarget.FindAllSubmatch(data, -1) {
+			hint, err := npmHint(file+" targets.node", ">="+string(match[1]))
+			if err != nil {
+				return nil, err
+			}
+			hints = append(hints, hint)
+		}
+	}
+	return hints, nil
+}
@@ -0,0 +1,13 @@
+package nodeversion_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestNodeversion(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Nodeversion Suite")
+}
@@ -0,0 +1,118 @@
+package nodeversion_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/nodeversion"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Nodeversion", func() {
+	var buildDir string
+	versions := []string{"6.14.3", "8.11.3", "8.12.0", "10.9.0"}
+
+	BeforeEach(func() {
+		var err error
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.nodeversion.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	write := func(path, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, path)), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, path), []byte(contents), 0644)).To(Succeed())
+	}
+
+	resolve := func() (string, []nodeversion.Conflict, error) {
+		hints, err := nodeversion.Hints(buildDir)
+		Expect(err).To(BeNil())
+		return nodeversion.Resolve(hints, versions)
+	}
+
+	It("has no hints for an app which states no node version", func() {
+		write("package.json", `{"dependencies": {"@rails/webpacker": "^3.2.1"}}`)
+		write(".babelrc", `{"presets": [["env", {"targets": {"browsers": "> 1%"}}]]}`)
+		hints, err := nodeversion.Hints(buildDir)
+		Expect(err).To(BeNil())
+		Expect(hints).To(BeEmpty())
+	})
+
+	It("reads npm ranges from engines", func() {
+		write("package.json", `{"engines": {"node": ">= 8 < 10"}}`)
+		version, conflicts, err := resolve()
+		Expect(err).To(BeNil())
+		Expect(conflicts).To(BeEmpty())
+		Expect(version).To(Equal("8.12.0"))
+	})
+
+	It("reads < and > against a partial version like npm", func() {
+		write("package.json", `{"engines": {"node": ">6 <10"}}`)
+		version, _, err := resolve()
+		Expect(err).To(BeNil())
+		Expect(version).To(Equal("8.12.0"))
+
+		write("package.json", `{"engines": {"node": ">10"}}`)
+		_, _, err = resolve()
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("treats ~> in webpacker.yml as pessimistic", func() {
+		write("config/webpacker.yml", "default: &default\n  node_version: '~> 8.11'\nproduction:\n  <<: *default\n")
+		version, _, err := resolve()
+		Expect(err).To(BeNil())
+		Expect(version).To(Equal("8.12.0"))
+
+		write("config/webpacker.yml", "production:\n  node_version: '~> 8.11.0'\n")
+		version, _, err = resolve()
+		Expect(err).To(BeNil())
+		Expect(version).To(Equal("8.11.3"))
+	})
+
+	It("reads the node targets of babel and webpack", func() {
+		write("babel.config.js", "module.exports = { presets: [['@babel/preset-env', { targets: { node: '8.12' } }]] }\n")
+		write("config/webpack/environment.js", "loader.options.presets = [['env', { targets: { browsers: '> 1%', node: 6 } }]]\n")
+		hints, err := nodeversion.Hints(buildDir)
+		Expect(err).To(BeNil())
+		Expect(hints).To(HaveLen(2))
+		Expect(hints[0].String()).To(Equal(">=8.12 from babel.config.js targets.node"))
+		Expect(hints[1].Source).To(Equal(filepath.Join("config", "webpack", "environment.js") + " targets.node"))
+	})
+
+	It("narrows engines with the engines of the installed webpacker", func() {
+		write("package.json", `{"engines": {"node": "6 || 8 || 10"}}`)
+		write("node_modules/@rails/webpacker/package.json", `{"engines": {"node": ">=6.0.0 <10"}}`)
+		version, conflicts, err := resolve()
+		Expect(err).To(BeNil())
+		Expect(conflicts).To(BeEmpty())
+		Expect(version).To(Equal("8.12.0"))
+	})
+
+	It("ignores hints which conflict with engines", func() {
+		write("package.json", `{"engines": {"node": "^8.11"}}`)
+		write("config/webpacker.yml", "production:\n  node_version: '>= 10'\n")
+		write(".babelrc", `{"presets": [["env", {"targets": {"node": "8.11"}}]]}`)
+		version, conflicts, err := resolve()
+		Expect(err).To(BeNil())
+		Expect(version).To(Equal("8.12.0"))
+		Expect(conflicts).To(HaveLen(1))
+		Expect(conflicts[0].String()).To(Equal("node >= 10 from config/webpacker.yml node_version conflicts with ^8.11 from package.json engines.node"))
+	})
+
+	It("fails when engines matches no node in the buildpack", func() {
+		write("package.json", `{"engines": {"node": "~4.2"}}`)
+		_, _, err := resolve()
+		Expect(err).To(MatchError("no node version in the buildpack matches ~4.2 from package.json engines.node, the buildpack has 6.14.3, 8.11.3, 8.12.0, 10.9.0"))
+	})
+
+	It("fails on a range it cannot parse", func() {
+		write("package.json", `{"engines": {"node": ">= eight"}}`)
+		_, err := nodeversion.Hints(buildDir)
+		Expect(err).To(MatchError("package.json engines.node is not a valid version range: >= eight"))
+	})
+})
@@ -0,0 +1,179 @@
+// Package outdated compares the gems locked in a Gemfile.lock with a
+// snapshot of the rubygems index shipped in the buildpack, so staging can
+// point out outdated and vulnerable gems without reaching the network.
+package outdated
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"ruby/lockfile"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// IndexFile is the snapshot, relative to the buildpack directory
+const IndexFile = "gem_index.yml"
+
+// Advisory is a published vulnerability, versions matching one of the
+// Patched requirements are not affected
+type Advisory struct {
+	ID      string   `yaml:"id"`
+	Title   string   `yaml:"title"`
+	Patched []string `yaml:"patched"`
+}
+
+type Entry struct {
+	Latest     string     `yaml:"latest"`
+	Advisories []Advisory `yaml:"advisories"`
+}
+
+// Index is the newest release of each gem and its advisories as of Date
+type Index struct {
+	Date string           `yaml:"date"`
+	Gems map[string]Entry `yaml:"gems"`
+}
+
+// LoadIndex reads the snapshot at path, nil is returned when the buildpack
+// does not ship one
+func LoadIndex(path string) (*Index, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	index := &Index{}
+	if err := yaml.UnmarshalStrict(data, index); err != nil {
+		return nil, fmt.Errorf("%s is invalid: %v", IndexFile, err)
+	}
+	return index, nil
+}
+
+// Level is how far a locked version is behind the newest release
+type Level int
+
+const (
+	Current Level = iota
+	Patch
+	Minor
+	Major
+)
+
+func (l Level) String() string {
+	switch l {
+	case Major:
+		return "major"
+	case Minor:
+		return "minor"
+	case Patch:
+		return "patch"
+	default:
+		return "current"
+	}
+}
+
+type Gem struct {
+	Name       string
+	Locked     string
+	Latest     string
+	Behind     Level
+	Advisories []Advisory
+}
+
+// Compare returns the locked rubygems sourced gems which are behind the
+// index or affected by an advisory, the most vulnerable first and then the
+// most outdated
+func Compare(lock *lockfile.Lockfile, index *Index) []Gem {
+	seen := map[string]bool{}
+	var gems []Gem
+	for _, spec := range lock.Specs {
+		entry, found := index.Gems[spec.Name]
+		if spec.Source.Type != "GEM" || !found || seen[spec.Name] {
+			continue
+		}
+		seen[spec.Name] = true
+
+		gem := Gem{Name: spec.Name, Locked: spec.Version, Latest: entry.Latest, Behind: behind(spec.Version, entry.Latest)}
+		for _, advisory := range entry.Advisories {
+			if affected(spec.Version, advisory) {
+				gem.Advisories = append(gem.Advisories, advisory)
+			}
+		}
+		if gem.Behind != Current || len(gem.Advisories) > 0 {
+			gems = append(gems, gem)
+		}
+	}
+
+	sort.Slice(gems, func(i, j int) bool {
+		if len(gems[i].Advisories) != len(gems[j].Advisories) {
+			return len(gems[i].Advisories) > len(gems[j].Advisories)
+		}
+		if gems[i].Behind != gems[j].Behind {
+			return gems[i].Behind > gems[j].Behind
+		}
+		return gems[i].Name < gems[j].Name
+	})
+	return gems
+}
+
+// Print writes the first limit gems as a table
+func Print(w io.Writer, gems []Gem, limit int) {
+	if len(gems) > limit {
+		gems = gems[:limit]
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, gem := range gems {
+		var ids []string
+		for _, advisory := range gem.Advisories {
+			ids = append(ids, advisory.ID)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t-> %s\t%s\t%s\n", gem.Name, gem.Locked, gem.Latest, gem.Behind, strings.Join(ids, ", "))
+	}
+	tw.Flush()
+}
+
+// behind compares versions a segment at a time, a locked version newer
+// than the snapshot counts as current
+func behind(locked, latest string) Level {
+	l, n := segments(locked), segments(latest)
+	for i, level := range []Level{Major, Minor, Patch} {
+		if l[i] < n[i] {
+			return level
+		} else if l[i] > n[i] {
+			return Current
+		}
+	}
+	return Current
+}
+
+func segments(version string) [3]int {
+	var s [3]int
+	for i, part := range strings.SplitN(version, ".", 4) {
+		if i == 3 {
+			break
+		}
+		s[i], _ = strconv.Atoi(part)
+	}
+	return s
+}
+
+func affected(version string, advisory Advisory) bool {
+	v, err := lockfile.ParseVersion(version)
+	if err != nil {
+		return false
+	}
+	for _, patched := range advisory.Patched {
+		if c, err := lockfile.ParseRequirement(patched); err == nil && c.Check(v) {
+			return false
+		}
+	}
+	return true
+}
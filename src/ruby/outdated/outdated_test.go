@@ -0,0 +1,97 @@
+package outdated_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/lockfile"
+	"ruby/outdated"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Outdated", func() {
+	var index *outdated.Index
+
+	BeforeEach(func() {
+		index = &outdated.Index{
+			Date: "2018-07-20",
+			Gems: map[string]outdated.Entry{
+				"rails": {Latest: "5.2.0"},
+				"rack":  {Latest: "2.0.5", Advisories: []outdated.Advisory{{ID: "CVE-2015-3225", Patched: []string{"~> 1.5.4", ">= 1.6.2"}}}},
+				"puma":  {Latest: "3.12.0"},
+				"pg":    {Latest: "1.0.0"},
+				"nokogiri": {Latest: "1.8.4", Advisories: []outdated.Advisory{
+					{ID: "CVE-2017-9050", Patched: []string{">= 1.8.1"}},
+					{ID: "CVE-2018-0000", Patched: []string{">= 1.8.4"}},
+				}},
+			},
+		}
+	})
+
+	lock := func(contents string) *lockfile.Lockfile {
+		l, err := lockfile.Parse(strings.NewReader(contents))
+		Expect(err).To(BeNil())
+		return l
+	}
+
+	Describe("Compare", func() {
+		It("lists the most vulnerable and then the most outdated gems", func() {
+			gems := outdated.Compare(lock("GEM\n  remote: https://rubygems.org/\n  specs:\n    nokogiri (1.8.0)\n    nokogiri (1.8.0-x86_64-linux)\n    pg (1.0.0)\n    puma (3.11.4)\n    rack (1.5.2)\n    rails (4.2.10)\n    sinatra (2.0.0)\n"), index)
+
+			var names []string
+			for _, gem := range gems {
+				names = append(names, gem.Name)
+			}
+			Expect(names).To(Equal([]string{"nokogiri", "rack", "rails", "puma"}))
+			Expect(gems[0].Advisories).To(HaveLen(2))
+			Expect(gems[1].Behind).To(Equal(outdated.Major))
+			Expect(gems[2].Behind).To(Equal(outdated.Major))
+			Expect(gems[3].Behind).To(Equal(outdated.Minor))
+		})
+
+		It("leaves out patched versions and git sourced gems", func() {
+			gems := outdated.Compare(lock("GIT\n  remote: https://github.com/rails/rails.git\n  revision: 0123456789abcdef0123456789abcdef01234567\n  specs:\n    rails (5.0.0)\n\nGEM\n  remote: https://rubygems.org/\n  specs:\n    rack (1.5.4)\n"), index)
+			Expect(gems).To(HaveLen(1))
+			Expect(gems[0].Name).To(Equal("rack"))
+			Expect(gems[0].Advisories).To(BeEmpty())
+		})
+	})
+
+	Describe("Print", func() {
+		It("prints the first gems as a table", func() {
+			buffer := new(bytes.Buffer)
+			outdated.Print(buffer, []outdated.Gem{
+				{Name: "rack", Locked: "1.5.2", Latest: "2.0.5", Behind: outdated.Major, Advisories: []outdated.Advisory{{ID: "CVE-2015-3225"}}},
+				{Name: "puma", Locked: "3.11.4", Latest: "3.12.0", Behind: outdated.Minor},
+				{Name: "pg", Locked: "0.21.0", Latest: "1.0.0", Behind: outdated.Major},
+			}, 2)
+			Expect(buffer.String()).To(Equal("rack  1.5.2   -> 2.0.5   major  CVE-2015-3225\npuma  3.11.4  -> 3.12.0  minor  \n"))
+		})
+	})
+
+	Describe("LoadIndex", func() {
+		It("loads the snapshot shipped in the buildpack", func() {
+			index, err := outdated.LoadIndex(filepath.Join("..", "..", "..", outdated.IndexFile))
+			Expect(err).To(BeNil())
+			Expect(index.Gems).To(HaveKey("rails"))
+		})
+
+		It("returns nil without a snapshot", func() {
+			Expect(outdated.LoadIndex(filepath.Join("does-not-exist", outdated.IndexFile))).To(BeNil())
+		})
+
+		It("rejects unknown keys", func() {
+			dir, err := ioutil.TempDir("", "ruby-buildpack.outdated.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+
+			Expect(ioutil.WriteFile(filepath.Join(dir, outdated.IndexFile), []byte("gems:\n  rails:\n    newest: 5.2.0\n"), 0644)).To(Succeed())
+			_, err = outdated.LoadIndex(filepath.Join(dir, outdated.IndexFile))
+			Expect(err).To(MatchError(ContainSubstring("gem_index.yml is invalid")))
+		})
+	})
+})
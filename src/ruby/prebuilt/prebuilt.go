@@ -0,0 +1,404 @@
+package prebuilt
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"ruby/installer"
+	"ruby/lockfile"
+	"ruby/workspace"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"golang.org/x/crypto/ed25519"
+)
+
+// KeyFile is where an operator packages the base64 ed25519 public key the
+// index of their cache is signed with, relative to the buildpack directory.
+// Without it nothing is fetched from the cache.
+const KeyFile = "prebuilt_gem_cache.pub"
+
+// IndexFile maps each gem of a stack and ruby to the sha256 of its
+// artifact, IndexFile with .sig appended holds its base64 signature
+const IndexFile = "index.json"
+
+// signedIndex is the document the operator signs. The stack, ruby and
+// expiry are signed with the gems, so an index cannot be replayed for
+// another stack or ruby, or after the operator stopped refreshing it.
+type signedIndex struct {
+	Stack   string            `json:"stack"`
+	Ruby    string            `json:"ruby"`
+	Expires time.Time         `json:"expires"`
+	Gems    map[string]string `json:"gems"`
+}
+
+// Timeout bounds each request to the cache, which only speeds staging up
+const Timeout = 2 * time.Minute
+
+type Gem struct {
+	Name    string
+	Version string
+}
+
+func (g Gem) String() string {
+	return g.Name + "-" + g.Version
+}
+
+// Cache stores compiled native gems keyed by gem, version, ruby and stack
+// in an operator run HTTP store, so the same extension is only compiled once
+// across all apps. The store is shared by every app, so an artifact is only
+// installed when the index the operator signed with Key lists its sha256;
+// what apps upload with PUT is not trusted until the operator adds it to
+// the index.
+type Cache struct {
+	URL    string
+	Stack  string
+	Ruby   string
+	Log    *libbuildpack.Logger
+	Client *http.Client
+	// Key verifies the signature of the index
+	Key ed25519.PublicKey
+	// Token is sent as a bearer token with uploads
+	Token string
+	// Workspace holds artifacts until they are checked and extracted
+	Workspace *workspace.Workspace
+	index     map[string]string
+}
+
+func New(url, stack, ruby string, key ed25519.PublicKey, log *libbuildpack.Logger) *Cache {
+	return &Cache{
+		URL:    strings.TrimSuffix(url, "/"),
+		Stack:  stack,
+		Ruby:   ruby,
+		Log:    log,
+		Client: &http.Client{Timeout: Timeout},
+		Key:    key,
+	}
+}
+
+// LoadKey reads the public key at path, nil is returned when the operator
+// did not package one
+func LoadKey(path string) (ed25519.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s must hold a base64 ed25519 public key", KeyFile)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+func (c *Cache) artifactURL(gem Gem) string {
+	return fmt.Sprintf("%s/%s/%s/%s.tgz", c.URL, c.Stack, c.Ruby, gem)
+}
+
+func (c *Cache) indexURL() string {
+	return fmt.Sprintf("%s/%s/%s/%s", c.URL, c.Stack, c.Ruby, IndexFile)
+}
+
+// get returns the body of url, nil when the cache does not have it
+func (c *Cache) get(url string, what string) ([]byte, error) {
+	resp, err := c.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s fetching %s", resp.Status, what)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Index returns the sha256 of each artifact the operator signed for the
+// stack and ruby, it is fetched once. A cache without an index is empty, one
+// whose signature does not verify, which was signed for another stack or
+// ruby, or which expired is an error.
+func (c *Cache) Index() (map[string]string, error) {
+	if c.index != nil {
+		return c.index, nil
+	}
+	if c.Key == nil {
+		return nil, fmt.Errorf("the buildpack has no %s to verify the cache with", KeyFile)
+	}
+	index, err := c.get(c.indexURL(), IndexFile)
+	if err != nil {
+		return nil, err
+	}
+	c.index = map[string]string{}
+	if index == nil {
+		return c.index, nil
+	}
+	encoded, err := c.get(c.indexURL()+".sig", IndexFile+".sig")
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if encoded == nil || err != nil || !ed25519.Verify(c.Key, index, signature) {
+		c.index = nil
+		return nil, fmt.Errorf("the signature of %s does not match %s", IndexFile, KeyFile)
+	}
+	c.index = nil
+	var signed signedIndex
+	if err := json.Unmarshal(index, &signed); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", IndexFile, err)
+	}
+	if signed.Stack != c.Stack || signed.Ruby != c.Ruby {
+		return nil, fmt.Errorf("%s is signed for %s on %s, not %s on %s", IndexFile, signed.Ruby, signed.Stack, c.Ruby, c.Stack)
+	}
+	if !time.Now().Before(signed.Expires) {
+		return nil, fmt.Errorf("%s expired at %s", IndexFile, signed.Expires.UTC().Format(time.RFC3339))
+	}
+	c.index = signed.Gems
+	if c.index == nil {
+		c.index = map[string]string{}
+	}
+	return c.index, nil
+}
+
+// LockedGems returns the rubygems sourced gems in a Gemfile.lock. Platform
+// specific gems already ship compiled and are left out, like the gems the
+// Gemfile does not install on linux.
+func LockedGems(gemfileLock string) ([]Gem, error) {
+	lock, err := lockfile.ParseInstallable(gemfileLock)
+	if err != nil {
+		return nil, err
+	}
+
+	var gems []Gem
+	for _, spec := range lock.Specs {
+		if spec.Source.Type == "GEM" && spec.Platform == "" {
+			gems = append(gems, Gem{Name: spec.Name, Version: spec.Version})
+		}
+	}
+	return gems, nil
+}
+
+// NativeGems returns the gems in bundleDir which built an extension
+func NativeGems(bundleDir string) ([]Gem, error) {
+	dirs, err := filepath.Glob(filepath.Join(bundleDir, "extensions", "*", "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var gems []Gem
+	for _, dir := range dirs {
+		name := filepath.Base(dir)
+		idx := strings.LastIndex(name, "-")
+		if idx <= 0 {
+			continue
+		}
+		gems = append(gems, Gem{Name: name[:idx], Version: name[idx+1:]})
+	}
+	return gems, nil
+}
+
+// Fetch installs a prebuilt gem into bundleDir, it returns false when the
+// signed index does not list the gem. The artifact is downloaded into a
+// directory of the workspace and only extracted there once its sha256
+// matches the index, what was extracted is moved into bundleDir once the
+// whole archive was.
+func (c *Cache) Fetch(gem Gem, bundleDir string) (bool, error) {
+	index, err := c.Index()
+	if err != nil {
+		return false, err
+	}
+	expected, found := index[gem.String()]
+	if !found {
+		return false, nil
+	}
+
+	resp, err := c.Client.Get(c.artifactURL(gem))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %s fetching %s", resp.Status, gem)
+	}
+
+	staging, err := c.stagingDir(bundleDir)
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(staging)
+	file, err := os.Create(filepath.Join(staging, gem.String()+".tgz"))
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), resp.Body); err != nil {
+		return false, err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return false, fmt.Errorf("sha256 mismatch for %s: the index lists %s, the cache sent %s", gem, expected, actual)
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return false, err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return false, err
+	}
+	defer gz.Close()
+
+	extracted := filepath.Join(staging, "extracted")
+	if err := os.MkdirAll(extracted, 0755); err != nil {
+		return false, err
+	}
+	if err := installer.ExtractTar(gz, extracted); err != nil {
+		return false, err
+	}
+	if err := installer.MoveInto(extracted, bundleDir); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// stagingDir holds an artifact for bundleDir while it is downloaded and
+// extracted, or packed for an upload
+func (c *Cache) stagingDir(bundleDir string) (string, error) {
+	if c.Workspace != nil {
+		return c.Workspace.Dir("prebuilt")
+	}
+	if err := os.MkdirAll(filepath.Dir(bundleDir), 0755); err != nil {
+		return "", err
+	}
+	return ioutil.TempDir(filepath.Dir(bundleDir), ".prebuilt")
+}
+
+// Store uploads the gem's files, spec and compiled extension from bundleDir
+// with Token. Apps only fetch it once the operator lists it in the index.
+func (c *Cache) Store(gem Gem, bundleDir string) error {
+	if c.Token == "" {
+		return fmt.Errorf("uploads to the prebuilt gem cache need a token")
+	}
+	staging, err := c.stagingDir(bundleDir)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	file, err := os.Create(filepath.Join(staging, gem.String()+".tgz"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	paths := []string{
+		filepath.Join("gems", gem.String()),
+		filepath.Join("specifications", gem.String()+".gemspec"),
+	}
+	extensions, err := filepath.Glob(filepath.Join(bundleDir, "extensions", "*", "*", gem.String()))
+	if err != nil {
+		return err
+	}
+	for _, dir := range extensions {
+		rel, err := filepath.Rel(bundleDir, dir)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, rel)
+	}
+
+	if err := writeTarGz(file, bundleDir, paths); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", c.artifactURL(gem), file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s storing %s", resp.Status, gem)
+	}
+	return nil
+}
+
+func writeTarGz(w io.Writer, baseDir string, paths []string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for _, path := range paths {
+		err := filepath.Walk(filepath.Join(baseDir, path), func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(baseDir, file)
+			if err != nil {
+				return err
+			}
+
+			link := ""
+			if info.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(file); err != nil {
+					return err
+				}
+			}
+			header, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(rel)
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
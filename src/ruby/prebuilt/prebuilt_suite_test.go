@@ -0,0 +1,13 @@
+package prebuilt_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestPrebuilt(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Prebuilt Suite")
+}
@@ -0,0 +1,266 @@
+package prebuilt_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"ruby/prebuilt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ed25519"
+)
+
+var _ = Describe("Prebuilt", func() {
+	var (
+		err       error
+		bundleDir string
+		nokogiri  prebuilt.Gem
+	)
+
+	BeforeEach(func() {
+		bundleDir, err = ioutil.TempDir("", "ruby-buildpack.bundle.")
+		Expect(err).To(BeNil())
+		nokogiri = prebuilt.Gem{Name: "nokogiri", Version: "1.8.4"}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(bundleDir)).To(Succeed())
+	})
+
+	Describe("LockedGems", func() {
+		It("returns the rubygems sourced gems", func() {
+			lockfile := filepath.Join(bundleDir, "Gemfile.lock")
+			Expect(ioutil.WriteFile(lockfile, []byte(`GIT
+  remote: https://github.com/example/private.git
+  revision: abc123
+  specs:
+    private (0.1.0)
+
+GEM
+  remote: https://rubygems.org/
+  specs:
+    mini_portile2 (2.3.0)
+    nokogiri (1.8.4)
+      mini_portile2 (~> 2.3.0)
+    nokogiri (1.8.4-x86_64-linux)
+    rack (1.5.2)
+
+PLATFORMS
+  ruby
+
+DEPENDENCIES
+  nokogiri (= 1.8.4)
+`), 0644)).To(Succeed())
+
+			Expect(prebuilt.LockedGems(lockfile)).To(Equal([]prebuilt.Gem{
+				{Name: "mini_portile2", Version: "2.3.0"},
+				{Name: "nokogiri", Version: "1.8.4"},
+				{Name: "rack", Version: "1.5.2"},
+			}))
+		})
+	})
+
+	Describe("NativeGems", func() {
+		It("returns gems with a compiled extension", func() {
+			Expect(os.MkdirAll(filepath.Join(bundleDir, "extensions", "x86_64-linux", "2.5.0", "nokogiri-1.8.4"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(bundleDir, "extensions", "x86_64-linux", "2.5.0", "unf_ext-0.0.7.5"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(bundleDir, "gems", "rack-1.5.2"), 0755)).To(Succeed())
+
+			Expect(prebuilt.NativeGems(bundleDir)).To(Equal([]prebuilt.Gem{
+				{Name: "nokogiri", Version: "1.8.4"},
+				{Name: "unf_ext", Version: "0.0.7.5"},
+			}))
+		})
+	})
+
+	Describe("Fetch and Store", func() {
+		var (
+			server *httptest.Server
+			cache  *prebuilt.Cache
+			buffer *bytes.Buffer
+			mu     sync.Mutex
+			stored map[string][]byte
+			tokens []string
+			key    ed25519.PrivateKey
+		)
+
+		// signIndex lists the stored artifacts in an index for stack and
+		// ruby with their sha256 and signs it with key, as the operator does
+		signIndex := func(stack, ruby string, expires time.Time) {
+			gems := map[string]string{}
+			for path, body := range stored {
+				if strings.HasSuffix(path, ".tgz") {
+					sum := sha256.Sum256(body)
+					gems[strings.TrimSuffix(filepath.Base(path), ".tgz")] = hex.EncodeToString(sum[:])
+				}
+			}
+			data, err := json.Marshal(map[string]interface{}{"stack": stack, "ruby": ruby, "expires": expires, "gems": gems})
+			Expect(err).ToNot(HaveOccurred())
+			stored["/cflinuxfs3/ruby-2.5.1/index.json"] = data
+			stored["/cflinuxfs3/ruby-2.5.1/index.json.sig"] = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)))
+		}
+		sign := func() {
+			signIndex("cflinuxfs3", "ruby-2.5.1", time.Now().Add(time.Hour))
+		}
+
+		BeforeEach(func() {
+			stored = map[string][]byte{}
+			tokens = nil
+			var public ed25519.PublicKey
+			public, key, err = ed25519.GenerateKey(nil)
+			Expect(err).ToNot(HaveOccurred())
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				switch r.Method {
+				case "PUT":
+					body, err := ioutil.ReadAll(r.Body)
+					Expect(err).ToNot(HaveOccurred())
+					stored[r.URL.Path] = body
+					tokens = append(tokens, r.Header.Get("Authorization"))
+					w.WriteHeader(http.StatusCreated)
+				case "GET":
+					if body, found := stored[r.URL.Path]; found {
+						w.Write(body)
+					} else {
+						w.WriteHeader(http.StatusNotFound)
+					}
+				}
+			}))
+			buffer = new(bytes.Buffer)
+			cache = prebuilt.New(server.URL+"/", "cflinuxfs3", "ruby-2.5.1", public, libbuildpack.NewLogger(ansicleaner.New(buffer)))
+			cache.Token = "upload-token"
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("returns false when the gem is not cached", func() {
+			Expect(cache.Fetch(nokogiri, bundleDir)).To(BeFalse())
+		})
+
+		// store puts nokogiri into the cache with an extension
+		store := func() {
+			extDir := filepath.Join(bundleDir, "extensions", "x86_64-linux", "2.5.0", "nokogiri-1.8.4", "nokogiri")
+			Expect(os.MkdirAll(extDir, 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(extDir, "nokogiri.so"), []byte("elf"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(bundleDir, "gems", "nokogiri-1.8.4", "lib"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(bundleDir, "gems", "nokogiri-1.8.4", "lib", "nokogiri.rb"), []byte("ruby"), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(bundleDir, "specifications"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(bundleDir, "specifications", "nokogiri-1.8.4.gemspec"), []byte("spec"), 0644)).To(Succeed())
+
+			Expect(cache.Store(nokogiri, bundleDir)).To(Succeed())
+			Expect(stored).To(HaveKey("/cflinuxfs3/ruby-2.5.1/nokogiri-1.8.4.tgz"))
+			Expect(tokens).To(Equal([]string{"Bearer upload-token"}))
+		}
+
+		It("fetches a gem that was stored and signed into the index", func() {
+			store()
+			sign()
+
+			otherDir, err := ioutil.TempDir("", "ruby-buildpack.bundle.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(otherDir)
+
+			Expect(cache.Fetch(nokogiri, otherDir)).To(BeTrue())
+			Expect(ioutil.ReadFile(filepath.Join(otherDir, "extensions", "x86_64-linux", "2.5.0", "nokogiri-1.8.4", "nokogiri", "nokogiri.so"))).To(Equal([]byte("elf")))
+			Expect(ioutil.ReadFile(filepath.Join(otherDir, "gems", "nokogiri-1.8.4", "lib", "nokogiri.rb"))).To(Equal([]byte("ruby")))
+			Expect(ioutil.ReadFile(filepath.Join(otherDir, "specifications", "nokogiri-1.8.4.gemspec"))).To(Equal([]byte("spec")))
+		})
+
+		It("does not fetch a gem which was stored but is not in the index", func() {
+			sign()
+			store()
+			Expect(cache.Fetch(nokogiri, bundleDir)).To(BeFalse())
+		})
+
+		It("does not extract a gem which does not match the index", func() {
+			store()
+			sign()
+			stored["/cflinuxfs3/ruby-2.5.1/nokogiri-1.8.4.tgz"] = append(stored["/cflinuxfs3/ruby-2.5.1/nokogiri-1.8.4.tgz"], 0)
+
+			otherDir, err := ioutil.TempDir("", "ruby-buildpack.bundle.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(otherDir)
+			_, err = cache.Fetch(nokogiri, otherDir)
+			Expect(err).To(MatchError(ContainSubstring("sha256 mismatch for nokogiri-1.8.4")))
+			Expect(filepath.Join(otherDir, "gems")).ToNot(BeADirectory())
+		})
+
+		It("leaves the bundle dir as it was when the archive is cut short", func() {
+			store()
+			artifact := stored["/cflinuxfs3/ruby-2.5.1/nokogiri-1.8.4.tgz"]
+			stored["/cflinuxfs3/ruby-2.5.1/nokogiri-1.8.4.tgz"] = artifact[:len(artifact)-20]
+			sign()
+
+			otherDir, err := ioutil.TempDir("", "ruby-buildpack.bundle.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(otherDir)
+			_, err = cache.Fetch(nokogiri, otherDir)
+			Expect(err).To(HaveOccurred())
+			Expect(ioutil.ReadDir(otherDir)).To(BeEmpty())
+		})
+
+		It("fails when the index is not signed by the key", func() {
+			store()
+			sign()
+			_, key, err = ed25519.GenerateKey(nil)
+			Expect(err).ToNot(HaveOccurred())
+			sign()
+			_, err = cache.Fetch(nokogiri, bundleDir)
+			Expect(err).To(MatchError("the signature of index.json does not match prebuilt_gem_cache.pub"))
+		})
+
+		It("fails when the index is signed for another stack", func() {
+			store()
+			signIndex("cflinuxfs2", "ruby-2.5.1", time.Now().Add(time.Hour))
+			_, err = cache.Fetch(nokogiri, bundleDir)
+			Expect(err).To(MatchError("index.json is signed for ruby-2.5.1 on cflinuxfs2, not ruby-2.5.1 on cflinuxfs3"))
+		})
+
+		It("fails when the index is signed for another ruby", func() {
+			store()
+			signIndex("cflinuxfs3", "ruby-2.4.4", time.Now().Add(time.Hour))
+			_, err = cache.Fetch(nokogiri, bundleDir)
+			Expect(err).To(MatchError("index.json is signed for ruby-2.4.4 on cflinuxfs3, not ruby-2.5.1 on cflinuxfs3"))
+		})
+
+		It("fails when the index expired", func() {
+			store()
+			signIndex("cflinuxfs3", "ruby-2.5.1", time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC))
+			_, err = cache.Fetch(nokogiri, bundleDir)
+			Expect(err).To(MatchError("index.json expired at 2018-06-01T00:00:00Z"))
+		})
+
+		It("does not upload without a token", func() {
+			cache.Token = ""
+			Expect(cache.Store(nokogiri, bundleDir)).To(MatchError("uploads to the prebuilt gem cache need a token"))
+			Expect(stored).To(BeEmpty())
+		})
+
+		It("returns an error for unexpected responses", func() {
+			server.Close()
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+			}))
+			cache.URL = server.URL
+
+			_, err := cache.Fetch(nokogiri, bundleDir)
+			Expect(err).To(MatchError("unexpected status 401 Unauthorized fetching index.json"))
+		})
+	})
+})
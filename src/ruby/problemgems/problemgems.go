@@ -0,0 +1,210 @@
+package problemgems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/Masterminds/semver"
+	yaml "gopkg.in/yaml.v2"
+)
+
+type Versions interface {
+	HasGemVersion(gem string, constraints ...string) (bool, error)
+}
+
+type Gem struct {
+	Name       string   `yaml:"name"`
+	Constraint string   `yaml:"constraint"`
+	Reason     string   `yaml:"reason"`
+	Migration  string   `yaml:"migration"`
+	Fatal      bool     `yaml:"fatal"`
+	Stacks     []string `yaml:"stacks"`
+	Ruby       string   `yaml:"ruby"`
+}
+
+var Known = []Gem{
+	{
+		Name:       "therubyracer",
+		Constraint: ">= 0",
+		Reason:     "depends on libv8, which no longer compiles on current stacks",
+		Migration:  "Replace therubyracer with mini_racer, or remove it and let the buildpack install node.js as the ExecJS runtime.",
+		Fatal:      true,
+	},
+	{
+		Name:       "libv8",
+		Constraint: "< 6.0.0",
+		Reason:     "bundles a version of V8 which no longer compiles on current stacks",
+		Migration:  "Upgrade to mini_racer >= 0.4.0, which uses the precompiled libv8-node gem instead.",
+		Fatal:      true,
+	},
+	{
+		Name:       "capybara-webkit",
+		Constraint: ">= 0",
+		Reason:     "requires Qt WebKit, which is not available on the stack",
+		Migration:  "Make sure capybara-webkit is only in a group listed in BUNDLE_WITHOUT, or switch to selenium-webdriver with headless Chrome.",
+		Fatal:      false,
+	},
+}
+
+// StackIncompatible lists native gems which do not build or load on some
+// stacks. Buildpack manifests can extend or replace entries, by gem name,
+// with a native_gem_incompatibilities list.
+var StackIncompatible = []Gem{
+	{
+		Name:       "ruby-oci8",
+		Constraint: ">= 0",
+		Reason:     "requires the Oracle Instant Client libraries, which are not part of the stack",
+		Migration:  "Vendor Instant Client in the app and add its directory to the ld_library_path directory, or use a stack which provides it.",
+		Stacks:     []string{"cflinuxfs2", "cflinuxfs3", "cflinuxfs4"},
+	},
+	{
+		Name:       "openssl",
+		Constraint: "< 3.0.0",
+		Reason:     "does not compile against OpenSSL 3, which is the only OpenSSL on the stack",
+		Migration:  "Upgrade the openssl gem to 3.0.0 or later, or remove it to use the version bundled with Ruby 3.1+.",
+		Stacks:     []string{"cflinuxfs4"},
+	},
+	{
+		Name:       "mysql",
+		Constraint: ">= 0",
+		Reason:     "is unmaintained and does not compile against the MySQL 8 client library on the stack",
+		Migration:  "Replace the mysql gem with mysql2 and use the mysql2 adapter in config/database.yml.",
+		Stacks:     []string{"cflinuxfs4"},
+	},
+}
+
+// RubyUpgrade lists gems which need a bump before the app can move to a
+// newer ruby, Ruby is the constraint on the ruby versions which need it.
+// Buildpack manifests can extend or replace entries, by gem name, with a
+// ruby_upgrade_gems list.
+var RubyUpgrade = []Gem{
+	{
+		Name:       "json",
+		Constraint: "< 1.8.6",
+		Reason:     "does not compile against Ruby 2.4, which unified Fixnum and Bignum into Integer",
+		Migration:  "Upgrade json to 1.8.6 or later.",
+		Ruby:       ">= 2.4.0",
+	},
+	{
+		Name:       "activesupport",
+		Constraint: "< 4.2.8",
+		Reason:     "fails to boot on Ruby 2.4, which unified Fixnum and Bignum into Integer",
+		Migration:  "Upgrade Rails to 4.2.8 or later.",
+		Ruby:       ">= 2.4.0",
+	},
+}
+
+// manifestGems are the lists of the manifest which extend the gem tables
+type manifestGems struct {
+	StackIncompatible []Gem `yaml:"native_gem_incompatibilities"`
+	RubyUpgrade       []Gem `yaml:"ruby_upgrade_gems"`
+}
+
+func loadManifestGems(manifestFile string) (manifestGems, error) {
+	var manifest manifestGems
+	data, err := ioutil.ReadFile(manifestFile)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return manifest, err
+	}
+	err = yaml.Unmarshal(data, &manifest)
+	return manifest, err
+}
+
+// LoadStackIncompatible returns StackIncompatible with the overrides from
+// the native_gem_incompatibilities list in manifestFile applied
+func LoadStackIncompatible(manifestFile string) ([]Gem, error) {
+	manifest, err := loadManifestGems(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	return override(StackIncompatible, manifest.StackIncompatible), nil
+}
+
+// LoadRubyUpgrade returns RubyUpgrade with the overrides from the
+// ruby_upgrade_gems list in manifestFile applied
+func LoadRubyUpgrade(manifestFile string) ([]Gem, error) {
+	manifest, err := loadManifestGems(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	return override(RubyUpgrade, manifest.RubyUpgrade), nil
+}
+
+func override(table, overrides []Gem) []Gem {
+	gems := append([]Gem{}, table...)
+	for _, override := range overrides {
+		replaced := false
+		for i, gem := range gems {
+			if gem.Name == override.Name {
+				gems[i] = override
+				replaced = true
+			}
+		}
+		if !replaced {
+			gems = append(gems, override)
+		}
+	}
+	return gems
+}
+
+// Find returns the known problem gems which are locked in the app's Gemfile.lock
+func Find(versions Versions) ([]Gem, error) {
+	return find(versions, Known)
+}
+
+// FindOnStack returns the gems from table which are locked in the app's
+// Gemfile.lock and listed as incompatible with stack
+func FindOnStack(versions Versions, stack string, table []Gem) ([]Gem, error) {
+	var gems []Gem
+	for _, gem := range table {
+		for _, s := range gem.Stacks {
+			if s == stack {
+				gems = append(gems, gem)
+				break
+			}
+		}
+	}
+	return find(versions, gems)
+}
+
+// FindForUpgrade returns the gems from table which are locked in the app's
+// Gemfile.lock and need a bump to move from the ruby version from to to
+func FindForUpgrade(versions Versions, from, to string, table []Gem) ([]Gem, error) {
+	fromVersion, err := semver.NewVersion(from)
+	if err != nil {
+		return nil, err
+	}
+	toVersion, err := semver.NewVersion(to)
+	if err != nil {
+		return nil, err
+	}
+
+	var gems []Gem
+	for _, gem := range table {
+		constraint, err := semver.NewConstraint(gem.Ruby)
+		if err != nil {
+			return nil, fmt.Errorf("the ruby constraint of %s is invalid: %v", gem.Name, err)
+		}
+		if constraint.Check(toVersion) && !constraint.Check(fromVersion) {
+			gems = append(gems, gem)
+		}
+	}
+	return find(versions, gems)
+}
+
+func find(versions Versions, gems []Gem) ([]Gem, error) {
+	var found []Gem
+	for _, gem := range gems {
+		locked, err := versions.HasGemVersion(gem.Name, gem.Constraint)
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			found = append(found, gem)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,13 @@
+package problemgems_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestProblemgems(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Problemgems Suite")
+}
@@ -0,0 +1,190 @@
+package problemgems_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/problemgems"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+//go:generate mockgen -source=problemgems.go --destination=mocks_problemgems_test.go --package=problemgems_test
+
+var _ = Describe("Problemgems", func() {
+	var (
+		mockCtrl     *gomock.Controller
+		mockVersions *MockVersions
+	)
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockVersions = NewMockVersions(mockCtrl)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Describe("Find", func() {
+		Context("no problem gems are locked", func() {
+			BeforeEach(func() {
+				mockVersions.EXPECT().HasGemVersion(gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
+			})
+
+			It("returns nothing", func() {
+				Expect(problemgems.Find(mockVersions)).To(BeEmpty())
+			})
+		})
+
+		Context("therubyracer and an old libv8 are locked", func() {
+			BeforeEach(func() {
+				mockVersions.EXPECT().HasGemVersion("therubyracer", ">= 0").Return(true, nil)
+				mockVersions.EXPECT().HasGemVersion("libv8", "< 6.0.0").Return(true, nil)
+				mockVersions.EXPECT().HasGemVersion(gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
+			})
+
+			It("returns both gems with migration guidance", func() {
+				found, err := problemgems.Find(mockVersions)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(found).To(HaveLen(2))
+				Expect(found[0].Name).To(Equal("therubyracer"))
+				Expect(found[0].Migration).To(ContainSubstring("mini_racer"))
+				Expect(found[0].Fatal).To(BeTrue())
+				Expect(found[1].Name).To(Equal("libv8"))
+			})
+		})
+
+		Context("the lockfile can not be read", func() {
+			BeforeEach(func() {
+				mockVersions.EXPECT().HasGemVersion(gomock.Any(), gomock.Any()).Return(false, errors.New("bad lockfile"))
+			})
+
+			It("returns the error", func() {
+				_, err := problemgems.Find(mockVersions)
+				Expect(err).To(MatchError("bad lockfile"))
+			})
+		})
+	})
+
+	Describe("FindOnStack", func() {
+		var table []problemgems.Gem
+
+		BeforeEach(func() {
+			table = []problemgems.Gem{
+				{Name: "openssl", Constraint: "< 3.0.0", Stacks: []string{"cflinuxfs4"}},
+				{Name: "ruby-oci8", Constraint: ">= 0", Stacks: []string{"cflinuxfs3", "cflinuxfs4"}},
+			}
+			mockVersions.EXPECT().HasGemVersion("openssl", "< 3.0.0").AnyTimes().Return(true, nil)
+			mockVersions.EXPECT().HasGemVersion("ruby-oci8", ">= 0").AnyTimes().Return(false, nil)
+		})
+
+		It("returns the locked gems incompatible with the stack", func() {
+			found, err := problemgems.FindOnStack(mockVersions, "cflinuxfs4", table)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(HaveLen(1))
+			Expect(found[0].Name).To(Equal("openssl"))
+		})
+
+		It("ignores gems listed for other stacks", func() {
+			Expect(problemgems.FindOnStack(mockVersions, "cflinuxfs3", table)).To(BeEmpty())
+		})
+	})
+
+	Describe("FindForUpgrade", func() {
+		var table []problemgems.Gem
+
+		BeforeEach(func() {
+			table = []problemgems.Gem{
+				{Name: "json", Constraint: "< 1.8.6", Ruby: ">= 2.4.0"},
+				{Name: "bigdecimal", Constraint: "< 1.4.0", Ruby: ">= 2.6.0"},
+			}
+			mockVersions.EXPECT().HasGemVersion("json", "< 1.8.6").AnyTimes().Return(true, nil)
+			mockVersions.EXPECT().HasGemVersion("bigdecimal", "< 1.4.0").AnyTimes().Return(true, nil)
+		})
+
+		It("returns the locked gems the target ruby needs bumped", func() {
+			found, err := problemgems.FindForUpgrade(mockVersions, "2.3.7", "2.5.1", table)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(HaveLen(1))
+			Expect(found[0].Name).To(Equal("json"))
+		})
+
+		It("ignores gems the current ruby already needed bumped", func() {
+			Expect(problemgems.FindForUpgrade(mockVersions, "2.4.4", "2.5.1", table)).To(BeEmpty())
+		})
+	})
+
+	Describe("LoadStackIncompatible", func() {
+		var manifestDir string
+
+		BeforeEach(func() {
+			var err error
+			manifestDir, err = ioutil.TempDir("", "ruby-buildpack.manifest.")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(manifestDir)).To(Succeed())
+		})
+
+		It("returns the embedded table when the manifest has no overrides", func() {
+			Expect(ioutil.WriteFile(filepath.Join(manifestDir, "manifest.yml"), []byte("language: ruby\n"), 0644)).To(Succeed())
+			Expect(problemgems.LoadStackIncompatible(filepath.Join(manifestDir, "manifest.yml"))).To(Equal(problemgems.StackIncompatible))
+		})
+
+		It("replaces and adds entries from the manifest", func() {
+			Expect(ioutil.WriteFile(filepath.Join(manifestDir, "manifest.yml"), []byte(`---
+language: ruby
+native_gem_incompatibilities:
+- name: ruby-oci8
+  constraint: ">= 0"
+  reason: requires Oracle Instant Client
+  stacks: [cflinuxfs2]
+- name: tiny_tds
+  constraint: "< 2.0.0"
+  reason: requires FreeTDS
+  stacks: [cflinuxfs4]
+`), 0644)).To(Succeed())
+
+			gems, err := problemgems.LoadStackIncompatible(filepath.Join(manifestDir, "manifest.yml"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gems).To(HaveLen(len(problemgems.StackIncompatible) + 1))
+			Expect(gems[0]).To(Equal(problemgems.Gem{Name: "ruby-oci8", Constraint: ">= 0", Reason: "requires Oracle Instant Client", Stacks: []string{"cflinuxfs2"}}))
+			Expect(gems[len(gems)-1].Name).To(Equal("tiny_tds"))
+		})
+	})
+
+	Describe("LoadRubyUpgrade", func() {
+		var manifestDir string
+
+		BeforeEach(func() {
+			var err error
+			manifestDir, err = ioutil.TempDir("", "ruby-buildpack.manifest.")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(manifestDir)).To(Succeed())
+		})
+
+		It("adds entries from the manifest", func() {
+			Expect(ioutil.WriteFile(filepath.Join(manifestDir, "manifest.yml"), []byte(`---
+language: ruby
+ruby_upgrade_gems:
+- name: bigdecimal
+  constraint: "< 1.4.0"
+  ruby: ">= 2.6.0"
+  migration: Upgrade bigdecimal to 1.4.0 or later.
+`), 0644)).To(Succeed())
+
+			gems, err := problemgems.LoadRubyUpgrade(filepath.Join(manifestDir, "manifest.yml"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gems).To(HaveLen(len(problemgems.RubyUpgrade) + 1))
+			Expect(gems[len(gems)-1]).To(Equal(problemgems.Gem{Name: "bigdecimal", Constraint: "< 1.4.0", Ruby: ">= 2.6.0", Migration: "Upgrade bigdecimal to 1.4.0 or later."}))
+		})
+	})
+})
@@ -0,0 +1,148 @@
+// Package procstat samples the CPU time and disk IO of the buildpack's
+// process tree from /proc. The difference between two samples is what the
+// commands run in between cost, bundle install and rake assets:precompile
+// included, since the kernel adds the time and IO of a child to its parent
+// once the parent waits for it. Children still running when a sample is
+// taken are added from their own entries.
+package procstat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// clockTicks is USER_HZ, the unit of the times in /proc/<pid>/stat, which
+// is 100 on every architecture Cloud Foundry stacks run on
+const clockTicks = 100
+
+// Usage is the CPU time and the bytes read from and written to storage by
+// a process tree
+type Usage struct {
+	CPUSeconds float64 `json:"cpu_seconds"`
+	ReadBytes  uint64  `json:"read_bytes"`
+	WriteBytes uint64  `json:"write_bytes"`
+}
+
+// Sub is what was used between earlier and u. A running child counted in
+// earlier which was killed rather than waited for takes its usage with it,
+// so nothing goes below zero.
+func (u Usage) Sub(earlier Usage) Usage {
+	cpu := u.CPUSeconds - earlier.CPUSeconds
+	if cpu < 0 {
+		cpu = 0
+	}
+	return Usage{
+		CPUSeconds: cpu,
+		ReadBytes:  sub(u.ReadBytes, earlier.ReadBytes),
+		WriteBytes: sub(u.WriteBytes, earlier.WriteBytes),
+	}
+}
+
+// Sample is the usage of this process, its waited for children and its
+// running descendants
+func Sample() (Usage, error) {
+	return SampleTree("/proc", os.Getpid())
+}
+
+// SampleTree is Sample of the process pid in the proc filesystem at root.
+// The IO of a process is left out when its io file can not be read, which
+// needs the same user as the process.
+func SampleTree(root string, pid int) (Usage, error) {
+	total, _, err := sample(root, pid)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	children := map[int][]int{}
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return Usage{}, err
+	}
+	for _, entry := range entries {
+		child, err := strconv.Atoi(entry.Name())
+		if err != nil || child == pid {
+			continue
+		}
+		if _, ppid, err := sample(root, child); err == nil {
+			children[ppid] = append(children[ppid], child)
+		}
+	}
+
+	queue := children[pid]
+	for len(queue) > 0 {
+		child := queue[0]
+		queue = append(queue[1:], children[child]...)
+		// A process which exited since the directory was read is counted in
+		// its parent once it is waited for
+		if usage, _, err := sample(root, child); err == nil {
+			total.CPUSeconds += usage.CPUSeconds
+			total.ReadBytes += usage.ReadBytes
+			total.WriteBytes += usage.WriteBytes
+		}
+	}
+	return total, nil
+}
+
+// sample reads the usage of pid alone, with the times of its waited for
+// children, and its parent
+func sample(root string, pid int) (Usage, int, error) {
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return Usage{}, 0, err
+	}
+	// The command in parentheses may contain spaces and parentheses itself
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return Usage{}, 0, fmt.Errorf("could not parse %s/stat", dir)
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 15 {
+		return Usage{}, 0, fmt.Errorf("could not parse %s/stat", dir)
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Usage{}, 0, fmt.Errorf("could not parse %s/stat: %v", dir, err)
+	}
+	var ticks uint64
+	// utime, stime, cutime and cstime
+	for _, field := range fields[11:15] {
+		n, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return Usage{}, 0, fmt.Errorf("could not parse %s/stat: %v", dir, err)
+		}
+		ticks += n
+	}
+
+	usage := Usage{CPUSeconds: float64(ticks) / clockTicks}
+	if io, err := ioutil.ReadFile(filepath.Join(dir, "io")); err == nil {
+		for _, line := range strings.Split(string(io), "\n") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			n, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 64)
+			if err != nil {
+				continue
+			}
+			switch parts[0] {
+			case "read_bytes":
+				usage.ReadBytes = n
+			case "write_bytes":
+				usage.WriteBytes = n
+			}
+		}
+	}
+	return usage, ppid, nil
+}
+
+func sub(a, b uint64) uint64 {
+	if a < b {
+		return 0
+	}
+	return a - b
+}
@@ -0,0 +1,13 @@
+package procstat_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestProcstat(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Procstat Suite")
+}
@@ -0,0 +1,67 @@
+package procstat_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/procstat"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Procstat", func() {
+	var root string
+
+	process := func(pid, ppid int, comm string, utime, stime, cutime, cstime int, io string) {
+		dir := filepath.Join(root, fmt.Sprint(pid))
+		Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+		stat := fmt.Sprintf("%d (%s) S %d 1 1 0 -1 4194560 100 0 0 0 %d %d %d %d 20 0 1 0 100 1000 100\n", pid, comm, ppid, utime, stime, cutime, cstime)
+		Expect(ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644)).To(Succeed())
+		if io != "" {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "io"), []byte(io), 0644)).To(Succeed())
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "ruby-buildpack.proc.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(root)).To(Succeed())
+	})
+
+	Describe("SampleTree", func() {
+		It("adds the running descendants to the process and its waited for children", func() {
+			process(10, 1, "supply", 100, 50, 200, 50, "rchar: 1\nread_bytes: 4096\nwrite_bytes: 8192\n")
+			process(11, 10, "bundle install", 300, 100, 0, 0, "read_bytes: 1024\nwrite_bytes: 2048\n")
+			process(12, 11, "make (cc1)", 50, 50, 0, 0, "")
+			process(20, 1, "other", 1000, 1000, 0, 0, "read_bytes: 1\nwrite_bytes: 1\n")
+
+			Expect(procstat.SampleTree(root, 10)).To(Equal(procstat.Usage{CPUSeconds: 9, ReadBytes: 5120, WriteBytes: 10240}))
+		})
+
+		It("fails when the process has no stat", func() {
+			_, err := procstat.SampleTree(root, 10)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Sample", func() {
+		It("reads this process", func() {
+			_, err := procstat.Sample()
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Describe("Sub", func() {
+		It("never goes below zero", func() {
+			later := procstat.Usage{CPUSeconds: 1, ReadBytes: 10, WriteBytes: 100}
+			earlier := procstat.Usage{CPUSeconds: 2, ReadBytes: 5, WriteBytes: 200}
+			Expect(later.Sub(earlier)).To(Equal(procstat.Usage{ReadBytes: 5}))
+		})
+	})
+})
@@ -0,0 +1,246 @@
+// Package profiled builds the profile.d scripts the buildpack writes into
+// the droplet. The launcher sources them with bash before the app starts, so
+// a quoting mistake breaks every instance; building scripts from typed
+// statements keeps the quoting in one place.
+package profiled
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"ruby/filesystem"
+	"strings"
+)
+
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Value is what an environment variable is set to
+type Value interface {
+	word() string
+}
+
+type literal string
+
+func (l literal) word() string {
+	return "'" + strings.Replace(string(l), "'", `'\''`, -1) + "'"
+}
+
+type expand string
+
+func (e expand) word() string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`").Replace(string(e)) + `"`
+}
+
+// Literal is used as is, nothing in it is expanded by the shell
+func Literal(value string) Value {
+	return literal(value)
+}
+
+// Expand may refer to other variables, such as $DEPS_DIR or $HOME, which
+// are expanded when the script is sourced
+func Expand(value string) Value {
+	return expand(value)
+}
+
+// Guard is a condition a script block only runs under. Setup computes what
+// the condition tests once at the top of the script, cleanup undoes it.
+type Guard struct {
+	test    string
+	setup   string
+	cleanup string
+	names   []string
+}
+
+// IfUnset runs the block when name is unset or empty
+func IfUnset(name string) Guard {
+	return Guard{test: fmt.Sprintf(`[ -z "${%s:-}" ]`, name), names: []string{name}}
+}
+
+// IfSet runs the block when name is set and not empty
+func IfSet(name string) Guard {
+	return Guard{test: fmt.Sprintf(`[ -n "${%s:-}" ]`, name), names: []string{name}}
+}
+
+// IfProcessType runs the block in instances started as the process type
+// name, such as web or worker
+func IfProcessType(name string) Guard {
+	return Guard{
+		test:    fmt.Sprintf(`[ "$__cf_process_type" = %s ]`, literal(name).word()),
+		setup:   `__cf_process_type=$(echo "$VCAP_APPLICATION" | sed -n 's/.*"process_type": *"\([^"]*\)".*/\1/p')`,
+		cleanup: "unset __cf_process_type",
+	}
+}
+
+type statement struct {
+	line   string
+	names  []string
+	block  *Script
+	guards []Guard
+}
+
+// Script is a profile.d script, statements render in the order they are added
+type Script struct {
+	statements []statement
+}
+
+func New() *Script {
+	return &Script{}
+}
+
+// AddEnv exports name, replacing any value it had
+func (s *Script) AddEnv(name string, value Value) *Script {
+	return s.add(fmt.Sprintf("export %s=%s", name, value.word()), name)
+}
+
+// AddEnvDefault exports name unless the app or the platform already set it
+func (s *Script) AddEnvDefault(name string, value Value) *Script {
+	return s.add(fmt.Sprintf("export %s=${%s:-%s}", name, name, value.word()), name)
+}
+
+// AddPathPrepend puts dir in front of the colon separated list in name,
+// without leaving an empty entry when name was unset
+func (s *Script) AddPathPrepend(name string, dir Value) *Script {
+	return s.add(fmt.Sprintf(`export %s=%s${%s:+":$%s"}`, name, dir.word(), name, name), name)
+}
+
+// AddPathAppend puts dir at the end of the colon separated list in name
+func (s *Script) AddPathAppend(name string, dir Value) *Script {
+	return s.add(fmt.Sprintf(`export %s=${%s:+"$%s:"}%s`, name, name, name, dir.word()), name)
+}
+
+// AddUnset removes variables the script only used while it ran
+func (s *Script) AddUnset(names ...string) *Script {
+	return s.add("unset "+strings.Join(names, " "), names...)
+}
+
+// AddShell adds shell the builder has no statement for, such as reading a
+// value out of VCAP_SERVICES. It is not quoted or checked.
+func (s *Script) AddShell(shell string) *Script {
+	s.statements = append(s.statements, statement{line: strings.TrimRight(shell, "\n")})
+	return s
+}
+
+// AddScriptBlock runs the statements of block only when every guard holds
+func (s *Script) AddScriptBlock(block *Script, guards ...Guard) *Script {
+	s.statements = append(s.statements, statement{block: block, guards: guards})
+	return s
+}
+
+// Empty is true when nothing was added to the script
+func (s *Script) Empty() bool {
+	return len(s.statements) == 0
+}
+
+func (s *Script) add(line string, names ...string) *Script {
+	s.statements = append(s.statements, statement{line: line, names: names})
+	return s
+}
+
+// Render returns the shell source of the script, or an error when a
+// variable name could not be exported
+func (s *Script) Render() (string, error) {
+	if err := s.validate(); err != nil {
+		return "", err
+	}
+
+	var setup, cleanup []string
+	s.guards(func(guard Guard) {
+		if guard.setup != "" && !contains(setup, guard.setup) {
+			setup = append(setup, guard.setup)
+			cleanup = append(cleanup, guard.cleanup)
+		}
+	})
+
+	out := &bytes.Buffer{}
+	for _, line := range setup {
+		fmt.Fprintln(out, line)
+	}
+	s.render(out, "")
+	for _, line := range cleanup {
+		fmt.Fprintln(out, line)
+	}
+	return out.String(), nil
+}
+
+// Write renders the script into the profile.d directory of depDir
+func (s *Script) Write(depDir, name string) error {
+	return s.WriteFS(filesystem.OS, depDir, name)
+}
+
+// WriteFS is Write into fs
+func (s *Script) WriteFS(fs filesystem.FS, depDir, name string) error {
+	script, err := s.Render()
+	if err != nil {
+		return err
+	}
+
+	profileD := filepath.Join(depDir, "profile.d")
+	if err := fs.MkdirAll(profileD, 0755); err != nil {
+		return err
+	}
+	return fs.WriteFile(filepath.Join(profileD, name), []byte(script), 0644)
+}
+
+func (s *Script) render(out *bytes.Buffer, indent string) {
+	for _, statement := range s.statements {
+		if statement.block == nil {
+			for _, line := range strings.Split(statement.line, "\n") {
+				fmt.Fprintf(out, "%s%s\n", indent, line)
+			}
+			continue
+		}
+		if len(statement.guards) == 0 {
+			statement.block.render(out, indent)
+			continue
+		}
+
+		var tests []string
+		for _, guard := range statement.guards {
+			tests = append(tests, guard.test)
+		}
+		fmt.Fprintf(out, "%sif %s; then\n", indent, strings.Join(tests, " && "))
+		statement.block.render(out, indent+"  ")
+		fmt.Fprintf(out, "%sfi\n", indent)
+	}
+}
+
+func (s *Script) validate() error {
+	for _, statement := range s.statements {
+		names := statement.names
+		for _, guard := range statement.guards {
+			names = append(names, guard.names...)
+		}
+		for _, name := range names {
+			if !envVarName.MatchString(name) {
+				return fmt.Errorf("%q is not a valid environment variable name", name)
+			}
+		}
+		if statement.block != nil {
+			if err := statement.block.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Script) guards(visit func(Guard)) {
+	for _, statement := range s.statements {
+		for _, guard := range statement.guards {
+			visit(guard)
+		}
+		if statement.block != nil {
+			statement.block.guards(visit)
+		}
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+package profiled_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestProfiled(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Profiled Suite")
+}
@@ -0,0 +1,113 @@
+package profiled_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/profiled"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Profiled", func() {
+	// golden compares a rendered script with testdata/name, run the tests
+	// with UPDATE_GOLDEN=1 to rewrite the files after changing the output
+	golden := func(name string, script *profiled.Script) {
+		rendered, err := script.Render()
+		Expect(err).To(BeNil())
+
+		path := filepath.Join("testdata", name)
+		if os.Getenv("UPDATE_GOLDEN") != "" {
+			Expect(ioutil.WriteFile(path, []byte(rendered), 0644)).To(Succeed())
+		}
+		expected, err := ioutil.ReadFile(path)
+		Expect(err).To(BeNil())
+		Expect(rendered).To(Equal(string(expected)))
+	}
+
+	source := func(script *profiled.Script, env ...string) string {
+		rendered, err := script.Render()
+		Expect(err).To(BeNil())
+		cmd := exec.Command("bash", "-c", rendered+"\nenv")
+		cmd.Env = env
+		output, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(output))
+		return string(output)
+	}
+
+	Describe("Render", func() {
+		It("renders environment variables", func() {
+			golden("env.sh", profiled.New().
+				AddEnv("RAILS_ENV", profiled.Literal("production")).
+				AddEnv("GREETING", profiled.Literal("it's $HOME")).
+				AddEnvDefault("GEM_HOME", profiled.Expand("$DEPS_DIR/0/gem_home")).
+				AddPathPrepend("LD_LIBRARY_PATH", profiled.Expand("$HOME/ld_library_path")).
+				AddPathAppend("PATH", profiled.Expand("$HOME/bin")))
+		})
+
+		It("renders guarded blocks", func() {
+			web := profiled.New().AddEnv("RAILS_MAX_THREADS", profiled.Literal("5"))
+			worker := profiled.New().AddEnv("RAILS_MAX_THREADS", profiled.Literal("25"))
+			lookup := profiled.New().
+				AddShell(`__cf_redis_url=$(echo "$VCAP_SERVICES" | sed -n 's/.*"uri": *"\([^"]*\)".*/\1/p')`).
+				AddScriptBlock(profiled.New().AddEnv("REDIS_URL", profiled.Expand("$__cf_redis_url")), profiled.IfSet("__cf_redis_url")).
+				AddUnset("__cf_redis_url")
+
+			golden("guarded.sh", profiled.New().
+				AddScriptBlock(web, profiled.IfProcessType("web")).
+				AddScriptBlock(worker, profiled.IfProcessType("worker")).
+				AddScriptBlock(lookup, profiled.IfUnset("REDIS_URL")))
+		})
+
+		It("rejects invalid variable names", func() {
+			_, err := profiled.New().AddScriptBlock(profiled.New().AddEnv("BAD NAME", profiled.Literal("x"))).Render()
+			Expect(err).To(MatchError(`"BAD NAME" is not a valid environment variable name`))
+		})
+	})
+
+	Describe("the rendered script", func() {
+		It("does not expand literals", func() {
+			env := source(profiled.New().AddEnv("GREETING", profiled.Literal("it's $HOME `id`")), "HOME=/home/vcap")
+			Expect(env).To(ContainSubstring("GREETING=it's $HOME `id`\n"))
+		})
+
+		It("expands other variables", func() {
+			env := source(profiled.New().AddEnv("BIN", profiled.Expand(`$HOME/bin "quoted"`)), "HOME=/home/vcap")
+			Expect(env).To(ContainSubstring(`BIN=/home/vcap/bin "quoted"` + "\n"))
+		})
+
+		It("keeps defaults the app set", func() {
+			script := profiled.New().AddEnvDefault("RAILS_ENV", profiled.Literal("production"))
+			Expect(source(script, "RAILS_ENV=staging")).To(ContainSubstring("RAILS_ENV=staging\n"))
+			Expect(source(script)).To(ContainSubstring("RAILS_ENV=production\n"))
+		})
+
+		It("does not leave empty path entries", func() {
+			script := profiled.New().AddPathPrepend("LD_LIBRARY_PATH", profiled.Literal("/app/lib"))
+			Expect(source(script)).To(ContainSubstring("LD_LIBRARY_PATH=/app/lib\n"))
+			Expect(source(script, "LD_LIBRARY_PATH=/usr/lib")).To(ContainSubstring("LD_LIBRARY_PATH=/app/lib:/usr/lib\n"))
+		})
+
+		It("only runs the blocks of the instance's process type", func() {
+			script := profiled.New().
+				AddScriptBlock(profiled.New().AddEnv("THREADS", profiled.Literal("5")), profiled.IfProcessType("web")).
+				AddScriptBlock(profiled.New().AddEnv("THREADS", profiled.Literal("25")), profiled.IfProcessType("worker"))
+			env := source(script, `VCAP_APPLICATION={"process_type": "worker"}`)
+			Expect(env).To(ContainSubstring("THREADS=25\n"))
+			Expect(env).ToNot(ContainSubstring("__cf_process_type"))
+		})
+	})
+
+	Describe("Write", func() {
+		It("writes the script into profile.d", func() {
+			depDir, err := ioutil.TempDir("", "ruby-buildpack.profiled.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(depDir)
+
+			Expect(profiled.New().AddEnv("RACK_ENV", profiled.Literal("production")).Write(depDir, "rack.sh")).To(Succeed())
+			Expect(ioutil.ReadFile(filepath.Join(depDir, "profile.d", "rack.sh"))).To(Equal([]byte("export RACK_ENV='production'\n")))
+		})
+	})
+})
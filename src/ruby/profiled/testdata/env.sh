@@ -0,0 +1,5 @@
+export RAILS_ENV='production'
+export GREETING='it'\''s $HOME'
+export GEM_HOME=${GEM_HOME:-"$DEPS_DIR/0/gem_home"}
+export LD_LIBRARY_PATH="$HOME/ld_library_path"${LD_LIBRARY_PATH:+":$LD_LIBRARY_PATH"}
+export PATH=${PATH:+"$PATH:"}"$HOME/bin"
@@ -0,0 +1,15 @@
+__cf_process_type=$(echo "$VCAP_APPLICATION" | sed -n 's/.*"process_type": *"\([^"]*\)".*/\1/p')
+if [ "$__cf_process_type" = 'web' ]; then
+  export RAILS_MAX_THREADS='5'
+fi
+if [ "$__cf_process_type" = 'worker' ]; then
+  export RAILS_MAX_THREADS='25'
+fi
+if [ -z "${REDIS_URL:-}" ]; then
+  __cf_redis_url=$(echo "$VCAP_SERVICES" | sed -n 's/.*"uri": *"\([^"]*\)".*/\1/p')
+  if [ -n "${__cf_redis_url:-}" ]; then
+    export REDIS_URL="$__cf_redis_url"
+  fi
+  unset __cf_redis_url
+fi
+unset __cf_process_type
@@ -0,0 +1,267 @@
+// Package provenance records where each dependency the buildpack installed
+// came from, and turns those records into an attestation of the droplet: an
+// in-toto statement with a SLSA provenance predicate, wrapped in a DSSE
+// envelope. The envelope is signed when the operator provides an ed25519
+// key, so the attestation can be checked without trusting the droplet.
+package provenance
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// File is the attestation in the dep dir, next to the staging report
+const File = "provenance.intoto.json"
+
+// KeyFile is the operator's signing key, packaged in the buildpack dir so it
+// never reaches the environment of the app or the commands staging runs
+const KeyFile = "attestation_signing.key"
+
+const (
+	StatementType = "https://in-toto.io/Statement/v0.1"
+	PredicateType = "https://slsa.dev/provenance/v0.2"
+	PayloadType   = "application/vnd.in-toto+json"
+	BuilderID     = "https://github.com/cloudfoundry/ruby-buildpack"
+	BuildType     = "https://github.com/cloudfoundry/ruby-buildpack/staging@v1"
+)
+
+// Where an installed dependency was read from
+const (
+	FromBuildpack = "buildpack"
+	FromAppCache  = "app_cache"
+	FromDownload  = "download"
+)
+
+// Dependency is an installed manifest or sideloaded dependency. Version is
+// the version of its manifest entry and BuildpackVersion the version of the
+// buildpack whose manifest it came from.
+type Dependency struct {
+	Name             string    `json:"name"`
+	Version          string    `json:"version,omitempty"`
+	URI              string    `json:"uri"`
+	SHA256           string    `json:"sha256"`
+	Origin           string    `json:"origin"`
+	BuildpackVersion string    `json:"buildpack_version,omitempty"`
+	InstalledAt      time.Time `json:"installed_at"`
+}
+
+type Statement struct {
+	Type          string    `json:"_type"`
+	Subject       []Subject `json:"subject"`
+	PredicateType string    `json:"predicateType"`
+	Predicate     Predicate `json:"predicate"`
+}
+
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type Predicate struct {
+	Builder     Builder     `json:"builder"`
+	BuildType   string      `json:"buildType"`
+	BuildConfig BuildConfig `json:"buildConfig"`
+	Materials   []Material  `json:"materials"`
+}
+
+type Builder struct {
+	ID string `json:"id"`
+}
+
+// BuildConfig keeps the full record of each dependency, SLSA materials only
+// have room for the URI and digest
+type BuildConfig struct {
+	Dependencies []Dependency `json:"dependencies"`
+}
+
+type Material struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// NewStatement attests that subject, whose digest is "algorithm:hex" like
+// the digest of the staging report contents, was built from dependencies
+func NewStatement(subject, digest string, dependencies []Dependency) (Statement, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return Statement{}, fmt.Errorf("invalid digest %q", digest)
+	}
+	statement := Statement{
+		Type:          StatementType,
+		Subject:       []Subject{{Name: subject, Digest: map[string]string{parts[0]: parts[1]}}},
+		PredicateType: PredicateType,
+		Predicate: Predicate{
+			Builder:     Builder{ID: BuilderID},
+			BuildType:   BuildType,
+			BuildConfig: BuildConfig{Dependencies: dependencies},
+			Materials:   []Material{},
+		},
+	}
+	if statement.Predicate.BuildConfig.Dependencies == nil {
+		statement.Predicate.BuildConfig.Dependencies = []Dependency{}
+	}
+	for _, dep := range dependencies {
+		statement.Predicate.Materials = append(statement.Predicate.Materials, Material{
+			URI:    dep.URI,
+			Digest: map[string]string{"sha256": dep.SHA256},
+		})
+	}
+	return statement, nil
+}
+
+// Envelope is a DSSE envelope around a statement. Signatures is empty
+// unless it was signed.
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     string      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+func NewEnvelope(statement Statement) (*Envelope, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		PayloadType: PayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []Signature{},
+	}, nil
+}
+
+// Statement decodes the payload
+func (e *Envelope) Statement() (Statement, error) {
+	var statement Statement
+	payload, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return statement, err
+	}
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return statement, err
+	}
+	return statement, nil
+}
+
+// Sign adds a signature over the payload with key
+func (e *Envelope) Sign(key ed25519.PrivateKey) error {
+	message, err := e.signed()
+	if err != nil {
+		return err
+	}
+	e.Signatures = append(e.Signatures, Signature{
+		KeyID: KeyID(key.Public().(ed25519.PublicKey)),
+		Sig:   base64.StdEncoding.EncodeToString(ed25519.Sign(key, message)),
+	})
+	return nil
+}
+
+// Verify reports whether the envelope carries a valid signature by key
+func (e *Envelope) Verify(key ed25519.PublicKey) error {
+	message, err := e.signed()
+	if err != nil {
+		return err
+	}
+	keyID := KeyID(key)
+	for _, signature := range e.Signatures {
+		if signature.KeyID != keyID {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(key, message, sig) {
+			return fmt.Errorf("signature does not match")
+		}
+		return nil
+	}
+	return fmt.Errorf("attestation is not signed by key %s", keyID)
+}
+
+// signed is the DSSE pre-authentication encoding of the payload
+func (e *Envelope) signed() ([]byte, error) {
+	payload, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return nil, err
+	}
+	message := new(bytes.Buffer)
+	fmt.Fprintf(message, "DSSEv1 %d %s %d ", len(e.PayloadType), e.PayloadType, len(payload))
+	message.Write(payload)
+	return message.Bytes(), nil
+}
+
+func (e *Envelope) Save(depDir string) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(depDir, File), append(data, '\n'), 0644)
+}
+
+func Load(depDir string) (*Envelope, error) {
+	data, err := ioutil.ReadFile(filepath.Join(depDir, File))
+	if err != nil {
+		return nil, err
+	}
+	e := &Envelope{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ParseKey reads a base64 ed25519 private key, either the 32 byte seed or
+// the 64 byte key
+func ParseKey(encoded string) (ed25519.PrivateKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("not base64: %v", err)
+	}
+	switch len(key) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case ed25519.PrivateKeySize:
+		if private := ed25519.NewKeyFromSeed(key[:ed25519.SeedSize]); !bytes.Equal(private, key) {
+			return nil, fmt.Errorf("the public half of the key does not match its seed")
+		}
+		return ed25519.PrivateKey(key), nil
+	}
+	return nil, fmt.Errorf("an ed25519 key is %d or %d bytes, not %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(key))
+}
+
+// LoadKey reads the key ParseKey accepts from path, a missing file is no key
+func LoadKey(path string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	key, err := ParseKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", KeyFile, err)
+	}
+	return key, nil
+}
+
+// KeyID is the hex sha256 of the public key
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
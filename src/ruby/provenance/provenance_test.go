@@ -0,0 +1,119 @@
+package provenance_test
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"ruby/provenance"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ed25519"
+)
+
+var _ = Describe("Provenance", func() {
+	var (
+		dependencies []provenance.Dependency
+		statement    provenance.Statement
+		seed         []byte
+	)
+
+	BeforeEach(func() {
+		dependencies = []provenance.Dependency{
+			{Name: "ruby", Version: "2.5.1", URI: "https://buildpacks.cloudfoundry.org/ruby-2.5.1.tgz", SHA256: "abc123", Origin: provenance.FromDownload, BuildpackVersion: "1.7.9", InstalledAt: time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)},
+			{Name: "bundler", Version: "1.16.2", URI: "https://buildpacks.cloudfoundry.org/bundler-1.16.2.tgz", SHA256: "def456", Origin: provenance.FromAppCache, BuildpackVersion: "1.7.9", InstalledAt: time.Date(2018, 6, 1, 12, 0, 5, 0, time.UTC)},
+		}
+		var err error
+		statement, err = provenance.NewStatement("droplet", "sha256:0123abcd", dependencies)
+		Expect(err).To(BeNil())
+		seed = []byte(strings.Repeat("k", ed25519.SeedSize))
+	})
+
+	Describe("NewStatement", func() {
+		It("attests the subject digest with a material for each dependency", func() {
+			Expect(statement.Type).To(Equal(provenance.StatementType))
+			Expect(statement.PredicateType).To(Equal(provenance.PredicateType))
+			Expect(statement.Subject).To(Equal([]provenance.Subject{{Name: "droplet", Digest: map[string]string{"sha256": "0123abcd"}}}))
+			Expect(statement.Predicate.Builder.ID).To(Equal(provenance.BuilderID))
+			Expect(statement.Predicate.Materials).To(Equal([]provenance.Material{
+				{URI: "https://buildpacks.cloudfoundry.org/ruby-2.5.1.tgz", Digest: map[string]string{"sha256": "abc123"}},
+				{URI: "https://buildpacks.cloudfoundry.org/bundler-1.16.2.tgz", Digest: map[string]string{"sha256": "def456"}},
+			}))
+			Expect(statement.Predicate.BuildConfig.Dependencies).To(Equal(dependencies))
+		})
+
+		It("rejects a digest without an algorithm", func() {
+			_, err := provenance.NewStatement("droplet", "0123abcd", nil)
+			Expect(err).To(MatchError(`invalid digest "0123abcd"`))
+		})
+	})
+
+	Describe("Envelope", func() {
+		It("round trips the statement through the dep dir", func() {
+			depDir, err := ioutil.TempDir("", "ruby-buildpack.provenance.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(depDir)
+
+			envelope, err := provenance.NewEnvelope(statement)
+			Expect(err).To(BeNil())
+			Expect(envelope.PayloadType).To(Equal(provenance.PayloadType))
+			Expect(envelope.Save(depDir)).To(Succeed())
+
+			loaded, err := provenance.Load(depDir)
+			Expect(err).To(BeNil())
+			Expect(loaded.Signatures).To(BeEmpty())
+			Expect(loaded.Statement()).To(Equal(statement))
+		})
+
+		It("verifies with the public half of the signing key", func() {
+			key := ed25519.NewKeyFromSeed(seed)
+			envelope, err := provenance.NewEnvelope(statement)
+			Expect(err).To(BeNil())
+			Expect(envelope.Sign(key)).To(Succeed())
+
+			public := key.Public().(ed25519.PublicKey)
+			Expect(envelope.Signatures).To(HaveLen(1))
+			Expect(envelope.Signatures[0].KeyID).To(Equal(provenance.KeyID(public)))
+			Expect(envelope.Verify(public)).To(Succeed())
+
+			other, _, err := ed25519.GenerateKey(nil)
+			Expect(err).To(BeNil())
+			Expect(envelope.Verify(other)).To(MatchError(ContainSubstring("attestation is not signed by key")))
+		})
+
+		It("fails to verify a changed payload", func() {
+			key := ed25519.NewKeyFromSeed(seed)
+			envelope, err := provenance.NewEnvelope(statement)
+			Expect(err).To(BeNil())
+			Expect(envelope.Sign(key)).To(Succeed())
+
+			statement.Predicate.Materials[0].Digest["sha256"] = "evil"
+			changed, err := provenance.NewEnvelope(statement)
+			Expect(err).To(BeNil())
+			envelope.Payload = changed.Payload
+			Expect(envelope.Verify(key.Public().(ed25519.PublicKey))).To(MatchError("signature does not match"))
+		})
+	})
+
+	Describe("ParseKey", func() {
+		It("reads a seed or a full key", func() {
+			key := ed25519.NewKeyFromSeed(seed)
+			Expect(provenance.ParseKey(base64.StdEncoding.EncodeToString(seed) + "\n")).To(Equal(key))
+			Expect(provenance.ParseKey(base64.StdEncoding.EncodeToString(key))).To(Equal(key))
+		})
+
+		It("rejects anything else", func() {
+			_, err := provenance.ParseKey("not a key")
+			Expect(err).To(MatchError(ContainSubstring("not base64")))
+			_, err = provenance.ParseKey(base64.StdEncoding.EncodeToString([]byte("short")))
+			Expect(err).To(MatchError("an ed25519 key is 32 or 64 bytes, not 5"))
+
+			key := ed25519.NewKeyFromSeed(seed)
+			key[40] ^= 0xff
+			_, err = provenance.ParseKey(base64.StdEncoding.EncodeToString(key))
+			Expect(err).To(MatchError(ContainSubstring("does not match its seed")))
+		})
+	})
+})
@@ -0,0 +1,149 @@
+package redact
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"ruby/lockfile"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Bundler looks the credentials for a gem source host up in the setting
+// named after it, BUNDLE_GEMS__CONTRIBSYS__COM for gems.contribsys.com.
+// Settings such as BUNDLE_PATH__SYSTEM or BUNDLE_GEM__TEST use the same
+// separator, so a setting only holds credentials when it is named after a
+// source of the Gemfile.lock or its value is a user:password pair. Build
+// options and local git overrides never do.
+var (
+	hostKey          = regexp.MustCompile(`^BUNDLE_.+__`)
+	notCredentialKey = regexp.MustCompile(`^BUNDLE_(BUILD|LOCAL|MIRROR)__`)
+	mirrorKey        = regexp.MustCompile(`^BUNDLE_MIRROR__`)
+	userPassword     = regexp.MustCompile(`^[^:/\s]+:[^/\s]+$`)
+)
+
+// SourceKeys are the names of the settings bundler looks the credentials of
+// the sources in gemfileLock up in. A missing or unparsable lockfile has
+// none.
+func SourceKeys(gemfileLock string) map[string]bool {
+	keys := map[string]bool{}
+	lock, err := lockfile.ParseFile(gemfileLock)
+	if err != nil {
+		return keys
+	}
+	for _, source := range lock.Sources {
+		if u, err := url.Parse(source.Remote); err == nil && u.Hostname() != "" {
+			keys[settingKey(u.Hostname())] = true
+		}
+	}
+	return keys
+}
+
+// settingKey is the setting bundler keeps the credentials for host in
+func settingKey(host string) string {
+	host = strings.Replace(strings.Replace(host, ".", "__", -1), "-", "___", -1)
+	return "BUNDLE_" + strings.ToUpper(host)
+}
+
+func isCredential(key, value string, sourceKeys map[string]bool) bool {
+	if !hostKey.MatchString(key) || notCredentialKey.MatchString(key) {
+		return false
+	}
+	return sourceKeys[key] || userPassword.MatchString(value)
+}
+
+// ScrubBundleConfig removes source credentials from a bundler config file,
+// and the user info from mirror URLs, returning the names of the settings
+// it changed. Installed gems do not need them at runtime. sourceKeys are
+// the SourceKeys of the app's Gemfile.lock.
+func ScrubBundleConfig(data []byte, sourceKeys map[string]bool) ([]byte, []string, error) {
+	var config yaml.MapSlice
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, nil, err
+	}
+
+	var scrubbed yaml.MapSlice
+	var changed []string
+	for _, item := range config {
+		key := fmt.Sprint(item.Key)
+		if mirrorKey.MatchString(key) {
+			if u, err := url.Parse(fmt.Sprint(item.Value)); err == nil && u.User != nil {
+				u.User = nil
+				item.Value = u.String()
+				changed = append(changed, key)
+			}
+		} else if isCredential(key, fmt.Sprint(item.Value), sourceKeys) {
+			changed = append(changed, key)
+			continue
+		}
+		scrubbed = append(scrubbed, item)
+	}
+	if len(changed) == 0 {
+		return data, nil, nil
+	}
+
+	out, err := yaml.Marshal(scrubbed)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append([]byte("---\n"), out...), changed, nil
+}
+
+// AddBundleCredentials masks the source credentials of the app in buildDir,
+// set as BUNDLE_* environment variables or checked into .bundle/config
+func (r *Redactor) AddBundleCredentials(buildDir string, environ []string) error {
+	gemfile := "Gemfile"
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, "BUNDLE_GEMFILE=") && kv != "BUNDLE_GEMFILE=" {
+			gemfile = strings.TrimPrefix(kv, "BUNDLE_GEMFILE=")
+		}
+	}
+	sourceKeys := SourceKeys(filepath.Join(buildDir, gemfile) + ".lock")
+
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 && isCredential(parts[0], parts[1], sourceKeys) {
+			r.addSecret(parts[1])
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(buildDir, ".bundle", "config"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var config yaml.MapSlice
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		// bundler will refuse to use it, there are no credentials to leak
+		return nil
+	}
+	for _, item := range config {
+		key, value := fmt.Sprint(item.Key), fmt.Sprint(item.Value)
+		if mirrorKey.MatchString(key) {
+			if u, err := url.Parse(value); err == nil && u.User != nil {
+				if password, set := u.User.Password(); set {
+					r.addSecret(password)
+				} else {
+					r.addSecret(u.User.Username())
+				}
+			}
+		} else if isCredential(key, value, sourceKeys) {
+			r.addSecret(value)
+		}
+	}
+	return nil
+}
+
+func (r *Redactor) addSecret(secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+	r.secrets = append(r.secrets, secret)
+	r.sortSecrets()
+}
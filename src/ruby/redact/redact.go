@@ -0,0 +1,128 @@
+package redact
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const Mask = "[REDACTED]"
+
+// minSecretLength keeps short values such as "1", "true" or "false" from
+// being masked everywhere they appear in the log
+const minSecretLength = 8
+
+// DefaultEnvPatterns match the names of environment variables whose values
+// are always masked. Bundler source credentials are found by
+// AddBundleCredentials.
+var DefaultEnvPatterns = []string{
+	`TOKEN`,
+	`SECRET`,
+	`PASSWORD`,
+	`SIGNING_KEY`,
+}
+
+type Redactor struct {
+	secrets  []string
+	patterns []*regexp.Regexp
+}
+
+// New masks the values of environment variables whose names match
+// DefaultEnvPatterns, plus any text matching the operator supplied
+// comma separated list of regular expressions.
+func New(environ []string, operatorPatterns string) (*Redactor, error) {
+	r := &Redactor{}
+
+	var envPatterns []*regexp.Regexp
+	for _, pattern := range DefaultEnvPatterns {
+		envPatterns = append(envPatterns, regexp.MustCompile("(?i)"+pattern))
+	}
+
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || len(parts[1]) < minSecretLength {
+			continue
+		}
+		for _, pattern := range envPatterns {
+			if pattern.MatchString(parts[0]) {
+				r.secrets = append(r.secrets, parts[1])
+				break
+			}
+		}
+	}
+	r.sortSecrets()
+
+	for _, pattern := range strings.Split(operatorPatterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %s: %v", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	return r, nil
+}
+
+// sortSecrets puts longer secrets first so a secret containing another is
+// fully masked
+func (r *Redactor) sortSecrets() {
+	sort.Slice(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+}
+
+func (r *Redactor) Redact(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.Replace(s, secret, Mask, -1)
+	}
+	for _, re := range r.patterns {
+		s = re.ReplaceAllLiteralString(s, Mask)
+	}
+	return s
+}
+
+// Writer redacts everything written to w. Output is held back until a
+// full line is available so a secret split across writes is still masked;
+// call Close to flush a trailing partial line.
+func (r *Redactor) Writer(w io.Writer) io.WriteCloser {
+	return &writer{redactor: r, w: w}
+}
+
+type writer struct {
+	redactor *Redactor
+	w        io.Writer
+	buf      bytes.Buffer
+	mu       sync.Mutex
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	if idx := bytes.LastIndexByte(w.buf.Bytes(), '\n'); idx >= 0 {
+		lines := string(w.buf.Next(idx + 1))
+		if _, err := io.WriteString(w.w, w.redactor.Redact(lines)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.w, w.redactor.Redact(w.buf.String()))
+	w.buf.Reset()
+	return err
+}
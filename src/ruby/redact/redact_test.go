@@ -0,0 +1,78 @@
+package redact_test
+
+import (
+	"bytes"
+	"fmt"
+	"ruby/redact"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Redact", func() {
+	var environ []string
+
+	BeforeEach(func() {
+		environ = []string{
+			"GITHUB_TOKEN=ghtoken1234",
+			"secret_key_base=abcdef123456",
+			"DB_PASSWORD=hunter22",
+			"BP_ATTESTATION_SIGNING_KEY=c2lnbmluZ2tleQ==",
+			"RAILS_ENV=production",
+			"API_TOKEN=no",
+			"USE_SECRET_STORE=true",
+			"PASSWORD_RESET=disable",
+		}
+	})
+
+	Describe("Redact", func() {
+		It("masks the values of matching environment variables", func() {
+			r, err := redact.New(environ, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Redact("token=ghtoken1234 key=abcdef123456 pw=hunter22")).To(Equal("token=[REDACTED] key=[REDACTED] pw=[REDACTED]"))
+			Expect(r.Redact("signing with c2lnbmluZ2tleQ==")).To(Equal("signing with [REDACTED]"))
+		})
+
+		It("leaves other values and short values alone", func() {
+			r, err := redact.New(environ, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Redact("RAILS_ENV=production answer=no")).To(Equal("RAILS_ENV=production answer=no"))
+			Expect(r.Redact("cache=true reset=disable")).To(Equal("cache=true reset=disable"))
+		})
+
+		It("masks text matching operator patterns", func() {
+			r, err := redact.New(environ, `ghp_[A-Za-z0-9]+, https://[^@/]+@`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Redact("cloning https://me:pw@github.com with ghp_abc123")).To(Equal("cloning [REDACTED]github.com with [REDACTED]"))
+		})
+
+		It("rejects invalid operator patterns", func() {
+			_, err := redact.New(environ, "ok,(unclosed")
+			Expect(err).To(MatchError(ContainSubstring("invalid redaction pattern (unclosed")))
+		})
+	})
+
+	Describe("Writer", func() {
+		var (
+			buffer *bytes.Buffer
+			r      *redact.Redactor
+		)
+
+		BeforeEach(func() {
+			var err error
+			buffer = new(bytes.Buffer)
+			r, err = redact.New(environ, "")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("masks secrets split across writes", func() {
+			w := r.Writer(buffer)
+			fmt.Fprint(w, "Fetching with hunt")
+			Expect(buffer.String()).To(BeEmpty())
+			fmt.Fprint(w, "er22\nnext line")
+			Expect(buffer.String()).To(Equal("Fetching with [REDACTED]\n"))
+			Expect(w.Close()).To(Succeed())
+			Expect(buffer.String()).To(Equal("Fetching with [REDACTED]\nnext line"))
+		})
+	})
+})
@@ -0,0 +1,144 @@
+package report
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Contents are digests of what is in the droplet, so two deploys can be
+// compared without their droplets. The digest covers everything else in
+// Contents and is signed when the operator sets a signing secret.
+type Contents struct {
+	Gems      []Gem             `json:"gems"`
+	Assets    map[string]string `json:"assets,omitempty"`
+	Binaries  map[string]string `json:"binaries,omitempty"`
+	Digest    string            `json:"digest"`
+	Signature string            `json:"signature,omitempty"`
+}
+
+// Gem is an installed gem and the Gemfile groups which bring it in
+type Gem struct {
+	Name     string   `json:"name"`
+	Version  string   `json:"version"`
+	Platform string   `json:"platform,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	Digest   string   `json:"digest"`
+}
+
+// Seal computes the digest of the contents and, with a secret, signs it
+func (c *Contents) Seal(secret string) error {
+	digest, err := c.digest()
+	if err != nil {
+		return err
+	}
+	c.Digest = digest
+	c.Signature = ""
+	if secret != "" {
+		c.Signature = sign(digest, secret)
+	}
+	return nil
+}
+
+// Verify reports whether the contents still match their digest and were
+// signed with secret
+func (c *Contents) Verify(secret string) error {
+	digest, err := c.digest()
+	if err != nil {
+		return err
+	}
+	if digest != c.Digest {
+		return fmt.Errorf("contents do not match their digest")
+	}
+	if c.Signature == "" {
+		return fmt.Errorf("contents are not signed")
+	}
+	if !hmac.Equal([]byte(sign(digest, secret)), []byte(c.Signature)) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+func (c *Contents) digest() (string, error) {
+	unsealed := *c
+	unsealed.Digest, unsealed.Signature = "", ""
+	data, err := json.Marshal(unsealed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+func sign(digest, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(digest))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// HashFile returns the sha256 of a file's contents
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// HashDir returns a sha256 over the relative path and contents of every
+// file beneath dir, files whose slash separated path relative to dir skip
+// returns true for are left out.
+// Symlinks are hashed by their target so the digest does not follow them.
+func HashDir(dir string, skip func(rel string) bool) (string, error) {
+	var lines []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if skip != nil && skip(filepath.ToSlash(rel)) {
+			return nil
+		}
+
+		var sum string
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			sum = "-> " + target
+		} else if sum, err = HashFile(path); err != nil {
+			return err
+		}
+		lines = append(lines, filepath.ToSlash(rel)+" "+sum+"\n")
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(lines)
+	hash := sha256.New()
+	for _, line := range lines {
+		io.WriteString(hash, line)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,77 @@
+package report_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/report"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Contents", func() {
+	var contents *report.Contents
+
+	BeforeEach(func() {
+		contents = &report.Contents{
+			Gems:     []report.Gem{{Name: "rack", Version: "2.0.5", Groups: []string{"default"}, Digest: "abc"}},
+			Binaries: map[string]string{"deps/0/bin/ruby": "def"},
+		}
+	})
+
+	It("seals the contents with a signed digest", func() {
+		Expect(contents.Seal("s3cr3t")).To(Succeed())
+		Expect(contents.Digest).To(HavePrefix("sha256:"))
+		Expect(contents.Signature).To(HavePrefix("hmac-sha256:"))
+		Expect(contents.Verify("s3cr3t")).To(Succeed())
+	})
+
+	It("does not sign without a secret", func() {
+		Expect(contents.Seal("")).To(Succeed())
+		Expect(contents.Digest).ToNot(BeEmpty())
+		Expect(contents.Verify("s3cr3t")).To(MatchError("contents are not signed"))
+	})
+
+	It("detects contents changed after sealing", func() {
+		Expect(contents.Seal("s3cr3t")).To(Succeed())
+		contents.Gems[0].Version = "2.0.6"
+		Expect(contents.Verify("s3cr3t")).To(MatchError("contents do not match their digest"))
+	})
+
+	It("detects the wrong secret", func() {
+		Expect(contents.Seal("s3cr3t")).To(Succeed())
+		Expect(contents.Verify("other")).To(MatchError("signature does not match"))
+	})
+
+	Describe("HashDir", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "ruby-buildpack.hashdir.")
+			Expect(err).To(BeNil())
+			Expect(os.MkdirAll(filepath.Join(dir, "lib"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "lib", "rack.rb"), []byte("module Rack; end\n"), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("changes with the contents of a file", func() {
+			before, err := report.HashDir(dir, nil)
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "lib", "rack.rb"), []byte("module Rack; VERSION = 1; end\n"), 0644)).To(Succeed())
+			Expect(report.HashDir(dir, nil)).ToNot(Equal(before))
+		})
+
+		It("leaves out skipped files", func() {
+			before, err := report.HashDir(dir, nil)
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "lib", "mkmf.log"), []byte("checking for ruby.h"), 0644)).To(Succeed())
+			Expect(report.HashDir(dir, func(rel string) bool { return rel == "lib/mkmf.log" })).To(Equal(before))
+			Expect(report.HashDir(dir, nil)).ToNot(Equal(before))
+		})
+	})
+})
@@ -0,0 +1,79 @@
+package report
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Host is an outbound host staging contacted, or had bundler or yarn
+// contact, with what for, so egress rules for the staging cells can be
+// written host by host
+type Host struct {
+	// Host is host:port
+	Host     string   `json:"host"`
+	Purposes []string `json:"purposes"`
+}
+
+// defaultPorts are the ports of the URL schemes staging uses, git+https and
+// the like use the port of their transport
+var defaultPorts = map[string]string{"https": "443", "http": "80", "ssh": "22", "git": "9418"}
+
+// EgressHost returns the host:port rawURL connects to, or "" when it does
+// not go over the network, such as the file:// URIs of a cached buildpack.
+// scp like git remotes, git@github.com:acme/app.git, connect over ssh.
+func EgressHost(rawURL string) string {
+	if !strings.Contains(rawURL, "://") {
+		if at := strings.Index(rawURL, "@"); at >= 0 {
+			if colon := strings.Index(rawURL[at:], ":"); colon > 1 {
+				return net.JoinHostPort(rawURL[at+1:at+colon], "22")
+			}
+		}
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	port := u.Port()
+	if port == "" {
+		scheme := u.Scheme
+		if i := strings.LastIndex(scheme, "+"); i >= 0 {
+			scheme = scheme[i+1:]
+		}
+		if port = defaultPorts[scheme]; port == "" {
+			return ""
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// AddEgress records that the hosts of rawURLs were contacted for purpose
+func (r *Report) AddEgress(purpose string, rawURLs ...string) {
+	for _, rawURL := range rawURLs {
+		host := EgressHost(rawURL)
+		if host == "" {
+			continue
+		}
+		i := sort.Search(len(r.Egress), func(i int) bool { return r.Egress[i].Host >= host })
+		if i == len(r.Egress) || r.Egress[i].Host != host {
+			r.Egress = append(r.Egress, Host{})
+			copy(r.Egress[i+1:], r.Egress[i:])
+			r.Egress[i] = Host{Host: host}
+		}
+		purposes := r.Egress[i].Purposes
+		j := sort.SearchStrings(purposes, purpose)
+		if j == len(purposes) || purposes[j] != purpose {
+			purposes = append(purposes, "")
+			copy(purposes[j+1:], purposes[j:])
+			purposes[j] = purpose
+		}
+		r.Egress[i].Purposes = purposes
+	}
+}
+
+// RecordEgress adds the hosts of rawURLs to the report in depDir
+func RecordEgress(depDir, purpose string, rawURLs ...string) error {
+	return Update(depDir, func(r *Report) { r.AddEgress(purpose, rawURLs...) })
+}
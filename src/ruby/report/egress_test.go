@@ -0,0 +1,39 @@
+package report_test
+
+import (
+	"ruby/report"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Egress", func() {
+	It("finds the host and port each URL connects to", func() {
+		for url, host := range map[string]string{
+			"https://buildpacks.cloudfoundry.org/dependencies/ruby/ruby-2.5.1.tgz": "buildpacks.cloudfoundry.org:443",
+			"http://Gems.Example.com:8080/":                                        "gems.example.com:8080",
+			"git+https://github.com/acme/left-pad.git#abc":                         "github.com:443",
+			"git://github.com/acme/rack.git":                                       "github.com:9418",
+			"git@github.com:acme/rails.git":                                        "github.com:22",
+			"https://[::1]:9292/":                                                  "[::1]:9292",
+			"file:///tmp/buildpack/dependencies/ruby.tgz":                          "",
+			"vendor/cache": "",
+		} {
+			Expect(report.EgressHost(url)).To(Equal(host), url)
+		}
+	})
+
+	It("lists each host once, sorted, with every purpose", func() {
+		r := &report.Report{}
+		r.AddEgress("gem source", "https://rubygems.org/", "https://gems.example.com/private/")
+		r.AddEgress("download of ruby", "https://buildpacks.cloudfoundry.org/ruby.tgz", "file:///buildpack/ruby.tgz")
+		r.AddEgress("gem fallback source", "https://rubygems.org/")
+		r.AddEgress("gem source", "https://rubygems.org/")
+
+		Expect(r.Egress).To(Equal([]report.Host{
+			{Host: "buildpacks.cloudfoundry.org:443", Purposes: []string{"download of ruby"}},
+			{Host: "gems.example.com:443", Purposes: []string{"gem source"}},
+			{Host: "rubygems.org:443", Purposes: []string{"gem fallback source", "gem source"}},
+		}))
+	})
+})
@@ -0,0 +1,187 @@
+// Package report builds the staging report, a JSON summary of what the
+// buildpack installed and detected. Supply and finalize run as separate
+// processes so each step loads the report from the dep dir, adds to it and
+// saves it again; it ends up in the droplet alongside the dependencies.
+package report
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ruby/procstat"
+	"ruby/provenance"
+	"ruby/resolution"
+	"ruby/toolchain"
+)
+
+const File = "staging_report.json"
+
+type Report struct {
+	Toolchain toolchain.Versions `json:"toolchain"`
+	Engine    string             `json:"engine,omitempty"`
+	Ruby      string             `json:"ruby,omitempty"`
+	Rubygems  string             `json:"rubygems,omitempty"`
+	Revision  string             `json:"revision,omitempty"`
+	Contents  *Contents          `json:"contents,omitempty"`
+	Metrics   *Metrics           `json:"metrics,omitempty"`
+	// DeveloperRuby is the BP_RUBY_URL ruby was installed from, staging
+	// with it is unsupported
+	DeveloperRuby string `json:"developer_ruby,omitempty"`
+	// InsecureGemSources are the gem sources found using plain http
+	InsecureGemSources []string `json:"insecure_gem_sources,omitempty"`
+	// GemChecksums are the sha256 of the installed gem packages, recorded
+	// for apps whose Gemfile.lock has no CHECKSUMS section
+	GemChecksums map[string]string `json:"gem_checksums,omitempty"`
+	// Permissions are the file modes finalize changed in the droplet
+	Permissions *Permissions `json:"permissions,omitempty"`
+	// Conflicts explain why bundler could not resolve the Gemfile
+	Conflicts []resolution.Conflict `json:"conflicts,omitempty"`
+	// Deprecations are the IDs of the deprecated behaviors the app uses
+	Deprecations []string `json:"deprecations,omitempty"`
+	// Dependencies are where supply got each dependency it installed,
+	// finalize attests to them in provenance.File
+	Dependencies []provenance.Dependency `json:"dependencies,omitempty"`
+	// Egress are the outbound hosts staging contacted, sorted by host
+	Egress []Host `json:"egress,omitempty"`
+	// CompressedAssets are the variants finalize wrote of the compiled assets
+	CompressedAssets *CompressedAssets `json:"compressed_assets,omitempty"`
+}
+
+// CompressedAssets count the .gz and .br files added to the droplet and the
+// bytes they add to it
+type CompressedAssets struct {
+	Gzip   int   `json:"gzip"`
+	Brotli int   `json:"brotli"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// MaxPermissionChanges limits how many changed files the report lists
+const MaxPermissionChanges = 100
+
+type Permissions struct {
+	Changed int `json:"changed"`
+	// Files are the first MaxPermissionChanges files changed, such as
+	// "deps/0/vendor_bundle/ruby/2.5.0/gems/rack-2.0.5/README.md 0666 -> 0644"
+	Files []string `json:"files,omitempty"`
+}
+
+// Metrics measure the staging itself, finalize renders them for prometheus
+type Metrics struct {
+	// Durations are the seconds each staging phase took
+	Durations map[string]float64 `json:"durations,omitempty"`
+	// CacheHits record whether each cached directory was restored
+	CacheHits map[string]bool `json:"cache_hits,omitempty"`
+	// Components are the seconds installing each part of the droplet took
+	Components map[string]Component `json:"components,omitempty"`
+	// CacheSaves measure saving each cached directory
+	CacheSaves map[string]CacheSave `json:"cache_saves,omitempty"`
+	// Resources are the CPU time and disk IO of each staging phase
+	Resources map[string]procstat.Usage `json:"resources,omitempty"`
+}
+
+// CacheSave is how a directory was saved to the cache. Bytes and Stored,
+// its size before and after compression, are only measured when it is
+// compressed.
+type CacheSave struct {
+	Compression string  `json:"compression"`
+	Seconds     float64 `json:"seconds"`
+	Bytes       int64   `json:"bytes,omitempty"`
+	Stored      int64   `json:"stored,omitempty"`
+}
+
+// Component is warm when it was installed on top of what the app cache or an
+// earlier run of supply left behind, and cold when it started from nothing
+type Component struct {
+	Seconds float64 `json:"seconds"`
+	Warm    bool    `json:"warm"`
+}
+
+// Load reads the report from depDir, an empty report is returned when no
+// step has saved one yet
+func Load(depDir string) (*Report, error) {
+	r := &Report{}
+	data, err := ioutil.ReadFile(filepath.Join(depDir, File))
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Report) Save(depDir string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(depDir, File), append(data, '\n'), 0644)
+}
+
+// Update loads the report from depDir, applies change and saves it
+func Update(depDir string, change func(*Report)) error {
+	r, err := Load(depDir)
+	if err != nil {
+		return err
+	}
+	change(r)
+	return r.Save(depDir)
+}
+
+// RecordDuration adds the time since start as the duration of phase
+func RecordDuration(depDir, phase string, start time.Time) error {
+	return Update(depDir, func(r *Report) {
+		if r.Metrics == nil {
+			r.Metrics = &Metrics{}
+		}
+		if r.Metrics.Durations == nil {
+			r.Metrics.Durations = map[string]float64{}
+		}
+		r.Metrics.Durations[phase] = time.Since(start).Seconds()
+	})
+}
+
+// RecordCacheHit records whether the cached directory name was restored
+func RecordCacheHit(depDir, name string, hit bool) error {
+	return Update(depDir, func(r *Report) {
+		if r.Metrics == nil {
+			r.Metrics = &Metrics{}
+		}
+		if r.Metrics.CacheHits == nil {
+			r.Metrics.CacheHits = map[string]bool{}
+		}
+		r.Metrics.CacheHits[name] = hit
+	})
+}
+
+// RecordComponent adds the time since start as the install time of the
+// component name
+func RecordComponent(depDir, name string, start time.Time, warm bool) error {
+	return Update(depDir, func(r *Report) {
+		if r.Metrics == nil {
+			r.Metrics = &Metrics{}
+		}
+		if r.Metrics.Components == nil {
+			r.Metrics.Components = map[string]Component{}
+		}
+		r.Metrics.Components[name] = Component{Seconds: time.Since(start).Seconds(), Warm: warm}
+	})
+}
+
+// RecordResources records usage as the CPU time and disk IO of phase
+func RecordResources(depDir, phase string, usage procstat.Usage) error {
+	return Update(depDir, func(r *Report) {
+		if r.Metrics == nil {
+			r.Metrics = &Metrics{}
+		}
+		if r.Metrics.Resources == nil {
+			r.Metrics.Resources = map[string]procstat.Usage{}
+		}
+		r.Metrics.Resources[phase] = usage
+	})
+}
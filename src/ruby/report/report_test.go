@@ -0,0 +1,73 @@
+package report_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/report"
+	"ruby/toolchain"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Report", func() {
+	var depDir string
+
+	BeforeEach(func() {
+		var err error
+		depDir, err = ioutil.TempDir("", "ruby-buildpack.dep.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(depDir)).To(Succeed())
+	})
+
+	It("is empty before it is saved", func() {
+		r, err := report.Load(depDir)
+		Expect(err).To(BeNil())
+		Expect(r).To(Equal(&report.Report{}))
+	})
+
+	It("keeps what earlier steps added", func() {
+		tools := toolchain.Versions{GCC: "7.5.0", Libc: "2.27"}
+		Expect(report.Update(depDir, func(r *report.Report) { r.Toolchain = tools })).To(Succeed())
+
+		r, err := report.Load(depDir)
+		Expect(err).To(BeNil())
+		Expect(r.Toolchain).To(Equal(tools))
+
+		data, err := ioutil.ReadFile(filepath.Join(depDir, "staging_report.json"))
+		Expect(err).To(BeNil())
+		Expect(string(data)).To(ContainSubstring(`"gcc": "7.5.0"`))
+	})
+
+	It("returns an error for a corrupt report", func() {
+		Expect(ioutil.WriteFile(filepath.Join(depDir, "staging_report.json"), []byte("{"), 0644)).To(Succeed())
+		_, err := report.Load(depDir)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("records phase durations and cache hits", func() {
+		Expect(report.RecordDuration(depDir, "install_gems", time.Now().Add(-2*time.Second))).To(Succeed())
+		Expect(report.RecordCacheHit(depDir, "vendor_bundle", true)).To(Succeed())
+		Expect(report.RecordCacheHit(depDir, "node_modules", false)).To(Succeed())
+
+		r, err := report.Load(depDir)
+		Expect(err).To(BeNil())
+		Expect(r.Metrics.Durations["install_gems"]).To(BeNumerically("~", 2, 0.5))
+		Expect(r.Metrics.CacheHits).To(Equal(map[string]bool{"vendor_bundle": true, "node_modules": false}))
+	})
+	It("records how long each component took", func() {
+		Expect(report.RecordComponent(depDir, "gems", time.Now().Add(-3*time.Second), true)).To(Succeed())
+		Expect(report.RecordComponent(depDir, "assets", time.Now(), false)).To(Succeed())
+
+		r, err := report.Load(depDir)
+		Expect(err).To(BeNil())
+		Expect(r.Metrics.Components["gems"].Seconds).To(BeNumerically("~", 3, 0.5))
+		Expect(r.Metrics.Components["gems"].Warm).To(BeTrue())
+		Expect(r.Metrics.Components["assets"].Warm).To(BeFalse())
+	})
+})
@@ -0,0 +1,125 @@
+// Package resolution explains why bundler could not resolve the Gemfile.
+// Bundler prints every chain of dependencies leading to a conflicting gem,
+// for a rails app that is easily hundreds of lines which end up far above
+// the end of the staging log. Parse condenses them to which gem wants which
+// version of the conflicting gem.
+package resolution
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	conflictLine = regexp.MustCompile(`^Bundler could not find compatible versions for gem "([^"]+)":$`)
+	sectionLine  = regexp.MustCompile(`^ {2}In (.+):$`)
+	stepLine     = regexp.MustCompile(`^( {4,})(\S+?)(?: \(([^)]*)\))?(?: was resolved to (\S+), which depends on)?$`)
+)
+
+// Conflict is a gem for which bundler found no version satisfying every
+// requirement
+type Conflict struct {
+	Gem          string        `json:"gem"`
+	Requirements []Requirement `json:"requirements"`
+}
+
+// Requirement is one version constraint on the conflicting gem. Via are the
+// resolved gems, outermost first, through which the Gemfile or its lock
+// asks for it; it is empty when they ask for the gem directly.
+type Requirement struct {
+	In          string   `json:"in"`
+	Via         []string `json:"via,omitempty"`
+	Requirement string   `json:"requirement"`
+}
+
+func (r Requirement) String() string {
+	requirement := r.Requirement
+	if requirement == "" {
+		requirement = "any version"
+	}
+	if len(r.Via) == 0 && strings.HasSuffix(r.In, ".lock") {
+		return fmt.Sprintf("%s locks %s", r.In, requirement)
+	} else if len(r.Via) == 0 {
+		return fmt.Sprintf("%s wants %s", r.In, requirement)
+	}
+	wants := fmt.Sprintf("%s wants %s", r.Via[len(r.Via)-1], requirement)
+	if len(r.Via) > 1 {
+		wants += fmt.Sprintf(" (through %s in %s)", strings.Join(r.Via[:len(r.Via)-1], ", "), r.In)
+	} else {
+		wants += fmt.Sprintf(" (in %s)", r.In)
+	}
+	return wants
+}
+
+// Parse returns the conflicts in the output of bundle install, there are
+// none when it failed for any other reason
+func Parse(output string) []Conflict {
+	var (
+		conflicts []Conflict
+		conflict  *Conflict
+		section   string
+		via       []string
+	)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(strings.Replace(scanner.Text(), "\x00", "", -1), " \r")
+
+		if m := conflictLine.FindStringSubmatch(line); m != nil {
+			conflicts = append(conflicts, Conflict{Gem: m[1]})
+			conflict, section, via = &conflicts[len(conflicts)-1], "", nil
+			continue
+		}
+		if conflict == nil {
+			continue
+		}
+		if m := sectionLine.FindStringSubmatch(line); m != nil {
+			section, via = sourceName(m[1]), nil
+			continue
+		}
+		if line == "" {
+			via = nil
+			continue
+		}
+
+		m := stepLine.FindStringSubmatch(line)
+		if m == nil || section == "" {
+			// The explanation has ended
+			conflict = nil
+			continue
+		}
+		depth := (len(m[1]) - 4) / 2
+		if depth < len(via) {
+			via = via[:depth]
+		}
+		if m[4] != "" {
+			via = append(via, m[2]+" "+m[4])
+			continue
+		}
+		conflict.Requirements = append(conflict.Requirements, Requirement{In: section, Via: append([]string{}, via...), Requirement: m[3]})
+		via = nil
+	}
+	return conflicts
+}
+
+// sourceName shortens "snapshot (Gemfile.lock)" to Gemfile.lock
+func sourceName(section string) string {
+	if strings.HasPrefix(section, "snapshot (") && strings.HasSuffix(section, ")") {
+		return strings.TrimSuffix(strings.TrimPrefix(section, "snapshot ("), ")")
+	}
+	return section
+}
+
+// Summary renders the conflicts as a few lines, one per requirement
+func Summary(conflicts []Conflict) string {
+	var lines []string
+	for _, conflict := range conflicts {
+		lines = append(lines, fmt.Sprintf("No version of %s satisfies every requirement on it:", conflict.Gem))
+		for _, requirement := range conflict.Requirements {
+			lines = append(lines, "  "+requirement.String())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
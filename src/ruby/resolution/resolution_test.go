@@ -0,0 +1,69 @@
+package resolution_test
+
+import (
+	"ruby/resolution"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const conflictOutput = `Fetching gem metadata from https://rubygems.org/.........
+Resolving dependencies...
+Bundler could not find compatible versions for gem "rack":
+  In snapshot (Gemfile.lock):
+    rack (= 1.6.0)
+
+  In Gemfile:
+    rails (= 5.1.4) was resolved to 5.1.4, which depends on
+      actionpack (= 5.1.4) was resolved to 5.1.4, which depends on
+        rack (~> 2.0)
+
+    sinatra (~> 1.4) was resolved to 1.4.8, which depends on
+      rack (~> 1.5)
+
+Running ` + "`bundle update`" + ` will rebuild your snapshot from scratch, using only
+the gems in your Gemfile, which may resolve the conflict.
+`
+
+var _ = Describe("Resolution", func() {
+	Describe("Parse", func() {
+		It("condenses the requirement chains of each conflicting gem", func() {
+			Expect(resolution.Parse(conflictOutput)).To(Equal([]resolution.Conflict{{
+				Gem: "rack",
+				Requirements: []resolution.Requirement{
+					{In: "Gemfile.lock", Via: []string{}, Requirement: "= 1.6.0"},
+					{In: "Gemfile", Via: []string{"rails 5.1.4", "actionpack 5.1.4"}, Requirement: "~> 2.0"},
+					{In: "Gemfile", Via: []string{"sinatra 1.4.8"}, Requirement: "~> 1.5"},
+				},
+			}}))
+		})
+
+		It("parses conflicts on the ruby version", func() {
+			output := "Bundler could not find compatible versions for gem \"ruby\x00\":\n" +
+				"  In Gemfile:\n" +
+				"    ruby\x00 (~> 2.6.0)\n\n" +
+				"    nokogiri (= 1.13.0) was resolved to 1.13.0, which depends on\n" +
+				"      ruby\x00 (>= 2.7.0)\n"
+
+			conflicts := resolution.Parse(output)
+			Expect(conflicts).To(HaveLen(1))
+			Expect(conflicts[0].Gem).To(Equal("ruby"))
+			Expect(conflicts[0].Requirements).To(HaveLen(2))
+			Expect(conflicts[0].Requirements[1].Via).To(Equal([]string{"nokogiri 1.13.0"}))
+			Expect(conflicts[0].Requirements[1].Requirement).To(Equal(">= 2.7.0"))
+		})
+
+		It("returns nothing for other failures", func() {
+			Expect(resolution.Parse("Gem::Ext::BuildError: ERROR: Failed to build gem native extension.\n")).To(BeEmpty())
+		})
+	})
+
+	Describe("Summary", func() {
+		It("renders a line per requirement", func() {
+			Expect(resolution.Summary(resolution.Parse(conflictOutput))).To(Equal("No version of rack satisfies every requirement on it:\n" +
+				"  Gemfile.lock locks = 1.6.0\n" +
+				"  actionpack 5.1.4 wants ~> 2.0 (through rails 5.1.4 in Gemfile)\n" +
+				"  sinatra 1.4.8 wants ~> 1.5 (in Gemfile)"))
+		})
+	})
+})
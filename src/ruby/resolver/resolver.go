@@ -0,0 +1,184 @@
+// Package resolver decides which versions of the buildpack's dependencies
+// may be installed. Operators packaging their own buildpack can ship a
+// version_policy.yml to deny versions, require minimum patch levels and
+// name version aliases without changing the resolution code.
+package resolver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/cloudfoundry/libbuildpack"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// PolicyFile is where a custom packaged buildpack keeps its policy, relative
+// to the buildpack directory
+const PolicyFile = "version_policy.yml"
+
+// Resolver picks dependency versions from the ones a buildpack ships
+type Resolver interface {
+	// AllDependencyVersions are the versions of name which may be installed
+	AllDependencyVersions(name string) []string
+	// DefaultVersion is installed when the app does not ask for a version
+	DefaultVersion(name string) (libbuildpack.Dependency, error)
+	// FindMatchingVersion is the newest version of name matching constraint
+	FindMatchingVersion(name, constraint string) (string, error)
+}
+
+type Manifest interface {
+	AllDependencyVersions(string) []string
+	DefaultVersion(string) (libbuildpack.Dependency, error)
+}
+
+type manifestResolver struct {
+	manifest Manifest
+}
+
+// New resolves versions straight from the buildpack's manifest
+func New(manifest Manifest) Resolver {
+	return &manifestResolver{manifest: manifest}
+}
+
+func (m *manifestResolver) AllDependencyVersions(name string) []string {
+	return m.manifest.AllDependencyVersions(name)
+}
+
+func (m *manifestResolver) DefaultVersion(name string) (libbuildpack.Dependency, error) {
+	return m.manifest.DefaultVersion(name)
+}
+
+func (m *manifestResolver) FindMatchingVersion(name, constraint string) (string, error) {
+	return libbuildpack.FindMatchingVersion(constraint, m.manifest.AllDependencyVersions(name))
+}
+
+// Rule is the operator policy for one dependency
+type Rule struct {
+	// Deny are versions or constraints such as 2.4.x which are never installed
+	Deny []string `yaml:"deny"`
+	// MinimumPatch maps a version line such as 2.5 to its lowest allowed version
+	MinimumPatch map[string]string `yaml:"minimum_patch"`
+	// Aliases name constraints, e.g. lts: 2.5.x
+	Aliases map[string]string `yaml:"aliases"`
+}
+
+// Policy applies operator rules on top of another Resolver
+type Policy struct {
+	Rules map[string]Rule
+	Next  Resolver
+}
+
+// Load wraps next with the policy in path, without a policy file next is
+// returned as is
+func Load(next Resolver, path string) (Resolver, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return next, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	rules := map[string]Rule{}
+	if err := yaml.UnmarshalStrict(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s is invalid: %v", PolicyFile, err)
+	}
+	for name, rule := range rules {
+		for line, minimum := range rule.MinimumPatch {
+			if _, err := semver.NewVersion(minimum); err != nil || !strings.HasPrefix(minimum, line+".") {
+				return nil, fmt.Errorf("%s is invalid: %s.minimum_patch.%s must be a %s.x version, got %s", PolicyFile, name, line, line, minimum)
+			}
+		}
+	}
+	return &Policy{Rules: rules, Next: next}, nil
+}
+
+func (p *Policy) AllDependencyVersions(name string) []string {
+	var allowed []string
+	for _, version := range p.Next.AllDependencyVersions(name) {
+		if p.denied(name, version) == "" {
+			allowed = append(allowed, version)
+		}
+	}
+	return allowed
+}
+
+// DefaultVersion replaces a denied default with the newest allowed version
+// of the same line
+func (p *Policy) DefaultVersion(name string) (libbuildpack.Dependency, error) {
+	dep, err := p.Next.DefaultVersion(name)
+	if err != nil {
+		return dep, err
+	}
+	if p.denied(name, dep.Version) == "" {
+		return dep, nil
+	}
+
+	line := dep.Version
+	if v, err := semver.NewVersion(dep.Version); err == nil {
+		line = fmt.Sprintf("%d.%d.x", v.Major(), v.Minor())
+	}
+	version, err := p.FindMatchingVersion(name, line)
+	if err != nil {
+		return dep, fmt.Errorf("the default %s %s is %s and no other %s version is allowed", name, dep.Version, p.denied(name, dep.Version), line)
+	}
+	return libbuildpack.Dependency{Name: name, Version: version}, nil
+}
+
+// FindMatchingVersion expands aliases and only considers allowed versions,
+// when policy rules out every match the error says which rule did
+func (p *Policy) FindMatchingVersion(name, constraint string) (string, error) {
+	if alias, found := p.Rules[name].Aliases[constraint]; found {
+		constraint = alias
+	}
+
+	version, err := libbuildpack.FindMatchingVersion(constraint, p.AllDependencyVersions(name))
+	if err == nil {
+		return version, nil
+	}
+	if shipped, shippedErr := libbuildpack.FindMatchingVersion(constraint, p.Next.AllDependencyVersions(name)); shippedErr == nil {
+		return "", fmt.Errorf("%s %s is %s", name, shipped, p.denied(name, shipped))
+	}
+	return "", err
+}
+
+// denied explains why policy rules out a version, empty when it is allowed
+func (p *Policy) denied(name, version string) string {
+	rule, found := p.Rules[name]
+	if !found {
+		return ""
+	}
+
+	for _, deny := range rule.Deny {
+		if deny == version {
+			return "denied by the operator's version policy"
+		}
+		if _, err := libbuildpack.FindMatchingVersion(deny, []string{version}); err == nil {
+			return fmt.Sprintf("denied by the operator's version policy (%s)", deny)
+		}
+	}
+
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return ""
+	}
+	if minimum, found := rule.MinimumPatch[fmt.Sprintf("%d.%d", v.Major(), v.Minor())]; found {
+		if min, err := semver.NewVersion(minimum); err == nil && v.LessThan(min) {
+			return fmt.Sprintf("below the minimum patch level %s of the operator's version policy", minimum)
+		}
+	}
+	return ""
+}
+
+// Describe summarises the policy for the staging log
+func (p *Policy) Describe() string {
+	var names []string
+	for name := range p.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,145 @@
+package resolver_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/resolver"
+
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeManifest struct {
+	versions map[string][]string
+	defaults map[string]string
+}
+
+func (m *fakeManifest) AllDependencyVersions(name string) []string {
+	return m.versions[name]
+}
+
+func (m *fakeManifest) DefaultVersion(name string) (libbuildpack.Dependency, error) {
+	version, found := m.defaults[name]
+	if !found {
+		return libbuildpack.Dependency{}, fmt.Errorf("no default version for %s", name)
+	}
+	return libbuildpack.Dependency{Name: name, Version: version}, nil
+}
+
+var _ = Describe("Resolver", func() {
+	var (
+		dir      string
+		manifest *fakeManifest
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ruby-buildpack.resolver.")
+		Expect(err).To(BeNil())
+
+		manifest = &fakeManifest{
+			versions: map[string][]string{
+				"ruby": {"2.3.7", "2.4.3", "2.4.4", "2.5.0", "2.5.1"},
+				"node": {"8.11.3"},
+			},
+			defaults: map[string]string{"ruby": "2.4.3"},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	load := func(policy string) resolver.Resolver {
+		path := filepath.Join(dir, resolver.PolicyFile)
+		Expect(ioutil.WriteFile(path, []byte(policy), 0644)).To(Succeed())
+		r, err := resolver.Load(resolver.New(manifest), path)
+		Expect(err).To(BeNil())
+		return r
+	}
+
+	Describe("New", func() {
+		It("resolves from the manifest", func() {
+			r := resolver.New(manifest)
+			Expect(r.AllDependencyVersions("ruby")).To(HaveLen(5))
+			Expect(r.FindMatchingVersion("ruby", "2.4.x")).To(Equal("2.4.4"))
+			Expect(r.DefaultVersion("ruby")).To(Equal(libbuildpack.Dependency{Name: "ruby", Version: "2.4.3"}))
+		})
+	})
+
+	Describe("Load", func() {
+		It("returns the resolver when there is no policy", func() {
+			next := resolver.New(manifest)
+			Expect(resolver.Load(next, filepath.Join(dir, resolver.PolicyFile))).To(BeIdenticalTo(next))
+		})
+
+		It("rejects unknown keys", func() {
+			Expect(ioutil.WriteFile(filepath.Join(dir, resolver.PolicyFile), []byte("ruby:\n  allow: [2.5.1]\n"), 0644)).To(Succeed())
+			_, err := resolver.Load(resolver.New(manifest), filepath.Join(dir, resolver.PolicyFile))
+			Expect(err).To(MatchError(ContainSubstring("version_policy.yml is invalid")))
+		})
+
+		It("rejects a minimum patch outside its line", func() {
+			Expect(ioutil.WriteFile(filepath.Join(dir, resolver.PolicyFile), []byte("ruby:\n  minimum_patch:\n    \"2.5\": 2.4.4\n"), 0644)).To(Succeed())
+			_, err := resolver.Load(resolver.New(manifest), filepath.Join(dir, resolver.PolicyFile))
+			Expect(err).To(MatchError("version_policy.yml is invalid: ruby.minimum_patch.2.5 must be a 2.5.x version, got 2.4.4"))
+		})
+	})
+
+	Describe("Policy", func() {
+		var r resolver.Resolver
+
+		BeforeEach(func() {
+			r = load(`---
+ruby:
+  deny:
+  - 2.3.x
+  - 2.5.0
+  minimum_patch:
+    "2.4": 2.4.4
+  aliases:
+    lts: 2.4.x
+    latest: x
+`)
+		})
+
+		It("leaves out denied versions and versions below the minimum patch", func() {
+			Expect(r.AllDependencyVersions("ruby")).To(Equal([]string{"2.4.4", "2.5.1"}))
+			Expect(r.AllDependencyVersions("node")).To(Equal([]string{"8.11.3"}))
+		})
+
+		It("expands aliases", func() {
+			Expect(r.FindMatchingVersion("ruby", "lts")).To(Equal("2.4.4"))
+			Expect(r.FindMatchingVersion("ruby", "latest")).To(Equal("2.5.1"))
+		})
+
+		It("explains which rule denied a version", func() {
+			_, err := r.FindMatchingVersion("ruby", "2.3.x")
+			Expect(err).To(MatchError("ruby 2.3.7 is denied by the operator's version policy (2.3.x)"))
+
+			_, err = r.FindMatchingVersion("ruby", "2.5.0")
+			Expect(err).To(MatchError("ruby 2.5.0 is denied by the operator's version policy"))
+
+			_, err = r.FindMatchingVersion("ruby", "2.4.3")
+			Expect(err).To(MatchError("ruby 2.4.3 is below the minimum patch level 2.4.4 of the operator's version policy"))
+		})
+
+		It("returns the manifest's error for versions the buildpack does not ship", func() {
+			_, err := r.FindMatchingVersion("ruby", "2.6.x")
+			Expect(err).To(MatchError("no match found for 2.6.x in [2.4.4 2.5.1]"))
+		})
+
+		It("replaces a denied default with the newest allowed version of its line", func() {
+			Expect(r.DefaultVersion("ruby")).To(Equal(libbuildpack.Dependency{Name: "ruby", Version: "2.4.4"}))
+		})
+
+		It("returns an error when no version of the default's line is allowed", func() {
+			manifest.defaults["ruby"] = "2.3.7"
+			_, err := r.DefaultVersion("ruby")
+			Expect(err).To(MatchError("the default ruby 2.3.7 is denied by the operator's version policy (2.3.x) and no other 2.3.x version is allowed"))
+		})
+	})
+})
@@ -0,0 +1,120 @@
+// Package revision finds the commit an app was pushed from, so a droplet can
+// be traced back to it. Capistrano style deploys leave the commit in a
+// REVISION file; otherwise it is read from the .git directory, without
+// needing git.
+package revision
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// File holds the revision in the root of the app
+const File = "REVISION"
+
+var objectName = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// Detect returns the revision of the app in appDir, or "" when it has
+// neither a REVISION file nor git metadata
+func Detect(appDir string) (string, error) {
+	if data, err := ioutil.ReadFile(filepath.Join(appDir, File)); err == nil {
+		if rev := firstLine(string(data)); rev != "" {
+			return rev, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	gitDir, err := findGitDir(appDir)
+	if err != nil || gitDir == "" {
+		return "", err
+	}
+	return head(gitDir)
+}
+
+// Short abbreviates a commit for log lines
+func Short(rev string) string {
+	if objectName.MatchString(rev) {
+		return rev[:12]
+	}
+	return rev
+}
+
+// findGitDir follows a .git file, as left by worktrees and submodules, to
+// the directory it points at
+func findGitDir(appDir string) (string, error) {
+	gitDir := filepath.Join(appDir, ".git")
+	info, err := os.Stat(gitDir)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	} else if info.IsDir() {
+		return gitDir, nil
+	}
+
+	data, err := ioutil.ReadFile(gitDir)
+	if err != nil {
+		return "", err
+	}
+	line := firstLine(string(data))
+	if !strings.HasPrefix(line, "gitdir:") {
+		return "", fmt.Errorf(".git is not a git directory")
+	}
+	dir := strings.TrimSpace(strings.TrimPrefix(line, "gitdir:"))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(appDir, dir)
+	}
+	return dir, nil
+}
+
+// head resolves HEAD to a commit, looking the branch up in packed-refs once
+// git gc has removed its loose ref
+func head(gitDir string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	line := firstLine(string(data))
+	if !strings.HasPrefix(line, "ref:") {
+		return commit(line)
+	}
+
+	ref := strings.TrimSpace(strings.TrimPrefix(line, "ref:"))
+	if data, err := ioutil.ReadFile(filepath.Join(gitDir, filepath.FromSlash(ref))); err == nil {
+		return commit(firstLine(string(data)))
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(gitDir, "packed-refs"))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[1] == ref {
+			return commit(fields[0])
+		}
+	}
+	// A branch without commits
+	return "", nil
+}
+
+func commit(name string) (string, error) {
+	if !objectName.MatchString(name) {
+		return "", fmt.Errorf("HEAD does not point at a commit: %q", name)
+	}
+	return name, nil
+}
+
+func firstLine(s string) string {
+	return strings.TrimSpace(strings.SplitN(s, "\n", 2)[0])
+}
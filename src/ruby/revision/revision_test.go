@@ -0,0 +1,85 @@
+package revision_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/revision"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Revision", func() {
+	const sha = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+	var appDir string
+
+	BeforeEach(func() {
+		var err error
+		appDir, err = ioutil.TempDir("", "ruby-buildpack.revision.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(appDir)).To(Succeed())
+	})
+
+	write := func(path, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(filepath.Join(appDir, path)), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(appDir, path), []byte(contents), 0644)).To(Succeed())
+	}
+
+	detect := func() string {
+		rev, err := revision.Detect(appDir)
+		Expect(err).To(BeNil())
+		return rev
+	}
+
+	It("is empty without a REVISION file or git metadata", func() {
+		Expect(detect()).To(Equal(""))
+	})
+
+	It("reads the REVISION file", func() {
+		write("REVISION", "v1.2.3\n")
+		write(".git/HEAD", sha+"\n")
+		Expect(detect()).To(Equal("v1.2.3"))
+	})
+
+	It("reads a detached HEAD", func() {
+		write(".git/HEAD", sha+"\n")
+		Expect(detect()).To(Equal(sha))
+	})
+
+	It("resolves the branch HEAD points at", func() {
+		write(".git/HEAD", "ref: refs/heads/main\n")
+		write(".git/refs/heads/main", sha+"\n")
+		Expect(detect()).To(Equal(sha))
+	})
+
+	It("resolves a packed branch", func() {
+		write(".git/HEAD", "ref: refs/heads/main\n")
+		write(".git/packed-refs", "# pack-refs with: peeled fully-peeled sorted\n"+sha+" refs/heads/main\n")
+		Expect(detect()).To(Equal(sha))
+	})
+
+	It("follows a .git file to the git directory", func() {
+		write("app/.git", "gitdir: ../repo/.git/worktrees/app\n")
+		write("repo/.git/worktrees/app/HEAD", sha+"\n")
+
+		rev, err := revision.Detect(filepath.Join(appDir, "app"))
+		Expect(err).To(BeNil())
+		Expect(rev).To(Equal(sha))
+	})
+
+	It("fails when HEAD does not point at a commit", func() {
+		write(".git/HEAD", "garbage\n")
+		_, err := revision.Detect(appDir)
+		Expect(err).To(MatchError(`HEAD does not point at a commit: "garbage"`))
+	})
+
+	It("abbreviates commits", func() {
+		Expect(revision.Short(sha)).To(Equal("4b825dc642cb"))
+		Expect(revision.Short("v1.2.3")).To(Equal("v1.2.3"))
+	})
+})
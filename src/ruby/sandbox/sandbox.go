@@ -0,0 +1,120 @@
+// Package sandbox runs the subprocesses of staging, such as bundler, rake,
+// yarn and node, with only an allowlisted environment. The staging container
+// carries platform internals, like VCAP_SERVICES and the credentials of the
+// droplet upload, which no tool needs, and the environment a tool sees is
+// then the same on every platform.
+package sandbox
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Allowed are the variables passed through, a trailing * matches any suffix
+var Allowed = []string{
+	"PATH", "HOME", "USER", "LANG", "LC_*", "TERM", "TMPDIR", "TZ",
+	"CF_STACK", "DEPS_DIR",
+	"GEM_*", "BUNDLE_*", "BUNDLER_*", "RUBY*", "RAILS_*", "RACK_*",
+	"DATABASE_URL", "SECRET_KEY_BASE",
+	"NODE_*", "NPM_*", "YARN_*",
+	"JAVA_HOME", "JAVA_OPTS", "JRUBY_OPTS",
+	"LD_LIBRARY_PATH", "LIBRARY_PATH", "CPATH", "C_INCLUDE_PATH", "PKG_CONFIG_PATH",
+	"CC", "CXX", "CFLAGS", "CXXFLAGS", "CPPFLAGS", "LDFLAGS", "MAKEFLAGS",
+	"CARGO_HOME", "RUSTUP_HOME", "NOKOGIRI_*",
+	"SSL_CERT_FILE", "SSL_CERT_DIR",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+}
+
+// Executor runs commands like libbuildpack.Command. When Enabled the
+// environment of each command is cut down to the variables matching Allowed
+// or Extra, plus the ones the caller set on the command itself.
+type Executor struct {
+	Enabled bool
+	Extra   []string
+	Environ func() []string
+}
+
+// New returns an executor reading the environment of the buildpack, extra
+// is a comma separated list of further names or patterns to allow
+func New(enabled bool, extra string) *Executor {
+	e := &Executor{Enabled: enabled, Environ: os.Environ}
+	for _, name := range strings.Split(extra, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			e.Extra = append(e.Extra, name)
+		}
+	}
+	return e
+}
+
+func (e *Executor) Execute(dir string, stdout io.Writer, stderr io.Writer, program string, args ...string) error {
+	cmd := exec.Command(program, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Dir = dir
+
+	return e.Run(cmd)
+}
+
+func (e *Executor) Output(dir string, program string, args ...string) (string, error) {
+	cmd := exec.Command(program, args...)
+	cmd.Stderr = os.Stderr
+	cmd.Dir = dir
+
+	e.restrict(cmd)
+	output, err := cmd.Output()
+	return string(output), err
+}
+
+func (e *Executor) Run(cmd *exec.Cmd) error {
+	e.restrict(cmd)
+	return cmd.Run()
+}
+
+func (e *Executor) restrict(cmd *exec.Cmd) {
+	if !e.Enabled {
+		return
+	}
+	cmd.Env = e.Env(cmd.Env)
+}
+
+// Env filters env, or the environment of the buildpack when env is nil.
+// Variables which env adds to or changes from the environment of the
+// buildpack were set on purpose and are kept.
+func (e *Executor) Env(env []string) []string {
+	inherited := map[string]string{}
+	for _, kv := range e.Environ() {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 {
+			inherited[parts[0]] = parts[1]
+		}
+	}
+	if env == nil {
+		env = e.Environ()
+	}
+
+	filtered := []string{}
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if value, found := inherited[parts[0]]; !found || value != parts[1] || e.allowed(parts[0]) {
+			filtered = append(filtered, kv)
+		}
+	}
+	return filtered
+}
+
+func (e *Executor) allowed(name string) bool {
+	for _, patterns := range [][]string{Allowed, e.Extra} {
+		for _, pattern := range patterns {
+			if strings.HasSuffix(pattern, "*") && strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+				return true
+			} else if pattern == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package sandbox_test
+
+import (
+	"bytes"
+	"os/exec"
+	"ruby/sandbox"
+	"sort"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sandbox", func() {
+	var executor *sandbox.Executor
+
+	BeforeEach(func() {
+		executor = &sandbox.Executor{
+			Enabled: true,
+			Environ: func() []string {
+				return []string{"PATH=/usr/bin:/bin", "BUNDLE_PATH=/deps/0/vendor_bundle", "LC_ALL=C", "VCAP_SERVICES={}", "CF_INSTANCE_KEY=/etc/cf/key"}
+			},
+		}
+	})
+
+	Describe("Env", func() {
+		It("keeps only the allowlisted variables", func() {
+			Expect(executor.Env(nil)).To(Equal([]string{"PATH=/usr/bin:/bin", "BUNDLE_PATH=/deps/0/vendor_bundle", "LC_ALL=C"}))
+		})
+
+		It("keeps the variables added to the command", func() {
+			env := executor.Env(append(executor.Environ(), "MAKEFLAGS=-j1", "SPROCKETS_CACHE=/tmp/assets"))
+			Expect(env).To(ContainElement("SPROCKETS_CACHE=/tmp/assets"))
+			Expect(env).ToNot(ContainElement("VCAP_SERVICES={}"))
+		})
+
+		It("keeps the variables the command changes", func() {
+			Expect(executor.Env([]string{"VCAP_SERVICES=[]", "CF_INSTANCE_KEY=/etc/cf/key"})).To(Equal([]string{"VCAP_SERVICES=[]"}))
+		})
+
+		It("allows further names and patterns", func() {
+			executor.Extra = []string{"VCAP_SERVICES", "CF_INSTANCE_*"}
+			Expect(executor.Env(nil)).To(HaveLen(5))
+		})
+	})
+
+	Describe("Execute", func() {
+		BeforeEach(func() {
+			if _, err := exec.LookPath("env"); err != nil {
+				Skip("env is not installed")
+			}
+		})
+
+		environment := func() []string {
+			output := new(bytes.Buffer)
+			Expect(executor.Execute("/", output, output, "env")).To(Succeed())
+			lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+			sort.Strings(lines)
+			return lines
+		}
+
+		It("runs the command with the allowlisted variables", func() {
+			Expect(environment()).To(Equal([]string{"BUNDLE_PATH=/deps/0/vendor_bundle", "LC_ALL=C", "PATH=/usr/bin:/bin"}))
+		})
+
+		It("passes the environment through unchanged when disabled", func() {
+			executor.Enabled = false
+			Expect(environment()).ToNot(Equal([]string{"BUNDLE_PATH=/deps/0/vendor_bundle", "LC_ALL=C", "PATH=/usr/bin:/bin"}))
+		})
+	})
+})
@@ -0,0 +1,191 @@
+// Package schedules reads the scheduled jobs an app declares for
+// sidekiq-scheduler, sidekiq-cron, resque-scheduler and whenever. A job
+// whose class or rake task does not exist is skipped by the scheduler at
+// runtime without failing, so finalize checks them while staging.
+package schedules
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Files are the schedule descriptors read, relative to the app. Only the
+// :schedule: of config/sidekiq.yml is read, the rest configures sidekiq.
+var Files = []string{"config/schedule.yml", "config/resque_schedule.yml", "config/sidekiq.yml", "config/schedule.rb"}
+
+// Job is a scheduled job, it runs either Class or the rake task Task.
+// Name is its name in File, or the line for whenever.
+type Job struct {
+	Name  string
+	File  string
+	Class string
+	Task  string
+}
+
+// timingKeys are the keys the schedulers accept for when a job runs
+var timingKeys = []string{"cron", "every", "at", "in", "interval"}
+
+var (
+	wheneverJob = regexp.MustCompile(`^\s*(rake|runner)\s*\(?\s*(["'])(.+?)["']`)
+	constant    = regexp.MustCompile(`^(?:::)?([A-Z][A-Za-z0-9_]*(?:::[A-Z][A-Za-z0-9_]*)*)`)
+)
+
+// Load reads the descriptors in buildDir. Problems need no code of the app
+// to be found, such as a job without a class or without a schedule.
+func Load(buildDir string) ([]Job, []string, error) {
+	var (
+		jobs     []Job
+		problems []string
+	)
+	for _, file := range Files {
+		data, err := ioutil.ReadFile(filepath.Join(buildDir, file))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		var (
+			found    []Job
+			problem  []string
+			parseErr error
+		)
+		if filepath.Ext(file) == ".rb" {
+			found = parseWhenever(file, string(data))
+		} else {
+			found, problem, parseErr = parseYAML(file, data)
+		}
+		if parseErr != nil {
+			if file == "config/sidekiq.yml" {
+				continue
+			}
+			return nil, nil, fmt.Errorf("could not parse %s: %v", file, parseErr)
+		}
+		jobs = append(jobs, found...)
+		problems = append(problems, problem...)
+	}
+	return jobs, problems, nil
+}
+
+// parseYAML reads a schedule.yml, a map or list of jobs, or the :schedule:
+// of a sidekiq.yml
+func parseYAML(file string, data []byte) ([]Job, []string, error) {
+	var descriptor interface{}
+	if err := yaml.Unmarshal(data, &descriptor); err != nil {
+		return nil, nil, err
+	}
+	if file == "config/sidekiq.yml" {
+		config := stringMap(descriptor)
+		descriptor = config["schedule"]
+		if descriptor == nil {
+			descriptor = stringMap(config["scheduler"])["schedule"]
+		}
+	}
+
+	entries := map[string]map[string]interface{}{}
+	switch descriptor := descriptor.(type) {
+	case map[interface{}]interface{}:
+		// The schedulers use the name of a job as its class when it has none
+		for name, entry := range stringMap(descriptor) {
+			job := stringMap(entry)
+			if job != nil && job["class"] == nil {
+				job["class"] = name
+			}
+			entries[name] = job
+		}
+	case []interface{}:
+		// sidekiq-cron also takes a list of jobs which name themselves
+		for i, entry := range descriptor {
+			job := stringMap(entry)
+			name, _ := job["name"].(string)
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			entries[name] = job
+		}
+	}
+
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		jobs     []Job
+		problems []string
+	)
+	for _, name := range names {
+		entry := entries[name]
+		if entry == nil {
+			problems = append(problems, fmt.Sprintf("%s in %s is not a job", name, file))
+			continue
+		}
+		class, _ := entry["class"].(string)
+		if class == "" {
+			problems = append(problems, fmt.Sprintf("%s in %s has no class", name, file))
+		}
+		if !hasTiming(entry) {
+			problems = append(problems, fmt.Sprintf("%s in %s has no %s", name, file, strings.Join(timingKeys, ", ")))
+		}
+		if class != "" {
+			jobs = append(jobs, Job{Name: name, File: file, Class: class})
+		}
+	}
+	return jobs, problems, nil
+}
+
+// parseWhenever finds the rake and runner jobs of a whenever schedule.rb.
+// Runners which do not start with a constant can not be checked.
+func parseWhenever(file, source string) []Job {
+	var jobs []Job
+	for _, line := range strings.Split(source, "\n") {
+		m := wheneverJob.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		job := Job{Name: strings.TrimSpace(line), File: file}
+		if fields := strings.Fields(m[3]); m[1] == "rake" && len(fields) > 0 {
+			job.Task = fields[0]
+			if i := strings.Index(job.Task, "["); i > 0 {
+				job.Task = job.Task[:i]
+			}
+		} else if c := constant.FindStringSubmatch(m[3]); m[1] == "runner" && c != nil {
+			job.Class = c[1]
+		} else {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+func hasTiming(entry map[string]interface{}) bool {
+	for _, key := range timingKeys {
+		if entry[key] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// stringMap is a YAML map with its keys as strings, the leading colons of
+// keys written as ruby symbols are dropped
+func stringMap(value interface{}) map[string]interface{} {
+	m, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil
+	}
+	result := map[string]interface{}{}
+	for key, value := range m {
+		result[strings.TrimPrefix(fmt.Sprint(key), ":")] = value
+	}
+	return result
+}
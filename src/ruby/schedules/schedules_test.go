@@ -0,0 +1,116 @@
+package schedules_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/schedules"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schedules", func() {
+	var buildDir string
+
+	write := func(file, contents string) {
+		Expect(os.MkdirAll(filepath.Join(buildDir, filepath.Dir(file)), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, file), []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		buildDir, err = ioutil.TempDir("", "ruby-buildpack.schedules.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("has no jobs without descriptors", func() {
+		jobs, problems, err := schedules.Load(buildDir)
+		Expect(err).To(BeNil())
+		Expect(jobs).To(BeEmpty())
+		Expect(problems).To(BeEmpty())
+	})
+
+	It("reads the jobs of a schedule.yml", func() {
+		write("config/schedule.yml", `
+cleanup:
+  cron: "0 3 * * *"
+  class: CleanupJob
+ReportJob:
+  every: 1h
+broken:
+  class: BrokenJob
+`)
+		jobs, problems, err := schedules.Load(buildDir)
+		Expect(err).To(BeNil())
+		Expect(jobs).To(Equal([]schedules.Job{
+			{Name: "ReportJob", File: "config/schedule.yml", Class: "ReportJob"},
+			{Name: "broken", File: "config/schedule.yml", Class: "BrokenJob"},
+			{Name: "cleanup", File: "config/schedule.yml", Class: "CleanupJob"},
+		}))
+		Expect(problems).To(Equal([]string{"broken in config/schedule.yml has no cron, every, at, in, interval"}))
+	})
+
+	It("reads the list of jobs sidekiq-cron takes", func() {
+		write("config/schedule.yml", `
+- name: cleanup
+  cron: "0 3 * * *"
+  class: CleanupJob
+- cron: "*/5 * * * *"
+`)
+		jobs, problems, err := schedules.Load(buildDir)
+		Expect(err).To(BeNil())
+		Expect(jobs).To(Equal([]schedules.Job{{Name: "cleanup", File: "config/schedule.yml", Class: "CleanupJob"}}))
+		Expect(problems).To(Equal([]string{"#2 in config/schedule.yml has no class"}))
+	})
+
+	It("reads the schedule of a sidekiq.yml", func() {
+		write("config/sidekiq.yml", `
+:concurrency: 5
+:scheduler:
+  :schedule:
+    hello:
+      :every: 30s
+      :class: HelloJob
+`)
+		jobs, problems, err := schedules.Load(buildDir)
+		Expect(err).To(BeNil())
+		Expect(jobs).To(Equal([]schedules.Job{{Name: "hello", File: "config/sidekiq.yml", Class: "HelloJob"}}))
+		Expect(problems).To(BeEmpty())
+	})
+
+	It("ignores a sidekiq.yml it can not parse", func() {
+		write("config/sidekiq.yml", ":concurrency: [5\n")
+		jobs, _, err := schedules.Load(buildDir)
+		Expect(err).To(BeNil())
+		Expect(jobs).To(BeEmpty())
+	})
+
+	It("fails on a schedule.yml it can not parse", func() {
+		write("config/schedule.yml", "cleanup: [\n")
+		_, _, err := schedules.Load(buildDir)
+		Expect(err).To(MatchError(HavePrefix("could not parse config/schedule.yml:")))
+	})
+
+	It("reads the rake and runner jobs of whenever", func() {
+		write("config/schedule.rb", `
+every 1.day, at: '4:30 am' do
+  rake "reports:send[daily]"
+  runner "Cleanup::Sessions.purge(7)"
+  runner 'puts 1'
+  command "/usr/bin/true"
+end
+`)
+		jobs, problems, err := schedules.Load(buildDir)
+		Expect(err).To(BeNil())
+		Expect(jobs).To(Equal([]schedules.Job{
+			{Name: `rake "reports:send[daily]"`, File: "config/schedule.rb", Task: "reports:send"},
+			{Name: `runner "Cleanup::Sessions.purge(7)"`, File: "config/schedule.rb", Class: "Cleanup::Sessions"},
+		}))
+		Expect(problems).To(BeEmpty())
+	})
+})
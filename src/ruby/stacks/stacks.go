@@ -0,0 +1,78 @@
+// Package stacks checks the stack an app stages on against the stacks the
+// buildpack has a ruby for. libbuildpack accepts any stack with at least one
+// dependency, so without this a stack with only node or the JDK fails much
+// later, once the cache is restored, when no ruby matches.
+package stacks
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Runtimes are the dependencies an app runs on, a stack needs at least one
+var Runtimes = []string{"ruby", "jruby"}
+
+// Supported lists the versions of each runtime by stack, in manifest order.
+// A manifest packaged for one stack supports only that stack.
+func Supported(manifest *libbuildpack.Manifest) map[string]map[string][]string {
+	supported := map[string]map[string][]string{}
+	for _, entry := range manifest.ManifestEntries {
+		if !isRuntime(entry.Dependency.Name) {
+			continue
+		}
+		stacks := entry.CFStacks
+		if manifest.Stack != "" {
+			stacks = []string{manifest.Stack}
+		}
+		for _, stack := range stacks {
+			if supported[stack] == nil {
+				supported[stack] = map[string][]string{}
+			}
+			supported[stack][entry.Dependency.Name] = append(supported[stack][entry.Dependency.Name], entry.Dependency.Version)
+		}
+	}
+	return supported
+}
+
+// Check fails unless the manifest has a runtime for stack. The error lists
+// the supported stacks with their runtime versions.
+func Check(manifest *libbuildpack.Manifest, stack string) error {
+	supported := Supported(manifest)
+	if _, found := supported[stack]; found {
+		return nil
+	}
+	if len(supported) == 0 {
+		return fmt.Errorf("this buildpack has no ruby for any stack")
+	}
+
+	var names []string
+	for name := range supported {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	message := fmt.Sprintf("this buildpack has no ruby for the stack %q, push the app with one of the supported stacks, such as cf push -s %s:", stack, names[0])
+	for _, name := range names {
+		var runtimes []string
+		for _, runtime := range Runtimes {
+			if versions := supported[name][runtime]; len(versions) > 0 {
+				runtimes = append(runtimes, runtime+" "+strings.Join(versions, ", "))
+			}
+		}
+		message += fmt.Sprintf("\n  %s: %s", name, strings.Join(runtimes, "; "))
+	}
+	return errors.New(message)
+}
+
+func isRuntime(name string) bool {
+	for _, runtime := range Runtimes {
+		if name == runtime {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package stacks_test
+
+import (
+	"ruby/stacks"
+
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stacks", func() {
+	var manifest *libbuildpack.Manifest
+
+	entry := func(name, version string, stacks ...string) libbuildpack.ManifestEntry {
+		return libbuildpack.ManifestEntry{Dependency: libbuildpack.Dependency{Name: name, Version: version}, CFStacks: stacks}
+	}
+
+	BeforeEach(func() {
+		manifest = &libbuildpack.Manifest{ManifestEntries: []libbuildpack.ManifestEntry{
+			entry("ruby", "2.4.4", "cflinuxfs2", "cflinuxfs3"),
+			entry("ruby", "2.5.1", "cflinuxfs3"),
+			entry("jruby", "9.1.17.0", "cflinuxfs3"),
+			entry("node", "8.11.3", "cflinuxfs3", "windows2016"),
+		}}
+	})
+
+	Describe("Supported", func() {
+		It("lists the runtime versions of each stack", func() {
+			Expect(stacks.Supported(manifest)).To(Equal(map[string]map[string][]string{
+				"cflinuxfs2": {"ruby": {"2.4.4"}},
+				"cflinuxfs3": {"ruby": {"2.4.4", "2.5.1"}, "jruby": {"9.1.17.0"}},
+			}))
+		})
+
+		It("only has the stack a stack specific manifest is packaged for", func() {
+			manifest.Stack = "cflinuxfs3"
+			Expect(stacks.Supported(manifest)).To(Equal(map[string]map[string][]string{
+				"cflinuxfs3": {"ruby": {"2.4.4", "2.5.1"}, "jruby": {"9.1.17.0"}},
+			}))
+		})
+	})
+
+	Describe("Check", func() {
+		It("accepts a stack with a ruby", func() {
+			Expect(stacks.Check(manifest, "cflinuxfs2")).To(Succeed())
+		})
+
+		It("lists the supported stacks for a stack with only other dependencies", func() {
+			Expect(stacks.Check(manifest, "windows2016")).To(MatchError(`this buildpack has no ruby for the stack "windows2016", push the app with one of the supported stacks, such as cf push -s cflinuxfs2:
+  cflinuxfs2: ruby 2.4.4
+  cflinuxfs3: ruby 2.4.4, 2.5.1; jruby 9.1.17.0`))
+		})
+
+		It("fails when no stack has a ruby", func() {
+			manifest.ManifestEntries = manifest.ManifestEntries[3:]
+			Expect(stacks.Check(manifest, "cflinuxfs3")).To(MatchError("this buildpack has no ruby for any stack"))
+		})
+	})
+})
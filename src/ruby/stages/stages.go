@@ -0,0 +1,88 @@
+// Package stages brackets the stages of staging with markers in the log, so
+// tooling reading the staging log, such as the platform UI, can render
+// progress without parsing the buildpack's prose:
+//
+//	=== stage:begin name=install_ruby ===
+//	=== stage:end name=install_ruby status=ok duration=12.345s ===
+//
+// The markers are lines of their own between the human output, status is
+// ok or failed. How long each stage took is recorded as a duration in the
+// staging report, which the staging metrics render, together with the CPU
+// time and disk IO of the buildpack's process tree during the stage.
+package stages
+
+import (
+	"fmt"
+	"ruby/procstat"
+	"ruby/report"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Prefix starts every marker line
+const Prefix = "=== stage:"
+
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+// Stage is a stage which began, named in snake case like the durations of
+// the staging report
+type Stage struct {
+	name   string
+	start  time.Time
+	log    *libbuildpack.Logger
+	depDir string
+	ended  bool
+	// usage is nil when /proc could not be sampled, outside of linux
+	usage *procstat.Usage
+}
+
+// Begin writes the begin marker of name. depDir is where the duration is
+// recorded when the stage ends.
+func Begin(log *libbuildpack.Logger, depDir, name string) *Stage {
+	fmt.Fprintf(log.Output(), "%sbegin name=%s ===\n", Prefix, name)
+	stage := &Stage{name: name, start: time.Now(), log: log, depDir: depDir}
+	if usage, err := procstat.Sample(); err == nil {
+		stage.usage = &usage
+	} else {
+		log.Debug("Unable to sample the resource usage of %s: %v", name, err)
+	}
+	return stage
+}
+
+// End writes the end marker, failed when err is not nil, and records the
+// duration and resource usage of the stage. Only the first End counts.
+func (s *Stage) End(err error) {
+	if s.ended {
+		return
+	}
+	s.ended = true
+
+	status := StatusOK
+	if err != nil {
+		status = StatusFailed
+	}
+	fmt.Fprintf(s.log.Output(), "%send name=%s status=%s duration=%.3fs ===\n", Prefix, s.name, status, time.Since(s.start).Seconds())
+	if err := report.RecordDuration(s.depDir, s.name, s.start); err != nil {
+		s.log.Debug("Unable to record the duration of %s: %v", s.name, err)
+	}
+	if s.usage == nil {
+		return
+	}
+	if usage, err := procstat.Sample(); err != nil {
+		s.log.Debug("Unable to sample the resource usage of %s: %v", s.name, err)
+	} else if err := report.RecordResources(s.depDir, s.name, usage.Sub(*s.usage)); err != nil {
+		s.log.Debug("Unable to record the resource usage of %s: %v", s.name, err)
+	}
+}
+
+// Run runs fn as the stage name and returns its error
+func Run(log *libbuildpack.Logger, depDir, name string, fn func() error) error {
+	stage := Begin(log, depDir, name)
+	err := fn()
+	stage.End(err)
+	return err
+}
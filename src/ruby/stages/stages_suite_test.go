@@ -0,0 +1,13 @@
+package stages_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestStages(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Stages Suite")
+}
@@ -0,0 +1,76 @@
+package stages_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"ruby/report"
+	"ruby/stages"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stages", func() {
+	var (
+		depDir string
+		buffer *bytes.Buffer
+		log    *libbuildpack.Logger
+	)
+
+	BeforeEach(func() {
+		var err error
+		depDir, err = ioutil.TempDir("", "ruby-buildpack.stages.")
+		Expect(err).To(BeNil())
+		buffer = new(bytes.Buffer)
+		log = libbuildpack.NewLogger(ansicleaner.New(buffer))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(depDir)).To(Succeed())
+	})
+
+	It("brackets the output of a stage with markers and records its duration", func() {
+		Expect(stages.Run(log, depDir, "install_ruby", func() error {
+			log.Info("Installing ruby")
+			return nil
+		})).To(Succeed())
+
+		Expect(buffer.String()).To(MatchRegexp(`^=== stage:begin name=install_ruby ===\n       Installing ruby\n=== stage:end name=install_ruby status=ok duration=\d+\.\d{3}s ===\n$`))
+		r, err := report.Load(depDir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Metrics.Durations).To(HaveKey("install_ruby"))
+	})
+
+	It("records the resources the process tree used during a stage", func() {
+		Expect(stages.Run(log, depDir, "install_gems", func() error {
+			return exec.Command("true").Run()
+		})).To(Succeed())
+
+		r, err := report.Load(depDir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Metrics.Resources).To(HaveKey("install_gems"))
+		Expect(r.Metrics.Resources["install_gems"].CPUSeconds).To(BeNumerically(">=", 0))
+	})
+
+	It("marks a stage which returned an error as failed", func() {
+		Expect(stages.Run(log, depDir, "install_gems", func() error {
+			return errors.New("bundle install failed")
+		})).To(MatchError("bundle install failed"))
+
+		Expect(buffer.String()).To(ContainSubstring("=== stage:end name=install_gems status=failed duration="))
+	})
+
+	It("only ends a stage once", func() {
+		stage := stages.Begin(log, depDir, "finalize")
+		stage.End(nil)
+		stage.End(errors.New("failed later"))
+
+		Expect(buffer.String()).To(ContainSubstring("stage:end name=finalize status=ok"))
+		Expect(buffer.String()).ToNot(ContainSubstring("status=failed"))
+	})
+})
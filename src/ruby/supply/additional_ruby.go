@@ -0,0 +1,164 @@
+package supply
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"ruby/depsdir"
+	"ruby/profiled"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/kr/text"
+)
+
+// additionalRubyDir is where the ruby BP_ADDITIONAL_RUBY asks for is
+// installed, relative to the dep dir
+const additionalRubyDir = "additional_ruby"
+
+// additionalRubyEnv are replaced rather than inherited when bundling for the
+// additional ruby, they point at the app's ruby and its gems
+var additionalRubyEnv = []string{"PATH", "GEM_HOME", "GEM_PATH", "BUNDLE_PATH", "BUNDLE_GEMFILE", "BUNDLE_APP_CONFIG"}
+
+// InstallAdditionalRuby installs the ruby BP_ADDITIONAL_RUBY asks for next
+// to the app's ruby and bundles the app's gems for it, so a legacy worker
+// and a migrated web process can run from one droplet. Bundler keeps the
+// gems of each ruby apart below the bundle path by ABI version. The processes
+// in BP_ADDITIONAL_RUBY_PROCESSES run with the additional ruby, the others
+// keep the app's. A dual boot app bundles the additional ruby with the
+// Gemfile the app did not stage with.
+func (s *Supplier) InstallAdditionalRuby(engine, appVersion string) error {
+	constraint := s.Flags.String("BP_ADDITIONAL_RUBY")
+	if constraint == "" || !s.appHasGemfile {
+		return nil
+	}
+	if engine != "ruby" {
+		return fmt.Errorf("BP_ADDITIONAL_RUBY is only supported for apps running MRI, this app runs %s", engine)
+	}
+
+	version, err := s.Resolver.FindMatchingVersion("ruby", constraint)
+	if err != nil {
+		return fmt.Errorf("BP_ADDITIONAL_RUBY %s: %v", constraint, err)
+	}
+	if version == appVersion {
+		return fmt.Errorf("BP_ADDITIONAL_RUBY %s resolves to ruby %s, which the app already runs with", constraint, version)
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return err
+	}
+	abi := fmt.Sprintf("%d.%d.0", v.Major(), v.Minor())
+
+	s.Log.BeginStep("Installing additional ruby %s", version)
+	installDir := filepath.Join(s.Stager.DepDir(), additionalRubyDir)
+	if err := s.installOnce("additional-ruby", version, additionalRubyDir, func() error {
+		return s.Installer.InstallDependency(libbuildpack.Dependency{Name: "ruby", Version: version}, installDir)
+	}); err != nil {
+		return err
+	}
+
+	gemfile, err := s.additionalRubyGemfile()
+	if err != nil {
+		return err
+	}
+	if err := s.bundleAdditionalRuby(installDir, abi, gemfile); err != nil {
+		return err
+	}
+	return s.writeAdditionalRubyProfileD(abi, gemfile)
+}
+
+// additionalRubyGemfile is the Gemfile of a dual boot app which the app's
+// ruby does not stage with, or the app's Gemfile
+func (s *Supplier) additionalRubyGemfile() (string, error) {
+	gemfile, err := filepath.Rel(s.Stager.BuildDir(), s.Versions.Gemfile())
+	if err != nil {
+		return "", err
+	}
+
+	candidates := append([]string{"Gemfile"}, nextGemfiles...)
+	for _, name := range candidates {
+		if name == gemfile {
+			continue
+		}
+		if exists, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), name+".lock")); err != nil {
+			return "", err
+		} else if exists {
+			s.Log.Info("Bundling the additional ruby with %s", name)
+			return name, nil
+		}
+	}
+	return gemfile, nil
+}
+
+func (s *Supplier) bundleAdditionalRuby(installDir, abi, gemfile string) error {
+	appConfig, err := s.Workspace.Dir("additional_ruby_bundle")
+	if err != nil {
+		return err
+	}
+
+	bundlePath := s.bundlePath().Dir("ruby", abi)
+	env := []string{
+		"PATH=" + filepath.Join(installDir, "bin") + ":" + os.Getenv("PATH"),
+		"GEM_HOME=" + filepath.Join(installDir, "gem_home"),
+		"GEM_PATH=" + strings.Join([]string{bundlePath, filepath.Join(installDir, "gem_home"), s.deps().Bundler()}, ":"),
+		"BUNDLE_GEMFILE=" + filepath.Join(s.Stager.BuildDir(), gemfile),
+		"BUNDLE_APP_CONFIG=" + appConfig,
+		"NOKOGIRI_USE_SYSTEM_LIBRARIES=true",
+	}
+	for _, variable := range os.Environ() {
+		if !containsString(additionalRubyEnv, strings.SplitN(variable, "=", 2)[0]) {
+			env = append(env, variable)
+		}
+	}
+
+	args := []string{"install", "--without", os.Getenv("BUNDLE_WITHOUT"), "--jobs=4", "--retry=4", "--path", s.bundlePath().Root}
+	if with := os.Getenv("BUNDLE_WITH"); with != "" {
+		args = append(args, "--with", with)
+	}
+	if exists, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), gemfile+".lock")); err != nil {
+		return err
+	} else if exists {
+		args = append(args, "--deployment")
+	}
+
+	s.Log.Info("Running: bundle %s", strings.Join(args, " "))
+	cmd := exec.Command("bundle", args...)
+	cmd.Dir = s.Stager.BuildDir()
+	cmd.Stdout = text.NewIndentWriter(s.Log.Output(), []byte("       "))
+	cmd.Stderr = text.NewIndentWriter(s.Log.Output(), []byte("       "))
+	cmd.Env = env
+	if err := s.Command.Run(cmd); err != nil {
+		return fmt.Errorf("bundle install for the additional ruby: %v", err)
+	}
+	return nil
+}
+
+// writeAdditionalRubyProfileD selects the additional ruby for its processes.
+// It sorts before ruby.sh, which only sets what is still unset.
+func (s *Supplier) writeAdditionalRubyProfileD(abi, gemfile string) error {
+	dir := depsdir.Runtime(s.Stager.DepsIdx())
+	bundlePath := s.bundlePath().RuntimeDir("ruby", abi)
+	env := profiled.New().
+		AddPathPrepend("PATH", profiled.Expand(dir.Join(additionalRubyDir, depsdir.Bin))).
+		AddEnv("GEM_HOME", profiled.Expand(dir.Join(additionalRubyDir, depsdir.GemHome))).
+		AddEnv("GEM_PATH", profiled.Expand(bundlePath+":"+dir.Join(additionalRubyDir, depsdir.GemHome)+":"+dir.Bundler())).
+		AddEnv("BUNDLE_PATH", profiled.Expand(bundlePath)).
+		AddEnv("BUNDLE_GEMFILE", profiled.Expand("$HOME/"+gemfile))
+
+	script := profiled.New()
+	var processes []string
+	for _, process := range strings.Split(s.Flags.String("BP_ADDITIONAL_RUBY_PROCESSES"), ",") {
+		if process = strings.TrimSpace(process); process != "" {
+			script.AddScriptBlock(env, profiled.IfProcessType(process))
+			processes = append(processes, process)
+		}
+	}
+	if len(processes) == 0 {
+		s.Log.Warning("BP_ADDITIONAL_RUBY_PROCESSES is empty, no process will run with the additional ruby")
+		return nil
+	}
+	s.Log.Info("The %s processes run with the additional ruby", strings.Join(processes, ", "))
+	return script.Write(s.Stager.DepDir(), "additional_ruby.sh")
+}
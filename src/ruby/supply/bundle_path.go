@@ -0,0 +1,68 @@
+package supply
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"ruby/bundlepath"
+	"strings"
+)
+
+// SetupBundlePath resolves where gems are installed, the vendor_bundle of
+// the dep dir unless BP_BUNDLE_PATH moves them. A path outside the droplet
+// must be writable while staging and is warned about, the droplet only runs
+// on cells which have the gems at the same path.
+func (s *Supplier) SetupBundlePath() error {
+	bundle, err := bundlepath.Resolve(s.Flags.String("BP_BUNDLE_PATH"), s.Stager.BuildDir(), s.deps(), s.Stager.DepsIdx())
+	if err != nil {
+		return err
+	}
+	s.bundle = bundle
+	s.warnAppBundlePath()
+
+	if bundle.IsDefault(s.deps()) {
+		return nil
+	}
+	if err := os.MkdirAll(bundle.Root, 0755); err != nil {
+		return fmt.Errorf("BP_BUNDLE_PATH %s can not be created: %v", bundle.Root, err)
+	}
+	probe, err := ioutil.TempFile(bundle.Root, ".ruby-buildpack.")
+	if err != nil {
+		return fmt.Errorf("BP_BUNDLE_PATH %s is not writable: %v", bundle.Root, err)
+	}
+	probe.Close()
+	if err := os.Remove(probe.Name()); err != nil {
+		return err
+	}
+
+	if bundle.InDroplet {
+		s.Log.Info("Installing gems to %s, which is not cached between stagings", bundle.Runtime)
+		return nil
+	}
+	s.Log.Warning("Installing gems to %s, outside the droplet, since BP_BUNDLE_PATH is set. The app only starts where the same gems are at that path, the droplet can not be moved to other cells, spaces or foundations without them, and the gems are not cached between stagings.", bundle.Root)
+	return nil
+}
+
+// bundlePath is where gems are installed, the vendor_bundle of the dep dir
+// until SetupBundlePath ran
+func (s *Supplier) bundlePath() bundlepath.Path {
+	if s.bundle.Root == "" {
+		return bundlepath.Default(s.deps(), s.Stager.DepsIdx())
+	}
+	return s.bundle
+}
+
+// warnAppBundlePath warns about a BUNDLE_PATH of the app's environment. It is
+// replaced while staging, but at runtime it wins over the one the buildpack
+// writes, and bundler would not find the gems. The BUNDLE_PATH of an earlier
+// supply is in the env dir.
+func (s *Supplier) warnAppBundlePath() {
+	value := os.Getenv("BUNDLE_PATH")
+	if value == "" {
+		return
+	}
+	if written, err := s.fs().ReadFile(s.deps().EnvFile("BUNDLE_PATH")); err == nil && strings.TrimSpace(string(written)) == value {
+		return
+	}
+	s.Log.Warning("BUNDLE_PATH is set to %s by the app's environment. Gems are installed to %s while staging, but at runtime BUNDLE_PATH takes precedence and bundler will not find them. Unset BUNDLE_PATH, or set BP_BUNDLE_PATH to install the gems somewhere else.", value, s.bundle.Root)
+}
@@ -1,96 +1,227 @@
 package main
 
 import (
-
-	// _ "ruby/hooks"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"ruby/cache"
+	"ruby/config"
+	"ruby/deprecations"
+	"ruby/diagnostics"
+	"ruby/featureflags"
+	"ruby/gembundle"
+	_ "ruby/hooks"
+	"ruby/installer"
+	"ruby/provenance"
+	"ruby/redact"
+	"ruby/report"
+	"ruby/resolver"
+	"ruby/sandbox"
+	"ruby/stacks"
 	"ruby/supply"
 	"ruby/versions"
+	"ruby/workspace"
 	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
 )
 
 func main() {
-	logger := libbuildpack.NewLogger(os.Stdout)
+	if len(os.Args) > 1 && os.Args[1] == "--list-flags" {
+		featureflags.New(os.Environ()).List(os.Stdout)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--list-deprecations" {
+		deprecations.List(os.Stdout)
+		return
+	}
+
+	flags := featureflags.New(os.Environ())
+	redactor, err := redact.New(os.Environ(), flags.String("BP_REDACT_PATTERNS"))
+	if err != nil {
+		libbuildpack.NewLogger(os.Stdout).Error("Unable to setup log redaction: %s", err.Error())
+		os.Exit(21)
+	}
+	if len(os.Args) > 1 {
+		if err := redactor.AddBundleCredentials(os.Args[1], os.Environ()); err != nil {
+			libbuildpack.NewLogger(os.Stdout).Error("Unable to setup log redaction: %s", err.Error())
+			os.Exit(21)
+		}
+	}
+	logfile, err := ioutil.TempFile("", "cloudfoundry.ruby-buildpack.supply")
+	if err != nil {
+		libbuildpack.NewLogger(os.Stdout).Error("Unable to create log file: %s", err.Error())
+		os.Exit(22)
+	}
+	defer logfile.Close()
+	stdout := redactor.Writer(io.MultiWriter(os.Stdout, logfile))
+	defer stdout.Close()
+	logger := libbuildpack.NewLogger(stdout)
+	// os.Exit skips the deferred calls, exit writes out a trailing partial
+	// line the redacting writer still holds first
+	exit := func(code int) {
+		stdout.Close()
+		os.Exit(code)
+	}
 
 	buildpackDir, err := libbuildpack.GetBuildpackDir()
 	if err != nil {
 		logger.Error("Unable to determine buildpack directory: %s", err.Error())
-		os.Exit(9)
+		exit(9)
 	}
 
 	manifest, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
 	if err != nil {
 		logger.Error("Unable to load buildpack manifest: %s", err.Error())
-		os.Exit(10)
+		exit(10)
+	}
+
+	if err := flags.Validate(); err != nil {
+		logger.Error("Invalid buildpack flags: %s", err.Error())
+		exit(20)
+	}
+	for _, name := range flags.Unknown() {
+		logger.Warning("%s is not a flag understood by the ruby buildpack, it will be ignored", name)
 	}
 
-	installer := libbuildpack.NewInstaller(manifest)
+	installer := installer.New(manifest, logger)
 
 	stager := libbuildpack.NewStager(os.Args[1:], logger, manifest)
 	if err := stager.CheckBuildpackValid(); err != nil {
-		os.Exit(11)
+		exit(11)
+	}
+	if err := stacks.Check(manifest, os.Getenv("CF_STACK")); err != nil {
+		logger.Error("Stack not supported by buildpack: %s", err.Error())
+		exit(11)
 	}
 
 	if err = installer.SetAppCacheDir(stager.CacheDir()); err != nil {
 		logger.Error("Unable to setup app cache dir: %s", err)
-		os.Exit(18)
+		exit(18)
 	}
 
 	if err = manifest.ApplyOverride(stager.DepsDir()); err != nil {
 		logger.Error("Unable to apply override.yml files: %s", err)
-		os.Exit(17)
+		exit(17)
 	}
 
 	err = libbuildpack.RunBeforeCompile(stager)
 	if err != nil {
 		logger.Error("Before Compile: %s", err.Error())
-		os.Exit(12)
+		exit(12)
 	}
 
 	if err := os.MkdirAll(filepath.Join(stager.DepDir(), "bin"), 0755); err != nil {
 		logger.Error("Unable to create bin directory: %s", err.Error())
-		os.Exit(13)
+		exit(13)
 	}
 
 	err = stager.SetStagingEnvironment()
 	if err != nil {
 		logger.Error("Unable to setup environment variables: %s", err.Error())
-		os.Exit(14)
+		exit(14)
+	}
+
+	appConfig, err := config.Load(stager.BuildDir())
+	if err != nil {
+		logger.Error("Unable to load app config: %s", err.Error())
+		exit(23)
+	}
+	if err := appConfig.SelectProfile(flags.String("BP_PROFILE")); err != nil {
+		logger.Error("Unable to select install profile: %s", err.Error())
+		exit(23)
 	}
 
 	cacher, err := cache.New(stager, logger, libbuildpack.NewYAML())
 	if err != nil {
 		logger.Error("Unable to create cacher: %s", err.Error())
-		os.Exit(14)
+		exit(14)
+	}
+	if err := cacher.SetCompression(flags.String("BP_CACHE_COMPRESSION")); err != nil {
+		logger.Error("Invalid BP_CACHE_COMPRESSION: %s", err.Error())
+		exit(14)
+	}
+
+	versionResolver, err := resolver.Load(resolver.New(manifest), filepath.Join(buildpackDir, resolver.PolicyFile))
+	if err != nil {
+		logger.Error("Unable to load the version policy: %s", err.Error())
+		exit(24)
+	}
+	if policy, ok := versionResolver.(*resolver.Policy); ok {
+		logger.Info("Applying the operator's version policy for %s", policy.Describe())
+	}
+
+	gemBundle, err := gembundle.Load(filepath.Join(buildpackDir, gembundle.Dir))
+	if err != nil {
+		logger.Error("Unable to load the gem bundle: %s", err.Error())
+		exit(26)
+	}
+
+	ws, err := workspace.New("ruby-buildpack.supply.")
+	if err != nil {
+		logger.Error("Unable to create the staging workspace: %s", err.Error())
+		exit(25)
 	}
+	installer.Workspace = ws
 
 	s := supply.Supplier{
-		Stager:    stager,
-		Manifest:  manifest,
-		Installer: installer,
-		Log:       logger,
-		Versions:  versions.New(stager.BuildDir(), manifest),
-		Cache:     cacher,
-		Command:   &libbuildpack.Command{},
-		TempDir:   &supply.LinuxTempDir{Log: logger},
+		Stager:       stager,
+		Manifest:     manifest,
+		Resolver:     versionResolver,
+		Installer:    installer,
+		Log:          logger,
+		Versions:     versions.New(stager.BuildDir(), versionResolver),
+		Cache:        cacher,
+		Command:      sandbox.New(flags.Bool("BP_EXEC_SANDBOX"), flags.String("BP_EXEC_ENV_ALLOW")),
+		TempDir:      &supply.LinuxTempDir{Log: logger, Workspace: ws},
+		Flags:        flags,
+		Config:       appConfig,
+		Workspace:    ws,
+		Deprecations: deprecations.New(logger, stager.DepDir()),
+		GemBundle:    gemBundle,
 	}
 
 	err = supply.Run(&s)
+	if err != nil && flags.Bool("BP_DIAGNOSTICS") {
+		collector := diagnostics.Collector{
+			DepDir:       stager.DepDir(),
+			BuildpackDir: buildpackDir,
+			LogFiles:     []string{logfile.Name()},
+			Environ:      os.Environ(),
+			Redactor:     redactor,
+			Log:          logger,
+			UploadURL:    flags.String("BP_DIAGNOSTICS_URL"),
+			Workspace:    ws,
+		}
+		collector.Report()
+	}
+	if err := ws.Cleanup(); err != nil {
+		logger.Warning("Unable to remove the staging workspace: %s", err.Error())
+	}
 	if err != nil {
-		os.Exit(15)
+		exit(15)
 	}
 
-	if err := stager.WriteConfigYml(nil); err != nil {
+	if err := stager.WriteConfigYml(s.ConfigYml()); err != nil {
 		logger.Error("Error writing config.yml: %s", err.Error())
-		os.Exit(16)
+		exit(16)
+	}
+
+	if err := report.Update(stager.DepDir(), func(r *report.Report) {
+		r.Dependencies = installer.Installed()
+		for _, dep := range r.Dependencies {
+			if dep.Origin == provenance.FromDownload {
+				r.AddEgress("download of "+dep.Name, dep.URI)
+			}
+		}
+	}); err != nil {
+		logger.Error("Unable to record the provenance of the dependencies: %s", err.Error())
+		exit(27)
 	}
 
 	if err = installer.CleanupAppCache(); err != nil {
 		logger.Error("Unable to clean up app cache: %s", err)
-		os.Exit(19)
+		exit(19)
 	}
 }
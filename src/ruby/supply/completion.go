@@ -0,0 +1,71 @@
+package supply
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// completionDir holds a marker for each dependency supply finished
+// installing, platforms retry staging steps against the same deps dir
+const completionDir = ".supply_complete"
+
+// installOnce runs install unless an earlier run of supply against this
+// deps dir completed installing the same version of name, or dir was
+// restored from the cache stamped with that version. Anything left in dir
+// by a run that did not complete is removed before installing again.
+func (s *Supplier) installOnce(name, version, dir string, install func() error) error {
+	start := time.Now()
+	marker := filepath.Join(s.Stager.DepDir(), completionDir, name)
+	if data, err := ioutil.ReadFile(marker); err == nil && strings.TrimSpace(string(data)) == version {
+		s.Log.BeginStep("Reusing %s %s installed by an earlier run of supply", name, version)
+		s.recordComponent(name, start, true)
+		s.Cache.Stamp(dir, name, version)
+		return nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if version != "" && s.Cache.Stamped(dir, name) == version {
+		// Only the links into the dep dir's bin are not cached
+		s.Log.BeginStep("Reusing %s %s from cache", name, version)
+		if err := s.Stager.LinkDirectoryInDepDir(filepath.Join(s.Stager.DepDir(), dir, "bin"), "bin"); err != nil {
+			return err
+		}
+		if err := s.Installer.Reused(libbuildpack.Dependency{Name: name, Version: version}); err != nil {
+			return err
+		}
+		s.recordComponent(name, start, true)
+	} else {
+		if err := os.RemoveAll(filepath.Join(s.Stager.DepDir(), dir)); err != nil {
+			return err
+		}
+		if err := install(); err != nil {
+			return err
+		}
+		s.recordComponent(name, start, false)
+	}
+	s.Cache.Stamp(dir, name, version)
+
+	if err := os.MkdirAll(filepath.Dir(marker), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(marker, []byte(version+"\n"), 0644)
+}
+
+// onlyVersion returns the only version of name in the manifest, or an empty
+// string when there is not exactly one
+func (s *Supplier) onlyVersion(name string) string {
+	versions := s.Manifest.AllDependencyVersions(name)
+	if len(versions) != 1 {
+		return ""
+	}
+	return versions[0]
+}
@@ -0,0 +1,95 @@
+package supply
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"ruby/depsdir"
+	"ruby/gemsource"
+	"ruby/report"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// developerRubySuffix marks the version of a ruby from BP_RUBY_URL. It never
+// matches a ruby of the manifest, so a ruby of the manifest cached or
+// installed by an earlier run is not mistaken for it, or the other way round.
+const developerRubySuffix = "-dev"
+
+// isDeveloperRuby is true for the version InstallDeveloperRuby returns
+func isDeveloperRuby(rubyVersion string) bool {
+	return strings.HasSuffix(rubyVersion, developerRubySuffix)
+}
+
+// developerRuby returns BP_RUBY_URL and BP_RUBY_SHA256, the url is empty
+// unless the developer mode is on
+func (s *Supplier) developerRuby() (string, string, error) {
+	url, checksum := s.Flags.String("BP_RUBY_URL"), strings.ToLower(s.Flags.String("BP_RUBY_SHA256"))
+	if url == "" && checksum == "" {
+		return "", "", nil
+	}
+	if url == "" {
+		return "", "", fmt.Errorf("BP_RUBY_SHA256 is set without BP_RUBY_URL")
+	}
+	if !sha256Hex.MatchString(checksum) {
+		return "", "", fmt.Errorf("BP_RUBY_URL needs BP_RUBY_SHA256 set to the sha256 of the archive, 64 hex digits")
+	}
+	return url, checksum, nil
+}
+
+// InstallDeveloperRuby installs the ruby archive at BP_RUBY_URL, checked
+// against BP_RUBY_SHA256, in place of a ruby of the manifest. It lets
+// buildpack developers run the fixtures against a build which is not in the
+// manifest yet, such as a nightly of ruby-head; apps staged with it are
+// unsupported. The archive must be laid out like the rubies of the manifest.
+// It returns the RUBY_VERSION of the ruby with developerRubySuffix.
+func (s *Supplier) InstallDeveloperRuby() (string, error) {
+	url, checksum, err := s.developerRuby()
+	if err != nil {
+		return "", err
+	}
+	s.Log.Warning("UNSUPPORTED: installing ruby from BP_RUBY_URL %s, bypassing the manifest. This is for testing builds of ruby before they are added to the buildpack, not for running apps.", gemsource.Display(url))
+
+	// A ruby of the manifest may have been restored from the cache, or
+	// installed by an earlier run of supply
+	installDir := s.deps().Ruby()
+	for _, path := range []string{installDir, filepath.Join(s.Stager.DepDir(), completionDir, "ruby")} {
+		if err := os.RemoveAll(path); err != nil {
+			return "", err
+		}
+	}
+	if err := s.Installer.InstallSideload(libbuildpack.Dependency{Name: "ruby"}, url, checksum, installDir); err != nil {
+		return "", err
+	}
+	ruby := filepath.Join(installDir, "bin", "ruby")
+	if exists, err := libbuildpack.FileExists(ruby); err != nil {
+		return "", err
+	} else if !exists {
+		return "", fmt.Errorf("the archive at BP_RUBY_URL has no bin/ruby, it must be laid out like the rubies of the manifest")
+	}
+	if err := s.linkRuby(); err != nil {
+		return "", err
+	}
+
+	output, err := s.Command.Output(s.Stager.BuildDir(), ruby, "-e", "print RUBY_VERSION, ' ', RUBY_DESCRIPTION")
+	if err != nil {
+		return "", fmt.Errorf("could not run the ruby from BP_RUBY_URL: %v", err)
+	}
+	parts := strings.SplitN(strings.TrimSpace(output), " ", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("could not read the version of the ruby from BP_RUBY_URL: %q", output)
+	}
+	version := parts[0] + developerRubySuffix
+	s.Log.Info("Installed %s, staging as ruby %s", parts[1], version)
+
+	s.Cache.Stamp(depsdir.Ruby, "ruby", "developer "+checksum)
+	return version, report.Update(s.Stager.DepDir(), func(r *report.Report) {
+		r.Engine = "ruby"
+		r.Ruby = version
+		r.DeveloperRuby = gemsource.Display(url)
+	})
+}
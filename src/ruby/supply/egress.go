@@ -0,0 +1,46 @@
+package supply
+
+import (
+	"ruby/lockfile"
+	"ruby/report"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// recordEgress adds the hosts of urls to the outbound hosts of the staging
+// report, which finalize lists at the end of staging
+func (s *Supplier) recordEgress(purpose string, urls ...string) {
+	if err := report.RecordEgress(s.Stager.DepDir(), purpose, urls...); err != nil {
+		s.Log.Debug("Unable to record the outbound hosts of the %s: %v", purpose, err)
+	}
+}
+
+// RecordGemEgress records the gem sources and git gem remotes of the
+// Gemfile.lock bundler installed, as outbound hosts of the staging
+func (s *Supplier) RecordGemEgress() {
+	gemfileLock := s.Versions.Gemfile() + ".lock"
+	if exists, err := libbuildpack.FileExists(gemfileLock); err != nil || !exists {
+		return
+	}
+	lock, err := lockfile.ParseInstallable(gemfileLock)
+	if err != nil {
+		s.Log.Debug("Unable to read the gem sources of %s: %v", gemfileLock, err)
+		return
+	}
+
+	var gemSources, gitRemotes []string
+	for _, source := range lock.Sources {
+		switch source.Type {
+		case "GEM":
+			gemSources = append(gemSources, source.Remote)
+		case "GIT":
+			gitRemotes = append(gitRemotes, source.Remote)
+		}
+	}
+	if err := report.Update(s.Stager.DepDir(), func(r *report.Report) {
+		r.AddEgress("gem source", gemSources...)
+		r.AddEgress("git gem", gitRemotes...)
+	}); err != nil {
+		s.Log.Debug("Unable to record the gem sources: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package supply_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"ruby/config"
+	"ruby/featureflags"
+	"ruby/filesystem"
+	"ruby/supply"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// The app and deps dir only exist in memory here, nothing below these paths
+// is touched on disk
+var _ = Describe("Supplying against an in-memory filesystem", func() {
+	const (
+		buildDir = "/in-memory/app"
+		depDir   = "/in-memory/deps/9"
+	)
+	var (
+		fs         *filesystem.Mem
+		supplier   *supply.Supplier
+		buffer     *bytes.Buffer
+		mockCtrl   *gomock.Controller
+		mockStager *MockStager
+	)
+
+	write := func(path, contents string, mode os.FileMode) {
+		Expect(fs.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(fs.WriteFile(path, []byte(contents), mode)).To(Succeed())
+	}
+	read := func(path string) string {
+		data, err := fs.ReadFile(path)
+		Expect(err).To(BeNil())
+		return string(data)
+	}
+
+	BeforeEach(func() {
+		fs = filesystem.NewMem()
+		buffer = new(bytes.Buffer)
+		mockCtrl = gomock.NewController(GinkgoT())
+
+		mockStager = NewMockStager(mockCtrl)
+		mockStager.EXPECT().BuildDir().AnyTimes().Return(buildDir)
+		mockStager.EXPECT().DepDir().AnyTimes().Return(depDir)
+		mockStager.EXPECT().DepsIdx().AnyTimes().Return("9")
+		mockVersions := NewMockVersions(mockCtrl)
+		mockVersions.EXPECT().Gemfile().AnyTimes().Return(filepath.Join(buildDir, "Gemfile"))
+
+		supplier = &supply.Supplier{
+			Stager:   mockStager,
+			Manifest: NewMockManifest(mockCtrl),
+			Log:      libbuildpack.NewLogger(ansicleaner.New(buffer)),
+			Versions: mockVersions,
+			Command:  NewMockCommand(mockCtrl),
+			Flags:    featureflags.New([]string{"BP_GEMFILE_NEXT=true"}),
+			Config:   &config.Config{},
+			FS:       fs,
+		}
+
+		write(filepath.Join(buildDir, "Gemfile"), "source 'https://rubygems.org'\n", 0644)
+		write(filepath.Join(buildDir, "Gemfile.lock"), "GEM\n  specs:\n    rack (2.0.3)\n", 0644)
+		write(filepath.Join(depDir, "bin", "rake"), "#!/tmp/deps/9/ruby/bin/ruby\nload Gem.bin_path('rake', 'rake')\n", 0755)
+		write(filepath.Join(depDir, "vendor_bundle", "ruby", "2.5.0", "bin", "puma"), "#!/usr/local/bin/ruby2.5\n", 0755)
+		Expect(fs.MkdirAll(filepath.Join(depDir, "bin", "subdir"), 0755)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.Unsetenv("BUNDLE_GEMFILE")).To(Succeed())
+		mockCtrl.Finish()
+	})
+
+	It("finds the Gemfile and rewrites the shebangs of binstubs", func() {
+		Expect(supplier.Setup()).To(Succeed())
+		Expect(supplier.RewriteShebangs()).To(Succeed())
+
+		Expect(read(filepath.Join(depDir, "bin", "rake"))).To(Equal("#!/usr/bin/env ruby\nload Gem.bin_path('rake', 'rake')\n"))
+		Expect(read(filepath.Join(depDir, "vendor_bundle", "ruby", "2.5.0", "bin", "puma"))).To(Equal("#!/usr/bin/env ruby\n"))
+		Expect(buildDir).ToNot(BeAnExistingFile())
+	})
+
+	It("stages with the next Gemfile", func() {
+		write(filepath.Join(buildDir, "Gemfile.next.lock"), "GEM\n  specs:\n    rack (2.2.3)\n", 0644)
+		mockStager.EXPECT().WriteEnvFile("BUNDLE_GEMFILE", "Gemfile.next")
+
+		Expect(supplier.SelectGemfile()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Staging with Gemfile.next"))
+	})
+})
@@ -11,6 +11,7 @@ import (
 	exec "os/exec"
 	reflect "reflect"
 	cache "ruby/cache"
+	toolchain "ruby/toolchain"
 )
 
 // MockCommand is a mock of Command interface
@@ -131,6 +132,18 @@ func (mr *MockManifestMockRecorder) DefaultVersion(arg0 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DefaultVersion", reflect.TypeOf((*MockManifest)(nil).DefaultVersion), arg0)
 }
 
+// RootDir mocks base method
+func (m *MockManifest) RootDir() string {
+	ret := m.ctrl.Call(m, "RootDir")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// RootDir indicates an expected call of RootDir
+func (mr *MockManifestMockRecorder) RootDir() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RootDir", reflect.TypeOf((*MockManifest)(nil).RootDir))
+}
+
 // MockInstaller is a mock of Installer interface
 type MockInstaller struct {
 	ctrl     *gomock.Controller
@@ -178,6 +191,30 @@ func (mr *MockInstallerMockRecorder) InstallOnlyVersion(arg0, arg1 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InstallOnlyVersion", reflect.TypeOf((*MockInstaller)(nil).InstallOnlyVersion), arg0, arg1)
 }
 
+// InstallSideload mocks base method
+func (m *MockInstaller) InstallSideload(arg0 libbuildpack.Dependency, arg1, arg2, arg3 string) error {
+	ret := m.ctrl.Call(m, "InstallSideload", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// InstallSideload indicates an expected call of InstallSideload
+func (mr *MockInstallerMockRecorder) InstallSideload(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InstallSideload", reflect.TypeOf((*MockInstaller)(nil).InstallSideload), arg0, arg1, arg2, arg3)
+}
+
+// Reused mocks base method
+func (m *MockInstaller) Reused(arg0 libbuildpack.Dependency) error {
+	ret := m.ctrl.Call(m, "Reused", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Reused indicates an expected call of Reused
+func (mr *MockInstallerMockRecorder) Reused(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reused", reflect.TypeOf((*MockInstaller)(nil).Reused), arg0)
+}
+
 // MockVersions is a mock of Versions interface
 type MockVersions struct {
 	ctrl     *gomock.Controller
@@ -253,6 +290,19 @@ func (mr *MockVersionsMockRecorder) RubyEngineVersion() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RubyEngineVersion", reflect.TypeOf((*MockVersions)(nil).RubyEngineVersion))
 }
 
+// RubyPlatform mocks base method
+func (m *MockVersions) RubyPlatform() (string, error) {
+	ret := m.ctrl.Call(m, "RubyPlatform")
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RubyPlatform indicates an expected call of RubyPlatform
+func (mr *MockVersionsMockRecorder) RubyPlatform() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RubyPlatform", reflect.TypeOf((*MockVersions)(nil).RubyPlatform))
+}
+
 // HasGemVersion mocks base method
 func (m *MockVersions) HasGemVersion(gem string, constraints ...string) (bool, error) {
 	varargs := []interface{}{gem}
@@ -302,6 +352,19 @@ func (mr *MockVersionsMockRecorder) HasWindowsGemfileLock() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasWindowsGemfileLock", reflect.TypeOf((*MockVersions)(nil).HasWindowsGemfileLock))
 }
 
+// GemGroups mocks base method
+func (m *MockVersions) GemGroups() (map[string][]string, error) {
+	ret := m.ctrl.Call(m, "GemGroups")
+	ret0, _ := ret[0].(map[string][]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GemGroups indicates an expected call of GemGroups
+func (mr *MockVersionsMockRecorder) GemGroups() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GemGroups", reflect.TypeOf((*MockVersions)(nil).GemGroups))
+}
+
 // Gemfile mocks base method
 func (m *MockVersions) Gemfile() string {
 	ret := m.ctrl.Call(m, "Gemfile")
@@ -349,6 +412,18 @@ func (mr *MockStagerMockRecorder) BuildDir() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BuildDir", reflect.TypeOf((*MockStager)(nil).BuildDir))
 }
 
+// CacheDir mocks base method
+func (m *MockStager) CacheDir() string {
+	ret := m.ctrl.Call(m, "CacheDir")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// CacheDir indicates an expected call of CacheDir
+func (mr *MockStagerMockRecorder) CacheDir() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CacheDir", reflect.TypeOf((*MockStager)(nil).CacheDir))
+}
+
 // DepDir mocks base method
 func (m *MockStager) DepDir() string {
 	ret := m.ctrl.Call(m, "DepDir")
@@ -493,15 +568,15 @@ func (mr *MockCacheMockRecorder) Metadata() *gomock.Call {
 }
 
 // Restore mocks base method
-func (m *MockCache) Restore() error {
-	ret := m.ctrl.Call(m, "Restore")
+func (m *MockCache) Restore(bundlerVersion string, tools toolchain.Versions) error {
+	ret := m.ctrl.Call(m, "Restore", bundlerVersion, tools)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Restore indicates an expected call of Restore
-func (mr *MockCacheMockRecorder) Restore() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restore", reflect.TypeOf((*MockCache)(nil).Restore))
+func (mr *MockCacheMockRecorder) Restore(bundlerVersion, tools interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restore", reflect.TypeOf((*MockCache)(nil).Restore), bundlerVersion, tools)
 }
 
 // Save mocks base method
@@ -515,3 +590,25 @@ func (m *MockCache) Save() error {
 func (mr *MockCacheMockRecorder) Save() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockCache)(nil).Save))
 }
+
+// Stamp mocks base method
+func (m *MockCache) Stamp(name, input, value string) {
+	m.ctrl.Call(m, "Stamp", name, input, value)
+}
+
+// Stamp indicates an expected call of Stamp
+func (mr *MockCacheMockRecorder) Stamp(name, input, value interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stamp", reflect.TypeOf((*MockCache)(nil).Stamp), name, input, value)
+}
+
+// Stamped mocks base method
+func (m *MockCache) Stamped(name, input string) string {
+	ret := m.ctrl.Call(m, "Stamped", name, input)
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// Stamped indicates an expected call of Stamped
+func (mr *MockCacheMockRecorder) Stamped(name, input interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stamped", reflect.TypeOf((*MockCache)(nil).Stamped), name, input)
+}
@@ -0,0 +1,78 @@
+package supply
+
+import (
+	"fmt"
+	"os/exec"
+	"ruby/lockfile"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/kr/text"
+)
+
+// checkPlatformGems looks for gems the Gemfile.lock in tempDir only has
+// builds of for other platforms, as when the lockfile was generated on Apple
+// Silicon and locks nokogiri for arm64-darwin. bundle install can not
+// install them on the staging platform. With BP_FORCE_RUBY_PLATFORM the ruby
+// platform is added to the lockfile and the gems are compiled from source,
+// otherwise staging fails with the command which fixes the lockfile. It
+// returns env for bundle install and needs ExportRubyABI to have run.
+func (s *Supplier) checkPlatformGems(tempDir, gemfileLock string, env []string) ([]string, error) {
+	arch := s.rubyABI["ruby_platform"]
+	if arch == "" {
+		return env, nil
+	}
+	if exists, err := libbuildpack.FileExists(gemfileLock); err != nil || !exists {
+		return env, err
+	}
+	lock, err := lockfile.ParseInstallable(gemfileLock)
+	if err != nil {
+		return env, err
+	}
+
+	platforms := []string{arch}
+	if s.rubyABI["ruby_engine"] == "jruby" {
+		platforms = append(platforms, "java")
+	}
+	fix := fmt.Sprintf("bundle lock --add-platform %s", arch)
+
+	foreign := lock.ForeignSpecs(platforms...)
+	if len(foreign) == 0 {
+		if !lock.SupportsPlatform(platforms...) {
+			s.Log.Warning("The PLATFORMS of Gemfile.lock, %s, do not include the staging platform %s. Bundler may refuse to install the bundle, run `%s` and push the updated Gemfile.lock.", strings.Join(lock.Platforms, ", "), arch, fix)
+		}
+		return env, nil
+	}
+
+	var gems []string
+	lockedFor := map[string][]string{}
+	for _, spec := range foreign {
+		gem := spec.Name + " " + spec.Version
+		if lockedFor[gem] == nil {
+			gems = append(gems, gem)
+		}
+		lockedFor[gem] = append(lockedFor[gem], spec.Platform)
+	}
+	var lines []string
+	for _, gem := range gems {
+		lines = append(lines, fmt.Sprintf("  - %s is locked for %s", gem, strings.Join(lockedFor[gem], ", ")))
+	}
+
+	if !s.Flags.Bool("BP_FORCE_RUBY_PLATFORM") {
+		s.Log.Error("Gemfile.lock has no build for the staging platform %s of:\n%s\nRun `%s` where the Gemfile.lock was generated and push the updated Gemfile.lock, or set BP_FORCE_RUBY_PLATFORM=true to compile these gems from source while staging.", arch, strings.Join(lines, "\n"), fix)
+		return env, fmt.Errorf("Gemfile.lock has no %s build of %d gems", arch, len(gems))
+	}
+
+	s.Log.Warning("Gemfile.lock has no build for the staging platform %s of:\n%s\nCompiling them from source since BP_FORCE_RUBY_PLATFORM is set. Run `%s` to install the platform's builds instead.", arch, strings.Join(lines, "\n"), fix)
+	env = append(env, "BUNDLE_FORCE_RUBY_PLATFORM=true")
+	s.Log.Info("Running: bundle lock --add-platform ruby")
+	cmd := exec.Command("bundle", "lock", "--add-platform", "ruby")
+	cmd.Dir = tempDir
+	cmd.Stdout = text.NewIndentWriter(s.Log.Output(), []byte("       "))
+	cmd.Stderr = text.NewIndentWriter(s.Log.Output(), []byte("       "))
+	cmd.Env = env
+	if err := s.Command.Run(cmd); err != nil {
+		return env, fmt.Errorf("adding the ruby platform to Gemfile.lock: %v", err)
+	}
+	return env, nil
+}
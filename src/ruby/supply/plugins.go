@@ -0,0 +1,260 @@
+package supply
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"ruby/depsdir"
+	"ruby/gemsource"
+	"ruby/profiled"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/kr/text"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	gemfilePlugin = regexp.MustCompile(`(?m)^[ \t]*plugin[ \t(]+["']([^"']+)["']([^#\n]*)`)
+	pluginArg     = regexp.MustCompile(`(?:(\w+):\s*|:(\w+)\s*=>\s*)?["']([^"']+)["']`)
+	gemfileSource = regexp.MustCompile(`(?m)^[ \t]*source[ \t(]+["']([^"']+)["'][ \t)]*$`)
+)
+
+// pluginOptions are the options of the plugin directive bundle plugin
+// install takes, the others, such as require, do not change what is installed
+var pluginOptions = []string{"source", "git", "branch", "ref", "path"}
+
+// bundlerPlugin is a plugin directive of the Gemfile
+type bundlerPlugin struct {
+	Name    string
+	Version string
+	Options map[string]string
+}
+
+// gemfilePlugins returns the plugins the Gemfile declares. A plugin without
+// a source comes from the global source of the Gemfile, as when bundle
+// install installs it.
+func gemfilePlugins(gemfile string) ([]bundlerPlugin, []string, error) {
+	data, err := ioutil.ReadFile(gemfile)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var globalSource string
+	if m := gemfileSource.FindSubmatch(data); m != nil {
+		globalSource = string(m[1])
+	}
+
+	var plugins []bundlerPlugin
+	var ignored []string
+	for _, m := range gemfilePlugin.FindAllSubmatch(data, -1) {
+		plugin := bundlerPlugin{Name: string(m[1]), Options: map[string]string{}}
+		for _, arg := range pluginArg.FindAllSubmatch(m[2], -1) {
+			key, value := string(arg[1])+string(arg[2]), string(arg[3])
+			switch {
+			case key == "" && plugin.Version == "":
+				plugin.Version = value
+			case key == "":
+				ignored = append(ignored, fmt.Sprintf("%s %s", plugin.Name, value))
+			case key == "github":
+				plugin.Options["git"] = "https://github.com/" + value + ".git"
+			case containsString(pluginOptions, key):
+				plugin.Options[key] = value
+			}
+		}
+		if plugin.Options["git"] == "" && plugin.Options["path"] == "" && plugin.Options["source"] == "" && globalSource != "" {
+			plugin.Options["source"] = globalSource
+		}
+		plugins = append(plugins, plugin)
+	}
+	return plugins, ignored, nil
+}
+
+// args are the arguments of bundle plugin install for p, with display
+// the same without the user info of its sources for the log and the cache
+func (p bundlerPlugin) args(display bool) []string {
+	args := []string{"plugin", "install", p.Name}
+	if p.Version != "" {
+		args = append(args, "--version", p.Version)
+	}
+	for _, option := range pluginOptions {
+		value := p.Options[option]
+		if value == "" {
+			continue
+		}
+		if display {
+			value = gemsource.Display(value)
+		}
+		args = append(args, "--"+option, value)
+	}
+	return args
+}
+
+// remote is the source p is fetched from, an empty string when it is
+// vendored in the app
+func (p bundlerPlugin) remote() string {
+	if p.Options["path"] != "" {
+		return ""
+	}
+	if git := p.Options["git"]; git != "" {
+		return git
+	}
+	return p.Options["source"]
+}
+
+// InstallBundlerPlugins installs the plugins of the Gemfile in appDir, the
+// copy of the app bundle install runs in, before bundle install so the
+// source and hook plugins are there when bundler loads the Gemfile. They go
+// to the bundler_plugin of the dep dir, which is cached, and a plugin whose
+// directive did not change is reused from the cache rather than fetched.
+// With BP_BUNDLER_PLUGINS_OFFLINE staging fails, rather than fetching, when
+// a plugin is neither cached nor vendored with path. The downloads and git
+// clones, which keep the credentials of the sources, are removed, and the
+// profile.d script points the app's .bundle/plugin at the dep dir.
+func (s *Supplier) InstallBundlerPlugins(appDir string, env []string) error {
+	root := s.deps().Join(depsdir.BundlerPlugin)
+	plugins, ignored, err := gemfilePlugins(s.Versions.Gemfile())
+	if err != nil {
+		return err
+	}
+	if len(plugins) == 0 {
+		return os.RemoveAll(root)
+	}
+
+	s.Log.BeginStep("Installing bundler plugins")
+	for _, requirement := range ignored {
+		s.Log.Warning("bundle plugin install takes one version requirement, ignoring %s", requirement)
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	link := filepath.Join(appDir, ".bundle", "plugin")
+	if err := s.linkPluginRoot(link, root); err != nil {
+		return err
+	}
+
+	installed, err := installedPlugins(root)
+	if err != nil {
+		return err
+	}
+	var missing, remotes []string
+	for _, plugin := range plugins {
+		spec := strings.Join(plugin.args(true), " ")
+		if installed[plugin.Name] && s.Cache.Stamped(depsdir.BundlerPlugin, plugin.Name) == spec {
+			s.Log.Info("Using %s from cache", plugin.Name)
+			s.Cache.Stamp(depsdir.BundlerPlugin, plugin.Name, spec)
+			continue
+		}
+		if plugin.remote() != "" && s.Flags.Bool("BP_BUNDLER_PLUGINS_OFFLINE") {
+			missing = append(missing, plugin.Name)
+			continue
+		}
+
+		s.Log.Info("Running: bundle %s", spec)
+		output := &bytes.Buffer{}
+		cmd := exec.Command("bundle", plugin.args(false)...)
+		cmd.Dir = appDir
+		cmd.Stdout = io.MultiWriter(text.NewIndentWriter(s.Log.Output(), []byte("       ")), output)
+		cmd.Stderr = io.MultiWriter(text.NewIndentWriter(s.Log.Output(), []byte("       ")), output)
+		cmd.Env = env
+		if err := s.Command.Run(cmd); err != nil {
+			if remote := plugin.remote(); remote != "" {
+				s.Log.Error("Unable to fetch the bundler plugin %s from %s. Check the source is reachable from staging, or vendor the plugin in the app and declare it with path.", plugin.Name, gemsource.Display(remote))
+			}
+			return fmt.Errorf("installing the bundler plugin %s: %v", plugin.Name, err)
+		}
+		s.Cache.Stamp(depsdir.BundlerPlugin, plugin.Name, spec)
+		if remote := plugin.remote(); remote != "" {
+			remotes = append(remotes, remote)
+		}
+	}
+	if len(missing) > 0 {
+		s.Log.Error("BP_BUNDLER_PLUGINS_OFFLINE is set and the bundler plugins %s are not cached. Vendor them in the app and declare them with path, or stage once with network access.", strings.Join(missing, ", "))
+		return fmt.Errorf("%d bundler plugins are not available offline", len(missing))
+	}
+	s.recordEgress("bundler plugin", remotes...)
+
+	if err := os.RemoveAll(filepath.Join(root, "cache")); err != nil {
+		return err
+	}
+	if err := s.rewritePluginIndex(link, root); err != nil {
+		return err
+	}
+	if err := s.linkPluginRoot(filepath.Join(s.Stager.BuildDir(), ".bundle", "plugin"), root); err != nil {
+		return err
+	}
+
+	runtime := depsdir.Runtime(s.Stager.DepsIdx()).Join(depsdir.BundlerPlugin)
+	return profiled.New().
+		AddShell(`mkdir -p "$HOME/.bundle"`).
+		AddShell(fmt.Sprintf(`ln -sfn "%s" "$HOME/.bundle/plugin"`, runtime)).
+		AddShell(fmt.Sprintf(`[ -f "%[1]s/index" ] && sed -i "s|%[2]s|%[1]s|g" "%[1]s/index"`, runtime, root)).
+		Write(s.Stager.DepDir(), "bundler_plugin.sh")
+}
+
+// linkPluginRoot replaces the plugin root of the app at link with a link to
+// the dep dir. One the app pushed was installed on another machine, with
+// its paths.
+func (s *Supplier) linkPluginRoot(link, root string) error {
+	if info, err := os.Lstat(link); err == nil {
+		if info.Mode()&os.ModeSymlink == 0 {
+			s.Log.Warning("Ignoring the .bundle/plugin of the app, the bundler plugins are installed while staging")
+		}
+		if err := os.RemoveAll(link); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		return err
+	}
+	return os.Symlink(root, link)
+}
+
+// rewritePluginIndex replaces the paths bundler wrote to the plugin index
+// through link with the dep dir, link only exists while bundle install runs
+func (s *Supplier) rewritePluginIndex(link, root string) error {
+	index := filepath.Join(root, "index")
+	data, err := ioutil.ReadFile(index)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(index, bytes.Replace(data, []byte(link), []byte(root), -1), 0644)
+}
+
+// installedPlugins are the plugins in the index of the plugin root whose
+// directory is there
+func installedPlugins(root string) (map[string]bool, error) {
+	installed := map[string]bool{}
+	data, err := ioutil.ReadFile(filepath.Join(root, "index"))
+	if os.IsNotExist(err) {
+		return installed, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var index struct {
+		PluginPaths map[string]string `yaml:"plugin_paths"`
+	}
+	if err := yaml.Unmarshal(data, &index); err != nil {
+		return installed, nil
+	}
+	for name, path := range index.PluginPaths {
+		if exists, err := libbuildpack.FileExists(path); err != nil {
+			return nil, err
+		} else if exists {
+			installed[name] = true
+		}
+	}
+	return installed, nil
+}
@@ -0,0 +1,90 @@
+package supply
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"ruby/config"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// sideloadDir holds the sideloaded archives, relative to the dep dir
+const sideloadDir = "sideloads"
+
+// sideloadEnv are the directories linked into the dep dir for each
+// sideload, and the variables which list them while staging
+var sideloadEnv = []struct{ dir, env string }{
+	{"bin", "PATH"},
+	{"lib", "LD_LIBRARY_PATH"},
+	{"lib", "LIBRARY_PATH"},
+	{"include", "CPATH"},
+}
+
+// InstallSideloads installs the binaries listed under sideloads in the app's
+// config file, before bundle install so native gems can link against them.
+// Their bin and lib directories are linked into the dep dir, which puts
+// them on PATH and LD_LIBRARY_PATH at runtime.
+func (s *Supplier) InstallSideloads() error {
+	if len(s.Config.Sideloads) == 0 {
+		return nil
+	}
+
+	for _, sideload := range s.Config.Sideloads {
+		sideload := sideload
+		dir := filepath.Join(sideloadDir, sideload.Name)
+		if err := s.installOnce("sideload-"+sideload.Name, sideload.SHA256, dir, func() error {
+			installDir := filepath.Join(s.Stager.DepDir(), dir)
+			dep := libbuildpack.Dependency{Name: sideload.Name, Version: sideload.Version}
+			if err := s.Installer.InstallSideload(dep, sideload.URI, sideload.SHA256, installDir); err != nil {
+				return err
+			}
+			return s.linkSideload(sideload, installDir)
+		}); err != nil {
+			return fmt.Errorf("%s: %v", sideload.Name, err)
+		}
+	}
+
+	for _, link := range sideloadEnv {
+		dir := filepath.Join(s.Stager.DepDir(), link.dir)
+		if exists, err := libbuildpack.FileExists(dir); err != nil {
+			return err
+		} else if exists && !inPathList(os.Getenv(link.env), dir) {
+			if err := os.Setenv(link.env, strings.TrimSuffix(dir+":"+os.Getenv(link.env), ":")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Supplier) linkSideload(sideload config.Sideload, installDir string) error {
+	linked := false
+	for _, sub := range []string{"bin", "lib", "include"} {
+		dir := filepath.Join(installDir, sideload.Root, sub)
+		if exists, err := libbuildpack.FileExists(dir); err != nil {
+			return err
+		} else if !exists {
+			continue
+		}
+		if err := s.Stager.LinkDirectoryInDepDir(dir, sub); err != nil {
+			return err
+		}
+		linked = linked || sub != "include"
+	}
+
+	if !linked {
+		return fmt.Errorf("the archive has no bin or lib directory below '%s', set root in %s to the directory holding them", sideload.Root, config.Path)
+	}
+	return nil
+}
+
+func inPathList(list, dir string) bool {
+	for _, entry := range filepath.SplitList(list) {
+		if entry == dir {
+			return true
+		}
+	}
+	return false
+}
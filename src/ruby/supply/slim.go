@@ -0,0 +1,125 @@
+package supply
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// strippable are the binaries in the dep dir debug symbols are stripped
+// from, anything not matched is left alone
+var strippable = []string{
+	"ruby/bin/ruby",
+	"ruby/lib/libruby.so*",
+	"ruby/lib/ruby/*/x86_64-linux/*.so",
+	"ruby/lib/ruby/*/x86_64-linux/*/*.so",
+	"node/bin/node",
+}
+
+// prunable is documentation which is not read at runtime
+var prunable = []string{
+	"ruby/share/ri",
+	"ruby/share/doc",
+	"ruby/share/man",
+	"ruby/lib/ruby/gems/*/doc",
+	"node/share/doc",
+	"node/share/man",
+	"node/include",
+}
+
+// SlimInterpreters shrinks the droplet when BP_SLIM_INTERPRETERS=true by
+// stripping debug symbols from the interpreters and removing their
+// documentation. Only the paths in strippable and prunable are touched.
+func (s *Supplier) SlimInterpreters() error {
+	if !s.Flags.Bool("BP_SLIM_INTERPRETERS") {
+		return nil
+	}
+	s.Log.BeginStep("Slimming interpreters")
+
+	var saved int64
+	if _, err := s.Command.Output(s.Stager.BuildDir(), "strip", "--version"); err != nil {
+		s.Log.Warning("strip is not available on this stack, not stripping debug symbols")
+	} else {
+		for _, file := range s.matches(strippable) {
+			if elf, err := isELF(file); err != nil {
+				return err
+			} else if !elf {
+				continue
+			}
+			before, err := os.Stat(file)
+			if err != nil {
+				return err
+			}
+			if output, err := s.Command.Output(s.Stager.BuildDir(), "strip", "--strip-debug", file); err != nil {
+				s.Log.Warning("Could not strip %s: %s", s.relative(file), strings.TrimSpace(output))
+				continue
+			}
+			after, err := os.Stat(file)
+			if err != nil {
+				return err
+			}
+			saved += before.Size() - after.Size()
+		}
+	}
+
+	for _, dir := range s.matches(prunable) {
+		size, err := dirSize(dir)
+		if err != nil {
+			return err
+		}
+		s.Log.Debug("Removing %s", s.relative(dir))
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		saved += size
+	}
+
+	s.Log.Info("Removed %d MB from the droplet", saved>>20)
+	return nil
+}
+
+func (s *Supplier) matches(globs []string) []string {
+	var matches []string
+	for _, glob := range globs {
+		// the globs are constants, so ErrBadPattern can not happen
+		found, _ := filepath.Glob(filepath.Join(s.Stager.DepDir(), glob))
+		matches = append(matches, found...)
+	}
+	return matches
+}
+
+func (s *Supplier) relative(path string) string {
+	if rel, err := filepath.Rel(s.Stager.DepDir(), path); err == nil {
+		return rel
+	}
+	return path
+}
+
+func isELF(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := f.Read(magic); err != nil {
+		return false, nil
+	}
+	return bytes.Equal(magic, []byte("\x7fELF")), nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
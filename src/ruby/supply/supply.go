@@ -3,6 +3,7 @@ package supply
 import (
 	"bytes"
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,8 +11,39 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"ruby/buildignore"
+	"ruby/bundlepath"
 	"ruby/cache"
+	"ruby/config"
+	"ruby/deprecations"
+	"ruby/depsdir"
+	"ruby/diskspace"
+	"ruby/extensions"
+	"ruby/featureflags"
+	"ruby/filesystem"
+	"ruby/freshness"
+	"ruby/gembundle"
+	"ruby/gemsource"
+	"ruby/generated"
+	"ruby/gitcache"
+	"ruby/installer"
+	"ruby/lockfile"
+	"ruby/nodeversion"
+	"ruby/outdated"
+	"ruby/prebuilt"
+	"ruby/problemgems"
+	"ruby/profiled"
+	"ruby/report"
+	"ruby/resolution"
+	"ruby/resolver"
+	"ruby/revision"
+	"ruby/stages"
+	"ruby/syslibs"
+	"ruby/toolchain"
+	"ruby/workspace"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
 	"github.com/kr/text"
@@ -26,11 +58,14 @@ type Command interface {
 type Manifest interface {
 	AllDependencyVersions(string) []string
 	DefaultVersion(string) (libbuildpack.Dependency, error)
+	RootDir() string
 }
 
 type Installer interface {
 	InstallDependency(libbuildpack.Dependency, string) error
 	InstallOnlyVersion(string, string) error
+	InstallSideload(libbuildpack.Dependency, string, string, string) error
+	Reused(libbuildpack.Dependency) error
 }
 
 type Versions interface {
@@ -38,14 +73,17 @@ type Versions interface {
 	Version() (string, error)
 	JrubyVersion() (string, error)
 	RubyEngineVersion() (string, error)
+	RubyPlatform() (string, error)
 	HasGemVersion(gem string, constraints ...string) (bool, error)
 	VersionConstraint(version string, constraints ...string) (bool, error)
 	HasWindowsGemfileLock() (bool, error)
+	GemGroups() (map[string][]string, error)
 	Gemfile() string
 }
 
 type Stager interface {
 	BuildDir() string
+	CacheDir() string
 	DepDir() string
 	DepsIdx() string
 	LinkDirectoryInDepDir(string, string) error
@@ -60,45 +98,108 @@ type TempDir interface {
 
 type Cache interface {
 	Metadata() *cache.Metadata
-	Restore() error
+	Restore(bundlerVersion string, tools toolchain.Versions) error
 	Save() error
+	Stamp(name, input, value string)
+	Stamped(name, input string) string
 }
 
 type Supplier struct {
 	Stager            Stager
 	Manifest          Manifest
+	Resolver          resolver.Resolver
 	Installer         Installer
 	Log               *libbuildpack.Logger
 	Versions          Versions
 	Cache             Cache
 	Command           Command
 	TempDir           TempDir
+	Flags             *featureflags.FeatureFlags
+	Config            *config.Config
+	Workspace         *workspace.Workspace
+	Deprecations      *deprecations.Tracker
+	GemBundle         *gembundle.Bundle
+	FS                filesystem.FS
 	cachedNeedsNode   bool
 	needsNode         bool
 	appHasGemfile     bool
 	appHasGemfileLock bool
+	preinstalledGems  []prebuilt.Gem
+	restored          map[string]bool
+	rubyABI           map[string]string
+	bundle            bundlepath.Path
 }
 
-func Run(s *Supplier) error {
-	s.Log.BeginStep("Supplying Ruby")
+func Run(s *Supplier) (err error) {
+	if rev := s.RecordRevision(); rev != "" {
+		s.Log.BeginStep("Supplying Ruby for revision %s", revision.Short(rev))
+	} else {
+		s.Log.BeginStep("Supplying Ruby")
+	}
+	stage := s.beginStage("supply")
+	defer func() { stage.End(err) }()
+
+	if err := generated.Clean(s.Stager.DepDir(), s.Stager.CacheDir(), s.Log); err != nil {
+		s.Log.Error("Unable to remove scripts generated by the previous staging: %s", err.Error())
+		return err
+	}
+
+	s.CheckManifestAge()
+
+	if err := s.CheckDeprecatedFlags(); err != nil {
+		s.Log.Error("Unable to record deprecations: %s", err.Error())
+		return err
+	}
 
-	_ = s.Command.Execute(s.Stager.BuildDir(), ioutil.Discard, ioutil.Discard, "touch", "/tmp/checkpoint")
+	_ = s.Command.Execute(s.Stager.BuildDir(), ioutil.Discard, ioutil.Discard, "touch", s.Workspace.Path("checkpoint"))
 
 	if checksum, err := s.CalcChecksum(); err == nil {
 		s.Log.Debug("BuildDir Checksum Before Supply: %s", checksum)
 	}
 
+	if err := s.SelectGemfile(); err != nil {
+		s.Log.Error("Unable to select the Gemfile: %s", err.Error())
+		return err
+	}
+
 	if err := s.Setup(); err != nil {
 		s.Log.Error("Error during setup: %v", err)
 		return err
 	}
 
-	if err := s.Cache.Restore(); err != nil {
+	if err := s.runExtensions(extensions.AfterSupplySetup); err != nil {
+		s.Log.Error("%s", err.Error())
+		return err
+	}
+
+	if err := s.CheckProblemGems(); err != nil {
+		s.Log.Error("Unable to install gems: %s", err.Error())
+		return err
+	}
+
+	if err := s.CheckGemSources(); err != nil {
+		s.Log.Error("Unable to install gems: %s", err.Error())
+		return err
+	}
+
+	tools, err := s.DetectToolchain()
+	if err != nil {
+		s.Log.Error("Unable to record the compiler toolchain: %s", err.Error())
+		return err
+	}
+
+	if err := s.stage("restore_cache", func() error { return s.Cache.Restore(s.bundlerVersion(), tools) }); err != nil {
 		s.Log.Error("Unable to restore cache: %s", err.Error())
 		return err
 	}
+	s.recordCacheHits()
+
+	if err := s.MigrateDepDir(); err != nil {
+		s.Log.Error("Unable to migrate the cached dep dir: %s", err.Error())
+		return err
+	}
 
-	if err := s.InstallBundler(); err != nil {
+	if err := s.stage("install_bundler", s.InstallBundler); err != nil {
 		s.Log.Error("Unable to install bundler: %s", err.Error())
 		return err
 	}
@@ -126,37 +227,114 @@ func Run(s *Supplier) error {
 		}
 	}
 
-	if err := s.InstallRuby(engine, rubyVersion); err != nil {
+	if err := s.stage("install_ruby", func() error {
+		if s.Flags.String("BP_RUBY_URL") == "" {
+			return s.InstallRuby(engine, rubyVersion)
+		}
+		version, err := s.InstallDeveloperRuby()
+		rubyVersion = version
+		return err
+	}); err != nil {
 		s.Log.Error("Unable to install ruby: %s", err.Error())
 		return err
 	}
 
+	s.AdviseRubyUpgrade(engine, rubyVersion)
+
 	if err := s.AddPostRubyInstallDefaultEnv(engine); err != nil {
 		s.Log.Error("Unable to add bundler and gem path to default environment: %s", err.Error())
 		return err
 	}
 
-	if err := s.UpdateRubygems(); err != nil {
+	if err := s.ExportRubyABI(engine); err != nil {
+		s.Log.Error("Unable to export the ruby ABI: %s", err.Error())
+		return err
+	}
+
+	if err := s.UpdateRubygems(rubyVersion); err != nil {
 		s.Log.Error("Unable to update rubygems: %s", err.Error())
 		return err
 	}
 
 	if s.NeedsNode() {
-		if err := s.InstallNode(); err != nil {
+		if err := s.stage("install_node", s.InstallNode); err != nil {
 			s.Log.Error("Unable to install node: %s", err.Error())
 			return err
 		}
 
-		if err := s.InstallYarn(); err != nil {
+		if err := s.stage("install_yarn", s.InstallYarn); err != nil {
 			s.Log.Error("Unable to install yarn: %s", err.Error())
 			return err
 		}
 	}
 
-	if err := s.InstallGems(); err != nil {
+	if rustGems := s.RustGems(); len(rustGems) > 0 {
+		if err := s.InstallRust(rustGems); err != nil {
+			s.Log.Error("Unable to install rust: %s", err.Error())
+			return err
+		}
+	}
+
+	if err := s.InstallSideloads(); err != nil {
+		s.Log.Error("Unable to install sideloaded dependencies: %s", err.Error())
+		return err
+	}
+
+	if err := s.InstallBundledPrebuiltGems(engine, rubyVersion); err != nil {
+		s.Log.Error("Unable to install prebuilt gems from the gem bundle: %s", err.Error())
+		return err
+	}
+
+	if err := s.FetchPrebuiltGems(engine, rubyVersion); err != nil {
+		s.Log.Error("Unable to fetch prebuilt gems: %s", err.Error())
+		return err
+	}
+
+	if err := s.CheckSystemLibraries(); err != nil {
+		s.Log.Error("Unable to install gems: %s", err.Error())
+		return err
+	}
+
+	if err := s.PrepareGitGems(engine); err != nil {
+		s.Log.Error("Unable to prepare git gems: %s", err.Error())
+		return err
+	}
+
+	gemsStart := time.Now()
+	if err := s.stage("install_gems", s.InstallGems); err != nil {
 		s.Log.Error("Unable to install gems: %s", err.Error())
 		return err
 	}
+	s.recordComponent("gems", gemsStart, s.restored["vendor_bundle"])
+
+	if err := s.VerifyGemChecksums(); err != nil {
+		s.Log.Error("Unable to verify gem checksums: %s", err.Error())
+		return err
+	}
+
+	if err := s.VerifyGitGems(engine); err != nil {
+		s.Log.Error("Unable to verify git gems: %s", err.Error())
+		return err
+	}
+
+	if err := s.runExtensions(extensions.AfterGems); err != nil {
+		s.Log.Error("%s", err.Error())
+		return err
+	}
+
+	s.RecordGemEgress()
+
+	s.ReportOutdatedGems()
+
+	if err := s.StorePrebuiltGems(engine, rubyVersion); err != nil {
+		s.Log.Error("Unable to store prebuilt gems: %s", err.Error())
+		return err
+	}
+
+	if err := s.InstallAdditionalRuby(engine, rubyVersion); err != nil {
+		s.Log.Error("Unable to install the additional ruby: %s", err.Error())
+		return err
+	}
 
 	if err := s.RewriteShebangs(); err != nil {
 		s.Log.Error("Unable to rewrite shebangs: %s", err.Error())
@@ -168,16 +346,31 @@ func Run(s *Supplier) error {
 		return err
 	}
 
+	if err := s.SlimInterpreters(); err != nil {
+		s.Log.Error("Unable to slim interpreters: %s", err.Error())
+		return err
+	}
+
 	if err := s.WriteProfileD(engine); err != nil {
 		s.Log.Error("Unable to write profile.d: %s", err.Error())
 		return err
 	}
 
-	if err := s.Cache.Save(); err != nil {
+	if err := s.runExtensions(extensions.AfterSupply); err != nil {
+		s.Log.Error("%s", err.Error())
+		return err
+	}
+
+	if err := s.stage("save_cache", s.Cache.Save); err != nil {
 		s.Log.Error("Unable to save cache: %s", err.Error())
 		return err
 	}
 
+	if err := gitcache.Unlink(s.Stager.DepDir()); err != nil {
+		s.Log.Error("Unable to remove the git gem clones: %s", err.Error())
+		return err
+	}
+
 	if err := s.Stager.SetStagingEnvironment(); err != nil {
 		s.Log.Error("Unable to setup environment variables: %s", err.Error())
 		return err
@@ -187,7 +380,7 @@ func Run(s *Supplier) error {
 		s.Log.Debug("BuildDir Checksum After Supply: %s", checksum)
 	}
 
-	if filesChanged, err := s.Command.Output(s.Stager.BuildDir(), "find", ".", "-newer", "/tmp/checkpoint", "-not", "-path", "./.cloudfoundry/*", "-not", "-path", "./.cloudfoundry"); err == nil && filesChanged != "" {
+	if filesChanged, err := s.Command.Output(s.Stager.BuildDir(), "find", ".", "-newer", s.Workspace.Path("checkpoint"), "-not", "-path", "./.cloudfoundry/*", "-not", "-path", "./.cloudfoundry"); err == nil && filesChanged != "" {
 		s.Log.Debug("Below files changed:")
 		s.Log.Debug(filesChanged)
 	}
@@ -195,25 +388,244 @@ func Run(s *Supplier) error {
 	return nil
 }
 
+// nextGemfiles are the names dual boot tools give the Gemfile of the next
+// Rails version, Gemfile.next is what next_rails creates
+var nextGemfiles = []string{"Gemfile_next", "Gemfile.next"}
+
+// SelectGemfile points BUNDLE_GEMFILE at the next Gemfile of a dual boot app
+// when BP_GEMFILE_NEXT is set, so a canary of the Rails upgrade can be pushed
+// from the same repo. BUNDLE_GEMFILE set by the app always wins.
+func (s *Supplier) SelectGemfile() error {
+	if gemfile := os.Getenv("BUNDLE_GEMFILE"); gemfile != "" {
+		if s.Flags.Bool("BP_GEMFILE_NEXT") {
+			s.Log.Warning("Ignoring BP_GEMFILE_NEXT, BUNDLE_GEMFILE is set to %s", gemfile)
+		}
+		return nil
+	}
+
+	next := ""
+	for _, name := range nextGemfiles {
+		if exists, err := filesystem.Exists(s.fs(), filepath.Join(s.Stager.BuildDir(), name+".lock")); err != nil {
+			return err
+		} else if exists {
+			next = name
+			break
+		}
+	}
+
+	if !s.Flags.Bool("BP_GEMFILE_NEXT") {
+		if next != "" {
+			s.Log.Info("Found %s.lock, set BP_GEMFILE_NEXT=true to stage with %s", next, next)
+		}
+		return nil
+	} else if next == "" {
+		return fmt.Errorf("BP_GEMFILE_NEXT is set but there is no %s", strings.Join(nextGemfiles, ".lock or ")+".lock")
+	}
+
+	s.Log.BeginStep("Staging with %s", next)
+	if err := os.Setenv("BUNDLE_GEMFILE", next); err != nil {
+		return err
+	}
+	return s.Stager.WriteEnvFile("BUNDLE_GEMFILE", next)
+}
+
+// deps is the dep dir of the buildpack
+func (s *Supplier) deps() depsdir.Dir {
+	return depsdir.Dir(s.Stager.DepDir())
+}
+
+// fs is the filesystem the app and deps dir are on, tests can set FS to an
+// in-memory one
+func (s *Supplier) fs() filesystem.FS {
+	if s.FS == nil {
+		return filesystem.OS
+	}
+	return s.FS
+}
+
 func (s *Supplier) Setup() error {
-	if exists, err := libbuildpack.FileExists(s.Versions.Gemfile()); err != nil {
+	if exists, err := filesystem.Exists(s.fs(), s.Versions.Gemfile()); err != nil {
 		return fmt.Errorf("Unable to determine if Gemfile exists: %v", err)
 	} else {
 		s.appHasGemfile = exists
 	}
 
-	if exists, err := libbuildpack.FileExists(s.Versions.Gemfile() + ".lock"); err != nil {
+	if exists, err := filesystem.Exists(s.fs(), s.Versions.Gemfile()+".lock"); err != nil {
 		return fmt.Errorf("Unable to determine if Gemfile.lock exists: %v", err)
 	} else {
 		s.appHasGemfileLock = exists
 	}
 
+	return s.SetupBundlePath()
+}
+
+func (s *Supplier) CheckProblemGems() error {
+	if !s.appHasGemfileLock {
+		return nil
+	}
+
+	found, err := problemgems.Find(s.Versions)
+	if err != nil {
+		return fmt.Errorf("Unable to check for known problem gems: %v", err)
+	}
+
+	stackTable, err := problemgems.LoadStackIncompatible(filepath.Join(s.Manifest.RootDir(), "manifest.yml"))
+	if err != nil {
+		return fmt.Errorf("Unable to load native gem incompatibilities from the manifest: %v", err)
+	}
+	stackGems, err := problemgems.FindOnStack(s.Versions, os.Getenv("CF_STACK"), stackTable)
+	if err != nil {
+		return fmt.Errorf("Unable to check for gems incompatible with the stack: %v", err)
+	}
+	found = s.installedProblemGems(append(found, stackGems...))
+
+	fatal := false
+	for _, gem := range found {
+		if gem.Fatal {
+			fatal = true
+			s.Log.Error("The gem '%s' %s.\n%s", gem.Name, gem.Reason, gem.Migration)
+		} else {
+			s.Log.Warning("The gem '%s' %s.\n%s", gem.Name, gem.Reason, gem.Migration)
+		}
+	}
+	if fatal {
+		return fmt.Errorf("Gemfile.lock contains gems which can not be installed on this stack")
+	}
 	return nil
 }
 
+// installedProblemGems leaves out the gems only in groups BUNDLE_WITHOUT
+// skips, bundle install does not install them
+func (s *Supplier) installedProblemGems(found []problemgems.Gem) []problemgems.Gem {
+	if len(found) == 0 {
+		return found
+	}
+	groups, err := s.Versions.GemGroups()
+	if err != nil {
+		s.Log.Debug("Unable to read the Gemfile groups: %v", err)
+		return found
+	}
+	skipped := s.skippedGroups()
+	var installed []problemgems.Gem
+	for _, gem := range found {
+		if installedGroups(groups[gem.Name], skipped) {
+			installed = append(installed, gem)
+		} else {
+			s.Log.Debug("Skipping the check of %s, it is only in the groups %s", gem.Name, strings.Join(groups[gem.Name], ", "))
+		}
+	}
+	return installed
+}
+
+// CheckGemSources fails staging when the Gemfile or its lock fetch gems over
+// plain http, unless BP_ALLOW_HTTP_GEM_SOURCES turns that into a warning.
+// The sources found are recorded in the staging report either way.
+func (s *Supplier) CheckGemSources() error {
+	if !s.appHasGemfile {
+		return nil
+	}
+
+	gemfile := s.Versions.Gemfile()
+	insecure, err := gemsource.FindInsecure(gemfile, gemfile+".lock")
+	if err != nil {
+		return fmt.Errorf("Unable to read the gem sources: %v", err)
+	}
+	if err := report.Update(s.Stager.DepDir(), func(r *report.Report) {
+		r.InsecureGemSources = nil
+		for _, source := range insecure {
+			r.InsecureGemSources = append(r.InsecureGemSources, source.String())
+		}
+	}); err != nil {
+		return err
+	}
+	if len(insecure) == 0 {
+		return nil
+	}
+
+	allow := s.Flags.Bool("BP_ALLOW_HTTP_GEM_SOURCES")
+	for _, source := range insecure {
+		if allow {
+			s.Log.Warning("Gems are fetched over plain http from %s", source)
+		} else {
+			s.Log.Error("Gems are fetched over plain http from %s", source)
+		}
+	}
+	if allow {
+		return nil
+	}
+	s.Log.Info("Change the sources to https, or set BP_ALLOW_HTTP_GEM_SOURCES=true to stage anyway")
+	return fmt.Errorf("the app fetches gems over plain http")
+}
+
+// VerifyGemChecksums compares the installed gem packages with the CHECKSUMS
+// section bundler 2.5 writes to Gemfile.lock and fails staging on the first
+// difference, the bundler the buildpack installs does not check them
+// itself. Bundler downloads and installs each gem in one step, so this runs
+// once bundle install is done: it keeps a tampered gem out of the droplet,
+// but the gem's extconf.rb and any other install time code has already run
+// on the staging container by then. Only fallback gems are checked before
+// they are installed, see gemsource.Fallback.Fetch. Without the section the
+// checksums are recorded in the staging report when BP_REPORT_GEM_CHECKSUMS
+// is set, so a later staging can be compared against them.
+func (s *Supplier) VerifyGemChecksums() error {
+	if !s.appHasGemfile {
+		return nil
+	}
+
+	gemfileLock := s.Versions.Gemfile() + ".lock"
+	if exists, err := libbuildpack.FileExists(gemfileLock); err != nil || !exists {
+		return err
+	}
+	lock, err := lockfile.ParseFile(gemfileLock)
+	if err != nil {
+		return err
+	}
+	if len(lock.Checksums) == 0 && !s.Flags.Bool("BP_REPORT_GEM_CHECKSUMS") {
+		return nil
+	}
+
+	dirs, err := s.bundlePath().Glob("cache")
+	if err != nil {
+		return err
+	}
+	installed, err := gemsource.Checksums(dirs...)
+	if err != nil {
+		return err
+	}
+
+	if len(lock.Checksums) == 0 {
+		s.Log.Info("Recorded the checksums of %d gems in the staging report", len(installed))
+		return report.Update(s.Stager.DepDir(), func(r *report.Report) { r.GemChecksums = installed })
+	}
+
+	mismatches := gemsource.VerifyChecksums(lock.Checksums, installed)
+	if len(mismatches) == 0 {
+		s.Log.Info("Verified the installed gems against the checksums in %s", filepath.Base(gemfileLock))
+		return nil
+	}
+	for _, mismatch := range mismatches {
+		s.Log.Error("Checksum mismatch: %s", mismatch)
+	}
+	return fmt.Errorf("the package of %s differs from the one in %s", mismatches[0].Gem, filepath.Base(gemfileLock))
+}
+
 func (s *Supplier) DetermineRuby() (string, string, error) {
+	if url, _, err := s.developerRuby(); err != nil {
+		return "", "", err
+	} else if url != "" {
+		// InstallDeveloperRuby determines the version once it is installed
+		if s.appHasGemfile {
+			if engine, err := s.Versions.Engine(); err != nil {
+				return "", "", fmt.Errorf("Unable to determine ruby engine: %v", err)
+			} else if engine != "ruby" {
+				return "", "", fmt.Errorf("BP_RUBY_URL only installs MRI, the Gemfile declares %s", engine)
+			}
+		}
+		return "ruby", "", nil
+	}
+
 	if !s.appHasGemfile {
-		dep, err := s.Manifest.DefaultVersion("ruby")
+		dep, err := s.Resolver.DefaultVersion("ruby")
 		if err != nil {
 			return "", "", fmt.Errorf("Unable to determine default ruby version: %v", err)
 		}
@@ -231,8 +643,17 @@ func (s *Supplier) DetermineRuby() (string, string, error) {
 		if err != nil {
 			return "", "", fmt.Errorf("Unable to determine ruby version: %v", err)
 		}
+		if rubyVersion != "" && s.Config.Ruby.Version != "" {
+			s.Log.Warning("Ignoring ruby.version in %s, the Gemfile declares the Ruby version", config.Path)
+		} else if rubyVersion == "" && s.Config.Ruby.Version != "" {
+			rubyVersion, err = s.Resolver.FindMatchingVersion("ruby", s.Config.Ruby.Version)
+			if err != nil {
+				return "", "", fmt.Errorf("Unable to find ruby %s from %s: %v", s.Config.Ruby.Version, config.Path, err)
+			}
+			s.Log.Info("Using ruby %s from %s", rubyVersion, config.Path)
+		}
 		if rubyVersion == "" {
-			if dep, err := s.Manifest.DefaultVersion("ruby"); err != nil {
+			if dep, err := s.Resolver.DefaultVersion("ruby"); err != nil {
 				return "", "", fmt.Errorf("Unable to determine ruby version: %v", err)
 			} else {
 				rubyVersion = dep.Version
@@ -259,64 +680,92 @@ func (s *Supplier) InstallYarn() error {
 		return nil
 	}
 
-	tempDir, err := ioutil.TempDir("", "yarn")
-	if err != nil {
-		return err
-	}
-	if err := s.Installer.InstallOnlyVersion("yarn", tempDir); err != nil {
-		return err
-	}
-	if paths, err := filepath.Glob(filepath.Join(tempDir, "yarn-v*")); err != nil {
-		return err
-	} else if len(paths) != 1 {
-		return fmt.Errorf("Unable to find yarn distribution dir")
-	} else {
-		tempDir = paths[0]
-	}
+	return s.installOnce("yarn", s.onlyVersion("yarn"), "yarn", func() error {
+		tempDir, err := s.Workspace.Dir("yarn")
+		if err != nil {
+			return err
+		}
+		if err := s.Installer.InstallOnlyVersion("yarn", tempDir); err != nil {
+			return err
+		}
+		if paths, err := filepath.Glob(filepath.Join(tempDir, "yarn-v*")); err != nil {
+			return err
+		} else if len(paths) != 1 {
+			return fmt.Errorf("Unable to find yarn distribution dir")
+		} else {
+			tempDir = paths[0]
+		}
 
-	if err := os.Rename(tempDir, filepath.Join(s.Stager.DepDir(), "yarn")); err != nil {
-		return err
-	}
-	return s.Stager.LinkDirectoryInDepDir(filepath.Join(s.Stager.DepDir(), "yarn", "bin"), "bin")
+		if err := os.Rename(tempDir, filepath.Join(s.Stager.DepDir(), "yarn")); err != nil {
+			return err
+		}
+		return s.Stager.LinkDirectoryInDepDir(filepath.Join(s.Stager.DepDir(), "yarn", "bin"), "bin")
+	})
 }
 
 func (s *Supplier) InstallBundler() error {
-	if err := s.Installer.InstallOnlyVersion("bundler", filepath.Join(s.Stager.DepDir(), "bundler")); err != nil {
-		return err
-	}
-
-	if err := s.Stager.LinkDirectoryInDepDir(filepath.Join(s.Stager.DepDir(), "bundler", "bin"), "bin"); err != nil {
-		return err
-	}
-
-	return nil
+	return s.installOnce("bundler", s.bundlerVersion(), "bundler", func() error {
+		if err := s.Installer.InstallOnlyVersion("bundler", s.deps().Bundler()); err != nil {
+			return err
+		}
+		return s.Stager.LinkDirectoryInDepDir(s.deps().Join(depsdir.Bundler, "bin"), "bin")
+	})
 }
 
 func (s *Supplier) InstallNode() error {
 	var dep libbuildpack.Dependency
 
-	tempDir, err := ioutil.TempDir("", "node")
-	if err != nil {
-		return err
-	}
 	nodeInstallDir := filepath.Join(s.Stager.DepDir(), "node")
 
-	version, err := libbuildpack.FindMatchingVersion("x", s.Manifest.AllDependencyVersions("node"))
+	version, err := s.nodeVersion()
 	if err != nil {
 		return err
 	}
 	dep.Name = "node"
 	dep.Version = version
 
-	if err := s.Installer.InstallDependency(dep, tempDir); err != nil {
-		return err
-	}
+	return s.installOnce(dep.Name, dep.Version, "node", func() error {
+		tempDir, err := s.Workspace.Dir("node")
+		if err != nil {
+			return err
+		}
 
-	if err := os.Rename(filepath.Join(tempDir, fmt.Sprintf("node-v%s-linux-x64", dep.Version)), nodeInstallDir); err != nil {
-		return err
+		if err := s.Installer.InstallDependency(dep, tempDir); err != nil {
+			return err
+		}
+
+		if err := os.Rename(filepath.Join(tempDir, fmt.Sprintf("node-v%s-linux-x64", dep.Version)), nodeInstallDir); err != nil {
+			return err
+		}
+
+		return s.Stager.LinkDirectoryInDepDir(filepath.Join(nodeInstallDir, "bin"), "bin")
+	})
+}
+
+// nodeVersion is the newest node allowed by the version hints of the app,
+// hints which conflict with one of a higher priority are ignored with a
+// warning. Without hints it is the newest node.
+func (s *Supplier) nodeVersion() (string, error) {
+	hints, err := nodeversion.Hints(s.Stager.BuildDir())
+	if err != nil {
+		return "", err
+	}
+	if len(hints) == 0 {
+		return s.Resolver.FindMatchingVersion("node", "x")
 	}
 
-	return s.Stager.LinkDirectoryInDepDir(filepath.Join(nodeInstallDir, "bin"), "bin")
+	version, conflicts, err := nodeversion.Resolve(hints, s.Resolver.AllDependencyVersions("node"))
+	if err != nil {
+		return "", err
+	}
+	for _, conflict := range conflicts {
+		s.Log.Warning("The %s and is ignored", conflict)
+	}
+	if len(conflicts) > 0 {
+		s.Log.Info("Node versions are resolved from %s", nodeversion.Order)
+	}
+	s.Log.Debug("Node %s matches %v", version, hints)
+	return version, nil
 }
 
 func (s *Supplier) NeedsNode() bool {
@@ -348,196 +797,816 @@ func (s *Supplier) isNodeInstalled() bool {
 	return err == nil
 }
 
-func (s *Supplier) InstallJVM() error {
-	if exists, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), ".jdk")); err != nil {
-		return err
-	} else if exists {
-		s.Log.Info("Using pre-installed JDK")
+// RustGems returns the gems in the Gemfile.lock which compile a native
+// extension with cargo, either through rb_sys or a bundled rust crate. Gems
+// locked precompiled for the platform of the ruby, or only in groups
+// BUNDLE_WITHOUT leaves out, do not need rust.
+func (s *Supplier) RustGems() []string {
+	lock, err := lockfile.ParseInstallable(s.Versions.Gemfile() + ".lock")
+	if err != nil {
 		return nil
 	}
-
-	jvmInstallDir := filepath.Join(s.Stager.DepDir(), "jvm")
-	if err := s.Installer.InstallOnlyVersion("openjdk1.8-latest", jvmInstallDir); err != nil {
-		return err
+	platform, err := s.Versions.RubyPlatform()
+	if err != nil {
+		return nil
 	}
-	if err := s.Stager.LinkDirectoryInDepDir(filepath.Join(jvmInstallDir, "bin"), "bin"); err != nil {
-		return err
+	groups, err := s.Versions.GemGroups()
+	if err != nil {
+		s.Log.Debug("Unable to read the Gemfile groups: %v", err)
+	}
+	skipped := s.skippedGroups()
+	compiled := func(name string) bool {
+		return lock.Compiled(name, platform) && installedGroups(groups[name], skipped)
 	}
 
-	scriptContents := `
-if ! [[ "${JAVA_OPTS}" == *-Xmx* ]]; then
-  export JAVA_MEM=${JAVA_MEM:--Xmx${JVM_MAX_HEAP:-384}m}
-fi
-export JAVA_OPTS=${JAVA_OPTS:--Xss512k -XX:+UseCompressedOops -Dfile.encoding=UTF-8}
-export JRUBY_OPTS=${JRUBY_OPTS:--Xcompile.invokedynamic=false}
-`
-
-	return s.Stager.WriteProfileD("jruby.sh", scriptContents)
+	var found []string
+	for _, gem := range []struct{ name, constraint string }{
+		{"rb_sys", ">= 0"},
+		{"wasmtime", ">= 0"},
+		{"polars-df", ">= 0"},
+		{"commonmarker", ">= 1.0.0"},
+	} {
+		if hasGem, err := s.Versions.HasGemVersion(gem.name, gem.constraint); err != nil || !hasGem {
+			continue
+		}
+		if gem.name == "rb_sys" {
+			// rb_sys compiles the extensions of the gems depending on it
+			if !anyDependent(lock, gem.name, compiled) {
+				continue
+			}
+		} else if !compiled(gem.name) {
+			continue
+		}
+		found = append(found, gem.name)
+	}
+	return found
+}
+
+// anyDependent reports whether any locked gem depending on name matches
+func anyDependent(lock *lockfile.Lockfile, name string, match func(string) bool) bool {
+	for _, spec := range lock.Specs {
+		for _, dep := range spec.Dependencies {
+			if dep.Name == name && match(spec.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Supplier) isRustInstalled() bool {
+	_, err := s.Command.Output(s.Stager.BuildDir(), "cargo", "--version")
+	return err == nil
+}
+
+func (s *Supplier) InstallRust(gems []string) error {
+	if s.isRustInstalled() {
+		s.Log.BeginStep("Skipping install of rust since it has been supplied")
+		return nil
+	}
+
+	versions := s.Resolver.AllDependencyVersions("rust")
+	if len(versions) == 0 {
+		s.Log.Warning("The gems %s need a rust toolchain to compile, but rust is not available for the %s stack. Lock them precompiled for x86_64-linux or supply rust with another buildpack.", strings.Join(gems, ", "), os.Getenv("CF_STACK"))
+		return nil
+	}
+	version, err := libbuildpack.FindMatchingVersion("x", versions)
+	if err != nil {
+		return err
+	}
+
+	s.Log.BeginStep("Installing rust for %s", strings.Join(gems, ", "))
+	rustInstallDir := filepath.Join(s.Stager.DepDir(), "rust")
+	if err := s.installOnce("rust", version, "rust", func() error {
+		if err := s.Installer.InstallDependency(libbuildpack.Dependency{Name: "rust", Version: version}, rustInstallDir); err != nil {
+			return err
+		}
+		return s.Stager.LinkDirectoryInDepDir(filepath.Join(rustInstallDir, "bin"), "bin")
+	}); err != nil {
+		return err
+	}
+
+	return s.writeEnvFiles(map[string]string{"CARGO_HOME": filepath.Join(s.Stager.DepDir(), "cargo")}, false)
+}
+
+func (s *Supplier) InstallJVM() error {
+	if exists, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), ".jdk")); err != nil {
+		return err
+	} else if exists {
+		s.Log.Info("Using pre-installed JDK")
+		return nil
+	}
+
+	jvmInstallDir := filepath.Join(s.Stager.DepDir(), "jvm")
+	if err := s.installOnce("openjdk1.8-latest", s.onlyVersion("openjdk1.8-latest"), "jvm", func() error {
+		if err := s.Installer.InstallOnlyVersion("openjdk1.8-latest", jvmInstallDir); err != nil {
+			return err
+		}
+		return s.Stager.LinkDirectoryInDepDir(filepath.Join(jvmInstallDir, "bin"), "bin")
+	}); err != nil {
+		return err
+	}
+
+	scriptContents := `
+if ! [[ "${JAVA_OPTS}" == *-Xmx* ]]; then
+  export JAVA_MEM=${JAVA_MEM:--Xmx${JVM_MAX_HEAP:-384}m}
+fi
+export JAVA_OPTS=${JAVA_OPTS:--Xss512k -XX:+UseCompressedOops -Dfile.encoding=UTF-8}
+export JRUBY_OPTS=${JRUBY_OPTS:--Xcompile.invokedynamic=false}
+`
+
+	return s.Stager.WriteProfileD("jruby.sh", scriptContents)
 }
 
 func (s *Supplier) InstallRuby(name, version string) error {
-	installDir := filepath.Join(s.Stager.DepDir(), "ruby")
+	installDir := s.deps().Ruby()
+
+	if err := s.installOnce(name, version, "ruby", func() error {
+		if err := s.Installer.InstallDependency(libbuildpack.Dependency{Name: name, Version: version}, installDir); err != nil {
+			return err
+		}
+		return s.linkRuby()
+	}); err != nil {
+		return err
+	}
+
+	return report.Update(s.Stager.DepDir(), func(r *report.Report) {
+		r.Engine = name
+		r.Ruby = version
+	})
+}
+
+// linkRuby puts the ruby installed into the dep dir on the PATH
+func (s *Supplier) linkRuby() error {
+	if err := s.RewriteShebangs(); err != nil {
+		return err
+	}
+
+	if err := os.Symlink("ruby", s.deps().Join(depsdir.Ruby, "bin", "ruby.exe")); err != nil {
+		return err
+	}
+	return s.Stager.LinkDirectoryInDepDir(s.deps().Join(depsdir.Ruby, "bin"), "bin")
+}
+
+func (s *Supplier) RewriteShebangs() error {
+	files1, err := s.fs().Glob(s.deps().Join(depsdir.Bin, "*"))
+	if err != nil {
+		return err
+	}
+	files2, err := s.fs().Glob(s.bundlePath().Dir("ruby", "*", "bin", "*"))
+	if err != nil {
+		return err
+	}
+	files3, err := s.fs().Glob(filepath.Join(s.Stager.DepDir(), additionalRubyDir, "bin", "*"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range append(append(files1, files2...), files3...) {
+		if fileInfo, err := s.fs().Stat(file); err != nil {
+			return err
+		} else if fileInfo.IsDir() {
+			continue
+		}
+		fileContents, err := s.fs().ReadFile(file)
+		if err != nil {
+			return err
+		}
+		shebangRegex := regexp.MustCompile(`^#!/.*/ruby.*`)
+		fileContents = shebangRegex.ReplaceAll(fileContents, []byte("#!/usr/bin/env ruby"))
+		if err := s.fs().WriteFile(file, fileContents, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bundlerVersion returns the only bundler version in the manifest, or an
+// empty string when there is not exactly one
+func (s *Supplier) bundlerVersion() string {
+	return s.onlyVersion("bundler")
+}
+
+// RecordRevision adds the commit the app was pushed from to the staging
+// report, finalize exports it to the app as APP_REVISION. Staging goes on
+// without it when it can not be read.
+func (s *Supplier) RecordRevision() string {
+	rev, err := revision.Detect(s.Stager.BuildDir())
+	if err != nil {
+		s.Log.Warning("Unable to determine the app revision: %s", err.Error())
+		return ""
+	}
+	if err := report.Update(s.Stager.DepDir(), func(r *report.Report) { r.Revision = rev }); err != nil {
+		s.Log.Warning("Unable to record the app revision: %s", err.Error())
+	}
+	return rev
+}
+
+// DetectToolchain records the versions of the tools native extensions are
+// compiled with in the staging report, the cache compares them to decide
+// whether gems compiled by an earlier staging can be reused
+func (s *Supplier) DetectToolchain() (toolchain.Versions, error) {
+	tools := toolchain.Detect(s.Command, s.Stager.BuildDir())
+	s.Log.Debug("Compiler toolchain: %s", tools)
+	return tools, report.Update(s.Stager.DepDir(), func(r *report.Report) {
+		r.Toolchain = tools
+	})
+}
+
+func (s *Supplier) SymlinkBundlerIntoRubygems() error {
+	s.Log.Debug("SymlinkBundlerIntoRubygems")
+
+	rubyEngineVersion, err := s.Versions.RubyEngineVersion()
+	if err != nil {
+		return fmt.Errorf("Unable to determine ruby engine: %s", err)
+	}
+	bundlerVersion := s.bundlerVersion()
+	if bundlerVersion == "" {
+		return fmt.Errorf("expect 1 version of bundler, found %d", len(s.Manifest.AllDependencyVersions("bundler")))
+	}
+
+	destDir := s.deps().Join(depsdir.Ruby, "lib", "ruby", "gems", rubyEngineVersion, "gems")
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+	srcDir := s.deps().Join(depsdir.Bundler, "gems", "bundler-"+bundlerVersion)
+	relPath, err := filepath.Rel(destDir, srcDir)
+	if err != nil {
+		return err
+	}
+
+	destFile := filepath.Join(destDir, "bundler-"+bundlerVersion)
+	if found, err := libbuildpack.FileExists(destFile); err != nil {
+		return err
+	} else if found {
+		s.Log.Debug("Skipping linking bundler since destination exists")
+		return nil
+	}
+
+	return os.Symlink(relPath, destFile)
+}
+
+// UpdateRubygems upgrades rubygems to the version the manifest pins for
+// the ruby version line, or the only rubygems in the manifest, and records
+// the rubygems staging ends up with in the staging report. The installer
+// verifies the sha256 of the rubygems download.
+func (s *Supplier) UpdateRubygems(rubyVersion string) error {
+	dep := libbuildpack.Dependency{Name: "rubygems"}
+	versions := s.Resolver.AllDependencyVersions(dep.Name)
+	if len(versions) == 0 {
+		return nil
+	}
+	pins, err := installer.LoadRubygemsVersions(filepath.Join(s.Manifest.RootDir(), "manifest.yml"))
+	if err != nil {
+		return fmt.Errorf("Could not load the rubygems versions from the manifest: %v", err)
+	}
+	if pinned, found := installer.RubygemsFor(pins, rubyVersion); found {
+		if !containsString(versions, pinned) {
+			return fmt.Errorf("The manifest pins rubygems %s for ruby %s, but does not include it", pinned, rubyVersion)
+		}
+		dep.Version = pinned
+	} else if len(versions) > 1 {
+		return fmt.Errorf("Too many versions of rubygems in manifest")
+	} else {
+		dep.Version = versions[0]
+	}
+
+	currVersion, err := s.Command.Output("/", "gem", "--version")
+	if err != nil {
+		return fmt.Errorf("Could not determine current version of rubygems: %v", err)
+	}
+
+	currVersion = strings.TrimSpace(currVersion)
+	if newer, err := s.Versions.VersionConstraint(currVersion, fmt.Sprintf(">= %s", dep.Version)); err != nil {
+		return fmt.Errorf("Could not parse rubygems version constraint: %s >= %s: %v", currVersion, dep.Version, err)
+	} else if newer {
+		return s.recordRubygems(currVersion)
+	}
+
+	if engine, err := s.Versions.Engine(); err != nil {
+		return err
+	} else if engine == "jruby" {
+		s.Log.Debug("Skipping update of rubygems since jruby")
+		return s.recordRubygems(currVersion)
+	}
+
+	s.Log.BeginStep("Update rubygems from %s to %s", currVersion, dep.Version)
+
+	tempDir, err := s.Workspace.Dir("rubygems")
+	if err != nil {
+		return err
+	}
+
+	if err := s.Installer.InstallDependency(dep, tempDir); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.deps().GemHome(), 0755); err != nil {
+		return err
+	}
+
+	rubygemsDir := filepath.Join(tempDir, fmt.Sprintf("rubygems-%s", dep.Version))
+	if output, err := s.Command.Output(rubygemsDir, "ruby", "setup.rb"); err != nil {
+		s.Log.Error(output)
+		return fmt.Errorf("Could not install rubygems: %v", err)
+	}
+
+	return s.recordRubygems(dep.Version)
+}
+
+// beginStage begins the stage name in the log, see stages. Its duration goes
+// to the staging metrics when it ends.
+func (s *Supplier) beginStage(name string) *stages.Stage {
+	return stages.Begin(s.Log, s.Stager.DepDir(), name)
+}
+
+// stage runs fn as the stage name, see stages
+func (s *Supplier) stage(name string, fn func() error) error {
+	return stages.Run(s.Log, s.Stager.DepDir(), name, fn)
+}
+
+// runExtensions runs the extensions compiled in for point, see extensions
+func (s *Supplier) runExtensions(point extensions.Point) error {
+	return extensions.Run(extensions.Context{
+		Point:    point,
+		BuildDir: s.Stager.BuildDir(),
+		DepDir:   s.Stager.DepDir(),
+		DepsIdx:  s.Stager.DepsIdx(),
+		Flags:    s.Flags,
+		Log:      s.Log,
+	})
+}
+
+// recordComponent adds how long installing a component took to the staging
+// metrics, warm when it was installed on top of an earlier staging's work
+func (s *Supplier) recordComponent(name string, start time.Time, warm bool) {
+	if err := report.RecordComponent(s.Stager.DepDir(), name, start, warm); err != nil {
+		s.Log.Debug("Unable to record the install time of %s: %v", name, err)
+	}
+}
+
+// recordCacheHits records which cached directories Restore brought back
+func (s *Supplier) recordCacheHits() {
+	s.restored = map[string]bool{}
+	for _, name := range cache.Cached {
+		hit, err := libbuildpack.FileExists(filepath.Join(s.Stager.DepDir(), name))
+		s.restored[name] = hit
+		if err == nil {
+			err = report.RecordCacheHit(s.Stager.DepDir(), name, hit)
+		}
+		if err != nil {
+			s.Log.Debug("Unable to record the cache hit of %s: %v", name, err)
+		}
+	}
+}
+
+func (s *Supplier) recordRubygems(version string) error {
+	return report.Update(s.Stager.DepDir(), func(r *report.Report) {
+		r.Rubygems = version
+	})
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+type IndentedWriter struct {
+	w   io.Writer
+	pad string
+}
+
+func (w *IndentedWriter) Write(p []byte) (n int, err error) {
+	lines := strings.Split(string(p), "\n")
+	for i, line := range lines {
+		lines[i] = w.pad + line
+	}
+	p = []byte(strings.Join(lines, "\n"))
+	return w.Write(p)
+}
+
+type LinuxTempDir struct {
+	Log       *libbuildpack.Logger
+	Workspace *workspace.Workspace
+}
+
+func (t *LinuxTempDir) CopyDirToTemp(dir string) (string, error) {
+	tempDir, err := t.Workspace.Dir("app")
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("cp", "-al", dir, tempDir)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Log.Error(string(output))
+		return "", fmt.Errorf("Could not copy build dir to temp: %v", err)
+	}
+	tempDir = filepath.Join(tempDir, filepath.Base(dir))
+	return tempDir, nil
+}
+
+func (s *Supplier) prebuiltGemCache(engine, rubyVersion string) (*prebuilt.Cache, string, error) {
+	url := s.Flags.String("BP_PREBUILT_GEM_CACHE")
+	// The gems of a developer ruby are not shared, its ABI may change with
+	// every build
+	if url == "" || engine != "ruby" || isDeveloperRuby(rubyVersion) || !s.appHasGemfileLock {
+		return nil, "", nil
+	}
+
+	rubyEngineVersion, err := s.Versions.RubyEngineVersion()
+	if err != nil {
+		return nil, "", err
+	}
+	bundleDir := s.bundlePath().Dir(engine, rubyEngineVersion)
+
+	key, err := prebuilt.LoadKey(filepath.Join(s.Manifest.RootDir(), prebuilt.KeyFile))
+	if err != nil {
+		return nil, "", err
+	}
+	cache := prebuilt.New(url, os.Getenv("CF_STACK"), engine+"-"+rubyVersion, key, s.Log)
+	cache.Token = s.Flags.String("BP_PREBUILT_GEM_CACHE_TOKEN")
+	cache.Workspace = s.Workspace
+	return cache, bundleDir, nil
+}
+
+var nativeExtensionFailure = regexp.MustCompile(`An error occurred while installing (\S+) \(([^)]+)\), and Bundler cannot continue`)
+
+// failedNativeExtension returns the gem whose native extension failed to
+// compile in the output of bundle install
+func failedNativeExtension(output string) (prebuilt.Gem, bool) {
+	if !strings.Contains(output, "Failed to build gem native extension") {
+		return prebuilt.Gem{}, false
+	}
+	m := nativeExtensionFailure.FindStringSubmatch(output)
+	if m == nil {
+		return prebuilt.Gem{}, false
+	}
+	return prebuilt.Gem{Name: m[1], Version: m[2]}, true
+}
+
+// fetchFailure matches the errors bundler reports when a gem source could not
+// be reached or did not serve a gem
+var fetchFailure = regexp.MustCompile(`Gem::RemoteFetcher::FetchError|Bundler::HTTPError|Bundler::Fetcher::\w+Error|Could not fetch specs from|Could not reach host|Net::OpenTimeout|Net::ReadTimeout|Errno::ECONNREFUSED|Errno::ECONNRESET|SocketError`)
+
+// failedFetch reports whether bundle install failed to download from its
+// gem sources, the only failure fallback sources can help with. A gem which
+// does not compile or a Gemfile which does not resolve fails the same way
+// whichever source serves the gems.
+func failedFetch(output string) bool {
+	return fetchFailure.MatchString(output)
+}
+
+// retryNativeExtension installs a gem whose extension failed to compile on
+// its own, with a single make job and verbose output. Parallel builds of
+// extensions such as grpc and sassc race, and when the retry fails as well
+// the output shows why.
+func (s *Supplier) retryNativeExtension(gem prebuilt.Gem, tempDir string, env []string) error {
+	bundlePath := os.Getenv("BUNDLE_PATH")
+	if bundlePath == "" {
+		return fmt.Errorf("BUNDLE_PATH is not set")
+	}
+
+	s.Log.BeginStep("Compiling %s again with -j1", gem)
+	cmd := exec.Command("gem", "install", gem.Name, "--version", gem.Version, "--install-dir", bundlePath, "--ignore-dependencies", "--no-document", "--verbose")
+	cmd.Dir = tempDir
+	cmd.Stdout = text.NewIndentWriter(s.Log.Output(), []byte("       "))
+	cmd.Stderr = text.NewIndentWriter(s.Log.Output(), []byte("       "))
+	cmd.Env = append(env, "MAKEFLAGS=-j1")
+	return s.Command.Run(cmd)
+}
+
+// CheckDeprecatedFlags warns about buildpack flags set in a way which is
+// deprecated
+func (s *Supplier) CheckDeprecatedFlags() error {
+	if s.Flags.IsSet("BP_DEBUG") {
+		if debug, err := strconv.ParseBool(s.Flags.String("BP_DEBUG")); err != nil || !debug {
+			return s.Deprecations.Use("bp-debug-any-value")
+		}
+	}
+	return nil
+}
+
+// CheckManifestAge warns when the buildpack was packaged more than
+// BP_MANIFEST_MAX_AGE days ago, with the version lines the upstream releases
+// snapshot knows newer releases of. Like ReportOutdatedGems it never fails
+// staging.
+func (s *Supplier) CheckManifestAge() {
+	maxAge := s.Flags.Days("BP_MANIFEST_MAX_AGE")
+	if maxAge == 0 {
+		return
+	}
+
+	built, ok, err := freshness.BuildDate(s.Manifest.RootDir())
+	if err != nil {
+		s.Log.Warning("Unable to check the age of the buildpack: %s", err.Error())
+		return
+	} else if !ok {
+		s.Log.Debug("The buildpack manifest has no build_date, skipping the age check")
+		return
+	}
+	age := int(time.Since(built).Hours() / 24)
+	if age <= maxAge {
+		return
+	}
+
+	s.Log.Warning("This buildpack was packaged on %s, %d days ago, and may install rubies without recent security fixes. Upgrade to a newer release of the ruby buildpack.", built.Format("2006-01-02"), age)
+	releases, err := freshness.LoadReleases(filepath.Join(s.Manifest.RootDir(), freshness.ReleasesFile))
+	if err != nil {
+		s.Log.Warning("Unable to list newer upstream releases: %s", err.Error())
+		return
+	} else if releases == nil {
+		return
+	}
+	if lines := releases.Behind(s.Manifest.AllDependencyVersions); len(lines) > 0 {
+		s.Log.Info("Newer releases known as of %s:", releases.Date)
+		for _, line := range lines {
+			s.Log.Info("  - %s", line)
+		}
+	}
+}
+
+// outdatedLimit is how many gems ReportOutdatedGems lists
+const outdatedLimit = 10
+
+// ReportOutdatedGems compares the Gemfile.lock with the gem index snapshot
+// shipped in the buildpack when BP_REPORT_OUTDATED is set. It is only a
+// nudge, a snapshot which can not be read does not fail staging.
+func (s *Supplier) ReportOutdatedGems() {
+	if !s.Flags.Bool("BP_REPORT_OUTDATED") || !s.appHasGemfileLock {
+		return
+	}
+
+	index, err := outdated.LoadIndex(filepath.Join(s.Manifest.RootDir(), outdated.IndexFile))
+	if err != nil {
+		s.Log.Warning("Unable to check for outdated gems: %s", err.Error())
+		return
+	} else if index == nil {
+		s.Log.Warning("Unable to check for outdated gems, the buildpack does not include %s", outdated.IndexFile)
+		return
+	}
+	lock, err := lockfile.ParseInstallable(s.Versions.Gemfile() + ".lock")
+	if err != nil {
+		s.Log.Warning("Unable to check for outdated gems: %s", err.Error())
+		return
+	}
+
+	s.Log.BeginStep("Checking for outdated gems against the gem index of %s", index.Date)
+	gems := outdated.Compare(lock, index)
+	if len(gems) == 0 {
+		s.Log.Info("The locked gems are up to date")
+		return
+	}
+
+	vulnerable := 0
+	for _, gem := range gems {
+		if len(gem.Advisories) > 0 {
+			vulnerable++
+		}
+	}
+	s.Log.Info("Locked gems behind the newest release: %d, with known advisories: %d", len(gems), vulnerable)
+	outdated.Print(text.NewIndentWriter(s.Log.Output(), []byte("       ")), gems, outdatedLimit)
+	if len(gems) > outdatedLimit {
+		s.Log.Info("and %d more, run bundle outdated for the full list", len(gems)-outdatedLimit)
+	}
+}
+
+// CheckSystemLibraries makes sure the rootfs has the libraries the locked
+// native gems compile against, gems bundler will not compile again because
+// they are already installed are left out
+func (s *Supplier) CheckSystemLibraries() error {
+	if !s.Flags.Bool("BP_SYSTEM_LIBRARY_CHECK") || !s.appHasGemfileLock {
+		return nil
+	}
+
+	gems, err := prebuilt.LockedGems(s.Versions.Gemfile() + ".lock")
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, gem := range gems {
+		if bundlePath := os.Getenv("BUNDLE_PATH"); bundlePath != "" {
+			if exists, err := libbuildpack.FileExists(filepath.Join(bundlePath, "specifications", gem.String()+".gemspec")); err != nil {
+				return err
+			} else if exists {
+				continue
+			}
+		}
+		names = append(names, gem.Name)
+	}
 
-	if err := s.Installer.InstallDependency(libbuildpack.Dependency{Name: name, Version: version}, installDir); err != nil {
-		return err
+	missing := syslibs.New(os.Environ()).Check(names)
+	if len(missing) == 0 {
+		return nil
 	}
+	var lines, libraries []string
+	for _, m := range missing {
+		lines = append(lines, "  - "+m.String())
+		libraries = append(libraries, m.Library.Name)
+	}
+	s.Log.Error("Missing system libraries:\n%s\nInstall them with the apt buildpack before this one, or use a stack which includes them.", strings.Join(lines, "\n"))
+	return fmt.Errorf("missing system libraries: %s", strings.Join(libraries, ", "))
+}
 
-	if err := s.RewriteShebangs(); err != nil {
-		return err
+// PrepareGitGems points bundler's git cache at the clones kept in the app
+// cache and fetches the locked commit of each git source, so bundler does not
+// clone them again. Like the prebuilt gem cache this only speeds staging up,
+// failing to fetch a source only warns.
+func (s *Supplier) PrepareGitGems(engine string) error {
+	if !s.appHasGemfileLock {
+		return nil
 	}
 
-	if err := os.Symlink("ruby", filepath.Join(s.Stager.DepDir(), "ruby", "bin", "ruby.exe")); err != nil {
+	sources, err := gitcache.Sources(s.Versions.Gemfile() + ".lock")
+	if err != nil {
 		return err
 	}
-	return s.Stager.LinkDirectoryInDepDir(filepath.Join(s.Stager.DepDir(), "ruby", "bin"), "bin")
-}
+	if len(sources) == 0 {
+		return os.RemoveAll(s.deps().Join(depsdir.BundlerGit))
+	}
 
-func (s *Supplier) RewriteShebangs() error {
-	files1, err := filepath.Glob(filepath.Join(s.Stager.DepDir(), "bin", "*"))
+	defer s.recordComponent("git_gems", time.Now(), s.restored[gitcache.Dir])
+
+	rubyEngineVersion, err := s.Versions.RubyEngineVersion()
 	if err != nil {
 		return err
 	}
-	files2, err := filepath.Glob(filepath.Join(s.Stager.DepDir(), "vendor_bundle", "ruby", "*", "bin", "*"))
-	if err != nil {
+	cache := gitcache.New(s.Stager.DepDir(), s.Command, s.Log)
+	if err := cache.Link(s.bundlePath().Dir(engine, rubyEngineVersion)); err != nil {
 		return err
 	}
 
-	for _, file := range append(files1, files2...) {
-		if fileInfo, err := os.Stat(file); err != nil {
-			return err
-		} else if fileInfo.IsDir() {
-			continue
-		}
-		fileContents, err := ioutil.ReadFile(file)
-		if err != nil {
-			return err
-		}
-		shebangRegex := regexp.MustCompile(`^#!/.*/ruby.*`)
-		fileContents = shebangRegex.ReplaceAll(fileContents, []byte("#!/usr/bin/env ruby"))
-		if err := ioutil.WriteFile(file, fileContents, 0755); err != nil {
-			return err
+	s.Log.BeginStep("Preparing git gems")
+	for _, source := range sources {
+		if err := cache.Fetch(source); err != nil {
+			s.Log.Warning("Unable to fetch %s, bundler will clone it: %s", source.Remote, err.Error())
 		}
 	}
 	return nil
 }
 
-func (s *Supplier) SymlinkBundlerIntoRubygems() error {
-	s.Log.Debug("SymlinkBundlerIntoRubygems")
+// MigrateDepDir brings the directories restored from a cache saved by an
+// older buildpack to the current cache.Version, which is then cached
+func (s *Supplier) MigrateDepDir() error {
+	return cache.MigrateDepDir(s.deps(), s.Cache.Metadata(), s.Log)
+}
 
-	rubyEngineVersion, err := s.Versions.RubyEngineVersion()
-	if err != nil {
-		return fmt.Errorf("Unable to determine ruby engine: %s", err)
-	}
-	versions := s.Manifest.AllDependencyVersions("bundler")
-	if len(versions) != 1 {
-		return fmt.Errorf("expect 1 version of bundler, found %d", len(versions))
+// VerifyGitGems checks bundler's checkouts of the git sources pinned by SHA
+// against the Gemfile.lock once the gems are installed. Rails engines from
+// git are loaded from these checkouts when the app boots, and a checkout
+// restored from the cache is not checked by bundler again. A difference
+// fails staging unless BP_STRICT_GIT_GEMS is turned off, then it only warns.
+func (s *Supplier) VerifyGitGems(engine string) error {
+	if !s.appHasGemfileLock {
+		return nil
 	}
-	bundlerVersion := versions[0]
 
-	destDir := filepath.Join(s.Stager.DepDir(), "ruby", "lib", "ruby", "gems", rubyEngineVersion, "gems")
-	if err := os.MkdirAll(destDir, 0755); err != nil {
+	sources, err := gitcache.Sources(s.Versions.Gemfile() + ".lock")
+	if err != nil || len(sources) == 0 {
 		return err
 	}
-	srcDir := filepath.Join(s.Stager.DepDir(), "bundler", "gems", "bundler-"+bundlerVersion)
-	relPath, err := filepath.Rel(destDir, srcDir)
+	rubyEngineVersion, err := s.Versions.RubyEngineVersion()
 	if err != nil {
 		return err
 	}
+	bundleDir := s.bundlePath().Dir(engine, rubyEngineVersion)
 
-	destFile := filepath.Join(destDir, "bundler-"+bundlerVersion)
-	if found, err := libbuildpack.FileExists(destFile); err != nil {
-		return err
-	} else if found {
-		s.Log.Debug("Skipping linking bundler since destination exists")
+	cache := gitcache.New(s.Stager.DepDir(), s.Command, s.Log)
+	var problems []string
+	for _, source := range sources {
+		if err := cache.Verify(source, bundleDir); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) == 0 {
+		s.Log.Info("Verified the git gems against their locked revisions")
 		return nil
 	}
-
-	return os.Symlink(relPath, destFile)
-}
-
-func (s *Supplier) UpdateRubygems() error {
-	dep := libbuildpack.Dependency{Name: "rubygems"}
-	versions := s.Manifest.AllDependencyVersions(dep.Name)
-	if len(versions) == 0 {
+	if !s.Flags.Bool("BP_STRICT_GIT_GEMS") {
+		for _, problem := range problems {
+			s.Log.Warning("Git gem mismatch: %s", problem)
+		}
 		return nil
-	} else if len(versions) > 1 {
-		return fmt.Errorf("Too many versions of rubygems in manifest")
 	}
-	dep.Version = versions[0]
-
-	currVersion, err := s.Command.Output("/", "gem", "--version")
-	if err != nil {
-		return fmt.Errorf("Could not determine current version of rubygems: %v", err)
+	for _, problem := range problems {
+		s.Log.Error("Git gem mismatch: %s", problem)
 	}
+	return fmt.Errorf("git gems differ from %s", filepath.Base(s.Versions.Gemfile()+".lock"))
+}
 
-	currVersion = strings.TrimSpace(currVersion)
-	if newer, err := s.Versions.VersionConstraint(currVersion, fmt.Sprintf(">= %s", dep.Version)); err != nil {
-		return fmt.Errorf("Could not parse rubygems version constraint: %s >= %s: %v", currVersion, dep.Version, err)
-	} else if newer {
+// InstallBundledPrebuiltGems installs the prebuilt gems of the gem bundle
+// the buildpack was packaged with, so neither BP_PREBUILT_GEM_CACHE nor
+// bundler need to provide them
+func (s *Supplier) InstallBundledPrebuiltGems(engine, rubyVersion string) error {
+	if s.GemBundle == nil || len(s.GemBundle.Index.Prebuilt) == 0 || engine != "ruby" || isDeveloperRuby(rubyVersion) || !s.appHasGemfileLock {
 		return nil
 	}
-
-	if engine, err := s.Versions.Engine(); err != nil {
+	rubyEngineVersion, err := s.Versions.RubyEngineVersion()
+	if err != nil {
 		return err
-	} else if engine == "jruby" {
-		s.Log.Debug("Skipping update of rubygems since jruby")
-		return nil
 	}
+	bundleDir := s.bundlePath().Dir(engine, rubyEngineVersion)
 
-	s.Log.BeginStep("Update rubygems from %s to %s", currVersion, dep.Version)
-
-	tempDir, err := ioutil.TempDir("", "rubygems")
+	gems, err := prebuilt.LockedGems(s.Versions.Gemfile() + ".lock")
 	if err != nil {
 		return err
 	}
+	for _, gem := range gems {
+		if exists, err := libbuildpack.FileExists(filepath.Join(bundleDir, "specifications", gem.String()+".gemspec")); err != nil {
+			return err
+		} else if exists {
+			continue
+		}
+		if found, err := s.GemBundle.InstallPrebuilt(os.Getenv("CF_STACK"), engine+"-"+rubyVersion, gem, bundleDir); err != nil {
+			s.Log.Warning("Unable to install prebuilt %s from the gem bundle: %s", gem, err.Error())
+		} else if found {
+			s.Log.Info("Using prebuilt %s from the gem bundle", gem)
+			s.preinstalledGems = append(s.preinstalledGems, gem)
+		}
+	}
+	return nil
+}
 
-	if err := s.Installer.InstallDependency(dep, tempDir); err != nil {
+// FetchPrebuiltGems installs gems from the operator's BP_PREBUILT_GEM_CACHE
+// before bundler runs, so bundler finds them installed and skips compiling.
+// Only the gems the index the operator signed lists are fetched. The cache
+// is an optimisation, failing to reach it or verify its index only warns.
+func (s *Supplier) FetchPrebuiltGems(engine, rubyVersion string) error {
+	cache, bundleDir, err := s.prebuiltGemCache(engine, rubyVersion)
+	if err != nil || cache == nil {
 		return err
 	}
+	s.recordEgress("prebuilt gem cache", cache.URL)
+	if _, err := cache.Index(); err != nil {
+		s.Log.Warning("Unable to use the prebuilt gem cache: %s", err.Error())
+		return nil
+	}
 
-	if err := os.MkdirAll(filepath.Join(s.Stager.DepDir(), "gem_home"), 0755); err != nil {
+	gems, err := prebuilt.LockedGems(s.Versions.Gemfile() + ".lock")
+	if err != nil {
 		return err
 	}
 
-	rubygemsDir := filepath.Join(tempDir, fmt.Sprintf("rubygems-%s", dep.Version))
-	if output, err := s.Command.Output(rubygemsDir, "ruby", "setup.rb"); err != nil {
-		s.Log.Error(output)
-		return fmt.Errorf("Could not install rubygems: %v", err)
-	}
+	s.Log.BeginStep("Fetching prebuilt gems")
+	for _, gem := range gems {
+		if exists, err := libbuildpack.FileExists(filepath.Join(bundleDir, "specifications", gem.String()+".gemspec")); err != nil {
+			return err
+		} else if exists {
+			s.preinstalledGems = append(s.preinstalledGems, gem)
+			continue
+		}
 
+		if found, err := cache.Fetch(gem, bundleDir); err != nil {
+			s.Log.Warning("Unable to fetch prebuilt %s: %s", gem, err.Error())
+		} else if found {
+			s.Log.Info("Using prebuilt %s", gem)
+			s.preinstalledGems = append(s.preinstalledGems, gem)
+		}
+	}
 	return nil
 }
 
-type IndentedWriter struct {
-	w   io.Writer
-	pad string
-}
-
-func (w *IndentedWriter) Write(p []byte) (n int, err error) {
-	lines := strings.Split(string(p), "\n")
-	for i, line := range lines {
-		lines[i] = w.pad + line
+// StorePrebuiltGems uploads the native gems bundler compiled during this
+// staging to BP_PREBUILT_GEM_CACHE when BP_PREBUILT_GEM_CACHE_UPLOAD is set,
+// gems restored from the app cache or fetched prebuilt are not uploaded
+// again. Other apps only use them once the operator signs them into the
+// index.
+func (s *Supplier) StorePrebuiltGems(engine, rubyVersion string) error {
+	if !s.Flags.Bool("BP_PREBUILT_GEM_CACHE_UPLOAD") {
+		return nil
+	}
+	cache, bundleDir, err := s.prebuiltGemCache(engine, rubyVersion)
+	if err != nil || cache == nil {
+		return err
+	}
+	if cache.Token == "" {
+		s.Log.Warning("Not uploading to the prebuilt gem cache, BP_PREBUILT_GEM_CACHE_UPLOAD needs BP_PREBUILT_GEM_CACHE_TOKEN")
+		return nil
 	}
-	p = []byte(strings.Join(lines, "\n"))
-	return w.Write(p)
-}
-
-type LinuxTempDir struct {
-	Log *libbuildpack.Logger
-}
 
-func (t *LinuxTempDir) CopyDirToTemp(dir string) (string, error) {
-	tempDir, err := ioutil.TempDir("", "app")
+	gems, err := prebuilt.NativeGems(bundleDir)
 	if err != nil {
-		return "", err
+		return err
 	}
-	cmd := exec.Command("cp", "-al", dir, tempDir)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		t.Log.Error(string(output))
-		return "", fmt.Errorf("Could not copy build dir to temp: %v", err)
+
+	preinstalled := map[prebuilt.Gem]bool{}
+	for _, gem := range s.preinstalledGems {
+		preinstalled[gem] = true
 	}
-	tempDir = filepath.Join(tempDir, filepath.Base(dir))
-	return tempDir, nil
+
+	for _, gem := range gems {
+		if preinstalled[gem] {
+			continue
+		}
+		if err := cache.Store(gem, bundleDir); err != nil {
+			s.Log.Warning("Unable to store prebuilt %s: %s", gem, err.Error())
+		} else {
+			s.Log.Info("Stored prebuilt %s", gem)
+		}
+	}
+	return nil
 }
 
 func (s *Supplier) InstallGems() error {
@@ -548,6 +1617,14 @@ func (s *Supplier) InstallGems() error {
 	s.warnBundleConfig()
 	s.warnWindowsGemfile()
 
+	// A vendor_bundle restored from the cache is unused when BP_BUNDLE_PATH
+	// moves the gems, it would only add to the droplet
+	if !s.bundlePath().IsDefault(s.deps()) {
+		if err := s.fs().RemoveAll(s.deps().VendorBundle()); err != nil {
+			return err
+		}
+	}
+
 	tempDir, err := s.TempDir.CopyDirToTemp(s.Stager.BuildDir())
 	if err != nil {
 		return nil
@@ -568,6 +1645,8 @@ func (s *Supplier) InstallGems() error {
 		}
 	}
 
+	s.copyBundledGems(tempDir, gemfileLock)
+
 	// Remove .bundle/config && copy if exists
 	if exists, err := libbuildpack.FileExists(filepath.Join(tempDir, ".bundle", "config")); err != nil {
 		return err
@@ -576,28 +1655,67 @@ func (s *Supplier) InstallGems() error {
 		libbuildpack.CopyFile(filepath.Join(s.Stager.BuildDir(), ".bundle", "config"), filepath.Join(tempDir, ".bundle", "config"))
 	}
 
-	args := []string{"install", "--without", os.Getenv("BUNDLE_WITHOUT"), "--jobs=4", "--retry=4", "--path", filepath.Join(s.Stager.DepDir(), "vendor_bundle"), "--binstubs", filepath.Join(s.Stager.DepDir(), "binstubs")}
+	args := []string{"install", "--without", os.Getenv("BUNDLE_WITHOUT"), "--jobs=4", "--retry=4", "--path", s.bundlePath().Root, "--binstubs", s.deps().Binstubs()}
+	if with := os.Getenv("BUNDLE_WITH"); with != "" {
+		args = append(args, "--with", with)
+	}
 	if exists, err := libbuildpack.FileExists(gemfileLock); err != nil {
 		return err
 	} else if exists {
 		args = append(args, "--deployment")
 	}
 
+	env := os.Environ()
+	env = append(env, "NOKOGIRI_USE_SYSTEM_LIBRARIES=true")
+	if env, err = s.checkPlatformGems(tempDir, gemfileLock, env); err != nil {
+		return err
+	}
+
+	if err := s.InstallBundlerPlugins(tempDir, env); err != nil {
+		return err
+	}
+
 	version := s.Manifest.AllDependencyVersions("bundler")
 	s.Log.BeginStep("Installing dependencies using bundler %s", version[0])
 	s.Log.Info("Running: bundle %s", strings.Join(args, " "))
 
-	env := os.Environ()
-	env = append(env, "NOKOGIRI_USE_SYSTEM_LIBRARIES=true")
-
-	cmd := exec.Command("bundle", args...)
-	cmd.Dir = tempDir
-	cmd.Stdout = text.NewIndentWriter(os.Stdout, []byte("       "))
-	cmd.Stderr = text.NewIndentWriter(os.Stderr, []byte("       "))
-	cmd.Env = env
-	if err := s.Command.Run(cmd); err != nil {
+	detector := &diskspace.Detector{}
+	output := &bytes.Buffer{}
+	bundleInstall := func() error {
+		output.Reset()
+		cmd := exec.Command("bundle", args...)
+		cmd.Dir = tempDir
+		cmd.Stdout = io.MultiWriter(text.NewIndentWriter(s.Log.Output(), []byte("       ")), detector, output)
+		cmd.Stderr = io.MultiWriter(text.NewIndentWriter(s.Log.Output(), []byte("       ")), detector, output)
+		cmd.Env = env
+		err := s.Command.Run(cmd)
+		if err != nil && detector.Exhausted {
+			s.reportDiskExhausted()
+			return fmt.Errorf("Ran out of disk space while installing gems: %v", err)
+		}
+		if err != nil {
+			s.explainConflicts(output.String())
+		}
 		return err
 	}
+	if err := bundleInstall(); err != nil {
+		if detector.Exhausted {
+			return err
+		}
+		if gem, found := failedNativeExtension(output.String()); found && s.Flags.Bool("BP_NATIVE_EXTENSION_RETRY") {
+			if err := s.retryNativeExtension(gem, tempDir, env); err != nil {
+				return fmt.Errorf("%s failed to compile again with -j1: %v", gem, err)
+			}
+		} else if !failedFetch(output.String()) {
+			return err
+		} else if fetched := s.fetchFallbackGems(tempDir, gemfileLock); fetched == 0 {
+			return err
+		}
+		s.Log.Info("Running: bundle %s", strings.Join(args, " "))
+		if err := bundleInstall(); err != nil {
+			return err
+		}
+	}
 
 	if err := s.regenerateBundlerBinStub(tempDir); err != nil {
 		return err
@@ -605,28 +1723,28 @@ func (s *Supplier) InstallGems() error {
 
 	s.Log.Info("Cleaning up the bundler cache.")
 
-	cmd = exec.Command("bundle", "clean")
+	cmd := exec.Command("bundle", "clean")
 	cmd.Dir = tempDir
-	cmd.Stdout = text.NewIndentWriter(os.Stdout, []byte("       "))
-	cmd.Stderr = text.NewIndentWriter(os.Stderr, []byte("       "))
+	cmd.Stdout = text.NewIndentWriter(s.Log.Output(), []byte("       "))
+	cmd.Stderr = text.NewIndentWriter(s.Log.Output(), []byte("       "))
 	cmd.Env = env
 	if err := s.Command.Run(cmd); err != nil {
 		return err
 	}
 
 	// Copy binstubs to bin
-	files, err := ioutil.ReadDir(filepath.Join(s.Stager.DepDir(), "binstubs"))
+	files, err := ioutil.ReadDir(s.deps().Binstubs())
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return fmt.Errorf("Could not read dep/binstubs directory: %v", err)
 		}
 	} else {
 		for _, file := range files {
-			target := filepath.Join(s.Stager.DepDir(), "bin", file.Name())
+			target := s.deps().Join(depsdir.Bin, file.Name())
 			if exists, err := libbuildpack.FileExists(target); err != nil {
 				return fmt.Errorf("Checking existence: %v", err)
 			} else if !exists {
-				source := filepath.Join(s.Stager.DepDir(), "binstubs", file.Name())
+				source := s.deps().Join(depsdir.Binstubs, file.Name())
 				if err := libbuildpack.CopyFile(source, target); err != nil {
 					return fmt.Errorf("CopyFile: %v", err)
 				}
@@ -654,20 +1772,143 @@ func (s *Supplier) InstallGems() error {
 	return os.RemoveAll(tempDir)
 }
 
+// explainConflicts summarizes the version conflicts a failed bundle install
+// printed, below the explanation of bundler which can run to hundreds of
+// lines, and records them in the staging report
+func (s *Supplier) explainConflicts(output string) {
+	conflicts := resolution.Parse(output)
+	if len(conflicts) == 0 {
+		return
+	}
+	s.Log.Error("Bundler could not resolve the Gemfile")
+	for _, line := range strings.Split(resolution.Summary(conflicts), "\n") {
+		s.Log.Info("%s", line)
+	}
+	if data, err := json.Marshal(conflicts); err == nil {
+		s.Log.Info("Resolution conflict as JSON: %s", data)
+	}
+	if err := report.Update(s.Stager.DepDir(), func(r *report.Report) { r.Conflicts = conflicts }); err != nil {
+		s.Log.Warning("Unable to record the conflicts in the staging report: %s", err.Error())
+	}
+}
+
+// reportDiskExhausted explains a bundle install which ran out of disk space
+// or inodes, with what is using them, rather than leaving the user with the
+// ENOSPC stack trace of whichever gem happened to be installing
+func (s *Supplier) reportDiskExhausted() {
+	usage, err := diskspace.UsageOf(s.Stager.DepDir())
+	if err != nil {
+		s.Log.Error("The container ran out of disk space while installing gems")
+	} else {
+		if usage.InodesExhausted() {
+			s.Log.Error("The container ran out of inodes while installing gems, there are too many files rather than too many bytes")
+		} else {
+			s.Log.Error("The container ran out of disk space while installing gems")
+		}
+		s.Log.Info("Disk: %s free of %s", diskspace.HumanBytes(usage.FreeBytes), diskspace.HumanBytes(usage.Bytes))
+		if usage.Inodes > 0 {
+			s.Log.Info("Inodes: %d free of %d", usage.FreeInodes, usage.Inodes)
+		}
+	}
+
+	gemDirs, _ := s.bundlePath().Glob("gems")
+	for _, gemDir := range gemDirs {
+		largest, err := diskspace.Largest(gemDir, 5)
+		if err != nil || len(largest) == 0 {
+			continue
+		}
+		s.Log.Info("Installed gems with the most files:")
+		for _, entry := range largest {
+			s.Log.Info("  %s: %d files, %s", entry.Name, entry.Files, diskspace.HumanBytes(uint64(entry.Bytes)))
+		}
+	}
+
+	s.Log.Info("To stage with less space:")
+	s.Log.Info("  - set BUNDLE_WITHOUT to skip more groups, e.g. development:test (currently %q)", os.Getenv("BUNDLE_WITHOUT"))
+	s.Log.Info("  - remove unused gems, and vendor/cache or other large files with .cfignore")
+	s.Log.Info("  - push with a larger disk quota, e.g. cf push -k 2G")
+}
+
+// copyBundledGems puts the locked gems the gem bundle has in the app's
+// vendor/cache before bundle install, bundler installs them from there
+// rather than downloading them
+func (s *Supplier) copyBundledGems(appDir, gemfileLock string) {
+	if s.GemBundle == nil || len(s.GemBundle.Index.Gems) == 0 {
+		return
+	}
+	if exists, err := libbuildpack.FileExists(gemfileLock); err != nil || !exists {
+		return
+	}
+	gems, err := gemsource.LockedGems(gemfileLock)
+	if err != nil {
+		s.Log.Warning("Unable to read %s for the gem bundle: %s", filepath.Base(gemfileLock), err.Error())
+		return
+	}
+
+	copied, err := s.GemBundle.CopyGems(gems, filepath.Join(appDir, "vendor", "cache"))
+	if err != nil {
+		s.Log.Warning("Unable to use gems from the gem bundle: %s", err.Error())
+	}
+	if len(copied) > 0 {
+		s.Log.Info("Using %d gems from the gem bundle", len(copied))
+	}
+}
+
+// fetchFallbackGems downloads the locked gems bundler did not install from
+// the BP_GEM_FALLBACK_SOURCES into the app's vendor/cache, where bundler
+// looks before going to the gem source, and returns how many it fetched.
+// Each gem is fetched on its own so one flaky source does not fail the rest.
+func (s *Supplier) fetchFallbackGems(appDir, gemfileLock string) int {
+	fallback := gemsource.New(s.Flags.String("BP_GEM_FALLBACK_SOURCES"))
+	if len(fallback.Sources) == 0 {
+		return 0
+	}
+	if exists, err := libbuildpack.FileExists(gemfileLock); err != nil || !exists {
+		return 0
+	}
+	gems, err := gemsource.LockedGems(gemfileLock)
+	if err != nil {
+		s.Log.Warning("Unable to read %s for fallback gem sources: %s", filepath.Base(gemfileLock), err.Error())
+		return 0
+	}
+
+	s.Log.BeginStep("Fetching gems bundler could not install from fallback sources")
+	s.recordEgress("gem fallback source", fallback.Sources...)
+	cacheDir := filepath.Join(appDir, "vendor", "cache")
+	fetched := 0
+	for _, gem := range gems {
+		if installed, err := s.bundlePath().Glob("specifications", gem.String()+".gemspec"); err == nil && len(installed) > 0 {
+			continue
+		}
+		if exists, err := libbuildpack.FileExists(filepath.Join(cacheDir, gem.String()+".gem")); err == nil && exists {
+			continue
+		}
+
+		source, err := fallback.Fetch(gem, cacheDir)
+		if err != nil {
+			s.Log.Warning("Unable to fetch %s: %s", gem, err.Error())
+			continue
+		}
+		s.Log.Info("Fetched %s from %s", gem, source)
+		fetched++
+	}
+	return fetched
+}
+
 func (s *Supplier) regenerateBundlerBinStub(appDir string) error {
 	s.Log.BeginStep("Regenerating bundler binstubs...")
-	cmd := exec.Command("bundle", "binstubs", "bundler", "--force", "--path", filepath.Join(s.Stager.DepDir(), "binstubs"))
+	cmd := exec.Command("bundle", "binstubs", "bundler", "--force", "--path", s.deps().Binstubs())
 	cmd.Dir = appDir
-	cmd.Stdout = text.NewIndentWriter(os.Stdout, []byte("       "))
-	cmd.Stderr = text.NewIndentWriter(os.Stderr, []byte("       "))
+	cmd.Stdout = text.NewIndentWriter(s.Log.Output(), []byte("       "))
+	cmd.Stderr = text.NewIndentWriter(s.Log.Output(), []byte("       "))
 	if err := s.Command.Run(cmd); err != nil {
 		return err
 	}
-	return libbuildpack.CopyFile(filepath.Join(s.Stager.DepDir(), "binstubs", "bundle"), filepath.Join(s.Stager.DepDir(), "bin", "bundle"))
+	return libbuildpack.CopyFile(s.deps().Join(depsdir.Binstubs, "bundle"), s.deps().Join(depsdir.Bin, "bundle"))
 }
 
 func (s *Supplier) EnableLDLibraryPathEnv() error {
-	if exists, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), "ld_library_path")); err != nil {
+	if exists, err := filesystem.Exists(s.fs(), filepath.Join(s.Stager.BuildDir(), "ld_library_path")); err != nil {
 		return err
 	} else if !exists {
 		return nil
@@ -690,23 +1931,104 @@ func (s *Supplier) EnableLDLibraryPathEnv() error {
 	return s.Stager.WriteProfileD("app_lib_path.sh", scriptContents)
 }
 
+var utf8Locale = regexp.MustCompile(`(?i)\.utf-?8(@|$)`)
+
+// bundleWithout is the BUNDLE_WITHOUT staging sets when the app has none,
+// development and test followed by the groups the install profile leaves
+// out. A group the profile installs with its with list is not left out,
+// bundler refuses a group in both lists.
+func (s *Supplier) bundleWithout() string {
+	without := []string{"development", "test"}
+	profile := s.Config.Profile
+	if profile == nil {
+		return strings.Join(without, ":")
+	}
+	with := map[string]bool{}
+	for _, group := range profile.With {
+		with[group] = true
+	}
+	seen := map[string]bool{}
+	var groups []string
+	for _, group := range append(without, profile.Without...) {
+		if !seen[group] && !with[group] {
+			seen[group] = true
+			groups = append(groups, group)
+		}
+	}
+	return strings.Join(groups, ":")
+}
+
+// skippedGroups are the Gemfile groups bundle install leaves out
+func (s *Supplier) skippedGroups() map[string]bool {
+	without := os.Getenv("BUNDLE_WITHOUT")
+	if without == "" {
+		without = s.bundleWithout()
+	}
+	skipped := map[string]bool{}
+	for _, group := range strings.FieldsFunc(without, func(r rune) bool { return r == ':' || r == ' ' }) {
+		skipped[group] = true
+	}
+	return skipped
+}
+
+// installedGroups reports whether bundle install installs a gem the groups
+// bring in, gems no group reaches are installed
+func installedGroups(groups []string, skipped map[string]bool) bool {
+	if len(groups) == 0 {
+		return true
+	}
+	for _, group := range groups {
+		if !skipped[group] {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Supplier) CreateDefaultEnv() error {
 	environmentDefaults := map[string]string{
 		"RAILS_ENV":      "production",
 		"RACK_ENV":       "production",
 		"RAILS_GROUPS":   "assets",
-		"BUNDLE_WITHOUT": "development:test",
+		"BUNDLE_WITHOUT": s.bundleWithout(),
 		"BUNDLE_GEMFILE": "Gemfile",
-		"BUNDLE_BIN":     filepath.Join(s.Stager.DepDir(), "binstubs"),
+		"BUNDLE_BIN":     s.deps().Binstubs(),
 		"BUNDLE_CONFIG":  filepath.Join(s.Stager.DepDir(), "bundle_config"),
-		"GEM_HOME":       filepath.Join(s.Stager.DepDir(), "gem_home"),
+		"GEM_HOME":       s.deps().GemHome(),
 		"GEM_PATH": strings.Join([]string{
-			filepath.Join(s.Stager.DepDir(), "gem_home"),
-			filepath.Join(s.Stager.DepDir(), "bundler"),
+			s.deps().GemHome(),
+			s.deps().Bundler(),
 		}, ":"),
 	}
+	if profile := s.Config.Profile; profile != nil {
+		s.Log.BeginStep("Using install profile %s from %s", profile.Name, config.Path)
+		if len(profile.With) > 0 {
+			environmentDefaults["BUNDLE_WITH"] = strings.Join(profile.With, ":")
+		}
+		if s.Config.WithoutGroup("assets") {
+			delete(environmentDefaults, "RAILS_GROUPS")
+		}
+	}
+	if s.Flags.Bool("BP_REPRODUCIBLE") {
+		// Lets rubygems and native builds embed a fixed time instead of now
+		environmentDefaults["SOURCE_DATE_EPOCH"] = "315532800"
+	}
 
-	return s.writeEnvFiles(environmentDefaults, false)
+	// Ruby reads files and command output in the encoding of the locale,
+	// which is US-ASCII when none is set. LC_ALL follows a LANG the app set.
+	environmentDefaults["LANG"] = s.Flags.String("BP_LOCALE")
+	environmentDefaults["LC_ALL"] = environmentDefaults["LANG"]
+	if lang := os.Getenv("LANG"); lang != "" {
+		environmentDefaults["LC_ALL"] = lang
+	}
+
+	if err := s.writeEnvFiles(environmentDefaults, false); err != nil {
+		return err
+	}
+	if locale := os.Getenv("LC_ALL"); !utf8Locale.MatchString(locale) {
+		s.Log.Warning("The locale is %s, ruby reads files and command output as US-ASCII unless it is a UTF-8 locale such as C.UTF-8", locale)
+	}
+	return nil
 }
 
 func (s *Supplier) AddPostRubyInstallDefaultEnv(engine string) error {
@@ -716,17 +2038,46 @@ func (s *Supplier) AddPostRubyInstallDefaultEnv(engine string) error {
 		return err
 	}
 	environmentDefaults := map[string]string{
-		"BUNDLE_PATH": filepath.Join(s.Stager.DepDir(), "vendor_bundle", engine, rubyEngineVersion),
+		"BUNDLE_PATH": s.bundlePath().Dir(engine, rubyEngineVersion),
 		"GEM_PATH": strings.Join([]string{
-			filepath.Join(s.Stager.DepDir(), "vendor_bundle", engine, rubyEngineVersion),
-			filepath.Join(s.Stager.DepDir(), "gem_home"),
-			filepath.Join(s.Stager.DepDir(), "bundler"),
+			s.bundlePath().Dir(engine, rubyEngineVersion),
+			s.deps().GemHome(),
+			s.deps().Bundler(),
 		}, ":"),
 	}
 	s.Log.Debug("Setting post ruby install env: %v", environmentDefaults)
 	return s.writeEnvFiles(environmentDefaults, true)
 }
 
+// ExportRubyABI tells later buildpacks and sidecars which ruby ABI and
+// platform to compile extensions for, without running ruby themselves. They
+// are exported as RUBY_ABI_VERSION and RUBY_PLATFORM for the rest of staging
+// and at runtime, and kept for the config of config.yml.
+func (s *Supplier) ExportRubyABI(engine string) error {
+	abi, err := s.Versions.RubyEngineVersion()
+	if err != nil {
+		return err
+	}
+	platform, err := s.Versions.RubyPlatform()
+	if err != nil {
+		return err
+	}
+
+	s.rubyABI = map[string]string{"ruby_engine": engine, "ruby_abi_version": abi, "ruby_platform": platform}
+	if err := s.writeEnvFiles(map[string]string{"RUBY_ABI_VERSION": abi, "RUBY_PLATFORM": platform}, true); err != nil {
+		return err
+	}
+	return profiled.New().
+		AddEnv("RUBY_ABI_VERSION", profiled.Literal(abi)).
+		AddEnv("RUBY_PLATFORM", profiled.Literal(platform)).
+		Write(s.Stager.DepDir(), "ruby_abi.sh")
+}
+
+// ConfigYml is the config supply leaves in the config.yml of its dep dir
+func (s *Supplier) ConfigYml() map[string]string {
+	return s.rubyABI
+}
+
 func (s *Supplier) writeEnvFiles(environment map[string]string, clobber bool) error {
 	for envVar, envDefault := range environment {
 		if os.Getenv(envVar) == "" || clobber {
@@ -747,23 +2098,40 @@ func (s *Supplier) WriteProfileD(engine string) error {
 		return err
 	}
 
-	depsIdx := s.Stager.DepsIdx()
+	// The app runs with the Gemfile it was staged with, even when
+	// BUNDLE_GEMFILE was only set for staging
+	gemfile, err := filepath.Rel(s.Stager.BuildDir(), s.Versions.Gemfile())
+	if err != nil {
+		return err
+	}
+
+	if err := profiled.New().
+		AddEnvDefault("LANG", profiled.Literal(s.Flags.String("BP_LOCALE"))).
+		AddEnvDefault("LC_ALL", profiled.Expand("$LANG")).
+		Write(s.Stager.DepDir(), "locale.sh"); err != nil {
+		return err
+	}
+
+	runtime := depsdir.Runtime(s.Stager.DepsIdx())
 	scriptContents := fmt.Sprintf(`
-export LANG=${LANG:-en_US.UTF-8}
 export RAILS_ENV=${RAILS_ENV:-production}
 export RACK_ENV=${RACK_ENV:-production}
 export RAILS_SERVE_STATIC_FILES=${RAILS_SERVE_STATIC_FILES:-enabled}
 export RAILS_LOG_TO_STDOUT=${RAILS_LOG_TO_STDOUT:-enabled}
-export BUNDLE_GEMFILE=${BUNDLE_GEMFILE:-$HOME/Gemfile}
+export BUNDLE_GEMFILE=${BUNDLE_GEMFILE:-$HOME/%s}
 
-export GEM_HOME=${GEM_HOME:-$DEPS_DIR/%s/gem_home}
-export GEM_PATH=${GEM_PATH:-$DEPS_DIR/%s/vendor_bundle/%s/%s:$DEPS_DIR/%s/gem_home:$DEPS_DIR/%s/bundler}
-export BUNDLE_PATH=${BUNDLE_PATH:-$DEPS_DIR/%s/vendor_bundle/%s/%s}
+export GEM_HOME=${GEM_HOME:-%s}
+export GEM_PATH=${GEM_PATH:-%s:%s:%s}
+export BUNDLE_PATH=${BUNDLE_PATH:-%s}
 
 ## Change to current DEPS_DIR
-bundle config PATH "$DEPS_DIR/%s/vendor_bundle" > /dev/null
+bundle config PATH "%s" > /dev/null
 bundle config WITHOUT "%s" > /dev/null
-`, depsIdx, depsIdx, engine, rubyEngineVersion, depsIdx, depsIdx, depsIdx, engine, rubyEngineVersion, depsIdx, os.Getenv("BUNDLE_WITHOUT"))
+`, gemfile, runtime.GemHome(), s.bundlePath().RuntimeDir(engine, rubyEngineVersion), runtime.GemHome(), runtime.Bundler(), s.bundlePath().RuntimeDir(engine, rubyEngineVersion), s.bundlePath().Runtime, os.Getenv("BUNDLE_WITHOUT"))
+
+	if with := os.Getenv("BUNDLE_WITH"); with != "" {
+		scriptContents += fmt.Sprintf("bundle config WITH \"%s\" > /dev/null\n", with)
+	}
 
 	if s.appHasGemfile && s.appHasGemfileLock {
 		hasRails41, err := s.Versions.HasGemVersion("rails", ">=4.1.0.beta1")
@@ -786,10 +2154,25 @@ bundle config WITHOUT "%s" > /dev/null
 	return s.Stager.WriteProfileD("ruby.sh", scriptContents)
 }
 
+// CalcChecksum digests the app, leaving out .cloudfoundry and the paths
+// matched by .buildignore
 func (s *Supplier) CalcChecksum() (string, error) {
 	h := md5.New()
 	basepath := s.Stager.BuildDir()
-	err := filepath.Walk(basepath, func(path string, info os.FileInfo, err error) error {
+	ignore, err := buildignore.Load(basepath)
+	if err != nil {
+		return "", err
+	}
+	err = filepath.Walk(basepath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if relpath, err := filepath.Rel(basepath, path); err == nil && relpath != "." && ignore.Match(filepath.ToSlash(relpath), info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		if info.Mode().IsRegular() {
 			relpath, err := filepath.Rel(basepath, path)
 			if strings.HasPrefix(relpath, ".cloudfoundry/") {
@@ -818,15 +2201,15 @@ func (s *Supplier) CalcChecksum() (string, error) {
 }
 
 func (s *Supplier) warnWindowsGemfile() {
-	if body, err := ioutil.ReadFile(filepath.Join(s.Stager.BuildDir(), "Gemfile")); err == nil {
+	if body, err := s.fs().ReadFile(s.Versions.Gemfile()); err == nil {
 		if bytes.Contains(body, []byte("\r\n")) {
-			s.Log.Warning("Windows line endings detected in Gemfile. Your app may fail to stage. Please use UNIX line endings.")
+			s.Log.Warning("Windows line endings detected in %s. Your app may fail to stage. Please use UNIX line endings.", filepath.Base(s.Versions.Gemfile()))
 		}
 	}
 }
 
 func (s *Supplier) warnBundleConfig() {
-	if exists, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), ".bundle", "config")); err == nil && exists {
+	if exists, err := filesystem.Exists(s.fs(), filepath.Join(s.Stager.BuildDir(), ".bundle", "config")); err == nil && exists {
 		s.Log.Warning("You have the `.bundle/config` file checked into your repository\nIt contains local state like the location of the installed bundle\nas well as configured git local gems, and other settings that should\nnot be shared between multiple checkouts of a single repo. Please\nremove the `.bundle/` folder from your repo and add it to your `.gitignore` file.")
 	}
 }
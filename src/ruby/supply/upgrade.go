@@ -0,0 +1,112 @@
+package supply
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"ruby/config"
+	"ruby/installer"
+	"ruby/lockfile"
+	"ruby/problemgems"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// rubyDirective is the ruby declaration of a Gemfile, such as ruby '2.4.4'
+var rubyDirective = regexp.MustCompile(`(?m)^[ \t]*(ruby[ \t]+['"][^'"]+['"])`)
+
+// AdviseRubyUpgrade prints the steps to move the app to the newest ruby of
+// the newest supported version line when it stages with an older line: the
+// version to declare, the edit to make and the locked gems which need a
+// bump first. Like ReportOutdatedGems it never fails staging.
+func (s *Supplier) AdviseRubyUpgrade(engine, rubyVersion string) {
+	if engine != "ruby" {
+		return
+	}
+
+	lines, err := installer.LoadVersionLines(filepath.Join(s.Manifest.RootDir(), "manifest.yml"))
+	if err != nil {
+		s.Log.Warning("Unable to load the ruby version lines: %s", err.Error())
+		return
+	}
+	supported, ok := installer.SupportedLine(lines, "ruby")
+	if !ok {
+		return
+	}
+	target, err := libbuildpack.FindMatchingVersion(supported.Line, s.Manifest.AllDependencyVersions("ruby"))
+	if err != nil {
+		s.Log.Debug("The buildpack has no ruby %s to upgrade to", supported.Line)
+		return
+	}
+	if current, err := semver.NewVersion(rubyVersion); err != nil {
+		return
+	} else if newest, err := semver.NewVersion(target); err != nil || !current.LessThan(newest) {
+		return
+	} else if current.Major() == newest.Major() && current.Minor() == newest.Minor() {
+		return
+	}
+
+	var steps []string
+	if directive := s.gemfileRubyDirective(); directive != "" {
+		steps = append(steps, fmt.Sprintf("In %s, change %s to ruby '%s'", filepath.Base(s.Versions.Gemfile()), directive, target))
+	} else if s.Config.Ruby.Version != "" {
+		steps = append(steps, fmt.Sprintf("In %s, change ruby.version to %s", config.Path, target))
+	} else {
+		steps = append(steps, fmt.Sprintf("Add ruby '%s' to %s", target, filepath.Base(s.Versions.Gemfile())))
+	}
+
+	var bumps []problemgems.Gem
+	if s.appHasGemfileLock {
+		table, err := problemgems.LoadRubyUpgrade(filepath.Join(s.Manifest.RootDir(), "manifest.yml"))
+		if err != nil {
+			s.Log.Warning("Unable to load the gems which need a bump for ruby %s: %s", target, err.Error())
+		} else if bumps, err = problemgems.FindForUpgrade(s.Versions, rubyVersion, target, table); err != nil {
+			s.Log.Warning("Unable to check the gems which need a bump for ruby %s: %s", target, err.Error())
+		}
+	}
+	if len(bumps) > 0 {
+		var names []string
+		for _, gem := range bumps {
+			names = append(names, gem.Name)
+			steps = append(steps, fmt.Sprintf("The gem '%s' %s. %s", gem.Name, gem.Reason, gem.Migration))
+		}
+		steps = append(steps, fmt.Sprintf("Run bundle update --conservative %s", strings.Join(names, " ")))
+	}
+	if s.lockedRubyVersion() != "" {
+		steps = append(steps, "Run bundle update --ruby to update the RUBY VERSION of Gemfile.lock")
+	} else {
+		steps = append(steps, "Run bundle install and commit Gemfile.lock")
+	}
+
+	s.Log.BeginStep("Upgrading to ruby %s", target)
+	for i, step := range steps {
+		s.Log.Info("%d. %s", i+1, step)
+	}
+}
+
+// gemfileRubyDirective returns the ruby declaration of the Gemfile, or an
+// empty string when it does not declare one or can not be read
+func (s *Supplier) gemfileRubyDirective() string {
+	gemfile, err := s.fs().ReadFile(s.Versions.Gemfile())
+	if err != nil {
+		return ""
+	}
+	if match := rubyDirective.FindSubmatch(gemfile); match != nil {
+		return string(match[1])
+	}
+	return ""
+}
+
+// lockedRubyVersion returns the RUBY VERSION of the Gemfile.lock
+func (s *Supplier) lockedRubyVersion() string {
+	if !s.appHasGemfileLock {
+		return ""
+	}
+	lock, err := lockfile.ParseFile(s.Versions.Gemfile() + ".lock")
+	if err != nil {
+		return ""
+	}
+	return lock.RubyVersion
+}
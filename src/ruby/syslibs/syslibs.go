@@ -0,0 +1,142 @@
+// Package syslibs checks that the system libraries popular native gems
+// compile against are in the rootfs before bundler starts. Without it
+// bundler fails on the first gem whose library is missing, and each fix
+// costs the app another push to find the next one.
+package syslibs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Library is found when one of its Headers is in an include directory and
+// one of its Libs in a library directory, both may be globs
+type Library struct {
+	Name    string
+	Package string
+	Headers []string
+	Libs    []string
+}
+
+type Requirement struct {
+	Gem       string
+	Libraries []Library
+}
+
+var (
+	libpq = Library{
+		Name:    "libpq",
+		Package: "libpq-dev",
+		Headers: []string{"libpq-fe.h", "postgresql/libpq-fe.h"},
+		Libs:    []string{"libpq.so*"},
+	}
+	libmysqlclient = Library{
+		Name:    "libmariadb",
+		Package: "libmariadb-dev or libmysqlclient-dev",
+		Headers: []string{"mysql/mysql.h", "mariadb/mysql.h"},
+		Libs:    []string{"libmariadb.so*", "libmysqlclient.so*"},
+	}
+	libmagick = Library{
+		Name:    "libmagick",
+		Package: "libmagickwand-dev",
+		Headers: []string{"ImageMagick*/MagickCore/MagickCore.h", "ImageMagick*/magick/MagickCore.h"},
+		Libs:    []string{"libMagickCore*.so*"},
+	}
+	libsqlite3 = Library{
+		Name:    "libsqlite3",
+		Package: "libsqlite3-dev",
+		Headers: []string{"sqlite3.h"},
+		Libs:    []string{"libsqlite3.so*"},
+	}
+	libcurl = Library{
+		Name:    "libcurl",
+		Package: "libcurl4-openssl-dev",
+		Headers: []string{"curl/curl.h", "*/curl/curl.h"},
+		Libs:    []string{"libcurl.so*"},
+	}
+)
+
+// Known maps native gems to the libraries their extensions link against
+var Known = []Requirement{
+	{Gem: "pg", Libraries: []Library{libpq}},
+	{Gem: "mysql2", Libraries: []Library{libmysqlclient}},
+	{Gem: "rmagick", Libraries: []Library{libmagick}},
+	{Gem: "sqlite3", Libraries: []Library{libsqlite3}},
+	{Gem: "curb", Libraries: []Library{libcurl}},
+}
+
+var (
+	defaultIncludeDirs = []string{"/usr/include", "/usr/local/include", "/usr/include/x86_64-linux-gnu"}
+	defaultLibDirs     = []string{"/usr/lib", "/usr/local/lib", "/usr/lib/x86_64-linux-gnu", "/lib/x86_64-linux-gnu", "/usr/lib64"}
+)
+
+// Missing is a library a locked gem needs which is not in the rootfs
+type Missing struct {
+	Gem     string
+	Library Library
+}
+
+func (m Missing) String() string {
+	return fmt.Sprintf("%s needs %s, provided by %s", m.Gem, m.Library.Name, m.Library.Package)
+}
+
+type Checker struct {
+	IncludeDirs []string
+	LibDirs     []string
+}
+
+// New looks in the rootfs and in the directories on the compiler search
+// paths of environ, where the apt buildpack puts the libraries it installs
+func New(environ []string) *Checker {
+	c := &Checker{
+		IncludeDirs: append([]string{}, defaultIncludeDirs...),
+		LibDirs:     append([]string{}, defaultLibDirs...),
+	}
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			continue
+		}
+		switch parts[0] {
+		case "CPATH", "C_INCLUDE_PATH":
+			c.IncludeDirs = append(c.IncludeDirs, filepath.SplitList(parts[1])...)
+		case "LIBRARY_PATH", "LD_LIBRARY_PATH":
+			c.LibDirs = append(c.LibDirs, filepath.SplitList(parts[1])...)
+		}
+	}
+	return c
+}
+
+// Check returns every library missing for the locked gems, in the order
+// of Known
+func (c *Checker) Check(gems []string) []Missing {
+	locked := map[string]bool{}
+	for _, gem := range gems {
+		locked[gem] = true
+	}
+
+	var missing []Missing
+	for _, requirement := range Known {
+		if !locked[requirement.Gem] {
+			continue
+		}
+		for _, library := range requirement.Libraries {
+			if !found(c.IncludeDirs, library.Headers) || !found(c.LibDirs, library.Libs) {
+				missing = append(missing, Missing{Gem: requirement.Gem, Library: library})
+			}
+		}
+	}
+	return missing
+}
+
+func found(dirs, patterns []string) bool {
+	for _, dir := range dirs {
+		for _, pattern := range patterns {
+			if matches, _ := filepath.Glob(filepath.Join(dir, pattern)); len(matches) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
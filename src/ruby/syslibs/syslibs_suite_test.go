@@ -0,0 +1,13 @@
+package syslibs_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestSyslibs(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Syslibs Suite")
+}
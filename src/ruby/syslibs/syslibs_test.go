@@ -0,0 +1,65 @@
+package syslibs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/syslibs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Syslibs", func() {
+	var (
+		rootDir string
+		checker *syslibs.Checker
+	)
+
+	BeforeEach(func() {
+		var err error
+		rootDir, err = ioutil.TempDir("", "ruby-buildpack.syslibs.")
+		Expect(err).To(BeNil())
+		checker = &syslibs.Checker{
+			IncludeDirs: []string{filepath.Join(rootDir, "include")},
+			LibDirs:     []string{filepath.Join(rootDir, "lib")},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(rootDir)).To(Succeed())
+	})
+
+	touch := func(path string) {
+		Expect(os.MkdirAll(filepath.Dir(filepath.Join(rootDir, path)), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(rootDir, path), []byte{}, 0644)).To(Succeed())
+	}
+
+	It("finds the headers and libraries of locked gems", func() {
+		touch("include/postgresql/libpq-fe.h")
+		touch("lib/libpq.so.5")
+		touch("include/ImageMagick-6/magick/MagickCore.h")
+		touch("lib/libMagickCore-6.Q16.so")
+		Expect(checker.Check([]string{"pg", "rmagick", "rack"})).To(BeEmpty())
+	})
+
+	It("lists every missing library at once", func() {
+		touch("lib/libpq.so")
+		missing := checker.Check([]string{"mysql2", "rack", "pg"})
+		Expect(missing).To(HaveLen(2))
+		Expect(missing[0].String()).To(Equal("pg needs libpq, provided by libpq-dev"))
+		Expect(missing[1].String()).To(Equal("mysql2 needs libmariadb, provided by libmariadb-dev or libmysqlclient-dev"))
+	})
+
+	It("ignores gems which are not locked", func() {
+		Expect(checker.Check([]string{"rack"})).To(BeEmpty())
+	})
+
+	It("looks in the compiler search paths", func() {
+		checker = syslibs.New([]string{"CPATH=/deps/0/apt/usr/include", "LIBRARY_PATH=/deps/0/apt/usr/lib:/deps/1/lib", "LD_LIBRARY_PATH="})
+		Expect(checker.IncludeDirs).To(ContainElement("/deps/0/apt/usr/include"))
+		Expect(checker.LibDirs).To(ContainElement("/deps/0/apt/usr/lib"))
+		Expect(checker.LibDirs).To(ContainElement("/deps/1/lib"))
+		Expect(checker.LibDirs).ToNot(ContainElement(""))
+	})
+})
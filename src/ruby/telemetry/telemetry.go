@@ -0,0 +1,179 @@
+// Package telemetry lets an operator collect which rubies and gems the apps
+// on their platform stage with, so deprecations can be planned from usage.
+// Nothing is sent unless the operator packages the buildpack with
+// ConfigFile, and apps can still opt out. The payload only holds versions
+// and salted hashes of the app's direct gem dependencies; values which do
+// not look like a version or a stack name are dropped rather than sent.
+package telemetry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"ruby/lockfile"
+	"ruby/report"
+	"sort"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ConfigFile is where a custom packaged buildpack enables telemetry,
+// relative to the buildpack directory
+const ConfigFile = "telemetry.yml"
+
+// MaxGems limits how many gems are reported for a single app
+const MaxGems = 50
+
+const defaultTimeout = 5
+
+var safeValue = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z._-]{0,63}$`)
+
+type Config struct {
+	// Endpoint receives the payload as JSON in a POST request
+	Endpoint string `yaml:"endpoint"`
+	// Salt is mixed into the gem name hashes, so only the operator can
+	// match them against the names of gems they are interested in
+	Salt string `yaml:"salt"`
+	// Timeout is how many seconds the request may take
+	Timeout int `yaml:"timeout"`
+}
+
+// LoadConfig reads the configuration at path, nil is returned when the
+// operator did not enable telemetry
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("%s is invalid: %v", ConfigFile, err)
+	}
+	if u, err := url.Parse(config.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s is invalid: endpoint must be an http or https URL, got %q", ConfigFile, config.Endpoint)
+	}
+	if config.Salt == "" {
+		return nil, fmt.Errorf("%s is invalid: salt must be set", ConfigFile)
+	}
+	if config.Timeout < 0 {
+		return nil, fmt.Errorf("%s is invalid: timeout must be a number of seconds, got %d", ConfigFile, config.Timeout)
+	} else if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+	return config, nil
+}
+
+// Gem is a direct dependency of the app. Version is left out for gems
+// which do not come from a gem server, their versions are made up by the app.
+type Gem struct {
+	Hash    string `json:"hash"`
+	Version string `json:"version,omitempty"`
+}
+
+type Payload struct {
+	Buildpack string `json:"buildpack_version,omitempty"`
+	Stack     string `json:"stack,omitempty"`
+	Engine    string `json:"engine,omitempty"`
+	Ruby      string `json:"ruby,omitempty"`
+	Rubygems  string `json:"rubygems,omitempty"`
+	Bundler   string `json:"bundler,omitempty"`
+	Gems      []Gem  `json:"gems"`
+}
+
+// Build collects the payload from the staging report and Gemfile.lock
+func Build(r *report.Report, lock *lockfile.Lockfile, stack, buildpackVersion, salt string) *Payload {
+	p := &Payload{
+		Buildpack: safe(buildpackVersion),
+		Stack:     safe(stack),
+		Engine:    safe(r.Engine),
+		Ruby:      safe(r.Ruby),
+		Rubygems:  safe(r.Rubygems),
+		Bundler:   safe(lock.BundledWith),
+		Gems:      []Gem{},
+	}
+
+	for _, dep := range lock.Dependencies {
+		gem := Gem{Hash: hash(salt, dep.Name)}
+		if spec, found := lock.Spec(dep.Name); found && spec.Source != nil && spec.Source.Type == "GEM" {
+			gem.Version = safe(spec.Version)
+		}
+		p.Gems = append(p.Gems, gem)
+	}
+	sort.Slice(p.Gems, func(i, j int) bool { return p.Gems[i].Hash < p.Gems[j].Hash })
+	if len(p.Gems) > MaxGems {
+		p.Gems = p.Gems[:MaxGems]
+	}
+	return p
+}
+
+// Send posts the payload to the endpoint
+func Send(config *Config, p *Payload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: time.Duration(config.Timeout) * time.Second}
+	resp, err := client.Post(config.Endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Reporter sends the payload of a finished staging. Errors are logged
+// rather than returned, telemetry never fails staging.
+type Reporter struct {
+	Config           *Config
+	DepDir           string
+	GemfileLock      string
+	BuildpackVersion string
+	Log              *libbuildpack.Logger
+}
+
+func (r *Reporter) Report() {
+	staging, err := report.Load(r.DepDir)
+	if err != nil {
+		r.Log.Debug("Unable to load the staging report for telemetry: %v", err)
+		return
+	}
+	lock, err := lockfile.ParseInstallable(r.GemfileLock)
+	if err != nil {
+		r.Log.Debug("Unable to read %s for telemetry: %v", r.GemfileLock, err)
+		return
+	}
+
+	r.Log.BeginStep("Sending anonymized dependency usage to the operator, set BP_TELEMETRY_OPT_OUT=true to opt out")
+	if err := Send(r.Config, Build(staging, lock, os.Getenv("CF_STACK"), r.BuildpackVersion, r.Config.Salt)); err != nil {
+		r.Log.Debug("Unable to send telemetry: %v", err)
+	}
+}
+
+func hash(salt, name string) string {
+	sum := sha256.Sum256([]byte(salt + "\x00" + name))
+	return hex.EncodeToString(sum[:])
+}
+
+func safe(value string) string {
+	if safeValue.MatchString(value) {
+		return value
+	}
+	return ""
+}
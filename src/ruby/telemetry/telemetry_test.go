@@ -0,0 +1,182 @@
+package telemetry_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"ruby/lockfile"
+	"ruby/report"
+	"ruby/telemetry"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const gemfileLock = `GIT
+  remote: https://git.example.com/acme/billing.git
+  revision: 0123456789abcdef0123456789abcdef01234567
+  specs:
+    acme-billing (0.1.0)
+
+GEM
+  remote: https://rubygems.org/
+  specs:
+    rack (2.0.5)
+    rails (5.1.4)
+      rack (~> 2.0)
+
+PLATFORMS
+  ruby
+
+DEPENDENCIES
+  acme-billing!
+  rails (= 5.1.4)
+
+BUNDLED WITH
+   1.16.2
+`
+
+var _ = Describe("Telemetry", func() {
+	var (
+		dir  string
+		lock *lockfile.Lockfile
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ruby-buildpack.telemetry.")
+		Expect(err).To(BeNil())
+
+		lock, err = lockfile.Parse(strings.NewReader(gemfileLock))
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	Describe("LoadConfig", func() {
+		load := func(contents string) (*telemetry.Config, error) {
+			Expect(ioutil.WriteFile(filepath.Join(dir, telemetry.ConfigFile), []byte(contents), 0644)).To(Succeed())
+			return telemetry.LoadConfig(filepath.Join(dir, telemetry.ConfigFile))
+		}
+
+		It("is off without a config", func() {
+			config, err := telemetry.LoadConfig(filepath.Join(dir, telemetry.ConfigFile))
+			Expect(err).To(BeNil())
+			Expect(config).To(BeNil())
+		})
+
+		It("defaults the timeout", func() {
+			config, err := load("endpoint: https://usage.example.com/ruby\nsalt: s3cret\n")
+			Expect(err).To(BeNil())
+			Expect(config).To(Equal(&telemetry.Config{Endpoint: "https://usage.example.com/ruby", Salt: "s3cret", Timeout: 5}))
+		})
+
+		It("requires an http endpoint and a salt", func() {
+			_, err := load("endpoint: usage.example.com\nsalt: s3cret\n")
+			Expect(err).To(MatchError(`telemetry.yml is invalid: endpoint must be an http or https URL, got "usage.example.com"`))
+
+			_, err = load("endpoint: https://usage.example.com/ruby\n")
+			Expect(err).To(MatchError("telemetry.yml is invalid: salt must be set"))
+
+			_, err = load("endpoint: https://usage.example.com/ruby\nsalt: s3cret\nenabled: true\n")
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Describe("Build", func() {
+		It("hashes the direct dependencies and only keeps versions of rubygems sourced gems", func() {
+			p := telemetry.Build(&report.Report{Engine: "ruby", Ruby: "2.5.1", Rubygems: "2.7.7"}, lock, "cflinuxfs3", "1.7.22", "s3cret")
+			Expect(p.Buildpack).To(Equal("1.7.22"))
+			Expect(p.Stack).To(Equal("cflinuxfs3"))
+			Expect(p.Ruby).To(Equal("2.5.1"))
+			Expect(p.Bundler).To(Equal("1.16.2"))
+			Expect(p.Gems).To(HaveLen(2))
+
+			versions := map[string]string{}
+			for _, gem := range p.Gems {
+				Expect(gem.Hash).To(MatchRegexp(`^[0-9a-f]{64}$`))
+				versions[gem.Hash] = gem.Version
+			}
+			Expect(versions).To(ContainElement("5.1.4"))
+			Expect(versions).To(ContainElement(""))
+
+			data, err := json.Marshal(p)
+			Expect(err).To(BeNil())
+			Expect(string(data)).ToNot(ContainSubstring("rails"))
+			Expect(string(data)).ToNot(ContainSubstring("acme"))
+		})
+
+		It("gives different hashes for a different salt", func() {
+			a := telemetry.Build(&report.Report{}, lock, "", "", "one")
+			b := telemetry.Build(&report.Report{}, lock, "", "", "two")
+			Expect(a.Gems[0].Hash).ToNot(Equal(b.Gems[0].Hash))
+		})
+
+		It("drops values which do not look like versions", func() {
+			p := telemetry.Build(&report.Report{Ruby: "2.5.1 (built by jane@example.com)"}, lock, "cflinuxfs3\n", "1.7.22", "s3cret")
+			Expect(p.Ruby).To(BeEmpty())
+			Expect(p.Stack).To(BeEmpty())
+		})
+	})
+
+	Describe("Reporter", func() {
+		var (
+			buffer   *bytes.Buffer
+			received []byte
+			status   int
+			server   *httptest.Server
+			reporter *telemetry.Reporter
+		)
+
+		BeforeEach(func() {
+			buffer = new(bytes.Buffer)
+			received, status = nil, http.StatusNoContent
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Method).To(Equal("POST"))
+				Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+				received, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(status)
+			}))
+
+			Expect(ioutil.WriteFile(filepath.Join(dir, "Gemfile.lock"), []byte(gemfileLock), 0644)).To(Succeed())
+			Expect((&report.Report{Engine: "ruby", Ruby: "2.5.1"}).Save(dir)).To(Succeed())
+			reporter = &telemetry.Reporter{
+				Config:           &telemetry.Config{Endpoint: server.URL, Salt: "s3cret", Timeout: 5},
+				DepDir:           dir,
+				GemfileLock:      filepath.Join(dir, "Gemfile.lock"),
+				BuildpackVersion: "1.7.22",
+				Log:              libbuildpack.NewLogger(ansicleaner.New(buffer)),
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("posts the payload and tells the app how to opt out", func() {
+			reporter.Report()
+			p := telemetry.Payload{}
+			Expect(json.Unmarshal(received, &p)).To(Succeed())
+			Expect(p.Engine).To(Equal("ruby"))
+			Expect(p.Ruby).To(Equal("2.5.1"))
+			Expect(p.Gems).To(HaveLen(2))
+			Expect(buffer.String()).To(ContainSubstring("set BP_TELEMETRY_OPT_OUT=true to opt out"))
+		})
+
+		It("does not fail when the endpoint does", func() {
+			status = http.StatusInternalServerError
+			reporter.Report()
+			Expect(received).ToNot(BeEmpty())
+			Expect(telemetry.Send(reporter.Config, &telemetry.Payload{})).To(MatchError("unexpected status 500 Internal Server Error"))
+		})
+	})
+})
@@ -0,0 +1,69 @@
+// Package toolchain detects the versions of the compilers and libc on the
+// staging rootfs, native extensions built with one toolchain are not
+// guaranteed to load on a rootfs with another
+package toolchain
+
+import (
+	"regexp"
+	"strings"
+)
+
+type Command interface {
+	Output(string, string, ...string) (string, error)
+}
+
+type Versions struct {
+	GCC  string `yaml:"gcc,omitempty" json:"gcc,omitempty"`
+	Make string `yaml:"make,omitempty" json:"make,omitempty"`
+	Libc string `yaml:"libc,omitempty" json:"libc,omitempty"`
+}
+
+var version = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// Detect runs each tool for its version, tools which are missing or whose
+// version can not be parsed are left empty
+func Detect(command Command, dir string) Versions {
+	return Versions{
+		GCC:  detect(command, dir, "gcc", "-dumpfullversion", "-dumpversion"),
+		Make: detect(command, dir, "make", "--version"),
+		Libc: detect(command, dir, "ldd", "--version"),
+	}
+}
+
+func detect(command Command, dir, program string, args ...string) string {
+	output, err := command.Output(dir, program, args...)
+	if err != nil {
+		return ""
+	}
+	firstLine := strings.SplitN(strings.TrimSpace(output), "\n", 2)[0]
+	// ldd prints "ldd (Ubuntu GLIBC 2.27-3ubuntu1) 2.27", the last version
+	// on the line is the glibc one
+	versions := version.FindAllString(firstLine, -1)
+	if len(versions) == 0 {
+		return ""
+	}
+	return versions[len(versions)-1]
+}
+
+// Changed returns whether a tool detected in both v and other has a
+// different version, tools that were not detected are not compared
+func (v Versions) Changed(other Versions) bool {
+	return differ(v.GCC, other.GCC) || differ(v.Make, other.Make) || differ(v.Libc, other.Libc)
+}
+
+func (v Versions) String() string {
+	var parts []string
+	for _, tool := range []struct{ name, version string }{{"gcc", v.GCC}, {"make", v.Make}, {"libc", v.Libc}} {
+		if tool.version != "" {
+			parts = append(parts, tool.name+" "+tool.version)
+		}
+	}
+	if len(parts) == 0 {
+		return "unknown"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func differ(a, b string) bool {
+	return a != "" && b != "" && a != b
+}
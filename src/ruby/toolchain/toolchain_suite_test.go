@@ -0,0 +1,13 @@
+package toolchain_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestToolchain(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Toolchain Suite")
+}
@@ -0,0 +1,53 @@
+package toolchain_test
+
+import (
+	"errors"
+	"ruby/toolchain"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeCommand map[string]string
+
+func (c fakeCommand) Output(_ string, program string, args ...string) (string, error) {
+	if output, found := c[program+" "+strings.Join(args, " ")]; found {
+		return output, nil
+	}
+	return "", errors.New("not found")
+}
+
+var _ = Describe("Toolchain", func() {
+	Describe("Detect", func() {
+		It("parses the version of each tool", func() {
+			tools := toolchain.Detect(fakeCommand{
+				"gcc -dumpfullversion -dumpversion": "5.4.0\n",
+				"make --version":                    "GNU Make 4.1\nBuilt for x86_64-pc-linux-gnu\n",
+				"ldd --version":                     "ldd (Ubuntu GLIBC 2.23-0ubuntu11) 2.23\n",
+			}, "/app")
+			Expect(tools).To(Equal(toolchain.Versions{GCC: "5.4.0", Make: "4.1", Libc: "2.23"}))
+			Expect(tools.String()).To(Equal("gcc 5.4.0, make 4.1, libc 2.23"))
+		})
+
+		It("leaves missing tools empty", func() {
+			tools := toolchain.Detect(fakeCommand{"make --version": "GNU Make 4.2.1\n"}, "/app")
+			Expect(tools).To(Equal(toolchain.Versions{Make: "4.2.1"}))
+			Expect(toolchain.Detect(fakeCommand{}, "/app").String()).To(Equal("unknown"))
+		})
+	})
+
+	Describe("Changed", func() {
+		tools := toolchain.Versions{GCC: "7.5.0", Make: "4.1", Libc: "2.27"}
+
+		It("is true when a version differs", func() {
+			Expect(tools.Changed(toolchain.Versions{GCC: "7.5.0", Make: "4.1", Libc: "2.31"})).To(BeTrue())
+		})
+
+		It("ignores tools which were not detected", func() {
+			Expect(tools.Changed(tools)).To(BeFalse())
+			Expect(tools.Changed(toolchain.Versions{GCC: "7.5.0"})).To(BeFalse())
+			Expect(toolchain.Versions{}.Changed(tools)).To(BeFalse())
+		})
+	})
+})
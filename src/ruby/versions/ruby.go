@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"ruby/lockfile"
 	"strconv"
 	"strings"
 
@@ -39,10 +40,10 @@ type output struct {
 func (v *Versions) Engine() (string, error) {
 	gemfile := v.Gemfile()
 	code := fmt.Sprintf(`
-		b = Bundler::Dsl.evaluate('%s', '%s.lock', {}).ruby_version if File.exists?('%s')
+		b = definition('%s').ruby_version if File.exists?('%s')
 	  return 'ruby' if !b
 		b.engine
-	`, filepath.Base(gemfile), filepath.Base(gemfile), filepath.Base(gemfile))
+	`, filepath.Base(gemfile), filepath.Base(gemfile))
 
 	data, err := v.run(filepath.Dir(gemfile), code, []string{})
 	if err != nil {
@@ -56,14 +57,14 @@ func (v *Versions) Version() (string, error) {
 	versions := v.manifest.AllDependencyVersions("ruby")
 	gemfile := v.Gemfile()
 	code := fmt.Sprintf(`
-		b = Bundler::Dsl.evaluate('%s', '%s.lock', {}).ruby_version
+		b = definition('%s').ruby_version
 	  return '' if !b
 
 		r = Gem::Requirement.create(b.versions)
 		version = input.select { |v| r.satisfied_by? Gem::Version.new(v) }.sort.last
 		raise "No Matching versions, ruby #{r} not found in this buildpack" unless version
 		version
-	`, filepath.Base(gemfile), filepath.Base(gemfile))
+	`, filepath.Base(gemfile))
 
 	data, err := v.run(filepath.Dir(gemfile), code, versions)
 	if err != nil {
@@ -76,11 +77,11 @@ func (v *Versions) Version() (string, error) {
 func (v *Versions) JrubyVersion() (string, error) {
 	gemfile := v.Gemfile()
 	code := fmt.Sprintf(`
-		b = Bundler::Dsl.evaluate('%s', '%s.lock', {}).ruby_version
+		b = definition('%s').ruby_version
 	  return '' if !b
 
 	  "#{b.versions_string(b.engine_versions)}"
-	`, filepath.Base(gemfile), filepath.Base(gemfile))
+	`, filepath.Base(gemfile))
 
 	data, err := v.run(filepath.Dir(gemfile), code, []string{})
 	if err != nil {
@@ -100,6 +101,18 @@ func (v *Versions) RubyEngineVersion() (string, error) {
 	return data.(string), nil
 }
 
+// RubyPlatform is the platform ruby was built for, such as x86_64-linux,
+// which names its directory of arch specific headers and libraries
+func (v *Versions) RubyPlatform() (string, error) {
+	code := `require 'rbconfig';RbConfig::CONFIG['arch']`
+
+	data, err := v.run(v.buildDir, code, []string{})
+	if err != nil {
+		return "", err
+	}
+	return data.(string), nil
+}
+
 func (v *Versions) VersionConstraint(version string, constraints ...string) (bool, error) {
 	code := `
 		version = input.shift
@@ -159,9 +172,35 @@ func (v *Versions) GemMajorVersion(gem string) (int, error) {
 	}
 }
 
+// GemGroups maps each locked gem to the Gemfile groups which bring it in,
+// a gem required by other gems gets the groups of every gem requiring it
+func (v *Versions) GemGroups() (map[string][]string, error) {
+	code := `
+		definition(input.first).dependencies.map { |d| [d.name, d.groups.map(&:to_s)] }
+	`
+	data, err := v.run(v.buildDir, code, []string{v.Gemfile()})
+	if err != nil {
+		return nil, err
+	}
+
+	direct := map[string][]string{}
+	for _, pair := range data.([]interface{}) {
+		pair := pair.([]interface{})
+		for _, group := range pair[1].([]interface{}) {
+			direct[pair[0].(string)] = append(direct[pair[0].(string)], group.(string))
+		}
+	}
+
+	lock, err := lockfile.ParseFile(v.Gemfile() + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	return lock.Groups(direct), nil
+}
+
 //Should return true if either:
-// (1) the only platform in the Gemfile.lock is windows (mingw/mswin)
-//     (there is no ruby or jruby platform)
+// (1) there is no ruby or java platform in the Gemfile.lock, as when the
+//     only platform is windows (mingw/mswin)
 //     -or-
 // (2) the Gemfile.lock line endings are /r/n, rather than just /n
 func (v *Versions) HasWindowsGemfileLock() (bool, error) {
@@ -171,50 +210,22 @@ func (v *Versions) HasWindowsGemfileLock() (bool, error) {
 	} else if !good {
 		return false, nil
 	}
-	if bytes, err := ioutil.ReadFile(gemfileLockPath); err != nil {
-		return false, err
-	} else if strings.Contains(string(bytes), "\r\n") {
-		return true, nil
-	}
-
-	code := `
-	  return false if !File.exists?(input["gemfilelock"])
-		parsed = Bundler::LockfileParser.new(File.read(input["gemfilelock"]))
-		parsed.platforms.detect do |platform|
-		  if platform.is_a?(String)
-        /ruby/.match(platform)
-			elsif platform.is_a?(Gem::Platform)
-			  /java/.match(platform.os)
-			end
-    end.nil?
-	`
-
-	data, err := v.run(filepath.Dir(v.Gemfile()),
-		code,
-		map[string]string{"gemfilelock": fmt.Sprintf("%s.lock", v.Gemfile())})
+	lock, err := lockfile.ParseFile(gemfileLockPath)
 	if err != nil {
 		return false, err
 	}
-	return data.(bool), nil
+	return lock.CRLF || !lock.HasRubyOrJavaPlatform(), nil
 }
 
 func (v *Versions) specs() (map[string]string, error) {
 	if len(v.cachedSpecs) > 0 {
 		return v.cachedSpecs, nil
 	}
-	code := `
-		parsed = Bundler::LockfileParser.new(File.read(input["gemfilelock"]))
-		Hash[*(parsed.specs.map{|spec| [spec.name, spec.version.to_s]}).flatten]
-	`
-
-	data, err := v.run(filepath.Dir(v.Gemfile()), code, map[string]string{"gemfilelock": fmt.Sprintf("%s.lock", v.Gemfile())})
+	lock, err := lockfile.ParseInstallable(v.Gemfile() + ".lock")
 	if err != nil {
 		return nil, err
 	}
-	specs := make(map[string]string, 0)
-	for k, v := range data.(map[string]interface{}) {
-		specs[k] = v.(string)
-	}
+	specs := lock.Versions()
 	v.cachedSpecs = specs
 	return v.cachedSpecs, nil
 }
@@ -227,8 +238,21 @@ func (v *Versions) Gemfile() string {
 	return filepath.Join(v.buildDir, gemfile)
 }
 
+// run evaluates code with in as its input. definition(gemfile) evaluates the
+// app's Gemfile as cleaned up by lockfile.Normalize rather than as saved,
+// bundler reads a byte order mark or a non breaking space as part of a name.
 func (v *Versions) run(dir, code string, in interface{}) (interface{}, error) {
-	data, err := json.Marshal(in)
+	payload := struct {
+		Input   interface{} `json:"input"`
+		Gemfile *string     `json:"gemfile"`
+	}{Input: in}
+	if gemfile, err := ioutil.ReadFile(v.Gemfile()); err == nil {
+		contents := string(lockfile.Normalize(gemfile))
+		payload.Gemfile = &contents
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	data, err := json.Marshal(payload)
 	if err != nil {
 		return "", err
 	}
@@ -236,11 +260,17 @@ func (v *Versions) run(dir, code string, in interface{}) (interface{}, error) {
 	code = fmt.Sprintf(`
 	  stdout, $stdout = $stdout, $stderr
 		begin
+			def definition(gemfile)
+				builder = Bundler::Dsl.new
+				builder.eval_gemfile(gemfile, $gemfile)
+				builder.to_definition(Pathname.new("#{gemfile}.lock"), {})
+			end
 			def data(input)
 				%s
 			end
-			input = JSON.parse(STDIN.read)
-			out = data(input)
+			payload = JSON.parse(STDIN.read)
+			$gemfile = payload['gemfile']
+			out = data(payload['input'])
 			stdout.puts({error:nil, data:out}.to_json)
 		rescue => e
 			stdout.puts({error:e.to_s, data:nil}.to_json)
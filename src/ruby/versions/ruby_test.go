@@ -182,6 +182,20 @@ var _ = Describe("Ruby", func() {
 			})
 		})
 
+		Context("Gemfile was saved on windows", func() {
+			BeforeEach(func() {
+				gemfile := "\ufeffsource 'https://rubygems.org'\r\nruby\u00a0\"~>2.2.0\"\r\n"
+				Expect(ioutil.WriteFile(filepath.Join(tmpDir, "Gemfile"), []byte(gemfile), 0644)).To(Succeed())
+			})
+
+			It("still finds the ruby directive", func() {
+				manifest.EXPECT().AllDependencyVersions("ruby").Return([]string{"2.2.3", "2.2.4", "2.3.3"})
+				v := versions.New(tmpDir, manifest)
+				Expect(v.Version()).To(Equal("2.2.4"))
+				Expect(v.Engine()).To(Equal("ruby"))
+			})
+		})
+
 		Context("Gemfile has no constraint", func() {
 			BeforeEach(func() {
 				Expect(ioutil.WriteFile(filepath.Join(tmpDir, "Gemfile"), []byte(``), 0644)).To(Succeed())
@@ -284,6 +298,63 @@ BUNDLED WITH
 		})
 	})
 
+	Describe("GemGroups", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(tmpDir, "Gemfile"), []byte("source 'https://rubygems.org'\ngem 'roda'\ngroup :test do\n  gem 'rack-test'\nend\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(tmpDir, "Gemfile.lock"), []byte(`GEM
+  remote: https://rubygems.org/
+  specs:
+    rack (2.0.3)
+    rack-test (0.8.2)
+      rack (>= 1.0, < 3)
+    roda (2.28.0)
+      rack
+
+PLATFORMS
+  ruby
+
+DEPENDENCIES
+  rack-test
+  roda
+
+BUNDLED WITH
+   1.15.3
+`), 0644)).To(Succeed())
+		})
+
+		It("gives each gem the groups which bring it in", func() {
+			v := versions.New(tmpDir, manifest)
+			Expect(v.GemGroups()).To(Equal(map[string][]string{
+				"roda":      {"default"},
+				"rack-test": {"test"},
+				"rack":      {"default", "test"},
+			}))
+		})
+	})
+
+	Context("a dual boot app sets BUNDLE_GEMFILE to Gemfile_next", func() {
+		BeforeEach(func() {
+			os.Setenv("BUNDLE_GEMFILE", "Gemfile_next")
+			Expect(ioutil.WriteFile(filepath.Join(tmpDir, "Gemfile"), []byte(`gem 'rails', '~> 6.1.0'`), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(tmpDir, "Gemfile.lock"), []byte("GEM\n  specs:\n    rails (6.1.7)\n\nPLATFORMS\n  x64-mingw32\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(tmpDir, "Gemfile_next"), []byte(`gem 'rails', '~> 7.0.0'`), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(tmpDir, "Gemfile_next.lock"), []byte("GEM\n  specs:\n    rails (7.0.4)\n\nPLATFORMS\n  ruby\n"), 0644)).To(Succeed())
+		})
+		AfterEach(func() { os.Unsetenv("BUNDLE_GEMFILE") })
+
+		It("reads gems from Gemfile_next.lock", func() {
+			v := versions.New(tmpDir, manifest)
+			Expect(v.Gemfile()).To(Equal(filepath.Join(tmpDir, "Gemfile_next")))
+			Expect(v.HasGemVersion("rails", ">= 7.0")).To(BeTrue())
+			Expect(v.GemMajorVersion("rails")).To(Equal(7))
+		})
+
+		It("checks the line endings and platforms of Gemfile_next.lock", func() {
+			v := versions.New(tmpDir, manifest)
+			Expect(v.HasWindowsGemfileLock()).To(BeFalse())
+		})
+	})
+
 	Describe("GemMajorVersion", func() {
 		BeforeEach(func() {
 			Expect(ioutil.WriteFile(filepath.Join(tmpDir, "Gemfile"), []byte(`gem 'roda'`), 0644)).To(Succeed())
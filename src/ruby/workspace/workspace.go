@@ -0,0 +1,45 @@
+// Package workspace keeps the scratch files of a staging, such as archives
+// extracted before they are moved into the deps dir and the copy of the app
+// bundler runs in, below one directory in TMPDIR. Removing that directory
+// when supply returns, whether it succeeded or not, leaves nothing behind.
+package workspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+type Workspace struct {
+	Root string
+}
+
+// New creates the workspace in TMPDIR, or /tmp when TMPDIR is not set
+func New(prefix string) (*Workspace, error) {
+	root, err := ioutil.TempDir("", prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &Workspace{Root: root}, nil
+}
+
+// Dir creates a new directory in the workspace, its name starts with name
+func (w *Workspace) Dir(name string) (string, error) {
+	return ioutil.TempDir(w.Root, name)
+}
+
+// File creates a new file in the workspace, its name starts with name
+func (w *Workspace) File(name string) (*os.File, error) {
+	return ioutil.TempFile(w.Root, name)
+}
+
+// Path is a fixed name in the workspace, for files which are looked up again
+// by name later in the staging
+func (w *Workspace) Path(name string) string {
+	return filepath.Join(w.Root, name)
+}
+
+// Cleanup removes the workspace and everything in it
+func (w *Workspace) Cleanup() error {
+	return os.RemoveAll(w.Root)
+}
@@ -0,0 +1,147 @@
+package workspace_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"ruby/diagnostics"
+	"ruby/prebuilt"
+	"ruby/redact"
+	"ruby/workspace"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ed25519"
+)
+
+var _ = Describe("Workspace", func() {
+	var (
+		tmpDir    string
+		oldTmpDir string
+		ws        *workspace.Workspace
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "ruby-buildpack.tmpdir.")
+		Expect(err).To(BeNil())
+		oldTmpDir = os.Getenv("TMPDIR")
+		Expect(os.Setenv("TMPDIR", tmpDir)).To(Succeed())
+
+		ws, err = workspace.New("supply")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.Setenv("TMPDIR", oldTmpDir)).To(Succeed())
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("is created in TMPDIR", func() {
+		Expect(filepath.Dir(ws.Root)).To(Equal(tmpDir))
+		Expect(ws.Root).To(BeADirectory())
+	})
+
+	It("creates directories, files and fixed paths inside the workspace", func() {
+		dir, err := ws.Dir("node")
+		Expect(err).To(BeNil())
+		Expect(filepath.Dir(dir)).To(Equal(ws.Root))
+
+		file, err := ws.File("gem")
+		Expect(err).To(BeNil())
+		Expect(file.Close()).To(Succeed())
+		Expect(filepath.Dir(file.Name())).To(Equal(ws.Root))
+
+		Expect(ws.Path("checkpoint")).To(Equal(filepath.Join(ws.Root, "checkpoint")))
+	})
+
+	It("leaves nothing in TMPDIR once cleaned up", func() {
+		dir, err := ws.Dir("node")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(dir, "node-v6.14.3-linux-x64", "bin"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "node-v6.14.3-linux-x64", "bin", "node"), []byte("node"), 0755)).To(Succeed())
+
+		Expect(ws.Cleanup()).To(Succeed())
+		Expect(ioutil.ReadDir(tmpDir)).To(BeEmpty())
+		Expect(ws.Cleanup()).To(Succeed())
+	})
+
+	Context("the staging used the prebuilt gem cache and failed", func() {
+		var (
+			server    *httptest.Server
+			stored    map[string][]byte
+			stagedDir string
+		)
+
+		BeforeEach(func() {
+			var err error
+			stored = map[string][]byte{}
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				Expect(err).To(BeNil())
+				if r.Method == "PUT" {
+					stored[r.URL.Path] = body
+				} else if data, found := stored[r.URL.Path]; found {
+					w.Write(data)
+				} else {
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			stagedDir, err = ioutil.TempDir(filepath.Dir(tmpDir), "ruby-buildpack.staged.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			server.Close()
+			Expect(os.RemoveAll(stagedDir)).To(Succeed())
+		})
+
+		It("leaves nothing in TMPDIR once cleaned up", func() {
+			public, private, err := ed25519.GenerateKey(nil)
+			Expect(err).To(BeNil())
+			cache := prebuilt.New(server.URL, "cflinuxfs3", "ruby-2.5.1", public, libbuildpack.NewLogger(ioutil.Discard))
+			cache.Token = "upload-token"
+			cache.Workspace = ws
+
+			bundleDir := filepath.Join(stagedDir, "bundle")
+			Expect(os.MkdirAll(filepath.Join(bundleDir, "gems", "nokogiri-1.8.4"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(bundleDir, "gems", "nokogiri-1.8.4", "nokogiri.rb"), []byte("ruby"), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(bundleDir, "specifications"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(bundleDir, "specifications", "nokogiri-1.8.4.gemspec"), []byte("spec"), 0644)).To(Succeed())
+			nokogiri := prebuilt.Gem{Name: "nokogiri", Version: "1.8.4"}
+			Expect(cache.Store(nokogiri, bundleDir)).To(Succeed())
+
+			sum := sha256.Sum256(stored["/cflinuxfs3/ruby-2.5.1/nokogiri-1.8.4.tgz"])
+			index, err := json.Marshal(map[string]interface{}{"stack": "cflinuxfs3", "ruby": "ruby-2.5.1", "expires": time.Now().Add(time.Hour), "gems": map[string]string{"nokogiri-1.8.4": hex.EncodeToString(sum[:])}})
+			Expect(err).To(BeNil())
+			stored["/cflinuxfs3/ruby-2.5.1/index.json"] = index
+			stored["/cflinuxfs3/ruby-2.5.1/index.json.sig"] = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(private, index)))
+			Expect(cache.Fetch(nokogiri, filepath.Join(stagedDir, "other_bundle"))).To(BeTrue())
+
+			redactor, err := redact.New(nil, "")
+			Expect(err).To(BeNil())
+			collector := diagnostics.Collector{
+				DepDir:    stagedDir,
+				Redactor:  redactor,
+				Log:       libbuildpack.NewLogger(ansicleaner.New(new(bytes.Buffer))),
+				UploadURL: server.URL + "/diagnostics.tgz",
+				Workspace: ws,
+			}
+			collector.Report()
+			Expect(stored).To(HaveKey("/diagnostics.tgz"))
+
+			Expect(ws.Cleanup()).To(Succeed())
+			Expect(ioutil.ReadDir(tmpDir)).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,201 @@
+// Package yarnlock reads the yarn.lock of yarn 1 apps, and checks which of
+// its packages an app vendors in an offline mirror or in node_modules, so
+// yarn can install them without the registry.
+package yarnlock
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Package is an entry of yarn.lock, the versions several requirements of a
+// package resolved to
+type Package struct {
+	Name      string
+	Version   string
+	Resolved  string
+	Integrity string
+}
+
+// String is name@version, as yarn names packages in its output
+func (p Package) String() string {
+	return p.Name + "@" + p.Version
+}
+
+var field = regexp.MustCompile(`^  (version|resolved|integrity) "?([^"]*)"?$`)
+
+// Parse reads the packages of a yarn.lock in the order it lists them
+func Parse(data []byte) ([]Package, error) {
+	var (
+		packages []Package
+		current  *Package
+	)
+	for i, line := range strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") {
+			if !strings.HasSuffix(line, ":") {
+				return nil, fmt.Errorf("yarn.lock line %d: expected a package, got %q", i+1, line)
+			}
+			requirement := strings.Trim(strings.TrimSpace(strings.SplitN(strings.TrimSuffix(line, ":"), ",", 2)[0]), `"`)
+			at := strings.LastIndex(requirement, "@")
+			if at <= 0 {
+				return nil, fmt.Errorf("yarn.lock line %d: %q has no version requirement", i+1, requirement)
+			}
+			packages = append(packages, Package{Name: requirement[:at]})
+			current = &packages[len(packages)-1]
+			continue
+		}
+		if m := field.FindStringSubmatch(line); m != nil && current != nil {
+			switch m[1] {
+			case "version":
+				current.Version = m[2]
+			case "resolved":
+				current.Resolved = m[2]
+			case "integrity":
+				current.Integrity = m[2]
+			}
+		}
+	}
+	return packages, nil
+}
+
+// ParseFile reads the yarn.lock at path
+func ParseFile(path string) ([]Package, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+var tarballName = regexp.MustCompile(`(?:(@[^/]+)(?:/|%2f))?[^/]+/(?:-|_attachments)/(?:@[^/]+/)?([^/]+)$`)
+
+// MirrorFile is the name yarn gives the tarball of p in an offline mirror,
+// scoped packages are prefixed with their scope. It is empty for packages
+// not resolved to a tarball URL, such as git or file dependencies.
+func (p Package) MirrorFile() string {
+	u, err := url.Parse(p.Resolved)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	if m := tarballName.FindStringSubmatch(u.Path); m != nil {
+		if m[1] != "" {
+			return m[1] + "-" + m[2]
+		}
+		return m[2]
+	}
+	return path.Base(u.Path)
+}
+
+// Verify checks the tarball at file against the integrity of p, or the sha1
+// after # in its resolved URL for lockfiles older than integrity
+func (p Package) Verify(file string) error {
+	expected := map[string]string{}
+	for _, sri := range strings.Fields(p.Integrity) {
+		parts := strings.SplitN(sri, "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if digest, err := base64.StdEncoding.DecodeString(parts[1]); err == nil {
+			expected[parts[0]] = hex.EncodeToString(digest)
+		}
+	}
+	if len(expected) == 0 {
+		if i := strings.LastIndex(p.Resolved, "#"); i >= 0 {
+			expected["sha1"] = p.Resolved[i+1:]
+		}
+	}
+
+	hashes := map[string]hash.Hash{}
+	var writers []io.Writer
+	for algorithm := range expected {
+		var h hash.Hash
+		switch algorithm {
+		case "sha1":
+			h = sha1.New()
+		case "sha256":
+			h = sha256.New()
+		case "sha512":
+			h = sha512.New()
+		default:
+			continue
+		}
+		hashes[algorithm] = h
+		writers = append(writers, h)
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
+		return err
+	}
+	for algorithm, h := range hashes {
+		if actual := hex.EncodeToString(h.Sum(nil)); actual != expected[algorithm] {
+			return fmt.Errorf("%s of %s is %s, yarn.lock expects %s", algorithm, filepath.Base(file), actual, expected[algorithm])
+		}
+	}
+	return nil
+}
+
+// Installed returns the name@version of every package in a node_modules
+// directory, including the packages nested in the node_modules of others
+func Installed(nodeModules string) (map[string]bool, error) {
+	installed := map[string]bool{}
+	err := filepath.Walk(nodeModules, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == nodeModules {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() || info.Name() != "package.json" {
+			return nil
+		}
+		// Only the package.json at the root of a package, not those of
+		// its fixtures or sources
+		dir := filepath.Dir(path)
+		parent := filepath.Base(filepath.Dir(dir))
+		if strings.HasPrefix(parent, "@") {
+			parent = filepath.Base(filepath.Dir(filepath.Dir(dir)))
+		}
+		if parent != "node_modules" {
+			return nil
+		}
+		var manifest struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &manifest); err != nil || manifest.Name == "" {
+			return nil
+		}
+		installed[manifest.Name+"@"+manifest.Version] = true
+		return nil
+	})
+	return installed, err
+}
@@ -0,0 +1,13 @@
+package yarnlock_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestYarnlock(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Yarnlock Suite")
+}
@@ -0,0 +1,119 @@
+package yarnlock_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"ruby/yarnlock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const yarnLock = `# THIS IS AN AUTOGENERATED FILE. DO NOT EDIT THIS FILE DIRECTLY.
+# yarn lockfile v1
+
+
+"@babel/code-frame@^7.0.0", "@babel/code-frame@^7.8.3":
+  version "7.8.3"
+  resolved "https://registry.yarnpkg.com/@babel/code-frame/-/code-frame-7.8.3.tgz#33e25903d7481181534e12ec0a25f16b6fcf419e"
+  integrity sha512-a9gxpmdXtZEInkCSHUJDLHZVBgb1QS0jhss4cPP93EW7s+uC5bikET2twEF3KV+7rDblJcmNvTR7VJejqd2C2g==
+  dependencies:
+    "@babel/highlight" "^7.8.3"
+
+left-pad@^1.3.0:
+  version "1.3.0"
+  resolved "https://registry.yarnpkg.com/left-pad/-/left-pad-1.3.0.tgz#ebfb0f08ca499b3bc417c83545d8788f826adc86"
+
+"my-fork@git+https://github.com/acme/my-fork.git":
+  version "0.1.0"
+  resolved "git+https://github.com/acme/my-fork.git#0123456789abcdef"
+`
+
+var _ = Describe("Yarnlock", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ruby-buildpack.yarnlock.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	Describe("Parse", func() {
+		It("reads every package", func() {
+			packages, err := yarnlock.Parse([]byte(yarnLock))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(packages).To(HaveLen(3))
+			Expect(packages[0].String()).To(Equal("@babel/code-frame@7.8.3"))
+			Expect(packages[0].Integrity).To(HavePrefix("sha512-a9gx"))
+			Expect(packages[1]).To(Equal(yarnlock.Package{Name: "left-pad", Version: "1.3.0", Resolved: "https://registry.yarnpkg.com/left-pad/-/left-pad-1.3.0.tgz#ebfb0f08ca499b3bc417c83545d8788f826adc86"}))
+			Expect(packages[2].String()).To(Equal("my-fork@0.1.0"))
+		})
+
+		It("rejects lines which are not yarn.lock", func() {
+			_, err := yarnlock.Parse([]byte("<<<<<<< HEAD\n"))
+			Expect(err).To(MatchError(`yarn.lock line 1: expected a package, got "<<<<<<< HEAD"`))
+		})
+	})
+
+	Describe("MirrorFile", func() {
+		It("names the tarballs like yarn does", func() {
+			packages, err := yarnlock.Parse([]byte(yarnLock))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(packages[0].MirrorFile()).To(Equal("@babel-code-frame-7.8.3.tgz"))
+			Expect(packages[1].MirrorFile()).To(Equal("left-pad-1.3.0.tgz"))
+			Expect(packages[2].MirrorFile()).To(BeEmpty())
+		})
+	})
+
+	Describe("Verify", func() {
+		var tarball string
+
+		BeforeEach(func() {
+			tarball = filepath.Join(dir, "left-pad-1.3.0.tgz")
+			Expect(ioutil.WriteFile(tarball, []byte("tarball of left-pad"), 0644)).To(Succeed())
+		})
+
+		It("checks the integrity", func() {
+			pkg := yarnlock.Package{Name: "left-pad", Version: "1.3.0", Integrity: "sha512-lCjOg44fbuAHGIz7sFi8GwN+rQ05dRhe/cP3Ok5DQqMKdtpkTP+SWHwMXYZdvM4pWGD7Z2Pi2eoRUkVXAjqTNA=="}
+			Expect(pkg.Verify(tarball)).To(Succeed())
+
+			Expect(ioutil.WriteFile(tarball, []byte("tampered"), 0644)).To(Succeed())
+			Expect(pkg.Verify(tarball)).To(MatchError(ContainSubstring("sha512 of left-pad-1.3.0.tgz is ")))
+		})
+
+		It("falls back to the sha1 of the resolved URL", func() {
+			pkg := yarnlock.Package{Name: "left-pad", Version: "1.3.0", Resolved: "https://registry.yarnpkg.com/left-pad/-/left-pad-1.3.0.tgz#ebfb0f08ca499b3bc417c83545d8788f826adc86"}
+			Expect(pkg.Verify(tarball)).To(Succeed())
+
+			pkg.Resolved = "https://registry.yarnpkg.com/left-pad/-/left-pad-1.3.0.tgz#0000000000000000000000000000000000000000"
+			Expect(pkg.Verify(tarball)).To(MatchError("sha1 of left-pad-1.3.0.tgz is ebfb0f08ca499b3bc417c83545d8788f826adc86, yarn.lock expects 0000000000000000000000000000000000000000"))
+		})
+	})
+
+	Describe("Installed", func() {
+		write := func(file, contents string) {
+			Expect(os.MkdirAll(filepath.Join(dir, filepath.Dir(file)), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, file), []byte(contents), 0644)).To(Succeed())
+		}
+
+		It("finds the installed packages, nested and scoped ones too", func() {
+			write("node_modules/left-pad/package.json", `{"name": "left-pad", "version": "1.3.0"}`)
+			write("node_modules/left-pad/test/fixture/package.json", `{"name": "fixture", "version": "0.0.1"}`)
+			write("node_modules/@babel/code-frame/package.json", `{"name": "@babel/code-frame", "version": "7.8.3"}`)
+			write("node_modules/@babel/code-frame/node_modules/chalk/package.json", `{"name": "chalk", "version": "2.4.2"}`)
+
+			installed, err := yarnlock.Installed(filepath.Join(dir, "node_modules"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(installed).To(Equal(map[string]bool{"left-pad@1.3.0": true, "@babel/code-frame@7.8.3": true, "chalk@2.4.2": true}))
+		})
+
+		It("is empty without node_modules", func() {
+			Expect(yarnlock.Installed(filepath.Join(dir, "node_modules"))).To(BeEmpty())
+		})
+	})
+})